@@ -217,6 +217,13 @@ type ImageProxy struct {
 	KcImageRepoMirror string `json:"kcImageRepoMirror" yaml:"kcImageRepoMirror,omitempty"`
 }
 
+// DownloadProxy the proxy the agents download the online packages through, the pods do not inherit it.
+type DownloadProxy struct {
+	HTTPProxy  string `json:"httpProxy" yaml:"httpProxy,omitempty"`
+	HTTPSProxy string `json:"httpsProxy" yaml:"httpsProxy,omitempty"`
+	NoProxy    string `json:"noProxy" yaml:"noProxy,omitempty"`
+}
+
 type Agents map[string]Metadata // key:ip
 
 func (a Agents) ListIP() []string {
@@ -291,6 +298,7 @@ type DeployConfig struct {
 	MQ                 *MQ                            `json:"mq" yaml:"mq,omitempty"`
 	OpLog              *OpLog                         `json:"opLog" yaml:"opLog,omitempty"`
 	ImageProxy         *ImageProxy                    `json:"imageProxy" yaml:"imageProxy,omitempty"`
+	DownloadProxy      DownloadProxy                  `json:"downloadProxy" yaml:"downloadProxy,omitempty"`
 	AuthenticationOpts *options.AuthenticationOptions `json:"authentication" yaml:"authentication,omitempty"`
 }
 
@@ -486,6 +494,9 @@ func (c *DeployConfig) AddFlags(flags *pflag.FlagSet) {
 	flags.StringVar(&c.OpLog.Dir, "oplog-dir", c.OpLog.Dir, "kc agent operation log dir")
 	flags.IntVar(&c.OpLog.Threshold, "oplog-threshold", c.OpLog.Threshold, "kc agent operation log single threshold")
 	flags.StringVar(&c.ImageProxy.KcImageRepoMirror, "kc-image-repo-mirror", c.ImageProxy.KcImageRepoMirror, "K8s image repository mirror")
+	flags.StringVar(&c.DownloadProxy.HTTPProxy, "download-http-proxy", c.DownloadProxy.HTTPProxy, "the proxy kc agents download online packages over http through")
+	flags.StringVar(&c.DownloadProxy.HTTPSProxy, "download-https-proxy", c.DownloadProxy.HTTPSProxy, "the proxy kc agents download online packages over https through")
+	flags.StringVar(&c.DownloadProxy.NoProxy, "download-no-proxy", c.DownloadProxy.NoProxy, "comma-separated hosts, domains and CIDRs kc agents download from without the proxy, e.g. the local registry")
 
 	AddFlagsToSSH(c.SSHConfig, flags)
 }
@@ -609,6 +620,7 @@ func (c *DeployConfig) GetKcAgentConfigTemplateContent(metadata Metadata) (strin
 	data["OpLogDir"] = c.OpLog.Dir
 	data["OpLogThreshold"] = c.OpLog.Threshold
 	data["KcImageRepoMirror"] = c.ImageProxy.KcImageRepoMirror
+	data["DownloadProxy"] = c.DownloadProxy
 	var buffer bytes.Buffer
 	if err = tmpl.Execute(&buffer, data); err != nil {
 		return "", fmt.Errorf("template execute failed: %s", err.Error())
@@ -20,6 +20,10 @@ package v1
 
 import (
 	"net/http"
+	"os"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/sets"
 
 	"k8s.io/component-base/version"
 
@@ -32,6 +36,7 @@ import (
 	"github.com/kubeclipper/kubeclipper/pkg/utils/certs"
 
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
 
 	"github.com/kubeclipper/kubeclipper/pkg/scheme"
 
@@ -70,6 +75,63 @@ func (h *handler) ListOfflineResource(request *restful.Request, response *restfu
 	_ = response.WriteHeaderAndEntity(http.StatusOK, result)
 }
 
+// ListCNIRegistrations lists the cnis registered in the component registry, a version is offline when its
+// packages are present in the static server.
+func (h *handler) ListCNIRegistrations(request *restful.Request, response *restful.Response) {
+	offline := scheme.PackageMetadata{}
+	if err := offline.ReadMetadata(false, h.serverConfig.StaticServerOptions.Path); err != nil && !os.IsNotExist(err) {
+		restplus.HandleInternalError(response, request, err)
+		return
+	}
+	resources := offline.Addons
+	if query.GetBoolValueWithDefault(request, "online", false) {
+		online := scheme.PackageMetadata{}
+		if err := online.ReadMetadata(true, ""); err != nil {
+			restplus.HandleInternalError(response, request, err)
+			return
+		}
+		resources = append(resources, online.Addons...)
+	}
+	registrations := cniRegistrations(cni.Registrations(), resources, offline)
+	_ = response.WriteHeaderAndEntity(http.StatusOK, kc.CNIRegistrationList{Items: registrations, TotalCount: len(registrations)})
+}
+
+func cniRegistrations(registrations []cni.Registration, resources []scheme.MetaResource, offline scheme.PackageMetadata) []kc.CNIRegistration {
+	versions := make(map[string]map[string]*kc.CNIVersion)
+	for _, r := range resources {
+		if r.Type != "cni" {
+			continue
+		}
+		if versions[r.Name] == nil {
+			versions[r.Name] = make(map[string]*kc.CNIVersion)
+		}
+		v, ok := versions[r.Name][r.Version]
+		if !ok {
+			v = &kc.CNIVersion{Version: r.Version}
+			versions[r.Name][r.Version] = v
+		}
+		if offline.AddonsExist(r.Name, r.Version, r.Arch) && !sets.New(v.Arches...).Has(r.Arch) {
+			v.Offline = true
+			v.Arches = append(v.Arches, r.Arch)
+		}
+	}
+	items := make([]kc.CNIRegistration, 0, len(registrations))
+	for _, r := range registrations {
+		item := kc.CNIRegistration{Registration: r, Versions: []kc.CNIVersion{}}
+		for _, v := range versions[r.Type] {
+			sort.Strings(v.Arches)
+			// the registrations are the registered cnis, their defaults always load
+			v.Defaults, _ = cni.Defaults(r.Type, v.Version)
+			item.Versions = append(item.Versions, *v)
+		}
+		sort.Slice(item.Versions, func(i, j int) bool {
+			return item.Versions[i].Version < item.Versions[j].Version
+		})
+		items = append(items, item)
+	}
+	return items
+}
+
 // Deprecated: use core/v1/handler.DescribeTemplate instead
 func (h *handler) DescribeTemplate(req *restful.Request, resp *restful.Response) {
 	setting, err := h.platformOperator.GetPlatformSetting(req.Request.Context())
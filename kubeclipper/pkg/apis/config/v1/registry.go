@@ -103,6 +103,16 @@ func AddToContainer(c *restful.Container, platformOperator platform.Operator, co
 		Returns(http.StatusOK, http.StatusText(http.StatusOK), kc.ComponentMeta{}).
 		Returns(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil))
 
+	webservice.Route(webservice.GET("/cnis").
+		To(h.ListCNIRegistrations).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreConfigTag}).
+		Doc("List the registered cnis, the versions of their packages and the configuration defaults of the versions.").
+		Param(webservice.QueryParameter("online", "list the versions of the online resource too").
+			Required(false).
+			DefaultValue("false")).
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), kc.CNIRegistrationList{}).
+		Returns(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), errors.HTTPError{}))
+
 	webservice.Route(webservice.GET("/template").
 		Doc("Information about platform template").
 		Metadata(restfulspec.KeyOpenAPITags, []string{CoreConfigTag}).
@@ -24,8 +24,10 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -73,12 +75,14 @@ import (
 	"github.com/kubeclipper/kubeclipper/pkg/query"
 	"github.com/kubeclipper/kubeclipper/pkg/scheme/common"
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
 	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/k8s"
 	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/validation"
 	apirequest "github.com/kubeclipper/kubeclipper/pkg/server/request"
 	"github.com/kubeclipper/kubeclipper/pkg/server/restplus"
 	"github.com/kubeclipper/kubeclipper/pkg/service"
 	bs "github.com/kubeclipper/kubeclipper/pkg/simple/backupstore"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
 	"github.com/kubeclipper/kubeclipper/pkg/simple/generic"
 	"github.com/kubeclipper/kubeclipper/pkg/utils/certs"
 	"github.com/kubeclipper/kubeclipper/pkg/utils/netutil"
@@ -111,6 +115,9 @@ const (
 	resourceExistCheckerHeader = "X-CHECK-EXIST"
 )
 
+// supportBundleDir the dir the server keeps the support bundles collected by the cni operations in.
+const supportBundleDir = "/opt/kubeclipper-server/support-bundles"
+
 var (
 	ErrNodesRegionDifferent = errors.New("nodes belongs to different region")
 )
@@ -424,6 +431,10 @@ func (h *handler) CreateClusters(request *restful.Request, response *restful.Res
 		timeoutSecs = v
 	}
 	c.Complete()
+	if errs := validation.ValidateCluster(&c); len(errs) > 0 {
+		restplus.HandleBadRequest(response, request, errs.ToAggregate())
+		return
+	}
 	// validate node exist
 	extraMeta, err := h.getClusterMetadata(request.Request.Context(), &c, false)
 	if err != nil {
@@ -507,10 +518,67 @@ func (h *handler) UpdateClusters(request *restful.Request, response *restful.Res
 			return
 		}
 
+		old := clu.DeepCopy()
 		// update fields
 		clu.Labels = c.Labels
 		clu.Annotations = c.Annotations
 		clu.ContainerRuntime.Registries = c.ContainerRuntime.Registries
+		if c.CNI.Type != "" && c.CNI.Type != clu.CNI.Type {
+			// the cluster controller migrates the cni when the spec type changes
+			if err = cni.CheckMigration(clu.CNI.Type, c.CNI.Type); err != nil {
+				restplus.HandleBadRequest(response, request, err)
+				return
+			}
+			if c.CNI.Version == "" {
+				restplus.HandleBadRequest(response, request, fmt.Errorf("the %s version is required by the cni migration", c.CNI.Type))
+				return
+			}
+			if clu.Status.Versions.CNIType == "" {
+				// clusters created before the cni type was recorded, the old spec is the installed one
+				clu.Status.Versions.CNIType = clu.CNI.Type
+			}
+			if clu.Status.Versions.CNI == "" {
+				clu.Status.Versions.CNI = clu.CNI.Version
+			}
+			// the calico configuration is kept, the migration removes calico with it
+			clu.CNI.Type = c.CNI.Type
+			clu.CNI.Version = c.CNI.Version
+			clu.CNI.Namespace = strutil.StringDefaultIfEmpty(cni.CiliumNamespaceDefault, c.CNI.Namespace)
+			clu.CNI.Cilium = c.CNI.Cilium
+		} else if c.CNI.Version != "" && c.CNI.Version != clu.CNI.Version {
+			// the cluster controller upgrades the cni when the spec version changes
+			if err = cni.CheckUpgradeVersion(clu.CNI.Version, c.CNI.Version); err != nil {
+				restplus.HandleBadRequest(response, request, err)
+				return
+			}
+			if clu.Status.Versions.CNI == "" {
+				// clusters created before the cni version was recorded, the old spec version is the installed one
+				clu.Status.Versions.CNI = clu.CNI.Version
+			}
+			clu.CNI.Version = c.CNI.Version
+		}
+		if c.CNI.Cilium != nil && clu.CNI.Cilium != nil {
+			// the cluster controller applies the changed agent placement with the cni upgrade
+			clu.CNI.Cilium.NodeSelector = c.CNI.Cilium.NodeSelector
+			clu.CNI.Cilium.Tolerations = c.CNI.Cilium.Tolerations
+		}
+		// the workloads are restarted by the next cni install or upgrade
+		clu.CNI.RestartWorkloads = c.CNI.RestartWorkloads
+		if confirm := request.QueryParameter(query.ParameterConfirm); confirm != "" {
+			// confirm=false makes the cni upgrades wait for the approval of their diff
+			if clu.Annotations == nil {
+				clu.Annotations = make(map[string]string)
+			}
+			if confirm == "false" {
+				clu.Annotations[common.AnnotationCNIUpgradeConfirm] = "false"
+			} else {
+				delete(clu.Annotations, common.AnnotationCNIUpgradeConfirm)
+			}
+		}
+		if errs := validation.ValidateClusterUpdate(clu, old); len(errs) > 0 {
+			restplus.HandleBadRequest(response, request, errs.ToAggregate())
+			return
+		}
 		_, err = h.clusterOperator.UpdateCluster(context.TODO(), clu)
 		if err != nil {
 			restplus.HandleInternalError(response, request, err)
@@ -568,6 +636,282 @@ func (h *handler) UpdateClusterCertification(request *restful.Request, response
 	_ = response.WriteHeaderAndEntity(http.StatusOK, c)
 }
 
+func (h *handler) CheckClusterCNI(request *restful.Request, response *restful.Response) {
+	cluName := request.PathParameter(query.ParameterName)
+	ctx := request.Request.Context()
+	dryRun := query.GetBoolValueWithDefault(request, query.ParamDryRun, false)
+	c, err := h.clusterOperator.GetCluster(ctx, cluName)
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	if c.Status.Phase != v1.ClusterRunning {
+		restplus.HandleBadRequest(response, request, fmt.Errorf("cluster %s current is %s, can't check cni", c.Name, c.Status.Phase))
+		return
+	}
+
+	extraMeta, err := h.getClusterMetadata(ctx, c, false)
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	op, err := h.parseCheckCNIOperation(c, extraMeta)
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+
+	// the check does not change the cluster, the health of every node is in the step responses of the operation
+	op.Name = uuid.New().String()
+	op.Labels = map[string]string{
+		common.LabelClusterName:      c.Name,
+		common.LabelTimeoutSeconds:   v1.DefaultOperationTimeoutSecs,
+		common.LabelOperationAction:  v1.OperationCheckCNI,
+		common.LabelOperationSponsor: buildOperationSponsor(h.genericConfig),
+	}
+	op.Status.Status = v1.OperationStatusRunning
+	if !dryRun {
+		op, err = h.opOperator.CreateOperation(ctx, op)
+		if err != nil {
+			restplus.HandleBadRequest(response, request, err)
+			return
+		}
+	}
+
+	go h.doOperation(context.TODO(), op, &service.Options{DryRun: dryRun})
+	_ = response.WriteHeaderAndEntity(http.StatusOK, op)
+}
+
+func (h *handler) CheckClusterCNIConnectivity(request *restful.Request, response *restful.Response) {
+	cluName := request.PathParameter(query.ParameterName)
+	ctx := request.Request.Context()
+	dryRun := query.GetBoolValueWithDefault(request, query.ParamDryRun, false)
+	var timeout time.Duration
+	if ts := request.QueryParameter(query.ParameterTimeoutSeconds); ts != "" {
+		seconds, err := strconv.Atoi(ts)
+		if err != nil || seconds <= 0 {
+			restplus.HandleBadRequest(response, request, fmt.Errorf("invalid %s %q", query.ParameterTimeoutSeconds, ts))
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	c, err := h.clusterOperator.GetCluster(ctx, cluName)
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	if c.Status.Phase != v1.ClusterRunning {
+		restplus.HandleBadRequest(response, request, fmt.Errorf("cluster %s current is %s, can't test cni connectivity", c.Name, c.Status.Phase))
+		return
+	}
+
+	extraMeta, err := h.getClusterMetadata(ctx, c, false)
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	steps, err := k8s.CheckCNIConnectivity(extraMeta, &c.CNI, &c.Networking, timeout)
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+
+	// the test does not change the cluster, the result of every check is in the step response of the operation
+	op := &v1.Operation{Steps: steps}
+	op.Name = uuid.New().String()
+	op.Labels = map[string]string{
+		common.LabelClusterName:      c.Name,
+		common.LabelTimeoutSeconds:   v1.DefaultOperationTimeoutSecs,
+		common.LabelOperationAction:  v1.OperationCheckCNIConnectivity,
+		common.LabelOperationSponsor: buildOperationSponsor(h.genericConfig),
+	}
+	op.Status.Status = v1.OperationStatusRunning
+	if !dryRun {
+		op, err = h.opOperator.CreateOperation(ctx, op)
+		if err != nil {
+			restplus.HandleBadRequest(response, request, err)
+			return
+		}
+	}
+
+	go h.doOperation(context.TODO(), op, &service.Options{DryRun: dryRun})
+	_ = response.WriteHeaderAndEntity(http.StatusOK, op)
+}
+
+func (h *handler) ListClusterCNIOperations(request *restful.Request, response *restful.Response) {
+	c, err := h.clusterOperator.GetCluster(request.Request.Context(), request.PathParameter(query.ParameterName))
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	cf, err := cni.Load(c.CNI.Type)
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	_ = response.WriteHeaderAndEntity(http.StatusOK, cf.Create().Operations(c.CNI.Namespace))
+}
+
+func (h *handler) RunClusterCNIOperation(request *restful.Request, response *restful.Response) {
+	cluName := request.PathParameter(query.ParameterName)
+	name := request.PathParameter(query.ParameterOperation)
+	ctx := request.Request.Context()
+	dryRun := query.GetBoolValueWithDefault(request, query.ParamDryRun, false)
+	confirmed := query.GetBoolValueWithDefault(request, query.ParameterConfirm, false)
+	maxSize := int64(query.GetIntValueWithDefault(request, query.ParameterMaxSize, cni.DefaultSupportBundleMaxSize>>20)) << 20
+	if maxSize <= 0 {
+		restplus.HandleBadRequest(response, request, fmt.Errorf("invalid support bundle max size %d", maxSize>>20))
+		return
+	}
+	since := query.GetIntValueWithDefault(request, query.ParameterSince, 0)
+	if since < 0 {
+		restplus.HandleBadRequest(response, request, fmt.Errorf("invalid log window of %d minutes", since))
+		return
+	}
+	c, err := h.clusterOperator.GetCluster(ctx, cluName)
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	if c.Status.Phase != v1.ClusterRunning {
+		restplus.HandleBadRequest(response, request, fmt.Errorf("cluster %s current is %s, can't run cni operation", c.Name, c.Status.Phase))
+		return
+	}
+
+	extraMeta, err := h.getClusterMetadata(ctx, c, false)
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	steps, err := k8s.RunCNIOperation(extraMeta, &c.CNI, &c.Networking, name, cni.OperationOptions{Confirmed: confirmed, MaxBundleSize: maxSize,
+		Since: time.Duration(since) * time.Minute})
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	bundle := isBundleOperation(&c.CNI, name)
+
+	op := &v1.Operation{Steps: steps}
+	op.Name = uuid.New().String()
+	op.Labels = map[string]string{
+		common.LabelClusterName:      c.Name,
+		common.LabelTimeoutSeconds:   v1.DefaultOperationTimeoutSecs,
+		common.LabelOperationAction:  v1.OperationRunCNIOperation,
+		common.LabelOperationSponsor: buildOperationSponsor(h.genericConfig),
+	}
+	op.Status.Status = v1.OperationStatusRunning
+	if !dryRun {
+		op, err = h.opOperator.CreateOperation(ctx, op)
+		if err != nil {
+			restplus.HandleBadRequest(response, request, err)
+			return
+		}
+	}
+
+	go func() {
+		h.doOperation(context.TODO(), op, &service.Options{DryRun: dryRun})
+		if bundle && !dryRun {
+			if err := h.fetchSupportBundle(context.TODO(), op.Name, steps[0].Nodes[0].ID, maxSize); err != nil {
+				logger.Error("fetch cni support bundle error", zap.String("operation", op.Name), zap.Error(err))
+			}
+		}
+	}()
+	_ = response.WriteHeaderAndEntity(http.StatusOK, op)
+}
+
+// isBundleOperation returns whether the operation name of c collects a support bundle.
+func isBundleOperation(c *v1.CNI, name string) bool {
+	cf, err := cni.Load(c.Type)
+	if err != nil {
+		return false
+	}
+	for _, op := range cf.Create().Operations(c.Namespace) {
+		if op.Name == name {
+			return op.Output == cni.OperationOutputBundle
+		}
+	}
+	return false
+}
+
+// fetchSupportBundle downloads the support bundle the operation opID collected on node into supportBundleDir,
+// chunk by chunk so that every chunk fits in a message.
+func (h *handler) fetchSupportBundle(ctx context.Context, opID, node string, maxSize int64) error {
+	if err := os.MkdirAll(supportBundleDir, 0700); err != nil {
+		return err
+	}
+	bundle := filepath.Join(supportBundleDir, opID+".tar.gz")
+	f, err := os.CreateTemp(supportBundleDir, opID+"-*.part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	var offset int64
+	for {
+		req, err := json.Marshal(oplog.FileContentRequest{OpID: opID, Name: cni.SupportBundleFile, Offset: offset})
+		if err != nil {
+			return err
+		}
+		reqCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+		resp, err := h.delivery.DeliverFileRequest(reqCtx, &service.LogOperation{
+			Op:                service.OperationFile,
+			OperationIdentity: string(req),
+			To:                node,
+		})
+		cancel()
+		if err != nil {
+			return err
+		}
+		if resp.FileSize > maxSize {
+			return fmt.Errorf("the support bundle has %d bytes, more than the %d bytes allowed", resp.FileSize, maxSize)
+		}
+		if _, err = f.Write(resp.Content); err != nil {
+			return err
+		}
+		offset += int64(len(resp.Content))
+		if offset >= resp.FileSize || len(resp.Content) == 0 {
+			break
+		}
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), bundle)
+}
+
+func (h *handler) DownloadClusterCNIBundle(request *restful.Request, response *restful.Response) {
+	cluName := request.PathParameter(query.ParameterName)
+	opID := request.PathParameter(query.ParameterOperation)
+	op, err := h.opOperator.GetOperationEx(request.Request.Context(), opID, "0")
+	if err != nil {
+		if apimachineryErrors.IsNotFound(err) {
+			restplus.HandleNotFound(response, request, err)
+			return
+		}
+		restplus.HandleInternalError(response, request, err)
+		return
+	}
+	if op.Labels[common.LabelClusterName] != cluName || op.Labels[common.LabelOperationAction] != v1.OperationRunCNIOperation {
+		restplus.HandleNotFound(response, request, fmt.Errorf("operation %s is not a cni operation of cluster %s", opID, cluName))
+		return
+	}
+	f, err := os.Open(filepath.Join(supportBundleDir, opID+".tar.gz"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			restplus.HandleNotFound(response, request, fmt.Errorf("the support bundle of operation %s is not collected yet", opID))
+			return
+		}
+		restplus.HandleInternalError(response, request, err)
+		return
+	}
+	defer f.Close()
+	response.AddHeader("Content-Type", "application/gzip")
+	response.AddHeader("Content-Disposition", fmt.Sprintf("attachment; filename=%s-cni-%s.tar.gz", cluName, opID))
+	if _, err = io.Copy(response, f); err != nil {
+		logger.Error("write cni support bundle error", zap.String("operation", opID), zap.Error(err))
+	}
+}
+
 func (h *handler) GetKubeConfig(request *restful.Request, response *restful.Response) {
 	name := request.PathParameter(query.ParameterName)
 	proxyMode := strings.ToLower(request.QueryParameter("proxy")) == "true"
@@ -594,30 +938,142 @@ func (h *handler) GetKubeConfig(request *restful.Request, response *restful.Resp
 			restplus.HandleInternalError(response, request, err)
 			return
 		}
-
-		if clu.Annotations != nil && clu.Annotations[common.AnnotationActualName] != "" {
-			extraMeta.ClusterName = clu.Annotations[common.AnnotationActualName]
+		kubeConfig, err := h.getClusterKubeConfig(clu, extraMeta)
+		if err != nil {
+			restplus.HandleInternalError(response, request, err)
+			return
 		}
+		kubeConfigData = []byte(kubeConfig)
+	}
+
+	_, _ = response.Write(kubeConfigData)
+}
+
+// getClusterKubeConfig reads the admin kubeconfig of the cluster from an available master.
+func (h *handler) getClusterKubeConfig(clu *v1.Cluster, extraMeta *component.ExtraMetadata) (string, error) {
+	clusterName := extraMeta.ClusterName
+	if clu.Annotations != nil && clu.Annotations[common.AnnotationActualName] != "" {
+		clusterName = clu.Annotations[common.AnnotationActualName]
+	}
+
+	masters, err := extraMeta.Masters.AvailableKubeMasters()
+	if err != nil {
+		return "", err
+	}
+	var externalAddress string
+	if clu.Labels != nil {
+		externalAddress = clu.Labels[common.LabelExternalIP]
+	}
+	return k8s.GetKubeConfig(context.TODO(), clusterName, masters[0], externalAddress, h.delivery)
+}
+
+func (h *handler) ConnectClusterMesh(request *restful.Request, response *restful.Response) {
+	cluName := request.PathParameter(query.ParameterName)
+	ctx := request.Request.Context()
+	dryRun := query.GetBoolValueWithDefault(request, query.ParamDryRun, false)
+	body := &ClusterMeshConnect{}
+	if err := request.ReadEntity(body); err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	if body.Peer == "" || body.Peer == cluName {
+		restplus.HandleBadRequest(response, request, fmt.Errorf("the peer of the cluster mesh must be another cluster"))
+		return
+	}
 
-		masters, err := extraMeta.Masters.AvailableKubeMasters()
+	var (
+		clusters   []*v1.Cluster
+		metas      []*component.ExtraMetadata
+		kubeConfig = make(map[string]string)
+	)
+	for _, name := range []string{cluName, body.Peer} {
+		c, err := h.clusterOperator.GetCluster(ctx, name)
 		if err != nil {
-			restplus.HandleInternalError(response, request, err)
+			restplus.HandleBadRequest(response, request, err)
 			return
 		}
-		var externalAddress string
-		if clu.Labels != nil {
-			externalAddress = clu.Labels[common.LabelExternalIP]
+		if c.Status.Phase != v1.ClusterRunning {
+			restplus.HandleBadRequest(response, request, fmt.Errorf("cluster %s current is %s, can't connect cluster mesh", c.Name, c.Status.Phase))
+			return
 		}
-
-		kubeConfig, err := k8s.GetKubeConfig(context.TODO(), extraMeta.ClusterName, masters[0], externalAddress, h.delivery)
+		if c.CNI.Cilium == nil || c.CNI.Cilium.ClusterMesh == nil || !c.CNI.Cilium.ClusterMesh.EnableClusterMesh {
+			restplus.HandleBadRequest(response, request, fmt.Errorf("cilium cluster mesh is not enabled on cluster %s", c.Name))
+			return
+		}
+		extraMeta, err := h.getClusterMetadata(ctx, c, false)
+		if err != nil {
+			restplus.HandleBadRequest(response, request, err)
+			return
+		}
+		config, err := h.getClusterKubeConfig(c, extraMeta)
 		if err != nil {
 			restplus.HandleInternalError(response, request, err)
 			return
 		}
-		kubeConfigData = []byte(kubeConfig)
+		clusters, metas = append(clusters, c), append(metas, extraMeta)
+		kubeConfig[c.CNI.Cilium.ClusterMesh.ClusterName] = config
+	}
+	if err := cni.ValidateClusterMeshPeers(clusters[0].CNI.Cilium.ClusterMesh, clusters[1].CNI.Cilium.ClusterMesh); err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	merged, err := k8s.MergeKubeConfig(kubeConfig)
+	if err != nil {
+		restplus.HandleInternalError(response, request, err)
+		return
+	}
+	steps, err := k8s.ConnectClusterMesh(metas[0], clusters[0], metas[1], clusters[1], merged)
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
 	}
 
-	_, _ = response.Write(kubeConfigData)
+	// the connection does not change the cluster specs, both of them carry the cluster mesh configuration already
+	op := &v1.Operation{Steps: steps}
+	op.Name = uuid.New().String()
+	op.Labels = map[string]string{
+		common.LabelClusterName:      cluName,
+		common.LabelTimeoutSeconds:   v1.DefaultOperationTimeoutSecs,
+		common.LabelOperationAction:  v1.OperationConnectClusterMesh,
+		common.LabelOperationSponsor: buildOperationSponsor(h.genericConfig),
+	}
+	op.Status.Status = v1.OperationStatusRunning
+	if !dryRun {
+		op, err = h.opOperator.CreateOperation(ctx, op)
+		if err != nil {
+			restplus.HandleBadRequest(response, request, err)
+			return
+		}
+	}
+
+	go h.doOperation(context.TODO(), op, &service.Options{DryRun: dryRun})
+	_ = response.WriteHeaderAndEntity(http.StatusOK, op)
+}
+
+// checkClusterMeshIdentity rejects a cilium cluster mesh name or id already used by another cluster, the clusters
+// of a mesh must have distinct identities and any two clusters may be connected later.
+func (h *handler) checkClusterMeshIdentity(ctx context.Context, c *v1.Cluster) error {
+	if c.CNI.Cilium == nil || c.CNI.Cilium.ClusterMesh == nil || !c.CNI.Cilium.ClusterMesh.EnableClusterMesh {
+		return nil
+	}
+	mesh := c.CNI.Cilium.ClusterMesh
+	clusters, err := h.clusterOperator.ListClusters(ctx, &query.Query{
+		Pagination:           query.NoPagination(),
+		ResourceVersion:      "0",
+		ResourceVersionMatch: query.ResourceVersionMatchNotOlderThan,
+	})
+	if err != nil {
+		return err
+	}
+	for _, item := range clusters.Items {
+		if item.Name == c.Name || item.CNI.Cilium == nil || item.CNI.Cilium.ClusterMesh == nil || !item.CNI.Cilium.ClusterMesh.EnableClusterMesh {
+			continue
+		}
+		if err = cni.ValidateClusterMeshPeers(mesh, item.CNI.Cilium.ClusterMesh); err != nil {
+			return fmt.Errorf("cluster %s: %v", item.Name, err)
+		}
+	}
+	return nil
 }
 
 func (h *handler) getProxyKubeConfig(ctx context.Context, clusterName string) ([]byte, error) {
@@ -792,6 +1248,44 @@ func (h *handler) syncNodeDisable(node *v1.Node, reqDisable bool) error {
 	return nil
 }
 
+// CollectNodePackageCache collects the package cache of the node and reports the reclaimed bytes, the package versions
+// used by the cluster of the node are never removed.
+func (h *handler) CollectNodePackageCache(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter(query.ParameterName)
+	ctx := request.Request.Context()
+	req := &downloader.CacheGCRequest{
+		CacheGCPolicy: downloader.CacheGCPolicy{
+			KeepVersions: query.GetIntValueWithDefault(request, query.ParameterKeepVersions, 0),
+			MaxSize:      int64(query.GetIntValueWithDefault(request, query.ParameterMaxSize, 0)) << 20,
+		},
+		DryRun: query.GetBoolValueWithDefault(request, query.ParamDryRun, false),
+	}
+	node, err := h.clusterOperator.GetNodeEx(ctx, name, "0")
+	if err != nil {
+		if apimachineryErrors.IsNotFound(err) {
+			restplus.HandleNotFound(response, request, err)
+			return
+		}
+		restplus.HandleInternalError(response, request, err)
+		return
+	}
+	if clusterName := node.Labels[common.LabelClusterName]; clusterName != "" {
+		c, err := h.clusterOperator.GetCluster(ctx, clusterName)
+		if err != nil {
+			// the packages in use are unknown, nothing may be removed safely
+			restplus.HandleInternalError(response, request, err)
+			return
+		}
+		req.InUse = k8s.ClusterPackages(c)
+	}
+	result, err := h.delivery.DeliverCacheGCRequest(ctx, node.Name, req)
+	if err != nil {
+		restplus.HandleInternalError(response, request, err)
+		return
+	}
+	_ = response.WriteHeaderAndEntity(http.StatusOK, result)
+}
+
 // DeleteNode delete node record from etcd,only called by kcctl now.
 func (h *handler) DeleteNode(request *restful.Request, response *restful.Response) {
 	name := request.PathParameter(query.ParameterName)
@@ -951,6 +1445,8 @@ func (h *handler) getClusterMetadata(ctx context.Context, c *v1.Cluster, skipNod
 		ControlPlaneStatus: c.Status.ControlPlaneHealth,
 		CNI:                c.CNI.Type,
 		CNINamespace:       c.CNI.Namespace,
+		KubeProxyMode:      c.Networking.ProxyMode,
+		APIServerEndpoint:  c.ExternalAPIServerEndpoint(),
 	}
 
 	if c.Annotations != nil {
@@ -962,6 +1458,15 @@ func (h *handler) getClusterMetadata(ctx context.Context, c *v1.Cluster, skipNod
 
 	meta.Addons = append(meta.Addons, c.Addons...)
 
+	overrides, err := h.getCNITemplateOverrides(ctx)
+	if err != nil {
+		return nil, err
+	}
+	meta.CNITemplates = overrides
+	if err = h.completeLocalRegistry(ctx, meta, &c.CNI); err != nil {
+		return nil, err
+	}
+
 	masters, err := h.getNodeInfo(ctx, c.Masters, skipNodeNotFound)
 	if err != nil {
 		return nil, err
@@ -1010,8 +1515,14 @@ func (h *handler) getNodeInfo(ctx context.Context, nodes v1.WorkerNodeList, skip
 			Region:   n.Labels[common.LabelTopologyRegion],
 			Hostname: n.Labels[common.LabelHostname],
 			Role:     n.Labels[common.LabelNodeRole],
+			Arch:     n.Labels[common.LabelArchStable],
+			HelmSDK:  n.Labels[common.LabelAgentHelmSDK] == "true",
+			WaitFor:  n.Labels[common.LabelAgentWaitFor] == "true",
+			Labels:   node.Labels,
 		}
 		_, item.Disable = n.Labels[common.LabelNodeDisable]
+		item.Deleting = n.DeletionTimestamp != nil
+		item.Conditions = n.Status.Conditions
 		meta = append(meta, item)
 	}
 
@@ -1168,6 +1679,9 @@ func (h *handler) createClusterCheck(ctx context.Context, c *v1.Cluster) error {
 	if len(c.Masters) == 0 {
 		return fmt.Errorf("cluster must have one master node")
 	}
+	if err := h.checkClusterMeshIdentity(ctx, c); err != nil {
+		return err
+	}
 
 	cluInfo, err := h.clusterOperator.GetClusterEx(ctx, c.Name, "0")
 	if err != nil && !apimachineryErrors.IsNotFound(err) {
@@ -1535,6 +2049,7 @@ func (h *handler) UpdateBackup(request *restful.Request, response *restful.Respo
 
 func (h *handler) RetryCluster(request *restful.Request, response *restful.Response) {
 	dryRun := query.GetBoolValueWithDefault(request, query.ParamDryRun, false)
+	force := query.GetBoolValueWithDefault(request, query.ParameterForce, false)
 	name := request.PathParameter(query.ParameterName)
 
 	op, err := h.opOperator.GetOperationEx(request.Request.Context(), name, "0")
@@ -1581,7 +2096,16 @@ func (h *handler) RetryCluster(request *restful.Request, response *restful.Respo
 
 	// if there is an uninstall error, continue directly from the current step
 	var continueSteps []v1.Step
-	if op.Steps[0].Action == v1.ActionInstall {
+	// the components rolled back after the failure are installed again, the retry restarts from the earliest
+	rollbacks := op.CompletedRollbacks(failedIndex)
+	if op.Steps[0].Action == v1.ActionInstall && op.Status.Status == v1.OperationStatusFailed && len(rollbacks) > 0 {
+		restartIndex := rollbacks[len(rollbacks)-1]
+		continueSteps = op.Steps[restartIndex:]
+		op.Status.Conditions = op.Status.Conditions[0:restartIndex]
+		if restartIndex > 0 && len(op.Status.Conditions[restartIndex-1].Status) > 0 && op.Status.Conditions[restartIndex-1].Status[0].Response != nil {
+			ctx = component.WithExtraData(ctx, op.Status.Conditions[restartIndex-1].Status[0].Response)
+		}
+	} else if op.Steps[0].Action == v1.ActionInstall {
 		findStepNode := func(nodes []v1.StepNode, nodeID string) v1.StepNode {
 			for _, v := range nodes {
 				if v.ID == nodeID {
@@ -1652,10 +2176,124 @@ func (h *handler) RetryCluster(request *restful.Request, response *restful.Respo
 
 	op.Steps = continueSteps
 
-	go h.doOperation(ctx, op, &service.Options{DryRun: dryRun})
+	go h.doOperation(ctx, op, &service.Options{DryRun: dryRun, Force: force})
+	_ = response.WriteHeaderAndEntity(http.StatusOK, nil)
+}
+
+// ApproveOperation approves the diff of the operation pending approval, the steps following the diff step run.
+func (h *handler) ApproveOperation(request *restful.Request, response *restful.Response) {
+	dryRun := query.GetBoolValueWithDefault(request, query.ParamDryRun, false)
+	name := request.PathParameter(query.ParameterName)
+
+	op, err := h.opOperator.GetOperationEx(request.Request.Context(), name, "0")
+	if err != nil {
+		if apimachineryErrors.IsNotFound(err) {
+			restplus.HandleNotFound(response, request, err)
+			return
+		}
+		restplus.HandleInternalError(response, request, err)
+		return
+	}
+	steps, err := clusteroperation.ApprovedSteps(op)
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+
+	op.Labels[common.LabelOperationConfirm] = "true"
+	op.Status.Status = v1.OperationStatusRunning
+	if !dryRun {
+		if op, err = h.opOperator.UpdateOperation(context.TODO(), op); err != nil {
+			restplus.HandleInternalError(response, request, err)
+			return
+		}
+	}
+
+	op.Steps = steps
+	go h.doOperation(context.TODO(), op, &service.Options{DryRun: dryRun})
 	_ = response.WriteHeaderAndEntity(http.StatusOK, nil)
 }
 
+// progressKeepalive how often an idle watch of the operation progress writes a comment, the proxies close the
+// streams idle for long.
+const progressKeepalive = 15 * time.Second
+
+// WatchOperationProgress streams the progress of the operation as server-sent events until the operation stops
+// running. The event id is the cursor of the event, a reconnecting watcher resumes after the Last-Event-ID header
+// or the cursor parameter. The events are step for the state transitions of the steps, output for the output of
+// the steps and operation for the status the operation stopped with.
+func (h *handler) WatchOperationProgress(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter(query.ParameterName)
+	cursor := request.HeaderParameter("Last-Event-ID")
+	if cursor == "" {
+		cursor = request.QueryParameter(query.ParameterCursor)
+	}
+	var after int64
+	if cursor != "" {
+		var err error
+		if after, err = strconv.ParseInt(cursor, 10, 64); err != nil || after < 0 {
+			restplus.HandleBadRequest(response, request, fmt.Errorf("invalid cursor %q", cursor))
+			return
+		}
+	}
+	ctx := request.Request.Context()
+	if _, err := h.opOperator.GetOperationEx(ctx, name, "0"); err != nil {
+		if apimachineryErrors.IsNotFound(err) {
+			restplus.HandleNotFound(response, request, err)
+			return
+		}
+		restplus.HandleInternalError(response, request, err)
+		return
+	}
+	flusher, ok := response.ResponseWriter.(http.Flusher)
+	if !ok {
+		restplus.HandleInternalError(response, request, fmt.Errorf("unable to watch the progress - can't get http.Flusher: %#v", response.ResponseWriter))
+		return
+	}
+	response.Header().Set("Content-Type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events := h.delivery.WatchOperationProgress(ctx, name, after)
+	ticker := time.NewTicker(progressKeepalive)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			data, merr := json.Marshal(e)
+			if merr != nil {
+				logger.Error("marshal operation progress error", zap.String("operation", name), zap.Error(merr))
+				return
+			}
+			_, err = fmt.Fprintf(response, "id: %d\nevent: %s\ndata: %s\n\n", e.Cursor, progressEventType(e), data)
+		case <-ticker.C:
+			_, err = fmt.Fprint(response, ": keepalive\n\n")
+		}
+		if err != nil {
+			logger.Debug("write operation progress failed", zap.String("operation", name), zap.Error(err))
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// progressEventType the server-sent event type of e.
+func progressEventType(e service.ProgressEvent) string {
+	switch {
+	case e.OperationStatus != "":
+		return "operation"
+	case e.Status != "":
+		return "step"
+	default:
+		return "output"
+	}
+}
+
 func (h *handler) CreateRecovery(request *restful.Request, response *restful.Response) {
 	r := &v1.Recovery{}
 	if err := request.ReadEntity(r); err != nil {
@@ -1898,6 +2536,218 @@ func (h *handler) InstallOrUninstallPlugins(request *restful.Request, response *
 	_ = response.WriteHeaderAndEntity(http.StatusOK, clu)
 }
 
+func (h *handler) RenderCNI(request *restful.Request, response *restful.Response) {
+	body := &CNIRender{}
+	if err := request.ReadEntity(body); err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	cf, err := cni.Load(body.CNI.Type)
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	meta := &component.ExtraMetadata{
+		CRI:           body.CNI.CriType,
+		LocalRegistry: body.CNI.LocalRegistry,
+		Offline:       body.CNI.Offline,
+		CNI:           body.CNI.Type,
+		CNINamespace:  body.CNI.Namespace,
+		KubeProxyMode: body.Networking.ProxyMode,
+	}
+	if meta.CNITemplates, err = h.getCNITemplateOverrides(request.Request.Context()); err != nil {
+		restplus.HandleInternalError(response, request, err)
+		return
+	}
+	stepper := cf.Create().InitStep(meta, &body.CNI, &body.Networking)
+	if err = stepper.Validate(); err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	values, err := stepper.RenderString(request.Request.Context())
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	_ = response.WriteHeaderAndEntity(http.StatusOK, CNIRenderResult{Values: values})
+}
+
+// PlanCNI returns the cni steps which creating the cluster would run, nothing is run or stored.
+func (h *handler) PlanCNI(request *restful.Request, response *restful.Response) {
+	c := v1.Cluster{}
+	if err := request.ReadEntity(&c); err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	ctx := request.Request.Context()
+	c.Complete()
+	extraMeta, err := h.getClusterMetadata(ctx, &c, false)
+	if err != nil {
+		if apimachineryErrors.IsNotFound(err) || err == ErrNodesRegionDifferent {
+			restplus.HandleBadRequest(response, request, err)
+			return
+		}
+		restplus.HandleInternalError(response, request, err)
+		return
+	}
+	extraMeta.OperationType = v1.OperationCreateCluster
+	plan, err := k8s.PlanCNI(ctx, extraMeta, &c)
+	if err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	_ = response.WriteHeaderAndEntity(http.StatusOK, plan)
+}
+
+// getCNITemplateOverrides returns the overrides of the cni templates keyed by cni.TemplateDataKey, nil when there is none.
+func (h *handler) getCNITemplateOverrides(ctx context.Context) (map[string]string, error) {
+	cm, err := h.coreOperator.GetConfigMapEx(ctx, cni.TemplateOverridesConfigMap, "0")
+	if err != nil {
+		if apimachineryErrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return cm.Data, nil
+}
+
+// completeLocalRegistry resolves the credentials and the CA bundle of the local registry of cni, the credentials
+// ConfigMap of the cni takes precedence over the platform registry settings.
+func (h *handler) completeLocalRegistry(ctx context.Context, meta *component.ExtraMetadata, cni *v1.CNI) error {
+	if meta.LocalRegistry == "" {
+		return nil
+	}
+	if config := cni.LocalRegistryConfig; config != nil {
+		if config.CABundleRef != "" {
+			cm, err := h.coreOperator.GetConfigMapEx(ctx, config.CABundleRef, "0")
+			if err != nil {
+				return fmt.Errorf("get the local registry CA bundle %s: %w", config.CABundleRef, err)
+			}
+			if meta.LocalRegistryCA = cm.Data["ca.crt"]; meta.LocalRegistryCA == "" {
+				return fmt.Errorf("the local registry CA bundle %s has no ca.crt", config.CABundleRef)
+			}
+		}
+		if config.CredentialsRef != "" {
+			cm, err := h.coreOperator.GetConfigMapEx(ctx, config.CredentialsRef, "0")
+			if err != nil {
+				return fmt.Errorf("get the local registry credentials %s: %w", config.CredentialsRef, err)
+			}
+			meta.LocalRegistryUsername, meta.LocalRegistryPassword = cm.Data["username"], cm.Data["password"]
+			if meta.LocalRegistryUsername == "" || meta.LocalRegistryPassword == "" {
+				return fmt.Errorf("the local registry credentials %s need the username and password", config.CredentialsRef)
+			}
+			return nil
+		}
+	}
+	setting, err := h.platformOperator.GetPlatformSetting(ctx)
+	if err != nil {
+		return err
+	}
+	host, _, _ := strings.Cut(meta.LocalRegistry, "/")
+	for _, r := range setting.Template.InsecureRegistry {
+		if r.Host == meta.LocalRegistry || r.Host == host {
+			meta.LocalRegistryUsername, meta.LocalRegistryPassword = r.Username, r.Password
+			break
+		}
+	}
+	return nil
+}
+
+// DescribeCNITemplate returns the override of the cni template if there is one, otherwise the built-in template.
+func (h *handler) DescribeCNITemplate(request *restful.Request, response *restful.Response) {
+	key := request.PathParameter("key")
+	t, err := cni.LoadOverridableTemplate(key)
+	if err != nil {
+		restplus.HandleNotFound(response, request, err)
+		return
+	}
+	overrides, err := h.getCNITemplateOverrides(request.Request.Context())
+	if err != nil {
+		restplus.HandleInternalError(response, request, err)
+		return
+	}
+	result := CNITemplate{Key: key, Template: t.DefaultTemplate()}
+	if text, ok := overrides[cni.TemplateDataKey(key)]; ok {
+		result.Template, result.Overridden = text, true
+	}
+	_ = response.WriteHeaderAndEntity(http.StatusOK, result)
+}
+
+// UpdateCNITemplate stores the override of the cni template, it is used by the cni steps generated afterwards.
+func (h *handler) UpdateCNITemplate(request *restful.Request, response *restful.Response) {
+	key := request.PathParameter("key")
+	body := &CNITemplate{}
+	if err := request.ReadEntity(body); err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	t, err := cni.LoadOverridableTemplate(key)
+	if err != nil {
+		restplus.HandleNotFound(response, request, err)
+		return
+	}
+	if strings.TrimSpace(body.Template) == "" {
+		restplus.HandleBadRequest(response, request, fmt.Errorf("the template of %s is empty, delete it to restore the built-in template", key))
+		return
+	}
+	if err = t.ValidateTemplate(body.Template); err != nil {
+		restplus.HandleBadRequest(response, request, err)
+		return
+	}
+	dryRun := query.GetBoolValueWithDefault(request, query.ParamDryRun, false)
+	ctx := request.Request.Context()
+	cm, err := h.coreOperator.GetConfigMapEx(ctx, cni.TemplateOverridesConfigMap, "0")
+	if err != nil && !apimachineryErrors.IsNotFound(err) {
+		restplus.HandleInternalError(response, request, err)
+		return
+	}
+	if !dryRun {
+		if err != nil {
+			cm = &v1.ConfigMap{Data: map[string]string{cni.TemplateDataKey(key): body.Template}}
+			cm.Name = cni.TemplateOverridesConfigMap
+			_, err = h.coreOperator.CreateConfigMap(ctx, cm)
+		} else {
+			if cm.Data == nil {
+				cm.Data = make(map[string]string)
+			}
+			cm.Data[cni.TemplateDataKey(key)] = body.Template
+			_, err = h.coreOperator.UpdateConfigMap(ctx, cm)
+		}
+		if err != nil {
+			restplus.HandleInternalError(response, request, err)
+			return
+		}
+	}
+	_ = response.WriteHeaderAndEntity(http.StatusOK, CNITemplate{Key: key, Template: body.Template, Overridden: true})
+}
+
+// DeleteCNITemplate removes the override of the cni template, the built-in template is used again.
+func (h *handler) DeleteCNITemplate(request *restful.Request, response *restful.Response) {
+	key := request.PathParameter("key")
+	t, err := cni.LoadOverridableTemplate(key)
+	if err != nil {
+		restplus.HandleNotFound(response, request, err)
+		return
+	}
+	dryRun := query.GetBoolValueWithDefault(request, query.ParamDryRun, false)
+	ctx := request.Request.Context()
+	cm, err := h.coreOperator.GetConfigMapEx(ctx, cni.TemplateOverridesConfigMap, "0")
+	if err != nil && !apimachineryErrors.IsNotFound(err) {
+		restplus.HandleInternalError(response, request, err)
+		return
+	}
+	if err == nil && !dryRun {
+		if _, ok := cm.Data[cni.TemplateDataKey(key)]; ok {
+			delete(cm.Data, cni.TemplateDataKey(key))
+			if _, err = h.coreOperator.UpdateConfigMap(ctx, cm); err != nil {
+				restplus.HandleInternalError(response, request, err)
+				return
+			}
+		}
+	}
+	_ = response.WriteHeaderAndEntity(http.StatusOK, CNITemplate{Key: key, Template: t.DefaultTemplate()})
+}
+
 func (h *handler) UpgradeCluster(request *restful.Request, response *restful.Response) {
 	name := request.PathParameter(query.ParameterName)
 	body := &ClusterUpgrade{}
@@ -0,0 +1,133 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kubeclipper/kubeclipper/pkg/errors"
+	mock_cluster "github.com/kubeclipper/kubeclipper/pkg/models/cluster/mock"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func clusterRequest(t *testing.T, method string, c *v1.Cluster) (*restful.Request, *restful.Response, *httptest.ResponseRecorder) {
+	body, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(method, "/clusters/"+c.Name, bytes.NewReader(body))
+	r.Header.Set("Content-Type", restful.MIME_JSON)
+	request := restful.NewRequest(r)
+	request.PathParameters()["name"] = c.Name
+	recorder := httptest.NewRecorder()
+	response := restful.NewResponse(recorder)
+	response.SetRequestAccepts(restful.MIME_JSON)
+	return request, response, recorder
+}
+
+func checkBadRequest(t *testing.T, recorder *httptest.ResponseRecorder, wantReason string) {
+	t.Helper()
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusBadRequest, recorder.Body.String())
+	}
+	httpErr := errors.HTTPError{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &httpErr); err != nil {
+		t.Fatalf("unmarshal the response: %v", err)
+	}
+	if !strings.Contains(httpErr.Reason, wantReason) {
+		t.Errorf("reason = %q, want %q", httpErr.Reason, wantReason)
+	}
+}
+
+func cniCluster(cniType string, cilium *v1.Cilium) *v1.Cluster {
+	return &v1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo"},
+		Masters:    v1.WorkerNodeList{{ID: "node1"}},
+		Networking: v1.Networking{
+			IPFamily: v1.IPFamilyIPv4,
+			Services: v1.NetworkRanges{CIDRBlocks: []string{"10.96.0.0/12"}},
+			Pods:     v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}},
+		},
+		CNI: v1.CNI{Type: cniType, Version: "1.14.4", Cilium: cilium},
+	}
+}
+
+func TestHandler_CreateClustersInvalidCNI(t *testing.T) {
+	tests := []struct {
+		name       string
+		cluster    *v1.Cluster
+		wantReason string
+	}{
+		{
+			name:       "mask size",
+			cluster:    cniCluster("cilium", &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"}, ClusterPoolIPv4MaskSize: 33}),
+			wantReason: "cni.cilium.clusterPoolIPv4MaskSize: Invalid value: 33",
+		},
+		{
+			name:       "operator replicas",
+			cluster:    cniCluster("cilium", &v1.Cilium{OperatorReplicas: -1}),
+			wantReason: "cni.cilium.operatorReplicas: Invalid value: -1",
+		},
+		{
+			name:       "tunnel mode",
+			cluster:    cniCluster("cilium", &v1.Cilium{TunnelMode: "gre"}),
+			wantReason: `cni.cilium.tunnelMode: Invalid value: invalid cilium tunnel mode "gre"`,
+		},
+		{
+			name:       "unsupported type",
+			cluster:    cniCluster("weave", nil),
+			wantReason: `cni.type: Unsupported value: "weave"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, response, recorder := clusterRequest(t, http.MethodPost, tt.cluster)
+			// the invalid clusters are rejected before the operator is used
+			(&handler{}).CreateClusters(request, response)
+			checkBadRequest(t, recorder, tt.wantReason)
+		})
+	}
+}
+
+func TestHandler_UpdateClustersInvalidCNI(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	clusterMockOperator := mock_cluster.NewMockOperator(ctrl)
+	running := cniCluster("calico", nil)
+	running.CNI.Calico = &v1.Calico{}
+	running.Status.Phase = v1.ClusterRunning
+	clusterMockOperator.EXPECT().GetCluster(gomock.Any(), "demo").Return(running, nil)
+	clusterMockOperator.EXPECT().UpdateCluster(gomock.Any(), gomock.Any()).Times(0)
+	h := &handler{clusterOperator: clusterMockOperator}
+
+	// the migration to cilium validates the new cilium configuration
+	request, response, recorder := clusterRequest(t, http.MethodPut,
+		cniCluster("cilium", &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"}, ClusterPoolIPv4MaskSize: 33}))
+	h.UpdateClusters(request, response)
+	checkBadRequest(t, recorder, "cni.cilium.clusterPoolIPv4MaskSize: Invalid value: 33")
+}
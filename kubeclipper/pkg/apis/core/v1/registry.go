@@ -25,6 +25,7 @@ import (
 
 	"github.com/kubeclipper/kubeclipper/pkg/authentication/auth"
 	"github.com/kubeclipper/kubeclipper/pkg/models/core"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
 	"github.com/kubeclipper/kubeclipper/pkg/simple/generic"
 
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
@@ -46,6 +47,7 @@ import (
 	"github.com/kubeclipper/kubeclipper/pkg/models/operation"
 	"github.com/kubeclipper/kubeclipper/pkg/query"
 	corev1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
 	"github.com/kubeclipper/kubeclipper/pkg/service"
 )
 
@@ -141,6 +143,8 @@ func SetupWebService(h *handler) *restful.WebService {
 		Param(webservice.PathParameter("name", "cluster name")).
 		Param(webservice.QueryParameter(query.ParamDryRun, "dry run update clusters").
 			Required(false).DataType("boolean")).
+		Param(webservice.QueryParameter(query.ParameterConfirm, "false makes the cni upgrades of the cluster stop after the diff of the settings until the operation is approved.").
+			Required(false).DataType("boolean")).
 		Returns(http.StatusOK, http.StatusText(http.StatusOK), nil))
 
 	webservice.Route(webservice.DELETE("/clusters/{name}").
@@ -179,6 +183,90 @@ func SetupWebService(h *handler) *restful.WebService {
 		Returns(http.StatusOK, http.StatusText(http.StatusOK), corev1.Cluster{}).
 		Returns(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil))
 
+	webservice.Route(webservice.POST("/clusters/{name}/cni/check").
+		To(h.CheckClusterCNI).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
+		Doc("Check the cni health of cluster.").
+		Param(webservice.PathParameter(query.ParameterName, "cluster name").
+			Required(true).
+			DataType("string")).
+		Param(webservice.QueryParameter(query.ParamDryRun, "dry run check cni").
+			Required(false).DataType("boolean")).
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), corev1.Operation{}).
+		Returns(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil))
+
+	webservice.Route(webservice.POST("/clusters/{name}/cni/connectivity").
+		To(h.CheckClusterCNIConnectivity).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
+		Doc("Run the cni connectivity test of cluster.").
+		Param(webservice.PathParameter(query.ParameterName, "cluster name").
+			Required(true).
+			DataType("string")).
+		Param(webservice.QueryParameter(query.ParameterTimeoutSeconds, "the seconds the connectivity test may take, defaults to 600").
+			Required(false).DataType("integer")).
+		Param(webservice.QueryParameter(query.ParamDryRun, "dry run connectivity test").
+			Required(false).DataType("boolean")).
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), corev1.Operation{}).
+		Returns(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil))
+
+	webservice.Route(webservice.GET("/clusters/{name}/cni/operations").
+		To(h.ListClusterCNIOperations).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
+		Doc("List the operations of the cni of cluster.").
+		Param(webservice.PathParameter(query.ParameterName, "cluster name").
+			Required(true).
+			DataType("string")).
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), []cni.Operation{}).
+		Returns(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil))
+
+	webservice.Route(webservice.POST("/clusters/{name}/cni/operations/{operation}").
+		To(h.RunClusterCNIOperation).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
+		Doc("Run an operation of the cni of cluster, the command output is the step output of the operation.").
+		Param(webservice.PathParameter(query.ParameterName, "cluster name").
+			Required(true).
+			DataType("string")).
+		Param(webservice.PathParameter(query.ParameterOperation, "cni operation name, e.g. status").
+			Required(true).
+			DataType("string")).
+		Param(webservice.QueryParameter(query.ParameterConfirm, "confirm running a destructive operation").
+			Required(false).DataType("boolean")).
+		Param(webservice.QueryParameter(query.ParameterMaxSize, "the max size in MiB of the support bundle of a bundle operation, 200 by default").
+			Required(false).DataType("integer")).
+		Param(webservice.QueryParameter(query.ParameterSince, "the minutes of logs a log operation collects, e.g. collect-logs, 30 by default").
+			Required(false).DataType("integer")).
+		Param(webservice.QueryParameter(query.ParamDryRun, "dry run cni operation").
+			Required(false).DataType("boolean")).
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), corev1.Operation{}).
+		Returns(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil))
+
+	webservice.Route(webservice.GET("/clusters/{name}/cni/bundles/{operation}").
+		To(h.DownloadClusterCNIBundle).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
+		Doc("Download the support bundle collected by a bundle operation of the cni of cluster, e.g. sysdump.").
+		Param(webservice.PathParameter(query.ParameterName, "cluster name").
+			Required(true).
+			DataType("string")).
+		Param(webservice.PathParameter(query.ParameterOperation, "the ID of the operation which ran the bundle operation").
+			Required(true).
+			DataType("string")).
+		Produces("application/gzip").
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), nil).
+		Returns(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil))
+
+	webservice.Route(webservice.POST("/clusters/{name}/cni/clustermesh").
+		To(h.ConnectClusterMesh).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
+		Doc("Connect the cilium cluster mesh of cluster to the peer cluster.").
+		Reads(ClusterMeshConnect{}).
+		Param(webservice.PathParameter(query.ParameterName, "cluster name").
+			Required(true).
+			DataType("string")).
+		Param(webservice.QueryParameter(query.ParamDryRun, "dry run connect cluster mesh").
+			Required(false).DataType("boolean")).
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), corev1.Operation{}).
+		Returns(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil))
+
 	webservice.Route(webservice.GET("/clusters/{name}/kubeconfig").
 		To(h.GetKubeConfig).
 		Produces("text/plain", restful.MIME_JSON).
@@ -362,6 +450,25 @@ func SetupWebService(h *handler) *restful.WebService {
 		Returns(http.StatusOK, http.StatusText(http.StatusOK), corev1.Node{}).
 		Returns(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil))
 
+	webservice.Route(webservice.POST("/nodes/{name}/cache/gc").
+		To(h.CollectNodePackageCache).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreNodeTag}).
+		Doc("Collect the package cache of the node, the packages used by the cluster of the node are kept.").
+		Param(webservice.PathParameter(query.ParameterName, "node name").
+			Required(true).
+			DataType("string")).
+		Param(webservice.QueryParameter(query.ParameterKeepVersions, "the most recently used versions kept per package, the agent setting when it is 0, every version when it is negative").
+			Required(false).
+			DataType("integer")).
+		Param(webservice.QueryParameter(query.ParameterMaxSize, "the cache size in MiB the least recently used versions are removed down to, the agent setting when it is 0, unlimited when it is negative").
+			Required(false).
+			DataType("integer")).
+		Param(webservice.QueryParameter(query.ParamDryRun, "report the versions to remove without removing them").
+			Required(false).
+			DataType("boolean")).
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), downloader.CacheGCResult{}).
+		Returns(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil))
+
 	webservice.Route(webservice.DELETE("/nodes/{name}").
 		To(h.DeleteNode).
 		Metadata(restfulspec.KeyOpenAPITags, []string{CoreNodeTag}).
@@ -445,11 +552,38 @@ func SetupWebService(h *handler) *restful.WebService {
 		Doc("clusters retry operation.").
 		Param(webservice.QueryParameter(query.ParamDryRun, "dry run clusters retry operation.").
 			Required(false).DataType("boolean")).
+		Param(webservice.QueryParameter(query.ParameterForce, "run the steps completed in a prior attempt again instead of skipping them.").
+			Required(false).DataType("boolean")).
 		Param(webservice.PathParameter(query.ParameterName, "operation name").
 			Required(true).
 			DataType("string")).
 		Returns(http.StatusOK, http.StatusText(http.StatusOK), corev1.Cluster{}))
 
+	webservice.Route(webservice.GET("/operations/{name}/progress").
+		To(h.WatchOperationProgress).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
+		Doc("watch the progress of the operation as server-sent events, the step state transitions and the output of the steps reporting their progress.").
+		Produces("text/event-stream").
+		Param(webservice.PathParameter(query.ParameterName, "operation name").
+			Required(true).
+			DataType("string")).
+		Param(webservice.QueryParameter(query.ParameterCursor, "resume after the event of the cursor, the Last-Event-ID header takes precedence.").
+			Required(false).
+			DataType("integer")).
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), service.ProgressEvent{}).
+		Returns(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil))
+
+	webservice.Route(webservice.POST("/operations/{name}/approve").
+		To(h.ApproveOperation).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
+		Doc("approve the diff of the operation pending approval, the operation continues with the steps after the diff.").
+		Param(webservice.QueryParameter(query.ParamDryRun, "dry run the approved steps.").
+			Required(false).DataType("boolean")).
+		Param(webservice.PathParameter(query.ParameterName, "operation name").
+			Required(true).
+			DataType("string")).
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), nil))
+
 	webservice.Route(webservice.POST("/clusters/{name}/upgrade").
 		To(h.UpgradeCluster).
 		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
@@ -462,6 +596,53 @@ func SetupWebService(h *handler) *restful.WebService {
 			DataType("string")).
 		Returns(http.StatusOK, http.StatusText(http.StatusOK), nil))
 
+	webservice.Route(webservice.POST("/cni/render").
+		To(h.RenderCNI).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
+		Doc("Preview the rendered cni manifests or helm values.").
+		Reads(CNIRender{}).
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), CNIRenderResult{}))
+
+	webservice.Route(webservice.GET("/cni/templates/{key:*}").
+		To(h.DescribeCNITemplate).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
+		Doc("Describe the cni template registered under key.").
+		Param(webservice.PathParameter("key", "template key, e.g. cniInfo-cilium/v1/template").
+			Required(true).
+			DataType("string")).
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), CNITemplate{}).
+		Returns(http.StatusNotFound, http.StatusText(http.StatusNotFound), nil))
+
+	webservice.Route(webservice.PUT("/cni/templates/{key:*}").
+		To(h.UpdateCNITemplate).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
+		Doc("Override the cni template registered under key, the template must render against a sample configuration.").
+		Reads(CNITemplate{}).
+		Param(webservice.PathParameter("key", "template key, e.g. cniInfo-cilium/v1/template").
+			Required(true).
+			DataType("string")).
+		Param(webservice.QueryParameter(query.ParamDryRun, "dry run override cni template").
+			Required(false).DataType("boolean")).
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), CNITemplate{}))
+
+	webservice.Route(webservice.DELETE("/cni/templates/{key:*}").
+		To(h.DeleteCNITemplate).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
+		Doc("Restore the built-in cni template registered under key.").
+		Param(webservice.PathParameter("key", "template key, e.g. cniInfo-cilium/v1/template").
+			Required(true).
+			DataType("string")).
+		Param(webservice.QueryParameter(query.ParamDryRun, "dry run restore cni template").
+			Required(false).DataType("boolean")).
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), CNITemplate{}))
+
+	webservice.Route(webservice.POST("/plan").
+		To(h.PlanCNI).
+		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
+		Doc("Plan the cni steps of creating the cluster without running them.").
+		Reads(corev1.Cluster{}).
+		Returns(http.StatusOK, http.StatusText(http.StatusOK), cni.Plan{}))
+
 	webservice.Route(webservice.PATCH("/clusters/{name}/status").
 		To(h.ResetClusterStatus).
 		Metadata(restfulspec.KeyOpenAPITags, []string{CoreClusterTag}).
@@ -132,3 +132,27 @@ type ClusterUpgrade struct {
 	Offline       bool   `json:"offline"`
 	LocalRegistry string `json:"localRegistry"`
 }
+
+type CNIRender struct {
+	CNI        corev1.CNI        `json:"cni"`
+	Networking corev1.Networking `json:"networking"`
+}
+
+type CNIRenderResult struct {
+	// Values the rendered manifests or helm values of the cni.
+	Values string `json:"values"`
+}
+
+type CNITemplate struct {
+	// Key the key the template is registered under, e.g. cniInfo-cilium/v1/template.
+	Key string `json:"key"`
+	// Template the template text, the override when there is one, otherwise the built-in template.
+	Template string `json:"template"`
+	// Overridden whether Template is a user override.
+	Overridden bool `json:"overridden"`
+}
+
+type ClusterMeshConnect struct {
+	// Peer the cluster to connect to, cilium cluster mesh must be enabled on both clusters.
+	Peer string `json:"peer"`
+}
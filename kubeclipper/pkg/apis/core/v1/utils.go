@@ -37,6 +37,7 @@ import (
 	"github.com/kubeclipper/kubeclipper/pkg/models/cluster"
 	"github.com/kubeclipper/kubeclipper/pkg/query"
 
+	"github.com/kubeclipper/kubeclipper/pkg/clusteroperation"
 	"github.com/kubeclipper/kubeclipper/pkg/component"
 	"github.com/kubeclipper/kubeclipper/pkg/component/utils"
 	"github.com/kubeclipper/kubeclipper/pkg/scheme/common"
@@ -114,12 +115,15 @@ func (h *handler) parseOperationFromCluster(extraMetadata *component.ExtraMetada
 	if err != nil {
 		return nil, err
 	}
+	cSteps = clusteroperation.LabelComponent(cSteps, "cri")
 
 	// kubernetes
 	k8sSteps, err := getK8sSteps(ctx, c, action)
 	if err != nil {
 		return nil, err
 	}
+	// the cni steps carry their component already
+	k8sSteps = clusteroperation.LabelComponent(k8sSteps, "kubernetes")
 
 	carr := make([]v1.Addon, len(c.Addons))
 	copy(carr, c.Addons)
@@ -144,7 +148,9 @@ func (h *handler) parseOperationFromCluster(extraMetadata *component.ExtraMetada
 		steps = append(steps, cSteps...)
 	}
 
-	op.Steps = steps
+	if op.Steps, err = clusteroperation.SortSteps(steps); err != nil {
+		return nil, err
+	}
 	return op, nil
 }
 
@@ -241,6 +247,7 @@ func getRecoveryStep(c *v1.Cluster, bp *v1.BackupPoint, b *v1.Backup, restoreDir
 		BackupFileSize: b.Status.BackupFileSize,
 		BackupFileMD5:  b.Status.BackupFileMD5,
 		FileDir:        f,
+		Cluster:        c,
 	}
 
 	switch bp.StorageType {
@@ -354,6 +361,16 @@ func (h *handler) parseUpdateCertOperation(clu *v1.Cluster, extraMetadata *compo
 	return op, nil
 }
 
+func (h *handler) parseCheckCNIOperation(clu *v1.Cluster, extraMetadata *component.ExtraMetadata) (*v1.Operation, error) {
+	op := &v1.Operation{}
+	steps, err := k8s.CheckCNI(extraMetadata, &clu.CNI, &clu.Networking, utils.UnwrapNodeList(extraMetadata.GetAllNodes()))
+	if err != nil {
+		return nil, err
+	}
+	op.Steps = steps
+	return op, nil
+}
+
 func (h *handler) checkBackupPointInUseByBackup(backups *v1.BackupList, name string) bool {
 	for _, item := range backups.Items {
 		if item.BackupPointName == name {
@@ -420,7 +437,7 @@ func (h *handler) parseAddonStep(ctx context.Context, clu *v1.Cluster, addons []
 		if err != nil {
 			return []v1.Step{}, err
 		}
-		steps = append(steps, s...)
+		steps = append(steps, clusteroperation.LabelComponent(s, comp.Name)...)
 	}
 	clu.ContainerRuntime.InsecureRegistry = registry.List()
 	return steps, nil
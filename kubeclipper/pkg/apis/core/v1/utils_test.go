@@ -381,6 +381,22 @@ func Test_parseRecoverySteps(t *testing.T) {
 	}
 }
 
+func Test_parseCheckCNIOperation(t *testing.T) {
+	metadata := &component.ExtraMetadata{ClusterName: "demo", CNINamespace: "kube-system",
+		Masters: component.NodeList{{ID: "node1", Hostname: "master1"}}}
+	h := &handler{}
+	op, err := h.parseCheckCNIOperation(cniCluster("cilium", &v1.Cilium{}), metadata)
+	if err != nil || len(op.Steps) == 0 {
+		t.Fatalf("parseCheckCNIOperation() = %v, %v", op, err)
+	}
+	// the errors of the cni are reported on their field as on the creation of the cluster
+	_, err = h.parseCheckCNIOperation(cniCluster("cilium", &v1.Cilium{TunnelMode: "gre"}), metadata)
+	want := `cni.cilium.tunnelMode: Invalid value: invalid cilium tunnel mode "gre"`
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("parseCheckCNIOperation() error = %v, want %s", err, want)
+	}
+}
+
 func Test_parseActBackupSteps(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -15,6 +15,10 @@ const (
 	clusterExample = `
 	# upgrade k8s cluster version 
 	cluster upgrade --cluster-name clu-1 --version v1.23.9 --offline
+	# retry the failed operation of the cluster
+	cluster retry --cluster-name clu-1
+	# remove the cached packages the cluster nodes no longer use
+	cluster gc-cache --cluster-name clu-1
 `
 )
 
@@ -37,5 +41,7 @@ func NewCmdCluster(streams options.IOStreams) *cobra.Command {
 		},
 	}
 	cmd.AddCommand(NewCmdClusterUpgrade(streams))
+	cmd.AddCommand(NewCmdClusterRetry(streams))
+	cmd.AddCommand(NewCmdClusterGCCache(streams))
 	return cmd
 }
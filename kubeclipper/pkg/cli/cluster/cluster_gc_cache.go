@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/kubeclipper/kubeclipper/cmd/kcctl/app/options"
+	"github.com/kubeclipper/kubeclipper/pkg/cli/printer"
+	"github.com/kubeclipper/kubeclipper/pkg/cli/utils"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/client/kc"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
+)
+
+const (
+	gcCacheLongDescription = `
+	Remove the packages cached on the nodes of the cluster that are no longer needed.
+
+	The agents keep the most recently used versions of every package, the versions used by the cluster
+	are never removed. The policy of the agents is overridden by --keep-versions and --max-size.
+`
+	clusterGCCacheExample = `
+	# remove the cached packages the cluster nodes no longer use
+	kcctl cluster gc-cache --cluster-name clu-1
+	# keep one version of every package on the node and report the versions to remove without removing them
+	kcctl cluster gc-cache --node node-1 --keep-versions 1 --dry-run
+`
+)
+
+type ClusterGCCacheOpts struct {
+	BaseOptions
+	ClusterName  string
+	Node         string
+	KeepVersions int
+	// MaxSize the cache size in MiB.
+	MaxSize int64
+	DryRun  bool
+}
+
+func NewClusterGCCacheOpts(streams options.IOStreams) *ClusterGCCacheOpts {
+	return &ClusterGCCacheOpts{
+		BaseOptions: BaseOptions{
+			PrintFlags: printer.NewPrintFlags(),
+			CliOpts:    options.NewCliOptions(),
+			IOStreams:  streams,
+		},
+	}
+}
+
+func NewCmdClusterGCCache(streams options.IOStreams) *cobra.Command {
+	c := NewClusterGCCacheOpts(streams)
+	cmd := &cobra.Command{
+		Use:     "gc-cache (--cluster-name | --node) [flags]",
+		Short:   "remove the cached packages the nodes no longer use",
+		Long:    gcCacheLongDescription,
+		Example: clusterGCCacheExample,
+		Run: func(cmd *cobra.Command, args []string) {
+			utils.CheckErr(c.Complete())
+			utils.CheckErr(c.Validates())
+			utils.CheckErr(c.Run())
+		},
+	}
+
+	cmd.Flags().StringVarP(&c.ClusterName, "cluster-name", "c", c.ClusterName, "cluster name, every node of the cluster is collected")
+	cmd.Flags().StringVar(&c.Node, "node", c.Node, "node name")
+	cmd.Flags().IntVar(&c.KeepVersions, "keep-versions", c.KeepVersions, "the most recently used versions kept per package, negative keeps every version")
+	cmd.Flags().Int64Var(&c.MaxSize, "max-size", c.MaxSize, "the cache size in MiB the least recently used versions are removed down to, negative is unlimited")
+	cmd.Flags().BoolVar(&c.DryRun, "dry-run", c.DryRun, "report the versions to remove without removing them")
+
+	return cmd
+}
+
+func (c *ClusterGCCacheOpts) Complete() error {
+	if err := c.CliOpts.Complete(); err != nil {
+		return err
+	}
+	client, err := kc.FromConfig(c.CliOpts.ToRawConfig())
+	if err != nil {
+		return err
+	}
+	c.Client = client
+	return nil
+}
+
+func (c *ClusterGCCacheOpts) Validates() error {
+	if (c.ClusterName == "") == (c.Node == "") {
+		return errors.New("please specify either cluster name or node")
+	}
+	return nil
+}
+
+func (c *ClusterGCCacheOpts) Run() error {
+	nodes := []string{c.Node}
+	if c.ClusterName != "" {
+		clusters, err := c.Client.DescribeCluster(context.TODO(), c.ClusterName)
+		if err != nil {
+			return err
+		}
+		nodes = sets.List(clusters.Items[0].GetAllNodes())
+	}
+	policy := downloader.CacheGCPolicy{KeepVersions: c.KeepVersions, MaxSize: c.MaxSize << 20}
+	var failed []string
+	for _, node := range nodes {
+		result, err := c.Client.CollectNodePackageCache(context.TODO(), node, policy, c.DryRun)
+		if err != nil {
+			_, _ = fmt.Fprintf(c.ErrOut, "node %s: collect the package cache failed: %v\n", node, err)
+			failed = append(failed, node)
+			continue
+		}
+		for _, p := range result.Removed {
+			_, _ = fmt.Fprintf(c.Out, "node %s: removed %s (%d bytes)\n", node, p.Key(), p.Size)
+		}
+		_, _ = fmt.Fprintf(c.Out, "node %s: reclaimed %d bytes, kept %d package versions\n", node, result.ReclaimedBytes, len(result.Kept))
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("collect the package cache of nodes %v failed", failed)
+	}
+	return nil
+}
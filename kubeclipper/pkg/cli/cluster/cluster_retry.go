@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kubeclipper/kubeclipper/cmd/kcctl/app/options"
+	"github.com/kubeclipper/kubeclipper/pkg/cli/printer"
+	"github.com/kubeclipper/kubeclipper/pkg/cli/utils"
+	"github.com/kubeclipper/kubeclipper/pkg/query"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/common"
+	corev1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/client/kc"
+)
+
+const (
+	retryLongDescription = `
+	Retry the latest operation of the cluster after it failed.
+
+	The steps completed by a prior attempt of the operation are skipped unless --force is set.
+`
+	clusterRetryExample = `
+	# retry the failed operation of the cluster
+	kcctl cluster retry --cluster-name clu-1
+	# retry the failed operation of the cluster, running the completed steps again
+	kcctl cluster retry --cluster-name clu-1 --force
+`
+)
+
+type ClusterRetryOpts struct {
+	BaseOptions
+	ClusterName string
+	Force       bool
+}
+
+func NewClusterRetryOpts(streams options.IOStreams) *ClusterRetryOpts {
+	return &ClusterRetryOpts{
+		BaseOptions: BaseOptions{
+			PrintFlags: printer.NewPrintFlags(),
+			CliOpts:    options.NewCliOptions(),
+			IOStreams:  streams,
+		},
+	}
+}
+
+func NewCmdClusterRetry(streams options.IOStreams) *cobra.Command {
+	c := NewClusterRetryOpts(streams)
+	cmd := &cobra.Command{
+		Use:     "retry (--cluster-name) [flags]",
+		Short:   "retry the failed operation of kubernetes cluster",
+		Long:    retryLongDescription,
+		Example: clusterRetryExample,
+		Run: func(cmd *cobra.Command, args []string) {
+			utils.CheckErr(c.Complete())
+			utils.CheckErr(c.Validates())
+			utils.CheckErr(c.Run())
+		},
+	}
+
+	cmd.Flags().StringVarP(&c.ClusterName, "cluster-name", "c", c.ClusterName, "cluster name")
+	cmd.Flags().BoolVar(&c.Force, "force", c.Force, "run the steps completed in a prior attempt again")
+
+	return cmd
+}
+
+func (c *ClusterRetryOpts) Complete() error {
+	if err := c.CliOpts.Complete(); err != nil {
+		return err
+	}
+	client, err := kc.FromConfig(c.CliOpts.ToRawConfig())
+	if err != nil {
+		return err
+	}
+	c.Client = client
+	return nil
+}
+
+func (c *ClusterRetryOpts) Validates() error {
+	if c.ClusterName == "" {
+		return errors.New("please specify cluster name")
+	}
+	return nil
+}
+
+func (c *ClusterRetryOpts) Run() error {
+	q := query.New()
+	q.LabelSelector = fmt.Sprintf("%s=%s", common.LabelClusterName, c.ClusterName)
+	opList, err := c.Client.ListOperation(context.TODO(), kc.Queries(*q))
+	if err != nil {
+		return err
+	}
+	var latest *corev1.Operation
+	for i := range opList.Items {
+		if latest == nil || latest.CreationTimestamp.Before(&opList.Items[i].CreationTimestamp) {
+			latest = &opList.Items[i]
+		}
+	}
+	if latest == nil {
+		return fmt.Errorf("cluster %s has no operation to retry", c.ClusterName)
+	}
+	if latest.Status.Status != corev1.OperationStatusFailed && latest.Status.Status != corev1.OperationStatusTermination {
+		return fmt.Errorf("the latest operation %s of cluster %s is %s, only a failed operation can be retried",
+			latest.Name, c.ClusterName, latest.Status.Status)
+	}
+	if err = c.Client.RetryOperation(context.TODO(), latest.Name, c.Force); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.Out, "retrying operation %s of cluster %s\n", latest.Name, c.ClusterName)
+	return nil
+}
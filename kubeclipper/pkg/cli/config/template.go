@@ -309,6 +309,17 @@ downloader:
   address: {{.StaticServerAddress}}
   tlsCertFile: ""
   tlsPrivateKey: ""
+{{- with .DownloadProxy}}
+{{- if .HTTPProxy}}
+  httpProxy: "{{.HTTPProxy}}"
+{{- end}}
+{{- if .HTTPSProxy}}
+  httpsProxy: "{{.HTTPSProxy}}"
+{{- end}}
+{{- if .NoProxy}}
+  noProxy: "{{.NoProxy}}"
+{{- end}}
+{{- end}}
 log:
   logFile: ""
   logFileMaxSizeMB: 100
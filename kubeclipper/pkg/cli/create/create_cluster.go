@@ -148,6 +148,7 @@ type CreateClusterOptions struct {
 	K8sVersion                string
 	CNI                       string
 	CNIVersion                string
+	SkipCNICompatibility      bool
 	Name                      string
 	createdByIP               bool
 	CertSans                  []string
@@ -217,6 +218,7 @@ func NewCmdCreateCluster(streams options.IOStreams) *cobra.Command {
 	cmd.Flags().StringVar(&o.K8sVersion, "k8s-version", o.K8sVersion, "k8s version")
 	cmd.Flags().StringVar(&o.CNI, "cni", o.CNI, "k8s cni type, calico or others")
 	cmd.Flags().StringVar(&o.CNIVersion, "cni-version", o.CNIVersion, "k8s cni version")
+	cmd.Flags().BoolVar(&o.SkipCNICompatibility, "skip-cni-compatibility-check", o.SkipCNICompatibility, "install the cni version even if it does not support the k8s version")
 	cmd.Flags().StringSliceVar(&o.CertSans, "cert-sans", o.CertSans, "k8s cluster certificate signing ipList or domainList")
 	cmd.Flags().StringVar(&o.CaCertFile, "ca-cert", o.CaCertFile, "k8s external root-ca cert file")
 	cmd.Flags().StringVar(&o.CaKeyFile, "ca-key", o.CaKeyFile, "k8s external root-ca key file")
@@ -478,9 +480,10 @@ func (l *CreateClusterOptions) newCluster() *v1.Cluster {
 		KubeProxy: v1.KubeProxy{},
 		Etcd:      v1.Etcd{},
 		CNI: v1.CNI{
-			LocalRegistry: l.LocalRegistry,
-			Type:          l.CNI,
-			Version:       l.CNIVersion,
+			LocalRegistry:          l.LocalRegistry,
+			Type:                   l.CNI,
+			Version:                l.CNIVersion,
+			SkipCompatibilityCheck: l.SkipCNICompatibility,
 			Calico: &v1.Calico{
 				IPv4AutoDetection: l.IPv4AutoDetection,
 				IPv6AutoDetection: "first-found",
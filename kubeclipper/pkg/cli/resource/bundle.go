@@ -0,0 +1,263 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package resource
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kubeclipper/kubeclipper/pkg/cli/logger"
+	"github.com/kubeclipper/kubeclipper/pkg/cli/utils"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/sshutils"
+)
+
+// cniResourceType the type of the cni packages in metadata.json.
+const cniResourceType = "cni"
+
+const (
+	exportLongDescription = `
+  Export the air-gap bundle of a cni version
+
+  The bundle is a single .tar.gz file with the chart and the images of the cni version, the images of every
+  optional component are included. The chart is downloaded from the package server, the images are pulled
+  by docker for the architecture. The manifest.json of the bundle carries the sha256 digests of the files.`
+	resourceExportExample = `
+  # Export the bundle of cilium 1.14.4 for amd64 nodes to the current directory
+  kcctl resource export --name cilium --version 1.14.4 --arch amd64
+
+  Please read 'kcctl resource export -h' get more resource export flags`
+	importLongDescription = `
+  Import the air-gap bundle of a cni version
+
+  The bundle is validated before it is pushed to the package servers: it must be the bundle of the version
+  of the cni, and every file must match its sha256 digest. The offline installs of the version use it then.`
+	resourceImportExample = `
+  # Import the bundle of cilium 1.14.4
+  kcctl resource import --bundle cilium-1.14.4-amd64-bundle.tar.gz --name cilium --version 1.14.4
+
+  Please read 'kcctl resource import -h' get more resource import flags`
+)
+
+func NewCmdResourceExport(o *ResourceOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "export (--name <cni-type>) (--version <cni-version>) [flags]",
+		DisableFlagsInUseLine: true,
+		Short:                 "export the air-gap bundle of a cni version",
+		Long:                  exportLongDescription,
+		Example:               resourceExportExample,
+		Args:                  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			utils.CheckErr(o.ValidateArgsBundle(cmd))
+			utils.CheckErr(o.ResourceExport(context.TODO()))
+		},
+	}
+
+	cmd.Flags().StringVar(&o.Name, "name", o.Name, "cni type, e.g. cilium.")
+	cmd.Flags().StringVar(&o.Version, "version", o.Version, "cni version.")
+	cmd.Flags().StringVar(&o.Arch, "arch", o.Arch, "the architecture of the nodes.")
+	cmd.Flags().StringVar(&o.Source, "source", downloader.CloudStaticServer, "the package server the chart is downloaded from.")
+	cmd.Flags().StringVarP(&o.Output, "output", "o", ".", "the directory the bundle is written to.")
+
+	utils.CheckErr(cmd.MarkFlagRequired("name"))
+	utils.CheckErr(cmd.MarkFlagRequired("version"))
+	return cmd
+}
+
+func NewCmdResourceImport(o *ResourceOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "import (--bundle <file name>) (--name <cni-type>) (--version <cni-version>) [flags]",
+		DisableFlagsInUseLine: true,
+		Short:                 "import the air-gap bundle of a cni version",
+		Long:                  importLongDescription,
+		Example:               resourceImportExample,
+		Args:                  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			utils.CheckErr(o.Complete())
+			utils.CheckErr(o.ValidateArgsBundle(cmd))
+			if o.Pkg == "" {
+				utils.CheckErr(utils.UsageErrorf(cmd, "the bundle must be specified"))
+			}
+			if !o.preCheck() {
+				return
+			}
+			utils.CheckErr(o.ResourceImport())
+		},
+	}
+
+	o.cliOpts.AddFlags(cmd.Flags())
+	cmd.Flags().StringVar(&o.Pkg, "bundle", o.Pkg, "the bundle exported by kcctl resource export.")
+	cmd.Flags().StringVar(&o.Name, "name", o.Name, "cni type the bundle must be of, e.g. cilium.")
+	cmd.Flags().StringVar(&o.Version, "version", o.Version, "cni version the bundle must be of.")
+
+	utils.CheckErr(cmd.MarkFlagRequired("bundle"))
+	utils.CheckErr(cmd.MarkFlagRequired("name"))
+	utils.CheckErr(cmd.MarkFlagRequired("version"))
+	return cmd
+}
+
+func (o *ResourceOptions) ValidateArgsBundle(cmd *cobra.Command) error {
+	if o.Name == "" {
+		return utils.UsageErrorf(cmd, "the cni type must be specified")
+	}
+	if o.Version == "" {
+		return utils.UsageErrorf(cmd, "the cni version must be specified")
+	}
+	if _, err := cni.Load(o.Name); err != nil {
+		return utils.UsageErrorf(cmd, "cni %s: %v", o.Name, err)
+	}
+	return nil
+}
+
+// ResourceExport writes the bundle of the cni version into o.Output.
+func (o *ResourceOptions) ResourceExport(ctx context.Context) error {
+	images, err := cni.BundleImages(o.Name, o.Version)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "kc-bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	files := make(map[string]string)
+	chart := filepath.Join(dir, downloader.ChartFilename)
+	ok, err := downloadFile(ctx, fmt.Sprintf("%s/%s/%s/%s/%s", strings.TrimSuffix(o.Source, "/"), o.Name, o.Version, o.Arch, downloader.ChartFilename), chart)
+	if err != nil {
+		return err
+	}
+	if ok {
+		files[downloader.ChartFilename] = chart
+	} else {
+		// the cnis installed from manifests have no chart
+		logger.Infof("%s-%s has no chart on %s, the bundle only has the images", o.Name, o.Version, o.Source)
+	}
+	if files[downloader.ImageFilename], err = o.saveImages(images, dir); err != nil {
+		return err
+	}
+
+	bundle := filepath.Join(o.Output, downloader.BundleName(o.Name, o.Version, o.Arch))
+	f, err := os.Create(bundle)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	m := &downloader.BundleManifest{Type: o.Name, Version: o.Version, Arch: o.Arch, Images: images}
+	if err = downloader.WriteBundle(f, m, files); err != nil {
+		_ = os.Remove(bundle)
+		return err
+	}
+	logger.Infof("the bundle of %s-%s for %s is exported to %s", o.Name, o.Version, o.Arch, bundle)
+	return nil
+}
+
+// saveImages pulls the images for o.Arch by docker and saves them into images.tar.gz of dir.
+func (o *ResourceOptions) saveImages(images []string, dir string) (string, error) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return "", fmt.Errorf("docker is required to pull the images of the bundle: %v", err)
+	}
+	for _, image := range images {
+		logger.Infof("pull %s for %s", image, o.Arch)
+		ret, err := sshutils.RunCmdAsSSH(fmt.Sprintf("docker pull --platform linux/%s %s", o.Arch, image))
+		if err != nil {
+			return "", err
+		}
+		if err = ret.Error(); err != nil {
+			return "", fmt.Errorf("pull %s failed: %v", image, err)
+		}
+	}
+	file := filepath.Join(dir, downloader.ImageFilename)
+	ret, err := sshutils.RunCmdAsSSH(fmt.Sprintf("docker save -o %[1]s %[2]s && gzip %[1]s", strings.TrimSuffix(file, ".gz"), strings.Join(images, " ")))
+	if err != nil {
+		return "", err
+	}
+	if err = ret.Error(); err != nil {
+		return "", fmt.Errorf("save the images failed: %v", err)
+	}
+	return file, nil
+}
+
+// ResourceImport validates the bundle o.Pkg and pushes its package to the package servers.
+func (o *ResourceOptions) ResourceImport() error {
+	f, err := os.Open(o.Pkg)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dir, err := os.MkdirTemp("", "kc-bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	m, err := downloader.ReadBundle(f, dir, o.Name, o.Version)
+	if err != nil {
+		return fmt.Errorf("invalid bundle %s: %w", o.Pkg, err)
+	}
+	logger.Infof("the bundle of %s-%s for %s is valid, push it to the package servers", m.Type, m.Version, m.Arch)
+
+	// the package in the layout the package servers unpack, name/version/arch/
+	pkg := filepath.Join(dir, fmt.Sprintf("%s-%s-%s.tar.gz", m.Type, m.Version, m.Arch))
+	ret, err := sshutils.RunCmdAsSSH(fmt.Sprintf("tar -zcf %s -C %s %s", pkg, dir, m.Type))
+	if err != nil {
+		return err
+	}
+	if err = ret.Error(); err != nil {
+		return err
+	}
+	o.Pkg = pkg
+	return o.pushPackage(cniResourceType, m.Type, m.Version, m.Arch)
+}
+
+// downloadFile downloads url into file, ok is false when the server does not have it.
+func downloadFile(ctx context.Context, url, file string) (ok bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("download %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("download %s failed: %s", url, resp.Status)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err = io.Copy(f, resp.Body); err != nil {
+		return false, fmt.Errorf("download %s failed: %v", url, err)
+	}
+	return true, nil
+}
@@ -45,6 +45,7 @@ import (
 	"github.com/kubeclipper/kubeclipper/pkg/cli/printer"
 	"github.com/kubeclipper/kubeclipper/pkg/cli/utils"
 	"github.com/kubeclipper/kubeclipper/pkg/simple/client/kc"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
 	"github.com/kubeclipper/kubeclipper/pkg/utils/sshutils"
 )
 
@@ -52,7 +53,8 @@ const (
 	longDescription = `
   Online resource operation.
 
-  Currently, You can push, delete, and list offline resource packs.`
+  Currently, You can push, delete, and list offline resource packs,
+  and export and import the air-gap bundles of cni versions.`
 	resourceExample = `
   # List offline resource packs
   kcctl resource list
@@ -63,6 +65,10 @@ const (
   # Delete offline resource packs
   kcctl resource delete --name k8s --version v1.23.6 --arch amd64
 
+  # Export the air-gap bundle of a cni version and import it
+  kcctl resource export --name cilium --version 1.14.4 --arch amd64
+  kcctl resource import --bundle cilium-1.14.4-amd64-bundle.tar.gz --name cilium --version 1.14.4
+
 
   Please read 'kcctl resource -h' get more resource flags.`
 	listLongDescription = `
@@ -87,7 +93,10 @@ const (
 	name/version/
 	name/version/arch/
 	name/version/arch/images.tar.gz
-	name/version/arch/manifest.json`
+	name/version/arch/manifest.json
+
+  The files with a sha256 digest in manifest.json are verified after the push,
+  a package failing the verification is removed.`
 	resourcePushExample = `
   # Push k8s offline resource k8s
   kcctl resource push --pkg /root/k8s-v1.23.6-amd64.tar.gz --type k8s  
@@ -128,6 +137,11 @@ type ResourceOptions struct {
 	Arch    string
 
 	Pkg string
+
+	// Source the package server the bundles are exported from.
+	Source string
+	// Output the directory the bundles are exported to.
+	Output string
 }
 
 func NewResourceOptions(streams options.IOStreams) *ResourceOptions {
@@ -158,6 +172,8 @@ func NewCmdResource(streams options.IOStreams) *cobra.Command {
 	cmd.AddCommand(NewCmdResourceList(o))
 	cmd.AddCommand(NewCmdResourcePush(o))
 	cmd.AddCommand(NewCmdResourceDelete(o))
+	cmd.AddCommand(NewCmdResourceExport(o))
+	cmd.AddCommand(NewCmdResourceImport(o))
 
 	return cmd
 }
@@ -406,6 +422,11 @@ func (o *ResourceOptions) ResourcePush() error {
 	if err != nil {
 		return err
 	}
+	return o.pushPackage(o.Type, name, version, arch)
+}
+
+// pushPackage pushes the package o.Pkg of version of name for arch to the package servers.
+func (o *ResourceOptions) pushPackage(typ, name, version, arch string) error {
 	if _, ok := httputil.IsURL(o.Pkg); !ok {
 		ls, err := sshutils.RunCmdAsSSH(fmt.Sprintf("ls -l %s", o.Pkg))
 		if err != nil {
@@ -431,7 +452,7 @@ func (o *ResourceOptions) ResourcePush() error {
 			return err
 		}
 
-		metas.AddonsAppendOnly(o.Type, name, version, arch)
+		metas.AddonsAppendOnly(typ, name, version, arch)
 
 		metaBytes, err := json.MarshalIndent(&metas.PackageMetadata, "", "  ")
 		if err != nil {
@@ -483,10 +504,14 @@ func (o *ResourceOptions) ResourcePush() error {
 			logger.Errorf("node(%s) push resource failed: %s", node, err.Error())
 			return err
 		}
+		if err = o.verifyPackage(node, name, version, arch); err != nil {
+			logger.Errorf("node(%s) push resource failed: %s", node, err.Error())
+			return err
+		}
 	}
 
 	// send metadata.json
-	err = utils.SendPackageV2(o.deployConfig.SSHConfig, "metadata.json", o.deployConfig.ServerIPs, o.deployConfig.StaticServerPath, nil, nil)
+	err := utils.SendPackageV2(o.deployConfig.SSHConfig, "metadata.json", o.deployConfig.ServerIPs, o.deployConfig.StaticServerPath, nil, nil)
 	if err != nil {
 		return err
 	}
@@ -496,6 +521,42 @@ func (o *ResourceOptions) ResourcePush() error {
 	return nil
 }
 
+// verifyPackage checks the decompressed files against the sha256 digests of the package manifest.json,
+// the files of a package failing the check are removed so that the downloader never serves them.
+func (o *ResourceOptions) verifyPackage(node, name, version, arch string) error {
+	dir := filepath.Join(o.deployConfig.StaticServerPath, name, version, arch)
+	manifestFile := filepath.Join(dir, downloader.ManifestFilename)
+	ret, err := sshutils.SSHCmdWithSudo(o.deployConfig.SSHConfig, node, fmt.Sprintf("if [ -f %[1]s ]; then cat %[1]s; fi", manifestFile))
+	if err != nil {
+		return err
+	}
+	if err = ret.Error(); err != nil {
+		return err
+	}
+	if strings.TrimSpace(ret.Stdout) == "" {
+		logger.Infof("%s has no %s, skip the checksum verification", filepath.Base(o.Pkg), downloader.ManifestFilename)
+		return nil
+	}
+	var manifest []downloader.ManifestElement
+	if err = json.Unmarshal([]byte(ret.Stdout), &manifest); err != nil {
+		return fmt.Errorf("parse %s of %s failed: %v", downloader.ManifestFilename, filepath.Base(o.Pkg), err)
+	}
+	checklist := downloader.SHA256Checklist(manifest)
+	if checklist == "" {
+		// packages built before the sha256 digests were added
+		return nil
+	}
+	ret, err = sshutils.SSHCmdWithSudo(o.deployConfig.SSHConfig, node, fmt.Sprintf("cd %s && sha256sum -c --quiet <<'EOF'\n%sEOF", dir, checklist))
+	if err != nil {
+		return err
+	}
+	if ret.ExitCode != 0 {
+		_, _ = sshutils.SSHCmdWithSudo(o.deployConfig.SSHConfig, node, fmt.Sprintf("rm -rf %s", dir))
+		return fmt.Errorf("checksum mismatch for %s: %s", filepath.Base(o.Pkg), strings.TrimSpace(ret.Stdout+"\n"+ret.Stderr))
+	}
+	return nil
+}
+
 func (o *ResourceOptions) ResourceDelete() error {
 	for _, node := range o.deployConfig.ServerIPs {
 		metas, err := o.ReadMetadata(node)
@@ -59,6 +59,12 @@ func BuildOperationAdapter(
 	case v1.OperationRecoverCluster:
 	case v1.OperationUpdateCertification:
 	case v1.OperationUpdateAPIServerCertification:
+	case v1.OperationUpgradeCNI:
+	case v1.OperationMigrateCNI:
+	case v1.OperationCheckCNI:
+	case v1.OperationConnectClusterMesh:
+	case v1.OperationCheckCNIConnectivity:
+	case v1.OperationRunCNIOperation:
 		// TODO support all operations
 	default:
 		return &v1.Operation{}, fmt.Errorf("unsupported %s operation type", pendingOp.OperationType)
@@ -70,7 +76,7 @@ func BuildOperationAdapter(
 // IsRetry whether the operation supports retry
 func IsRetry(opType string) bool {
 	switch opType {
-	case v1.OperationBackupCluster, v1.OperationRecoverCluster, v1.OperationUpgradeCluster:
+	case v1.OperationBackupCluster, v1.OperationRecoverCluster, v1.OperationUpgradeCluster, v1.OperationMigrateCNI:
 		return false
 	}
 	return true
@@ -176,6 +182,20 @@ func Retry(op *v1.Operation) (context.Context, *v1.Operation, []v1.Step, error)
 	return ctx, op, continueSteps, nil
 }
 
+// ApprovedSteps the steps following the diff step of the operation pending approval, they run once the diff is
+// approved.
+func ApprovedSteps(op *v1.Operation) ([]v1.Step, error) {
+	if op.Status.Status != v1.OperationStatusPendingApproval {
+		return nil, fmt.Errorf("operation %s is %s, only the operations pending approval can be approved", op.Name, op.Status.Status)
+	}
+	for i := range op.Steps {
+		if op.Steps[i].Diff {
+			return op.Steps[i+1:], nil
+		}
+	}
+	return nil, fmt.Errorf("operation %s has no diff step", op.Name)
+}
+
 // SupportConcurrent whether concurrency is supported
 func SupportConcurrent(opType string) bool {
 	switch opType {
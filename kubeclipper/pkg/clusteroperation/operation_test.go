@@ -0,0 +1,53 @@
+package clusteroperation
+
+import (
+	"testing"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func TestApprovedSteps(t *testing.T) {
+	steps := []v1.Step{{Name: "renderCiliumValues"}, {Name: "diffCiliumRelease", Diff: true}, {Name: "upgradeCiliumRelease"}, {Name: "markCiliumRelease"}}
+	tests := []struct {
+		name    string
+		op      *v1.Operation
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "pending approval",
+			op:   &v1.Operation{Steps: steps, Status: v1.OperationStatus{Status: v1.OperationStatusPendingApproval}},
+			want: []string{"upgradeCiliumRelease", "markCiliumRelease"},
+		},
+		{
+			name:    "not pending approval",
+			op:      &v1.Operation{Steps: steps, Status: v1.OperationStatus{Status: v1.OperationStatusFailed}},
+			wantErr: true,
+		},
+		{
+			name:    "no diff step",
+			op:      &v1.Operation{Steps: steps[2:], Status: v1.OperationStatus{Status: v1.OperationStatusPendingApproval}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApprovedSteps(tt.op)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApprovedSteps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, step := range got {
+				names = append(names, step.Name)
+			}
+			if len(names) != len(tt.want) {
+				t.Fatalf("ApprovedSteps() = %v, want %v", names, tt.want)
+			}
+			for i := range names {
+				if names[i] != tt.want[i] {
+					t.Errorf("ApprovedSteps() = %v, want %v", names, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package clusteroperation
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+// LabelComponent sets the component of the steps which do not belong to one yet.
+func LabelComponent(steps []v1.Step, component string) []v1.Step {
+	for i := range steps {
+		if steps[i].Component == "" {
+			steps[i].Component = component
+		}
+	}
+	return steps
+}
+
+// SortSteps orders steps so that every step runs after the steps it depends on, see v1.Step DependsOn. The order
+// of the steps is kept otherwise: the first step whose dependencies ran is always the next one, so the steps
+// without dependencies keep the order they were assembled in. The dependencies naming no step are ignored, the
+// steps they name are optional. A dependency cycle is an error.
+func SortSteps(steps []v1.Step) ([]v1.Step, error) {
+	deps := Dependencies(steps)
+	sorted := make([]v1.Step, 0, len(steps))
+	done := make([]bool, len(steps))
+	for len(sorted) < len(steps) {
+		next := -1
+		for i := range steps {
+			if !done[i] && ready(deps[i], done) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, fmt.Errorf("the steps have a dependency cycle: %s", strings.Join(cycle(steps, deps, done), " -> "))
+		}
+		done[next] = true
+		sorted = append(sorted, steps[next])
+	}
+	return sorted, nil
+}
+
+// Dependencies the indexes of the steps each of steps depends on, see v1.Step DependsOn.
+func Dependencies(steps []v1.Step) [][]int {
+	byName := make(map[string][]int, len(steps))
+	for i, step := range steps {
+		key := step.Component + "/" + step.Name
+		byName[key] = append(byName[key], i)
+	}
+	deps := make([][]int, len(steps))
+	for i, step := range steps {
+		for _, name := range step.DependsOn {
+			key := name
+			if !strings.Contains(name, "/") {
+				key = step.Component + "/" + name
+			}
+			for _, j := range byName[key] {
+				if j != i {
+					deps[i] = append(deps[i], j)
+				}
+			}
+		}
+	}
+	return deps
+}
+
+func ready(deps []int, done []bool) bool {
+	for _, j := range deps {
+		if !done[j] {
+			return false
+		}
+	}
+	return true
+}
+
+// cycle walks the dependencies of the first pending step until a step repeats, the pending steps all wait on
+// another pending step so the walk always finds a cycle.
+func cycle(steps []v1.Step, deps [][]int, done []bool) []string {
+	start := 0
+	for done[start] {
+		start++
+	}
+	seen := make(map[int]int)
+	var path []int
+	for i := start; ; {
+		if at, ok := seen[i]; ok {
+			path = append(path[at:], i)
+			break
+		}
+		seen[i] = len(path)
+		path = append(path, i)
+		for _, j := range deps[i] {
+			if !done[j] {
+				i = j
+				break
+			}
+		}
+	}
+	names := make([]string, 0, len(path))
+	for _, i := range path {
+		names = append(names, steps[i].Component+"/"+steps[i].Name)
+	}
+	return names
+}
@@ -0,0 +1,101 @@
+package clusteroperation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func step(component, name string, dependsOn ...string) v1.Step {
+	return v1.Step{Component: component, Name: name, DependsOn: dependsOn}
+}
+
+func names(steps []v1.Step) []string {
+	var names []string
+	for _, s := range steps {
+		names = append(names, s.Component+"/"+s.Name)
+	}
+	return names
+}
+
+func TestSortSteps(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []v1.Step
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "independent steps keep their order",
+			steps: []v1.Step{step("cri", "installContainerd"), step("kubernetes", "kubeadmInit"), step("cni", "loadImage"), step("cni", "loadChart")},
+			want:  []string{"cri/installContainerd", "kubernetes/kubeadmInit", "cni/loadImage", "cni/loadChart"},
+		},
+		{
+			name: "satisfied dependencies keep the order",
+			steps: []v1.Step{step("cni", "loadImage"), step("cni", "loadChart"), step("cni", "renderYaml"),
+				step("cni", "installCiliumRelease", "loadChart", "renderYaml")},
+			want: []string{"cni/loadImage", "cni/loadChart", "cni/renderYaml", "cni/installCiliumRelease"},
+		},
+		{
+			name: "a step waits on a later dependency",
+			steps: []v1.Step{step("cni", "installCiliumRelease", "renderYaml"), step("cni", "loadImage"), step("cni", "renderYaml"),
+				step("cni", "checkReady")},
+			want: []string{"cni/loadImage", "cni/renderYaml", "cni/installCiliumRelease", "cni/checkReady"},
+		},
+		{
+			name:  "dependency of another component",
+			steps: []v1.Step{step("nfs", "installNFS", "cni/checkReady"), step("cni", "checkReady"), step("nfs", "checkNFS")},
+			want:  []string{"cni/checkReady", "nfs/installNFS", "nfs/checkNFS"},
+		},
+		{
+			name:  "the same name of another component is not a dependency",
+			steps: []v1.Step{step("nfs", "checkReady", "renderYaml"), step("cni", "renderYaml"), step("nfs", "renderYaml")},
+			want:  []string{"cni/renderYaml", "nfs/renderYaml", "nfs/checkReady"},
+		},
+		{
+			name:  "missing dependency is ignored",
+			steps: []v1.Step{step("cni", "installCiliumRelease", "verifyRegistryImages"), step("cni", "checkReady")},
+			want:  []string{"cni/installCiliumRelease", "cni/checkReady"},
+		},
+		{
+			name:    "cycle",
+			steps:   []v1.Step{step("cni", "loadImage"), step("cni", "a", "b"), step("cni", "b", "c"), step("cni", "c", "a")},
+			wantErr: "dependency cycle: cni/a -> cni/b -> cni/c -> cni/a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SortSteps(tt.steps)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("SortSteps() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SortSteps() error = %v", err)
+			}
+			if !reflect.DeepEqual(names(got), tt.want) {
+				t.Errorf("SortSteps() = %v, want %v", names(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	steps := []v1.Step{step("cni", "installHelm"), step("cni", "loadChart", "installHelm"), step("nfs", "installNFS", "cni/loadChart", "loadChart"),
+		step("cni", "installCiliumRelease", "loadChart", "verifyRegistryImages")}
+	want := [][]int{nil, {0}, {1}, {1}}
+	if got := Dependencies(steps); !reflect.DeepEqual(got, want) {
+		t.Errorf("Dependencies() = %v, want %v", got, want)
+	}
+}
+
+func TestLabelComponent(t *testing.T) {
+	steps := LabelComponent([]v1.Step{{Name: "kubeadmInit"}, {Name: "installCiliumRelease", Component: "cni"}}, "kubernetes")
+	if got := names(steps); !reflect.DeepEqual(got, []string{"kubernetes/kubeadmInit", "cni/installCiliumRelease"}) {
+		t.Errorf("LabelComponent() = %v", got)
+	}
+}
@@ -3,8 +3,11 @@ package common
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -20,6 +23,9 @@ import (
 
 const DefaultHelmChartRepo = "kubeclipper"
 
+// OCIScheme the scheme of the chart sources pulled from OCI registries.
+const OCIScheme = "oci://"
+
 const (
 	chartName  = "chart"
 	AgentChart = "AgentChart"
@@ -35,6 +41,42 @@ type Chart struct {
 	PkgName string `json:"pkgName"`
 	Version string `json:"version"`
 	Offline bool   `json:"offline"`
+	// Source the OCI repository the chart is pulled from, the pre-staged chart of the package server
+	// is used when it is empty or the chart is offline.
+	Source string `json:"source,omitempty"`
+}
+
+// IsOCI whether the chart is pulled from an OCI repository instead of the package server.
+func (i *Chart) IsOCI() bool {
+	return !i.Offline && strings.HasPrefix(i.Source, OCIScheme)
+}
+
+// pullStep pulls the chart by helm to the path the downloader stores it, the steps using the chart do not change.
+// The registry credentials are the ones helm logged in with, or the docker ones used by the image pulls.
+func (i *Chart) pullStep(nodes []v1.StepNode) v1.Step {
+	dst := filepath.Join(downloader.BaseDstDir, "."+i.PkgName, i.Version)
+	script := fmt.Sprintf(`cfg=""
+if [ ! -f $HOME/.config/helm/registry/config.json ] && [ -f $HOME/.docker/config.json ]; then cfg="--registry-config $HOME/.docker/config.json"; fi
+tmp=$(mktemp -d)
+mkdir -p %[1]s && %[6]s pull %[2]s/%[3]s --version %[4]s --destination $tmp $cfg && mv $tmp/%[3]s-*.tgz %[1]s/%[5]s
+rc=$?
+rm -rf $tmp
+exit $rc`, dst, strings.TrimSuffix(i.Source, "/"), i.PkgName, i.Version, downloader.ChartFilename, HelmBin)
+	return v1.Step{
+		ID:         strutil.GetUUID(),
+		Name:       fmt.Sprintf("%s-chartLoad", i.PkgName),
+		Timeout:    metav1.Duration{Duration: 3 * time.Minute},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c", script},
+			},
+		},
+	}
 }
 
 func (i *Chart) Install(ctx context.Context, opts component.Options) ([]byte, error) {
@@ -43,12 +85,17 @@ func (i *Chart) Install(ctx context.Context, opts component.Options) ([]byte, er
 		return nil, err
 	}
 
-	if _, err = instance.DownloadCharts(); err != nil {
+	file, err := instance.DownloadCharts()
+	if err != nil {
+		if errors.Is(err, downloader.ErrChecksumMismatch) {
+			// the digests are in the agent log, the chart is truncated or tampered
+			return nil, fmt.Errorf("checksum mismatch for %s-%s.tgz", i.PkgName, i.Version)
+		}
 		return nil, fmt.Errorf("download %s-%s chart packages failed: %v", i.PkgName, i.Version, err)
 	}
 
 	logger.Infof("%s-%s chart packages offline install successfully", i.PkgName, i.Version)
-	return nil, err
+	return json.Marshal(&downloader.Artifact{Files: []string{file}, Source: instance.Source()})
 }
 
 func (i *Chart) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
@@ -68,12 +115,21 @@ func (i *Chart) NewInstance() component.ObjectMeta {
 	return &Chart{}
 }
 
+// InstallStepsV2 installs helm and loads the chart on nodes, the helm commands of the chart run on them.
 func (i *Chart) InstallStepsV2(nodes []v1.StepNode) ([]v1.Step, error) {
+	helm, err := GetInstallHelmStep(nodes, i.Offline)
+	if err != nil {
+		return nil, err
+	}
+	if i.IsOCI() {
+		return []v1.Step{helm, i.pullStep(nodes)}, nil
+	}
 	customCommand, err := json.Marshal(i)
 	if err != nil {
 		return nil, err
 	}
 	return []v1.Step{
+		helm,
 		{
 			ID:         strutil.GetUUID(),
 			Name:       fmt.Sprintf("%s-chartLoad", i.PkgName),
@@ -94,11 +150,19 @@ func (i *Chart) InstallStepsV2(nodes []v1.StepNode) ([]v1.Step, error) {
 }
 
 func (i *Chart) InstallSteps(nodeList component.NodeList) ([]v1.Step, error) {
+	helm, err := GetInstallHelmStep(utils.UnwrapNodeList(nodeList), i.Offline)
+	if err != nil {
+		return nil, err
+	}
+	if i.IsOCI() {
+		return []v1.Step{helm, i.pullStep(utils.UnwrapNodeList(nodeList))}, nil
+	}
 	customCommand, err := json.Marshal(i)
 	if err != nil {
 		return nil, err
 	}
 	return []v1.Step{
+		helm,
 		{
 			ID:         strutil.GetUUID(),
 			Name:       fmt.Sprintf("%s-chartLoad", i.PkgName),
@@ -130,15 +194,15 @@ func GetAddHelmRepoStep(nodes []v1.StepNode, repo string) v1.Step {
 		Commands: []v1.Command{
 			{
 				Type:         v1.CommandShell,
-				ShellCommand: []string{"bin/sh", "-c", fmt.Sprintf("helm repo remove %s || true", DefaultHelmChartRepo)}, // forward action, ignore errors
+				ShellCommand: []string{"bin/sh", "-c", fmt.Sprintf("%s repo remove %s || true", HelmBin, DefaultHelmChartRepo)}, // forward action, ignore errors
 			},
 			{
 				Type:         v1.CommandShell,
-				ShellCommand: []string{"/bin/sh", "-c", fmt.Sprintf("helm repo add %s %s", DefaultHelmChartRepo, repo)},
+				ShellCommand: []string{"/bin/sh", "-c", fmt.Sprintf("%s repo add %s %s", HelmBin, DefaultHelmChartRepo, repo)},
 			},
 			{
 				Type:         v1.CommandShell,
-				ShellCommand: []string{"bin/sh", "-c", fmt.Sprintf("helm repo update %s", DefaultHelmChartRepo)},
+				ShellCommand: []string{"bin/sh", "-c", fmt.Sprintf("%s repo update %s", HelmBin, DefaultHelmChartRepo)},
 			},
 		},
 	}
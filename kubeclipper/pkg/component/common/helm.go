@@ -0,0 +1,153 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/logger"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/cmdutil"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// HelmBin the helm binary owned by kubeclipper, the generated helm commands run it rather than the helm of
+	// the PATH so that they neither depend on the OS image nor break on a helm the user installed.
+	HelmBin = "/usr/local/bin/kc-helm"
+
+	helmName  = "helm"
+	AgentHelm = "AgentHelm"
+	// helmVersionDefault the helm release shipped by the package server.
+	helmVersionDefault = "v3.14.4"
+	// helmMinVersion the oldest helm able to install the charts, it pulls the OCI charts without the
+	// experimental flag.
+	helmMinVersion = "v3.8.0"
+	// helmFilename the package of helm, the tarball of the helm binary as released upstream.
+	helmFilename = "helm.tar.gz"
+)
+
+// helmArchs the architectures the package server ships helm for.
+var helmArchs = []string{"amd64", "arm64"}
+
+func init() {
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat, helmName, version, AgentHelm), &HelmBinary{}); err != nil {
+		panic(err)
+	}
+}
+
+// HelmBinary installs helm of Version to HelmBin on the node unless HelmBin is already at least MinVersion,
+// or removes it on uninstall.
+type HelmBinary struct {
+	Version    string `json:"version"`
+	MinVersion string `json:"minVersion"`
+	Offline    bool   `json:"offline"`
+}
+
+func (h *HelmBinary) NewInstance() component.ObjectMeta {
+	return &HelmBinary{}
+}
+
+func (h *HelmBinary) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	if ec, err := cmdutil.RunCmdWithContext(ctx, opts.DryRun, HelmBin, "version", "--short"); err == nil {
+		ok, err := helmVersionSatisfies(ec.StdOut(), h.MinVersion)
+		if err != nil {
+			logger.Warnf("detect the version of %s: %v, reinstall helm %s", HelmBin, err, h.Version)
+		} else if ok {
+			logger.Infof("%s %s is at least %s, skip installing helm", HelmBin, strings.TrimSpace(ec.StdOut()), h.MinVersion)
+			return nil, nil
+		}
+	}
+	member, err := helmTarballMember(runtime.GOARCH)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := downloader.NewInstance(ctx, helmName, h.Version, runtime.GOARCH, !h.Offline, opts.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	files, err := instance.DownloadCustomImages(helmFilename)
+	if err != nil {
+		return nil, fmt.Errorf("download helm %s failed: %v", h.Version, err)
+	}
+	dir := filepath.Dir(files[0])
+	if _, err = cmdutil.RunCmdWithContext(ctx, opts.DryRun, "tar", "-zxf", files[0], "-C", dir, member); err != nil {
+		return nil, err
+	}
+	_, err = cmdutil.RunCmdWithContext(ctx, opts.DryRun, "install", "-m", "0755", filepath.Join(dir, member), HelmBin)
+	return nil, err
+}
+
+func (h *HelmBinary) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	_, err := cmdutil.RunCmdWithContext(ctx, opts.DryRun, "rm", "-f", HelmBin)
+	return nil, err
+}
+
+// helmTarballMember the path of the helm binary in the upstream tarball of arch.
+func helmTarballMember(arch string) (string, error) {
+	for _, a := range helmArchs {
+		if a == arch {
+			return fmt.Sprintf("linux-%s/helm", arch), nil
+		}
+	}
+	return "", fmt.Errorf("helm is not shipped for the architecture %s, supported architectures: %v", arch, helmArchs)
+}
+
+// helmVersionSatisfies parses the output of helm version --short, e.g. v3.14.4+g81c902a, and reports whether
+// the version is at least min.
+func helmVersionSatisfies(output, min string) (bool, error) {
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return false, fmt.Errorf("empty helm version")
+	}
+	v, err := utilversion.ParseSemantic(fields[0])
+	if err != nil {
+		return false, fmt.Errorf("invalid helm version %q: %w", fields[0], err)
+	}
+	return v.AtLeast(utilversion.MustParseSemantic(min)), nil
+}
+
+// GetInstallHelmStep installs the pinned helm to HelmBin on nodes before the charts are used,
+// the step keeps a helm of at least helmMinVersion in place.
+func GetInstallHelmStep(nodes []v1.StepNode, offline bool) (v1.Step, error) {
+	return helmStep("installHelm", v1.ActionInstall, offline, nodes)
+}
+
+// GetRemoveHelmStep removes HelmBin from nodes once the last helm release was uninstalled.
+func GetRemoveHelmStep(nodes []v1.StepNode) (v1.Step, error) {
+	step, err := helmStep("removeHelm", v1.ActionUninstall, false, nodes)
+	step.ErrIgnore = true
+	return step, err
+}
+
+func helmStep(name string, action v1.StepAction, offline bool, nodes []v1.StepNode) (v1.Step, error) {
+	custom, err := json.Marshal(&HelmBinary{Version: helmVersionDefault, MinVersion: helmMinVersion, Offline: offline})
+	if err != nil {
+		return v1.Step{}, err
+	}
+	return v1.Step{
+		ID:         strutil.GetUUID(),
+		Name:       name,
+		Timeout:    metav1.Duration{Duration: 3 * time.Minute},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     action,
+		Commands: []v1.Command{
+			{
+				Type:          v1.CommandCustom,
+				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, helmName, version, AgentHelm),
+				CustomCommand: custom,
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"testing"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func TestHelmVersionSatisfies(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    bool
+		wantErr bool
+	}{
+		{name: "newer", output: "v3.14.4+g81c902a\n", want: true},
+		{name: "minimum", output: "v3.8.0+gd141386", want: true},
+		{name: "older", output: "v3.7.2+g663a896", want: false},
+		{name: "helm 2", output: "Client: v2.17.0+ga690bad", wantErr: true},
+		{name: "empty", output: "", wantErr: true},
+		{name: "garbage", output: "command not found", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := helmVersionSatisfies(tt.output, helmMinVersion)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("helmVersionSatisfies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("helmVersionSatisfies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHelmTarballMember(t *testing.T) {
+	tests := []struct {
+		arch    string
+		want    string
+		wantErr bool
+	}{
+		{arch: "amd64", want: "linux-amd64/helm"},
+		{arch: "arm64", want: "linux-arm64/helm"},
+		{arch: "s390x", wantErr: true},
+		{arch: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arch, func(t *testing.T) {
+			got, err := helmTarballMember(tt.arch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("helmTarballMember() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("helmTarballMember() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChart_InstallStepsV2(t *testing.T) {
+	nodes := []v1.StepNode{{ID: "node1"}}
+	for _, chart := range []*Chart{
+		{PkgName: "cilium", Version: "1.15.1"},
+		{PkgName: "cilium", Version: "1.15.1", Source: "oci://registry.example.com/charts"},
+	} {
+		steps, err := chart.InstallStepsV2(nodes)
+		if err != nil {
+			t.Fatalf("InstallStepsV2() error = %v", err)
+		}
+		if len(steps) != 2 || steps[0].Name != "installHelm" || steps[1].Name != "cilium-chartLoad" {
+			t.Fatalf("InstallStepsV2() = %+v, want helm installed before the chart is loaded", steps)
+		}
+		if steps[0].Commands[0].Identity != "helm/v1/AgentHelm" {
+			t.Errorf("installHelm identity = %s", steps[0].Commands[0].Identity)
+		}
+	}
+}
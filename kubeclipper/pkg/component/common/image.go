@@ -67,7 +67,7 @@ func (i *Imager) Install(ctx context.Context, opts component.Options) ([]byte, e
 	}
 
 	logger.Infof("%s-%s image packages offline install successfully", i.PkgName, i.Version)
-	return nil, err
+	return json.Marshal(&downloader.Artifact{Files: dstFiles, Source: instance.Source()})
 }
 
 func (i *Imager) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
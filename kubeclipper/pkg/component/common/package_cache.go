@@ -0,0 +1,96 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/logger"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
+)
+
+const (
+	packageCacheName = "packageCache"
+	AgentPackageGC   = "AgentPackageGC"
+)
+
+func init() {
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat, packageCacheName, version, AgentPackageGC), &PackageCacheGC{}); err != nil {
+		panic(err)
+	}
+}
+
+var _ component.StepRunnable = (*PackageCacheGC)(nil)
+
+// PackageCacheGC collects the packages the downloader cached on the node, it replies the downloader.CacheGCResult.
+type PackageCacheGC struct {
+	downloader.CacheGCRequest
+}
+
+func (p *PackageCacheGC) NewInstance() component.ObjectMeta {
+	return &PackageCacheGC{}
+}
+
+func (p *PackageCacheGC) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	req := p.CacheGCRequest
+	req.DryRun = req.DryRun || opts.DryRun
+	result, err := downloader.CollectCache(downloader.BaseDstDir, &req)
+	if err != nil {
+		return nil, fmt.Errorf("collect the package cache: %w", err)
+	}
+	logger.Infof("the package cache collection reclaimed %d bytes", result.ReclaimedBytes)
+	return json.Marshal(result)
+}
+
+func (p *PackageCacheGC) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	return nil, nil
+}
+
+// PackageCacheGCStep collects the package cache of nodes with the policy of the agents, the packages of inUse,
+// see downloader.PackageKey, are kept. The step is best-effort so that it can be appended to the uninstall steps.
+func PackageCacheGCStep(nodes []v1.StepNode, inUse []string) (v1.Step, error) {
+	custom, err := json.Marshal(&PackageCacheGC{CacheGCRequest: downloader.CacheGCRequest{InUse: inUse}})
+	if err != nil {
+		return v1.Step{}, err
+	}
+	return v1.Step{
+		ID:         strutil.GetUUID(),
+		Name:       "collectPackageCache",
+		Timeout:    metav1.Duration{Duration: 5 * time.Minute},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:          v1.CommandCustom,
+				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, packageCacheName, version, AgentPackageGC),
+				CustomCommand: custom,
+			},
+		},
+	}, nil
+}
@@ -20,6 +20,7 @@ package component
 
 import (
 	"errors"
+	"sort"
 	"strings"
 )
 
@@ -54,6 +55,16 @@ func LoadAgentStep(kv string) (StepRunnable, bool) {
 	return _agentSteps.load(kv)
 }
 
+// AgentStepKeys the sorted componentName/version/stepName keys of the registered agent steps.
+func AgentStepKeys() []string {
+	keys := make([]string, 0, len(_agentSteps.steps))
+	for k := range _agentSteps.steps {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (h *agentStep) load(kv string) (StepRunnable, bool) {
 	c, exist := h.steps[kv]
 	return c, exist
@@ -26,6 +26,7 @@ import (
 	"time"
 
 	"github.com/kubeclipper/kubeclipper/pkg/utils/netutil"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/sysutil"
 
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
 )
@@ -38,6 +39,8 @@ type (
 	oplogKey     struct{}
 	retryKey     struct{}
 	repoMirror   struct{}
+	commandEnv   struct{}
+	factsKey     struct{}
 )
 
 type ExtraMetadata struct {
@@ -56,7 +59,24 @@ type ExtraMetadata struct {
 	Addons                    []v1.Addon
 	CNI                       string
 	CNINamespace              string
+	KubeProxyMode             string
 	OnlyInstallKubernetesComp bool
+	// CNITemplates the user overrides of the registered cni templates, keyed by the ConfigMap data key of the template key.
+	CNITemplates map[string]string
+	// LocalRegistryUsername and LocalRegistryPassword the credentials of LocalRegistry, resolved from the credentials
+	// ConfigMap of the cni or the platform registry settings, empty when the registry is anonymous.
+	LocalRegistryUsername string
+	LocalRegistryPassword string
+	// LocalRegistryCA the CA bundle of LocalRegistry resolved from the CA bundle ConfigMap of the cni.
+	LocalRegistryCA string
+	// APIServerPort the port the kube-apiserver of the masters listens on, 6443 when it is zero.
+	APIServerPort int
+	// APIServerEndpoint the host:port of the load balancer in front of the kube-apiservers, empty when the cluster
+	// has none, see v1.Cluster ExternalAPIServerEndpoint.
+	APIServerEndpoint string
+	// AgentPort the port of the kubeclipper message queue the agents connect to, the nodes co-located with the
+	// kubeclipper server serve it. 9889 when it is zero.
+	AgentPort int
 }
 
 type Node struct {
@@ -67,6 +87,39 @@ type Node struct {
 	Hostname string
 	Role     string
 	Disable  bool
+	Arch     string
+	// HelmSDK whether the agent of the node runs the helm commands with the helm SDK, see v1.CommandHelm.
+	HelmSDK bool
+	// WaitFor whether the agent of the node runs the v1.CommandWaitFor commands.
+	WaitFor bool
+	// Labels the kubernetes labels of the node set in the cluster spec.
+	Labels map[string]string
+	// Deleting whether the node is being removed from kubeclipper.
+	Deleting bool
+	// Conditions the conditions of the node reported by its agent, e.g. Ready.
+	Conditions []v1.NodeCondition
+}
+
+// Unavailable the reason the steps can not run on the node, empty when they can. The disabled nodes, the nodes
+// being removed and the nodes whose agent is not Ready are unavailable, the nodes without conditions are not.
+func (n Node) Unavailable() string {
+	switch {
+	case n.Disable:
+		return "the node is disabled"
+	case n.Deleting:
+		return "the node is being removed"
+	}
+	for _, cond := range n.Conditions {
+		if cond.Type != v1.NodeReady || cond.Status == v1.ConditionTrue {
+			continue
+		}
+		reason := fmt.Sprintf("the node is not ready, the Ready condition is %s", cond.Status)
+		if cond.Reason != "" {
+			reason += ": " + cond.Reason
+		}
+		return reason
+	}
+	return ""
 }
 
 type NodeList []Node
@@ -276,6 +329,23 @@ func GetRetry(ctx context.Context) bool {
 	return false
 }
 
+// WithCommandEnv puts the environment variables of the custom command being run into ctx.
+func WithCommandEnv(ctx context.Context, env []v1.EnvVar) context.Context {
+	return context.WithValue(ctx, commandEnv{}, env)
+}
+
+// GetCommandEnv the value of the environment variable name of the custom command being run, the sensitive values,
+// e.g. passwords, are passed to the custom commands this way so that they are not persisted with the operation.
+func GetCommandEnv(ctx context.Context, name string) string {
+	env, _ := ctx.Value(commandEnv{}).([]v1.EnvVar)
+	for _, e := range env {
+		if e.Name == name {
+			return e.Value
+		}
+	}
+	return ""
+}
+
 func WithRepoMirror(ctx context.Context, mirror string) context.Context {
 	return context.WithValue(ctx, repoMirror{}, mirror)
 }
@@ -286,3 +356,16 @@ func GetRepoMirror(ctx context.Context) string {
 	}
 	return ""
 }
+
+// WithFacts puts the node-local facts into ctx, facts is only called when the facts are used.
+func WithFacts(ctx context.Context, facts func() sysutil.Facts) context.Context {
+	return context.WithValue(ctx, factsKey{}, facts)
+}
+
+// GetFacts the node-local facts, they are empty when ctx has none, e.g. the templates rendered on the server.
+func GetFacts(ctx context.Context) sysutil.Facts {
+	if v := ctx.Value(factsKey{}); v != nil {
+		return v.(func() sysutil.Facts)()
+	}
+	return sysutil.Facts{}
+}
@@ -832,3 +832,31 @@ func TestExtraMetadata_IsAllMasterAvailable(t *testing.T) {
 		})
 	}
 }
+
+func TestNode_Unavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		node Node
+		want string
+	}{
+		{name: "no conditions", node: Node{ID: "node1"}},
+		{name: "ready", node: Node{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}}},
+		{name: "disabled", node: Node{Disable: true}, want: "the node is disabled"},
+		{name: "deleting", node: Node{Deleting: true}, want: "the node is being removed"},
+		{
+			name: "not ready",
+			node: Node{Conditions: []v1.NodeCondition{
+				{Type: v1.NodeDiskPressure, Status: v1.ConditionTrue},
+				{Type: v1.NodeReady, Status: v1.ConditionUnknown, Reason: "NodeStatusUnknown"},
+			}},
+			want: "the node is not ready, the Ready condition is Unknown: NodeStatusUnknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.node.Unavailable(); got != tt.want {
+				t.Errorf("Unavailable() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -151,4 +151,5 @@ type OperationLogFile interface {
 	GetStepLogContent(opID, stepID string, offset int64, length int) (content []byte, deliverySize int64, logSize int64, err error)
 	CreateStepLogFileAndAppend(opID, stepID string, data []byte) error
 	TruncateStepLogFile(opID, stepID string) error
+	GetOperationFileContent(opID, name string, offset int64, length int) (content []byte, fileSize int64, err error)
 }
@@ -20,6 +20,7 @@ package component
 
 import (
 	"errors"
+	"sort"
 	"strings"
 )
 
@@ -54,6 +55,16 @@ func LoadTemplate(kv string) (TemplateRender, bool) {
 	return _tmpl.load(kv)
 }
 
+// TemplateKeys the sorted name/version/templateName keys of the registered templates.
+func TemplateKeys() []string {
+	keys := make([]string, 0, len(_tmpl.template))
+	for k := range _tmpl.template {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (h *tmpl) load(kv string) (TemplateRender, bool) {
 	c, exist := h.template[kv]
 	return c, exist
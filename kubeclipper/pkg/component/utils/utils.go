@@ -20,6 +20,7 @@ package utils
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/kubeclipper/kubeclipper/pkg/component"
@@ -53,6 +54,8 @@ func LoadImage(ctx context.Context, dryRun bool, file, criType string) error {
 		if err != nil {
 			return err
 		}
+	default:
+		return fmt.Errorf("unsupported cri type %q", criType)
 	}
 
 	_, err := cmdutil.RunCmdWithContext(ctx, dryRun, "rm", "-rf", file)
@@ -60,6 +63,20 @@ func LoadImage(ctx context.Context, dryRun bool, file, criType string) error {
 	return err
 }
 
+// TagImage tags the loaded image source as target with the CLI of the container runtime.
+func TagImage(ctx context.Context, dryRun bool, source, target, criType string) error {
+	switch criType {
+	case "containerd":
+		_, err := cmdutil.RunCmdWithContext(ctx, dryRun, "nerdctl", "-n", "k8s.io", "tag", source, target)
+		return err
+	case "docker":
+		_, err := cmdutil.RunCmdWithContext(ctx, dryRun, "docker", "tag", source, target)
+		return err
+	default:
+		return fmt.Errorf("unsupported cri type %q", criType)
+	}
+}
+
 func RetryFunc(ctx context.Context, opts component.Options, intervalTime time.Duration, funcName string, fn func(ctx context.Context, opts component.Options) error) error {
 	for {
 		select {
@@ -83,6 +100,9 @@ func UnwrapNodeList(nl component.NodeList) (nodes []v1.StepNode) {
 			IPv4:     v.IPv4,
 			NodeIPv4: v.NodeIPv4,
 			Hostname: v.Hostname,
+			Arch:     v.Arch,
+			HelmSDK:  v.HelmSDK,
+			WaitFor:  v.WaitFor,
 		})
 	}
 	return nodes
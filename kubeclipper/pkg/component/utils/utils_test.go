@@ -14,8 +14,9 @@ func TestLoadImage(t *testing.T) {
 		criType string
 	}
 	tests := []struct {
-		name string
-		args args
+		name    string
+		args    args
+		wantErr bool
 	}{
 		{
 			name: "load a docker image",
@@ -35,11 +36,41 @@ func TestLoadImage(t *testing.T) {
 				criType: "containerd",
 			},
 		},
+		{
+			name: "unsupported cri",
+			args: args{
+				ctx:     context.TODO(),
+				dryRun:  true,
+				file:    "/demoPath",
+				criType: "podman",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := LoadImage(tt.args.ctx, tt.args.dryRun, tt.args.file, tt.args.criType); (err != nil) != tt.wantErr {
+				t.Errorf("LoadImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTagImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		criType string
+		wantErr bool
+	}{
+		{name: "tag a docker image", criType: "docker"},
+		{name: "tag a containerd image", criType: "containerd"},
+		{name: "unsupported cri", criType: "podman", wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := LoadImage(tt.args.ctx, tt.args.dryRun, tt.args.file, tt.args.criType); err != nil {
-				t.Errorf("LoadImage() error = %v", err)
+			err := TagImage(context.TODO(), true, "quay.io/cilium/cilium:v1.14.4", "registry.local:5000/cilium/cilium:v1.14.4", tt.criType)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TagImage() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
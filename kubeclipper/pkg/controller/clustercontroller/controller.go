@@ -63,6 +63,7 @@ import (
 	"github.com/kubeclipper/kubeclipper/pkg/query"
 	"github.com/kubeclipper/kubeclipper/pkg/scheme/common"
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
 	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cri"
 	"github.com/kubeclipper/kubeclipper/pkg/service"
 
@@ -230,6 +231,14 @@ func (r *ClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		log.Error("update apiServer cert error", zap.Error(err))
 		return ctrl.Result{}, nil
 	}
+	if err = r.migrateCNI(ctx, clu); err != nil {
+		log.Error("migrate cni error", zap.Error(err))
+		return ctrl.Result{}, nil
+	}
+	if err = r.upgradeCNI(ctx, clu); err != nil {
+		log.Error("upgrade cni error", zap.Error(err))
+		return ctrl.Result{}, nil
+	}
 
 	return ctrl.Result{}, r.updateCRIRegistries(ctx, clu)
 }
@@ -464,6 +473,149 @@ func generateUpdateSANOperation(c *v1.Cluster, nodeList component.NodeList) *v1.
 	return op
 }
 
+// upgradeCNI creates the cni upgrade operation when the cni version, the cni DaemonSet placement or the BGP peerings
+// of cluster spec differ from the installed ones, the placement is applied by upgrading the installed version in place.
+func (r *ClusterReconciler) upgradeCNI(ctx context.Context, c *v1.Cluster) error {
+	if c.Status.Phase != v1.ClusterRunning ||
+		(c.Status.Versions.CNI == c.CNI.Version && c.Status.Versions.CNIPlacement == cni.AgentPlacementHash(&c.CNI) &&
+			c.Status.Versions.CNIBGP == cni.BGPPeeringHash(&c.CNI)) {
+		return nil
+	}
+	if c.Status.Versions.CNIType != "" && c.Status.Versions.CNIType != c.CNI.Type {
+		// the cni migration installs the spec version
+		return nil
+	}
+	if c.Status.Versions.CNI == "" {
+		// clusters created before the cni version was recorded
+		c.Status.Versions.CNI = c.CNI.Version
+		_, err := r.ClusterWriter.UpdateCluster(ctx, c)
+		return err
+	}
+	extra, err := r.assembleClusterExtraMetadata(ctx, c)
+	if err != nil {
+		return err
+	}
+	op, err := generateUpgradeCNIOperation(c, extra)
+	if err != nil {
+		return err
+	}
+	if _, err = r.OperationOperator.CreateOperation(ctx, op); err != nil {
+		return pkgerr.WithMessage(err, "create cni upgrade operation failed")
+	}
+	c.Status.Phase = v1.ClusterUpdating
+	if _, err = r.ClusterOperator.UpdateCluster(ctx, c); err != nil {
+		return pkgerr.WithMessage(err, "create cni upgrade operation,update cluster status failed")
+	}
+	return nil
+}
+
+func generateUpgradeCNIOperation(c *v1.Cluster, extra *component.ExtraMetadata) (*v1.Operation, error) {
+	masters := utils.UnwrapNodeList(extra.Masters)
+	if len(masters) == 0 {
+		return nil, fmt.Errorf("cluster %s has no master node", c.Name)
+	}
+	var (
+		steps []v1.Step
+		err   error
+	)
+	if peeringsOnly(c) {
+		steps, err = k8s.UpdateCNIPeerings(extra, &c.CNI, &c.Networking, []v1.StepNode{masters[0]})
+	} else {
+		steps, err = k8s.UpgradeCNI(extra, &c.CNI, &c.Networking, []v1.StepNode{masters[0]}, c.Status.Versions.CNI)
+	}
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{
+		common.LabelClusterName:     c.Name,
+		common.LabelTimeoutSeconds:  v1.DefaultOperationTimeoutSecs,
+		common.LabelOperationAction: v1.OperationUpgradeCNI,
+	}
+	if c.Annotations[common.AnnotationCNIUpgradeConfirm] == "false" {
+		// the operation stops after the diff of the cni settings until it is approved
+		labels[common.LabelOperationConfirm] = "false"
+	}
+	op := &v1.Operation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   uuid.New().String(),
+			Labels: labels,
+		},
+		Steps: steps,
+		Status: v1.OperationStatus{
+			Status: v1.OperationStatusPending, // operator controller will deliver it
+		},
+	}
+	if err = cni.AnnotateExcludedNodes(op, extra); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// peeringsOnly whether only the BGP peerings of the installed cni changed, they are re-applied without the helm
+// upgrade. Switching the BGP control plane on or off upgrades the cni.
+func peeringsOnly(c *v1.Cluster) bool {
+	hash := cni.BGPPeeringHash(&c.CNI)
+	return c.Status.Versions.CNI == c.CNI.Version && c.Status.Versions.CNIPlacement == cni.AgentPlacementHash(&c.CNI) &&
+		c.Status.Versions.CNIBGP != "" && hash != "" && c.Status.Versions.CNIBGP != hash
+}
+
+// migrateCNI creates the cni migration operation when the cni type of cluster spec differs from the installed one.
+func (r *ClusterReconciler) migrateCNI(ctx context.Context, c *v1.Cluster) error {
+	if c.Status.Phase != v1.ClusterRunning || c.Status.Versions.CNIType == c.CNI.Type {
+		return nil
+	}
+	if c.Status.Versions.CNIType == "" {
+		// clusters created before the cni type was recorded
+		c.Status.Versions.CNIType = c.CNI.Type
+		_, err := r.ClusterWriter.UpdateCluster(ctx, c)
+		return err
+	}
+	extra, err := r.assembleClusterExtraMetadata(ctx, c)
+	if err != nil {
+		return err
+	}
+	op, err := generateMigrateCNIOperation(c, extra)
+	if err != nil {
+		return err
+	}
+	if _, err = r.OperationOperator.CreateOperation(ctx, op); err != nil {
+		return pkgerr.WithMessage(err, "create cni migration operation failed")
+	}
+	c.Status.Phase = v1.ClusterUpdating
+	if _, err = r.ClusterOperator.UpdateCluster(ctx, c); err != nil {
+		return pkgerr.WithMessage(err, "create cni migration operation,update cluster status failed")
+	}
+	return nil
+}
+
+func generateMigrateCNIOperation(c *v1.Cluster, extra *component.ExtraMetadata) (*v1.Operation, error) {
+	// the spec keeps the configuration of the installed cni, only its type and version are replaced
+	from := c.CNI
+	from.Type = c.Status.Versions.CNIType
+	from.Version = c.Status.Versions.CNI
+	steps, err := k8s.MigrateCNI(extra, &c.CNI, &from, &c.Networking, utils.UnwrapNodeList(extra.GetAllNodes()))
+	if err != nil {
+		return nil, err
+	}
+	op := &v1.Operation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: uuid.New().String(),
+			Labels: map[string]string{
+				common.LabelClusterName:     c.Name,
+				common.LabelTimeoutSeconds:  v1.DefaultOperationTimeoutSecs,
+				common.LabelOperationAction: v1.OperationMigrateCNI,
+			}},
+		Steps: steps,
+		Status: v1.OperationStatus{
+			Status: v1.OperationStatusPending, // operator controller will deliver it
+		},
+	}
+	if err = cni.AnnotateExcludedNodes(op, extra); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
 func (r *ClusterReconciler) needUpdate(ctx context.Context, c *v1.Cluster) (bool, error) {
 	for _, nodeID := range c.Masters.GetNodeIDs() {
 		certificate, err := getCert(ctx, r.CmdDelivery, nodeID)
@@ -493,6 +645,9 @@ func (r *ClusterReconciler) canUpdate(c *v1.Cluster) (component.NodeList, error)
 			Hostname: node.Status.NodeInfo.Hostname,
 			Role:     node.Labels[common.LabelNodeRole],
 			Disable:  false,
+			Arch:     node.Labels[common.LabelArchStable],
+			HelmSDK:  node.Labels[common.LabelAgentHelmSDK] == "true",
+			WaitFor:  node.Labels[common.LabelAgentWaitFor] == "true",
 		})
 	}
 	masters, err := nl.AvailableKubeMasters()
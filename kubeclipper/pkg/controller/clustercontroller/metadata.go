@@ -21,6 +21,8 @@ func (r *ClusterReconciler) assembleClusterExtraMetadata(ctx context.Context, c
 		ControlPlaneStatus: c.Status.ControlPlaneHealth,
 		CNI:                c.CNI.Type,
 		CNINamespace:       c.CNI.Namespace,
+		KubeProxyMode:      c.Networking.ProxyMode,
+		APIServerEndpoint:  c.ExternalAPIServerEndpoint(),
 	}
 	meta.Addons = append(meta.Addons, c.Addons...)
 
@@ -52,8 +54,14 @@ func (r *ClusterReconciler) convertNodes(ctx context.Context, nodes v1.WorkerNod
 			Region:   n.Labels[common.LabelTopologyRegion],
 			Hostname: n.Labels[common.LabelHostname],
 			Role:     n.Labels[common.LabelNodeRole],
+			Arch:     n.Labels[common.LabelArchStable],
+			HelmSDK:  n.Labels[common.LabelAgentHelmSDK] == "true",
+			WaitFor:  n.Labels[common.LabelAgentWaitFor] == "true",
+			Labels:   node.Labels,
 		}
 		_, item.Disable = n.Labels[common.LabelNodeDisable]
+		item.Deleting = n.DeletionTimestamp != nil
+		item.Conditions = n.Status.Conditions
 		meta = append(meta, item)
 	}
 
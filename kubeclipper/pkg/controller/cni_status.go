@@ -0,0 +1,167 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	listerv1 "github.com/kubeclipper/kubeclipper/pkg/client/lister/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/controller-runtime/manager"
+	"github.com/kubeclipper/kubeclipper/pkg/logger"
+	"github.com/kubeclipper/kubeclipper/pkg/models/cluster"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
+	"github.com/kubeclipper/kubeclipper/pkg/service"
+)
+
+const (
+	cniStatusMonitorPeriod = 1 * time.Minute
+	cniStatusCheckTimeout  = 30 * time.Second
+)
+
+// reasons of the CNIReady condition
+const (
+	cniReasonReady          = "Ready"
+	cniReasonNotReady       = "NotReady"
+	cniReasonUnsupported    = "HealthCheckUnsupported"
+	cniReasonCheckFailed    = "HealthCheckFailed"
+	cniFailedChecksSeparate = "; "
+)
+
+// CNIStatusMon tracks the CNIReady condition of the running clusters with the health operation of their cni,
+// the cnis get the tracking by adding the operation to their CmdList.
+type CNIStatusMon struct {
+	ClusterWriter cluster.ClusterWriter
+	ClusterLister listerv1.ClusterLister
+	CmdDelivery   service.CmdDelivery
+	now           func() metav1.Time
+	log           logger.Logging
+}
+
+func (s *CNIStatusMon) SetupWithManager(mgr manager.Manager) {
+	s.now = metav1.Now
+	s.log = mgr.GetLogger().WithName("cni-status-monitor")
+	mgr.AddWorkerLoop(s.monitorCNIStatus, cniStatusMonitorPeriod)
+}
+
+func (s *CNIStatusMon) monitorCNIStatus() {
+	clusters, err := s.ClusterLister.List(labels.Everything())
+	if err != nil {
+		s.log.Error("list clusters failed, monitor cni status next period", zap.Error(err))
+		return
+	}
+	for _, clu := range clusters {
+		if clu.Status.Phase != v1.ClusterRunning || clu.CNI.Type == "" || len(clu.Masters) == 0 {
+			continue
+		}
+		s.updateCNICondition(clu.Name, s.checkCNI(clu))
+	}
+}
+
+// checkCNI runs the health operation of the cni of clu on its first master.
+func (s *CNIStatusMon) checkCNI(clu *v1.Cluster) v1.ClusterCondition {
+	metadata := &component.ExtraMetadata{ClusterName: clu.Name, CNI: clu.CNI.Type, CNINamespace: clu.CNI.Namespace}
+	cmdList, err := cni.RecoveryCNICmd(metadata, clu)
+	if err != nil {
+		return v1.ClusterCondition{Type: v1.ClusterCNIReady, Status: v1.ConditionUnknown, Reason: cniReasonUnsupported, Message: err.Error()}
+	}
+	cmd, ok := cmdList[cni.OperationHealth]
+	if !ok {
+		return v1.ClusterCondition{Type: v1.ClusterCNIReady, Status: v1.ConditionUnknown, Reason: cniReasonUnsupported,
+			Message: "the cni " + clu.CNI.Type + " has no health operation"}
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), cniStatusCheckTimeout)
+	defer cancel()
+	out, err := s.CmdDelivery.DeliverCmd(ctx, clu.Masters[0].ID, []string{"/bin/bash", "-c", cmd}, cniStatusCheckTimeout)
+	if err != nil {
+		return v1.ClusterCondition{Type: v1.ClusterCNIReady, Status: v1.ConditionUnknown, Reason: cniReasonCheckFailed, Message: err.Error()}
+	}
+	return cniCondition(cni.ParseWorkloadHealth(string(out)))
+}
+
+// cniCondition the CNIReady condition of the health result, the message lists the failed checks.
+func cniCondition(result *cni.HealthResult) v1.ClusterCondition {
+	if result.Healthy {
+		return v1.ClusterCondition{Type: v1.ClusterCNIReady, Status: v1.ConditionTrue, Reason: cniReasonReady}
+	}
+	var failed []string
+	for _, check := range result.Checks {
+		if !check.Healthy {
+			failed = append(failed, check.Name+": "+check.Message)
+		}
+	}
+	return v1.ClusterCondition{Type: v1.ClusterCNIReady, Status: v1.ConditionFalse, Reason: cniReasonNotReady,
+		Message: strings.Join(failed, cniFailedChecksSeparate)}
+}
+
+// updateCNICondition writes the observed condition to the cluster when it changed, the transitions of the
+// status are logged once.
+func (s *CNIStatusMon) updateCNICondition(clusterName string, observed v1.ClusterCondition) {
+	clu, err := s.ClusterLister.Get(clusterName)
+	if err != nil {
+		s.log.Warn("get cluster failed when update cni status, skip it", zap.String("cluster", clusterName), zap.Error(err))
+		return
+	}
+	clu = clu.DeepCopy()
+	transitioned, changed := setClusterCondition(&clu.Status, observed, s.now())
+	if !changed {
+		s.log.Debug("cni status has no change", zap.String("cluster", clusterName), zap.String("status", string(observed.Status)))
+		return
+	}
+	if _, err = s.ClusterWriter.UpdateCluster(context.TODO(), clu); err != nil {
+		s.log.Warn("update cni status failed", zap.String("cluster", clusterName), zap.Error(err))
+		return
+	}
+	if !transitioned {
+		return
+	}
+	fields := []zap.Field{zap.String("cluster", clusterName), zap.String("reason", observed.Reason), zap.String("message", observed.Message)}
+	if observed.Status == v1.ConditionTrue {
+		s.log.Info("cni is ready", fields...)
+	} else {
+		s.log.Warn("cni is not ready", fields...)
+	}
+}
+
+// setClusterCondition sets the observed condition of status, the transition time only changes with the status.
+func setClusterCondition(status *v1.ClusterStatus, observed v1.ClusterCondition, now metav1.Time) (transitioned, changed bool) {
+	current := status.GetCondition(observed.Type)
+	if current == nil {
+		observed.LastTransitionTime = now
+		status.Conditions = append(status.Conditions, observed)
+		return true, true
+	}
+	if current.Status == observed.Status && current.Reason == observed.Reason && current.Message == observed.Message {
+		return false, false
+	}
+	transitioned = current.Status != observed.Status
+	observed.LastTransitionTime = current.LastTransitionTime
+	if transitioned {
+		observed.LastTransitionTime = now
+	}
+	*current = observed
+	return transitioned, true
+}
@@ -0,0 +1,75 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
+)
+
+func TestSetClusterCondition(t *testing.T) {
+	t0 := metav1.NewTime(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	t1 := metav1.NewTime(t0.Add(time.Minute))
+	t2 := metav1.NewTime(t0.Add(2 * time.Minute))
+	notReady := v1.ClusterCondition{Type: v1.ClusterCNIReady, Status: v1.ConditionFalse, Reason: cniReasonNotReady, Message: "daemonset/cilium: 1 of 2 pods ready"}
+
+	status := &v1.ClusterStatus{}
+	if transitioned, changed := setClusterCondition(status, notReady, t0); !transitioned || !changed {
+		t.Fatalf("first condition: transitioned %v changed %v, want both", transitioned, changed)
+	}
+	if transitioned, changed := setClusterCondition(status, notReady, t1); transitioned || changed {
+		t.Fatalf("same condition: transitioned %v changed %v, want neither", transitioned, changed)
+	}
+
+	notReady.Message = "daemonset/cilium: 0 of 2 pods ready"
+	if transitioned, changed := setClusterCondition(status, notReady, t1); transitioned || !changed {
+		t.Fatalf("new message: transitioned %v changed %v, want only changed", transitioned, changed)
+	}
+	if got := status.GetCondition(v1.ClusterCNIReady); !got.LastTransitionTime.Equal(&t0) || got.Message != notReady.Message {
+		t.Fatalf("new message: got %+v, want the message updated at %v", got, t0)
+	}
+
+	ready := v1.ClusterCondition{Type: v1.ClusterCNIReady, Status: v1.ConditionTrue, Reason: cniReasonReady}
+	if transitioned, changed := setClusterCondition(status, ready, t2); !transitioned || !changed {
+		t.Fatalf("ready: transitioned %v changed %v, want both", transitioned, changed)
+	}
+	if len(status.Conditions) != 1 {
+		t.Fatalf("got %d conditions, want 1", len(status.Conditions))
+	}
+	if got := status.GetCondition(v1.ClusterCNIReady); got.Status != v1.ConditionTrue || !got.LastTransitionTime.Equal(&t2) {
+		t.Fatalf("ready: got %+v, want true at %v", got, t2)
+	}
+}
+
+func TestCNICondition(t *testing.T) {
+	got := cniCondition(cni.ParseWorkloadHealth("daemonset/cilium 3 2\ndeployment/cilium-operator 2 2\nrelease/cilium failed\n"))
+	want := "daemonset/cilium: 2 of 3 pods ready; release/cilium: release cilium failed"
+	if got.Status != v1.ConditionFalse || got.Reason != cniReasonNotReady || got.Message != want {
+		t.Errorf("cniCondition() = %+v, want false %s %q", got, cniReasonNotReady, want)
+	}
+	got = cniCondition(cni.ParseWorkloadHealth("daemonset/cilium 3 3\nrelease/cilium deployed\n"))
+	if got.Status != v1.ConditionTrue || got.Reason != cniReasonReady || got.Message != "" {
+		t.Errorf("cniCondition() = %+v, want true %s", got, cniReasonReady)
+	}
+}
@@ -35,5 +35,8 @@ const (
 	AgentStepInstall   CauseType = "agent install step command "
 	AgentStepUninstall CauseType = "agent uninstall step command"
 	ShellCommand       CauseType = "shell command step error"
+	HelmCommand        CauseType = "helm command step error"
+	WaitForCommand     CauseType = "wait command step error"
 	StepLog            CauseType = "step log error"
+	CacheGC            CauseType = "package cache gc error"
 )
@@ -77,7 +77,7 @@ func (l *operationOperator) GetOperation(ctx context.Context, name string) (*v1.
 
 func (l *operationOperator) CreateOperation(ctx context.Context, operation *v1.Operation) (*v1.Operation, error) {
 	ctx = genericapirequest.WithNamespace(ctx, operation.Namespace)
-	obj, err := l.storage.Create(ctx, operation, nil, &metav1.CreateOptions{})
+	obj, err := l.storage.Create(ctx, secrets.redact(operation), nil, &metav1.CreateOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +86,7 @@ func (l *operationOperator) CreateOperation(ctx context.Context, operation *v1.O
 
 func (l *operationOperator) UpdateOperation(ctx context.Context, operation *v1.Operation) (*v1.Operation, error) {
 	ctx = genericapirequest.WithNamespace(ctx, operation.Namespace)
-	obj, wasCreated, err := l.storage.Update(ctx, operation.Name, rest.DefaultUpdatedObjectInfo(operation), nil, nil, false, &metav1.UpdateOptions{})
+	obj, wasCreated, err := l.storage.Update(ctx, operation.Name, rest.DefaultUpdatedObjectInfo(secrets.redact(operation)), nil, nil, false, &metav1.UpdateOptions{})
 	if wasCreated {
 		logger.Debug("operation not exist, use create instead of update", zap.String("name", operation.Name))
 	}
@@ -114,6 +114,9 @@ func (l *operationOperator) DeleteOperation(ctx context.Context, name string) er
 	_, _, err = l.storage.Delete(ctx, name, func(ctx context.Context, obj runtime.Object) error {
 		return nil
 	}, &metav1.DeleteOptions{})
+	if err == nil {
+		secrets.forget(name)
+	}
 	return err
 }
 
@@ -0,0 +1,126 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package operation
+
+import (
+	"sync"
+	"time"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+// secretsRetention the time the secrets of a failed or terminated operation are kept for its retry.
+const secretsRetention = 24 * time.Hour
+
+// secrets the steps carrying secrets of the operations persisted by this server, keyed by operation name and
+// step ID. The operations are persisted with the secrets redacted, the steps are only kept in memory so that
+// the server can run and retry them.
+var secrets = &secretSteps{ops: make(map[string]*operationSecrets), now: time.Now}
+
+type secretSteps struct {
+	mu  sync.Mutex
+	ops map[string]*operationSecrets
+	now func() time.Time
+}
+
+type operationSecrets struct {
+	steps map[string]v1.Step
+	// expires the secrets are dropped after it, zero while the operation runs or waits for its approval.
+	expires time.Time
+}
+
+// redact returns a copy of op with its secrets redacted, the steps carrying secrets are kept in memory.
+// The secrets of an operation persisted as failed or terminated expire after secretsRetention unless it is
+// retried, the expired secrets of all operations are dropped.
+func (s *secretSteps) redact(op *v1.Operation) *v1.Operation {
+	redacted := op.DeepCopy()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for name, secret := range s.ops {
+		if secret.expired(now) {
+			delete(s.ops, name)
+		}
+	}
+	for i := range redacted.Steps {
+		step := &redacted.Steps[i]
+		if step.SecretsRedacted() {
+			// persisted already, e.g. the operation was read back to be updated
+			continue
+		}
+		if !step.RedactSecrets() {
+			continue
+		}
+		if s.ops[op.Name] == nil {
+			s.ops[op.Name] = &operationSecrets{steps: make(map[string]v1.Step)}
+		}
+		s.ops[op.Name].steps[step.ID] = *op.Steps[i].DeepCopy()
+	}
+	if secret := s.ops[op.Name]; secret != nil {
+		switch op.Status.Status {
+		case v1.OperationStatusFailed, v1.OperationStatusTermination:
+			if secret.expires.IsZero() {
+				secret.expires = now.Add(secretsRetention)
+			}
+		default:
+			secret.expires = time.Time{}
+		}
+	}
+	return redacted
+}
+
+func (s *secretSteps) restore(op *v1.Operation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret := s.ops[op.Name]
+	if secret == nil {
+		return
+	}
+	if secret.expired(s.now()) {
+		delete(s.ops, op.Name)
+		return
+	}
+	for i := range op.Steps {
+		if step, ok := secret.steps[op.Steps[i].ID]; ok && op.Steps[i].SecretsRedacted() {
+			op.Steps[i] = *step.DeepCopy()
+		}
+	}
+}
+
+func (s *secretSteps) forget(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ops, name)
+}
+
+func (o *operationSecrets) expired(now time.Time) bool {
+	return !o.expires.IsZero() && now.After(o.expires)
+}
+
+// RestoreSecrets restores the secrets of the steps of op redacted when it was persisted. The secrets are only
+// kept in memory, the steps of an operation persisted before the server restarted, or failed longer than
+// secretsRetention ago, keep their redacted secrets, see v1.Step.SecretsRedacted.
+func RestoreSecrets(op *v1.Operation) {
+	secrets.restore(op)
+}
+
+// ForgetSecrets drops the secrets of the operation name kept in memory, e.g. once it succeeded.
+func ForgetSecrets(name string) {
+	secrets.forget(name)
+}
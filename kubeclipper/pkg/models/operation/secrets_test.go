@@ -0,0 +1,191 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/registry/rest"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
+)
+
+// jsonStorage stores the JSON of the last operation created or updated.
+type jsonStorage struct {
+	rest.StandardStorage
+	stored []byte
+}
+
+func (s *jsonStorage) store(obj runtime.Object) (runtime.Object, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	s.stored = data
+	op := &v1.Operation{}
+	return op, json.Unmarshal(data, op)
+}
+
+func (s *jsonStorage) Create(ctx context.Context, obj runtime.Object, _ rest.ValidateObjectFunc, _ *metav1.CreateOptions) (runtime.Object, error) {
+	return s.store(obj)
+}
+
+func (s *jsonStorage) Update(ctx context.Context, _ string, objInfo rest.UpdatedObjectInfo, _ rest.ValidateObjectFunc,
+	_ rest.ValidateObjectUpdateFunc, _ bool, _ *metav1.UpdateOptions) (runtime.Object, bool, error) {
+	obj, err := objInfo.UpdatedObject(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	obj, err = s.store(obj)
+	return obj, false, err
+}
+
+func TestOperationOperator_redactSecrets(t *testing.T) {
+	const password, setPassword, token = "registry-s3cret", "etcd-s3cret", "token-s3cret"
+	metadata := &component.ExtraMetadata{
+		CRI:                   v1.CRIContainerd,
+		Masters:               component.NodeList{{ID: "node1"}},
+		LocalRegistryUsername: "admin",
+		LocalRegistryPassword: password,
+	}
+	stepper := (&cni.CiliumRunnable{}).InitStep(metadata, &v1.CNI{
+		Version:               "1.14.4",
+		LocalRegistry:         "registry.local:5000",
+		SkipImageVerification: true,
+		Cilium:                &v1.Cilium{ExtraSetArgs: []string{"clustermesh.apiserver.etcd.password=" + setPassword}},
+	}, &v1.Networking{})
+	nodes := []v1.StepNode{{ID: "node1"}}
+	steps, err := stepper.InstallSteps(nodes, "")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	steps = append(steps, v1.Step{ID: "login", Name: "login", Nodes: nodes, Commands: []v1.Command{{
+		Type:          v1.CommandShell,
+		ShellCommand:  []string{"login", "--token", token},
+		SensitiveArgs: []int{2},
+		Env:           []v1.EnvVar{{Name: "TOKEN", Value: token, Sensitive: true}, {Name: "USER", Value: "admin"}},
+	}}})
+	op := &v1.Operation{ObjectMeta: metav1.ObjectMeta{Name: "op-secrets"}, Steps: steps}
+	want := op.DeepCopy()
+	t.Cleanup(func() { ForgetSecrets(op.Name) })
+
+	storage := &jsonStorage{}
+	l := &operationOperator{storage: storage}
+	created, err := l.CreateOperation(context.TODO(), op)
+	if err != nil {
+		t.Fatalf("CreateOperation() error = %v", err)
+	}
+	assertNoSecrets := func(stored string) {
+		t.Helper()
+		for _, secret := range []string{password, setPassword, token} {
+			if strings.Contains(stored, secret) {
+				t.Errorf("the stored operation contains the secret %q:\n%s", secret, stored)
+			}
+		}
+		if !strings.Contains(stored, v1.RedactedValue) || !strings.Contains(stored, `"value":"admin"`) {
+			t.Errorf("the stored operation should only redact the sensitive values:\n%s", stored)
+		}
+	}
+	assertNoSecrets(string(storage.stored))
+	if !reflect.DeepEqual(op, want) {
+		t.Errorf("CreateOperation() redacted the secrets of the operation being created")
+	}
+
+	RestoreSecrets(created)
+	if !reflect.DeepEqual(created.Steps, want.Steps) {
+		t.Errorf("RestoreSecrets() did not restore the steps of the operation")
+	}
+	created.Status.Status = v1.OperationStatusRunning
+	if _, err = l.UpdateOperation(context.TODO(), created); err != nil {
+		t.Fatalf("UpdateOperation() error = %v", err)
+	}
+	assertNoSecrets(string(storage.stored))
+
+	// the operation read back from the storage keeps the secrets kept in memory
+	stored := &v1.Operation{}
+	if err = json.Unmarshal(storage.stored, stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.UpdateOperation(context.TODO(), stored); err != nil {
+		t.Fatalf("UpdateOperation() error = %v", err)
+	}
+	RestoreSecrets(stored)
+	if !reflect.DeepEqual(stored.Steps, want.Steps) {
+		t.Errorf("RestoreSecrets() did not restore the steps of the operation read back")
+	}
+
+	ForgetSecrets(op.Name)
+	if err = json.Unmarshal(storage.stored, stored); err != nil {
+		t.Fatal(err)
+	}
+	RestoreSecrets(stored)
+	if !stored.Steps[len(stored.Steps)-1].SecretsRedacted() {
+		t.Errorf("SecretsRedacted() = false, want the secrets lost once they are forgotten")
+	}
+}
+
+func TestSecretSteps_expire(t *testing.T) {
+	now := time.Now()
+	s := &secretSteps{ops: make(map[string]*operationSecrets), now: func() time.Time { return now }}
+	// persist redacts the secrets of the operation name with status, the stored copy is returned
+	persist := func(name string, status v1.OperationStatusType) *v1.Operation {
+		return s.redact(&v1.Operation{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Steps: []v1.Step{{ID: "login", Name: "login", Commands: []v1.Command{{
+				Type: v1.CommandShell,
+				Env:  []v1.EnvVar{{Name: "TOKEN", Value: "token-s3cret", Sensitive: true}},
+			}}}},
+			Status: v1.OperationStatus{Status: status},
+		})
+	}
+	stored := map[string]*v1.Operation{
+		"failed":     persist("failed", v1.OperationStatusFailed),
+		"terminated": persist("terminated", v1.OperationStatusTermination),
+		"retried":    persist("retried", v1.OperationStatusFailed),
+		"running":    persist("running", v1.OperationStatusRunning),
+	}
+	now = now.Add(secretsRetention - time.Minute)
+	// the retry runs the operation again before its secrets expire, the stored copy is persisted as running
+	retried := stored["retried"].DeepCopy()
+	retried.Status.Status = v1.OperationStatusRunning
+	s.redact(retried)
+	now = now.Add(2 * time.Minute)
+	// persisting any operation drops the expired secrets
+	persist("other", v1.OperationStatusRunning)
+
+	for name, want := range map[string]bool{"failed": false, "terminated": false, "retried": true, "running": true} {
+		op := stored[name].DeepCopy()
+		s.restore(op)
+		if got := !op.Steps[0].SecretsRedacted(); got != want {
+			t.Errorf("the secrets of the %s operation restored = %v, want %v", name, got, want)
+		}
+		if _, ok := s.ops[name]; ok != want {
+			t.Errorf("the secrets of the %s operation kept = %v, want %v", name, ok, want)
+		}
+	}
+}
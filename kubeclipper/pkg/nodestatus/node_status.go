@@ -128,6 +128,10 @@ func Metadata() Setter {
 
 func MachineInfo() Setter {
 	return func(node *v1.Node) error {
+		// the server sends the helm commands of the node as v1.CommandHelm once the agent advertises it
+		node.Labels[common.LabelAgentHelmSDK] = "true"
+		// and the readiness waits as v1.CommandWaitFor
+		node.Labels[common.LabelAgentWaitFor] = "true"
 		if node.Status.Capacity == nil {
 			node.Status.Capacity = v1.ResourceList{}
 		}
@@ -30,3 +30,16 @@ type LogContentResponse struct {
 	LogSize      int64  `json:"logSize"`
 	DeliverySize int64  `json:"deliverySize"`
 }
+
+// FileContentRequest requests a chunk of the file Name the steps of the operation OpID wrote into the operation dir.
+type FileContentRequest struct {
+	OpID   string `json:"opID"`
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Length int    `json:"length"`
+}
+
+type FileContentResponse struct {
+	Content  []byte `json:"content"`
+	FileSize int64  `json:"fileSize"`
+}
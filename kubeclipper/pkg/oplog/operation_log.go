@@ -20,6 +20,7 @@ package oplog
 
 import (
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -27,7 +28,12 @@ import (
 	"github.com/kubeclipper/kubeclipper/pkg/utils/fileutil"
 )
 
-const OperationLogSuffix = ".log"
+const (
+	OperationLogSuffix = ".log"
+	// MaximumFileChunk the file chunk size delivered in a message, it stays below the 1MB message limit
+	// once base64 encoded.
+	MaximumFileChunk = 512 << 10
+)
 
 var _ component.OperationLogFile = (*OperationLog)(nil)
 
@@ -149,3 +155,36 @@ func (op *OperationLog) TruncateStepLogFile(opID, stepID string) error {
 	}
 	return os.Truncate(path, 0)
 }
+
+// GetOperationFileContent get a chunk of the file name in the operation dir, the steps write the files the server
+// downloads there, e.g. the support bundles. The chunk is at most MaximumFileChunk bytes to fit in a message.
+func (op *OperationLog) GetOperationFileContent(opID, name string, offset int64, length int) (content []byte, fileSize int64, err error) {
+	if opID == "" || name == "" || filepath.Base(name) != name {
+		err = errors.New("opID or file name is invalid")
+		return
+	}
+	if length <= 0 || length > MaximumFileChunk {
+		length = MaximumFileChunk
+	}
+	f, err := os.Open(filepath.Join(op.cfg.Dir, opID, name))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return
+	}
+	fileSize = stat.Size()
+	if offset >= fileSize {
+		return nil, fileSize, nil
+	}
+	if remain := fileSize - offset; remain < int64(length) {
+		length = int(remain)
+	}
+	content = make([]byte, length)
+	if _, err = f.ReadAt(content, offset); err != nil && err != io.EOF {
+		return nil, fileSize, err
+	}
+	return content, fileSize, nil
+}
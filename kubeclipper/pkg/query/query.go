@@ -56,6 +56,11 @@ const (
 	ParameterSubDomain            = "subdomain"
 	ParameterFuzzySearch          = "fuzzy"
 	ParameterForce                = "force"
+	ParameterConfirm              = "confirm"
+	ParameterMaxSize              = "maxSize"
+	ParameterSince                = "since"
+	ParameterKeepVersions         = "keepVersions"
+	ParameterCursor               = "cursor"
 )
 
 const (
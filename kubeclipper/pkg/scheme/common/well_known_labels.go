@@ -22,6 +22,8 @@ const (
 	LabelHostname           = "kubeclipper.io/hostname"
 	LabelOSStable           = "kubeclipper.io/os"
 	LabelArchStable         = "kubeclipper.io/arch"
+	LabelAgentHelmSDK       = "kubeclipper.io/agent-helm-sdk"
+	LabelAgentWaitFor       = "kubeclipper.io/agent-wait-for"
 	LabelTopologyZone       = "topology.kubeclipper.io/zone"
 	LabelTopologyRegion     = "topology.kubeclipper.io/region"
 	LabelNodeRole           = "kubeclipper.io/nodeRole"
@@ -36,6 +38,7 @@ const (
 	LabelOperationRetry     = "kubeclipper.io/operation-retry-times"
 	LabelOperationIntent    = "kubeclipper.io/operation-intent"
 	LabelOperationSponsor   = "kubeclipper.io/operation-sponsor"
+	LabelOperationConfirm   = "kubeclipper.io/operation-confirm"
 	LabelTimeoutSeconds     = "kubeclipper.io/timeout"
 	LabelRoleTemplate       = "kubeclipper.io/role-template"
 	LabelHidden             = "kubeclipper.io/hidden"
@@ -69,6 +72,11 @@ const (
 	AnnotationMetadataProxyAPIServer = "metadata.kubeclipper.io/proxyAPIServer"
 	AnnotationMetadataProxySSH       = "metadata.kubeclipper.io/proxySSH"
 
+	// AnnotationCNIUpgradeConfirm set to false makes the cni upgrades of the cluster wait for the approval of their diff
+	AnnotationCNIUpgradeConfirm = "kubeclipper.io/cni-upgrade-confirm"
+	// AnnotationExcludedNodes the JSON list of the nodes the steps of the operation skip because they are
+	// unavailable and the reasons, see cni.ExcludedNode.
+	AnnotationExcludedNodes = "kubeclipper.io/excluded-nodes"
 	// AnnotationOnlyInstallKubernetesComp mean not install cni when create cluster
 	AnnotationOnlyInstallKubernetesComp = "kubeclipper.io/only-install-kubernetes-component"
 )
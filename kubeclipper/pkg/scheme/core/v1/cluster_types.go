@@ -19,6 +19,10 @@
 package v1
 
 import (
+	"bytes"
+	"encoding/json"
+	"net"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -88,6 +92,16 @@ type ClusterVersionsStatus struct {
 	// Scheduler is the currently desired version of the kube-scheduler. This field behaves the
 	// same as the apiserver field.
 	Scheduler string `json:"scheduler"`
+	// CNI is the currently installed version of the cni, a different spec version triggers the cni upgrade.
+	CNI string `json:"cni,omitempty"`
+	// CNIType is the currently installed cni, a different spec type triggers the cni migration.
+	CNIType string `json:"cniType,omitempty"`
+	// CNIPlacement is the hash of the cni DaemonSet placement applied to the cluster, a different spec placement
+	// triggers the cni upgrade of the installed version.
+	CNIPlacement string `json:"cniPlacement,omitempty"`
+	// CNIBGP is the hash of the cni BGP peerings applied to the cluster, a different spec hash re-applies the
+	// peerings without upgrading the cni.
+	CNIBGP string `json:"cniBGP,omitempty"`
 }
 
 type ClusterPhase string
@@ -134,6 +148,61 @@ type ClusterStatus struct {
 	Registries []RegistrySpec `json:"registries,omitempty"`
 	// ControlPlane Health
 	ControlPlaneHealth []ControlPlaneHealth `json:"controlPlaneHealth,omitempty"`
+	// CNIRelease what the last cni install, upgrade or migration deployed, empty for the cni not installed by helm.
+	// +optional
+	CNIRelease *CNIReleaseRecord `json:"cniRelease,omitempty"`
+	// Conditions the latest observed conditions of the cluster, e.g. CNIReady.
+	// +optional
+	Conditions []ClusterCondition `json:"conditions,omitempty"`
+}
+
+// GetCondition returns the condition of type t, nil when it was never observed.
+func (s *ClusterStatus) GetCondition(t ClusterConditionType) *ClusterCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == t {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+type ClusterConditionType string
+
+// ClusterCNIReady whether the cni workloads are ready and its release is deployed, tracked by the server.
+const ClusterCNIReady ClusterConditionType = "CNIReady"
+
+// ClusterCondition contains condition information for a cluster.
+type ClusterCondition struct {
+	Type ClusterConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown.
+	Status ConditionStatus `json:"status"`
+	// Last time the condition transit from one status to another.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// (brief) reason for the condition's last transition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Human readable message indicating details about last transition.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// StepRecordCNIRelease the name of the step which responds with the CNIReleaseRecord of the installed cni release.
+const StepRecordCNIRelease = "recordCNIRelease"
+
+// CNIReleaseRecord the helm command and values a cni release was deployed with, the sensitive values are redacted.
+type CNIReleaseRecord struct {
+	Release   string `json:"release"`
+	Namespace string `json:"namespace"`
+	// Command the helm command the release was installed or upgraded with.
+	Command []string `json:"command"`
+	// Values the values file the release was installed or upgraded with.
+	Values string `json:"values"`
+	// ValuesSHA256 the sha256 of the values file before the redaction.
+	ValuesSHA256 string `json:"valuesSHA256"`
+	// History the output of helm history after the release was installed or upgraded.
+	// +optional
+	History string `json:"history,omitempty"`
 }
 
 type ControlPlaneHealth struct {
@@ -150,6 +219,22 @@ func (c *Cluster) Offline() bool {
 	return false
 }
 
+// ExternalAPIServerEndpoint the host:port of the load balancer in front of the kube-apiservers set by the external
+// domain or IP labels, the domain is preferred and the port defaults to 6443. Empty when the cluster has none.
+func (c *Cluster) ExternalAPIServerEndpoint() string {
+	host, port := c.Labels[common.LabelExternalDomain], c.Labels[common.LabelExternalDomainPort]
+	if host == "" {
+		host, port = c.Labels[common.LabelExternalIP], c.Labels[common.LabelExternalPort]
+	}
+	if host == "" {
+		return ""
+	}
+	if port == "" {
+		port = "6443"
+	}
+	return net.JoinHostPort(host, port)
+}
+
 // GetAllCertSANs if api server set externalIP,use it as certSans
 func (c *Cluster) GetAllCertSANs() []string {
 	list := c.CertSANs
@@ -177,7 +262,13 @@ func (c *Cluster) Complete() {
 	c.CNI.LocalRegistry = c.LocalRegistry
 	c.CNI.CriType = c.ContainerRuntime.Type
 	c.CNI.Offline = c.Offline()
-	if common.IsKubeVersionGreater(c.KubernetesVersion, 126) {
+	if c.CNI.Type == "flannel" {
+		// the flannel manifests deploy flannel in their own namespace
+		c.CNI.Namespace = "kube-flannel"
+	} else if c.CNI.Type == "kube-ovn" {
+		// the kube-ovn chart deploys kube-ovn in kube-system whatever the release namespace is
+		c.CNI.Namespace = "kube-system"
+	} else if common.IsKubeVersionGreater(c.KubernetesVersion, 126) {
 		c.CNI.Namespace = "calico-system"
 	} else {
 		c.CNI.Namespace = "kube-system"
@@ -250,19 +341,103 @@ type Networking struct {
 }
 
 var (
-	AllowedCNI = sets.NewString("calico", "cilium")
+	AllowedCNI = sets.NewString("calico", "cilium", "flannel", "kube-ovn")
 )
 
 type CNI struct {
 	LocalRegistry string `json:"localRegistry" optional:"true"`
 	// TODO: Cluster multiple cni plugins are not supported at this time
-	Type      string  `json:"type" enum:"calico|cilium"`
-	Version   string  `json:"version"`
-	CriType   string  `json:"criType"`
-	Offline   bool    `json:"offline"`
-	Namespace string  `json:"namespace"`
-	Calico    *Calico `json:"calico" optional:"true"`
-	Cilium    *Cilium `json:"cilium" optional:"true"`
+	Type      string   `json:"type" enum:"calico|cilium|flannel|kube-ovn"`
+	Version   string   `json:"version"`
+	CriType   string   `json:"criType"`
+	Offline   bool     `json:"offline"`
+	Namespace string   `json:"namespace"`
+	Calico    *Calico  `json:"calico" optional:"true"`
+	Cilium    *Cilium  `json:"cilium" optional:"true"`
+	Flannel   *Flannel `json:"flannel" optional:"true"`
+	KubeOvn   *KubeOvn `json:"kubeOvn" optional:"true"`
+	// ChartSource the OCI repository the cni chart is pulled from by helm, e.g. oci://harbor.example.com/charts.
+	// The chart is downloaded from the package server when it is empty or the cni is offline.
+	ChartSource string `json:"chartSource,omitempty" optional:"true"`
+	// Timeouts the timeouts of cni operations, between 30s and 1h, unset ones use the built-in defaults.
+	// Install is passed to helm as --timeout and the step timeout adds a buffer on top of it.
+	Timeouts *CNITimeouts `json:"timeouts,omitempty" optional:"true"`
+	// RetryPolicy the retries of the cilium steps, unset fields use the defaults of 3 retries after 10s doubling the delay.
+	RetryPolicy *CNIRetryPolicy `json:"retryPolicy,omitempty" optional:"true"`
+	// SkipImageVerification skips checking LocalRegistry has the cni images before offline installs,
+	// for the registries which do not serve the registry API to the nodes.
+	SkipImageVerification bool `json:"skipImageVerification,omitempty" optional:"true"`
+	// SkipCompatibilityCheck installs the cni version on a kubernetes version out of the range it supports,
+	// the incompatibility is only logged.
+	SkipCompatibilityCheck bool `json:"skipCompatibilityCheck,omitempty" optional:"true"`
+	// PushToRegistry loads the offline images on one node and pushes them to LocalRegistry during the install,
+	// the other nodes pull them from the registry. The credentials of the registry are taken from the platform registry settings.
+	PushToRegistry bool `json:"pushToRegistry,omitempty" optional:"true"`
+	// RemovePushedImages deletes the images pushed by PushToRegistry from LocalRegistry when the cni is uninstalled.
+	RemovePushedImages bool `json:"removePushedImages,omitempty" optional:"true"`
+	// Purge deletes the CRDs and custom resources of cilium when it is uninstalled, helm keeps them.
+	// The purge operation deletes them after an uninstall without Purge.
+	Purge bool `json:"purge,omitempty" optional:"true"`
+	// RemoveHelm removes the helm binary kubeclipper installed on the nodes when the cni is uninstalled,
+	// the cni is the last component kubeclipper installs with helm.
+	RemoveHelm bool `json:"removeHelm,omitempty" optional:"true"`
+	// LocalRegistryConfig how the nodes reach LocalRegistry, for the registries with self-signed certificates or authentication.
+	LocalRegistryConfig *CNIRegistryConfig `json:"localRegistryConfig,omitempty" optional:"true"`
+	// EnableMultus installs multus in front of the cni, the cni stays the default network of the pods
+	// and the pods attach the NetworkAttachments as additional networks.
+	EnableMultus bool `json:"enableMultus,omitempty" optional:"true"`
+	// NetworkAttachments the NetworkAttachmentDefinitions applied once multus is installed, requires EnableMultus.
+	NetworkAttachments []NetworkAttachment `json:"networkAttachments,omitempty" optional:"true"`
+	// RestartWorkloads restarts the pods of the workloads node by node once the cilium install or upgrade is ready,
+	// the pods created on the previous cni or network configuration keep it until they are restarted.
+	// The workloads are left to the user when it is unset.
+	RestartWorkloads *CNIRestartWorkloads `json:"restartWorkloads,omitempty" optional:"true"`
+	// HelmExecutor the ID of the master the helm release of the cni is installed from, the first ready master when
+	// it is empty. The steps fail over to the other ready masters when the agent of the node does not respond.
+	HelmExecutor string `json:"helmExecutor,omitempty" optional:"true"`
+}
+
+type CNIRestartWorkloads struct {
+	// Concurrency the nodes drained at once, defaults to 1.
+	Concurrency int `json:"concurrency,omitempty" optional:"true"`
+	// GracePeriod the termination grace period of the evicted pods, the one of the pods is used when it is unset.
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty" optional:"true"`
+	// Timeout the time to drain a node, between 30s and 1h, defaults to 10m. The evictions respect the
+	// PodDisruptionBudgets, the drain fails when they block it for longer.
+	Timeout metav1.Duration `json:"timeout,omitempty" optional:"true"`
+}
+
+type NetworkAttachment struct {
+	Name string `json:"name"`
+	// Namespace defaults to default.
+	Namespace string `json:"namespace,omitempty" optional:"true"`
+	// Config the cni configuration of the network in JSON.
+	Config string `json:"config"`
+}
+
+type CNIRegistryConfig struct {
+	// InsecureSkipTLSVerify skips verifying the certificate of LocalRegistry.
+	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify,omitempty" optional:"true"`
+	// CABundleRef the name of the ConfigMap holding the CA bundle of LocalRegistry under the ca.crt key.
+	CABundleRef string `json:"caBundleRef,omitempty" optional:"true"`
+	// CredentialsRef the name of the ConfigMap holding the username and password of LocalRegistry under the
+	// username and password keys, the credentials of the platform registry settings are used when it is empty.
+	CredentialsRef string `json:"credentialsRef,omitempty" optional:"true"`
+}
+
+type CNIRetryPolicy struct {
+	// RetryTimes the retries of a failed step, between 0 and 10.
+	RetryTimes *int32 `json:"retryTimes,omitempty" optional:"true"`
+	// RetryInterval the delay before the first retry, at most 5m.
+	RetryInterval *metav1.Duration `json:"retryInterval,omitempty" optional:"true"`
+	// BackoffFactor the growth of the delay between retries, between 0 and 10, 0 means the default.
+	BackoffFactor int32 `json:"backoffFactor,omitempty" optional:"true"`
+}
+
+type CNITimeouts struct {
+	Install   metav1.Duration `json:"install,omitempty" optional:"true"`
+	Uninstall metav1.Duration `json:"uninstall,omitempty" optional:"true"`
+	ImageLoad metav1.Duration `json:"imageLoad,omitempty" optional:"true"`
 }
 
 type Calico struct {
@@ -271,14 +446,297 @@ type Calico struct {
 	Mode              string `json:"mode" enum:"BGP|Overlay-IPIP-All|Overlay-IPIP-Cross-Subnet|Overlay-Vxlan-All|Overlay-Vxlan-Cross-Subnet|overlay"`
 	IPManger          bool   `json:"IPManger" optional:"true"`
 	MTU               int    `json:"mtu"`
+	// DataplaneMode defaults to iptables, "ebpf" switches calico to the eBPF dataplane which replaces kube-proxy.
+	DataplaneMode string `json:"dataplaneMode,omitempty" enum:"iptables|ebpf" optional:"true"`
+	// TyphaReplicas deploys typha in front of the apiserver with the replicas, typha is not deployed when it is 0.
+	TyphaReplicas int `json:"typhaReplicas,omitempty" optional:"true"`
+	// BlockSize the prefix length of the IPv4 address blocks allocated to the nodes, defaults to 26.
+	BlockSize int `json:"blockSize,omitempty" optional:"true"`
+}
+
+type Flannel struct {
+	// Backend the flannel backend, defaults to vxlan. host-gw requires the nodes to share a layer 2 network.
+	Backend string `json:"backend,omitempty" enum:"vxlan|host-gw|wireguard" optional:"true"`
+}
+
+type KubeOvn struct {
+	// JoinCIDR the join subnet connecting the nodes with the pods, defaults to 100.64.0.0/16.
+	JoinCIDR string `json:"joinCIDR,omitempty" optional:"true"`
+	// JoinIPv6CIDR the IPv6 join subnet of dual-stack clusters, defaults to fd00:100:64::/112.
+	JoinIPv6CIDR string `json:"joinIPv6CIDR,omitempty" optional:"true"`
+	// TunnelType the tunnel between the nodes, defaults to geneve.
+	TunnelType string `json:"tunnelType,omitempty" enum:"geneve|vxlan|stt" optional:"true"`
+}
+
+// CiliumKubeProxyReplacement the kube-proxy replacement mode of cilium. The charts before cilium 1.14 take the
+// modes disabled, partial, probe and strict, the charts from cilium 1.15 take true and false and cilium 1.14 takes
+// both, either mode is translated to the one of the chart version.
+type CiliumKubeProxyReplacement string
+
+// UnmarshalJSON accepts the JSON booleans of the specs which set kubeProxyReplacement like the helm value.
+func (r *CiliumKubeProxyReplacement) UnmarshalJSON(data []byte) error {
+	if b := bytes.TrimSpace(data); bytes.Equal(b, []byte("true")) || bytes.Equal(b, []byte("false")) {
+		*r = CiliumKubeProxyReplacement(b)
+		return nil
+	}
+	var mode string
+	if err := json.Unmarshal(data, &mode); err != nil {
+		return err
+	}
+	*r = CiliumKubeProxyReplacement(mode)
+	return nil
 }
 
 type Cilium struct {
 	IPAMMode                   string   `json:"ipamMode"`
 	ClusterPoolIPv4PodCIDRList []string `json:"clusterPoolIPv4PodCIDRList"`
 	ClusterPoolIPv4MaskSize    int      `json:"clusterPoolIPv4MaskSize"`
-	KubeProxyReplacement       string   `json:"kubeProxyReplacement"`
-	OperatorReplicas           int      `json:"operatorReplicas"`
+	// ClusterPoolIPv6PodCIDRList defaults to the IPv6 pod CIDR of Networking on IPv6 clusters.
+	ClusterPoolIPv6PodCIDRList []string `json:"clusterPoolIPv6PodCIDRList" optional:"true"`
+	ClusterPoolIPv6MaskSize    int      `json:"clusterPoolIPv6MaskSize" optional:"true"`
+	// KubeProxyReplacement the kube-proxy replacement of cilium, the cni renders the mode in the representation of
+	// the chart version, see CiliumKubeProxyReplacement.
+	KubeProxyReplacement CiliumKubeProxyReplacement `json:"kubeProxyReplacement" enum:"disabled|partial|probe|strict|true|false"`
+	OperatorReplicas     int                        `json:"operatorReplicas"`
+	// EnableHubble enables the hubble observability layer on cilium agents.
+	EnableHubble bool `json:"enableHubble" optional:"true"`
+	// EnableHubbleRelay deploys hubble-relay, requires EnableHubble.
+	EnableHubbleRelay bool `json:"enableHubbleRelay" optional:"true"`
+	// EnableHubbleUI deploys hubble-ui, requires EnableHubble and implies EnableHubbleRelay.
+	EnableHubbleUI bool `json:"enableHubbleUI" optional:"true"`
+	// TunnelMode defaults to vxlan, "disabled" switches cilium to native routing.
+	TunnelMode string `json:"tunnelMode,omitempty" enum:"vxlan|geneve|disabled" optional:"true"`
+	// NativeRoutingCIDR the CIDR in which native routing can be performed, only used when TunnelMode is disabled.
+	// Defaults to the IPv4 pod CIDR of Networking.
+	NativeRoutingCIDR string `json:"nativeRoutingCIDR,omitempty" optional:"true"`
+	// CNIChainingMode runs cilium chained to the cni already installed on the nodes, the chained cni allocates the
+	// pod IPs and routes them so that the cluster pool and the tunnel settings must be empty.
+	// "aws-cni" chains to the AWS VPC CNI, "generic-veth" chains to any veth based cni and requires CustomCNIConf.
+	CNIChainingMode string `json:"cniChainingMode,omitempty" optional:"true" enum:"aws-cni|generic-veth"`
+	// CustomCNIConf the ConfigMap in the cilium namespace whose cni-config key holds the chained CNI configuration
+	// list written by cilium instead of its own, e.g. the conflist of the veth cni with the cilium-cni plugin appended.
+	CustomCNIConf string `json:"customCNIConf,omitempty" optional:"true"`
+	// Encryption enables transparent pod-to-pod encryption, disabled when it is nil.
+	Encryption *CiliumEncryption `json:"encryption,omitempty" optional:"true"`
+	// SkipReadinessCheck skips waiting for the cilium agent and operator rollout after install.
+	SkipReadinessCheck bool `json:"skipReadinessCheck,omitempty" optional:"true"`
+	// ReadinessCheckTimeout the rollout wait timeout of the readiness check, defaults to 5m.
+	ReadinessCheckTimeout *metav1.Duration `json:"readinessCheckTimeout,omitempty" optional:"true"`
+	// ForcePreflight installs cilium on the nodes failing the kernel, cgroup and bpf filesystem preflight checks,
+	// the failures are logged as warnings.
+	ForcePreflight bool `json:"forcePreflight,omitempty" optional:"true"`
+	// Profile the base values the generated values are merged over: minimal, default or production,
+	// defaults to default. The fields above win over the profile and HelmValues win over both.
+	Profile string `json:"profile,omitempty" optional:"true"`
+	// HelmValues raw helm values in YAML, deep-merged over the values generated from the fields above.
+	// User values win on conflicts: maps are merged recursively, lists and scalars are replaced wholesale
+	// and a null value deletes the generated key.
+	HelmValues string `json:"helmValues,omitempty" optional:"true"`
+	// OperatorResources the cilium-operator container resources, chart defaults apply when unset.
+	OperatorResources *CiliumOperatorResources `json:"operatorResources,omitempty" optional:"true"`
+	// OperatorNodeSelector the node labels the cilium-operator pods are scheduled to.
+	OperatorNodeSelector map[string]string `json:"operatorNodeSelector,omitempty" optional:"true"`
+	// OperatorTolerations the tolerations of the cilium-operator pods.
+	OperatorTolerations []CiliumToleration `json:"operatorTolerations,omitempty" optional:"true"`
+	// NodeSelector the node labels the cilium agent DaemonSet is scheduled to, the offline images are only loaded
+	// on the matching nodes. Changing it on a running cluster is applied by the cni upgrade.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty" optional:"true"`
+	// Tolerations the tolerations of the cilium agent pods, the chart tolerates every taint when it is empty.
+	Tolerations []CiliumToleration `json:"tolerations,omitempty" optional:"true"`
+	// ReleaseName the helm release name of cilium, defaults to cilium.
+	ReleaseName string `json:"releaseName,omitempty" optional:"true"`
+	// EnableBandwidthManager enforces the kubernetes.io/egress-bandwidth pod annotation with eBPF.
+	EnableBandwidthManager bool `json:"enableBandwidthManager,omitempty" optional:"true"`
+	// EnableBBR switches pod TCP congestion control to BBR, requires EnableBandwidthManager and kernel >= 5.18 on every node.
+	EnableBBR bool `json:"enableBBR,omitempty" optional:"true"`
+	// LoadBalancerMode how the service load balancer forwards to the remote backends, defaults to the chart default snat.
+	// "dsr" and "hybrid" require the native routing and the kube-proxy replacement.
+	LoadBalancerMode string `json:"loadBalancerMode,omitempty" optional:"true" enum:"snat|dsr|hybrid"`
+	// LoadBalancerAlgorithm the backend selection of the service load balancer, defaults to the chart default random.
+	// "maglev" requires the kube-proxy replacement.
+	LoadBalancerAlgorithm string `json:"loadBalancerAlgorithm,omitempty" optional:"true" enum:"random|maglev"`
+	// SocketLB translates the service addresses in the socket calls of the pods instead of per packet.
+	SocketLB bool `json:"socketLB,omitempty" optional:"true"`
+	// ExtraSetArgs key=value pairs passed to helm as --set after the values file, e.g. debug.enabled=true.
+	ExtraSetArgs []string `json:"extraSetArgs,omitempty" optional:"true"`
+	// MTU the MTU of the pod network, cilium derives it from the node devices when it is 0.
+	MTU int `json:"mtu,omitempty" optional:"true"`
+	// AutoDetectMTU sets the MTU to the smallest MTU of the default route interfaces of the nodes
+	// less the tunnel overhead, it can not be set together with MTU.
+	AutoDetectMTU bool `json:"autoDetectMTU,omitempty" optional:"true"`
+	// Metrics exposes the cilium and hubble metrics to prometheus, disabled when it is nil.
+	Metrics *CiliumMetrics `json:"metrics,omitempty" optional:"true"`
+	// ClusterMesh the identity of the cluster in a cilium cluster mesh, the cluster is not meshed when it is nil.
+	ClusterMesh *CiliumClusterMesh `json:"clusterMesh,omitempty" optional:"true"`
+	// CLIMirror the http server the cilium CLI is downloaded from instead of the package server,
+	// it has the layout of the package server, e.g. http://mirror.example.com/kc.
+	CLIMirror string `json:"cliMirror,omitempty" optional:"true"`
+	// PolicyEnforcementMode the cilium network policy enforcement mode, defaults to the chart default "default".
+	// "always" denies the traffic of every endpoint without an allow policy, including kube-system.
+	PolicyEnforcementMode string `json:"policyEnforcementMode,omitempty" optional:"true" enum:"default|always|never"`
+	// PolicyAuditMode logs the traffic the policies would drop instead of dropping it.
+	PolicyAuditMode bool `json:"policyAuditMode,omitempty" optional:"true"`
+	// IdentityAllocationMode how cilium allocates the security identities, defaults to crd.
+	// "kvstore" stores the identities in the etcd of KVStore.
+	IdentityAllocationMode string `json:"identityAllocationMode,omitempty" optional:"true" enum:"crd|kvstore"`
+	// KVStore the etcd cilium allocates the identities in, required when IdentityAllocationMode is kvstore.
+	KVStore *CiliumKVStore `json:"kvstore,omitempty" optional:"true"`
+	// EnableL2Announcements announces the LoadBalancer and external IPs of the services to the node network
+	// with ARP and NDP instead of MetalLB, requires the kube-proxy replacement and cilium >= 1.14.
+	EnableL2Announcements bool `json:"enableL2Announcements,omitempty" optional:"true"`
+	// LoadBalancerIPPools the CIDRs the cilium LB-IPAM allocates the LoadBalancer service IPs from,
+	// they must not overlap the pod and service CIDRs nor the node addresses.
+	LoadBalancerIPPools []string `json:"loadBalancerIPPools,omitempty" optional:"true"`
+	// BGP the cilium BGP control plane peering the nodes with the network fabric, disabled when it is nil.
+	BGP *CiliumBGP `json:"bgp,omitempty" optional:"true"`
+	// EnableHostFirewall enforces the CiliumClusterwideNetworkPolicies selecting the nodes on the node ports,
+	// a baseline policy keeps SSH, the kubelet, the kube-apiserver and the kubeclipper agent reachable.
+	EnableHostFirewall bool `json:"enableHostFirewall,omitempty" optional:"true"`
+	// EnableEgressGateway routes the traffic selected by the egress gateway policies through gateway nodes with
+	// a fixed source IP, requires the kube-proxy replacement. The BPF masquerading is enabled with it.
+	EnableEgressGateway bool `json:"enableEgressGateway,omitempty" optional:"true"`
+	// EgressGatewayPolicies the CiliumEgressGatewayPolicies applied after the install, requires EnableEgressGateway.
+	EgressGatewayPolicies []CiliumEgressGatewayPolicy `json:"egressGatewayPolicies,omitempty" optional:"true"`
+	// Pools switches the IPAM to multi-pool, every pool allocates the pod CIDRs of its nodes with its own mask size.
+	// The pool named default serves the pods without the ipam.cilium.io/ip-pool annotation, it is required and
+	// has no node selector. Pools require cilium >= 1.14, the native routing and empty cluster pool lists.
+	Pools []CiliumPodIPPool `json:"pools,omitempty" optional:"true"`
+	// EnableDNSProxy enables the L7 proxy with the DNS proxy the toFQDNs policies learn the IPs of the names from.
+	// The proxy interposes on the DNS of the pods, the readiness check probes the DNS resolution with it.
+	EnableDNSProxy bool `json:"enableDNSProxy,omitempty" optional:"true"`
+	// DNSProxyConfig tunes the DNS proxy, the chart defaults apply when it is nil. It requires EnableDNSProxy.
+	DNSProxyConfig *CiliumDNSProxy `json:"dnsProxyConfig,omitempty" optional:"true"`
+	// AgentResources the cilium agent container resources, chart defaults apply when unset. Changing it on a
+	// running cluster is applied by the cni upgrade.
+	AgentResources *CiliumOperatorResources `json:"agentResources,omitempty" optional:"true"`
+	// PriorityClassName the priority class of the cilium agent pods, defaults to system-node-critical so that
+	// the agents are the last pods evicted under node pressure.
+	PriorityClassName string `json:"priorityClassName,omitempty" optional:"true"`
+	// Debug enables the debug logs of the cilium agents and operator. The debug operations of the cni switch it
+	// on a running release without changing the other values. LogLevel takes precedence when it is set.
+	Debug bool `json:"debug,omitempty" optional:"true"`
+	// MonitorAggregation the aggregation of the events cilium monitor and hubble show, the chart default applies
+	// when it is empty.
+	MonitorAggregation string `json:"monitorAggregation,omitempty" enum:"none|low|medium|maximum" optional:"true"`
+	// LogLevel the log level of the cilium agents and operator, info by default. debug is the same as Debug,
+	// trace also enables the verbose debug logs of the datapath, policy, kvstore, envoy and flow subsystems.
+	LogLevel string `json:"logLevel,omitempty" enum:"info|debug|trace" optional:"true"`
+}
+
+// CiliumDNSProxy the settings of the cilium DNS proxy.
+type CiliumDNSProxy struct {
+	// MinTTL the seconds the IPs of a DNS answer are allowed by the toFQDNs policies at least, the shorter TTLs
+	// of the answers are raised to it.
+	MinTTL int `json:"minTTL,omitempty" optional:"true"`
+	// PreCache the path of a DNS cache file on the nodes the proxy is filled with on start, e.g. to allow the
+	// names resolved before an agent restart.
+	PreCache string `json:"preCache,omitempty" optional:"true"`
+	// RejectResponseCode the response code of the DNS queries denied by the policies, defaults to the chart
+	// default refused.
+	RejectResponseCode string `json:"rejectResponseCode,omitempty" optional:"true" enum:"refused|nameError"`
+}
+
+type CiliumPodIPPool struct {
+	// Name the name of the generated CiliumPodIPPool.
+	Name string `json:"name"`
+	// CIDR the IPv4 or IPv6 CIDR of the pool.
+	CIDR string `json:"cidr"`
+	// MaskSize the mask size of the pod CIDRs the nodes allocate from the pool, it must be larger than the
+	// prefix length of CIDR.
+	MaskSize int `json:"maskSize"`
+	// NodeSelector the labels of the nodes which allocate a pod CIDR of the pool ahead of its pods,
+	// the other nodes allocate one when a pod requests the pool.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty" optional:"true"`
+}
+
+type CiliumBGP struct {
+	// Enabled enables the BGP control plane, it requires at least one peering.
+	Enabled bool `json:"enabled"`
+	// Peerings the BGP sessions every node establishes, the peerings of the same LocalASN form one virtual router
+	// and must advertise the same CIDRs.
+	Peerings []CiliumBGPPeering `json:"peerings,omitempty" optional:"true"`
+}
+
+type CiliumBGPPeering struct {
+	// PeerAddress the IP address of the BGP peer.
+	PeerAddress string `json:"peerAddress"`
+	PeerASN     int64  `json:"peerASN"`
+	LocalASN    int64  `json:"localASN"`
+	// ExportPodCIDR advertises the pod CIDR of each node.
+	ExportPodCIDR bool `json:"exportPodCIDR,omitempty" optional:"true"`
+	// ServiceSelector the LoadBalancer services whose IPs are advertised, none are advertised when it is nil
+	// and every one when it is empty.
+	ServiceSelector *metav1.LabelSelector `json:"serviceSelector,omitempty" optional:"true"`
+}
+
+type CiliumEgressGatewayPolicy struct {
+	// Name the name of the generated CiliumEgressGatewayPolicy.
+	Name string `json:"name"`
+	// Namespaces the namespaces whose pods leave the cluster through the gateway.
+	Namespaces []string `json:"namespaces"`
+	// EgressNodeLabels the labels selecting the gateway node, the first matching node is the gateway.
+	EgressNodeLabels map[string]string `json:"egressNodeLabels"`
+	// EgressIP the source IP of the egress traffic, it must be an IPv4 address of the gateway node.
+	EgressIP string `json:"egressIP"`
+	// DestinationCIDRs the destinations routed through the gateway, defaults to 0.0.0.0/0.
+	DestinationCIDRs []string `json:"destinationCIDRs,omitempty" optional:"true"`
+}
+
+type CiliumKVStore struct {
+	// Endpoints the https endpoints of the etcd, e.g. https://10.0.0.1:2379.
+	Endpoints []string `json:"endpoints"`
+	// CAFile the path of the etcd CA certificate on the first master node.
+	CAFile string `json:"caFile"`
+	// CertFile the path of the etcd client certificate on the first master node.
+	CertFile string `json:"certFile"`
+	// KeyFile the path of the etcd client key on the first master node.
+	KeyFile string `json:"keyFile"`
+	// Force allows the kvstore mode on clusters with fewer than 3 control-plane nodes.
+	Force bool `json:"force,omitempty" optional:"true"`
+}
+
+type CiliumClusterMesh struct {
+	// ClusterName the name of the cluster in the mesh, unique across the clusters sharing the mesh.
+	ClusterName string `json:"clusterName"`
+	// ClusterID the id of the cluster in the mesh, 1-255 and unique across the clusters sharing the mesh.
+	ClusterID int `json:"clusterID"`
+	// EnableClusterMesh deploys the clustermesh-apiserver the other clusters of the mesh connect to.
+	EnableClusterMesh bool `json:"enableClusterMesh,omitempty" optional:"true"`
+	// APIServerServiceType the service type of the clustermesh-apiserver, defaults to NodePort.
+	APIServerServiceType string `json:"apiServerServiceType,omitempty" optional:"true" enum:"NodePort|LoadBalancer"`
+}
+
+type CiliumMetrics struct {
+	// EnablePrometheus exposes the metrics of the cilium agent and operator.
+	EnablePrometheus bool `json:"enablePrometheus,omitempty" optional:"true"`
+	// EnableServiceMonitor deploys the prometheus operator ServiceMonitors of the enabled metrics,
+	// the monitoring.coreos.com CRDs must be installed in the cluster.
+	EnableServiceMonitor bool `json:"enableServiceMonitor,omitempty" optional:"true"`
+	// HubbleMetrics the hubble metrics to enable, e.g. dns, drop, tcp, flow, requires EnableHubble.
+	HubbleMetrics []string `json:"hubbleMetrics,omitempty" optional:"true"`
+}
+
+// CiliumOperatorResources resource quantities keyed by resource name, e.g. cpu: 100m, memory: 128Mi, of the
+// cilium-operator or the cilium agent container.
+type CiliumOperatorResources struct {
+	Requests map[string]string `json:"requests,omitempty" optional:"true"`
+	Limits   map[string]string `json:"limits,omitempty" optional:"true"`
+}
+
+// CiliumToleration mirrors the core/v1 pod toleration.
+type CiliumToleration struct {
+	Key               string `json:"key,omitempty" optional:"true"`
+	Operator          string `json:"operator,omitempty" optional:"true" enum:"Exists|Equal"`
+	Value             string `json:"value,omitempty" optional:"true"`
+	Effect            string `json:"effect,omitempty" optional:"true" enum:"NoSchedule|PreferNoSchedule|NoExecute"`
+	TolerationSeconds *int64 `json:"tolerationSeconds,omitempty" optional:"true"`
+}
+
+type CiliumEncryption struct {
+	Type string `json:"type" enum:"wireguard|ipsec"`
+	// KeySecretName the secret storing the ipsec keys, defaults to cilium-ipsec-keys.
+	// The secret is generated before install when it does not exist.
+	KeySecretName string `json:"keySecretName,omitempty" optional:"true"`
 }
 
 type Etcd struct {
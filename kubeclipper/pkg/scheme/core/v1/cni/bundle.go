@@ -0,0 +1,37 @@
+package cni
+
+import (
+	"fmt"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+// BundleImages returns the images of the air-gap bundle of the cni version. Every optional component of the cni
+// is enabled, so that the offline installs of the version find their images whatever they enable.
+func BundleImages(cniType, version string) ([]string, error) {
+	factory, err := Load(cniType)
+	if err != nil {
+		return nil, fmt.Errorf("cni %s: %w", cniType, err)
+	}
+	cni := &v1.CNI{
+		Type:    cniType,
+		Version: version,
+		Offline: true,
+		Calico:  &v1.Calico{},
+		KubeOvn: &v1.KubeOvn{},
+		Cilium: &v1.Cilium{
+			EnableHubble:      true,
+			EnableHubbleRelay: true,
+			EnableHubbleUI:    true,
+			ClusterMesh:       &v1.CiliumClusterMesh{EnableClusterMesh: true},
+		},
+	}
+	networking := &v1.Networking{
+		IPFamily: v1.IPFamilyIPv4,
+		Services: v1.NetworkRanges{CIDRBlocks: []string{"10.96.0.0/12"}},
+		Pods:     v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}},
+	}
+	stepper := factory.Create().InitStep(&component.ExtraMetadata{CRI: v1.CRIContainerd}, cni, networking)
+	return stepper.GetImages(version, v1.CRIContainerd)
+}
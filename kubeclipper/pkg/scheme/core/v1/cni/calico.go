@@ -1,22 +1,28 @@
 package cni
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/kubeclipper/kubeclipper/pkg/component"
 	"github.com/kubeclipper/kubeclipper/pkg/component/common"
+	"github.com/kubeclipper/kubeclipper/pkg/component/utils"
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
 	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
 	"github.com/kubeclipper/kubeclipper/pkg/utils/fileutil"
 	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
 	tmplutil "github.com/kubeclipper/kubeclipper/pkg/utils/template"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
 )
 
 const (
@@ -30,8 +36,23 @@ const (
 	CalicoNetworkVXLANSubnet = "Overlay-Vxlan-Cross-Subnet"
 	// CalicoNetworkBGP BGP mode
 	CalicoNetworkBGP = "BGP"
+
+	// calicoReleaseStepTimeout the step timeout of the calico release when no install timeout is configured
+	calicoReleaseStepTimeout = 1 * time.Minute
+
+	CalicoDataplaneIptables = "iptables"
+	CalicoDataplaneEBPF     = "ebpf"
+
+	// calicoDefaultBlockSize the IPv4 block size when v1.Calico.BlockSize is not set
+	calicoDefaultBlockSize = 26
+	// calicoEBPFVersion the eBPF dataplane and the typha manifests are supported from calico v3.16 on
+	calicoEBPFVersion = "v3.16"
+	// calicoOperatorVersion the calico versions from v3.26 on are installed by the tigera operator, which scales typha itself
+	calicoOperatorVersion = "v3.26"
 )
 
+var calicoDataplaneModes = sets.NewString(CalicoDataplaneIptables, CalicoDataplaneEBPF)
+
 func init() {
 	Register(&CalicoRunnable{})
 	if err := component.RegisterTemplate(fmt.Sprintf(component.RegisterTemplateKeyFormat,
@@ -53,12 +74,28 @@ type CalicoRunnable struct {
 	BaseCni
 	NodeAddressDetectionV4 NodeAddressDetection
 	NodeAddressDetectionV6 NodeAddressDetection
+	// DataplaneMode, TyphaReplicas and BlockSize are resolved from v1.Calico, see InitStep.
+	DataplaneMode string `json:"dataplaneMode"`
+	TyphaReplicas int    `json:"typhaReplicas"`
+	BlockSize     int    `json:"blockSize"`
+	// K8sServiceHost and K8sServicePort are rendered when the eBPF dataplane replaces kube-proxy,
+	// calico can not reach the apiserver through the kubernetes service without kube-proxy.
+	K8sServiceHost string `json:"k8sServiceHost,omitempty"`
+	K8sServicePort int    `json:"k8sServicePort,omitempty"`
+	kubeProxyMode  string
+	// allNodes all nodes of the cluster, the kube-proxy rules are cleaned on every node
+	allNodes []v1.StepNode
 }
 
 func (runnable *CalicoRunnable) Type() string {
 	return "calico"
 }
 
+// DefaultNamespace the operator deploys calico in calico-system, the clusters older than 1.26 deploy it in kube-system.
+func (runnable *CalicoRunnable) DefaultNamespace() string {
+	return "calico-system"
+}
+
 func (runnable *CalicoRunnable) Create() Stepper {
 	return &CalicoRunnable{}
 }
@@ -85,25 +122,132 @@ func (runnable *CalicoRunnable) InitStep(metadata *component.ExtraMetadata, cni
 	stepper.PodIPv6CIDR = ipv6
 	stepper.NodeAddressDetectionV4 = ParseNodeAddressDetection(cni.Calico.IPv4AutoDetection)
 	stepper.NodeAddressDetectionV6 = ParseNodeAddressDetection(cni.Calico.IPv6AutoDetection)
+	defaults := stepper.Defaults()
+	stepper.DataplaneMode = strutil.StringDefaultIfEmpty(defaults.DataplaneMode, cni.Calico.DataplaneMode)
+	stepper.TyphaReplicas = cni.Calico.TyphaReplicas
+	stepper.BlockSize = cni.Calico.BlockSize
+	if stepper.BlockSize == 0 {
+		stepper.BlockSize = defaults.BlockSize
+	}
+	stepper.kubeProxyMode = metadata.KubeProxyMode
+	stepper.allNodes = utils.UnwrapNodeList(metadata.GetAllNodes())
+	stepper.nodeStates = newNodeStates(metadata)
+	if stepper.DataplaneMode == CalicoDataplaneEBPF {
+		stepper.K8sServiceHost, stepper.K8sServicePort = kubernetesServiceEndpoint(networking)
+	}
 
 	return stepper
 }
 
-func (runnable *CalicoRunnable) LoadImage(nodes []v1.StepNode) ([]v1.Step, error) {
-	var steps []v1.Step
-	bytes, err := json.Marshal(runnable)
-	if err != nil {
-		return nil, err
+// Defaults the effective calico configuration when the cluster leaves a field unset, the mode and the MTU have
+// no default.
+func (runnable *CalicoRunnable) Defaults() *v1.Calico {
+	return &v1.Calico{
+		IPv4AutoDetection: "first-found",
+		IPv6AutoDetection: "first-found",
+		DataplaneMode:     CalicoDataplaneIptables,
+		BlockSize:         calicoDefaultBlockSize,
+	}
+}
+
+func (runnable *CalicoRunnable) setDefaults(cni *v1.CNI) {
+	cni.Calico = runnable.Defaults()
+}
+
+func (runnable *CalicoRunnable) Validate() error {
+	if runnable.ChartSource != "" {
+		return fieldError("chartSource", fmt.Errorf("calico does not support a chart source, the chart is downloaded from the package server"))
+	}
+	if runnable.PushToRegistry {
+		return fieldError("pushToRegistry", fmt.Errorf("calico does not support pushing the images to the local registry"))
+	}
+	if err := runnable.validateDataplane(); err != nil {
+		return err
+	}
+	return fieldError("timeouts", runnable.validateTimeouts())
+}
+
+// validateDataplane checks the dataplane, typha and block size options against the calico version and the cluster.
+func (runnable *CalicoRunnable) validateDataplane() error {
+	mode := runnable.DataplaneMode
+	if mode != "" && !calicoDataplaneModes.Has(mode) {
+		return fmt.Errorf("invalid calico dataplane mode %q, supported values: %v", mode, calicoDataplaneModes.List())
+	}
+	if runnable.kubeProxyMode == kubeProxyModeEBPF && mode != CalicoDataplaneEBPF {
+		return fmt.Errorf("kube-proxy is not deployed when proxy mode is %s, calico dataplane mode must be %s",
+			kubeProxyModeEBPF, CalicoDataplaneEBPF)
+	}
+	if runnable.TyphaReplicas < 0 {
+		return fmt.Errorf("invalid calico typha replicas %d", runnable.TyphaReplicas)
+	}
+	v, err := utilversion.ParseGeneric(runnable.Version)
+	if err == nil && v.LessThan(utilversion.MustParseGeneric(calicoEBPFVersion)) {
+		if mode == CalicoDataplaneEBPF {
+			return fmt.Errorf("the calico eBPF dataplane requires calico %s or later", calicoEBPFVersion)
+		}
+		if runnable.TyphaReplicas > 0 {
+			return fmt.Errorf("calico typha requires calico %s or later", calicoEBPFVersion)
+		}
+	}
+	if err == nil && !v.LessThan(utilversion.MustParseGeneric(calicoOperatorVersion)) && runnable.TyphaReplicas > 0 {
+		return fmt.Errorf("calico %s is installed by the tigera operator which scales typha itself, typha replicas can not be set", runnable.Version)
+	}
+	if runnable.BlockSize == 0 {
+		return nil
+	}
+	if runnable.BlockSize < 20 || runnable.BlockSize > 32 {
+		return fmt.Errorf("invalid calico block size %d, it must be between 20 and 32", runnable.BlockSize)
+	}
+	if _, cidr, err := net.ParseCIDR(runnable.PodIPv4CIDR); err == nil {
+		if ones, _ := cidr.Mask.Size(); runnable.BlockSize < ones {
+			return fmt.Errorf("calico block size %d is larger than the pod cidr %s", runnable.BlockSize, runnable.PodIPv4CIDR)
+		}
 	}
+	return nil
+}
 
+// GetImages returns the images referenced by the calico manifests, the helm chart used by kubernetes 1.26+
+// deploys the tigera operator images which are not covered.
+func (runnable *CalicoRunnable) GetImages(version string, criType string) ([]string, error) {
+	if criType != "" && !v1.AllowedCRIType.Has(criType) {
+		return nil, fmt.Errorf("unsupported cri type %q", criType)
+	}
+	registry := strutil.StringDefaultIfEmpty("docker.io", strings.TrimSuffix(runnable.LocalRegistry, "/"))
+	var images []string
+	names := []string{"cni", "node", "kube-controllers", "pod2daemon-flexvol"}
+	if runnable.TyphaReplicas > 0 {
+		names = append(names, "typha")
+	}
+	for _, name := range names {
+		images = append(images, fmt.Sprintf("%s/calico/%s:%s", registry, name, version))
+	}
+	return images, nil
+}
+
+func (runnable *CalicoRunnable) UpgradeSteps(nodes []v1.StepNode, fromVersion, toVersion string) ([]v1.Step, error) {
+	return nil, fmt.Errorf("calico upgrade from %s to %s is not supported", fromVersion, toVersion)
+}
+
+func (runnable *CalicoRunnable) LoadImage(nodes []v1.StepNode) ([]v1.Step, error) {
+	var steps []v1.Step
 	if runnable.Offline && runnable.LocalRegistry == "" {
-		return []v1.Step{LoadImage("calico", bytes, nodes)}, nil
+		return runnable.stableSteps(loadImageSteps("calico", runnable.nodeStates.available(nodes), runnable.imageLoadTimeout(), func(arch string) ([]byte, error) {
+			target := *runnable
+			target.Arch = arch
+			return json.Marshal(&target)
+		}))
 	}
 
 	return steps, nil
 }
 
 func (runnable *CalicoRunnable) InstallSteps(nodes []v1.StepNode, kubernetesVersion string) ([]v1.Step, error) {
+	if err := runnable.nodeStates.require(nodes); err != nil {
+		return nil, err
+	}
+	if err := runnable.checkCompatibility(kubernetesVersion); err != nil {
+		return nil, err
+	}
 	var steps []v1.Step
 	bytes, err := json.Marshal(runnable)
 	if err != nil {
@@ -122,13 +266,58 @@ func (runnable *CalicoRunnable) InstallSteps(nodes []v1.StepNode, kubernetesVers
 		}
 		steps = append(steps, cLoadSteps...)
 		steps = append(steps, RenderYaml("calico", bytes, nodes))
-		steps = append(steps, InstallCalicoRelease(filepath.Join(downloader.BaseDstDir, "."+chart.PkgName, chart.Version, downloader.ChartFilename), filepath.Join(manifestDir, "calico.yaml"), nodes))
+		steps = append(steps, InstallCalicoRelease(filepath.Join(downloader.BaseDstDir, "."+chart.PkgName, chart.Version, downloader.ChartFilename), filepath.Join(manifestDir, "calico.yaml"), nodes,
+			HelmReleaseOptions{Timeout: runnable.installTimeout(0)}))
+		if runnable.DataplaneMode == CalicoDataplaneEBPF {
+			steps = append(steps, runnable.configureServicesEndpoint(nodes, true))
+		}
 	} else {
 		steps = append(steps, RenderYaml("calico", bytes, nodes))
+		if runnable.DataplaneMode == CalicoDataplaneEBPF {
+			steps = append(steps, runnable.configureServicesEndpoint(nodes, false))
+		}
 		steps = append(steps, ApplyYaml(filepath.Join(manifestDir, "calico.yaml"), nodes))
 	}
+	if runnable.DataplaneMode == CalicoDataplaneEBPF && runnable.kubeProxyMode != kubeProxyModeEBPF {
+		namespace := "kube-system"
+		if IsHighKubeVersion(kubernetesVersion) {
+			namespace = "calico-system"
+		}
+		steps = append(steps, removeKubeProxy("calico-node", namespace, nodes), cleanKubeProxyRules(runnable.allNodes))
+	}
 
-	return steps, nil
+	return runnable.stableSteps(steps, nil)
+}
+
+// configureServicesEndpoint points the calico components at the apiserver domain so that they keep working once
+// kube-proxy is removed. The tigera operator only loads the ConfigMap when it starts, it is restarted once its
+// namespace is created by the release and calico-node is waited for.
+func (runnable *CalicoRunnable) configureServicesEndpoint(nodes []v1.StepNode, operator bool) v1.Step {
+	commands := []v1.Command{applyServicesEndpoint("kube-system", runnable.K8sServiceHost, runnable.K8sServicePort)}
+	if operator {
+		commands = []v1.Command{
+			applyServicesEndpoint("tigera-operator", runnable.K8sServiceHost, runnable.K8sServicePort),
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "rollout", "restart", "deploy/tigera-operator", "-n", "tigera-operator"},
+			},
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					"for i in $(seq 60); do kubectl get ds calico-node -n calico-system >/dev/null 2>&1 && exit 0; sleep 5; done; exit 1"},
+			},
+		}
+	}
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "configureCalicoServicesEndpoint",
+		Timeout:    metav1.Duration{Duration: 6 * time.Minute},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands:   commands,
+	}
 }
 
 func (runnable *CalicoRunnable) UninstallSteps(nodes []v1.StepNode) ([]v1.Step, error) {
@@ -145,7 +334,29 @@ func (runnable *CalicoRunnable) UninstallSteps(nodes []v1.StepNode) ([]v1.Step,
 		steps = append(steps, runnable.clear(runnable.Calico, nodes)...)
 	}
 
-	return steps, nil
+	return runnable.stableSteps(steps, nil)
+}
+
+// RollbackSteps removes the calico release or manifest installed on nodes. The kube-proxy removed for the
+// eBPF dataplane is not restored, the install removes it again.
+func (runnable *CalicoRunnable) RollbackSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	steps := []v1.Step{runnable.removeRelease(nodes), RemoveManifests(nodes, "calico.yaml")}
+	images, err := runnable.rollbackImages("calico", runnable, runnable.allNodes)
+	if err != nil {
+		return nil, err
+	}
+	return runnable.stableSteps(append(steps, images...), nil)
+}
+
+// JoinNodeSteps loads the calico images on the joining nodes of offline clusters, the calico-node DaemonSet
+// schedules the agent itself.
+func (runnable *CalicoRunnable) JoinNodeSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	return runnable.LoadImage(nodes)
+}
+
+// LeaveNodeSteps the calico uninstall only cleans the nodes up, the resources are kept in the cluster.
+func (runnable *CalicoRunnable) LeaveNodeSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	return runnable.UninstallSteps(nodes)
 }
 
 func (runnable *CalicoRunnable) clear(calico *v1.Calico, nodes []v1.StepNode) []v1.Step {
@@ -157,9 +368,9 @@ func (runnable *CalicoRunnable) clear(calico *v1.Calico, nodes []v1.StepNode) []
 	switch calico.Mode {
 	case CalicoNetworkIPIPAll, CalicoNetworkIPIPSubnet:
 		steps = append(steps, v1.Step{
-			ID:         strutil.GetUUID(),
+			ID:         newStepID(),
 			Name:       "removeTunl",
-			Timeout:    metav1.Duration{Duration: 5 * time.Second},
+			Timeout:    metav1.Duration{Duration: runnable.uninstallTimeout(5 * time.Second)},
 			ErrIgnore:  true,
 			Nodes:      nodes,
 			Action:     v1.ActionUninstall,
@@ -173,9 +384,9 @@ func (runnable *CalicoRunnable) clear(calico *v1.Calico, nodes []v1.StepNode) []
 		})
 	case CalicoNetworkVXLANAll, CalicoNetworkVXLANSubnet:
 		steps = append(steps, v1.Step{
-			ID:         strutil.GetUUID(),
+			ID:         newStepID(),
 			Name:       "removeVtep",
-			Timeout:    metav1.Duration{Duration: 5 * time.Second},
+			Timeout:    metav1.Duration{Duration: runnable.uninstallTimeout(5 * time.Second)},
 			ErrIgnore:  true,
 			Nodes:      nodes,
 			Action:     v1.ActionUninstall,
@@ -190,9 +401,9 @@ func (runnable *CalicoRunnable) clear(calico *v1.Calico, nodes []v1.StepNode) []
 	}
 	// clean all cali* interface
 	steps = append(steps, v1.Step{
-		ID:         strutil.GetUUID(),
+		ID:         newStepID(),
 		Name:       "removeCali",
-		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		Timeout:    metav1.Duration{Duration: runnable.uninstallTimeout(30 * time.Second)},
 		ErrIgnore:  true,
 		Nodes:      nodes,
 		Action:     v1.ActionUninstall,
@@ -209,24 +420,115 @@ func (runnable *CalicoRunnable) clear(calico *v1.Calico, nodes []v1.StepNode) []
 	return steps
 }
 
+// MigrationSteps the cni migration only supports migrating from calico.
+func (runnable *CalicoRunnable) MigrationSteps(from Stepper, nodes []v1.StepNode) ([]v1.Step, error) {
+	return nil, fmt.Errorf("migrating from %T to calico is not supported", from)
+}
+
+// CheckSteps the health check is not implemented for calico.
+func (runnable *CalicoRunnable) CheckSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	return nil, fmt.Errorf("the calico health check is not supported")
+}
+
+// PreRestoreSteps calico has no state to capture before a restore.
+func (runnable *CalicoRunnable) PreRestoreSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	return nil, nil
+}
+
+// PostRestoreSteps calico has no state to reconcile after a restore, its daemon-set is restarted by the restore.
+func (runnable *CalicoRunnable) PostRestoreSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	return nil, nil
+}
+
+// removeRelease removes the calico resources, the tigera operator tears calico-system down once its
+// Installation is deleted. Clusters below kubernetes 1.26 installed the rendered manifest instead of the chart,
+// nothing is done when neither is installed.
+func (runnable *CalicoRunnable) removeRelease(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "removeCalicoRelease",
+		Timeout:    metav1.Duration{Duration: runnable.uninstallTimeout(5 * time.Minute)},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf(`if %[2]s status calico -n calico-system >/dev/null 2>&1; then kubectl delete installation default --ignore-not-found --wait && %[2]s uninstall calico -n calico-system --wait; elif [ -f %[1]s ]; then kubectl delete -f %[1]s --ignore-not-found; fi`,
+						filepath.Join(manifestDir, "calico.yaml"), common.HelmBin)},
+			},
+		},
+	}
+}
+
 // CmdList cni kubectl cmd list
 func (runnable *CalicoRunnable) CmdList(namespace string) map[string]string {
-	cmdList := make(map[string]string)
-	cmdList["get"] = fmt.Sprintf("kubectl get po -n %s | grep calico", namespace)
-	cmdList["restart"] = fmt.Sprintf("kubectl rollout restart ds calico-node -n %s", namespace)
+	return operationCommands(runnable.Operations(namespace))
+}
 
-	return cmdList
+func (runnable *CalicoRunnable) Operations(namespace string) []Operation {
+	return []Operation{
+		{
+			Name:        OperationGet,
+			Description: "List the calico pods.",
+			Command:     fmt.Sprintf("kubectl get po -n %s | grep calico", namespace),
+			Output:      OperationOutputText,
+		},
+		{
+			Name:        OperationStatus,
+			Description: "Show the rollout status of the calico nodes.",
+			Command:     fmt.Sprintf("kubectl get ds calico-node -n %[1]s -o wide && kubectl rollout status ds calico-node -n %[1]s --timeout=10s", namespace),
+			Output:      OperationOutputText,
+		},
+		{
+			Name:        OperationLogs,
+			Description: fmt.Sprintf("Show the last %d log lines of every calico node.", operationLogLines),
+			Command: fmt.Sprintf("kubectl -n %s logs -l k8s-app=calico-node -c calico-node --tail=%d --prefix --max-log-requests=%d",
+				namespace, operationLogLines, operationMaxLogRequests),
+			Output: OperationOutputText,
+		},
+		{
+			Name:        OperationVersion,
+			Description: "Show the image of the calico nodes.",
+			Command:     fmt.Sprintf("kubectl get ds calico-node -n %s -o jsonpath='{.spec.template.spec.containers[0].image}'", namespace),
+			Output:      OperationOutputText,
+		},
+		{
+			Name:        OperationRestart,
+			Description: "Restart the calico nodes, the pod networking of the nodes is disrupted until they are ready.",
+			Command:     fmt.Sprintf("kubectl rollout restart ds calico-node -n %s", namespace),
+			Destructive: true,
+			Output:      OperationOutputText,
+		},
+		healthOperation("calico", Workloads{Namespace: namespace, DaemonSets: []string{"calico-node"}, Deployments: []string{"calico-kube-controllers"}}),
+	}
 }
 
 func (runnable *CalicoRunnable) Render(ctx context.Context, opts component.Options) error {
+	runnable.gatherFacts(ctx)
+	if opts.DryRun {
+		_, err := runnable.RenderString(ctx)
+		return err
+	}
 	if err := os.MkdirAll(manifestDir, 0755); err != nil {
 		return err
 	}
 	manifestFile := filepath.Join(manifestDir, "calico.yaml")
-	return fileutil.WriteFileWithContext(ctx, manifestFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644,
+	return fileutil.WriteFileAtomicWithContext(ctx, manifestFile, 0644,
 		runnable.renderCalicoTo, opts.DryRun)
 }
 
+// RenderString returns the manifests Render writes to the manifest file.
+func (runnable *CalicoRunnable) RenderString(ctx context.Context) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := runnable.renderCalicoTo(buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
 func (runnable *CalicoRunnable) renderCalicoTo(w io.Writer) error {
 	at := tmplutil.New()
 	calicoTemp, err := runnable.CalicoTemplate()
@@ -244,13 +546,13 @@ func (runnable *CalicoRunnable) CalicoTemplate() (string, error) {
 	case "v3.11.2":
 		return calicoV3112, nil
 	case "v3.16.10":
-		return calicoV31610, nil
+		return calicoV31610 + calicoTypha, nil
 	case "v3.21.2":
-		return calicoV3212, nil
+		return calicoV3212 + calicoTypha, nil
 	case "v3.22.4":
-		return calicoV3224, nil
+		return calicoV3224 + calicoTypha, nil
 	case "v3.24.5":
-		return calicoV3245, nil
+		return calicoV3245 + calicoTypha, nil
 	case "v3.26.1":
 		return calicoV3261, nil
 	}
@@ -4871,6 +4871,18 @@ spec:
               value: "{{.DualStack}}"
             - name: FELIX_HEALTHENABLED
               value: "true"
+            {{if .BlockSize}}
+            - name: CALICO_IPV4POOL_BLOCK_SIZE
+              value: "{{.BlockSize}}"
+            {{end}}
+            {{if eq .DataplaneMode "ebpf"}}
+            - name: FELIX_BPFENABLED
+              value: "true"
+            {{end}}
+            {{if .TyphaReplicas}}
+            - name: FELIX_TYPHAK8SSERVICENAME
+              value: "calico-typha"
+            {{end}}
           securityContext:
             privileged: true
           resources:
@@ -8688,6 +8700,18 @@ spec:
               value: "info"
             - name: FELIX_HEALTHENABLED
               value: "true"
+            {{if .BlockSize}}
+            - name: CALICO_IPV4POOL_BLOCK_SIZE
+              value: "{{.BlockSize}}"
+            {{end}}
+            {{if eq .DataplaneMode "ebpf"}}
+            - name: FELIX_BPFENABLED
+              value: "true"
+            {{end}}
+            {{if .TyphaReplicas}}
+            - name: FELIX_TYPHAK8SSERVICENAME
+              value: "calico-typha"
+            {{end}}
           securityContext:
             privileged: true
           resources:
@@ -13104,6 +13128,18 @@ spec:
               value: "{{.DualStack}}"
             - name: FELIX_HEALTHENABLED
               value: "true"
+            {{if .BlockSize}}
+            - name: CALICO_IPV4POOL_BLOCK_SIZE
+              value: "{{.BlockSize}}"
+            {{end}}
+            {{if eq .DataplaneMode "ebpf"}}
+            - name: FELIX_BPFENABLED
+              value: "true"
+            {{end}}
+            {{if .TyphaReplicas}}
+            - name: FELIX_TYPHAK8SSERVICENAME
+              value: "calico-typha"
+            {{end}}
           securityContext:
             privileged: true
           resources:
@@ -17753,6 +17789,18 @@ spec:
               value: "{{.DualStack}}"
             - name: FELIX_HEALTHENABLED
               value: "true"
+            {{if .BlockSize}}
+            - name: CALICO_IPV4POOL_BLOCK_SIZE
+              value: "{{.BlockSize}}"
+            {{end}}
+            {{if eq .DataplaneMode "ebpf"}}
+            - name: FELIX_BPFENABLED
+              value: "true"
+            {{end}}
+            {{if .TyphaReplicas}}
+            - name: FELIX_TYPHAK8SSERVICENAME
+              value: "calico-typha"
+            {{end}}
           securityContext:
             privileged: true
           resources:
@@ -17917,7 +17965,7 @@ const calicoV3261 = `installation:
   {{end}}
   calicoNetwork:
     # Iptables, BPF
-    linuxDataplane: Iptables
+    linuxDataplane: {{if eq .DataplaneMode "ebpf"}}BPF{{else}}Iptables{{end}}
     mtu: {{.CNI.Calico.MTU}}
     nodeAddressAutodetectionV4:
       {{if eq .NodeAddressDetectionV4.Type "first-found"}}
@@ -17944,7 +17992,7 @@ const calicoV3261 = `installation:
       {{end}}
     {{end}}
     ipPools:
-      - blockSize: 26
+      - blockSize: {{with .BlockSize}}{{.}}{{else}}26{{end}}
         cidr: {{.PodIPv4CIDR}}
         {{if eq .CNI.Calico.Mode "Overlay-IPIP-All"}}
         encapsulation: IPIP
@@ -17977,3 +18025,101 @@ tigeraOperator:
 calicoctl:
   image: {{with .CNI.LocalRegistry}}{{.}}{{else}}docker.io{{end}}/calico/ctl
   tag: v3.26.1`
+
+// calicoTypha is appended to the calico manifests, typha fans out the datastore watches of calico-node
+// on large clusters.
+const calicoTypha = `
+{{if .TyphaReplicas}}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: calico-typha
+  namespace: kube-system
+  labels:
+    k8s-app: calico-typha
+spec:
+  ports:
+    - port: 5473
+      protocol: TCP
+      targetPort: calico-typha
+      name: calico-typha
+  selector:
+    k8s-app: calico-typha
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: calico-typha
+  namespace: kube-system
+  labels:
+    k8s-app: calico-typha
+spec:
+  replicas: {{.TyphaReplicas}}
+  revisionHistoryLimit: 2
+  selector:
+    matchLabels:
+      k8s-app: calico-typha
+  strategy:
+    rollingUpdate:
+      maxSurge: 100%
+      maxUnavailable: 1
+    type: RollingUpdate
+  template:
+    metadata:
+      labels:
+        k8s-app: calico-typha
+      annotations:
+        cluster-autoscaler.kubernetes.io/safe-to-evict: 'true'
+    spec:
+      nodeSelector:
+        kubernetes.io/os: linux
+      hostNetwork: true
+      tolerations:
+        - operator: Exists
+      serviceAccountName: calico-node
+      priorityClassName: system-cluster-critical
+      securityContext:
+        fsGroup: 65534
+      containers:
+      - image: {{with .CNI.LocalRegistry}}{{.}}/{{end}}calico/typha:{{.CNI.Version}}
+        imagePullPolicy: IfNotPresent
+        name: calico-typha
+        ports:
+        - containerPort: 5473
+          name: calico-typha
+          protocol: TCP
+        envFrom:
+        - configMapRef:
+            name: kubernetes-services-endpoint
+            optional: true
+        env:
+          - name: TYPHA_LOGSEVERITYSCREEN
+            value: "info"
+          - name: TYPHA_LOGFILEPATH
+            value: "none"
+          - name: TYPHA_LOGSEVERITYSYS
+            value: "none"
+          - name: TYPHA_CONNECTIONREBALANCINGMODE
+            value: "kubernetes"
+          - name: TYPHA_DATASTORETYPE
+            value: "kubernetes"
+          - name: TYPHA_HEALTHENABLED
+            value: "true"
+        livenessProbe:
+          httpGet:
+            path: /liveness
+            port: 9098
+            host: localhost
+          periodSeconds: 30
+          initialDelaySeconds: 30
+        securityContext:
+          runAsNonRoot: true
+          allowPrivilegeEscalation: false
+        readinessProbe:
+          httpGet:
+            path: /readiness
+            port: 9098
+            host: localhost
+          periodSeconds: 10
+{{end}}`
@@ -2,8 +2,11 @@ package cni
 
 import (
 	"bytes"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/kubeclipper/kubeclipper/pkg/component"
 	"github.com/kubeclipper/kubeclipper/pkg/constatns"
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
 )
@@ -12,8 +15,6 @@ func TestCNI_renderCalicoTo(t *testing.T) {
 	tests := []struct {
 		name    string
 		stepper CalicoRunnable
-		wantW   string
-		wantErr bool
 	}{
 		{
 			name: "base",
@@ -37,6 +38,25 @@ func TestCNI_renderCalicoTo(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "ebpf",
+			stepper: CalicoRunnable{
+				BaseCni: BaseCni{
+					PodIPv4CIDR: constatns.ClusterPodSubnet,
+					CNI: v1.CNI{
+						Type:    "calico",
+						Version: "v3.26.1",
+						Calico: &v1.Calico{
+							IPv4AutoDetection: "first-found",
+							Mode:              "Overlay-Vxlan-All",
+							MTU:               1440,
+						},
+					},
+				},
+				DataplaneMode: CalicoDataplaneEBPF,
+				BlockSize:     24,
+			},
+		},
 	}
 	for _, tt := range tests {
 		tt.stepper.NodeAddressDetectionV4 = ParseNodeAddressDetection(tt.stepper.Calico.IPv4AutoDetection)
@@ -48,7 +68,143 @@ func TestCNI_renderCalicoTo(t *testing.T) {
 				t.Errorf("renderCalicoTo() error = %v", err)
 				return
 			}
-			t.Log(w.String())
+			assertGolden(t, "calico-"+tt.name, w.Bytes())
+		})
+	}
+}
+
+func newCalicoStepper(metadata *component.ExtraMetadata, version string, calico *v1.Calico) *CalicoRunnable {
+	networking := &v1.Networking{DNSDomain: "cluster.local", Pods: v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}}}
+	return (&CalicoRunnable{}).InitStep(metadata, &v1.CNI{Version: version, Calico: calico}, networking).(*CalicoRunnable)
+}
+
+func TestCalicoRunnable_Validate(t *testing.T) {
+	tests := []struct {
+		name          string
+		version       string
+		config        *v1.Calico
+		kubeProxyMode string
+		wantErr       bool
+	}{
+		{name: "default", version: "v3.26.1", config: &v1.Calico{}},
+		{name: "ebpf", version: "v3.26.1", config: &v1.Calico{DataplaneMode: "ebpf"}},
+		{name: "typo", version: "v3.26.1", config: &v1.Calico{DataplaneMode: "bpf"}, wantErr: true},
+		{name: "ebpf on v3.11", version: "v3.11.2", config: &v1.Calico{DataplaneMode: "ebpf"}, wantErr: true},
+		{name: "typha on v3.24", version: "v3.24.5", config: &v1.Calico{TyphaReplicas: 3}},
+		{name: "typha on v3.11", version: "v3.11.2", config: &v1.Calico{TyphaReplicas: 3}, wantErr: true},
+		{name: "typha with operator", version: "v3.26.1", config: &v1.Calico{TyphaReplicas: 3}, wantErr: true},
+		{name: "negative typha", version: "v3.24.5", config: &v1.Calico{TyphaReplicas: -1}, wantErr: true},
+		{name: "block size", version: "v3.26.1", config: &v1.Calico{BlockSize: 24}},
+		{name: "block size too small", version: "v3.26.1", config: &v1.Calico{BlockSize: 19}, wantErr: true},
+		{name: "block size larger than pod cidr", version: "v3.26.1", config: &v1.Calico{BlockSize: 20}},
+		{name: "ebpf proxy without ebpf dataplane", version: "v3.26.1", config: &v1.Calico{}, kubeProxyMode: "ebpf", wantErr: true},
+		{name: "ebpf proxy", version: "v3.26.1", config: &v1.Calico{DataplaneMode: "ebpf"}, kubeProxyMode: "ebpf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := newCalicoStepper(&component.ExtraMetadata{KubeProxyMode: tt.kubeProxyMode}, tt.version, tt.config)
+			if err := stepper.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
 		})
 	}
+	stepper := newCalicoStepper(&component.ExtraMetadata{}, "v3.26.1", &v1.Calico{BlockSize: 24})
+	stepper.PodIPv4CIDR = "172.25.0.0/25"
+	if err := stepper.Validate(); err == nil {
+		t.Errorf("Validate() should reject a block size larger than the pod cidr")
+	}
+}
+
+func TestCalicoRunnable_renderManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		calico  *v1.Calico
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "default",
+			calico:  &v1.Calico{Mode: "BGP"},
+			want:    []string{"CALICO_IPV4POOL_BLOCK_SIZE\n              value: \"26\""},
+			notWant: []string{"FELIX_BPFENABLED", "calico-typha"},
+		},
+		{
+			name:   "ebpf and typha",
+			calico: &v1.Calico{Mode: "BGP", DataplaneMode: "ebpf", TyphaReplicas: 2, BlockSize: 24},
+			want: []string{"CALICO_IPV4POOL_BLOCK_SIZE\n              value: \"24\"", "FELIX_BPFENABLED", "FELIX_TYPHAK8SSERVICENAME",
+				"replicas: 2", "image: calico/typha:v3.24.5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &bytes.Buffer{}
+			if err := newCalicoStepper(&component.ExtraMetadata{}, "v3.24.5", tt.calico).renderCalicoTo(w); err != nil {
+				t.Fatalf("renderCalicoTo() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(w.String(), want) {
+					t.Errorf("renderCalicoTo() output does not contain %q", want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(w.String(), notWant) {
+					t.Errorf("renderCalicoTo() output should not contain %q", notWant)
+				}
+			}
+		})
+	}
+}
+
+func TestCalicoRunnable_InstallStepsEBPF(t *testing.T) {
+	metadata := &component.ExtraMetadata{KubeProxyMode: "ipvs", Masters: component.NodeList{{ID: "node1"}}, Workers: component.NodeList{{ID: "node2"}}}
+	tests := []struct {
+		name              string
+		kubernetesVersion string
+		namespace         string
+		want              []string
+	}{
+		{
+			name:              "manifest",
+			kubernetesVersion: "v1.23.6",
+			namespace:         "kube-system",
+			want:              []string{"renderCniYaml", "configureCalicoServicesEndpoint", "applyCniYaml", "removeKubeProxy", "cleanKubeProxyRules"},
+		},
+		{
+			name:              "operator",
+			kubernetesVersion: "v1.27.4",
+			namespace:         "calico-system",
+			want:              []string{"configureCalicoServicesEndpoint", "removeKubeProxy", "cleanKubeProxyRules"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := newCalicoStepper(metadata, "v3.26.1", &v1.Calico{DataplaneMode: "ebpf"})
+			steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, tt.kubernetesVersion)
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			if got := stepNames(steps[len(steps)-len(tt.want):]); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("last steps = %v, want %v", got, tt.want)
+			}
+			configure := stepByName(steps, "configureCalicoServicesEndpoint")
+			if !strings.Contains(configure.Commands[0].ShellCommand[2], "KUBERNETES_SERVICE_HOST=apiserver.cluster.local") {
+				t.Errorf("configureCalicoServicesEndpoint commands = %v", configure.Commands)
+			}
+			if remove := stepByName(steps, "removeKubeProxy"); !reflect.DeepEqual(remove.Commands[0].ShellCommand[3:6], []string{"ds/calico-node", "-n", tt.namespace}) {
+				t.Errorf("removeKubeProxy commands = %v", remove.Commands[0].ShellCommand)
+			}
+			if clean := stepByName(steps, "cleanKubeProxyRules"); len(clean.Nodes) != 2 {
+				t.Errorf("cleanKubeProxyRules nodes = %v, want all cluster nodes", clean.Nodes)
+			}
+		})
+	}
+
+	stepper := newCalicoStepper(&component.ExtraMetadata{KubeProxyMode: "ebpf"}, "v3.26.1", &v1.Calico{DataplaneMode: "ebpf"})
+	steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	if stepByName(steps, "removeKubeProxy").Name != "" {
+		t.Errorf("InstallSteps() should not remove kube-proxy when it is not deployed")
+	}
 }
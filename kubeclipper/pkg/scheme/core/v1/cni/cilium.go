@@ -1,26 +1,124 @@
 package cni
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kubeclipper/kubeclipper/pkg/component"
 	"github.com/kubeclipper/kubeclipper/pkg/component/common"
+	"github.com/kubeclipper/kubeclipper/pkg/component/utils"
+	"github.com/kubeclipper/kubeclipper/pkg/logger"
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
 	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
 	"github.com/kubeclipper/kubeclipper/pkg/utils/fileutil"
 	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
 	tmplutil "github.com/kubeclipper/kubeclipper/pkg/utils/template"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"sigs.k8s.io/yaml"
 )
 
 const (
 	CiliumNamespaceDefault = "kube-system"
+	// CiliumReleaseNameDefault the helm release name when v1.Cilium.ReleaseName is empty.
+	CiliumReleaseNameDefault = "cilium"
+
+	// cilium kubeProxyReplacement modes, see v1.CiliumKubeProxyReplacement
+	CiliumKubeProxyReplacementDisabled = "disabled"
+	CiliumKubeProxyReplacementPartial  = "partial"
+	CiliumKubeProxyReplacementProbe    = "probe"
+	CiliumKubeProxyReplacementStrict   = "strict"
+	CiliumKubeProxyReplacementTrue     = "true"
+	CiliumKubeProxyReplacementFalse    = "false"
+
+	CiliumTunnelVXLAN    = "vxlan"
+	CiliumTunnelGeneve   = "geneve"
+	CiliumTunnelDisabled = "disabled"
+
+	CiliumEncryptionWireguard       = "wireguard"
+	CiliumEncryptionIPsec           = "ipsec"
+	CiliumIPsecKeySecretNameDefault = "cilium-ipsec-keys"
+
+	CiliumPolicyEnforcementDefault = "default"
+	CiliumPolicyEnforcementAlways  = "always"
+	CiliumPolicyEnforcementNever   = "never"
+
+	CiliumChainingAWSCNI      = "aws-cni"
+	CiliumChainingGenericVeth = "generic-veth"
+
+	CiliumLBModeSNAT        = "snat"
+	CiliumLBModeDSR         = "dsr"
+	CiliumLBModeHybrid      = "hybrid"
+	CiliumLBAlgorithmRandom = "random"
+	CiliumLBAlgorithmMaglev = "maglev"
+
+	ciliumDefaultReadinessTimeout = 5 * time.Minute
+	// ciliumDefaultImageRepository the repository of the chart default images, which the offline packages contain.
+	ciliumDefaultImageRepository = "quay.io/cilium"
+	// ciliumAgentPriorityClassDefault the priority class of the agent pods when v1.Cilium PriorityClassName is unset.
+	ciliumAgentPriorityClassDefault = "system-node-critical"
+
+	// ciliumDefaultIPv4PodCIDR the cluster pool of the clusters without a cilium configuration, see Defaults.
+	ciliumDefaultIPv4PodCIDR  = "192.168.64.0/18"
+	ciliumDefaultIPv4MaskSize = 25
+	ciliumDefaultIPv6MaskSize = 120
+
+	// ciliumBBRMinKernel the oldest kernel cilium supports bbr for pods on.
+	ciliumBBRMinKernel = "5.18"
+
+	// ciliumHAOperatorReplicas the operator replicas derived for the clusters with more than one master.
+	ciliumHAOperatorReplicas = 2
+
+	// ciliumNodeConcurrency the nodes loading the images or cleaning up at once, the image loads saturate the
+	// network of the registry and the server otherwise.
+	ciliumNodeConcurrency = 10
+
+	ciliumTolerationOpExists = "Exists"
+	ciliumTolerationOpEqual  = "Equal"
+)
+
+var ciliumKubeProxyReplacementModes = sets.NewString(CiliumKubeProxyReplacementDisabled, CiliumKubeProxyReplacementPartial,
+	CiliumKubeProxyReplacementProbe, CiliumKubeProxyReplacementStrict, CiliumKubeProxyReplacementTrue, CiliumKubeProxyReplacementFalse)
+
+// cilium 1.14 accepts both sets of kubeProxyReplacement modes, older versions only the legacy ones and newer versions
+// only true/false, the modes of the other set are translated with these.
+var (
+	ciliumLegacyKubeProxyReplacements = map[string]string{
+		CiliumKubeProxyReplacementTrue:  CiliumKubeProxyReplacementStrict,
+		CiliumKubeProxyReplacementFalse: CiliumKubeProxyReplacementDisabled,
+	}
+	ciliumBoolKubeProxyReplacements = map[string]string{
+		CiliumKubeProxyReplacementStrict:   CiliumKubeProxyReplacementTrue,
+		CiliumKubeProxyReplacementDisabled: CiliumKubeProxyReplacementFalse,
+		// the partial replacement is configured by the single features from cilium 1.15
+		CiliumKubeProxyReplacementPartial: CiliumKubeProxyReplacementFalse,
+		CiliumKubeProxyReplacementProbe:   CiliumKubeProxyReplacementFalse,
+	}
+)
+
+var ciliumTolerationEffects = sets.NewString("NoSchedule", "PreferNoSchedule", "NoExecute")
+
+var ciliumPolicyEnforcementModes = sets.NewString(CiliumPolicyEnforcementDefault, CiliumPolicyEnforcementAlways, CiliumPolicyEnforcementNever)
+
+var ciliumChainingModes = sets.NewString(CiliumChainingAWSCNI, CiliumChainingGenericVeth)
+
+var (
+	ciliumLBModes      = sets.NewString(CiliumLBModeSNAT, CiliumLBModeDSR, CiliumLBModeHybrid)
+	ciliumLBAlgorithms = sets.NewString(CiliumLBAlgorithmRandom, CiliumLBAlgorithmMaglev)
 )
 
 func init() {
@@ -38,12 +136,111 @@ func init() {
 type CiliumRunnable struct {
 	BaseCni
 	CiliumConfig *v1.Cilium
+	// K8sServiceHost and K8sServicePort are rendered when cilium fully replaces kube-proxy,
+	// the agent can not reach the apiserver through the kubernetes service without kube-proxy.
+	K8sServiceHost string `json:"k8sServiceHost,omitempty"`
+	K8sServicePort int    `json:"k8sServicePort,omitempty"`
+	// LegacyTunnel renders the tunnel value of cilium < 1.14 instead of routingMode and tunnelProtocol.
+	LegacyTunnel bool `json:"legacyTunnel,omitempty"`
+	// Migration renders the values which let cilium run next to the installed cni until the nodes are migrated.
+	Migration bool `json:"migration,omitempty"`
+	// ValuesTemplate the user override of the values template, the built-in template is used when it is empty.
+	ValuesTemplate string `json:"valuesTemplate,omitempty"`
+	// ControlPlaneNodes the number of master nodes, the operator replicas are derived from it when they are not set.
+	ControlPlaneNodes int `json:"controlPlaneNodes,omitempty"`
+	// ImagePullSecret the image pull secret of the cilium pods, set when LocalRegistry requires authentication.
+	ImagePullSecret string `json:"imagePullSecret,omitempty"`
+	kubeProxyMode   string
+	// Images the image repositories rewritten to LocalRegistry, they are empty when LocalRegistry is not set
+	Images CiliumImages `json:"images"`
+	// allNodes all nodes of the cluster, used by the node level preflight checks
+	allNodes []v1.StepNode
+	// masters the master nodes of the cluster, the cluster scoped steps run on the first one
+	masters []v1.StepNode
+	// serviceCIDRs the service CIDRs of Networking, used by the pod CIDR validation
+	serviceCIDRs []string
+	// nodeLabels the labels of the cluster nodes, the agent NodeSelector is matched against them
+	nodeLabels map[string]labels.Set
+	// apiServerPort and agentPort the node ports the host firewall baseline policy allows
+	apiServerPort int
+	agentPort     int
+	// apiServerEndpoint the load balancer in front of the kube-apiservers the release is installed through
+	apiServerEndpoint string
+}
+
+// CiliumImages image repositories of the cilium components, without tag.
+type CiliumImages struct {
+	Cilium          string `json:"cilium,omitempty"`
+	Operator        string `json:"operator,omitempty"`
+	HubbleRelay     string `json:"hubbleRelay,omitempty"`
+	HubbleUI        string `json:"hubbleUI,omitempty"`
+	HubbleUIBackend string `json:"hubbleUIBackend,omitempty"`
+	Certgen         string `json:"certgen,omitempty"`
+	Envoy           string `json:"envoy,omitempty"`
+	ClusterMesh     string `json:"clusterMesh,omitempty"`
+}
+
+// NewCiliumImages returns the image repositories under registry, the registry may contain a port and a path,
+// e.g. registry.local:5000/mirror. The operator repository has no suffix, the chart appends -generic to it.
+func NewCiliumImages(registry string) CiliumImages {
+	registry = strings.TrimSuffix(registry, "/")
+	if registry == "" {
+		return CiliumImages{}
+	}
+	return CiliumImages{
+		Cilium:          registry + "/cilium/cilium",
+		Operator:        registry + "/cilium/operator",
+		HubbleRelay:     registry + "/cilium/hubble-relay",
+		HubbleUI:        registry + "/cilium/hubble-ui",
+		HubbleUIBackend: registry + "/cilium/hubble-ui-backend",
+		Certgen:         registry + "/cilium/certgen",
+		Envoy:           registry + "/cilium/cilium-envoy",
+		ClusterMesh:     registry + "/cilium/clustermesh-apiserver",
+	}
+}
+
+// ciliumImageTags the tags of the images not released with cilium itself, by cilium minor version.
+type ciliumImageTags struct {
+	HubbleUI string
+	Certgen  string
+	// Envoy is empty when the chart does not deploy the standalone envoy DaemonSet by default.
+	Envoy string
+	// Etcd the etcd of the clustermesh-apiserver, empty when the etcd binary is in the clustermesh-apiserver image.
+	Etcd string
+	// CLI the cilium CLI release supporting the cilium minor version.
+	CLI string
+}
+
+var ciliumImageTagsByMinor = map[string]ciliumImageTags{
+	"1.13": {HubbleUI: "v0.11.0", Certgen: "v0.1.8", Etcd: "v3.5.4", CLI: "v0.14.8"},
+	"1.14": {HubbleUI: "v0.12.1", Certgen: "v0.1.9", Etcd: "v3.5.11", CLI: "v0.15.23"},
+	"1.15": {HubbleUI: "v0.13.0", Certgen: "v0.1.9", Etcd: "v3.5.11", CLI: "v0.16.4"},
+	"1.16": {HubbleUI: "v0.13.1", Certgen: "v0.2.0", Envoy: "v1.29.7-39a2a56bbd5b3a591f69dbca51d3e30ef97e0e51", CLI: "v0.16.19"},
 }
 
+// ciliumEtcdImage the etcd repository of the clustermesh-apiserver under the default repository.
+const ciliumEtcdImage = "quay.io/coreos/etcd"
+
 func (runnable *CiliumRunnable) Type() string {
 	return "cilium"
 }
 
+func (runnable *CiliumRunnable) DefaultNamespace() string {
+	return CiliumNamespaceDefault
+}
+
+// SupportedVersions the cilium minor versions whose images are known, see ciliumImageTagsByMinor.
+func (runnable *CiliumRunnable) SupportedVersions() []string {
+	minors := make([]string, 0, len(ciliumImageTagsByMinor))
+	for minor := range ciliumImageTagsByMinor {
+		minors = append(minors, minor)
+	}
+	sort.Slice(minors, func(i, j int) bool {
+		return utilversion.MustParseGeneric(minors[i]).LessThan(utilversion.MustParseGeneric(minors[j]))
+	})
+	return minors
+}
+
 func (runnable *CiliumRunnable) Create() Stepper {
 	return &CiliumRunnable{}
 }
@@ -61,134 +258,1980 @@ func (runnable *CiliumRunnable) InitStep(metadata *component.ExtraMetadata, cni
 	stepper.CriType = metadata.CRI
 	stepper.Offline = cni.Offline
 	stepper.Namespace = cni.Namespace
-	stepper.CiliumConfig = cni.Cilium
+	stepper.ManifestDir = clusterManifestDir(metadata.ClusterName, "cilium")
+	stepper.PodIPv4CIDR, stepper.PodIPv6CIDR = SplitPodCIDRs(networking)
+	stepper.DualStack = stepper.PodIPv4CIDR != "" && stepper.PodIPv6CIDR != ""
+	stepper.CiliumConfig = stepper.completeIPv6(cni.Cilium)
+	stepper.CiliumConfig = stepper.completeRouting(stepper.CiliumConfig)
+	stepper.CiliumConfig = stepper.completeDefaults(stepper.CiliumConfig)
 	if stepper.Namespace == "" {
 		stepper.Namespace = CiliumNamespaceDefault
 	}
+	stepper.Images = NewCiliumImages(stepper.LocalRegistry)
+	stepper.kubeProxyMode = metadata.KubeProxyMode
+	stepper.ValuesTemplate = metadata.CNITemplates[TemplateDataKey(CiliumTemplateKey)]
+	stepper.registryAccess = RegistryAccess{
+		Username: metadata.LocalRegistryUsername,
+		Password: metadata.LocalRegistryPassword,
+		CA:       metadata.LocalRegistryCA,
+	}
+	if cni.LocalRegistryConfig != nil {
+		stepper.registryAccess.InsecureSkipTLSVerify = cni.LocalRegistryConfig.InsecureSkipTLSVerify
+	}
+	if stepper.LocalRegistry != "" && stepper.registryAccess.Username != "" {
+		stepper.ImagePullSecret = CiliumRegistrySecretName
+	}
+	stepper.allNodes = utils.UnwrapNodeList(metadata.GetAllNodes())
+	stepper.nodeStates = newNodeStates(metadata)
+	stepper.masters = utils.UnwrapNodeList(metadata.Masters)
+	stepper.nodeLabels = NodeLabels(metadata.GetAllNodes())
+	stepper.apiServerPort = metadata.APIServerPort
+	stepper.agentPort = metadata.AgentPort
+	stepper.apiServerEndpoint = metadata.APIServerEndpoint
+	stepper.ControlPlaneNodes = len(stepper.masters)
+	stepper.LegacyTunnel = isLegacyTunnelVersion(stepper.Version)
+	if networking != nil {
+		stepper.serviceCIDRs = networking.Services.CIDRBlocks
+	}
+	if stepper.kubeProxyReplaced() {
+		stepper.K8sServiceHost, stepper.K8sServicePort = kubernetesServiceEndpoint(networking)
+	}
 	return stepper
 }
 
+func (runnable *CiliumRunnable) Validate() error {
+	if err := runnable.validateTimeouts(); err != nil {
+		return fieldError("timeouts", err)
+	}
+	if err := runnable.validateRetryPolicy(); err != nil {
+		return fieldError("retryPolicy", err)
+	}
+	if err := runnable.validateRestartWorkloads(); err != nil {
+		return fieldError("restartWorkloads", err)
+	}
+	if err := runnable.validateChartSource(); err != nil {
+		return fieldError("chartSource", err)
+	}
+	if err := runnable.validateRegistryPush(); err != nil {
+		return fieldError("pushToRegistry", err)
+	}
+	if err := runnable.validateHelmExecutor(); err != nil {
+		return fieldError("helmExecutor", err)
+	}
+	if runnable.CiliumConfig == nil {
+		return runnable.validateClusterPool(runnable.Defaults())
+	}
+	mode := string(runnable.CiliumConfig.KubeProxyReplacement)
+	if mode != "" && !ciliumKubeProxyReplacementModes.Has(mode) {
+		return fieldError("cilium.kubeProxyReplacement", fmt.Errorf("invalid cilium kubeProxyReplacement %q, supported values: %v", mode, ciliumKubeProxyReplacementModes.List()))
+	}
+	if translated := runnable.kubeProxyReplacementMode(); translated != mode {
+		logger.Infof("cilium kubeProxyReplacement %s is rendered as %s for cilium %s", mode, translated, runnable.Version)
+	}
+	if runnable.kubeProxyMode == kubeProxyModeEBPF && !runnable.kubeProxyReplaced() {
+		return fieldError("cilium.kubeProxyReplacement", fmt.Errorf("kube-proxy is not deployed when proxy mode is %s, cilium kubeProxyReplacement must be %s or %s",
+			kubeProxyModeEBPF, CiliumKubeProxyReplacementStrict, CiliumKubeProxyReplacementTrue))
+	}
+	if err := runnable.validateChaining(); err != nil {
+		return fieldError("cilium.cniChainingMode", err)
+	}
+	switch runnable.CiliumConfig.TunnelMode {
+	case "", CiliumTunnelVXLAN, CiliumTunnelGeneve:
+	case CiliumTunnelDisabled:
+		if runnable.CiliumConfig.NativeRoutingCIDR == "" && runnable.PodIPv6CIDR == "" {
+			return fieldError("cilium.nativeRoutingCIDR", fmt.Errorf("cilium nativeRoutingCIDR is required when tunnel mode is %s", CiliumTunnelDisabled))
+		}
+	default:
+		return fieldError("cilium.tunnelMode", fmt.Errorf("invalid cilium tunnel mode %q, supported values: %s, %s, %s",
+			runnable.CiliumConfig.TunnelMode, CiliumTunnelVXLAN, CiliumTunnelGeneve, CiliumTunnelDisabled))
+	}
+	if runnable.CiliumConfig.EnableBBR && !runnable.CiliumConfig.EnableBandwidthManager {
+		return fieldError("cilium.enableBBR", fmt.Errorf("cilium bbr requires the bandwidth manager to be enabled"))
+	}
+	if runnable.CiliumConfig.EnableBBR && !runnable.VersionAtLeast("1.12") {
+		return fieldError("cilium.enableBBR", fmt.Errorf("cilium bbr requires cilium >= 1.12, got %s", runnable.Version))
+	}
+	if enc := runnable.CiliumConfig.Encryption; enc != nil && enc.Type != CiliumEncryptionWireguard && enc.Type != CiliumEncryptionIPsec {
+		return fieldError("cilium.encryption.type", fmt.Errorf("invalid cilium encryption type %q, supported values: %s, %s", enc.Type, CiliumEncryptionWireguard, CiliumEncryptionIPsec))
+	}
+	if err := runnable.validateLoadBalancerTuning(); err != nil {
+		return err
+	}
+	if mode := runnable.CiliumConfig.PolicyEnforcementMode; mode != "" && !ciliumPolicyEnforcementModes.Has(mode) {
+		return fieldError("cilium.policyEnforcementMode", fmt.Errorf("invalid cilium policy enforcement mode %q, supported values: %v", mode, ciliumPolicyEnforcementModes.List()))
+	}
+	if err := runnable.validateClusterPool(runnable.CiliumConfig); err != nil {
+		return err
+	}
+	if err := runnable.validatePodIPPools(); err != nil {
+		return fieldError("cilium.pools", err)
+	}
+	if err := runnable.validateOperatorPlacement(); err != nil {
+		return err
+	}
+	if err := runnable.validateAgentPlacement(); err != nil {
+		return err
+	}
+	if err := runnable.validateMTU(); err != nil {
+		return fieldError("cilium.mtu", err)
+	}
+	if err := runnable.validateMetrics(); err != nil {
+		return fieldError("cilium.metrics", err)
+	}
+	if err := runnable.validateClusterMesh(); err != nil {
+		return fieldError("cilium.clusterMesh", err)
+	}
+	if err := runnable.validateKVStore(); err != nil {
+		return fieldError("cilium.kvstore", err)
+	}
+	if err := runnable.validateLoadBalancer(); err != nil {
+		return fieldError("cilium.loadBalancerIPPools", err)
+	}
+	if err := runnable.validateBGP(); err != nil {
+		return fieldError("cilium.bgp", err)
+	}
+	if err := runnable.validateEgressGateway(); err != nil {
+		return fieldError("cilium.egressGatewayPolicies", err)
+	}
+	if err := runnable.validateDNSProxy(); err != nil {
+		return fieldError("cilium.dnsProxyConfig", err)
+	}
+	if err := runnable.validateMonitorAggregation(); err != nil {
+		return fieldError("cilium.monitorAggregation", err)
+	}
+	if err := runnable.validateLogLevel(); err != nil {
+		return fieldError("cilium.logLevel", err)
+	}
+	if err := runnable.validateCLIMirror(); err != nil {
+		return fieldError("cilium.cliMirror", err)
+	}
+	if name := runnable.CiliumConfig.ReleaseName; name != "" {
+		// the preflight release appends a suffix, keep it within the helm limit of 53 characters
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 || len(runnable.preflightReleaseName()) > helmReleaseNameMaxLength {
+			return fieldError("cilium.releaseName", fmt.Errorf("invalid cilium release name %q: must be a DNS-1123 label of at most %d characters",
+				name, helmReleaseNameMaxLength-len("-preflight")))
+		}
+	}
+	if profile := runnable.CiliumConfig.Profile; profile != "" && !sets.NewString(runnable.Profiles()...).Has(profile) {
+		return fieldError("cilium.profile", fmt.Errorf("invalid cilium profile %q, supported values: %v", profile, runnable.Profiles()))
+	}
+	if runnable.CiliumConfig.HelmValues != "" {
+		values := make(map[string]interface{})
+		if err := yaml.Unmarshal([]byte(runnable.CiliumConfig.HelmValues), &values); err != nil {
+			return fieldError("cilium.helmValues", fmt.Errorf("invalid cilium helm values: %w", err))
+		}
+	}
+	return fieldError("cilium.extraSetArgs", ValidateHelmSetArgs(runnable.CiliumConfig.ExtraSetArgs))
+}
+
+// extraSetArgs the user --set arguments of the cilium release.
+func (runnable *CiliumRunnable) extraSetArgs() []string {
+	if runnable.CiliumConfig == nil {
+		return nil
+	}
+	return runnable.CiliumConfig.ExtraSetArgs
+}
+
+// validateOperatorPlacement checks the cilium-operator resource quantities and tolerations.
+func (runnable *CiliumRunnable) validateOperatorPlacement() error {
+	if err := validateResources("operator", runnable.CiliumConfig.OperatorResources); err != nil {
+		return fieldError("cilium.operatorResources", err)
+	}
+	return fieldError("cilium.operatorTolerations", validateTolerations("operator", runnable.CiliumConfig.OperatorTolerations))
+}
+
+// validateAgentPlacement checks the agent resources, priority class, tolerations and node selector, the node
+// selector must match a node of the cluster at least.
+func (runnable *CiliumRunnable) validateAgentPlacement() error {
+	if err := validateResources("agent", runnable.CiliumConfig.AgentResources); err != nil {
+		return fieldError("cilium.agentResources", err)
+	}
+	if name := runnable.CiliumConfig.PriorityClassName; name != "" {
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return fieldError("cilium.priorityClassName", fmt.Errorf("invalid cilium agent priority class name %q: %s", name, strings.Join(errs, ", ")))
+		}
+	}
+	if err := validateTolerations("agent", runnable.CiliumConfig.Tolerations); err != nil {
+		return fieldError("cilium.tolerations", err)
+	}
+	selector := runnable.CiliumConfig.NodeSelector
+	for k, v := range selector {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fieldError("cilium.nodeSelector", fmt.Errorf("invalid cilium agent node selector key %q: %s", k, strings.Join(errs, ", ")))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return fieldError("cilium.nodeSelector", fmt.Errorf("invalid cilium agent node selector value %q of %s: %s", v, k, strings.Join(errs, ", ")))
+		}
+	}
+	if len(selector) > 0 && len(runnable.allNodes) > 0 && len(runnable.agentNodes(runnable.allNodes)) == 0 {
+		return fieldError("cilium.nodeSelector", fmt.Errorf("cilium agent node selector %v matches no node of the cluster", selector))
+	}
+	return nil
+}
+
+func validateTolerations(kind string, tolerations []v1.CiliumToleration) error {
+	for i, t := range tolerations {
+		switch t.Operator {
+		case "", ciliumTolerationOpEqual:
+		case ciliumTolerationOpExists:
+			if t.Value != "" {
+				return fmt.Errorf("invalid cilium %s toleration %d: value must be empty when operator is %s", kind, i, ciliumTolerationOpExists)
+			}
+		default:
+			return fmt.Errorf("invalid cilium %s toleration %d operator %q, supported values: %s, %s",
+				kind, i, t.Operator, ciliumTolerationOpExists, ciliumTolerationOpEqual)
+		}
+		if t.Key == "" && t.Operator != ciliumTolerationOpExists {
+			return fmt.Errorf("invalid cilium %s toleration %d: operator must be %s when key is empty", kind, i, ciliumTolerationOpExists)
+		}
+		if t.Effect != "" && !ciliumTolerationEffects.Has(t.Effect) {
+			return fmt.Errorf("invalid cilium %s toleration %d effect %q, supported values: %v", kind, i, t.Effect, ciliumTolerationEffects.List())
+		}
+	}
+	return nil
+}
+
+// agentNodes returns the nodes the cilium agent DaemonSet is scheduled to by the agent NodeSelector.
+func (runnable *CiliumRunnable) agentNodes(nodes []v1.StepNode) []v1.StepNode {
+	if runnable.CiliumConfig == nil {
+		return nodes
+	}
+	return selectNodes(nodes, runnable.nodeLabels, runnable.CiliumConfig.NodeSelector)
+}
+
+// AgentPlacementHash returns the hash of the cilium agent node selector, tolerations, resources and priority
+// class of c, it is empty when none of them is set.
+func AgentPlacementHash(c *v1.CNI) string {
+	if c.Type != "cilium" || c.Cilium == nil {
+		return ""
+	}
+	resources := nonEmptyResources(c.Cilium.AgentResources)
+	if len(c.Cilium.NodeSelector) == 0 && len(c.Cilium.Tolerations) == 0 && resources == nil && c.Cilium.PriorityClassName == "" {
+		return ""
+	}
+	data, _ := json.Marshal(struct {
+		NodeSelector      map[string]string           `json:"nodeSelector,omitempty"`
+		Tolerations       []v1.CiliumToleration       `json:"tolerations,omitempty"`
+		Resources         *v1.CiliumOperatorResources `json:"resources,omitempty"`
+		PriorityClassName string                      `json:"priorityClassName,omitempty"`
+	}{c.Cilium.NodeSelector, c.Cilium.Tolerations, resources, c.Cilium.PriorityClassName})
+	return fmt.Sprintf("%x", sha256.Sum256(data))[:16]
+}
+
+// AgentResources the cilium agent container resources, nil when neither requests nor limits are set so that the
+// chart defaults apply.
+func (runnable *CiliumRunnable) AgentResources() *v1.CiliumOperatorResources {
+	if runnable.CiliumConfig == nil {
+		return nil
+	}
+	return nonEmptyResources(runnable.CiliumConfig.AgentResources)
+}
+
+// AgentPriorityClassName the priority class of the cilium agent pods.
+func (runnable *CiliumRunnable) AgentPriorityClassName() string {
+	if runnable.CiliumConfig == nil || runnable.CiliumConfig.PriorityClassName == "" {
+		return ciliumAgentPriorityClassDefault
+	}
+	return runnable.CiliumConfig.PriorityClassName
+}
+
+// nonEmptyResources returns res, nil when it has neither requests nor limits.
+func nonEmptyResources(res *v1.CiliumOperatorResources) *v1.CiliumOperatorResources {
+	if res == nil || (len(res.Requests) == 0 && len(res.Limits) == 0) {
+		return nil
+	}
+	return res
+}
+
+func validateResources(component string, res *v1.CiliumOperatorResources) error {
+	if res == nil {
+		return nil
+	}
+	if err := validateQuantities(component, "requests", res.Requests); err != nil {
+		return err
+	}
+	return validateQuantities(component, "limits", res.Limits)
+}
+
+func validateQuantities(component, kind string, list map[string]string) error {
+	for name, quantity := range list {
+		if _, err := resource.ParseQuantity(quantity); err != nil {
+			return fmt.Errorf("invalid cilium %s %s %s quantity %q: %w", component, kind, name, quantity, err)
+		}
+	}
+	return nil
+}
+
+// validateClusterPool checks the cluster-pool IPAM CIDRs against Networking and the cluster nodes.
+func (runnable *CiliumRunnable) validateClusterPool(config *v1.Cilium) error {
+	if mode := config.IPAMMode; (mode != "" && mode != "cluster-pool") || config.CNIChainingMode != "" {
+		return nil
+	}
+	var nodeIPs []string
+	for _, node := range runnable.allNodes {
+		nodeIPs = append(nodeIPs, node.IPv4, node.NodeIPv4)
+	}
+	if err := ValidatePodCIDRPool(config.ClusterPoolIPv4PodCIDRList, config.ClusterPoolIPv4MaskSize,
+		runnable.serviceCIDRs, nodeIPs, len(runnable.allNodes)); err != nil {
+		return fieldError("cilium.clusterPoolIPv4PodCIDRList", fmt.Errorf("invalid cilium clusterPoolIPv4PodCIDRList: %w", err))
+	}
+	if err := ValidatePodCIDRPool(config.ClusterPoolIPv6PodCIDRList, config.ClusterPoolIPv6MaskSize,
+		runnable.serviceCIDRs, nodeIPs, len(runnable.allNodes)); err != nil {
+		return fieldError("cilium.clusterPoolIPv6PodCIDRList", fmt.Errorf("invalid cilium clusterPoolIPv6PodCIDRList: %w", err))
+	}
+	return nil
+}
+
+// ChainingMode the cni chaining mode, empty when cilium is the only cni.
+func (runnable *CiliumRunnable) ChainingMode() string {
+	if runnable.CiliumConfig == nil {
+		return ""
+	}
+	return runnable.CiliumConfig.CNIChainingMode
+}
+
+// validateChaining checks the chained cni owns the pod IPs and their routing.
+func (runnable *CiliumRunnable) validateChaining() error {
+	config := runnable.CiliumConfig
+	mode := config.CNIChainingMode
+	if mode == "" {
+		if config.CustomCNIConf != "" {
+			return fmt.Errorf("cilium customCNIConf requires a cniChainingMode")
+		}
+		return nil
+	}
+	if !ciliumChainingModes.Has(mode) {
+		return fmt.Errorf("invalid cilium cni chaining mode %q, supported values: %v", mode, ciliumChainingModes.List())
+	}
+	if len(config.ClusterPoolIPv4PodCIDRList) > 0 || len(config.ClusterPoolIPv6PodCIDRList) > 0 {
+		return fmt.Errorf("cilium cni chaining mode %s can not be used with the cluster pool, the chained cni allocates the pod IPs", mode)
+	}
+	if config.TunnelMode != "" {
+		return fmt.Errorf("cilium cni chaining mode %s can not be used with tunnel mode %s, the chained cni routes the pods", mode, config.TunnelMode)
+	}
+	if mode == CiliumChainingGenericVeth && config.CustomCNIConf == "" {
+		return fmt.Errorf("cilium cni chaining mode %s requires customCNIConf", mode)
+	}
+	return nil
+}
+
+// OperatorReplicas the cilium-operator replicas, the user value wins. Otherwise HA clusters and the production
+// profile run 2 replicas so that the operator survives the loss of a master, other clusters run 1.
+func (runnable *CiliumRunnable) OperatorReplicas() int {
+	if runnable.CiliumConfig != nil && runnable.CiliumConfig.OperatorReplicas > 0 {
+		return runnable.CiliumConfig.OperatorReplicas
+	}
+	if runnable.ControlPlaneNodes > 1 || runnable.Profile() == CiliumProfileProduction {
+		return ciliumHAOperatorReplicas
+	}
+	return 1
+}
+
+// completeIPv6 fills the IPv6 cluster pool from the pod CIDR of Networking,
+// the user configuration is copied rather than modified.
+func (runnable *CiliumRunnable) completeIPv6(config *v1.Cilium) *v1.Cilium {
+	if runnable.PodIPv6CIDR == "" || (config != nil && (config.CNIChainingMode != "" || len(config.Pools) > 0)) {
+		return config
+	}
+	completed := &v1.Cilium{
+		OperatorReplicas:        1,
+		ClusterPoolIPv4MaskSize: ciliumDefaultIPv4MaskSize,
+		KubeProxyReplacement:    CiliumKubeProxyReplacementFalse,
+	}
+	if runnable.PodIPv4CIDR != "" {
+		completed.ClusterPoolIPv4PodCIDRList = []string{runnable.PodIPv4CIDR}
+	}
+	if config != nil {
+		*completed = *config
+	}
+	if len(completed.ClusterPoolIPv6PodCIDRList) == 0 {
+		completed.ClusterPoolIPv6PodCIDRList = []string{runnable.PodIPv6CIDR}
+	}
+	if completed.ClusterPoolIPv6MaskSize == 0 {
+		completed.ClusterPoolIPv6MaskSize = ciliumDefaultIPv6MaskSize
+	}
+	return completed
+}
+
+// Defaults the effective cilium configuration of the version, the fields the cluster leaves unset render these
+// values. The operator replicas are left out, they follow the masters and the profile, see OperatorReplicas.
+func (runnable *CiliumRunnable) Defaults() *v1.Cilium {
+	kubeProxyReplacement := CiliumKubeProxyReplacementFalse
+	if runnable.SemVer() != nil && !runnable.VersionAtLeast("1.14") {
+		kubeProxyReplacement = CiliumKubeProxyReplacementDisabled
+	}
+	return &v1.Cilium{
+		IPAMMode:                   CiliumIPAMClusterPool,
+		ClusterPoolIPv4PodCIDRList: []string{ciliumDefaultIPv4PodCIDR},
+		ClusterPoolIPv4MaskSize:    ciliumDefaultIPv4MaskSize,
+		ClusterPoolIPv6MaskSize:    ciliumDefaultIPv6MaskSize,
+		KubeProxyReplacement:       v1.CiliumKubeProxyReplacement(kubeProxyReplacement),
+		ReadinessCheckTimeout:      &metav1.Duration{Duration: ciliumDefaultReadinessTimeout},
+		LogLevel:                   CiliumLogLevelInfo,
+	}
+}
+
+func (runnable *CiliumRunnable) setDefaults(cni *v1.CNI) {
+	versioned := &CiliumRunnable{}
+	versioned.Version = cni.Version
+	cni.Cilium = versioned.Defaults()
+}
+
+// completeDefaults applies the IPAM of Defaults, the cluster pool of Defaults when there is no cilium configuration
+// and only the IPAM mode otherwise, the pools of the configuration render the multi-pool IPAM. The user
+// configuration is copied rather than modified.
+func (runnable *CiliumRunnable) completeDefaults(config *v1.Cilium) *v1.Cilium {
+	defaults := runnable.Defaults()
+	if config == nil {
+		return &v1.Cilium{
+			IPAMMode:                   defaults.IPAMMode,
+			ClusterPoolIPv4PodCIDRList: defaults.ClusterPoolIPv4PodCIDRList,
+			ClusterPoolIPv4MaskSize:    defaults.ClusterPoolIPv4MaskSize,
+		}
+	}
+	if config.IPAMMode != "" || len(config.Pools) > 0 {
+		return config
+	}
+	completed := *config
+	completed.IPAMMode = defaults.IPAMMode
+	return &completed
+}
+
+// completeRouting defaults the native routing CIDR to the pod CIDR, the chart install fails without it.
+func (runnable *CiliumRunnable) completeRouting(config *v1.Cilium) *v1.Cilium {
+	if config == nil || config.TunnelMode != CiliumTunnelDisabled || config.NativeRoutingCIDR != "" {
+		return config
+	}
+	completed := *config
+	completed.NativeRoutingCIDR = runnable.PodIPv4CIDR
+	return &completed
+}
+
+// isLegacyTunnelVersion reports whether the cilium version predates routingMode and tunnelProtocol.
+func isLegacyTunnelVersion(version string) bool {
+	return !(&BaseCni{CNI: v1.CNI{Version: version}}).VersionAtLeast("1.14")
+}
+
+// kubeProxyReplacementMode the kubeProxyReplacement mode translated to the modes of the cilium version, the
+// mode is kept for cilium 1.14 and the unknown versions.
+func (runnable *CiliumRunnable) kubeProxyReplacementMode() string {
+	if runnable.CiliumConfig == nil {
+		return ""
+	}
+	mode := string(runnable.CiliumConfig.KubeProxyReplacement)
+	translations := map[string]string(nil)
+	switch {
+	case runnable.SemVer() == nil:
+	case !runnable.VersionAtLeast("1.14"):
+		translations = ciliumLegacyKubeProxyReplacements
+	case runnable.VersionAtLeast("1.15"):
+		translations = ciliumBoolKubeProxyReplacements
+	}
+	if translated, ok := translations[mode]; ok {
+		return translated
+	}
+	return mode
+}
+
+// KubeProxyReplacementValue the kubeProxyReplacement value of the chart version: a boolean from cilium 1.15,
+// the quoted mode before. The mode defaults to the one disabling the replacement.
+func (runnable *CiliumRunnable) KubeProxyReplacementValue() string {
+	mode := runnable.kubeProxyReplacementMode()
+	switch {
+	case runnable.SemVer() != nil && !runnable.VersionAtLeast("1.14"):
+		return strconv.Quote(strutil.StringDefaultIfEmpty(CiliumKubeProxyReplacementDisabled, mode))
+	case runnable.SemVer() != nil && runnable.VersionAtLeast("1.15"):
+		return strutil.StringDefaultIfEmpty(CiliumKubeProxyReplacementFalse, mode)
+	default:
+		return strconv.Quote(strutil.StringDefaultIfEmpty(CiliumKubeProxyReplacementFalse, mode))
+	}
+}
+
+// kubeProxyReplaced reports whether cilium fully takes over the kube-proxy.
+func (runnable *CiliumRunnable) kubeProxyReplaced() bool {
+	if runnable.CiliumConfig == nil {
+		return false
+	}
+	mode := string(runnable.CiliumConfig.KubeProxyReplacement)
+	return mode == CiliumKubeProxyReplacementStrict || mode == CiliumKubeProxyReplacementTrue
+}
+
 func (runnable *CiliumRunnable) LoadImage(nodes []v1.StepNode) ([]v1.Step, error) {
+	// the other nodes pull the images the push node pushes, it can not be skipped
+	if node, ok := runnable.pushNode(nodes); ok {
+		if err := runnable.nodeStates.require([]v1.StepNode{node}); err != nil {
+			return nil, err
+		}
+	}
+	nodes = runnable.nodeStates.available(nodes)
 	var steps []v1.Step
-	bytes, err := json.Marshal(runnable)
+	configure, err := runnable.configureRegistry(nodes)
 	if err != nil {
 		return nil, err
 	}
-
-	if runnable.Offline && runnable.LocalRegistry == "" {
-		return []v1.Step{LoadImage("cilium", bytes, nodes)}, nil
+	if configure != nil {
+		steps = append(steps, *configure)
+	}
+	if runnable.loadsImages() {
+		if !v1.AllowedCRIType.Has(runnable.CriType) {
+			return nil, fmt.Errorf("unsupported cri type %q", runnable.CriType)
+		}
+		retags, err := runnable.imageRetags()
+		if err != nil {
+			return nil, err
+		}
+		// the images are not needed on the nodes the agent is not scheduled to
+		if agents := runnable.agentNodes(nodes); len(agents) > 0 {
+			loadSteps, err := loadImageSteps("cilium", agents, runnable.imageLoadTimeout(), func(arch string) ([]byte, error) {
+				target := *runnable
+				target.Arch = arch
+				target.Retags = retags
+				return json.Marshal(&target)
+			})
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, withNodeConcurrency(loadSteps)...)
+		}
+	}
+	if node, ok := runnable.pushNode(nodes); ok {
+		push, err := runnable.pushImages(node)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, push)
 	}
 
-	return steps, nil
+	return runnable.stableSteps(runnable.withRetryPolicy(steps), nil)
 }
 
+// InstallSteps installs the cilium release from the helm executor, see helmExecutor, the cluster scoped steps fail
+// over to the other available masters when the agent of the executor does not respond.
 func (runnable *CiliumRunnable) InstallSteps(nodes []v1.StepNode, kubernetesVersion string) ([]v1.Step, error) {
-	var steps []v1.Step
-	bytes, err := json.Marshal(runnable)
+	nodes, failover, err := runnable.helmExecutor(nodes)
 	if err != nil {
 		return nil, err
 	}
+	if err := runnable.checkCompatibility(kubernetesVersion); err != nil {
+		return nil, err
+	}
+	var steps []v1.Step
 	chart := &common.Chart{
 		PkgName: "cilium",
 		Version: runnable.Version,
 		Offline: runnable.Offline,
+		Source:  runnable.ChartSource,
 	}
 
+	checks, report, err := runnable.preflightSteps(nodes)
+	if err != nil {
+		return nil, err
+	}
+	steps = append(steps, report...)
+	if runnable.encryptionType() == CiliumEncryptionWireguard {
+		steps = append(steps, runnable.checkWireguard())
+	}
+	if runnable.CiliumConfig != nil && runnable.CiliumConfig.EnableBBR {
+		steps = append(steps, runnable.checkBBRKernel())
+	}
+	if runnable.L2Announcements() && len(runnable.loadBalancerIPPools()) > 0 {
+		steps = append(steps, runnable.checkLoadBalancerNetwork())
+	}
+	if runnable.serviceMonitorEnabled() {
+		steps = append(steps, runnable.checkServiceMonitorCRD(nodes))
+	}
+	if runnable.Offline && runnable.LocalRegistry != "" && !runnable.SkipImageVerification {
+		verify, err := runnable.verifyRegistryImages(nodes)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, verify)
+	}
 	cLoadSteps, err := chart.InstallStepsV2(nodes)
 	if err != nil {
 		return nil, err
 	}
-	steps = append(steps, cLoadSteps...)
-	steps = append(steps, RenderYaml("cilium", bytes, nodes))
-	steps = append(steps, InstallCiliumRelease(filepath.Join(downloader.BaseDstDir, "."+chart.PkgName, chart.Version, downloader.ChartFilename), filepath.Join(manifestDir, "cilium.yaml"), runnable.Namespace, nodes))
+	steps = append(steps, inParallel(stagedOnFailover(cLoadSteps))...)
+	renderSteps, err := runnable.renderValues(runnable, nodes)
+	if err != nil {
+		return nil, err
+	}
+	steps = append(steps, renderSteps...)
+	steps = append(steps, PrepareNamespace("prepareCiliumNamespace", runnable.Namespace, nodes))
+	if runnable.ImagePullSecret != "" {
+		secret, err := runnable.createPullSecret(nodes)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, secret)
+	}
+	if runnable.encryptionType() == CiliumEncryptionIPsec {
+		steps = append(steps, runnable.createIPsecKeys(nodes))
+	}
+	if runnable.KVStore() != nil {
+		steps = append(steps, runnable.createKVStoreSecret(nodes))
+	}
+	if runnable.policyEnforcementMode() == CiliumPolicyEnforcementAlways {
+		steps = append(steps, runnable.warnPolicyEnforcement(nodes))
+	}
+	release := runnable.ReleaseName()
+	steps = append(steps, CheckHelmReleaseOwner("checkCiliumRelease", release, runnable.Namespace, nodes))
+	values := runnable.manifestPath("cilium.yaml")
+	install := InstallCiliumRelease(release, filepath.Join(downloader.BaseDstDir, "."+chart.PkgName, chart.Version, downloader.ChartFilename), values, runnable.Namespace, nodes,
+		HelmReleaseOptions{Timeout: runnable.installTimeout(0), SetArgs: runnable.extraSetArgs(), APIServerEndpoint: runnable.apiServerEndpoint})
+	// the release needs the downloaded chart and the rendered values, the image load does not wait on them
+	for _, step := range append(cLoadSteps, renderSteps...) {
+		install.DependsOn = append(install.DependsOn, step.Name)
+	}
+	record, err := RecordHelmRelease(install, release, runnable.Namespace, values)
+	if err != nil {
+		return nil, err
+	}
+	steps = append(steps, install, MarkHelmRelease("markCiliumRelease", release, runnable.Namespace, nodes), record)
+	cli, err := runnable.installCLI(nodes)
+	if err != nil {
+		return nil, err
+	}
+	// the diagnostics fall back to kubectl and helm without the CLI, it does not fail the install
+	cli.ErrIgnore = true
+	steps = append(steps, cli)
+	if runnable.kubeProxyReplaced() && runnable.kubeProxyMode != kubeProxyModeEBPF {
+		steps = append(steps, removeKubeProxy("cilium", runnable.Namespace, nodes), cleanKubeProxyRules(runnable.allNodes))
+	}
+	if runnable.CiliumConfig == nil || !runnable.CiliumConfig.SkipReadinessCheck {
+		steps = append(steps, runnable.checkReady(nodes))
+	}
+	// the pods created on the previous cni move to cilium
+	steps = append(steps, RestartWorkloads(runnable.RestartWorkloads, runnable.allNodes, nodes)...)
+	if runnable.HostFirewall() {
+		policy, err := runnable.applyHostFirewallPolicy(nodes)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, policy)
+	}
+	if runnable.loadBalancerEnabled() {
+		lb, err := runnable.applyLoadBalancer(nodes)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, lb)
+	}
+	if runnable.BGP() != nil {
+		bgp, err := runnable.applyBGP(nodes)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, bgp)
+	}
+	if len(runnable.egressGatewayPolicies()) > 0 {
+		egress, err := runnable.applyEgressGateway(nodes)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, egress)
+	}
+	if len(runnable.podIPPools()) > 1 {
+		pools, err := runnable.applyPodIPPools(nodes)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, pools)
+	}
 
-	return steps, nil
+	// the preflight checks of the nodes stay on their node, the other steps run on the executor
+	steps = append(checks, withFailover(steps, nodes, failover)...)
+	// the console follows the chart download, the helm actions and the readiness checks live
+	return runnable.stableSteps(withProgress(runnable.withRetryPolicy(steps)), nil)
 }
 
-func (runnable *CiliumRunnable) UninstallSteps(nodes []v1.StepNode) ([]v1.Step, error) {
-	bytes, err := json.Marshal(runnable)
+// UpgradeSteps upgrades cilium following the upstream procedure, the pre-flight DaemonSet pulls the new images
+// on every node before the agents are restarted so that the upgrade does not wait on image pulls.
+// When fromVersion is toVersion the release values are refreshed in place instead, e.g. to apply a changed
+// agent placement, without the pre-flight DaemonSet. The refresh diffs the rendered release against the deployed
+// one first when the agents run the helm SDK, see v1.Step Diff. The release is upgraded from the helm executor like
+// InstallSteps.
+func (runnable *CiliumRunnable) UpgradeSteps(nodes []v1.StepNode, fromVersion, toVersion string) ([]v1.Step, error) {
+	nodes, failover, err := runnable.helmExecutor(nodes)
 	if err != nil {
 		return nil, err
 	}
+	refresh := fromVersion == toVersion
+	if !refresh {
+		if err := CheckUpgradeVersion(fromVersion, toVersion); err != nil {
+			return nil, err
+		}
+	}
+	target := *runnable
+	target.Version = toVersion
+	target.LegacyTunnel = isLegacyTunnelVersion(toVersion)
 	var steps []v1.Step
-	if runnable.Offline && runnable.LocalRegistry == "" {
-		steps = append(steps, RemoveImage("cilium", bytes, nodes))
+	chart := &common.Chart{
+		PkgName: "cilium",
+		Version: target.Version,
+		Offline: target.Offline,
+		Source:  target.ChartSource,
 	}
-	steps = append(steps, v1.Step{
-		ID:         strutil.GetUUID(),
-		Name:       "uninstallCiliumRelease",
-		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
-		ErrIgnore:  true,
-		RetryTimes: 1,
-		Nodes:      nodes,
-		Action:     v1.ActionUninstall,
-		Commands: []v1.Command{
-			{
-				Type:         v1.CommandShell,
-				ShellCommand: []string{"helm", "uninstall", "cilium", "-n", runnable.Namespace},
-			},
-		},
-	})
-	return steps, nil
-}
-
-func (runnable *CiliumRunnable) CmdList(namespace string) map[string]string {
-	cmdList := make(map[string]string)
-	cmdList["get"] = fmt.Sprintf("kubectl get po -n %s | grep cilium", namespace)
-	cmdList["restart"] = fmt.Sprintf("kubectl rollout restart ds cilium -n %s", namespace)
-
-	return cmdList
-}
 
-func (runnable *CiliumRunnable) Render(ctx context.Context, opts component.Options) error {
-	if err := os.MkdirAll(manifestDir, 0755); err != nil {
-		return err
+	if len(target.allNodes) > 0 {
+		loadSteps, err := target.LoadImage(target.allNodes)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, loadSteps...)
 	}
-	manifestFile := filepath.Join(manifestDir, "cilium.yaml")
-	return fileutil.WriteFileWithContext(ctx, manifestFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644,
-		runnable.renderCiliumTo, opts.DryRun)
-}
-
-func (runnable *CiliumRunnable) renderCiliumTo(w io.Writer) error {
-	at := tmplutil.New()
-	ciliumTemp, err := runnable.CiliumTemplate()
+	if target.serviceMonitorEnabled() {
+		crd := target.checkServiceMonitorCRD(nodes)
+		crd.Action = v1.ActionUpgrade
+		steps = append(steps, crd)
+	}
+	cLoadSteps, err := chart.InstallStepsV2(nodes)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if _, err := at.RenderTo(w, ciliumTemp, runnable); err != nil {
-		return err
+	steps = append(steps, inParallel(stagedOnFailover(cLoadSteps))...)
+	renderSteps, err := target.renderValues(&target, nodes)
+	if err != nil {
+		return nil, err
+	}
+	steps = append(steps, renderSteps...)
+	chartPath := filepath.Join(downloader.BaseDstDir, "."+chart.PkgName, chart.Version, downloader.ChartFilename)
+	values := target.manifestPath("cilium.yaml")
+	if !refresh {
+		steps = append(steps, target.installPreflight(chartPath, values, nodes), target.removePreflight(nodes))
+	} else if HelmSDKSupported(nodes) {
+		diff := DiffHelmRelease("diffCiliumRelease", target.ReleaseName(), target.Namespace, chartPath, values, nodes,
+			HelmReleaseOptions{SetArgs: target.extraSetArgs()})
+		steps = append(steps, withHelmKubeconfig(diff, target.ReleaseName(), target.apiServerEndpoint))
+	}
+	if target.HostFirewall() {
+		// the installed release registered the CRD, the policy must be in place before the upgrade enables the firewall
+		policy, err := target.applyHostFirewallPolicy(nodes)
+		if err != nil {
+			return nil, err
+		}
+		policy.Action = v1.ActionUpgrade
+		steps = append(steps, policy)
+	}
+	// the refresh replaces the values so that the removed placement settings fall back to the chart defaults
+	upgrade := ciliumReleaseStep("upgradeCiliumRelease", target.ReleaseName(), chartPath, values, target.Namespace, nodes,
+		HelmReleaseOptions{ReuseValues: !refresh, Timeout: target.installTimeout(0), SetArgs: target.extraSetArgs(), APIServerEndpoint: target.apiServerEndpoint})
+	upgrade.Action = v1.ActionUpgrade
+	mark := MarkHelmRelease("markCiliumRelease", target.ReleaseName(), target.Namespace, nodes)
+	mark.Action = v1.ActionUpgrade
+	record, err := RecordHelmRelease(upgrade, target.ReleaseName(), target.Namespace, values)
+	if err != nil {
+		return nil, err
+	}
+	// the CLI step installs the CLI of the new version, a custom step only installs with the install action
+	cli, err := target.installCLI(nodes)
+	if err != nil {
+		return nil, err
+	}
+	cli.ErrIgnore = true
+	ready := target.checkReady(nodes)
+	ready.Action = v1.ActionUpgrade
+	steps = append(steps, upgrade, mark, record, cli, ready)
+	// the pods keep the network configuration of the previous release until they are restarted
+	for _, restart := range RestartWorkloads(target.RestartWorkloads, target.allNodes, nodes) {
+		restart.Action = v1.ActionUpgrade
+		steps = append(steps, restart)
+	}
+	if target.loadBalancerEnabled() {
+		lb, err := target.applyLoadBalancer(nodes)
+		if err != nil {
+			return nil, err
+		}
+		lb.Action = v1.ActionUpgrade
+		steps = append(steps, lb)
+	}
+	if target.BGP() != nil {
+		bgp, err := target.applyBGP(nodes)
+		if err != nil {
+			return nil, err
+		}
+		bgp.Action = v1.ActionUpgrade
+		steps = append(steps, bgp)
+	}
+	if len(target.egressGatewayPolicies()) > 0 {
+		egress, err := target.applyEgressGateway(nodes)
+		if err != nil {
+			return nil, err
+		}
+		egress.Action = v1.ActionUpgrade
+		steps = append(steps, egress)
+	}
+	if len(target.podIPPools()) > 1 {
+		pools, err := target.applyPodIPPools(nodes)
+		if err != nil {
+			return nil, err
+		}
+		pools.Action = v1.ActionUpgrade
+		steps = append(steps, pools)
+	}
+
+	steps = withFailover(steps, nodes, failover)
+	return runnable.stableSteps(withProgress(runnable.withRetryPolicy(steps)), nil)
+}
+
+// installPreflight deploys the cilium-pre-flight-check DaemonSet of the new chart and waits for its rollout.
+func (runnable *CiliumRunnable) installPreflight(chartPath, values string, nodes []v1.StepNode) v1.Step {
+	timeout := runnable.readinessTimeout()
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "checkCiliumPreflight",
+		Timeout:    metav1.Duration{Duration: timeout + time.Minute},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUpgrade,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{common.HelmBin, "upgrade", "--install", runnable.preflightReleaseName(), "-n", runnable.Namespace, chartPath, "-f", values,
+					"--set", "preflight.enabled=true", "--set", "agent=false", "--set", "operator.enabled=false"},
+			},
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "rollout", "status", "ds/cilium-pre-flight-check", "-n", runnable.Namespace, "--timeout", timeout.String()},
+			},
+		},
+	}
+}
+
+func (runnable *CiliumRunnable) removePreflight(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "removeCiliumPreflight",
+		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUpgrade,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{common.HelmBin, "uninstall", runnable.preflightReleaseName(), "-n", runnable.Namespace},
+			},
+		},
+	}
+}
+
+// GetImages returns the images deployed by the cilium chart of version with the current configuration,
+// rewritten to LocalRegistry when it is set. Offline installs load a single images.tar.gz, so the package
+// must be built from the list of the hubble components that are going to be enabled. The cilium images are
+// multi-arch, so the list is the same for amd64 and arm64 and the architecture is selected when the images are pulled.
+func (runnable *CiliumRunnable) GetImages(version string, criType string) ([]string, error) {
+	if criType != "" && !v1.AllowedCRIType.Has(criType) {
+		return nil, fmt.Errorf("unsupported cri type %q", criType)
+	}
+	v, err := utilversion.ParseGeneric(version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cilium version %q: %w", version, err)
+	}
+	tags, ok := ciliumImageTagsByMinor[fmt.Sprintf("%d.%d", v.Major(), v.Minor())]
+	if !ok {
+		return nil, fmt.Errorf("cilium version %s is not supported", version)
+	}
+	tag := "v" + strings.TrimPrefix(version, "v")
+	images := NewCiliumImages(strutil.StringDefaultIfEmpty(strings.TrimSuffix(ciliumDefaultImageRepository, "/cilium"), runnable.LocalRegistry))
+	list := []string{images.Cilium + ":" + tag, images.Operator + "-generic:" + tag}
+	if tags.Envoy != "" {
+		list = append(list, images.Envoy+":"+tags.Envoy)
+	}
+	if runnable.clusterMeshEnabled() {
+		list = append(list, images.ClusterMesh+":"+tag)
+		if tags.Etcd != "" {
+			list = append(list, runnable.EtcdImage()+":"+tags.Etcd)
+		}
+	}
+	if runnable.DNSProxy() {
+		list = append(list, runnable.DNSProbeImage())
+	}
+	if !runnable.hubbleEnabled() {
+		return list, nil
+	}
+	list = append(list, images.Certgen+":"+tags.Certgen)
+	if runnable.CiliumConfig.EnableHubbleRelay || runnable.CiliumConfig.EnableHubbleUI {
+		list = append(list, images.HubbleRelay+":"+tag)
+	}
+	if runnable.CiliumConfig.EnableHubbleUI {
+		list = append(list, images.HubbleUI+":"+tags.HubbleUI, images.HubbleUIBackend+":"+tags.HubbleUI)
+	}
+	return list, nil
+}
+
+// imageRetags pairs the images of the offline package with the names the rendered values reference where they
+// differ, e.g. the LocalRegistry names of the images, the image load steps tag the loaded images with them.
+func (runnable *CiliumRunnable) imageRetags() ([]RegistryImage, error) {
+	images, err := runnable.registryImages()
+	if err != nil {
+		return nil, err
+	}
+	var retags []RegistryImage
+	for _, image := range images {
+		if image.Source != image.Target {
+			retags = append(retags, image)
+		}
+	}
+	return retags, nil
+}
+
+// loadsImages reports whether the offline package is loaded on the nodes, it is not when the nodes pull the images
+// from the LocalRegistry the package is pushed to.
+func (runnable *CiliumRunnable) loadsImages() bool {
+	return runnable.Offline && (runnable.LocalRegistry == "" || !runnable.PushToRegistry)
+}
+
+func (runnable *CiliumRunnable) UninstallSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	var steps []v1.Step
+	// the release and secrets are only removed when the whole cluster is uninstalled, not when nodes are removed
+	if clusterNodes, ok := runnable.clusterScopedNodes(nodes); ok {
+		if runnable.loadBalancerEnabled() {
+			steps = append(steps, runnable.deleteLoadBalancer(clusterNodes))
+		}
+		if runnable.BGP() != nil {
+			steps = append(steps, runnable.deleteBGP(clusterNodes))
+		}
+		if runnable.HostFirewall() {
+			steps = append(steps, runnable.deleteHostFirewallPolicy(clusterNodes))
+		}
+		if runnable.EgressGateway() {
+			steps = append(steps, runnable.deleteEgressGateway(clusterNodes))
+		}
+		if runnable.MultiPool() {
+			steps = append(steps, runnable.deletePodIPPools(clusterNodes))
+		}
+		steps = append(steps, v1.Step{
+			ID:         newStepID(),
+			Name:       "uninstallCiliumRelease",
+			Timeout:    metav1.Duration{Duration: runnable.uninstallTimeout(1 * time.Minute)},
+			ErrIgnore:  true,
+			RetryTimes: 1,
+			Nodes:      clusterNodes,
+			Action:     v1.ActionUninstall,
+			Commands:   []v1.Command{runnable.uninstallReleaseCommand(clusterNodes)},
+		})
+		if runnable.Purge {
+			steps = append(steps, runnable.purgeSteps(clusterNodes)...)
+		}
+		if runnable.hubbleEnabled() {
+			steps = append(steps, runnable.clearHubble(clusterNodes))
+		}
+		if runnable.encryptionType() == CiliumEncryptionIPsec {
+			steps = append(steps, runnable.removeIPsecKeys(clusterNodes))
+		}
+		if runnable.KVStore() != nil {
+			steps = append(steps, runnable.removeKVStoreSecret(clusterNodes))
+		}
+		if runnable.ImagePullSecret != "" {
+			steps = append(steps, runnable.removePullSecret(clusterNodes))
+		}
+		if runnable.Namespace != CiliumNamespaceDefault {
+			steps = append(steps, RemoveCreatedNamespace("removeCiliumNamespace", runnable.Namespace, clusterNodes))
+		}
+		steps = append(steps, runnable.removeManifests(clusterNodes))
+		if runnable.PushToRegistry && runnable.RemovePushedImages {
+			remove, err := runnable.removePushedImages(clusterNodes)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, remove)
+		}
+		if runnable.RemoveHelm {
+			helm, err := common.GetRemoveHelmStep(clusterNodes)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, helm)
+		}
+	}
+	leaveSteps, err := runnable.LeaveNodeSteps(nodes)
+	if err != nil {
+		return nil, err
+	}
+	return runnable.stableSteps(runnable.withRetryPolicy(append(steps, leaveSteps...)), nil)
+}
+
+// removeManifests removes the values rendered on nodes, the ManifestDir of the cluster and the values the
+// operations created before ManifestDir was added rendered to the shared directory.
+func (runnable *CiliumRunnable) removeManifests(nodes []v1.StepNode) v1.Step {
+	manifests := []string{"cilium.yaml"}
+	if dir, err := filepath.Rel(manifestDir, runnable.manifestPath("")); err == nil && dir != "." {
+		manifests = append(manifests, dir)
+	}
+	return RemoveManifests(nodes, manifests...)
+}
+
+// uninstallReleaseCommand uninstalls the cilium release with the helm SDK of the agents, or with the helm binary
+// when an agent of nodes does not support it.
+func (runnable *CiliumRunnable) uninstallReleaseCommand(nodes []v1.StepNode) v1.Command {
+	if HelmSDKSupported(nodes) {
+		return helmUninstallCommand(runnable.ReleaseName(), runnable.Namespace)
+	}
+	return v1.Command{
+		Type:         v1.CommandShell,
+		ShellCommand: []string{common.HelmBin, "uninstall", runnable.ReleaseName(), "-n", runnable.Namespace},
+	}
+}
+
+// RollbackSteps uninstalls the cilium release installed on nodes and removes the secrets, the namespace and the
+// images created for it. The kube-proxy removed for the kube-proxy replacement is not restored, the install
+// removes it again.
+func (runnable *CiliumRunnable) RollbackSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	var steps []v1.Step
+	if runnable.loadBalancerEnabled() {
+		steps = append(steps, runnable.deleteLoadBalancer(nodes))
+	}
+	if runnable.BGP() != nil {
+		steps = append(steps, runnable.deleteBGP(nodes))
+	}
+	if runnable.HostFirewall() {
+		steps = append(steps, runnable.deleteHostFirewallPolicy(nodes))
+	}
+	if runnable.EgressGateway() {
+		steps = append(steps, runnable.deleteEgressGateway(nodes))
+	}
+	if runnable.MultiPool() {
+		steps = append(steps, runnable.deletePodIPPools(nodes))
+	}
+	steps = append(steps, UninstallHelmRelease("uninstallCiliumRelease", runnable.ReleaseName(), runnable.Namespace, nodes, runnable.uninstallTimeout(5*time.Minute)))
+	if runnable.hubbleEnabled() {
+		steps = append(steps, runnable.clearHubble(nodes))
+	}
+	if runnable.encryptionType() == CiliumEncryptionIPsec {
+		steps = append(steps, runnable.removeIPsecKeys(nodes))
+	}
+	if runnable.KVStore() != nil {
+		steps = append(steps, runnable.removeKVStoreSecret(nodes))
+	}
+	if runnable.ImagePullSecret != "" {
+		steps = append(steps, runnable.removePullSecret(nodes))
+	}
+	if runnable.Namespace != CiliumNamespaceDefault {
+		steps = append(steps, RemoveCreatedNamespace("removeCiliumNamespace", runnable.Namespace, nodes))
+	}
+	steps = append(steps, runnable.removeManifests(nodes))
+	images, err := runnable.rollbackImages("cilium", runnable, runnable.agentNodes(runnable.allNodes))
+	if err != nil {
+		return nil, err
+	}
+	return runnable.stableSteps(runnable.withRetryPolicy(append(steps, images...)), nil)
+}
+
+// JoinNodeSteps loads the images and installs the cilium CLI on the joining nodes, then waits for the cilium agent
+// scheduled on each of them by the DaemonSet. The release is not touched, the steps run after the nodes joined.
+func (runnable *CiliumRunnable) JoinNodeSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	steps, err := runnable.LoadImage(nodes)
+	if err != nil {
+		return nil, err
+	}
+	// the joining masters run the cluster scoped steps as well, they need the chart and the CLI
+	if masters := runnable.mastersOf(nodes); len(masters) > 0 {
+		chart := &common.Chart{
+			PkgName: "cilium",
+			Version: runnable.Version,
+			Offline: runnable.Offline,
+			Source:  runnable.ChartSource,
+		}
+		chartSteps, err := chart.InstallStepsV2(masters)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, inParallel(chartSteps)...)
+		cli, err := runnable.installCLI(masters)
+		if err != nil {
+			return nil, err
+		}
+		cli.ErrIgnore = true
+		steps = append(steps, cli)
+	}
+	if agents := runnable.agentNodes(nodes); (runnable.CiliumConfig == nil || !runnable.CiliumConfig.SkipReadinessCheck) &&
+		len(runnable.masters) > 0 && len(agents) > 0 {
+		steps = append(steps, runnable.checkNodeReady(agents))
+	}
+	return runnable.stableSteps(runnable.withRetryPolicy(steps), nil)
+}
+
+// LeaveNodeSteps removes the cilium state, the CLI and the offline images from the nodes leaving the cluster.
+func (runnable *CiliumRunnable) LeaveNodeSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	steps := []v1.Step{runnable.clearNode(nodes)}
+	if masters := runnable.mastersOf(nodes); len(masters) > 0 {
+		cli, err := runnable.removeCLI(masters)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, cli)
+	}
+	if runnable.loadsImages() {
+		custom, err := json.Marshal(runnable)
+		if err != nil {
+			return nil, err
+		}
+		prune, err := runnable.pruneImages(nodes)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, RemoveImage("cilium", custom, nodes), prune)
+	}
+	return runnable.stableSteps(runnable.withRetryPolicy(withNodeConcurrency(steps)), nil)
+}
+
+// withNodeConcurrency limits the per-node steps to ciliumNodeConcurrency nodes at once.
+func withNodeConcurrency(steps []v1.Step) []v1.Step {
+	for i := range steps {
+		steps[i].Concurrency = ciliumNodeConcurrency
+	}
+	return steps
+}
+
+// clusterScopedNodes returns the node running the cluster scoped uninstall steps,
+// ok is false when nodes do not cover every node of the cluster.
+func (runnable *CiliumRunnable) clusterScopedNodes(nodes []v1.StepNode) ([]v1.StepNode, bool) {
+	return clusterScopedNodes(nodes, runnable.allNodes, runnable.masters)
+}
+
+func (runnable *CiliumRunnable) encryptionType() string {
+	if runnable.CiliumConfig == nil || runnable.CiliumConfig.Encryption == nil {
+		return ""
+	}
+	return runnable.CiliumConfig.Encryption.Type
+}
+
+// IPsecKeySecretName returns the secret name of the ipsec keys, it is used by the values template.
+func (runnable *CiliumRunnable) IPsecKeySecretName() string {
+	if runnable.CiliumConfig == nil || runnable.CiliumConfig.Encryption == nil {
+		return CiliumIPsecKeySecretNameDefault
+	}
+	return strutil.StringDefaultIfEmpty(CiliumIPsecKeySecretNameDefault, runnable.CiliumConfig.Encryption.KeySecretName)
+}
+
+// checkWireguard make sure the kernel of every available node supports wireguard, cilium agent keeps crashing otherwise.
+func (runnable *CiliumRunnable) checkWireguard() v1.Step {
+	return v1.Step{
+		ID:             newStepID(),
+		Name:           "checkCiliumWireguard",
+		Timeout:        metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:      false,
+		RetryTimes:     0,
+		RetryPolicySet: true,
+		Nodes:          runnable.nodeStates.available(runnable.allNodes),
+		Action:         v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					`if [ -d /sys/module/wireguard ] || modprobe wireguard; then exit 0; fi; echo "wireguard is not supported by kernel $(uname -r) on node $(hostname), kernel >= 5.6 or the wireguard module is required" >&2; exit 1`},
+			},
+		},
+	}
+}
+
+// checkBBRKernel fails on the available nodes whose kernel is older than ciliumBBRMinKernel.
+func (runnable *CiliumRunnable) checkBBRKernel() v1.Step {
+	return v1.Step{
+		ID:             newStepID(),
+		Name:           "checkCiliumBBRKernel",
+		Timeout:        metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:      false,
+		RetryTimes:     0,
+		RetryPolicySet: true,
+		Nodes:          runnable.nodeStates.available(runnable.allNodes),
+		Action:         v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf(`kernel=$(uname -r); if [ "$(printf '%%s\n' %[1]s "${kernel%%%%-*}" | sort -V | head -n 1)" = %[1]s ]; then exit 0; fi; echo "cilium bbr requires kernel >= %[1]s, node $(hostname) runs kernel $kernel" >&2; exit 1`,
+						ciliumBBRMinKernel)},
+			},
+		},
+	}
+}
+
+// validateLoadBalancerTuning checks the service load balancer mode and algorithm, the DSR modes carry the service
+// address to the backend node in the packet, the tunnels do not.
+func (runnable *CiliumRunnable) validateLoadBalancerTuning() error {
+	mode, algorithm := runnable.CiliumConfig.LoadBalancerMode, runnable.CiliumConfig.LoadBalancerAlgorithm
+	if mode != "" && !ciliumLBModes.Has(mode) {
+		return fieldError("cilium.loadBalancerMode", fmt.Errorf("invalid cilium load balancer mode %q, supported values: %v", mode, ciliumLBModes.List()))
+	}
+	if algorithm != "" && !ciliumLBAlgorithms.Has(algorithm) {
+		return fieldError("cilium.loadBalancerAlgorithm", fmt.Errorf("invalid cilium load balancer algorithm %q, supported values: %v", algorithm, ciliumLBAlgorithms.List()))
+	}
+	if mode == CiliumLBModeDSR || mode == CiliumLBModeHybrid {
+		if runnable.CiliumConfig.TunnelMode != CiliumTunnelDisabled {
+			return fieldError("cilium.loadBalancerMode", fmt.Errorf("cilium load balancer mode %s requires the native routing, set the tunnel mode to %s", mode, CiliumTunnelDisabled))
+		}
+		if !runnable.kubeProxyReplaced() {
+			return fieldError("cilium.loadBalancerMode", fmt.Errorf("cilium load balancer mode %s requires kubeProxyReplacement %s or %s",
+				mode, CiliumKubeProxyReplacementStrict, CiliumKubeProxyReplacementTrue))
+		}
+	}
+	if algorithm == CiliumLBAlgorithmMaglev && !runnable.kubeProxyReplaced() {
+		return fieldError("cilium.loadBalancerAlgorithm", fmt.Errorf("cilium load balancer algorithm %s requires kubeProxyReplacement %s or %s",
+			algorithm, CiliumKubeProxyReplacementStrict, CiliumKubeProxyReplacementTrue))
 	}
 	return nil
 }
 
-func (runnable *CiliumRunnable) CiliumTemplate() (string, error) {
-	return ciliumValuesTemplate, nil
+// policyEnforcementMode the configured policy enforcement mode, empty when the chart default applies.
+func (runnable *CiliumRunnable) policyEnforcementMode() string {
+	if runnable.CiliumConfig == nil {
+		return ""
+	}
+	return runnable.CiliumConfig.PolicyEnforcementMode
+}
+
+// warnPolicyEnforcement reminds that the "always" mode drops the kube-system traffic without explicit allow policies,
+// the warning is the step output, it never fails the install.
+func (runnable *CiliumRunnable) warnPolicyEnforcement(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:             newStepID(),
+		Name:           "warnCiliumPolicyEnforcement",
+		Timeout:        metav1.Duration{Duration: 10 * time.Second},
+		ErrIgnore:      true,
+		RetryTimes:     0,
+		RetryPolicySet: true,
+		Nodes:          nodes,
+		Action:         v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf(`echo "WARNING: cilium policy enforcement mode is %s, the traffic of every endpoint without an allow policy is dropped, `+
+						`kube-system workloads such as coredns need explicit allow policies"`, CiliumPolicyEnforcementAlways)},
+			},
+		},
+	}
 }
 
-// InstallCiliumRelease apply helm chart with rendered values
-func InstallCiliumRelease(chartPath string, values string, namespace string, nodes []v1.StepNode) v1.Step {
+// checkServiceMonitorCRD fails before the release is installed when the prometheus operator CRDs are missing,
+// helm can not render the ServiceMonitors without them.
+func (runnable *CiliumRunnable) checkServiceMonitorCRD(nodes []v1.StepNode) v1.Step {
 	return v1.Step{
-		ID:         strutil.GetUUID(),
-		Name:       "installCiliumRelease",
-		Timeout:    metav1.Duration{Duration: 2 * time.Minute},
+		ID:         newStepID(),
+		Name:       "checkServiceMonitorCRD",
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
 		ErrIgnore:  false,
 		RetryTimes: 1,
 		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					`kubectl get crd servicemonitors.monitoring.coreos.com >/dev/null && exit 0; echo "cilium enableServiceMonitor requires the prometheus operator CRDs, install the prometheus operator or disable enableServiceMonitor" >&2; exit 1`},
+			},
+		},
+	}
+}
+
+// Metrics the metrics configuration rendered by the values template, nil when metrics are disabled.
+func (runnable *CiliumRunnable) Metrics() *v1.CiliumMetrics {
+	if runnable.CiliumConfig == nil {
+		return nil
+	}
+	return runnable.CiliumConfig.Metrics
+}
+
+func (runnable *CiliumRunnable) serviceMonitorEnabled() bool {
+	metrics := runnable.Metrics()
+	return metrics != nil && metrics.EnableServiceMonitor
+}
+
+func (runnable *CiliumRunnable) validateMetrics() error {
+	metrics := runnable.Metrics()
+	if metrics == nil {
+		return nil
+	}
+	if len(metrics.HubbleMetrics) > 0 && !runnable.hubbleEnabled() {
+		return fmt.Errorf("cilium hubbleMetrics requires enableHubble")
+	}
+	for _, metric := range metrics.HubbleMetrics {
+		if strings.TrimSpace(metric) == "" || strings.ContainsAny(metric, " \t\n") {
+			return fmt.Errorf("invalid cilium hubble metric %q", metric)
+		}
+	}
+	if metrics.EnableServiceMonitor && !metrics.EnablePrometheus && len(metrics.HubbleMetrics) == 0 {
+		return fmt.Errorf("cilium enableServiceMonitor requires enablePrometheus or hubbleMetrics")
+	}
+	return nil
+}
+
+// createIPsecKeys generates the ipsec keys secret when it does not exist.
+func (runnable *CiliumRunnable) createIPsecKeys(nodes []v1.StepNode) v1.Step {
+	secret := runnable.IPsecKeySecretName()
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "createCiliumIPsecKeys",
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf("kubectl create ns %s --dry-run=client -o yaml | kubectl apply -f -", runnable.Namespace)},
+			},
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf(`kubectl get secret %[1]s -n %[2]s || kubectl create secret generic %[1]s -n %[2]s --from-literal=keys="3+ rfc4106(gcm(aes)) $(dd if=/dev/urandom count=20 bs=1 2>/dev/null | xxd -p -c 64) 128"`,
+						secret, runnable.Namespace)},
+			},
+		},
+	}
+}
+
+func (runnable *CiliumRunnable) removeIPsecKeys(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "removeCiliumIPsecKeys",
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "delete", "secret", runnable.IPsecKeySecretName(), "-n", runnable.Namespace, "--ignore-not-found"},
+			},
+		},
+	}
+}
+
+// ReleaseName returns the helm release name of cilium.
+func (runnable *CiliumRunnable) ReleaseName() string {
+	if runnable.CiliumConfig != nil && runnable.CiliumConfig.ReleaseName != "" {
+		return runnable.CiliumConfig.ReleaseName
+	}
+	return CiliumReleaseNameDefault
+}
+
+func (runnable *CiliumRunnable) preflightReleaseName() string {
+	return runnable.ReleaseName() + "-preflight"
+}
+
+func (runnable *CiliumRunnable) readinessTimeout() time.Duration {
+	if runnable.CiliumConfig != nil && runnable.CiliumConfig.ReadinessCheckTimeout != nil &&
+		runnable.CiliumConfig.ReadinessCheckTimeout.Duration > 0 {
+		return runnable.CiliumConfig.ReadinessCheckTimeout.Duration
+	}
+	return ciliumDefaultReadinessTimeout
+}
+
+// checkReady waits for the cilium agent and operator rollout. The agents running v1.CommandWaitFor report the
+// last observed rollout state on timeout, the others print the pod events when the rollout does not complete so
+// that the failure reason shows up in the step log.
+func (runnable *CiliumRunnable) checkReady(nodes []v1.StepNode) v1.Step {
+	timeout := runnable.readinessTimeout()
+	var commands []v1.Command
+	if WaitForSupported(nodes) {
+		commands = append(commands,
+			rolloutCommand("DaemonSet", "cilium", runnable.Namespace, timeout, runnable.waitKubeconfig()),
+			rolloutCommand("Deployment", "cilium-operator", runnable.Namespace, timeout, runnable.waitKubeconfig()))
+	} else {
+		commands = append(commands, v1.Command{
+			Type: v1.CommandShell,
+			ShellCommand: []string{"/bin/bash", "-c",
+				fmt.Sprintf(`kubectl rollout status ds/cilium -n %[1]s --timeout %[2]s && kubectl rollout status deploy/cilium-operator -n %[1]s --timeout %[2]s || { kubectl get events -n %[1]s --field-selector involvedObject.kind=Pod --sort-by=.lastTimestamp | tail -n 20; exit 1; }`,
+					runnable.Namespace, timeout)},
+		})
+	}
+	var matchers []v1.StepErrorMatcher
+	stepTimeout := 2*timeout + time.Minute
+	// the DNS proxy interposes on the DNS of the pods, a ready cilium may still break the resolution. The probe pod
+	// has no allow policy, it can not resolve when the policies are always enforced.
+	if runnable.DNSProxy() && runnable.policyEnforcementMode() != CiliumPolicyEnforcementAlways {
+		if WaitForSupported(nodes) {
+			commands = append(commands, v1.Command{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c", runnable.dnsProbeScript()},
+			})
+		} else {
+			commands[0].ShellCommand[2] += "\n" + runnable.dnsProbeScript()
+		}
+		matchers = append(matchers, dnsResolutionMatcher)
+		stepTimeout += 3 * time.Minute
+	}
+	return v1.Step{
+		ID:                newStepID(),
+		Name:              "checkCiliumReady",
+		Timeout:           metav1.Duration{Duration: stepTimeout},
+		ErrIgnore:         false,
+		RetryTimes:        3,
+		Nodes:             nodes,
+		Action:            v1.ActionInstall,
+		ErrorMatchers:     matchers,
+		BeforeRunCommands: runnable.writeWaitKubeconfig(nodes),
+		Commands:          commands,
+	}
+}
+
+// checkNodeReady waits from the first master for the cilium agent on each of nodes, the agent pod shows up
+// once the DaemonSet schedules it on the joined node.
+func (runnable *CiliumRunnable) checkNodeReady(nodes []v1.StepNode) v1.Step {
+	timeout := runnable.readinessTimeout()
+	var hostnames []string
+	for _, node := range nodes {
+		hostnames = append(hostnames, node.Hostname)
+	}
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "checkCiliumNodeReady",
+		Timeout:    metav1.Duration{Duration: 2*timeout + time.Minute},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      runnable.masters[:1],
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf(`for node in %[3]s; do selector="k8s-app=cilium"; fields="spec.nodeName=$node"; `+
+						`timeout %[2]s bash -c "until kubectl get pod -n %[1]s -l $selector --field-selector $fields -o name | grep -q .; do sleep 2; done" && `+
+						`kubectl wait pod -n %[1]s -l $selector --field-selector $fields --for=condition=Ready --timeout %[2]s || `+
+						`{ kubectl get events -n %[1]s --field-selector involvedObject.kind=Pod --sort-by=.lastTimestamp | tail -n 20; exit 1; }; done`,
+						runnable.Namespace, timeout, strings.Join(hostnames, " "))},
+			},
+		},
+	}
+}
+
+// clearNode removes the cni config, network interfaces and bpf state left by the cilium agent on each node,
+// every removed path or link is printed so that the step log shows what was actually cleaned.
+func (runnable *CiliumRunnable) clearNode(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "clearCiliumNode",
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c", "rm -fv /etc/cni/net.d/05-cilium.conflist /etc/cni/net.d/*cilium*"},
+			},
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					`for link in $(ip -o link show | awk -F': ' '{print $2}' | cut -d@ -f1 | grep -E '^(cilium_|lxc)'); do ip link delete "$link" && echo "deleted link $link"; done`},
+			},
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					`for mnt in $(awk '$2 ~ /cilium/ && ($3 == "bpf" || $3 == "cgroup2") {print $2}' /proc/mounts); do umount "$mnt" && echo "unmounted $mnt"; done; ` +
+						`rm -rfv /sys/fs/bpf/tc/globals/cilium_* /sys/fs/bpf/cilium`},
+			},
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"rm", "-rfv", "/var/run/cilium"},
+			},
+		},
+	}
+}
+
+// pruneImages removes the offline loaded cilium images from the container runtime, they are still in use before helm uninstall.
+func (runnable *CiliumRunnable) pruneImages(nodes []v1.StepNode) (v1.Step, error) {
+	cmd, err := ImageRemoveCommand(runnable.CriType, ciliumDefaultImageRepository+"/")
+	if err != nil {
+		return v1.Step{}, err
+	}
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "pruneCiliumImages",
+		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
 		Commands: []v1.Command{
 			{
 				Type:         v1.CommandShell,
-				ShellCommand: []string{"helm", "upgrade", "--install", "--create-namespace", "cilium", "-n", namespace, chartPath, "-f", values},
+				ShellCommand: cmd,
 			},
 		},
+	}, nil
+}
+
+func (runnable *CiliumRunnable) hubbleEnabled() bool {
+	return runnable.CiliumConfig != nil && runnable.CiliumConfig.EnableHubble
+}
+
+// clearHubble removes the hubble certificates generated by the chart hooks, helm uninstall does not remove them.
+func (runnable *CiliumRunnable) clearHubble(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "removeHubbleSecrets",
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"kubectl", "delete", "secret", "-n", runnable.Namespace, "--ignore-not-found",
+					"hubble-ca-secret", "hubble-server-certs", "hubble-relay-client-certs", "hubble-relay-server-certs", "hubble-ui-client-certs"},
+			},
+		},
+	}
+}
+
+func (runnable *CiliumRunnable) CmdList(namespace string) map[string]string {
+	return operationCommands(runnable.Operations(namespace))
+}
+
+func (runnable *CiliumRunnable) Operations(namespace string) []Operation {
+	agent := fmt.Sprintf("kubectl -n %s exec ds/cilium -c cilium-agent --", namespace)
+	return []Operation{
+		{
+			Name:        OperationGet,
+			Description: "List the cilium pods.",
+			Command:     fmt.Sprintf("kubectl get po -n %s | grep cilium", namespace),
+			Output:      OperationOutputText,
+		},
+		{
+			Name:        OperationStatus,
+			Description: "Show the status of cilium, summarized by the cilium CLI when it is installed.",
+			Command: fmt.Sprintf("if command -v cilium >/dev/null 2>&1; then cilium status --brief -n %s; else %s cilium status --brief; fi",
+				namespace, agent),
+			Output: OperationOutputText,
+		},
+		{
+			Name:        OperationLogs,
+			Description: fmt.Sprintf("Show the last %d log lines of every cilium agent.", operationLogLines),
+			Command: fmt.Sprintf("kubectl -n %s logs -l k8s-app=cilium -c cilium-agent --tail=%d --prefix --max-log-requests=%d",
+				namespace, operationLogLines, operationMaxLogRequests),
+			Output: OperationOutputText,
+		},
+		{
+			Name:        OperationVersion,
+			Description: "Show the version of the cilium agent and of the release.",
+			Command:     fmt.Sprintf("%s cilium version && %s status %s -n %s", agent, common.HelmBin, runnable.ReleaseName(), namespace),
+			Output:      OperationOutputText,
+		},
+		{
+			Name:        OperationEndpoints,
+			Description: "List the cilium endpoints of the cluster.",
+			Command:     "kubectl get ciliumendpoints.cilium.io -A -o json",
+			Output:      OperationOutputJSON,
+		},
+		{
+			Name:        OperationRestart,
+			Description: "Restart the cilium agents, the pod networking of the nodes is disrupted until they are ready.",
+			Command:     ciliumRestartCommand(namespace),
+			Destructive: true,
+			Output:      OperationOutputText,
+		},
+		runnable.ciliumSysdump(namespace),
+		ciliumCollectLogs(namespace),
+		runnable.purgeOperation(namespace),
+		runnable.debugOperation(namespace, true),
+		runnable.debugOperation(namespace, false),
+		healthOperation("cilium", Workloads{Namespace: namespace, DaemonSets: []string{"cilium"},
+			Deployments: []string{"cilium-operator"}, Release: runnable.ReleaseName()}),
+	}
+}
+
+func (runnable *CiliumRunnable) Render(ctx context.Context, opts component.Options) error {
+	runnable.gatherFacts(ctx)
+	if opts.DryRun {
+		_, err := runnable.RenderString(ctx)
+		return err
+	}
+	manifestFile := runnable.manifestPath("cilium.yaml")
+	if err := os.MkdirAll(filepath.Dir(manifestFile), 0755); err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomicWithContext(ctx, manifestFile, 0644,
+		runnable.renderCiliumTo, opts.DryRun)
+}
+
+// RenderString returns the helm values Render writes to the manifest file.
+func (runnable *CiliumRunnable) RenderString(ctx context.Context) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := runnable.renderCiliumTo(buf); err != nil {
+		return "", err
 	}
+	return buf.String(), nil
+}
+
+func (runnable *CiliumRunnable) renderCiliumTo(w io.Writer) error {
+	// the values data of the servers predating Defaults may lack the cilium configuration
+	if runnable.CiliumConfig == nil {
+		completed := *runnable
+		completed.CiliumConfig = runnable.completeDefaults(nil)
+		runnable = &completed
+	}
+	at := tmplutil.New()
+	ciliumTemp, err := runnable.CiliumTemplate()
+	if err != nil {
+		return err
+	}
+	profile, err := ciliumProfileValues(runnable.Profile())
+	if err != nil {
+		return err
+	}
+	if profile == "" && (runnable.CiliumConfig == nil || runnable.CiliumConfig.HelmValues == "") {
+		_, err = at.RenderTo(w, ciliumTemp, runnable)
+		return err
+	}
+	generated, err := at.Render(ciliumTemp, runnable)
+	if err != nil {
+		return err
+	}
+	values := []byte(generated)
+	if profile != "" {
+		if values, err = MergeHelmValues([]byte(profile), generated); err != nil {
+			return err
+		}
+	}
+	if runnable.CiliumConfig != nil && runnable.CiliumConfig.HelmValues != "" {
+		if values, err = MergeHelmValues(values, runnable.CiliumConfig.HelmValues); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(values)
+	return err
+}
+
+// CiliumTemplate returns the override of the values template if there is one, otherwise the built-in template.
+func (runnable *CiliumRunnable) CiliumTemplate() (string, error) {
+	if runnable.ValuesTemplate != "" {
+		return runnable.ValuesTemplate, nil
+	}
+	return ciliumValuesTemplate, nil
+}
+
+// InstallCiliumRelease apply helm chart with rendered values, with the helm SDK of the agents when all nodes
+// support it and with the helm binary otherwise. With opts APIServerEndpoint the step writes the kubeconfig of
+// the node pointing at it first, the step writes it again on the node it fails over to.
+func InstallCiliumRelease(release, chartPath, values, namespace string, nodes []v1.StepNode, opts HelmReleaseOptions) v1.Step {
+	return ciliumReleaseStep("installCiliumRelease", release, chartPath, values, namespace, nodes, opts)
+}
+
+// ciliumReleaseStep the InstallCiliumRelease step named stepName, e.g. the upgrade and the promotion of the release.
+func ciliumReleaseStep(stepName, release, chartPath, values, namespace string, nodes []v1.StepNode, opts HelmReleaseOptions) v1.Step {
+	step := InstallHelmRelease(stepName, release, namespace, chartPath, values, nodes, opts)
+	if HelmSDKSupported(nodes) {
+		step.Commands = []v1.Command{helmInstallCommand(release, namespace, chartPath, values, opts)}
+	}
+	// a cancelled install leaves the release pending and blocks the next install
+	step.CancelCommands = []v1.Command{RollbackHelmRelease(release, namespace)}
+	return withHelmKubeconfig(step, release, opts.APIServerEndpoint)
 }
 
 const ciliumValuesTemplate = `operator:
-  replicas: {{ if .CiliumConfig }}{{.CiliumConfig.OperatorReplicas}}{{else}}1{{end}}
+  replicas: {{ .OperatorReplicas }}
+{{- if gt .OperatorReplicas 1 }}
+  affinity:
+    podAntiAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+      - topologyKey: kubernetes.io/hostname
+        labelSelector:
+          matchLabels:
+            io.cilium/app: operator
+    nodeAffinity:
+      preferredDuringSchedulingIgnoredDuringExecution:
+      - weight: 100
+        preference:
+          matchExpressions:
+          - key: node-role.kubernetes.io/control-plane
+            operator: Exists
+{{- end }}
+{{- with .CiliumConfig.OperatorResources }}
+  resources: {{ toJson . }}
+{{- end }}
+{{- with .CiliumConfig.OperatorNodeSelector }}
+  nodeSelector: {{ toJson . }}
+{{- end }}
+{{- with .CiliumConfig.OperatorTolerations }}
+  tolerations: {{ toJson . }}
+{{- end }}
+{{- if and .Metrics .Metrics.EnablePrometheus }}
+  prometheus:
+    enabled: true
+    serviceMonitor:
+      enabled: {{ .Metrics.EnableServiceMonitor }}
+{{- end }}
+{{- if .Migration }}
+  unmanagedPodWatcher:
+    restart: false
+{{- end }}
+{{- if .LocalRegistry }}
+  image:
+    repository: {{ .Images.Operator }}
+    useDigest: false
+image:
+  repository: {{ .Images.Cilium }}
+  useDigest: false
+{{- if .VersionAtLeast "1.14" }}
+envoy:
+  image:
+    repository: {{ .Images.Envoy }}
+    useDigest: false
+{{- end }}
+{{- end }}
+{{- with .ImagePullSecret }}
+imagePullSecrets:
+- name: {{ . }}
+{{- end }}
+{{- if .MultiPool }}
+ipam:
+  mode: multi-pool
+  operator:
+    autoCreateCiliumPodIPPools:
+      default: {{ toJson .DefaultPodIPPool }}
+endpointRoutes:
+  enabled: true
+{{- if .MultiPoolIPv6 }}
+ipv6:
+  enabled: true
+{{- end }}
+{{- else if not .ChainingMode }}
 ipam:
-  mode: "{{ if .CiliumConfig }}{{ if .CiliumConfig.IPAMMode }}{{.CiliumConfig.IPAMMode}}{{else}}cluster-pool{{end}}{{else}}cluster-pool{{end}}"
+  mode: "{{ .CiliumConfig.IPAMMode }}"
   operator:
-    clusterPoolIPv4PodCIDRList: {{ if .CiliumConfig }}{{ toJson .CiliumConfig.ClusterPoolIPv4PodCIDRList }}{{else}}["192.168.64.0/18"]{{end}}
-    clusterPoolIPv4MaskSize: {{ if .CiliumConfig }}{{.CiliumConfig.ClusterPoolIPv4MaskSize}}{{else}}25{{end}}
-kubeProxyReplacement: "{{ if .CiliumConfig }}{{.CiliumConfig.KubeProxyReplacement}}{{else}}false{{end}}"
+{{- with .CiliumConfig.ClusterPoolIPv4PodCIDRList }}
+    clusterPoolIPv4PodCIDRList:
+{{- toYaml . | nindent 6 }}
+    clusterPoolIPv4MaskSize: {{ $.CiliumConfig.ClusterPoolIPv4MaskSize }}
+{{- end }}
+{{- if .CiliumConfig.ClusterPoolIPv6PodCIDRList }}
+    clusterPoolIPv6PodCIDRList: {{ toJson .CiliumConfig.ClusterPoolIPv6PodCIDRList }}
+    clusterPoolIPv6MaskSize: {{ .CiliumConfig.ClusterPoolIPv6MaskSize }}
+ipv6:
+  enabled: true
+{{- end }}
+{{- end }}
+{{- if and .PodIPv6CIDR (not .PodIPv4CIDR) }}
+ipv4:
+  enabled: false
+{{- end }}
+{{- with .CiliumConfig.NodeSelector }}
+nodeSelector: {{ toJson . }}
+{{- end }}
+{{- with .CiliumConfig.Tolerations }}
+tolerations: {{ toJson . }}
+{{- end }}
+{{- with .AgentResources }}
+resources: {{ toJson . }}
+{{- end }}
+priorityClassName: {{ .AgentPriorityClassName }}
+kubeProxyReplacement: {{ .KubeProxyReplacementValue }}
+{{- if .CiliumConfig.MTU }}
+MTU: {{ .CiliumConfig.MTU }}
+{{- end }}
+{{- with .ClusterMesh }}
+cluster:
+  name: {{ .ClusterName }}
+  id: {{ .ClusterID }}
+{{- end }}
+{{- if and .ClusterMesh .ClusterMesh.EnableClusterMesh }}
+clustermesh:
+  useAPIServer: true
+  apiserver:
+    service:
+      type: {{ .MeshServiceType }}
+{{- if .LocalRegistry }}
+    image:
+      repository: {{ .Images.ClusterMesh }}
+      useDigest: false
+    etcd:
+      image:
+        repository: {{ .EtcdImage }}
+{{- end }}
+{{- end }}
+{{- if and .Metrics .Metrics.EnablePrometheus }}
+prometheus:
+  enabled: true
+  serviceMonitor:
+    enabled: {{ .Metrics.EnableServiceMonitor }}
+{{- end }}
+{{- if .CiliumConfig.TunnelMode }}
+{{- if eq .CiliumConfig.TunnelMode "disabled" }}
+{{- if .LegacyTunnel }}
+tunnel: disabled
+{{- else }}
+routingMode: native
+{{- end }}
+autoDirectNodeRoutes: true
+{{- if .CiliumConfig.NativeRoutingCIDR }}
+ipv4NativeRoutingCIDR: {{ .CiliumConfig.NativeRoutingCIDR }}
+{{- end }}
+{{- if .PodIPv6CIDR }}
+ipv6NativeRoutingCIDR: {{ .PodIPv6CIDR }}
+{{- end }}
+{{- else if .LegacyTunnel }}
+tunnel: {{ .CiliumConfig.TunnelMode }}
+{{- else }}
+routingMode: tunnel
+tunnelProtocol: {{ .CiliumConfig.TunnelMode }}
+{{- end }}
+{{- end }}
+{{- if and .ChainingMode (not .Migration) }}
+cni:
+  chainingMode: {{ .ChainingMode }}
+  exclusive: false
+{{- with .CiliumConfig.CustomCNIConf }}
+  customConf: true
+  configMap: {{ . }}
+{{- end }}
+{{- if .LegacyTunnel }}
+tunnel: disabled
+{{- else }}
+routingMode: native
+{{- end }}
+enableIPv4Masquerade: false
+{{- end }}
+{{- if .K8sServiceHost }}
+k8sServiceHost: {{ .K8sServiceHost }}
+k8sServicePort: {{ .K8sServicePort }}
+{{- end }}
+{{- if .CiliumConfig.EnableHubble }}
+hubble:
+  enabled: true
+{{- if and .Metrics .Metrics.HubbleMetrics }}
+  metrics:
+    enabled: {{ toJson .Metrics.HubbleMetrics }}
+{{- if .Metrics.EnableServiceMonitor }}
+    serviceMonitor:
+      enabled: true
+{{- end }}
+{{- end }}
+  relay:
+    enabled: {{ or .CiliumConfig.EnableHubbleRelay .CiliumConfig.EnableHubbleUI }}
+{{- if .LocalRegistry }}
+    image:
+      repository: {{ .Images.HubbleRelay }}
+      useDigest: false
+{{- end }}
+  ui:
+    enabled: {{ .CiliumConfig.EnableHubbleUI }}
+{{- if .LocalRegistry }}
+    frontend:
+      image:
+        repository: {{ .Images.HubbleUI }}
+        useDigest: false
+    backend:
+      image:
+        repository: {{ .Images.HubbleUIBackend }}
+        useDigest: false
+certgen:
+  image:
+    repository: {{ .Images.Certgen }}
+    useDigest: false
+{{- end }}
+{{- end }}
+{{- if .CiliumConfig.EnableBandwidthManager }}
+{{- if .VersionAtLeast "1.12" }}
+bandwidthManager:
+  enabled: true
+  bbr: {{ .CiliumConfig.EnableBBR }}
+{{- else }}
+bandwidthManager: true
+{{- end }}
+{{- end }}
+{{- if or .CiliumConfig.LoadBalancerMode .CiliumConfig.LoadBalancerAlgorithm }}
+loadBalancer:
+{{- with .CiliumConfig.LoadBalancerMode }}
+  mode: {{ . }}
+{{- end }}
+{{- with .CiliumConfig.LoadBalancerAlgorithm }}
+  algorithm: {{ . }}
+{{- end }}
+{{- end }}
+{{- if .CiliumConfig.SocketLB }}
+{{- if .VersionAtLeast "1.12" }}
+socketLB:
+  enabled: true
+{{- else }}
+hostServices:
+  enabled: true
+{{- end }}
+{{- end }}
+{{- if .CiliumConfig.Encryption }}
+encryption:
+  enabled: true
+  type: {{ .CiliumConfig.Encryption.Type }}
+{{- if eq .CiliumConfig.Encryption.Type "ipsec" }}
+  secretName: {{ .IPsecKeySecretName }}
+  ipsec:
+    secretName: {{ .IPsecKeySecretName }}
+{{- end }}
+{{- end }}
+{{- if .HostFirewall }}
+hostFirewall:
+  enabled: true
+{{- end }}
+{{- if .EgressGateway }}
+egressGateway:
+  enabled: true
+{{- end }}
+{{- if .BGP }}
+bgpControlPlane:
+  enabled: true
+{{- end }}
+{{- if .L2Announcements }}
+l2announcements:
+  enabled: true
+externalIPs:
+  enabled: true
+{{- end }}
+{{- if .CiliumConfig.IdentityAllocationMode }}
+identityAllocationMode: {{ .CiliumConfig.IdentityAllocationMode }}
+{{- end }}
+{{- with .KVStore }}
+etcd:
+  enabled: true
+  ssl: true
+  endpoints: {{ toJson .Endpoints }}
+{{- end }}
+{{- if not .Migration }}
+{{- with .CiliumConfig.PolicyEnforcementMode }}
+policyEnforcementMode: {{ . }}
+{{- end }}
+{{- end }}
+{{- if .CiliumConfig.PolicyAuditMode }}
+policyAuditMode: true
+{{- end }}
+{{- if .DNSProxy }}
+l7Proxy: true
+{{- with .DNSProxyValues }}
+dnsProxy: {{ toJson . }}
+{{- end }}
+{{- end }}
+{{- if .Migration }}
+tunnelPort: {{ .MigrationTunnelPort }}
+cni:
+  customConf: true
+  uninstall: false
+policyEnforcementMode: never
+{{- end }}
+{{- if .Debug }}
+debug:
+  enabled: true
+{{- with .DebugVerbose }}
+  verbose: {{ . }}
+{{- end }}
+{{- end }}
+{{- if or .Migration .EgressGateway .MonitorAggregation }}
+bpf:
+{{- if .Migration }}
+  hostLegacyRouting: true
+{{- end }}
+{{- if .EgressGateway }}
+  masquerade: true
+{{- end }}
+{{- with .MonitorAggregation }}
+  monitorAggregation: {{ . }}
+{{- end }}
+{{- end }}
 `
@@ -0,0 +1,228 @@
+package cni
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"time"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// CiliumBGPPolicyName the CiliumBGPPeeringPolicy generated from the BGP peerings.
+	CiliumBGPPolicyName = "kubeclipper-bgp"
+
+	ciliumBGPPolicyCRD = "ciliumbgppeeringpolicies.cilium.io"
+
+	// ciliumMaxASN the largest usable 4-byte ASN, 4294967295 is reserved.
+	ciliumMaxASN = 4294967294
+)
+
+// PeeringUpdater is implemented by the cnis which apply changed BGP peerings to the installed cni without upgrading it.
+type PeeringUpdater interface {
+	PeeringSteps(nodes []v1.StepNode) ([]v1.Step, error)
+}
+
+// BGP the BGP control plane rendered by the values template, nil when it is disabled.
+func (runnable *CiliumRunnable) BGP() *v1.CiliumBGP {
+	if runnable.CiliumConfig == nil || runnable.CiliumConfig.BGP == nil || !runnable.CiliumConfig.BGP.Enabled {
+		return nil
+	}
+	return runnable.CiliumConfig.BGP
+}
+
+// BGPPeeringHash returns the hash of the cilium BGP peerings of c, it is empty when the BGP control plane is disabled.
+func BGPPeeringHash(c *v1.CNI) string {
+	if c.Type != "cilium" || c.Cilium == nil || c.Cilium.BGP == nil || !c.Cilium.BGP.Enabled {
+		return ""
+	}
+	data, _ := json.Marshal(c.Cilium.BGP.Peerings)
+	return fmt.Sprintf("%x", sha256.Sum256(data))[:16]
+}
+
+func (runnable *CiliumRunnable) validateBGP() error {
+	bgp := runnable.CiliumConfig.BGP
+	if bgp == nil {
+		return nil
+	}
+	if !bgp.Enabled {
+		if len(bgp.Peerings) > 0 {
+			return fmt.Errorf("cilium bgp peerings require the bgp control plane to be enabled")
+		}
+		return nil
+	}
+	if !runnable.VersionAtLeast("1.13") {
+		return fmt.Errorf("cilium bgp control plane requires cilium >= 1.13, got %s", runnable.Version)
+	}
+	if len(bgp.Peerings) == 0 {
+		return fmt.Errorf("cilium bgp control plane requires at least one peering")
+	}
+	peers := make(map[string]bool)
+	routers := make(map[int64]v1.CiliumBGPPeering)
+	for _, peering := range bgp.Peerings {
+		for kind, asn := range map[string]int64{"peer": peering.PeerASN, "local": peering.LocalASN} {
+			if asn < 1 || asn > ciliumMaxASN {
+				return fmt.Errorf("invalid cilium bgp %s ASN %d of peer %s: must be between 1 and %d", kind, asn, peering.PeerAddress, ciliumMaxASN)
+			}
+		}
+		address, err := bgpPeerAddress(peering.PeerAddress)
+		if err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%d/%s", peering.LocalASN, address)
+		if peers[key] {
+			return fmt.Errorf("duplicate cilium bgp peer %s of local ASN %d", peering.PeerAddress, peering.LocalASN)
+		}
+		peers[key] = true
+		if peering.ServiceSelector != nil {
+			if _, err = metav1.LabelSelectorAsSelector(peering.ServiceSelector); err != nil {
+				return fmt.Errorf("invalid cilium bgp service selector of peer %s: %w", peering.PeerAddress, err)
+			}
+		}
+		router, ok := routers[peering.LocalASN]
+		if !ok {
+			routers[peering.LocalASN] = peering
+			continue
+		}
+		if router.ExportPodCIDR != peering.ExportPodCIDR || !reflect.DeepEqual(router.ServiceSelector, peering.ServiceSelector) {
+			return fmt.Errorf("cilium bgp peers %s and %s of local ASN %d must advertise the same CIDRs",
+				router.PeerAddress, peering.PeerAddress, peering.LocalASN)
+		}
+	}
+	return nil
+}
+
+// bgpPeerAddress returns the single address CIDR of the peer address, the CIDRs of a single address are accepted too.
+func bgpPeerAddress(address string) (string, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		addr, ipNet, err := net.ParseCIDR(address)
+		if err != nil {
+			return "", fmt.Errorf("invalid cilium bgp peer address %q: must be an IP address", address)
+		}
+		if ones, bits := ipNet.Mask.Size(); ones != bits {
+			return "", fmt.Errorf("invalid cilium bgp peer address %q: must be a single address", address)
+		}
+		ip = addr
+	}
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsMulticast() {
+		return "", fmt.Errorf("invalid cilium bgp peer address %q: must be a unicast address", address)
+	}
+	if ip.To4() != nil {
+		return ip.String() + "/32", nil
+	}
+	return ip.String() + "/128", nil
+}
+
+// BGPManifest the CiliumBGPPeeringPolicy of the BGP peerings, the peerings of the same local ASN form one
+// virtual router. Every node peers, a node only applies one policy.
+func (runnable *CiliumRunnable) BGPManifest() (string, error) {
+	bgp := runnable.BGP()
+	if bgp == nil {
+		return "", nil
+	}
+	var asns []int64
+	routers := make(map[int64]map[string]interface{})
+	for _, peering := range bgp.Peerings {
+		address, err := bgpPeerAddress(peering.PeerAddress)
+		if err != nil {
+			return "", err
+		}
+		neighbor := map[string]interface{}{"peerAddress": address, "peerASN": peering.PeerASN}
+		if router, ok := routers[peering.LocalASN]; ok {
+			router["neighbors"] = append(router["neighbors"].([]interface{}), neighbor)
+			continue
+		}
+		router := map[string]interface{}{
+			"localASN":      peering.LocalASN,
+			"exportPodCIDR": peering.ExportPodCIDR,
+			"neighbors":     []interface{}{neighbor},
+		}
+		if selector := peering.ServiceSelector; selector != nil {
+			if len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0 {
+				// cilium matches no service with an empty selector, this one matches every service
+				selector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "kubeclipper.io/bgp-never", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"never"}},
+				}}
+			}
+			router["serviceSelector"] = selector
+		}
+		routers[peering.LocalASN] = router
+		asns = append(asns, peering.LocalASN)
+	}
+	sort.Slice(asns, func(i, j int) bool { return asns[i] < asns[j] })
+	virtualRouters := make([]interface{}, 0, len(asns))
+	for _, asn := range asns {
+		virtualRouters = append(virtualRouters, routers[asn])
+	}
+	data, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "cilium.io/v2alpha1",
+		"kind":       "CiliumBGPPeeringPolicy",
+		"metadata":   map[string]string{"name": CiliumBGPPolicyName},
+		"spec":       map[string]interface{}{"virtualRouters": virtualRouters},
+	})
+	if err != nil {
+		return "", err
+	}
+	return "---\n" + string(data), nil
+}
+
+// applyBGP applies BGPManifest once the cilium operator registered the CRD.
+func (runnable *CiliumRunnable) applyBGP(nodes []v1.StepNode) (v1.Step, error) {
+	manifest, err := runnable.BGPManifest()
+	if err != nil {
+		return v1.Step{}, err
+	}
+	return v1.Step{
+		ID:                newStepID(),
+		Name:              "applyCiliumBGPPolicy",
+		Timeout:           metav1.Duration{Duration: 3 * time.Minute},
+		ErrIgnore:         false,
+		RetryTimes:        1,
+		Nodes:             nodes,
+		Action:            v1.ActionInstall,
+		BeforeRunCommands: runnable.writeWaitKubeconfig(nodes),
+		Commands:          applyAfterCRDs(nodes, runnable.waitKubeconfig(), manifest, ciliumBGPPolicyCRD),
+	}, nil
+}
+
+// deleteBGP deletes the generated CiliumBGPPeeringPolicy, the nodes close their BGP sessions.
+func (runnable *CiliumRunnable) deleteBGP(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "deleteCiliumBGPPolicy",
+		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "delete", "--ignore-not-found", ciliumBGPPolicyCRD + "/" + CiliumBGPPolicyName},
+			},
+		},
+	}
+}
+
+// PeeringSteps applies the BGP peerings to the installed cilium without a helm upgrade, the peering policy is
+// deleted when the BGP control plane is disabled. The BGP control plane itself is only switched by the upgrade.
+func (runnable *CiliumRunnable) PeeringSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	if runnable.BGP() == nil {
+		step := runnable.deleteBGP(nodes)
+		step.Action = v1.ActionUpgrade
+		return runnable.stableSteps([]v1.Step{step}, nil)
+	}
+	step, err := runnable.applyBGP(nodes)
+	if err != nil {
+		return nil, err
+	}
+	step.Action = v1.ActionUpgrade
+	return runnable.stableSteps(runnable.withRetryPolicy([]v1.Step{step}), nil)
+}
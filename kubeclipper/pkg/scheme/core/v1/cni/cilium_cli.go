@@ -0,0 +1,152 @@
+package cni
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/cmdutil"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ciliumCLI = "cilium-cli"
+	// ciliumCLIVersionDefault the cilium CLI release used when the cilium minor version has no matching release.
+	ciliumCLIVersionDefault = "v0.16.19"
+	// ciliumCLIFilename the package of the cilium CLI, a tarball of the cilium binary as released upstream.
+	ciliumCLIFilename = "cilium.tar.gz"
+	ciliumCLIPath     = "/usr/local/bin/cilium"
+)
+
+func init() {
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-"+ciliumCLI, version, component.TypeStep), &CiliumCLI{}); err != nil {
+		panic(err)
+	}
+}
+
+// CiliumCLI installs the cilium CLI of Version to /usr/local/bin on the node, or removes it on uninstall.
+// The CLI is downloaded from Mirror when it is set, otherwise from the package server.
+type CiliumCLI struct {
+	Version string `json:"version"`
+	Offline bool   `json:"offline"`
+	Mirror  string `json:"mirror,omitempty"`
+}
+
+func (c *CiliumCLI) NewInstance() component.ObjectMeta {
+	return &CiliumCLI{}
+}
+
+func (c *CiliumCLI) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	var (
+		instance *downloader.Downloader
+		err      error
+	)
+	if c.Mirror != "" {
+		instance, err = downloader.NewMirrorInstance(ctx, c.Mirror, ciliumCLI, c.Version, runtime.GOARCH, opts.DryRun)
+	} else {
+		instance, err = downloader.NewInstance(ctx, ciliumCLI, c.Version, runtime.GOARCH, !c.Offline, opts.DryRun)
+	}
+	if err != nil {
+		return nil, err
+	}
+	files, err := instance.DownloadCustomImages(ciliumCLIFilename)
+	if err != nil {
+		return nil, fmt.Errorf("download cilium CLI %s failed: %v", c.Version, err)
+	}
+	if _, err = cmdutil.RunCmdWithContext(ctx, opts.DryRun, "tar", "-zxf", files[0], "-C", filepath.Dir(ciliumCLIPath), "cilium"); err != nil {
+		return nil, err
+	}
+	_, err = cmdutil.RunCmdWithContext(ctx, opts.DryRun, "chmod", "0755", ciliumCLIPath)
+	return nil, err
+}
+
+func (c *CiliumCLI) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	_, err := cmdutil.RunCmdWithContext(ctx, opts.DryRun, "rm", "-f", ciliumCLIPath)
+	return nil, err
+}
+
+// cliVersion the cilium CLI release matching the cilium version.
+func (runnable *CiliumRunnable) cliVersion() string {
+	v, err := utilversion.ParseGeneric(runnable.Version)
+	if err != nil {
+		return ciliumCLIVersionDefault
+	}
+	return strutil.StringDefaultIfEmpty(ciliumCLIVersionDefault, ciliumImageTagsByMinor[fmt.Sprintf("%d.%d", v.Major(), v.Minor())].CLI)
+}
+
+func (runnable *CiliumRunnable) validateCLIMirror() error {
+	mirror := runnable.CiliumConfig.CLIMirror
+	if mirror == "" {
+		return nil
+	}
+	if u, err := url.Parse(mirror); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid cilium CLI mirror %q: must be an http or https URL", mirror)
+	}
+	return nil
+}
+
+// installCLI installs the cilium CLI matching the cilium version on nodes.
+func (runnable *CiliumRunnable) installCLI(nodes []v1.StepNode) (v1.Step, error) {
+	return runnable.cliStep("installCiliumCLI", v1.ActionInstall, nodes)
+}
+
+// removeCLI removes the cilium CLI from nodes.
+func (runnable *CiliumRunnable) removeCLI(nodes []v1.StepNode) (v1.Step, error) {
+	step, err := runnable.cliStep("removeCiliumCLI", v1.ActionUninstall, nodes)
+	step.ErrIgnore = true
+	return step, err
+}
+
+func (runnable *CiliumRunnable) cliStep(name string, action v1.StepAction, nodes []v1.StepNode) (v1.Step, error) {
+	cli := &CiliumCLI{Version: runnable.cliVersion(), Offline: runnable.Offline}
+	if runnable.CiliumConfig != nil {
+		cli.Mirror = runnable.CiliumConfig.CLIMirror
+	}
+	custom, err := json.Marshal(cli)
+	if err != nil {
+		return v1.Step{}, err
+	}
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       name,
+		Timeout:    metav1.Duration{Duration: 3 * time.Minute},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     action,
+		Commands: []v1.Command{
+			{
+				Type:          v1.CommandCustom,
+				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+ciliumCLI, version, component.TypeStep),
+				CustomCommand: custom,
+			},
+		},
+	}, nil
+}
+
+// mastersOf the masters of the cluster among nodes, the CLI is installed on the nodes the cluster scoped steps run on.
+func (runnable *CiliumRunnable) mastersOf(nodes []v1.StepNode) []v1.StepNode {
+	ids := sets.NewString()
+	for _, node := range runnable.masters {
+		ids.Insert(node.ID)
+	}
+	var masters []v1.StepNode
+	for _, node := range nodes {
+		if ids.Has(node.ID) {
+			masters = append(masters, node)
+		}
+	}
+	return masters
+}
@@ -0,0 +1,104 @@
+package cni
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component/common"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
+)
+
+const (
+	// OperationDebugOn and OperationDebugOff switch the debug logs of a running cilium release.
+	OperationDebugOn  = "debug-on"
+	OperationDebugOff = "debug-off"
+)
+
+const (
+	CiliumLogLevelInfo  = "info"
+	CiliumLogLevelDebug = "debug"
+	CiliumLogLevelTrace = "trace"
+
+	// ciliumTraceSubsystems the verbose debug subsystems of the trace log level.
+	ciliumTraceSubsystems = "flow kvstore envoy datapath policy"
+)
+
+var (
+	ciliumMonitorAggregations = sets.NewString("none", "low", "medium", "maximum")
+	ciliumLogLevels           = sets.NewString(CiliumLogLevelInfo, CiliumLogLevelDebug, CiliumLogLevelTrace)
+)
+
+// LogLevel the log level of the agents and operator, Debug raises the default info level to debug.
+func (runnable *CiliumRunnable) LogLevel() string {
+	switch {
+	case runnable.CiliumConfig == nil:
+		return CiliumLogLevelInfo
+	case runnable.CiliumConfig.LogLevel != "":
+		return runnable.CiliumConfig.LogLevel
+	case runnable.CiliumConfig.Debug:
+		return CiliumLogLevelDebug
+	}
+	return CiliumLogLevelInfo
+}
+
+// Debug whether the debug logs are rendered.
+func (runnable *CiliumRunnable) Debug() bool {
+	return runnable.LogLevel() != CiliumLogLevelInfo
+}
+
+// DebugVerbose the debug.verbose value of the chart, the subsystems logging verbosely at the trace level.
+func (runnable *CiliumRunnable) DebugVerbose() string {
+	if runnable.LogLevel() == CiliumLogLevelTrace {
+		return ciliumTraceSubsystems
+	}
+	return ""
+}
+
+func (runnable *CiliumRunnable) validateLogLevel() error {
+	if level := runnable.LogLevel(); !ciliumLogLevels.Has(level) {
+		return fmt.Errorf("invalid cilium log level %q, supported values: %v", level, ciliumLogLevels.List())
+	}
+	return nil
+}
+
+// MonitorAggregation the bpf.monitorAggregation value of the chart, empty when the chart default applies.
+func (runnable *CiliumRunnable) MonitorAggregation() string {
+	if runnable.CiliumConfig == nil {
+		return ""
+	}
+	return runnable.CiliumConfig.MonitorAggregation
+}
+
+func (runnable *CiliumRunnable) validateMonitorAggregation() error {
+	if level := runnable.MonitorAggregation(); level != "" && !ciliumMonitorAggregations.Has(level) {
+		return fmt.Errorf("invalid cilium monitor aggregation %q, supported values: %v", level, ciliumMonitorAggregations.List())
+	}
+	return nil
+}
+
+// ciliumRestartCommand restarts the cilium agents, e.g. to apply the changed configuration.
+func ciliumRestartCommand(namespace string) string {
+	return fmt.Sprintf("kubectl rollout restart ds cilium -n %s", namespace)
+}
+
+// debugOperation switches the debug logs of the running release on or off and restarts the agents. The release
+// keeps its other values, they are reused rather than rendered again, and the chart of the installed version
+// downloaded by the install is upgraded to. The next cni upgrade renders the Debug of the cni configuration.
+func (runnable *CiliumRunnable) debugOperation(namespace string, enabled bool) Operation {
+	name, state := OperationDebugOn, "on"
+	if !enabled {
+		name, state = OperationDebugOff, "off"
+	}
+	chartPath := filepath.Join(downloader.BaseDstDir, ".cilium", runnable.Version, downloader.ChartFilename)
+	return Operation{
+		Name:        name,
+		Description: fmt.Sprintf("Switch the debug logs of the running cilium release %s and restart the cilium agents, the other values of the release are kept.", state),
+		Command: fmt.Sprintf(`[ -f %[1]s ] || { echo "the cilium %[2]s chart %[1]s is not on the node, upgrade the cni to download it" >&2; exit 1; }
+%[3]s upgrade %[4]s %[1]s -n %[5]s --reuse-values --set debug.enabled=%[6]t && %[7]s`,
+			chartPath, runnable.Version, common.HelmBin, runnable.ReleaseName(), namespace, enabled, ciliumRestartCommand(namespace)),
+		Destructive: true,
+		Output:      OperationOutputText,
+	}
+}
@@ -0,0 +1,108 @@
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+const (
+	// ciliumDNSProbeImage and ciliumDNSProbeTag the image of the pod probing the DNS resolution.
+	ciliumDNSProbeImage = "docker.io/library/busybox"
+	ciliumDNSProbeTag   = "1.36.1"
+	// ciliumDNSProbeFailed the readiness check prints it when cilium is ready but the probe pod can not resolve.
+	ciliumDNSProbeFailed = "cilium is ready but the DNS resolution probe failed"
+)
+
+var ciliumDNSRejectResponseCodes = sets.NewString("refused", "nameError")
+
+// dnsResolutionMatcher tells the failed DNS probe of the readiness check from the failed rollouts.
+var dnsResolutionMatcher = v1.StepErrorMatcher{
+	Category: v1.StepErrorDNSResolutionFailed,
+	Pattern:  ciliumDNSProbeFailed,
+	Hint:     "cilium is running but the pods can not resolve the cluster names through the DNS proxy, check the kube-dns pods, the network policies selecting them and the DNS proxy settings",
+}
+
+// DNSProxy whether the DNS proxy is rendered.
+func (runnable *CiliumRunnable) DNSProxy() bool {
+	return runnable.CiliumConfig != nil && runnable.CiliumConfig.EnableDNSProxy
+}
+
+// DNSProxyValues the dnsProxy values of the chart, nil when the chart defaults apply.
+func (runnable *CiliumRunnable) DNSProxyValues() map[string]interface{} {
+	if !runnable.DNSProxy() || runnable.CiliumConfig.DNSProxyConfig == nil {
+		return nil
+	}
+	config := runnable.CiliumConfig.DNSProxyConfig
+	values := make(map[string]interface{})
+	if config.MinTTL > 0 {
+		values["minTtl"] = config.MinTTL
+	}
+	if config.PreCache != "" {
+		values["preCache"] = config.PreCache
+	}
+	if config.RejectResponseCode != "" {
+		values["dnsRejectResponseCode"] = config.RejectResponseCode
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+func (runnable *CiliumRunnable) validateDNSProxy() error {
+	config := runnable.CiliumConfig.DNSProxyConfig
+	if config == nil {
+		return nil
+	}
+	if !runnable.CiliumConfig.EnableDNSProxy {
+		return fmt.Errorf("cilium dns proxy config requires enableDNSProxy")
+	}
+	if config.MinTTL < 0 {
+		return fmt.Errorf("cilium dns proxy min TTL %d must not be negative", config.MinTTL)
+	}
+	if config.PreCache != "" && !filepath.IsAbs(config.PreCache) {
+		return fmt.Errorf("cilium dns proxy pre-cache %q must be an absolute path", config.PreCache)
+	}
+	if code := config.RejectResponseCode; code != "" && !ciliumDNSRejectResponseCodes.Has(code) {
+		return fmt.Errorf("invalid cilium dns proxy reject response code %q, supported values: %v", code, ciliumDNSRejectResponseCodes.List())
+	}
+	return nil
+}
+
+// DNSProbeImage the image of the DNS probe pod, rewritten to LocalRegistry when it is set.
+func (runnable *CiliumRunnable) DNSProbeImage() string {
+	if runnable.LocalRegistry == "" {
+		return ciliumDNSProbeImage + ":" + ciliumDNSProbeTag
+	}
+	return strings.TrimSuffix(runnable.LocalRegistry, "/") + "/library/busybox:" + ciliumDNSProbeTag
+}
+
+// dnsProbeScript resolves the kubernetes service from a pod on the nodes of the agents, it prints
+// ciliumDNSProbeFailed and fails when the name does not resolve.
+func (runnable *CiliumRunnable) dnsProbeScript() string {
+	spec := make(map[string]interface{})
+	if runnable.ImagePullSecret != "" {
+		spec["imagePullSecrets"] = []map[string]string{{"name": runnable.ImagePullSecret}}
+	}
+	if len(runnable.CiliumConfig.NodeSelector) > 0 {
+		spec["nodeSelector"] = runnable.CiliumConfig.NodeSelector
+	}
+	overrides := ""
+	if len(spec) > 0 {
+		// the maps of strings always marshal
+		data, _ := json.Marshal(map[string]interface{}{"apiVersion": "v1", "spec": spec})
+		overrides = fmt.Sprintf(" --overrides '%s'", data)
+	}
+	return fmt.Sprintf(`probe=kc-dns-probe-$RANDOM
+if ! kubectl run $probe -n %[1]s --image %[2]s --restart Never --rm -i --quiet --pod-running-timeout 2m%[3]s --command -- nslookup kubernetes.default; then
+  kubectl delete pod $probe -n %[1]s --ignore-not-found --wait=false >/dev/null
+  echo "%[4]s"
+  exit 1
+fi`, runnable.Namespace, runnable.DNSProbeImage(), overrides, ciliumDNSProbeFailed)
+}
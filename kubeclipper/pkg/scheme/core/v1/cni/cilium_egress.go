@@ -0,0 +1,147 @@
+package cni
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ciliumEgressPolicyLabel labels the generated CiliumEgressGatewayPolicies, the uninstall deletes them by it.
+	ciliumEgressPolicyLabel = "kubeclipper.io/cilium-egress-gateway"
+
+	ciliumEgressPolicyCRD = "ciliumegressgatewaypolicies.cilium.io"
+	// podNamespaceLabel the label cilium gives the pods of their namespace.
+	podNamespaceLabel = "io.kubernetes.pod.namespace"
+)
+
+// EgressGateway whether the egress gateway is rendered.
+func (runnable *CiliumRunnable) EgressGateway() bool {
+	return runnable.CiliumConfig != nil && runnable.CiliumConfig.EnableEgressGateway
+}
+
+func (runnable *CiliumRunnable) egressGatewayPolicies() []v1.CiliumEgressGatewayPolicy {
+	if runnable.CiliumConfig == nil {
+		return nil
+	}
+	return runnable.CiliumConfig.EgressGatewayPolicies
+}
+
+func (runnable *CiliumRunnable) validateEgressGateway() error {
+	policies := runnable.egressGatewayPolicies()
+	if !runnable.EgressGateway() {
+		if len(policies) > 0 {
+			return fmt.Errorf("cilium egress gateway policies require enableEgressGateway")
+		}
+		return nil
+	}
+	if !runnable.kubeProxyReplaced() {
+		return fmt.Errorf("cilium egress gateway requires kubeProxyReplacement %s or %s",
+			CiliumKubeProxyReplacementStrict, CiliumKubeProxyReplacementTrue)
+	}
+	names := make(map[string]bool)
+	for _, policy := range policies {
+		if errs := validation.IsDNS1123Subdomain(policy.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid cilium egress gateway policy name %q: %v", policy.Name, errs)
+		}
+		if names[policy.Name] {
+			return fmt.Errorf("duplicate cilium egress gateway policy %s", policy.Name)
+		}
+		names[policy.Name] = true
+		if len(policy.Namespaces) == 0 {
+			return fmt.Errorf("cilium egress gateway policy %s selects no namespace", policy.Name)
+		}
+		if len(policy.EgressNodeLabels) == 0 {
+			return fmt.Errorf("cilium egress gateway policy %s requires the egress node labels", policy.Name)
+		}
+		if ip := net.ParseIP(policy.EgressIP); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid egress IP %q of cilium egress gateway policy %s: must be an IPv4 address", policy.EgressIP, policy.Name)
+		}
+		for _, cidr := range policy.DestinationCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid destination CIDR %q of cilium egress gateway policy %s: %w", cidr, policy.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// EgressGatewayManifest the CiliumEgressGatewayPolicies of EgressGatewayPolicies, empty without policies.
+func (runnable *CiliumRunnable) EgressGatewayManifest() (string, error) {
+	buf := &bytes.Buffer{}
+	for _, policy := range runnable.egressGatewayPolicies() {
+		destinations := policy.DestinationCIDRs
+		if len(destinations) == 0 {
+			destinations = []string{"0.0.0.0/0"}
+		}
+		data, err := yaml.Marshal(map[string]interface{}{
+			"apiVersion": "cilium.io/v2",
+			"kind":       "CiliumEgressGatewayPolicy",
+			"metadata": map[string]interface{}{
+				"name":   policy.Name,
+				"labels": map[string]string{ciliumEgressPolicyLabel: "true"},
+			},
+			"spec": map[string]interface{}{
+				"selectors": []interface{}{
+					map[string]interface{}{"podSelector": &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: podNamespaceLabel, Operator: metav1.LabelSelectorOpIn, Values: policy.Namespaces},
+					}}},
+				},
+				"destinationCIDRs": destinations,
+				"egressGateway": map[string]interface{}{
+					"nodeSelector": map[string]interface{}{"matchLabels": policy.EgressNodeLabels},
+					"egressIP":     policy.EgressIP,
+				},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(buf, "---\n%s", data)
+	}
+	return buf.String(), nil
+}
+
+// applyEgressGateway applies EgressGatewayManifest once the cilium operator registered the CRD.
+func (runnable *CiliumRunnable) applyEgressGateway(nodes []v1.StepNode) (v1.Step, error) {
+	manifest, err := runnable.EgressGatewayManifest()
+	if err != nil {
+		return v1.Step{}, err
+	}
+	return v1.Step{
+		ID:                newStepID(),
+		Name:              "applyCiliumEgressGatewayPolicies",
+		Timeout:           metav1.Duration{Duration: 3 * time.Minute},
+		ErrIgnore:         false,
+		RetryTimes:        1,
+		Nodes:             nodes,
+		Action:            v1.ActionInstall,
+		BeforeRunCommands: runnable.writeWaitKubeconfig(nodes),
+		Commands:          applyAfterCRDs(nodes, runnable.waitKubeconfig(), manifest, ciliumEgressPolicyCRD),
+	}, nil
+}
+
+// deleteEgressGateway deletes the generated CiliumEgressGatewayPolicies before the release is uninstalled.
+func (runnable *CiliumRunnable) deleteEgressGateway(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "deleteCiliumEgressGatewayPolicies",
+		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "delete", "--ignore-not-found", ciliumEgressPolicyCRD, "-l", ciliumEgressPolicyLabel},
+			},
+		},
+	}
+}
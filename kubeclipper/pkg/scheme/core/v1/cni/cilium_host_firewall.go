@@ -0,0 +1,109 @@
+package cni
+
+import (
+	"strconv"
+	"time"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// CiliumHostFirewallPolicyName the CiliumClusterwideNetworkPolicy allowing the node ports kubeclipper and
+	// kubernetes need once the host firewall is enabled.
+	CiliumHostFirewallPolicyName = "kubeclipper-host-baseline"
+
+	ciliumClusterwidePolicyCRD = "ciliumclusterwidenetworkpolicies.cilium.io"
+
+	sshPort          = 22
+	kubeletPort      = 10250
+	defaultAgentPort = 9889
+)
+
+// HostFirewall whether the host firewall is rendered.
+func (runnable *CiliumRunnable) HostFirewall() bool {
+	return runnable.CiliumConfig != nil && runnable.CiliumConfig.EnableHostFirewall
+}
+
+// hostFirewallPorts the node ports the baseline policy allows from everywhere: SSH, the kubelet, the kube-apiserver
+// and the kubeclipper agent.
+func (runnable *CiliumRunnable) hostFirewallPorts() []int {
+	apiServer := runnable.apiServerPort
+	if apiServer == 0 {
+		apiServer = apiServerPort
+	}
+	agent := runnable.agentPort
+	if agent == 0 {
+		agent = defaultAgentPort
+	}
+	return []int{sshPort, kubeletPort, apiServer, agent}
+}
+
+// HostFirewallPolicyManifest the baseline CiliumClusterwideNetworkPolicy of the nodes, the traffic of the cluster
+// is allowed so that the host firewall does not cut the tunnels, the health checks and the pods off the nodes.
+func (runnable *CiliumRunnable) HostFirewallPolicyManifest() (string, error) {
+	ports := make([]map[string]string, 0, 4)
+	for _, port := range runnable.hostFirewallPorts() {
+		ports = append(ports, map[string]string{"port": strconv.Itoa(port), "protocol": "TCP"})
+	}
+	data, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "cilium.io/v2",
+		"kind":       "CiliumClusterwideNetworkPolicy",
+		"metadata":   map[string]string{"name": CiliumHostFirewallPolicyName},
+		"spec": map[string]interface{}{
+			"description":  "kubeclipper baseline of the cilium host firewall",
+			"nodeSelector": map[string]interface{}{},
+			"ingress": []interface{}{
+				map[string]interface{}{"fromEntities": []string{"cluster"}},
+				map[string]interface{}{
+					"fromEntities": []string{"all"},
+					"toPorts":      []interface{}{map[string]interface{}{"ports": ports}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return "---\n" + string(data), nil
+}
+
+// applyHostFirewallPolicy applies HostFirewallPolicyManifest right after the release is ready, the host firewall
+// denies the node ports without it.
+func (runnable *CiliumRunnable) applyHostFirewallPolicy(nodes []v1.StepNode) (v1.Step, error) {
+	manifest, err := runnable.HostFirewallPolicyManifest()
+	if err != nil {
+		return v1.Step{}, err
+	}
+	return v1.Step{
+		ID:                newStepID(),
+		Name:              "applyCiliumHostFirewallPolicy",
+		Timeout:           metav1.Duration{Duration: 3 * time.Minute},
+		ErrIgnore:         false,
+		RetryTimes:        1,
+		Nodes:             nodes,
+		Action:            v1.ActionInstall,
+		BeforeRunCommands: runnable.writeWaitKubeconfig(nodes),
+		Commands:          applyAfterCRDs(nodes, runnable.waitKubeconfig(), manifest, ciliumClusterwidePolicyCRD),
+	}, nil
+}
+
+// deleteHostFirewallPolicy deletes the baseline policy before the release is uninstalled.
+func (runnable *CiliumRunnable) deleteHostFirewallPolicy(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "deleteCiliumHostFirewallPolicy",
+		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "delete", "--ignore-not-found", ciliumClusterwidePolicyCRD + "/" + CiliumHostFirewallPolicyName},
+			},
+		},
+	}
+}
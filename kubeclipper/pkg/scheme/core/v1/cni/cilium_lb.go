@@ -0,0 +1,221 @@
+package cni
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// CiliumLBIPPoolName the CiliumLoadBalancerIPPool generated from the LoadBalancerIPPools.
+	CiliumLBIPPoolName = "kubeclipper-lb-pool"
+	// CiliumL2PolicyName the CiliumL2AnnouncementPolicy generated when the L2 announcements are enabled.
+	CiliumL2PolicyName = "kubeclipper-l2-policy"
+
+	ciliumLBIPPoolCRD = "ciliumloadbalancerippools.cilium.io"
+	ciliumL2PolicyCRD = "ciliuml2announcementpolicies.cilium.io"
+)
+
+var (
+	// ciliumL2AnnouncementsMinVersion the first cilium version with the L2 announcements.
+	ciliumL2AnnouncementsMinVersion = utilversion.MustParseGeneric("1.14.0")
+	// ciliumLBIPPoolBlocksVersion the first cilium version naming the CIDRs of the pools blocks instead of cidrs.
+	ciliumLBIPPoolBlocksVersion = utilversion.MustParseGeneric("1.15.0")
+)
+
+// L2Announcements whether the L2 announcements are rendered.
+func (runnable *CiliumRunnable) L2Announcements() bool {
+	return runnable.CiliumConfig != nil && runnable.CiliumConfig.EnableL2Announcements
+}
+
+func (runnable *CiliumRunnable) loadBalancerIPPools() []string {
+	if runnable.CiliumConfig == nil {
+		return nil
+	}
+	return runnable.CiliumConfig.LoadBalancerIPPools
+}
+
+// loadBalancerEnabled whether the install generates the LB-IPAM or the L2 announcement resources.
+func (runnable *CiliumRunnable) loadBalancerEnabled() bool {
+	return runnable.L2Announcements() || len(runnable.loadBalancerIPPools()) > 0
+}
+
+func (runnable *CiliumRunnable) validateLoadBalancer() error {
+	if runnable.L2Announcements() {
+		if !runnable.VersionAtLeast(ciliumL2AnnouncementsMinVersion.String()) {
+			return fmt.Errorf("cilium L2 announcements require cilium >= %s, got %s", ciliumL2AnnouncementsMinVersion, runnable.Version)
+		}
+		if !runnable.kubeProxyReplaced() {
+			return fmt.Errorf("cilium L2 announcements require kubeProxyReplacement %s or %s",
+				CiliumKubeProxyReplacementStrict, CiliumKubeProxyReplacementTrue)
+		}
+	}
+	reserved := map[string][]string{"service": runnable.serviceCIDRs, "pod": {runnable.PodIPv4CIDR, runnable.PodIPv6CIDR}}
+	reserved["pod"] = append(reserved["pod"], runnable.CiliumConfig.ClusterPoolIPv4PodCIDRList...)
+	reserved["pod"] = append(reserved["pod"], runnable.CiliumConfig.ClusterPoolIPv6PodCIDRList...)
+	for _, pool := range runnable.podIPPools() {
+		reserved["pod"] = append(reserved["pod"], pool.CIDR)
+	}
+	var pools []*net.IPNet
+	for _, cidr := range runnable.loadBalancerIPPools() {
+		_, pool, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid cilium load balancer IP pool %q: %w", cidr, err)
+		}
+		for kind, cidrs := range reserved {
+			for _, c := range cidrs {
+				if _, ipNet, err := net.ParseCIDR(c); err == nil && cidrOverlap(pool, ipNet) {
+					return fmt.Errorf("cilium load balancer IP pool %s overlaps the %s CIDR %s", cidr, kind, c)
+				}
+			}
+		}
+		for _, node := range runnable.allNodes {
+			for _, ip := range []string{node.IPv4, node.NodeIPv4} {
+				if nodeIP := net.ParseIP(ip); nodeIP != nil && pool.Contains(nodeIP) {
+					return fmt.Errorf("cilium load balancer IP pool %s overlaps the node address %s", cidr, ip)
+				}
+			}
+		}
+		for _, other := range pools {
+			if cidrOverlap(pool, other) {
+				return fmt.Errorf("cilium load balancer IP pools %s and %s overlap", cidr, other)
+			}
+		}
+		pools = append(pools, pool)
+	}
+	return nil
+}
+
+func cidrOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// checkLoadBalancerNetwork fails on the nodes which reach the IP pools through a gateway, the L2 announcements
+// only answer ARP and NDP on the networks the nodes are attached to.
+func (runnable *CiliumRunnable) checkLoadBalancerNetwork() v1.Step {
+	var ips []string
+	for _, cidr := range runnable.loadBalancerIPPools() {
+		if _, pool, err := net.ParseCIDR(cidr); err == nil {
+			ips = append(ips, pool.IP.String())
+		}
+	}
+	return v1.Step{
+		ID:             newStepID(),
+		Name:           "checkCiliumLBNetwork",
+		Timeout:        metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:      false,
+		RetryTimes:     0,
+		RetryPolicySet: true,
+		Nodes:          runnable.agentNodes(runnable.allNodes),
+		Action:         v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf(`for ip in %s; do if ip route get "$ip" | grep -qw via; then echo "the cilium load balancer IP pool of $ip is not on the network of node $(hostname), the L2 announcements can not reach it" >&2; exit 1; fi; done`,
+						strings.Join(ips, " "))},
+			},
+		},
+	}
+}
+
+// loadBalancerResources the generated resources as crd/name.
+func (runnable *CiliumRunnable) loadBalancerResources() []string {
+	var resources []string
+	if len(runnable.loadBalancerIPPools()) > 0 {
+		resources = append(resources, ciliumLBIPPoolCRD+"/"+CiliumLBIPPoolName)
+	}
+	if runnable.L2Announcements() {
+		resources = append(resources, ciliumL2PolicyCRD+"/"+CiliumL2PolicyName)
+	}
+	return resources
+}
+
+// LoadBalancerManifest the CiliumLoadBalancerIPPool of the LoadBalancerIPPools and the CiliumL2AnnouncementPolicy
+// of the L2 announcements, empty when neither is enabled.
+func (runnable *CiliumRunnable) LoadBalancerManifest() (string, error) {
+	var objects []map[string]interface{}
+	if pools := runnable.loadBalancerIPPools(); len(pools) > 0 {
+		field := "blocks"
+		if !runnable.VersionAtLeast(ciliumLBIPPoolBlocksVersion.String()) {
+			field = "cidrs"
+		}
+		blocks := make([]map[string]string, 0, len(pools))
+		for _, cidr := range pools {
+			blocks = append(blocks, map[string]string{"cidr": cidr})
+		}
+		objects = append(objects, map[string]interface{}{
+			"apiVersion": "cilium.io/v2alpha1",
+			"kind":       "CiliumLoadBalancerIPPool",
+			"metadata":   map[string]string{"name": CiliumLBIPPoolName},
+			"spec":       map[string]interface{}{field: blocks},
+		})
+	}
+	if runnable.L2Announcements() {
+		objects = append(objects, map[string]interface{}{
+			"apiVersion": "cilium.io/v2alpha1",
+			"kind":       "CiliumL2AnnouncementPolicy",
+			"metadata":   map[string]string{"name": CiliumL2PolicyName},
+			"spec":       map[string]bool{"loadBalancerIPs": true, "externalIPs": true},
+		})
+	}
+	buf := &bytes.Buffer{}
+	for _, obj := range objects {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(buf, "---\n%s", data)
+	}
+	return buf.String(), nil
+}
+
+// applyLoadBalancer applies LoadBalancerManifest once the cilium operator registered the CRDs.
+func (runnable *CiliumRunnable) applyLoadBalancer(nodes []v1.StepNode) (v1.Step, error) {
+	manifest, err := runnable.LoadBalancerManifest()
+	if err != nil {
+		return v1.Step{}, err
+	}
+	var crds []string
+	for _, resource := range runnable.loadBalancerResources() {
+		crds = append(crds, strings.Split(resource, "/")[0])
+	}
+	return v1.Step{
+		ID:                newStepID(),
+		Name:              "applyCiliumLBResources",
+		Timeout:           metav1.Duration{Duration: 3 * time.Minute},
+		ErrIgnore:         false,
+		RetryTimes:        1,
+		Nodes:             nodes,
+		Action:            v1.ActionInstall,
+		BeforeRunCommands: runnable.writeWaitKubeconfig(nodes),
+		Commands:          applyAfterCRDs(nodes, runnable.waitKubeconfig(), manifest, crds...),
+	}, nil
+}
+
+// deleteLoadBalancer deletes the generated LB-IPAM and L2 announcement resources, it must run before the release
+// is uninstalled, the CRDs are gone afterwards.
+func (runnable *CiliumRunnable) deleteLoadBalancer(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "deleteCiliumLBResources",
+		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: append([]string{"kubectl", "delete", "--ignore-not-found"}, runnable.loadBalancerResources()...),
+			},
+		},
+	}
+}
@@ -0,0 +1,282 @@
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component/common"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ciliumMigrationNodeLabel selects the nodes whose cilium agent takes over the pod network during the migration.
+	ciliumMigrationNodeLabel = "io.cilium.migration/cilium-default"
+	// ciliumMigrationNodeConfig the CiliumNodeConfig applied to the labeled nodes.
+	ciliumMigrationNodeConfig = "cilium-default"
+	// ciliumMigrationTunnelPort keeps the cilium vxlan port apart from the one of calico while both are running.
+	ciliumMigrationTunnelPort = 8473
+	// ciliumMigrationNodeTimeout the time to drain and restart the pods of a single node.
+	ciliumMigrationNodeTimeout = 10 * time.Minute
+)
+
+// MigrationSteps migrates the pod network of the cluster from calico to cilium without rebuilding it, following
+// the upstream procedure: cilium is installed next to calico without writing the cni config, then the nodes are
+// switched one by one by a CiliumNodeConfig and their pods are restarted, and finally calico is removed and
+// cilium becomes the primary cni. A node failing to switch restores its calico config before the plan stops.
+func (runnable *CiliumRunnable) MigrationSteps(from Stepper, nodes []v1.StepNode) ([]v1.Step, error) {
+	calico, ok := from.(*CalicoRunnable)
+	if !ok {
+		return nil, fmt.Errorf("migrating from %T to cilium is not supported", from)
+	}
+	if err := runnable.validateMigration(calico); err != nil {
+		return nil, err
+	}
+	master, ok := runnable.clusterScopedNodes(nodes)
+	if !ok {
+		return nil, fmt.Errorf("the cni migration must cover every node of the cluster")
+	}
+	master, failover, err := runnable.helmExecutor(master)
+	if err != nil {
+		return nil, err
+	}
+
+	migration := *runnable
+	migration.Migration = true
+	calicoBytes, err := json.Marshal(calico)
+	if err != nil {
+		return nil, err
+	}
+	chart := &common.Chart{
+		PkgName: "cilium",
+		Version: runnable.Version,
+		Offline: runnable.Offline,
+		Source:  runnable.ChartSource,
+	}
+	chartPath := filepath.Join(downloader.BaseDstDir, "."+chart.PkgName, chart.Version, downloader.ChartFilename)
+	values := runnable.manifestPath("cilium.yaml")
+	release := runnable.ReleaseName()
+
+	var steps []v1.Step
+	loadSteps, err := runnable.LoadImage(nodes)
+	if err != nil {
+		return nil, err
+	}
+	steps = append(steps, loadSteps...)
+	if runnable.encryptionType() == CiliumEncryptionWireguard {
+		steps = append(steps, runnable.checkWireguard())
+	}
+	if runnable.CiliumConfig != nil && runnable.CiliumConfig.EnableBBR {
+		steps = append(steps, runnable.checkBBRKernel())
+	}
+	cLoadSteps, err := chart.InstallStepsV2(master)
+	if err != nil {
+		return nil, err
+	}
+	steps = append(steps, inParallel(stagedOnFailover(cLoadSteps))...)
+
+	// install cilium next to calico, the agents keep off the cni config until their node is labeled
+	renderSteps, err := runnable.renderValues(&migration, master)
+	if err != nil {
+		return nil, err
+	}
+	steps = append(steps, renderSteps...)
+	steps = append(steps, PrepareNamespace("prepareCiliumNamespace", runnable.Namespace, master))
+	if runnable.ImagePullSecret != "" {
+		secret, err := runnable.createPullSecret(master)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, secret)
+	}
+	if runnable.encryptionType() == CiliumEncryptionIPsec {
+		steps = append(steps, runnable.createIPsecKeys(master))
+	}
+	if runnable.KVStore() != nil {
+		steps = append(steps, runnable.createKVStoreSecret(master))
+	}
+	steps = append(steps, CheckHelmReleaseOwner("checkCiliumRelease", release, runnable.Namespace, master))
+	steps = append(steps, InstallCiliumRelease(release, chartPath, values, runnable.Namespace, master,
+		HelmReleaseOptions{Timeout: runnable.installTimeout(0), SetArgs: runnable.extraSetArgs(), APIServerEndpoint: runnable.apiServerEndpoint}))
+	steps = append(steps, MarkHelmRelease("markCiliumRelease", release, runnable.Namespace, master))
+	steps = append(steps, runnable.applyMigrationNodeConfig(master), runnable.checkReady(master))
+
+	// the unavailable nodes are skipped rather than block the migration, they are recorded on the operation
+	available := runnable.nodeStates.available(nodes)
+	for _, node := range available {
+		steps = append(steps, runnable.migrateNode(node, master))
+	}
+
+	// remove calico, every pod is on the cilium network now
+	steps = append(steps, stagedOnFailover([]v1.Step{RenderYaml("calico", calicoBytes, master)})...)
+	steps = append(steps, calico.removeRelease(master))
+	calicoSteps, err := calico.UninstallSteps(available)
+	if err != nil {
+		return nil, err
+	}
+	steps = append(steps, calicoSteps...)
+
+	// render the values without the migration settings, cilium becomes the primary cni
+	renderSteps, err = runnable.renderValues(runnable, master)
+	if err != nil {
+		return nil, err
+	}
+	steps = append(steps, renderSteps...)
+	promote := ciliumReleaseStep("promoteCiliumRelease", release, chartPath, values, runnable.Namespace, master,
+		HelmReleaseOptions{Timeout: runnable.installTimeout(0), SetArgs: runnable.extraSetArgs(), APIServerEndpoint: runnable.apiServerEndpoint})
+	record, err := RecordHelmRelease(promote, release, runnable.Namespace, values)
+	if err != nil {
+		return nil, err
+	}
+	steps = append(steps, promote, record)
+	steps = append(steps, runnable.removeMigrationNodeConfig(master), runnable.checkReady(master))
+
+	return runnable.stableSteps(runnable.withRetryPolicy(withFailover(steps, master, failover)), nil)
+}
+
+// MigrationTunnelPort the vxlan port rendered during the migration.
+func (runnable *CiliumRunnable) MigrationTunnelPort() int {
+	return ciliumMigrationTunnelPort
+}
+
+// validateMigration checks the cilium configuration can run next to calico.
+func (runnable *CiliumRunnable) validateMigration(calico *CalicoRunnable) error {
+	if runnable.LegacyTunnel {
+		return fmt.Errorf("cilium %s does not support the cni migration, the migration requires cilium 1.14 or later", runnable.Version)
+	}
+	if runnable.kubeProxyReplaced() {
+		return fmt.Errorf("kube-proxy replacement is not supported by the cni migration, enable it after the migration")
+	}
+	if runnable.CiliumConfig != nil && runnable.CiliumConfig.TunnelMode == CiliumTunnelDisabled {
+		return fmt.Errorf("the cni migration requires a cilium tunnel mode, %s is not supported", CiliumTunnelDisabled)
+	}
+	pool := []string{ciliumDefaultIPv4PodCIDR}
+	if runnable.CiliumConfig != nil {
+		pool = append(append([]string{}, runnable.CiliumConfig.ClusterPoolIPv4PodCIDRList...), runnable.CiliumConfig.ClusterPoolIPv6PodCIDRList...)
+	}
+	for _, calicoCIDR := range []string{calico.PodIPv4CIDR, calico.PodIPv6CIDR} {
+		_, calicoNet, err := net.ParseCIDR(calicoCIDR)
+		if err != nil {
+			continue
+		}
+		for _, cidr := range pool {
+			if _, ipNet, err := net.ParseCIDR(cidr); err == nil && (ipNet.Contains(calicoNet.IP) || calicoNet.Contains(ipNet.IP)) {
+				return fmt.Errorf("cilium pod CIDR %s overlaps the calico pod CIDR %s, the migration requires a distinct pool", cidr, calicoCIDR)
+			}
+		}
+	}
+	return nil
+}
+
+// applyMigrationNodeConfig creates the CiliumNodeConfig which makes the agents of the labeled nodes write
+// their cni config and move the calico config aside.
+func (runnable *CiliumRunnable) applyMigrationNodeConfig(nodes []v1.StepNode) v1.Step {
+	nodeConfig := fmt.Sprintf(`apiVersion: cilium.io/v2alpha1
+kind: CiliumNodeConfig
+metadata:
+  namespace: %s
+  name: %s
+spec:
+  nodeSelector:
+    matchLabels:
+      %s: "true"
+  defaults:
+    write-cni-conf-when-ready: /host/etc/cni/net.d/05-cilium.conflist
+    custom-cni-conf: "false"
+    cni-chaining-mode: "none"
+    cni-exclusive: "true"
+`, runnable.Namespace, ciliumMigrationNodeConfig, ciliumMigrationNodeLabel)
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "applyCiliumNodeConfig",
+		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c", fmt.Sprintf("kubectl apply -f - <<'EOF'\n%sEOF", nodeConfig)},
+			},
+		},
+	}
+}
+
+// migrateNode switches the node to cilium from the master: the node is drained, then its cilium agent is
+// restarted with the migration config and the remaining pods are restarted on the cilium network.
+// On failure the calico config is restored through the agent, which mounts the cni config directory,
+// and the node is uncordoned before the step fails.
+func (runnable *CiliumRunnable) migrateNode(node v1.StepNode, nodes []v1.StepNode) v1.Step {
+	timeout := runnable.readinessTimeout()
+	script := fmt.Sprintf(`node=%[1]s
+ns=%[2]s
+agent() { kubectl get po -n $ns -l k8s-app=cilium --field-selector spec.nodeName=$node -o name; }
+rollback() {
+  echo "migrating node $node failed, restoring the calico config"
+  pod=$(agent)
+  [ -n "$pod" ] && kubectl exec -n $ns $pod -c cilium-agent -- sh -c 'rm -f /host/etc/cni/net.d/05-cilium.conflist; for f in /host/etc/cni/net.d/*.cilium_bak; do [ -e "$f" ] && mv "$f" "${f%%.cilium_bak}"; done'
+  kubectl label node $node %[3]s-
+  [ -n "$pod" ] && kubectl delete -n $ns $pod --wait=false
+  kubectl uncordon $node
+  exit 1
+}
+set -o pipefail
+kubectl cordon $node || rollback
+kubectl drain $node --ignore-daemonsets --delete-emptydir-data --timeout %[4]s || rollback
+kubectl label node $node %[3]s=true --overwrite || rollback
+kubectl delete -n $ns $(agent) || rollback
+sleep 5
+kubectl wait -n $ns --for=condition=Ready pod -l k8s-app=cilium --field-selector spec.nodeName=$node --timeout %[5]s || rollback
+kubectl get po -A --field-selector spec.nodeName=$node -o custom-columns=NS:.metadata.namespace,NAME:.metadata.name,HOST:.spec.hostNetwork --no-headers | awk '$3!="true"{print $1" "$2}' | while read pns pod; do kubectl delete po -n $pns $pod --wait=false; done || rollback
+kubectl uncordon $node`,
+		node.Hostname, runnable.Namespace, ciliumMigrationNodeLabel, ciliumMigrationNodeTimeout, timeout)
+	return v1.Step{
+		ID:             newStepID(),
+		Name:           fmt.Sprintf("migrateNode-%s", node.Hostname),
+		Timeout:        metav1.Duration{Duration: ciliumMigrationNodeTimeout + timeout + time.Minute},
+		ErrIgnore:      false,
+		RetryTimes:     0,
+		RetryPolicySet: true,
+		Nodes:          nodes,
+		Action:         v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c", script},
+			},
+		},
+	}
+}
+
+// removeMigrationNodeConfig deletes the CiliumNodeConfig and the node labels after cilium became the primary cni,
+// the agents are restarted to drop the per node config.
+func (runnable *CiliumRunnable) removeMigrationNodeConfig(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "removeCiliumNodeConfig",
+		Timeout:    metav1.Duration{Duration: runnable.readinessTimeout() + time.Minute},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "delete", "ciliumnodeconfig", ciliumMigrationNodeConfig, "-n", runnable.Namespace, "--ignore-not-found"},
+			},
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "label", "node", "--all", ciliumMigrationNodeLabel + "-"},
+			},
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "rollout", "restart", "ds/cilium", "-n", runnable.Namespace},
+			},
+		},
+	}
+}
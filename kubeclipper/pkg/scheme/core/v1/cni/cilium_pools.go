@@ -0,0 +1,232 @@
+package cni
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// CiliumIPAMClusterPool the IPAM mode of the cluster pool CIDR lists, the default.
+	CiliumIPAMClusterPool = "cluster-pool"
+	// CiliumIPAMMultiPool the IPAM mode of Pools.
+	CiliumIPAMMultiPool = "multi-pool"
+	// ciliumDefaultPodIPPool the pool of the pods without the ipam.cilium.io/ip-pool annotation, the operator
+	// creates it from the values.
+	ciliumDefaultPodIPPool = "default"
+	// ciliumPodIPPoolLabel labels the generated CiliumPodIPPools and CiliumNodeConfigs, the uninstall deletes them by it.
+	ciliumPodIPPoolLabel = "kubeclipper.io/cilium-pod-ip-pool"
+	// ciliumPodIPPoolPreAllocation the pod IPs the selected nodes allocate ahead of the pods of each pool.
+	ciliumPodIPPoolPreAllocation = 8
+
+	ciliumPodIPPoolCRD    = "ciliumpodippools.cilium.io"
+	ciliumNodeConfigCRD   = "ciliumnodeconfigs.cilium.io"
+	ciliumMultiPoolMinVer = "1.14"
+)
+
+// MultiPool whether the IPAM is rendered in multi-pool mode.
+func (runnable *CiliumRunnable) MultiPool() bool {
+	return len(runnable.podIPPools()) > 0
+}
+
+func (runnable *CiliumRunnable) podIPPools() []v1.CiliumPodIPPool {
+	if runnable.CiliumConfig == nil {
+		return nil
+	}
+	return runnable.CiliumConfig.Pools
+}
+
+// podIPPoolSpec the ipv4 or ipv6 spec of the CiliumPodIPPool of pool.
+func podIPPoolSpec(pool v1.CiliumPodIPPool) map[string]interface{} {
+	family := "ipv4"
+	if ip, _, err := net.ParseCIDR(pool.CIDR); err == nil && ip.To4() == nil {
+		family = "ipv6"
+	}
+	return map[string]interface{}{family: map[string]interface{}{"cidrs": []string{pool.CIDR}, "maskSize": pool.MaskSize}}
+}
+
+// DefaultPodIPPool the spec of the default pool the operator creates.
+func (runnable *CiliumRunnable) DefaultPodIPPool() map[string]interface{} {
+	for _, pool := range runnable.podIPPools() {
+		if pool.Name == ciliumDefaultPodIPPool {
+			return podIPPoolSpec(pool)
+		}
+	}
+	return nil
+}
+
+// MultiPoolIPv6 whether a pool allocates IPv6 pod CIDRs.
+func (runnable *CiliumRunnable) MultiPoolIPv6() bool {
+	for _, pool := range runnable.podIPPools() {
+		if _, ok := podIPPoolSpec(pool)["ipv6"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePodIPPools checks the pools are valid multi-pool IPAM pools which overlap neither each other nor the
+// service CIDRs and the node addresses.
+func (runnable *CiliumRunnable) validatePodIPPools() error {
+	pools := runnable.podIPPools()
+	if len(pools) == 0 {
+		if runnable.CiliumConfig != nil && runnable.CiliumConfig.IPAMMode == CiliumIPAMMultiPool {
+			return fmt.Errorf("cilium ipam mode %s requires the pools", CiliumIPAMMultiPool)
+		}
+		return nil
+	}
+	config := runnable.CiliumConfig
+	if !runnable.VersionAtLeast(ciliumMultiPoolMinVer) {
+		return fmt.Errorf("cilium pools require cilium >= %s, got %s", ciliumMultiPoolMinVer, runnable.Version)
+	}
+	if config.IPAMMode != "" && config.IPAMMode != CiliumIPAMMultiPool {
+		return fmt.Errorf("cilium pools require the ipam mode %s, got %s", CiliumIPAMMultiPool, config.IPAMMode)
+	}
+	if len(config.ClusterPoolIPv4PodCIDRList) > 0 || len(config.ClusterPoolIPv6PodCIDRList) > 0 {
+		return fmt.Errorf("cilium pools can not be used with the cluster pool CIDR lists")
+	}
+	if config.CNIChainingMode != "" {
+		return fmt.Errorf("cilium pools can not be used with the cni chaining mode %s, the chained cni allocates the pod IPs", config.CNIChainingMode)
+	}
+	if config.TunnelMode != CiliumTunnelDisabled {
+		return fmt.Errorf("cilium pools require the native routing, tunnel mode must be %s", CiliumTunnelDisabled)
+	}
+	var reserved []*net.IPNet
+	for _, cidr := range runnable.serviceCIDRs {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			reserved = append(reserved, ipNet)
+		}
+	}
+	var nodeIPs []string
+	for _, node := range runnable.allNodes {
+		nodeIPs = append(nodeIPs, node.IPv4, node.NodeIPv4)
+	}
+	names := make(map[string]bool)
+	cidrs := make(map[string]*net.IPNet)
+	for _, pool := range pools {
+		if errs := validation.IsDNS1123Subdomain(pool.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid cilium pool name %q: %v", pool.Name, errs)
+		}
+		if names[pool.Name] {
+			return fmt.Errorf("duplicate cilium pool %s", pool.Name)
+		}
+		names[pool.Name] = true
+		if pool.Name == ciliumDefaultPodIPPool && len(pool.NodeSelector) > 0 {
+			return fmt.Errorf("the cilium pool %s serves every node, it can not have a node selector", ciliumDefaultPodIPPool)
+		}
+		_, ipNet, err := net.ParseCIDR(pool.CIDR)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q of cilium pool %s: %w", pool.CIDR, pool.Name, err)
+		}
+		if prefix, bits := ipNet.Mask.Size(); pool.MaskSize <= prefix || pool.MaskSize > bits {
+			return fmt.Errorf("mask size %d of cilium pool %s must be larger than the prefix length of %s and at most %d",
+				pool.MaskSize, pool.Name, pool.CIDR, bits)
+		}
+		for name, other := range cidrs {
+			if cidrOverlap(ipNet, other) {
+				return fmt.Errorf("cilium pool %s overlaps the cilium pool %s", pool.Name, name)
+			}
+		}
+		cidrs[pool.Name] = ipNet
+		for _, svc := range reserved {
+			if cidrOverlap(ipNet, svc) {
+				return fmt.Errorf("cilium pool %s overlaps the service CIDR %s", pool.Name, svc)
+			}
+		}
+		for _, ip := range nodeIPs {
+			if nodeIP := net.ParseIP(ip); nodeIP != nil && ipNet.Contains(nodeIP) {
+				return fmt.Errorf("cilium pool %s overlaps the node address %s", pool.Name, ip)
+			}
+		}
+	}
+	if !names[ciliumDefaultPodIPPool] {
+		return fmt.Errorf("cilium pools require the pool %s of the pods without a pool annotation", ciliumDefaultPodIPPool)
+	}
+	return nil
+}
+
+// PodIPPoolManifest the CiliumPodIPPools of the pools other than the default one and the CiliumNodeConfigs
+// pre-allocating the pools on their selected nodes, empty when there are none.
+func (runnable *CiliumRunnable) PodIPPoolManifest() (string, error) {
+	buf := &bytes.Buffer{}
+	labels := map[string]string{ciliumPodIPPoolLabel: "true"}
+	for _, pool := range runnable.podIPPools() {
+		if pool.Name == ciliumDefaultPodIPPool {
+			continue
+		}
+		objects := []map[string]interface{}{{
+			"apiVersion": "cilium.io/v2alpha1",
+			"kind":       "CiliumPodIPPool",
+			"metadata":   map[string]interface{}{"name": pool.Name, "labels": labels},
+			"spec":       podIPPoolSpec(pool),
+		}}
+		if len(pool.NodeSelector) > 0 {
+			objects = append(objects, map[string]interface{}{
+				"apiVersion": "cilium.io/v2alpha1",
+				"kind":       "CiliumNodeConfig",
+				"metadata":   map[string]interface{}{"name": "pod-ip-pool-" + pool.Name, "namespace": runnable.Namespace, "labels": labels},
+				"spec": map[string]interface{}{
+					"nodeSelector": map[string]interface{}{"matchLabels": pool.NodeSelector},
+					"defaults": map[string]string{
+						"ipam-multi-pool-pre-allocation": fmt.Sprintf("%[1]s=%[3]d,%[2]s=%[3]d",
+							ciliumDefaultPodIPPool, pool.Name, ciliumPodIPPoolPreAllocation),
+					},
+				},
+			})
+		}
+		for _, object := range objects {
+			data, err := yaml.Marshal(object)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(buf, "---\n%s", data)
+		}
+	}
+	return buf.String(), nil
+}
+
+// applyPodIPPools applies PodIPPoolManifest once the cilium operator registered the CRDs.
+func (runnable *CiliumRunnable) applyPodIPPools(nodes []v1.StepNode) (v1.Step, error) {
+	manifest, err := runnable.PodIPPoolManifest()
+	if err != nil {
+		return v1.Step{}, err
+	}
+	return v1.Step{
+		ID:                newStepID(),
+		Name:              "applyCiliumPodIPPools",
+		Timeout:           metav1.Duration{Duration: 3 * time.Minute},
+		ErrIgnore:         false,
+		RetryTimes:        1,
+		Nodes:             nodes,
+		Action:            v1.ActionInstall,
+		BeforeRunCommands: runnable.writeWaitKubeconfig(nodes),
+		Commands:          applyAfterCRDs(nodes, runnable.waitKubeconfig(), manifest, ciliumPodIPPoolCRD, ciliumNodeConfigCRD),
+	}, nil
+}
+
+// deletePodIPPools deletes the generated CiliumPodIPPools and CiliumNodeConfigs before the release is uninstalled.
+func (runnable *CiliumRunnable) deletePodIPPools(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "deleteCiliumPodIPPools",
+		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf("kubectl delete --ignore-not-found %[1]s -l %[3]s; kubectl delete --ignore-not-found %[2]s -n %[4]s -l %[3]s",
+						ciliumPodIPPoolCRD, ciliumNodeConfigCRD, ciliumPodIPPoolLabel, runnable.Namespace)},
+			},
+		},
+	}
+}
@@ -0,0 +1,254 @@
+package cni
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/logger"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/cmdutil"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+)
+
+const (
+	ciliumPreflight = "cilium-preflight"
+
+	// ciliumMinKernel the oldest kernel cilium supports, ciliumMinKernel116 the one of cilium 1.16 and later.
+	ciliumMinKernel    = "4.19.57"
+	ciliumMinKernel116 = "5.4"
+	// ciliumRHELMinKernel the oldest RHEL kernel cilium supports, it backports the eBPF features of newer kernels.
+	ciliumRHELMinKernel = "4.18"
+)
+
+// ciliumKernelConfigs the kernel configs cilium requires, checked when the node has the config of its kernel.
+var ciliumKernelConfigs = []string{"CONFIG_BPF", "CONFIG_BPF_SYSCALL", "CONFIG_NET_CLS_BPF", "CONFIG_NET_SCH_INGRESS"}
+
+func init() {
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-"+ciliumPreflight, version, component.TypeStep), &CiliumPreflight{}); err != nil {
+		panic(err)
+	}
+}
+
+// PreflightFailure a preflight check failed on a node.
+type PreflightFailure struct {
+	Node    string `json:"node"`
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+// PreflightResult the failures of the preflight checks so far, every check step passes it to the next one.
+type PreflightResult struct {
+	Failures []PreflightFailure `json:"failures,omitempty"`
+}
+
+// CiliumPreflight checks the node meets the requirements of cilium, or reports the failures of the nodes when
+// Report is set. The check steps run one node after another like the MTU detection, each of them replies the
+// failures of the previous steps and its own, so that the report step fails once with the failures of every node.
+type CiliumPreflight struct {
+	// Node the name of the node in the failures.
+	Node string `json:"node,omitempty"`
+	// MinKernel the oldest kernel the cilium version supports.
+	MinKernel string `json:"minKernel,omitempty"`
+	Report    bool   `json:"report,omitempty"`
+	// Force reports the failures as warnings.
+	Force bool `json:"force,omitempty"`
+	// root the directory /proc, /sys and /boot are read from, the tests replace it.
+	root string
+}
+
+func (p *CiliumPreflight) NewInstance() component.ObjectMeta {
+	return &CiliumPreflight{}
+}
+
+func (p *CiliumPreflight) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	result := lastPreflight(ctx)
+	if p.Report {
+		return nil, p.report(ctx, result)
+	}
+	if !opts.DryRun {
+		result.Failures = append(result.Failures, p.check()...)
+	}
+	return json.Marshal(result)
+}
+
+func (p *CiliumPreflight) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	return nil, nil
+}
+
+// check runs the checks on the node.
+func (p *CiliumPreflight) check() []PreflightFailure {
+	var failures []PreflightFailure
+	fail := func(check, format string, args ...interface{}) {
+		failures = append(failures, PreflightFailure{Node: p.Node, Check: check, Message: fmt.Sprintf(format, args...)})
+	}
+	release := strings.TrimSpace(p.readFile("proc/sys/kernel/osrelease"))
+	if msg := checkKernel(release, p.MinKernel); msg != "" {
+		fail("kernel", msg)
+	}
+	filesystems := p.readFile("proc/filesystems")
+	if p.mountType("/sys/fs/cgroup") != "cgroup2" && !hasFilesystem(filesystems, "cgroup2") {
+		fail("cgroup", "/sys/fs/cgroup is not cgroup2 and the kernel does not support cgroup2")
+	}
+	if !hasFilesystem(filesystems, "bpf") {
+		fail("bpf", "the kernel does not support the bpf filesystem")
+	}
+	if config := p.readFile(filepath.Join("boot", "config-"+release)); release != "" && config != "" {
+		for _, name := range ciliumKernelConfigs {
+			if !strings.Contains(config, "\n"+name+"=y") && !strings.Contains(config, "\n"+name+"=m") {
+				fail("kernel config", "%s is not enabled", name)
+			}
+		}
+	}
+	return failures
+}
+
+// report fails with the failures of the nodes unless Force, the forced failures are written to the step log.
+func (p *CiliumPreflight) report(ctx context.Context, result PreflightResult) error {
+	if len(result.Failures) == 0 {
+		return nil
+	}
+	lines := []string{"cilium preflight checks failed:"}
+	for _, f := range result.Failures {
+		lines = append(lines, fmt.Sprintf("  %s: %s: %s", f.Node, f.Check, f.Message))
+	}
+	msg := strings.Join(lines, "\n")
+	if !p.Force {
+		return fmt.Errorf("%s", msg)
+	}
+	logger.Warnf("ignore the failures of the forced preflight: %s", msg)
+	if ok, err := cmdutil.CheckContextAndAppendStepLogFile(ctx, []byte("WARNING: "+msg+"\n")); ok && err != nil {
+		logger.Warnf("write the preflight warnings to the step log: %v", err)
+	}
+	return nil
+}
+
+func (p *CiliumPreflight) readFile(name string) string {
+	data, err := os.ReadFile(filepath.Join(strutil.StringDefaultIfEmpty("/", p.root), name))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// mountType the filesystem type mounted at mountPoint, empty when nothing is.
+func (p *CiliumPreflight) mountType(mountPoint string) string {
+	var fsType string
+	scanner := bufio.NewScanner(strings.NewReader(p.readFile("proc/mounts")))
+	for scanner.Scan() {
+		// the last mount at the mount point hides the others
+		if fields := strings.Fields(scanner.Text()); len(fields) >= 3 && fields[1] == mountPoint {
+			fsType = fields[2]
+		}
+	}
+	return fsType
+}
+
+// hasFilesystem reports whether the /proc/filesystems content lists fsType.
+func hasFilesystem(filesystems, fsType string) bool {
+	for _, line := range strings.Split(filesystems, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[len(fields)-1] == fsType {
+			return true
+		}
+	}
+	return false
+}
+
+// checkKernel returns why the kernel release is older than min, empty when it is not. The RHEL kernels are
+// accepted from ciliumRHELMinKernel.
+func checkKernel(release, min string) string {
+	if release == "" {
+		return "the kernel release is unknown"
+	}
+	v, err := utilversion.ParseGeneric(release)
+	if err != nil {
+		return fmt.Sprintf("invalid kernel release %s", release)
+	}
+	if !v.LessThan(utilversion.MustParseGeneric(min)) {
+		return ""
+	}
+	if strings.Contains(release, ".el") && !v.LessThan(utilversion.MustParseGeneric(ciliumRHELMinKernel)) {
+		return ""
+	}
+	return fmt.Sprintf("kernel %s is older than %s", release, min)
+}
+
+// lastPreflight returns the failures replied by the previous check step, none when the previous step is not one.
+func lastPreflight(ctx context.Context) PreflightResult {
+	result := PreflightResult{}
+	if data := component.GetExtraData(ctx); data != nil {
+		_ = json.Unmarshal(data, &result)
+	}
+	return result
+}
+
+// minKernel the oldest kernel the cilium version supports.
+func (runnable *CiliumRunnable) minKernel() string {
+	if v, err := parseMinor(runnable.Version); err == nil && !v.LessThan(utilversion.MustParseGeneric("1.16")) {
+		return ciliumMinKernel116
+	}
+	return ciliumMinKernel
+}
+
+// preflightSteps checks the kernel, cgroup2 and the bpf filesystem of the nodes running the cilium agent one
+// after another, the checks run on each node, and reports the failures of every node at once on the first of nodes.
+func (runnable *CiliumRunnable) preflightSteps(nodes []v1.StepNode) (checks, report []v1.Step, err error) {
+	checkNodes := runnable.nodeStates.available(runnable.allNodes)
+	if len(checkNodes) == 0 {
+		checkNodes = nodes
+	}
+	force := runnable.CiliumConfig != nil && runnable.CiliumConfig.ForcePreflight
+	agents := runnable.agentNodes(checkNodes)
+	if len(agents) == 0 || len(nodes) == 0 {
+		return nil, nil, nil
+	}
+	for _, node := range agents {
+		name := strutil.StringDefaultIfEmpty(node.ID, node.Hostname)
+		custom, err := json.Marshal(&CiliumPreflight{Node: name, MinKernel: runnable.minKernel()})
+		if err != nil {
+			return nil, nil, err
+		}
+		checks = append(checks, preflightStep("preflightCilium-"+name, custom, []v1.StepNode{node}))
+	}
+	custom, err := json.Marshal(&CiliumPreflight{Report: true, Force: force})
+	if err != nil {
+		return nil, nil, err
+	}
+	step := preflightStep("reportCiliumPreflight", custom, nodes[:1])
+	step.ErrorMatchers = []v1.StepErrorMatcher{{
+		Category: v1.StepErrorValidationFailed,
+		Pattern:  "cilium preflight checks failed",
+		Hint:     "upgrade the kernels of the failed nodes, or set forcePreflight to install cilium anyway",
+	}}
+	return checks, []v1.Step{step}, nil
+}
+
+func preflightStep(name string, custom []byte, nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:             newStepID(),
+		Name:           name,
+		Timeout:        metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:      false,
+		RetryTimes:     0,
+		RetryPolicySet: true,
+		Nodes:          nodes,
+		Action:         v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:          v1.CommandCustom,
+				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+ciliumPreflight, version, component.TypeStep),
+				CustomCommand: custom,
+			},
+		},
+	}
+}
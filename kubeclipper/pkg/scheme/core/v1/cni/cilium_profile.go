@@ -0,0 +1,57 @@
+package cni
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	CiliumProfileMinimal    = "minimal"
+	CiliumProfileDefault    = "default"
+	CiliumProfileProduction = "production"
+)
+
+// ciliumProfiles the base values of the profiles, profiles/cilium-<profile>.yaml.
+//
+//go:embed profiles/cilium-*.yaml
+var ciliumProfiles embed.FS
+
+// Profile the values profile of cilium, CiliumProfileDefault when it is not set.
+func (runnable *CiliumRunnable) Profile() string {
+	if runnable.CiliumConfig == nil || runnable.CiliumConfig.Profile == "" {
+		return CiliumProfileDefault
+	}
+	return runnable.CiliumConfig.Profile
+}
+
+// Profiles the values profiles cilium renders, sorted by name.
+func (runnable *CiliumRunnable) Profiles() []string {
+	files, _ := ciliumProfiles.ReadDir("profiles")
+	profiles := make([]string, 0, len(files))
+	for _, f := range files {
+		profiles = append(profiles, strings.TrimSuffix(strings.TrimPrefix(f.Name(), "cilium-"), ".yaml"))
+	}
+	sort.Strings(profiles)
+	return profiles
+}
+
+// ciliumProfileValues the base values of profile, empty when the profile sets no values.
+func ciliumProfileValues(profile string) (string, error) {
+	data, err := ciliumProfiles.ReadFile(path.Join("profiles", "cilium-"+profile+".yaml"))
+	if err != nil {
+		return "", fmt.Errorf("unknown cilium profile %q", profile)
+	}
+	values := make(map[string]interface{})
+	if err = yaml.Unmarshal(data, &values); err != nil {
+		return "", fmt.Errorf("parse the cilium profile %s: %w", profile, err)
+	}
+	if len(values) == 0 {
+		return "", nil
+	}
+	return string(data), nil
+}
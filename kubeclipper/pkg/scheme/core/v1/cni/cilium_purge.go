@@ -0,0 +1,87 @@
+package cni
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component/common"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OperationPurge deletes the cilium CRDs and custom resources left behind by the uninstalled release.
+const OperationPurge = "purge"
+
+// ciliumFinalizedResources the custom resources whose finalizers only the cilium operator removes, they are
+// deleted before the CRDs, which otherwise wait for them forever once the operator is gone.
+var ciliumFinalizedResources = []string{"ciliumendpoints.cilium.io", "ciliumnodes.cilium.io"}
+
+const (
+	// ciliumPurgeWait bounds the waits of the purge deletions, the purge operation waits ciliumPurgeOperationWait
+	// to finish within the operation timeout.
+	ciliumPurgeWait          = time.Minute
+	ciliumPurgeOperationWait = 15 * time.Second
+	// ciliumCRDs lists the names of the cilium CRDs.
+	ciliumCRDs = `kubectl get crd -o name | grep '\.cilium\.io$'`
+)
+
+// purgeObjectsCommand deletes the finalized custom resources, the finalizers of the ones still there after
+// wait are removed.
+func purgeObjectsCommand(wait time.Duration) string {
+	return fmt.Sprintf(`for r in %s; do
+  kubectl get crd "$r" >/dev/null 2>&1 || continue
+  kubectl delete "$r" --all -A --ignore-not-found --timeout=%s && continue
+  kubectl get "$r" -A --no-headers -o custom-columns=NS:.metadata.namespace,NAME:.metadata.name | while read -r ns name; do
+    [ "$ns" = "<none>" ] && ns=""
+    kubectl patch "$r" "$name" ${ns:+-n "$ns"} --type=merge -p '{"metadata":{"finalizers":null}}'
+  done
+done`, strings.Join(ciliumFinalizedResources, " "), wait)
+}
+
+// purgeCRDsCommand deletes the cilium CRDs and with them the remaining custom resources.
+func purgeCRDsCommand(wait time.Duration) string {
+	return fmt.Sprintf(`crds=$(%s); [ -z "$crds" ] || kubectl delete $crds --ignore-not-found --timeout=%s`, ciliumCRDs, wait)
+}
+
+// purgeSteps deletes the cilium CRDs and custom resources after the release is uninstalled, the CRDs of an
+// older cilium or their stale finalizers break a later install of another cilium version or cni. Only the
+// deletions of the objects may fail, the CRDs must be gone.
+func (runnable *CiliumRunnable) purgeSteps(nodes []v1.StepNode) []v1.Step {
+	return []v1.Step{
+		{
+			ID:         newStepID(),
+			Name:       "deleteCiliumObjects",
+			Timeout:    metav1.Duration{Duration: 3 * ciliumPurgeWait},
+			ErrIgnore:  true,
+			RetryTimes: 1,
+			Nodes:      nodes,
+			Action:     v1.ActionUninstall,
+			Commands:   []v1.Command{{Type: v1.CommandShell, ShellCommand: []string{"/bin/bash", "-c", purgeObjectsCommand(ciliumPurgeWait)}}},
+		},
+		{
+			ID:         newStepID(),
+			Name:       "purgeCiliumCRDs",
+			Timeout:    metav1.Duration{Duration: 3 * ciliumPurgeWait},
+			ErrIgnore:  false,
+			RetryTimes: 1,
+			Nodes:      nodes,
+			Action:     v1.ActionUninstall,
+			Commands:   []v1.Command{{Type: v1.CommandShell, ShellCommand: []string{"/bin/bash", "-c", purgeCRDsCommand(2 * ciliumPurgeWait)}}},
+		},
+	}
+}
+
+// purgeOperation purges the cilium CRDs of a cluster whose release was uninstalled without Purge, it refuses
+// to run while the release is installed.
+func (runnable *CiliumRunnable) purgeOperation(namespace string) Operation {
+	return Operation{
+		Name:        OperationPurge,
+		Description: "Delete the cilium CRDs and custom resources left behind by the uninstalled cilium release.",
+		Command: fmt.Sprintf(`if %s status %s -n %s >/dev/null 2>&1; then echo "the cilium release is installed, uninstall it before purging" >&2; exit 1; fi
+%s
+%s`, common.HelmBin, runnable.ReleaseName(), namespace, purgeObjectsCommand(ciliumPurgeOperationWait), purgeCRDsCommand(2*ciliumPurgeOperationWait)),
+		Destructive: true,
+		Output:      OperationOutputText,
+	}
+}
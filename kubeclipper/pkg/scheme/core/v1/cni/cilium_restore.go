@@ -0,0 +1,183 @@
+package cni
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/cmdutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const ciliumStaleNodes = "cilium-stale-nodes"
+
+func init() {
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-"+ciliumStaleNodes, version, component.TypeStep), &CiliumStaleNodes{}); err != nil {
+		panic(err)
+	}
+}
+
+// ciliumRestoreCRDs the file the pre-restore step captures the cilium CRDs in, in the order they were created.
+const ciliumRestoreCRDs = "cilium-crds.txt"
+
+// CiliumStaleNodes deletes the CiliumNodes of the nodes not in the cluster, a backup restored onto other nodes
+// brings back the CiliumNodes of the nodes it was taken on and their pod CIDRs are never released.
+type CiliumStaleNodes struct {
+	// Nodes the names of the kubernetes nodes of the cluster.
+	Nodes []string `json:"nodes"`
+}
+
+func (s *CiliumStaleNodes) NewInstance() component.ObjectMeta {
+	return &CiliumStaleNodes{}
+}
+
+func (s *CiliumStaleNodes) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	ec, err := cmdutil.RunCmdWithContext(ctx, opts.DryRun, "kubectl", "get", "ciliumnodes", "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		return nil, nil
+	}
+	stale, err := staleCiliumNodes([]byte(ec.StdOut()), s.Nodes)
+	if err != nil {
+		return nil, err
+	}
+	if len(stale) == 0 {
+		return nil, nil
+	}
+	_, err = cmdutil.RunCmdWithContext(ctx, opts.DryRun, "kubectl", append([]string{"delete", "ciliumnode", "--ignore-not-found"}, stale...)...)
+	return nil, err
+}
+
+func (s *CiliumStaleNodes) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	return nil, nil
+}
+
+// staleCiliumNodes returns the sorted names of the CiliumNodes of list, the output of kubectl get ciliumnodes -o json,
+// whose nodes are not one of nodes.
+func staleCiliumNodes(list []byte, nodes []string) ([]string, error) {
+	var ciliumNodes struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(list, &ciliumNodes); err != nil {
+		return nil, fmt.Errorf("parse the CiliumNodes: %w", err)
+	}
+	current := sets.NewString(nodes...)
+	var stale []string
+	for _, item := range ciliumNodes.Items {
+		if !current.Has(item.Metadata.Name) {
+			stale = append(stale, item.Metadata.Name)
+		}
+	}
+	sort.Strings(stale)
+	return stale, nil
+}
+
+// PreRestoreSteps captures the cilium CRDs of the cluster in the order they were created before the backup is
+// restored, the post-restore steps wait for them in that order.
+func (runnable *CiliumRunnable) PreRestoreSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	master, ok := runnable.clusterScopedNodes(nodes)
+	if !ok {
+		return nil, nil
+	}
+	file := runnable.manifestPath(ciliumRestoreCRDs)
+	return []v1.Step{
+		{
+			ID:         newStepID(),
+			Name:       "captureCiliumCRDs",
+			Timeout:    metav1.Duration{Duration: time.Minute},
+			ErrIgnore:  false,
+			RetryTimes: 1,
+			Nodes:      master,
+			Action:     v1.ActionInstall,
+			Commands: []v1.Command{
+				{
+					Type: v1.CommandShell,
+					ShellCommand: []string{"/bin/bash", "-c", fmt.Sprintf(`mkdir -p %s && { kubectl get crd --sort-by=.metadata.creationTimestamp -o name | grep '\.cilium\.io$' || true; } > %s`,
+						runnable.manifestPath(""), file)},
+				},
+			},
+		},
+	}, nil
+}
+
+// PostRestoreSteps brings cilium in line with the nodes of the restored cluster: the captured CRDs are waited for,
+// the readiness check runs again, the CiliumNodes of the nodes the backup was taken on are deleted and the
+// operator is restarted to allocate the pod CIDRs of the current nodes.
+func (runnable *CiliumRunnable) PostRestoreSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	master, ok := runnable.clusterScopedNodes(nodes)
+	if !ok {
+		return nil, nil
+	}
+	names := make([]string, 0, len(runnable.allNodes))
+	for _, node := range runnable.allNodes {
+		names = append(names, node.Hostname)
+	}
+	custom, err := json.Marshal(&CiliumStaleNodes{Nodes: names})
+	if err != nil {
+		return nil, err
+	}
+	file := runnable.manifestPath(ciliumRestoreCRDs)
+	return []v1.Step{
+		{
+			ID:         newStepID(),
+			Name:       "waitCiliumCRDs",
+			Timeout:    metav1.Duration{Duration: 5 * time.Minute},
+			ErrIgnore:  false,
+			RetryTimes: 1,
+			Nodes:      master,
+			Action:     v1.ActionInstall,
+			Commands: []v1.Command{
+				{
+					Type: v1.CommandShell,
+					// the file is missing when the pre-restore step did not run, e.g. for a restore started before it was added
+					ShellCommand: []string{"/bin/bash", "-c", fmt.Sprintf(`[ -f %[1]s ] || exit 0; while read -r crd; do kubectl wait --for condition=established --timeout=60s "$crd" || exit 1; done < %[1]s`, file)},
+				},
+			},
+		},
+		runnable.checkReady(master),
+		{
+			ID:         newStepID(),
+			Name:       "deleteStaleCiliumNodes",
+			Timeout:    metav1.Duration{Duration: 2 * time.Minute},
+			ErrIgnore:  false,
+			RetryTimes: 1,
+			Nodes:      master,
+			Action:     v1.ActionInstall,
+			Commands: []v1.Command{
+				{
+					Type:          v1.CommandCustom,
+					Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+ciliumStaleNodes, version, component.TypeStep),
+					CustomCommand: custom,
+				},
+			},
+		},
+		{
+			ID:         newStepID(),
+			Name:       "restartCiliumOperator",
+			Timeout:    metav1.Duration{Duration: runnable.readinessTimeout() + time.Minute},
+			ErrIgnore:  false,
+			RetryTimes: 1,
+			Nodes:      master,
+			Action:     v1.ActionInstall,
+			Commands: []v1.Command{
+				{
+					Type: v1.CommandShell,
+					ShellCommand: []string{"/bin/bash", "-c", fmt.Sprintf(`kubectl rollout restart deploy/cilium-operator -n %[1]s && kubectl rollout status deploy/cilium-operator -n %[1]s --timeout %[2]s`,
+						runnable.Namespace, runnable.readinessTimeout())},
+				},
+			},
+		},
+	}, nil
+}
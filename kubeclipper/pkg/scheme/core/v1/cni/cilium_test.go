@@ -0,0 +1,3483 @@
+package cni
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/component/common"
+	"github.com/kubeclipper/kubeclipper/pkg/component/utils"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/sysutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+)
+
+func TestCNI_renderCiliumTo(t *testing.T) {
+	tests := []struct {
+		name     string
+		stepper  CiliumRunnable
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "default",
+			stepper:  CiliumRunnable{},
+			contains: []string{`mode: "cluster-pool"`, "clusterPoolIPv4PodCIDRList:\n      - 192.168.64.0/18\n"},
+			excludes: []string{"hubble:"},
+		},
+		{
+			name: "hubble with ui",
+			stepper: CiliumRunnable{
+				CiliumConfig: &v1.Cilium{
+					ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"},
+					ClusterPoolIPv4MaskSize:    24,
+					OperatorReplicas:           1,
+					EnableHubble:               true,
+					EnableHubbleUI:             true,
+				},
+			},
+			contains: []string{"hubble:\n  enabled: true\n  relay:\n    enabled: true\n  ui:\n    enabled: true"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &bytes.Buffer{}
+			if err := tt.stepper.renderCiliumTo(w); err != nil {
+				t.Errorf("renderCiliumTo() error = %v", err)
+				return
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(w.String(), c) {
+					t.Errorf("renderCiliumTo() output does not contain %q:\n%s", c, w.String())
+				}
+			}
+			for _, c := range tt.excludes {
+				if strings.Contains(w.String(), c) {
+					t.Errorf("renderCiliumTo() output should not contain %q:\n%s", c, w.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_Validate(t *testing.T) {
+	tests := []struct {
+		name          string
+		version       string
+		config        *v1.Cilium
+		kubeProxyMode string
+		wantErr       bool
+	}{
+		{name: "nil config"},
+		{name: "empty mode", config: &v1.Cilium{}},
+		{name: "strict", config: &v1.Cilium{KubeProxyReplacement: "strict"}, kubeProxyMode: "ipvs"},
+		{name: "strict on 1.13", version: "1.13.4", config: &v1.Cilium{KubeProxyReplacement: "strict"}},
+		{name: "true on 1.13", version: "1.13.4", config: &v1.Cilium{KubeProxyReplacement: "true"}},
+		{name: "strict on 1.14", version: "1.14.4", config: &v1.Cilium{KubeProxyReplacement: "strict"}},
+		{name: "true on 1.14", version: "1.14.4", config: &v1.Cilium{KubeProxyReplacement: "true"}},
+		{name: "strict on 1.15", version: "1.15.1", config: &v1.Cilium{KubeProxyReplacement: "strict"}},
+		{name: "false on 1.16", version: "v1.16.1", config: &v1.Cilium{KubeProxyReplacement: "false"}},
+		{name: "typo", config: &v1.Cilium{KubeProxyReplacement: "ture"}, wantErr: true},
+		{name: "ebpf without replacement", config: &v1.Cilium{KubeProxyReplacement: "false"}, kubeProxyMode: "ebpf", wantErr: true},
+		{name: "ebpf with replacement", config: &v1.Cilium{KubeProxyReplacement: "true"}, kubeProxyMode: "ebpf"},
+		{name: "production profile", config: &v1.Cilium{Profile: CiliumProfileProduction}},
+		{name: "unknown profile", config: &v1.Cilium{Profile: "large"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{KubeProxyMode: tt.kubeProxyMode},
+				&v1.CNI{Version: tt.version, Cilium: tt.config}, &v1.Networking{})
+			if err := stepper.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_KubeProxyReplacementValue(t *testing.T) {
+	tests := []struct {
+		version string
+		mode    v1.CiliumKubeProxyReplacement
+		want    string
+	}{
+		{version: "1.13.4", want: `"disabled"`},
+		{version: "1.13.4", mode: "strict", want: `"strict"`},
+		{version: "1.13.4", mode: "true", want: `"strict"`},
+		{version: "1.13.4", mode: "false", want: `"disabled"`},
+		{version: "1.14.4", mode: "strict", want: `"strict"`},
+		{version: "1.14.4", mode: "true", want: `"true"`},
+		{version: "1.15.1", mode: "strict", want: "true"},
+		{version: "1.15.1", mode: "partial", want: "false"},
+		{version: "1.15.1", mode: "disabled", want: "false"},
+		{version: "1.16.1", mode: "true", want: "true"},
+		{version: "1.16.1", want: "false"},
+	}
+	for _, tt := range tests {
+		stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{},
+			&v1.CNI{Version: tt.version, Cilium: &v1.Cilium{KubeProxyReplacement: tt.mode}}, &v1.Networking{}).(*CiliumRunnable)
+		if err := stepper.Validate(); err != nil {
+			t.Fatalf("Validate() of %s on %s error = %v", tt.mode, tt.version, err)
+		}
+		if got := stepper.KubeProxyReplacementValue(); got != tt.want {
+			t.Errorf("KubeProxyReplacementValue() of %s on %s = %s, want %s", tt.mode, tt.version, got, tt.want)
+		}
+	}
+
+	// the specs stored with the helm value keep working
+	for data, want := range map[string]v1.CiliumKubeProxyReplacement{
+		`{"kubeProxyReplacement":true}`:     "true",
+		`{"kubeProxyReplacement":false}`:    "false",
+		`{"kubeProxyReplacement":"strict"}`: "strict",
+		`{}`:                                "",
+	} {
+		var config v1.Cilium
+		if err := json.Unmarshal([]byte(data), &config); err != nil || config.KubeProxyReplacement != want {
+			t.Errorf("unmarshal %s = %q, %v, want %q", data, config.KubeProxyReplacement, err, want)
+		}
+	}
+	var config v1.Cilium
+	if err := json.Unmarshal([]byte(`{"kubeProxyReplacement":1}`), &config); err == nil {
+		t.Errorf("unmarshal a number kubeProxyReplacement succeeded")
+	}
+}
+
+func TestCiliumRunnable_InstallStepsStrict(t *testing.T) {
+	metadata := &component.ExtraMetadata{KubeProxyMode: "ipvs", Masters: component.NodeList{{ID: "node1"}}, Workers: component.NodeList{{ID: "node2"}}}
+	stepper := (&CiliumRunnable{}).InitStep(metadata,
+		&v1.CNI{Version: "1.14.4", Cilium: &v1.Cilium{KubeProxyReplacement: "strict"}}, &v1.Networking{DNSDomain: "cluster.local"})
+	steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	if got := stepNames(steps[len(steps)-3:]); !reflect.DeepEqual(got, []string{"removeKubeProxy", "cleanKubeProxyRules", "checkCiliumReady"}) {
+		t.Errorf("last steps = %v, want [removeKubeProxy cleanKubeProxyRules checkCiliumReady]", got)
+	}
+	if clean := stepByName(steps, "cleanKubeProxyRules"); len(clean.Nodes) != 2 {
+		t.Errorf("cleanKubeProxyRules nodes = %v, want all cluster nodes", clean.Nodes)
+	}
+	if (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Cilium: &v1.Cilium{KubeProxyReplacement: "true"}}, nil).(*CiliumRunnable).K8sServiceHost != "apiserver.cluster.local" {
+		t.Errorf("InitStep() with nil networking should default the apiserver domain")
+	}
+	w := &bytes.Buffer{}
+	if err = stepper.(*CiliumRunnable).renderCiliumTo(w); err != nil {
+		t.Fatalf("renderCiliumTo() error = %v", err)
+	}
+	if !strings.Contains(w.String(), "k8sServiceHost: apiserver.cluster.local\nk8sServicePort: 6443") {
+		t.Errorf("renderCiliumTo() output does not contain k8sService settings:\n%s", w.String())
+	}
+}
+
+func TestCiliumRunnable_checkReady(t *testing.T) {
+	tests := []struct {
+		name        string
+		cilium      *v1.Cilium
+		wantTimeout string
+		wantSkip    bool
+	}{
+		{name: "default", wantTimeout: "--timeout 5m0s"},
+		{name: "custom timeout", cilium: &v1.Cilium{ReadinessCheckTimeout: &metav1.Duration{Duration: 10 * time.Minute}}, wantTimeout: "--timeout 10m0s"},
+		{name: "skip", cilium: &v1.Cilium{SkipReadinessCheck: true}, wantSkip: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.14.4", Cilium: tt.cilium}, &v1.Networking{})
+			steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "v1.27.4")
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			last := steps[len(steps)-1]
+			if tt.wantSkip {
+				if last.Name == "checkCiliumReady" {
+					t.Errorf("InstallSteps() should skip checkCiliumReady")
+				}
+				return
+			}
+			if last.Name != "checkCiliumReady" {
+				t.Fatalf("last step = %s, want checkCiliumReady", last.Name)
+			}
+			cmd := strings.Join(last.Commands[0].ShellCommand, " ")
+			if !strings.Contains(cmd, "ds/cilium -n kube-system "+tt.wantTimeout) ||
+				!strings.Contains(cmd, "deploy/cilium-operator -n kube-system "+tt.wantTimeout) {
+				t.Errorf("checkCiliumReady command = %s, want rollout status with %s", cmd, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+var update = flag.Bool("update", false, "update golden files")
+
+// assertGolden compares got with testdata/<name>.golden, the golden file is rewritten when -update is set.
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	golden := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatalf("update golden file %s failed: %v", golden, err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("read golden file %s failed: %v", golden, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch, got:\n%s\nwant:\n%s", golden, got, want)
+	}
+}
+
+func TestCiliumRunnable_renderIPv6(t *testing.T) {
+	tests := []struct {
+		name       string
+		cilium     *v1.Cilium
+		networking v1.Networking
+	}{
+		{
+			name: "cilium-dual-stack",
+			cilium: &v1.Cilium{
+				ClusterPoolIPv4PodCIDRList: []string{"172.25.0.0/16"},
+				ClusterPoolIPv4MaskSize:    24,
+				OperatorReplicas:           1,
+			},
+			networking: v1.Networking{
+				IPFamily: v1.IPFamilyDualStack,
+				Pods:     v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16", "fd00:10:244::/56"}},
+			},
+		},
+		{
+			name: "cilium-ipv6-only",
+			networking: v1.Networking{
+				Pods: v1.NetworkRanges{CIDRBlocks: []string{"fd00:10:244::/56"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Cilium: tt.cilium}, &tt.networking)
+			w := &bytes.Buffer{}
+			if err := stepper.(*CiliumRunnable).renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			assertGolden(t, tt.name, w.Bytes())
+		})
+	}
+}
+
+func stepNames(steps []v1.Step) []string {
+	var names []string
+	for _, s := range steps {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func stepByName(steps []v1.Step, name string) v1.Step {
+	for _, step := range steps {
+		if step.Name == name {
+			return step
+		}
+	}
+	return v1.Step{}
+}
+
+// withoutPreflight the steps after the preflight checks.
+func withoutPreflight(steps []v1.Step) []v1.Step {
+	for i, step := range steps {
+		if step.Name == "reportCiliumPreflight" {
+			return steps[i+1:]
+		}
+	}
+	return steps
+}
+
+func TestCiliumRunnable_UninstallStepsScope(t *testing.T) {
+	metadata := &component.ExtraMetadata{
+		Masters: component.NodeList{{ID: "master1"}, {ID: "master2"}},
+		Workers: component.NodeList{{ID: "worker1"}},
+	}
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Cilium: &v1.Cilium{
+		EnableHubble: true, Encryption: &v1.CiliumEncryption{Type: CiliumEncryptionIPsec},
+	}}, &v1.Networking{})
+	all := []v1.StepNode{{ID: "master1"}, {ID: "master2"}, {ID: "worker1"}}
+	steps, err := stepper.UninstallSteps(all)
+	if err != nil {
+		t.Fatalf("UninstallSteps() error = %v", err)
+	}
+	want := []string{"uninstallCiliumRelease", "removeHubbleSecrets", "removeCiliumIPsecKeys", "removeCniManifests", "clearCiliumNode", "removeCiliumCLI"}
+	if got := stepNames(steps); !reflect.DeepEqual(got, want) {
+		t.Fatalf("UninstallSteps() = %v, want %v", got, want)
+	}
+	for _, step := range steps[:4] {
+		if !reflect.DeepEqual(step.Nodes, []v1.StepNode{{ID: "master1"}}) {
+			t.Errorf("%s nodes = %v, want the first master only", step.Name, step.Nodes)
+		}
+	}
+	if !reflect.DeepEqual(steps[4].Nodes, all) {
+		t.Errorf("clearCiliumNode nodes = %v, want %v", steps[4].Nodes, all)
+	}
+	if masters := all[:2]; !reflect.DeepEqual(steps[5].Nodes, masters) {
+		t.Errorf("removeCiliumCLI nodes = %v, want %v", steps[5].Nodes, masters)
+	}
+
+	steps, err = stepper.UninstallSteps([]v1.StepNode{{ID: "worker1"}})
+	if err != nil {
+		t.Fatalf("UninstallSteps() error = %v", err)
+	}
+	if got := stepNames(steps); !reflect.DeepEqual(got, []string{"clearCiliumNode"}) {
+		t.Errorf("UninstallSteps() removing a node = %v, want [clearCiliumNode]", got)
+	}
+}
+
+func TestCiliumRunnable_UninstallRemoveHelm(t *testing.T) {
+	metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "master1"}}}
+	nodes := []v1.StepNode{{ID: "master1"}}
+	for _, remove := range []bool{false, true} {
+		steps, err := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{RemoveHelm: remove, Cilium: &v1.Cilium{}}, &v1.Networking{}).UninstallSteps(nodes)
+		if err != nil {
+			t.Fatalf("UninstallSteps() error = %v", err)
+		}
+		helm := stepByName(steps, "removeHelm")
+		if (helm.Name != "") != remove {
+			t.Fatalf("UninstallSteps() = %v, want removeHelm %v", stepNames(steps), remove)
+		}
+		if remove && (!helm.ErrIgnore || helm.Action != v1.ActionUninstall) {
+			t.Errorf("removeHelm = %+v, want an ignored uninstall step", helm)
+		}
+	}
+}
+
+func TestCiliumRunnable_HelmSDK(t *testing.T) {
+	for _, sdk := range []bool{false, true} {
+		metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "master1", HelmSDK: sdk}}}
+		nodes := []v1.StepNode{{ID: "master1", HelmSDK: sdk}}
+		runnable := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Cilium: &v1.Cilium{}}, &v1.Networking{})
+		install := InstallCiliumRelease("cilium", "chart.tgz", "values.yaml", "kube-system", nodes, HelmReleaseOptions{Timeout: 5 * time.Minute})
+		steps, err := runnable.UninstallSteps(nodes)
+		if err != nil {
+			t.Fatalf("UninstallSteps() error = %v", err)
+		}
+		uninstall := stepByName(steps, "uninstallCiliumRelease")
+		for _, step := range []v1.Step{install, uninstall} {
+			if got := step.Commands[0].Type == v1.CommandHelm; got != sdk {
+				t.Errorf("%s commands = %+v, want the helm command %v", step.Name, step.Commands, sdk)
+			}
+		}
+		for _, cmd := range append(install.Commands, install.CancelCommands...) {
+			if !reflect.DeepEqual(cmd.Env, helmEnv("")) {
+				t.Errorf("installCiliumRelease command env = %v, want the helm homes and kubeconfig pinned", cmd.Env)
+			}
+		}
+		if !sdk {
+			continue
+		}
+		if h := install.Commands[0].Helm; h.Release != "cilium" || h.Namespace != "kube-system" || h.Timeout.Duration != 5*time.Minute {
+			t.Errorf("installCiliumRelease helm = %+v", h)
+		}
+		if len(install.CancelCommands) != 1 || len(install.ErrorMatchers) == 0 || install.Timeout.Duration != 6*time.Minute {
+			t.Errorf("installCiliumRelease = %+v, want the cancel commands, error matchers and timeout of the shell step", install)
+		}
+		if h := uninstall.Commands[0].Helm; h.Action != v1.HelmActionUninstall || h.Release != "cilium" || h.Namespace != "kube-system" {
+			t.Errorf("uninstallCiliumRelease helm = %+v", h)
+		}
+	}
+}
+
+func TestCiliumRunnable_manifestDir(t *testing.T) {
+	metadata := &component.ExtraMetadata{ClusterName: "c1", Masters: component.NodeList{{ID: "master1"}}}
+	nodes := []v1.StepNode{{ID: "master1"}}
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", Cilium: &v1.Cilium{}}, &v1.Networking{}).(*CiliumRunnable)
+	values := "/tmp/.cni/c1/cilium/cilium.yaml"
+	steps, err := stepper.InstallSteps(nodes, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	if install := stepByName(steps, "installCiliumRelease"); !strings.Contains(fmt.Sprint(install.Commands), values) {
+		t.Errorf("installCiliumRelease commands = %v, want the values %s", install.Commands, values)
+	}
+	steps, err = stepper.UninstallSteps(nodes)
+	if err != nil {
+		t.Fatalf("UninstallSteps() error = %v", err)
+	}
+	want := []string{"rm", "-rf", "/tmp/.cni/cilium.yaml", "/tmp/.cni/c1/cilium"}
+	if got := stepByName(steps, "removeCniManifests").Commands[0].ShellCommand; !reflect.DeepEqual(got, want) {
+		t.Errorf("removeCniManifests command = %v, want %v", got, want)
+	}
+
+	// the steps of the operations created before the cluster directories render and install the shared values
+	data, _ := json.Marshal(stepper)
+	legacy := &CiliumRunnable{}
+	if err = json.Unmarshal(bytes.Replace(data, []byte(`"manifestDir":"/tmp/.cni/c1/cilium",`), nil, 1), legacy); err != nil {
+		t.Fatal(err)
+	}
+	if got := legacy.manifestPath("cilium.yaml"); got != "/tmp/.cni/cilium.yaml" {
+		t.Errorf("manifestPath() without ManifestDir = %s, want the shared values", got)
+	}
+
+	stepper.ManifestDir = filepath.Join(t.TempDir(), "c1", "cilium")
+	if err = stepper.Render(context.TODO(), component.Options{}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(stepper.ManifestDir, "cilium.yaml")); err != nil {
+		t.Errorf("Render() did not write the values to the cluster directory: %v", err)
+	}
+}
+
+func TestCiliumRunnable_UninstallPurge(t *testing.T) {
+	metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "master1"}}}
+	nodes := []v1.StepNode{{ID: "master1"}}
+	steps, err := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Cilium: &v1.Cilium{}}, &v1.Networking{}).UninstallSteps(nodes)
+	if err != nil {
+		t.Fatalf("UninstallSteps() error = %v", err)
+	}
+	if step := stepByName(steps, "purgeCiliumCRDs"); step.Name != "" {
+		t.Errorf("UninstallSteps() = %v, the CRDs are kept by default", stepNames(steps))
+	}
+
+	steps, err = (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Purge: true, Cilium: &v1.Cilium{}}, &v1.Networking{}).UninstallSteps(nodes)
+	if err != nil {
+		t.Fatalf("UninstallSteps() error = %v", err)
+	}
+	names := strings.Join(stepNames(steps), ",")
+	if !strings.HasPrefix(names, "uninstallCiliumRelease,deleteCiliumObjects,purgeCiliumCRDs,") {
+		t.Fatalf("UninstallSteps() = %s, want the objects and then the CRDs deleted after the release", names)
+	}
+	objects, crds := stepByName(steps, "deleteCiliumObjects"), stepByName(steps, "purgeCiliumCRDs")
+	if !objects.ErrIgnore || crds.ErrIgnore {
+		t.Errorf("ErrIgnore of deleteCiliumObjects = %v and purgeCiliumCRDs = %v, want only the object deletions ignored", objects.ErrIgnore, crds.ErrIgnore)
+	}
+	for _, want := range []string{"ciliumendpoints.cilium.io ciliumnodes.cilium.io", "--timeout=1m0s", `"finalizers":null`} {
+		if !strings.Contains(objects.Commands[0].ShellCommand[2], want) {
+			t.Errorf("deleteCiliumObjects command does not contain %q:\n%s", want, objects.Commands[0].ShellCommand[2])
+		}
+	}
+	if cmd := crds.Commands[0].ShellCommand[2]; !strings.Contains(cmd, `grep '\.cilium\.io$'`) || !strings.Contains(cmd, "--timeout=2m0s") {
+		t.Errorf("purgeCiliumCRDs command = %s", cmd)
+	}
+
+	for _, op := range (&CiliumRunnable{}).Operations("kube-system") {
+		if op.Name != OperationPurge {
+			continue
+		}
+		if !op.Destructive || !strings.HasPrefix(op.Command, "if "+common.HelmBin+" status cilium -n kube-system") {
+			t.Errorf("purge operation = %+v, want a destructive operation refusing the installed release", op)
+		}
+		return
+	}
+	t.Errorf("Operations() has no %s operation", OperationPurge)
+}
+
+func TestCiliumRunnable_namespace(t *testing.T) {
+	metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "master1"}}}
+	nodes := []v1.StepNode{{ID: "master1"}}
+	for _, namespace := range []string{"", "cilium-system"} {
+		stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", Namespace: namespace}, &v1.Networking{})
+		want := strutil.StringDefaultIfEmpty(CiliumNamespaceDefault, namespace)
+		steps, err := stepper.InstallSteps(nodes, "")
+		if err != nil {
+			t.Fatalf("InstallSteps() error = %v", err)
+		}
+		prepare := stepByName(steps, "prepareCiliumNamespace")
+		if prepare.Name == "" || prepare.ErrIgnore {
+			t.Fatalf("InstallSteps() = %v, want a failing prepareCiliumNamespace", stepNames(steps))
+		}
+		if names := strings.Join(stepNames(steps), " "); strings.Index(names, "prepareCiliumNamespace") > strings.Index(names, "installCiliumRelease") {
+			t.Errorf("prepareCiliumNamespace should run before the release is installed, got %v", names)
+		}
+		script := prepare.Commands[0].ShellCommand[2]
+		for _, part := range []string{"ns=" + want + "\n", "kubectl auth can-i create namespaces", `{.metadata.labels.pod-security\.kubernetes\.io/enforce}`,
+			"kubectl label namespace \"$ns\" " + NamespaceCreatedLabel + "=created"} {
+			if !strings.Contains(script, part) {
+				t.Errorf("prepareCiliumNamespace script does not contain %q:\n%s", part, script)
+			}
+		}
+		steps, err = stepper.UninstallSteps(nodes)
+		if err != nil {
+			t.Fatalf("UninstallSteps() error = %v", err)
+		}
+		if remove := stepByName(steps, "removeCiliumNamespace"); (remove.Name != "") != (namespace != "") {
+			t.Errorf("UninstallSteps() of namespace %s = %v", want, stepNames(steps))
+		}
+	}
+}
+
+func TestCiliumRunnable_JoinNodeSteps(t *testing.T) {
+	metadata := &component.ExtraMetadata{
+		CRI:     v1.CRIContainerd,
+		Masters: component.NodeList{{ID: "master1", Hostname: "master1"}},
+		Workers: component.NodeList{{ID: "worker1", Hostname: "worker1"}, {ID: "worker2", Hostname: "worker2"}},
+	}
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", Offline: true, Cilium: &v1.Cilium{}}, &v1.Networking{})
+	joining := []v1.StepNode{{ID: "worker2", Hostname: "worker2"}}
+	steps, err := stepper.JoinNodeSteps(joining)
+	if err != nil {
+		t.Fatalf("JoinNodeSteps() error = %v", err)
+	}
+	want := []string{"cniImageLoader", "checkCiliumNodeReady"}
+	if got := stepNames(steps); !reflect.DeepEqual(got, want) {
+		t.Fatalf("JoinNodeSteps() = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(steps[0].Nodes, joining) {
+		t.Errorf("cniImageLoader nodes = %v, want %v", steps[0].Nodes, joining)
+	}
+	ready := steps[1]
+	if !reflect.DeepEqual(ready.Nodes, []v1.StepNode{{ID: "master1", Hostname: "master1"}}) ||
+		!strings.Contains(ready.Commands[0].ShellCommand[2], "for node in worker2;") {
+		t.Errorf("checkCiliumNodeReady = %v on %v, want worker2 checked from master1", ready.Commands[0].ShellCommand, ready.Nodes)
+	}
+
+	steps, err = stepper.LeaveNodeSteps(joining)
+	if err != nil {
+		t.Fatalf("LeaveNodeSteps() error = %v", err)
+	}
+	want = []string{"clearCiliumNode", "removeCniImage", "pruneCiliumImages"}
+	if got := stepNames(steps); !reflect.DeepEqual(got, want) {
+		t.Errorf("LeaveNodeSteps() = %v, want %v", got, want)
+	}
+}
+
+func TestCiliumRunnable_MigrationSteps(t *testing.T) {
+	metadata := &component.ExtraMetadata{
+		Masters: component.NodeList{{ID: "master1", Hostname: "master1"}},
+		Workers: component.NodeList{{ID: "worker1", Hostname: "worker1"}},
+	}
+	networking := &v1.Networking{Pods: v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}}}
+	calico := (&CalicoRunnable{}).InitStep(metadata, &v1.CNI{Type: "calico", Version: "v3.26.1",
+		Calico: &v1.Calico{Mode: CalicoNetworkIPIPAll}}, networking)
+	all := []v1.StepNode{{ID: "master1", Hostname: "master1"}, {ID: "worker1", Hostname: "worker1"}}
+
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Type: "cilium", Version: "1.14.4"}, networking)
+	steps, err := stepper.MigrationSteps(calico, all)
+	if err != nil {
+		t.Fatalf("MigrationSteps() error = %v", err)
+	}
+	want := []string{"installHelm", "cilium-chartLoad", "renderCniYaml", "prepareCiliumNamespace", "checkCiliumRelease", "installCiliumRelease", "markCiliumRelease",
+		"applyCiliumNodeConfig", "checkCiliumReady", "migrateNode-master1", "migrateNode-worker1",
+		"renderCniYaml", "removeCalicoRelease", "removeTunl", "removeCali",
+		"renderCniYaml", "promoteCiliumRelease", "recordCNIRelease", "removeCiliumNodeConfig", "checkCiliumReady"}
+	if got := stepNames(steps); !reflect.DeepEqual(got, want) {
+		t.Fatalf("MigrationSteps() = %v, want %v", got, want)
+	}
+	for _, step := range steps {
+		if step.Name == "removeTunl" || step.Name == "removeCali" {
+			if !reflect.DeepEqual(step.Nodes, all) {
+				t.Errorf("%s nodes = %v, want %v", step.Name, step.Nodes, all)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(step.Nodes, []v1.StepNode{all[0]}) {
+			t.Errorf("%s nodes = %v, want the first master only", step.Name, step.Nodes)
+		}
+	}
+	migrate := stepByName(steps, "migrateNode-worker1").Commands[0].ShellCommand[2]
+	for _, cmd := range []string{"node=worker1", "kubectl drain $node", "io.cilium.migration/cilium-default=true", "mv \"$f\" \"${f%.cilium_bak}\"", "kubectl uncordon $node"} {
+		if !strings.Contains(migrate, cmd) {
+			t.Errorf("migrateNode-worker1 command does not contain %q:\n%s", cmd, migrate)
+		}
+	}
+
+	var migrationValues, primaryValues v1.Step
+	for _, step := range steps {
+		if step.Name == "renderCniYaml" && migrationValues.Name == "" {
+			migrationValues = step
+		} else if step.Name == "renderCniYaml" {
+			primaryValues = step
+		}
+	}
+	if !strings.Contains(string(migrationValues.Commands[0].Template.Data), `"migration":true`) {
+		t.Errorf("the first cilium values are not rendered for the migration")
+	}
+	if strings.Contains(string(primaryValues.Commands[0].Template.Data), `"migration":true`) {
+		t.Errorf("the last cilium values are rendered for the migration")
+	}
+	values, err := (&CiliumRunnable{Migration: true}).RenderString(context.TODO())
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	for _, value := range []string{"  unmanagedPodWatcher:\n    restart: false\n", "tunnelPort: 8473\ncni:\n  customConf: true\n  uninstall: false\n",
+		"policyEnforcementMode: never\n", "bpf:\n  hostLegacyRouting: true\n"} {
+		if !strings.Contains(values, value) {
+			t.Errorf("migration values do not contain %q:\n%s", value, values)
+		}
+	}
+
+	if _, err = stepper.MigrationSteps(calico, all[1:]); err == nil {
+		t.Errorf("MigrationSteps() of a part of the cluster should fail")
+	}
+	if _, err = stepper.MigrationSteps(stepper, all); err == nil {
+		t.Errorf("MigrationSteps() from cilium should fail")
+	}
+	if _, err = calico.MigrationSteps(stepper, all); err == nil {
+		t.Errorf("MigrationSteps() to calico should fail")
+	}
+	overlap := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", Cilium: &v1.Cilium{
+		ClusterPoolIPv4PodCIDRList: []string{"172.25.0.0/16"}, ClusterPoolIPv4MaskSize: 24}}, networking)
+	if _, err = overlap.MigrationSteps(calico, all); err == nil || !strings.Contains(err.Error(), "overlaps the calico pod CIDR") {
+		t.Errorf("MigrationSteps() error = %v, want the calico pod CIDR overlap", err)
+	}
+	legacy := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.13.9"}, networking)
+	if _, err = legacy.MigrationSteps(calico, all); err == nil {
+		t.Errorf("MigrationSteps() to cilium 1.13 should fail")
+	}
+}
+
+func TestCiliumRunnable_CheckSteps(t *testing.T) {
+	metadata := &component.ExtraMetadata{
+		Masters: component.NodeList{{ID: "master1"}},
+		Workers: component.NodeList{{ID: "worker1"}},
+	}
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4"}, &v1.Networking{})
+	all := []v1.StepNode{{ID: "master1"}, {ID: "worker1"}}
+	steps, err := stepper.CheckSteps(all)
+	if err != nil {
+		t.Fatalf("CheckSteps() error = %v", err)
+	}
+	if got := stepNames(steps); !reflect.DeepEqual(got, []string{"checkCiliumAgent", "checkCiliumCluster"}) {
+		t.Fatalf("CheckSteps() = %v", got)
+	}
+	if !reflect.DeepEqual(steps[0].Nodes, all) || !reflect.DeepEqual(steps[1].Nodes, all[:1]) {
+		t.Errorf("CheckSteps() nodes = %v and %v, want every node and the first master", steps[0].Nodes, steps[1].Nodes)
+	}
+	for _, step := range steps {
+		if !step.ErrIgnore {
+			t.Errorf("%s fails the operation, the health check must report every node", step.Name)
+		}
+	}
+	steps, err = stepper.CheckSteps(all[1:])
+	if err != nil {
+		t.Fatalf("CheckSteps() error = %v", err)
+	}
+	if got := stepNames(steps); !reflect.DeepEqual(got, []string{"checkCiliumAgent"}) {
+		t.Errorf("CheckSteps() of a part of the cluster = %v, want [checkCiliumAgent]", got)
+	}
+
+	for _, health := range []*CiliumHealth{{Namespace: "kube-system"}, {Namespace: "kube-system", Cluster: true, Nodes: 2}} {
+		resp, err := health.Install(context.TODO(), component.Options{DryRun: true})
+		if err != nil {
+			t.Fatalf("Install() error = %v", err)
+		}
+		result := &HealthResult{}
+		if err = json.Unmarshal(resp, result); err != nil {
+			t.Fatalf("Install() response %s is not a HealthResult: %v", resp, err)
+		}
+		if !result.Healthy || result.Node == "" || len(result.Checks) == 0 {
+			t.Errorf("Install() dry run result = %+v", result)
+		}
+	}
+	if _, err = (&CalicoRunnable{}).CheckSteps(all); err == nil {
+		t.Errorf("CheckSteps() of calico should fail")
+	}
+}
+
+func TestCiliumRunnable_encryptionSteps(t *testing.T) {
+	tests := []struct {
+		name          string
+		encryption    *v1.CiliumEncryption
+		wantInstall   []string
+		wantUninstall []string
+		wantValues    string
+	}{
+		{
+			name:          "ipsec",
+			encryption:    &v1.CiliumEncryption{Type: CiliumEncryptionIPsec},
+			wantInstall:   []string{"installHelm", "cilium-chartLoad", "renderCniYaml", "prepareCiliumNamespace", "createCiliumIPsecKeys", "checkCiliumRelease", "installCiliumRelease", "markCiliumRelease", "recordCNIRelease", "installCiliumCLI", "checkCiliumReady"},
+			wantUninstall: []string{"uninstallCiliumRelease", "removeCiliumIPsecKeys", "removeCniManifests", "clearCiliumNode"},
+			wantValues:    "encryption:\n  enabled: true\n  type: ipsec\n  secretName: cilium-ipsec-keys\n  ipsec:\n    secretName: cilium-ipsec-keys\n",
+		},
+		{
+			name:          "wireguard",
+			encryption:    &v1.CiliumEncryption{Type: CiliumEncryptionWireguard},
+			wantInstall:   []string{"checkCiliumWireguard", "installHelm", "cilium-chartLoad", "renderCniYaml", "prepareCiliumNamespace", "checkCiliumRelease", "installCiliumRelease", "markCiliumRelease", "recordCNIRelease", "installCiliumCLI", "checkCiliumReady"},
+			wantUninstall: []string{"uninstallCiliumRelease", "removeCniManifests", "clearCiliumNode"},
+			wantValues:    "encryption:\n  enabled: true\n  type: wireguard\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Cilium: &v1.Cilium{Encryption: tt.encryption}}, &v1.Networking{})
+			if err := stepper.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			steps, err := stepper.InstallSteps(nil, "")
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			if got := stepNames(steps); !reflect.DeepEqual(got, tt.wantInstall) {
+				t.Errorf("InstallSteps() = %v, want %v", got, tt.wantInstall)
+			}
+			steps, err = stepper.UninstallSteps(nil)
+			if err != nil {
+				t.Fatalf("UninstallSteps() error = %v", err)
+			}
+			if got := stepNames(steps); !reflect.DeepEqual(got, tt.wantUninstall) {
+				t.Errorf("UninstallSteps() = %v, want %v", got, tt.wantUninstall)
+			}
+			w := &bytes.Buffer{}
+			if err = stepper.(*CiliumRunnable).renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			if !strings.Contains(w.String(), tt.wantValues) {
+				t.Errorf("renderCiliumTo() output does not contain %q:\n%s", tt.wantValues, w.String())
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_localRegistry(t *testing.T) {
+	tests := []struct {
+		name     string
+		registry string
+		want     string
+	}{
+		{name: "without port", registry: "registry.local", want: "registry.local/cilium"},
+		{name: "with port", registry: "172.0.0.1:5000", want: "172.0.0.1:5000/cilium"},
+		{name: "nested path", registry: "registry.local:5000/mirror/", want: "registry.local:5000/mirror/cilium"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{
+				LocalRegistry: tt.registry,
+				Offline:       true,
+				Cilium:        &v1.Cilium{OperatorReplicas: 1, EnableHubble: true, EnableHubbleUI: true},
+			}, &v1.Networking{})
+			runnable := stepper.(*CiliumRunnable)
+			if runnable.Images.Operator != tt.want+"/operator" {
+				t.Errorf("Images.Operator = %s, want %s", runnable.Images.Operator, tt.want+"/operator")
+			}
+			w := &bytes.Buffer{}
+			if err := runnable.renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			for _, image := range []string{"cilium", "operator", "hubble-relay", "hubble-ui", "hubble-ui-backend", "certgen", "cilium-envoy"} {
+				if !strings.Contains(w.String(), "repository: "+tt.want+"/"+image+"\n") {
+					t.Errorf("renderCiliumTo() output does not contain %s image:\n%s", image, w.String())
+				}
+			}
+			if strings.Contains(w.String(), "quay.io") || strings.Count(w.String(), "useDigest: false") != 7 {
+				t.Errorf("renderCiliumTo() output should rewrite all images:\n%s", w.String())
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_renderTunnelMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    string
+		cilium     *v1.Cilium
+		wantValues string
+		excludes   string
+		wantErr    bool
+	}{
+		{
+			name:       "1.13 geneve",
+			version:    "1.13.4",
+			cilium:     &v1.Cilium{TunnelMode: CiliumTunnelGeneve},
+			wantValues: "tunnel: geneve\n",
+			excludes:   "tunnelProtocol",
+		},
+		{
+			name:       "1.13 disabled",
+			version:    "1.13.4",
+			cilium:     &v1.Cilium{TunnelMode: CiliumTunnelDisabled},
+			wantValues: "tunnel: disabled\nautoDirectNodeRoutes: true\nipv4NativeRoutingCIDR: 172.25.0.0/16\n",
+			excludes:   "routingMode",
+		},
+		{
+			name:       "vxlan",
+			cilium:     &v1.Cilium{TunnelMode: CiliumTunnelVXLAN},
+			wantValues: "routingMode: tunnel\ntunnelProtocol: vxlan\n",
+			excludes:   "NativeRoutingCIDR",
+		},
+		{
+			name:       "geneve",
+			cilium:     &v1.Cilium{TunnelMode: CiliumTunnelGeneve},
+			wantValues: "routingMode: tunnel\ntunnelProtocol: geneve\n",
+			excludes:   "NativeRoutingCIDR",
+		},
+		{
+			name:       "disabled defaults to pod cidr",
+			cilium:     &v1.Cilium{TunnelMode: CiliumTunnelDisabled},
+			wantValues: "routingMode: native\nautoDirectNodeRoutes: true\nipv4NativeRoutingCIDR: 172.25.0.0/16\n",
+			excludes:   "tunnelProtocol",
+		},
+		{
+			name:       "disabled with native routing cidr",
+			cilium:     &v1.Cilium{TunnelMode: CiliumTunnelDisabled, NativeRoutingCIDR: "10.0.0.0/8"},
+			wantValues: "routingMode: native\nautoDirectNodeRoutes: true\nipv4NativeRoutingCIDR: 10.0.0.0/8\n",
+			excludes:   "tunnelProtocol",
+		},
+		{
+			name:    "invalid",
+			cilium:  &v1.Cilium{TunnelMode: "ipip"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networking := &v1.Networking{Pods: v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}}}
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: tt.version, Cilium: tt.cilium}, networking)
+			if err := stepper.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			w := &bytes.Buffer{}
+			if err := stepper.(*CiliumRunnable).renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			if !strings.Contains(w.String(), tt.wantValues) || strings.Contains(w.String(), tt.excludes) {
+				t.Errorf("renderCiliumTo() output should contain %q without %q:\n%s", tt.wantValues, tt.excludes, w.String())
+			}
+			if tt.name == "disabled defaults to pod cidr" && tt.cilium.NativeRoutingCIDR != "" {
+				t.Errorf("InitStep() should not modify the cilium config of cni")
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_UpgradeSteps(t *testing.T) {
+	tests := []struct {
+		name        string
+		fromVersion string
+		toVersion   string
+		helmSDK     bool
+		want        []string
+		wantReuse   bool
+		wantErr     bool
+	}{
+		{
+			name:        "upgrade",
+			fromVersion: "1.14.4",
+			toVersion:   "1.15.1",
+			want: []string{"installHelm", "cilium-chartLoad", "renderCniYaml", "checkCiliumPreflight", "removeCiliumPreflight",
+				"upgradeCiliumRelease", "markCiliumRelease", "recordCNIRelease", "installCiliumCLI", "checkCiliumReady"},
+			wantReuse: true,
+		},
+		{name: "downgrade", fromVersion: "1.15.1", toVersion: "1.14.4", wantErr: true},
+		{
+			name:        "same version refreshes the values",
+			fromVersion: "1.14.4",
+			toVersion:   "1.14.4",
+			want: []string{"installHelm", "cilium-chartLoad", "renderCniYaml",
+				"upgradeCiliumRelease", "markCiliumRelease", "recordCNIRelease", "installCiliumCLI", "checkCiliumReady"},
+		},
+		{
+			name:        "upgrade with the helm SDK",
+			fromVersion: "1.14.4",
+			toVersion:   "1.15.1",
+			helmSDK:     true,
+			want: []string{"installHelm", "cilium-chartLoad", "renderCniYaml", "checkCiliumPreflight", "removeCiliumPreflight",
+				"upgradeCiliumRelease", "markCiliumRelease", "recordCNIRelease", "installCiliumCLI", "checkCiliumReady"},
+			wantReuse: true,
+		},
+		{
+			name:        "same version with the helm SDK diffs the values",
+			fromVersion: "1.14.4",
+			toVersion:   "1.14.4",
+			helmSDK:     true,
+			want: []string{"installHelm", "cilium-chartLoad", "renderCniYaml", "diffCiliumRelease",
+				"upgradeCiliumRelease", "markCiliumRelease", "recordCNIRelease", "installCiliumCLI", "checkCiliumReady"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: tt.fromVersion}, &v1.Networking{})
+			steps, err := stepper.UpgradeSteps([]v1.StepNode{{ID: "node1", HelmSDK: tt.helmSDK}}, tt.fromVersion, tt.toVersion)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpgradeSteps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := stepNames(steps); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UpgradeSteps() = %v, want %v", got, tt.want)
+			}
+			if version := stepper.(*CiliumRunnable).Version; version != tt.fromVersion {
+				t.Errorf("UpgradeSteps() changed the stepper version to %s", version)
+			}
+			for _, step := range steps[2:] {
+				if step.Name != "renderCniYaml" && step.Name != "installCiliumCLI" && step.Name != v1.StepRecordCNIRelease && step.Action != v1.ActionUpgrade {
+					t.Errorf("step %s action = %s, want %s", step.Name, step.Action, v1.ActionUpgrade)
+				}
+			}
+			cmd := stepByName(steps, "upgradeCiliumRelease").Commands[0]
+			upgrade := strings.Join(cmd.ShellCommand, " ")
+			if cmd.Helm != nil {
+				upgrade = fmt.Sprintf("%s --reuse-values=%v", cmd.Helm.ChartPath, cmd.Helm.ReuseValues)
+				if !cmd.Helm.ReuseValues {
+					upgrade = cmd.Helm.ChartPath
+				}
+			}
+			if (cmd.Helm != nil) != tt.helmSDK {
+				t.Errorf("upgradeCiliumRelease command = %+v, want the helm SDK %v", cmd, tt.helmSDK)
+			}
+			if strings.Contains(upgrade, "--reuse-values") != tt.wantReuse || !strings.Contains(upgrade, "/.cilium/"+tt.toVersion+"/") {
+				t.Errorf("upgradeCiliumRelease command = %s, want --reuse-values %v with chart %s", upgrade, tt.wantReuse, tt.toVersion)
+			}
+			if diff := stepByName(steps, "diffCiliumRelease"); diff.Name != "" {
+				if h := diff.Commands[0].Helm; !diff.Diff || h.Action != v1.HelmActionDiff || h.ReuseValues || h.CreateNamespace {
+					t.Errorf("diffCiliumRelease = %+v, helm = %+v", diff, h)
+				}
+			}
+			for _, step := range steps {
+				if !step.ReportProgress {
+					t.Errorf("step %s does not report its progress", step.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_bandwidthManager(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *v1.Cilium
+		wantRender  []string
+		wantMissing []string
+		wantCheck   bool
+		wantErr     bool
+	}{
+		{name: "disabled", config: &v1.Cilium{OperatorReplicas: 1}, wantMissing: []string{"bandwidthManager:"}},
+		{
+			name:       "bandwidth manager",
+			config:     &v1.Cilium{OperatorReplicas: 1, EnableBandwidthManager: true},
+			wantRender: []string{"bandwidthManager:\n  enabled: true\n  bbr: false\n"},
+		},
+		{
+			name:       "bbr",
+			config:     &v1.Cilium{OperatorReplicas: 1, EnableBandwidthManager: true, EnableBBR: true},
+			wantRender: []string{"bandwidthManager:\n  enabled: true\n  bbr: true\n"},
+			wantCheck:  true,
+		},
+		{name: "bbr without bandwidth manager", config: &v1.Cilium{EnableBBR: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1"}}, Workers: component.NodeList{{ID: "node2"}}}
+			stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", Cilium: tt.config}, &v1.Networking{})
+			if err := stepper.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			w := &bytes.Buffer{}
+			if err := stepper.(*CiliumRunnable).renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			for _, want := range tt.wantRender {
+				if !strings.Contains(w.String(), want) {
+					t.Errorf("renderCiliumTo() output does not contain %q:\n%s", want, w.String())
+				}
+			}
+			for _, unwanted := range tt.wantMissing {
+				if strings.Contains(w.String(), unwanted) {
+					t.Errorf("renderCiliumTo() output contains %q:\n%s", unwanted, w.String())
+				}
+			}
+			steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "")
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			check := stepByName(steps, "checkCiliumBBRKernel")
+			if (check.Name != "") != tt.wantCheck {
+				t.Fatalf("InstallSteps() = %v, want checkCiliumBBRKernel %v", stepNames(steps), tt.wantCheck)
+			}
+			if tt.wantCheck {
+				if withoutPreflight(steps)[0].Name != "checkCiliumBBRKernel" || len(check.Nodes) != 2 {
+					t.Errorf("checkCiliumBBRKernel should run first on all nodes, got steps %v nodes %v", stepNames(steps), check.Nodes)
+				}
+				if !strings.Contains(check.Commands[0].ShellCommand[2], "kernel >= 5.18, node $(hostname)") {
+					t.Errorf("checkCiliumBBRKernel command = %s", check.Commands[0].ShellCommand[2])
+				}
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_policyEnforcement(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *v1.Cilium
+		wantRender  []string
+		wantMissing []string
+		wantWarning bool
+		wantErr     bool
+	}{
+		{name: "chart default", config: &v1.Cilium{OperatorReplicas: 1}, wantMissing: []string{"policyEnforcementMode:", "policyAuditMode:"}},
+		{
+			name:        "always with audit",
+			config:      &v1.Cilium{OperatorReplicas: 1, PolicyEnforcementMode: CiliumPolicyEnforcementAlways, PolicyAuditMode: true},
+			wantRender:  []string{"\npolicyEnforcementMode: always\n", "\npolicyAuditMode: true\n"},
+			wantWarning: true,
+		},
+		{
+			name:       "never",
+			config:     &v1.Cilium{OperatorReplicas: 1, PolicyEnforcementMode: CiliumPolicyEnforcementNever},
+			wantRender: []string{"\npolicyEnforcementMode: never\n"},
+		},
+		{name: "unknown mode", config: &v1.Cilium{PolicyEnforcementMode: "strict"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1"}}}
+			stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", Cilium: tt.config}, &v1.Networking{})
+			if err := stepper.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			w := &bytes.Buffer{}
+			if err := stepper.(*CiliumRunnable).renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			for _, want := range tt.wantRender {
+				if !strings.Contains(w.String(), want) {
+					t.Errorf("renderCiliumTo() output does not contain %q:\n%s", want, w.String())
+				}
+			}
+			for _, unwanted := range tt.wantMissing {
+				if strings.Contains(w.String(), unwanted) {
+					t.Errorf("renderCiliumTo() output contains %q:\n%s", unwanted, w.String())
+				}
+			}
+			steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "")
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			warning := stepByName(steps, "warnCiliumPolicyEnforcement")
+			if (warning.Name != "") != tt.wantWarning {
+				t.Fatalf("InstallSteps() = %v, want warnCiliumPolicyEnforcement %v", stepNames(steps), tt.wantWarning)
+			}
+			if tt.wantWarning && !warning.ErrIgnore {
+				t.Error("warnCiliumPolicyEnforcement should not fail the install")
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_DNSProxy(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *v1.Cilium
+		wantRender  []string
+		wantMissing []string
+		wantProbe   bool
+		wantErr     bool
+	}{
+		{name: "disabled", config: &v1.Cilium{OperatorReplicas: 1}, wantMissing: []string{"l7Proxy:", "dnsProxy:"}},
+		{
+			name:        "chart defaults",
+			config:      &v1.Cilium{OperatorReplicas: 1, EnableDNSProxy: true},
+			wantRender:  []string{"\nl7Proxy: true\n"},
+			wantMissing: []string{"dnsProxy:"},
+			wantProbe:   true,
+		},
+		{
+			name: "tuned",
+			config: &v1.Cilium{OperatorReplicas: 1, EnableDNSProxy: true,
+				DNSProxyConfig: &v1.CiliumDNSProxy{MinTTL: 3600, PreCache: "/var/lib/cilium/dns-cache.json", RejectResponseCode: "nameError"}},
+			wantRender: []string{`dnsProxy: {"dnsRejectResponseCode":"nameError","minTtl":3600,"preCache":"/var/lib/cilium/dns-cache.json"}`},
+			wantProbe:  true,
+		},
+		{
+			name:        "policies always enforced",
+			config:      &v1.Cilium{OperatorReplicas: 1, EnableDNSProxy: true, PolicyEnforcementMode: CiliumPolicyEnforcementAlways},
+			wantRender:  []string{"\nl7Proxy: true\n"},
+			wantMissing: []string{"dnsProxy:"},
+		},
+		{name: "config without proxy", config: &v1.Cilium{DNSProxyConfig: &v1.CiliumDNSProxy{MinTTL: 60}}, wantErr: true},
+		{name: "negative ttl", config: &v1.Cilium{EnableDNSProxy: true, DNSProxyConfig: &v1.CiliumDNSProxy{MinTTL: -1}}, wantErr: true},
+		{name: "relative pre-cache", config: &v1.Cilium{EnableDNSProxy: true, DNSProxyConfig: &v1.CiliumDNSProxy{PreCache: "cache.json"}}, wantErr: true},
+		{name: "unknown response code", config: &v1.Cilium{EnableDNSProxy: true, DNSProxyConfig: &v1.CiliumDNSProxy{RejectResponseCode: "drop"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1"}}}
+			stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", Cilium: tt.config}, &v1.Networking{})
+			if err := stepper.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			w := &bytes.Buffer{}
+			if err := stepper.(*CiliumRunnable).renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			for _, want := range tt.wantRender {
+				if !strings.Contains(w.String(), want) {
+					t.Errorf("renderCiliumTo() output does not contain %q:\n%s", want, w.String())
+				}
+			}
+			for _, unwanted := range tt.wantMissing {
+				if strings.Contains(w.String(), unwanted) {
+					t.Errorf("renderCiliumTo() output contains %q:\n%s", unwanted, w.String())
+				}
+			}
+			steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "")
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			ready := stepByName(steps, "checkCiliumReady")
+			script := ready.Commands[0].ShellCommand[2]
+			if strings.Contains(script, "nslookup kubernetes.default") != tt.wantProbe {
+				t.Fatalf("checkCiliumReady = %s, want the DNS probe %v", script, tt.wantProbe)
+			}
+			if !tt.wantProbe {
+				return
+			}
+			if !strings.Contains(script, ciliumDNSProbeImage+":"+ciliumDNSProbeTag) {
+				t.Errorf("checkCiliumReady = %s, want the probe image", script)
+			}
+			if m := ready.ClassifyError(1, "Server:\n;; connection timed out\n"+ciliumDNSProbeFailed); m == nil || m.Category != v1.StepErrorDNSResolutionFailed {
+				t.Errorf("ClassifyError() = %+v, want %s", m, v1.StepErrorDNSResolutionFailed)
+			}
+			if m := ready.ClassifyError(1, "error: timed out waiting for the condition"); m != nil && m.Category == v1.StepErrorDNSResolutionFailed {
+				t.Errorf("ClassifyError() of the rollout = %s", m.Category)
+			}
+			images, err := stepper.GetImages("1.14.4", "")
+			if err != nil {
+				t.Fatalf("GetImages() error = %v", err)
+			}
+			if !strings.Contains(strings.Join(images, " "), ciliumDNSProbeImage+":"+ciliumDNSProbeTag) {
+				t.Errorf("GetImages() = %v, want the probe image", images)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_KVStore(t *testing.T) {
+	kvstore := func(force bool) *v1.CiliumKVStore {
+		return &v1.CiliumKVStore{Endpoints: []string{"https://10.0.0.1:2379"}, CAFile: "/etc/etcd/ca.crt",
+			CertFile: "/etc/etcd/client.crt", KeyFile: "/etc/etcd/client.key", Force: force}
+	}
+	masters := component.NodeList{{ID: "node1"}, {ID: "node2"}, {ID: "node3"}}
+	tests := []struct {
+		name    string
+		masters component.NodeList
+		config  *v1.Cilium
+		wantErr bool
+	}{
+		{name: "crd", masters: masters[:1], config: &v1.Cilium{IdentityAllocationMode: CiliumIdentityAllocationCRD}},
+		{name: "kvstore", masters: masters, config: &v1.Cilium{IdentityAllocationMode: CiliumIdentityAllocationKVStore, KVStore: kvstore(false)}},
+		{name: "forced on a single master", masters: masters[:1], config: &v1.Cilium{IdentityAllocationMode: CiliumIdentityAllocationKVStore, KVStore: kvstore(true)}},
+		{name: "single master", masters: masters[:1], config: &v1.Cilium{IdentityAllocationMode: CiliumIdentityAllocationKVStore, KVStore: kvstore(false)}, wantErr: true},
+		{name: "missing certificates", masters: masters, config: &v1.Cilium{IdentityAllocationMode: CiliumIdentityAllocationKVStore,
+			KVStore: &v1.CiliumKVStore{Endpoints: []string{"https://10.0.0.1:2379"}}}, wantErr: true},
+		{name: "missing kvstore", masters: masters, config: &v1.Cilium{IdentityAllocationMode: CiliumIdentityAllocationKVStore}, wantErr: true},
+		{name: "http endpoint", masters: masters, config: &v1.Cilium{IdentityAllocationMode: CiliumIdentityAllocationKVStore,
+			KVStore: &v1.CiliumKVStore{Endpoints: []string{"http://10.0.0.1:2379"}, CAFile: "/ca", CertFile: "/crt", KeyFile: "/key"}}, wantErr: true},
+		{name: "relative certificate", masters: masters, config: &v1.Cilium{IdentityAllocationMode: CiliumIdentityAllocationKVStore,
+			KVStore: &v1.CiliumKVStore{Endpoints: []string{"https://10.0.0.1:2379"}, CAFile: "ca", CertFile: "/crt", KeyFile: "/key"}}, wantErr: true},
+		{name: "kvstore without the mode", masters: masters, config: &v1.Cilium{KVStore: kvstore(false)}, wantErr: true},
+		{name: "unknown mode", masters: masters, config: &v1.Cilium{IdentityAllocationMode: "etcd"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.OperatorReplicas = 1
+			metadata := &component.ExtraMetadata{Masters: tt.masters}
+			stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", Cilium: tt.config}, &v1.Networking{})
+			if err := stepper.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			w := &bytes.Buffer{}
+			if err := stepper.(*CiliumRunnable).renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			enabled := tt.config.IdentityAllocationMode == CiliumIdentityAllocationKVStore
+			if want := "\nidentityAllocationMode: " + tt.config.IdentityAllocationMode + "\n"; !strings.Contains(w.String(), want) {
+				t.Errorf("renderCiliumTo() output does not contain %q:\n%s", want, w.String())
+			}
+			if want := "etcd:\n  enabled: true\n  ssl: true\n  endpoints: [\"https://10.0.0.1:2379\"]\n"; strings.Contains(w.String(), want) != enabled {
+				t.Errorf("renderCiliumTo() output contains %q = %v, want %v:\n%s", want, !enabled, enabled, w.String())
+			}
+			nodes := utils.UnwrapNodeList(tt.masters)
+			steps, err := stepper.InstallSteps(nodes[:1], "")
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			secret := stepByName(steps, "createCiliumEtcdSecret")
+			if (secret.Name != "") != enabled {
+				t.Fatalf("InstallSteps() = %v, want createCiliumEtcdSecret %v", stepNames(steps), enabled)
+			}
+			if !enabled {
+				return
+			}
+			if names := strings.Join(stepNames(steps), " "); strings.Index(names, "createCiliumEtcdSecret") > strings.Index(names, "installCiliumRelease") {
+				t.Errorf("createCiliumEtcdSecret should run before the release is installed, got %v", names)
+			}
+			if cmd := secret.Commands[1].ShellCommand[2]; !strings.Contains(cmd, "--from-file=etcd-client-ca.crt=/etc/etcd/ca.crt") ||
+				!strings.Contains(cmd, "--from-file=etcd-client.key=/etc/etcd/client.key") {
+				t.Errorf("createCiliumEtcdSecret command = %s", cmd)
+			}
+			uninstall, err := stepper.UninstallSteps(nodes)
+			if err != nil {
+				t.Fatalf("UninstallSteps() error = %v", err)
+			}
+			if stepByName(uninstall, "removeCiliumEtcdSecret").Name == "" {
+				t.Errorf("UninstallSteps() = %v, want removeCiliumEtcdSecret", stepNames(uninstall))
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_OperatorReplicas(t *testing.T) {
+	masters := func(n int) component.NodeList {
+		list := make(component.NodeList, n)
+		for i := range list {
+			list[i] = component.Node{ID: fmt.Sprintf("master%d", i)}
+		}
+		return list
+	}
+	tests := []struct {
+		name         string
+		masters      int
+		config       *v1.Cilium
+		want         int
+		wantAffinity bool
+	}{
+		{name: "single master", masters: 1, want: 1},
+		{name: "three masters", masters: 3, want: 2, wantAffinity: true},
+		{name: "five masters", masters: 5, want: 2, wantAffinity: true},
+		{name: "three masters without replicas", masters: 3, config: &v1.Cilium{}, want: 2, wantAffinity: true},
+		{name: "user value on a single master", masters: 1, config: &v1.Cilium{OperatorReplicas: 2}, want: 2, wantAffinity: true},
+		{name: "user value on five masters", masters: 5, config: &v1.Cilium{OperatorReplicas: 1}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := &component.ExtraMetadata{Masters: masters(tt.masters)}
+			stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", Cilium: tt.config}, &v1.Networking{}).(*CiliumRunnable)
+			if got := stepper.OperatorReplicas(); got != tt.want {
+				t.Errorf("OperatorReplicas() = %d, want %d", got, tt.want)
+			}
+			// the agent renders the values from the serialized stepper
+			data, err := json.Marshal(stepper)
+			if err != nil {
+				t.Fatal(err)
+			}
+			agent := &CiliumRunnable{}
+			if err = json.Unmarshal(data, agent); err != nil {
+				t.Fatal(err)
+			}
+			values, err := agent.RenderString(context.TODO())
+			if err != nil {
+				t.Fatalf("RenderString() error = %v", err)
+			}
+			if want := fmt.Sprintf("operator:\n  replicas: %d\n", tt.want); !strings.HasPrefix(values, want) {
+				t.Errorf("RenderString() does not start with %q:\n%s", want, values)
+			}
+			if got := strings.Contains(values, "podAntiAffinity:"); got != tt.wantAffinity {
+				t.Errorf("RenderString() renders podAntiAffinity = %v, want %v:\n%s", got, tt.wantAffinity, values)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_releaseName(t *testing.T) {
+	nodes := []v1.StepNode{{ID: "node1"}}
+	stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.14.4", Namespace: "cilium-system",
+		Cilium: &v1.Cilium{ReleaseName: "edge-cni"}}, &v1.Networking{})
+	if err := stepper.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	install, err := stepper.InstallSteps(nodes, "")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	check := stepByName(install, "checkCiliumRelease")
+	if !strings.Contains(check.Commands[0].ShellCommand[2], "helm status edge-cni -n cilium-system") ||
+		!strings.Contains(check.Commands[0].ShellCommand[2], "name=edge-cni,kubeclipper.io/managed=true") {
+		t.Errorf("checkCiliumRelease command = %s", check.Commands[0].ShellCommand[2])
+	}
+	if got := stepByName(install, "installCiliumRelease").Commands[0].ShellCommand[4]; got != "edge-cni" {
+		t.Errorf("installCiliumRelease release = %s, want edge-cni", got)
+	}
+	uninstall, err := stepper.UninstallSteps(nodes)
+	if err != nil {
+		t.Fatalf("UninstallSteps() error = %v", err)
+	}
+	if got := strings.Join(uninstall[0].Commands[0].ShellCommand, " "); got != common.HelmBin+" uninstall edge-cni -n cilium-system" {
+		t.Errorf("uninstallCiliumRelease command = %s", got)
+	}
+	upgrade, err := stepper.UpgradeSteps(nodes, "1.14.4", "1.15.1")
+	if err != nil {
+		t.Fatalf("UpgradeSteps() error = %v", err)
+	}
+	if got := stepByName(upgrade, "upgradeCiliumRelease").Commands[0].ShellCommand[4]; got != "edge-cni" {
+		t.Errorf("upgradeCiliumRelease release = %s, want edge-cni", got)
+	}
+	if got := stepByName(upgrade, "checkCiliumPreflight").Commands[0].ShellCommand[3]; got != "edge-cni-preflight" {
+		t.Errorf("checkCiliumPreflight release = %s, want edge-cni-preflight", got)
+	}
+	if got := stepper.CmdList("cilium-system")["version"]; !strings.Contains(got, "helm status edge-cni -n cilium-system") {
+		t.Errorf("CmdList() version = %s", got)
+	}
+	cmdList, err := RecoveryCNICmd(&component.ExtraMetadata{CNI: "cilium", CNINamespace: "cilium-system"},
+		&v1.Cluster{CNI: v1.CNI{Type: "cilium", Cilium: &v1.Cilium{ReleaseName: "edge-cni"}}})
+	if err != nil {
+		t.Fatalf("RecoveryCNICmd() error = %v", err)
+	}
+	if got := cmdList["version"]; !strings.Contains(got, "helm status edge-cni -n cilium-system") {
+		t.Errorf("RecoveryCNICmd() version = %s", got)
+	}
+
+	if got := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{}, &v1.Networking{}).CmdList("kube-system")["version"]; !strings.Contains(got, "helm status cilium -n kube-system") {
+		t.Errorf("CmdList() default version = %s", got)
+	}
+	for _, name := range []string{"Cilium", "cilium_1", strings.Repeat("c", 44)} {
+		stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Cilium: &v1.Cilium{ReleaseName: name}}, &v1.Networking{})
+		if err := stepper.Validate(); err == nil {
+			t.Errorf("Validate() release name %q expected error", name)
+		}
+	}
+}
+
+func TestCiliumRunnable_renderHelmValues(t *testing.T) {
+	stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Cilium: &v1.Cilium{
+		OperatorReplicas: 1,
+		HelmValues:       "operator:\n  replicas: 3\nbandwidthManager:\n  enabled: true\n",
+	}}, &v1.Networking{})
+	if err := stepper.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	w := &bytes.Buffer{}
+	if err := stepper.(*CiliumRunnable).renderCiliumTo(w); err != nil {
+		t.Fatalf("renderCiliumTo() error = %v", err)
+	}
+	for _, want := range []string{"operator:\n  replicas: 3\n", "bandwidthManager:\n  enabled: true\n", "mode: cluster-pool\n"} {
+		if !strings.Contains(w.String(), want) {
+			t.Errorf("renderCiliumTo() output does not contain %q:\n%s", want, w.String())
+		}
+	}
+
+	stepper = (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Cilium: &v1.Cilium{
+		HelmValues: "operator:\n\treplicas: 3\n",
+	}}, &v1.Networking{})
+	if err := stepper.Validate(); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Validate() error = %v, want line-numbered yaml error", err)
+	}
+	if err := stepper.(*CiliumRunnable).renderCiliumTo(&bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("renderCiliumTo() error = %v, want line-numbered yaml error", err)
+	}
+}
+
+func TestCiliumRunnable_extraSetArgs(t *testing.T) {
+	stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.14.4", Cilium: &v1.Cilium{
+		OperatorReplicas: 1,
+		ExtraSetArgs:     []string{"debug.enabled=true"},
+	}}, &v1.Networking{})
+	if err := stepper.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	if cmd := strings.Join(stepByName(steps, "installCiliumRelease").Commands[0].ShellCommand, " "); !strings.HasSuffix(cmd, "--set debug.enabled=true") {
+		t.Errorf("installCiliumRelease command = %s, want the extra --set argument", cmd)
+	}
+
+	stepper.(*CiliumRunnable).CiliumConfig.ExtraSetArgs = []string{"debug.enabled=true; rm -rf /"}
+	if err = stepper.Validate(); err == nil {
+		t.Errorf("Validate() with shell metacharacters in the set arguments should fail")
+	}
+}
+
+func TestCiliumRunnable_chartSource(t *testing.T) {
+	cni := &v1.CNI{Version: "1.14.4", ChartSource: "oci://harbor.example.com/charts/"}
+	stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, cni, &v1.Networking{})
+	if err := stepper.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	load := stepByName(steps, "cilium-chartLoad").Commands[0]
+	if load.Type != v1.CommandShell {
+		t.Fatalf("cilium-chartLoad command type = %v, want the helm pull shell command", load.Type)
+	}
+	for _, want := range []string{"helm pull oci://harbor.example.com/charts/cilium --version 1.14.4", "/tmp/kc-downloader/.cilium/1.14.4/charts.tgz", "--registry-config $HOME/.docker/config.json"} {
+		if !strings.Contains(load.ShellCommand[2], want) {
+			t.Errorf("cilium-chartLoad command does not contain %q:\n%s", want, load.ShellCommand[2])
+		}
+	}
+
+	// offline installs keep the chart of the package server
+	cni.Offline = true
+	stepper = (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, cni, &v1.Networking{})
+	steps, err = stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	if got := stepByName(steps, "cilium-chartLoad").Commands[0].Type; got != v1.CommandCustom {
+		t.Errorf("offline cilium-chartLoad command type = %v, want the downloader", got)
+	}
+
+	for _, source := range []string{"https://harbor.example.com/charts", "oci://", "oci://harbor.example.com/charts;id"} {
+		stepper = (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.14.4", ChartSource: source}, &v1.Networking{})
+		if err = stepper.Validate(); err == nil {
+			t.Errorf("Validate() with chart source %q should fail", source)
+		}
+	}
+}
+
+func TestCiliumRunnable_renderOperatorPlacement(t *testing.T) {
+	render := func(c *v1.Cilium) string {
+		stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Cilium: c}, &v1.Networking{})
+		if err := stepper.Validate(); err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+		w := &bytes.Buffer{}
+		if err := stepper.(*CiliumRunnable).renderCiliumTo(w); err != nil {
+			t.Fatalf("renderCiliumTo() error = %v", err)
+		}
+		return w.String()
+	}
+
+	out := render(&v1.Cilium{OperatorReplicas: 1})
+	for _, unwanted := range []string{"resources:", "nodeSelector:", "tolerations:"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("renderCiliumTo() output contains %q when unset:\n%s", unwanted, out)
+		}
+	}
+
+	out = render(&v1.Cilium{
+		OperatorReplicas: 1,
+		OperatorResources: &v1.CiliumOperatorResources{
+			Requests: map[string]string{"cpu": "50m", "memory": "64Mi"},
+			Limits:   map[string]string{"memory": "256Mi"},
+		},
+		OperatorNodeSelector: map[string]string{"node-role.kubernetes.io/control-plane": ""},
+		OperatorTolerations:  []v1.CiliumToleration{{Key: "node-role.kubernetes.io/control-plane", Operator: "Exists", Effect: "NoSchedule"}},
+	})
+	for _, want := range []string{
+		"operator:\n  replicas: 1\n  resources: ",
+		`resources: {"requests":{"cpu":"50m","memory":"64Mi"},"limits":{"memory":"256Mi"}}`,
+		`nodeSelector: {"node-role.kubernetes.io/control-plane":""}`,
+		`tolerations: [{"key":"node-role.kubernetes.io/control-plane","operator":"Exists","effect":"NoSchedule"}]`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("renderCiliumTo() output does not contain %q:\n%s", want, out)
+		}
+	}
+
+	invalid := []*v1.Cilium{
+		{OperatorResources: &v1.CiliumOperatorResources{Requests: map[string]string{"cpu": "100m0"}}},
+		{OperatorResources: &v1.CiliumOperatorResources{Limits: map[string]string{"memory": "lots"}}},
+		{OperatorTolerations: []v1.CiliumToleration{{Key: "a", Operator: "In"}}},
+		{OperatorTolerations: []v1.CiliumToleration{{Key: "a", Operator: "Exists", Value: "b"}}},
+		{OperatorTolerations: []v1.CiliumToleration{{Operator: "Equal", Value: "b"}}},
+		{OperatorTolerations: []v1.CiliumToleration{{Key: "a", Effect: "NoRun"}}},
+	}
+	for i, c := range invalid {
+		stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Cilium: c}, &v1.Networking{})
+		if err := stepper.Validate(); err == nil {
+			t.Errorf("case %d: Validate() expected error", i)
+		}
+	}
+}
+
+func TestCiliumRunnable_agentPlacement(t *testing.T) {
+	metadata := &component.ExtraMetadata{
+		CRI:     v1.CRIContainerd,
+		Masters: component.NodeList{{ID: "node1", Hostname: "master1"}},
+		Workers: component.NodeList{
+			{ID: "node2", Hostname: "worker1", Labels: map[string]string{"kubeclipper.io/cni": "cilium"}},
+			{ID: "node3", Hostname: "gpu1", Labels: map[string]string{"nvidia.com/gpu": "true"}},
+		},
+	}
+	newStepper := func(c *v1.Cilium) *CiliumRunnable {
+		return (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Type: "cilium", Version: "1.14.4", Offline: true, Cilium: c}, &v1.Networking{}).(*CiliumRunnable)
+	}
+	placement := &v1.Cilium{
+		NodeSelector: map[string]string{"kubeclipper.io/cni": "cilium"},
+		Tolerations:  []v1.CiliumToleration{{Key: "dedicated", Operator: "Equal", Value: "network", Effect: "NoSchedule"}},
+	}
+	stepper := newStepper(placement)
+	if err := stepper.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	w := &bytes.Buffer{}
+	if err := stepper.renderCiliumTo(w); err != nil {
+		t.Fatalf("renderCiliumTo() error = %v", err)
+	}
+	for _, want := range []string{
+		"\nnodeSelector: {\"kubeclipper.io/cni\":\"cilium\"}\n",
+		"\ntolerations: [{\"key\":\"dedicated\",\"operator\":\"Equal\",\"value\":\"network\",\"effect\":\"NoSchedule\"}]\n",
+	} {
+		if !strings.Contains(w.String(), want) {
+			t.Errorf("renderCiliumTo() output does not contain %q:\n%s", want, w.String())
+		}
+	}
+
+	all := stepper.allNodes
+	steps, err := stepper.LoadImage(all)
+	if err != nil {
+		t.Fatalf("LoadImage() error = %v", err)
+	}
+	if load := stepByName(steps, "cniImageLoader"); !reflect.DeepEqual(load.Nodes, []v1.StepNode{all[1]}) {
+		t.Errorf("cniImageLoader nodes = %v, want the nodes matching the agent node selector", load.Nodes)
+	}
+	if steps, err = stepper.JoinNodeSteps(all[2:]); err != nil || len(steps) != 0 {
+		t.Errorf("JoinNodeSteps() of a node without the agent = %v, %v", stepNames(steps), err)
+	}
+	// the nodes unknown to the metadata are not skipped
+	if steps, err = stepper.LoadImage([]v1.StepNode{{ID: "node4"}}); err != nil || len(steps) != 1 {
+		t.Errorf("LoadImage() of an unknown node = %v, %v", stepNames(steps), err)
+	}
+	// the well-known kubelet labels select nodes as well
+	if nodes := newStepper(&v1.Cilium{NodeSelector: map[string]string{"kubernetes.io/hostname": "gpu1"}}).agentNodes(all); !reflect.DeepEqual(nodes, all[2:]) {
+		t.Errorf("agentNodes() = %v, want %v", nodes, all[2:])
+	}
+
+	invalid := []*v1.Cilium{
+		{NodeSelector: map[string]string{"kubeclipper.io/cni": "calico"}},
+		{NodeSelector: map[string]string{"invalid key!": "a"}},
+		{NodeSelector: map[string]string{"a": "invalid value!"}},
+		{Tolerations: []v1.CiliumToleration{{Key: "a", Operator: "In"}}},
+	}
+	for i, c := range invalid {
+		if err := newStepper(c).Validate(); err == nil {
+			t.Errorf("case %d: Validate() expected error", i)
+		}
+	}
+
+	if hash := AgentPlacementHash(&v1.CNI{Type: "cilium", Cilium: &v1.Cilium{}}); hash != "" {
+		t.Errorf("AgentPlacementHash() without placement = %q, want empty", hash)
+	}
+	hash := AgentPlacementHash(&v1.CNI{Type: "cilium", Cilium: placement})
+	changed := placement.DeepCopy()
+	changed.NodeSelector["kubeclipper.io/cni"] = "cilium-1"
+	if hash == "" || hash == AgentPlacementHash(&v1.CNI{Type: "cilium", Cilium: changed}) {
+		t.Errorf("AgentPlacementHash() = %q, want it to change with the node selector", hash)
+	}
+}
+
+func TestCiliumRunnable_agentResources(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *v1.Cilium
+		wantRender  []string
+		wantMissing []string
+		wantErr     bool
+	}{
+		{
+			name:        "unset",
+			config:      &v1.Cilium{},
+			wantRender:  []string{"\npriorityClassName: system-node-critical\n"},
+			wantMissing: []string{"\nresources:"},
+		},
+		{
+			name:        "empty resources",
+			config:      &v1.Cilium{AgentResources: &v1.CiliumOperatorResources{Requests: map[string]string{}}},
+			wantMissing: []string{"\nresources:"},
+		},
+		{
+			name: "set",
+			config: &v1.Cilium{
+				AgentResources:    &v1.CiliumOperatorResources{Requests: map[string]string{"cpu": "100m", "memory": "512Mi"}, Limits: map[string]string{"memory": "1Gi"}},
+				PriorityClassName: "network-critical",
+			},
+			wantRender:  []string{"\nresources: {\"requests\":{\"cpu\":\"100m\",\"memory\":\"512Mi\"},\"limits\":{\"memory\":\"1Gi\"}}\n", "\npriorityClassName: network-critical\n"},
+			wantMissing: []string{"system-node-critical"},
+		},
+		{name: "invalid quantity", config: &v1.Cilium{AgentResources: &v1.CiliumOperatorResources{Limits: map[string]string{"memory": "1 GB"}}}, wantErr: true},
+		{name: "invalid priority class", config: &v1.Cilium{PriorityClassName: "Critical!"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.14.4", Cilium: tt.config}, &v1.Networking{}).(*CiliumRunnable)
+			if err := stepper.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			w := &bytes.Buffer{}
+			if err := stepper.renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			for _, want := range tt.wantRender {
+				if !strings.Contains(w.String(), want) {
+					t.Errorf("renderCiliumTo() output does not contain %q:\n%s", want, w.String())
+				}
+			}
+			for _, unwanted := range tt.wantMissing {
+				if strings.Contains(w.String(), unwanted) {
+					t.Errorf("renderCiliumTo() output contains %q:\n%s", unwanted, w.String())
+				}
+			}
+		})
+	}
+
+	// the refresh upgrade applies the changed resources and priority class
+	if hash := AgentPlacementHash(&v1.CNI{Type: "cilium", Cilium: &v1.Cilium{AgentResources: &v1.CiliumOperatorResources{}}}); hash != "" {
+		t.Errorf("AgentPlacementHash() with empty resources = %q, want empty", hash)
+	}
+	resources := &v1.Cilium{AgentResources: &v1.CiliumOperatorResources{Limits: map[string]string{"memory": "1Gi"}}}
+	hash := AgentPlacementHash(&v1.CNI{Type: "cilium", Cilium: resources})
+	changed := resources.DeepCopy()
+	changed.AgentResources.Limits["memory"] = "2Gi"
+	if hash == "" || hash == AgentPlacementHash(&v1.CNI{Type: "cilium", Cilium: changed}) {
+		t.Errorf("AgentPlacementHash() = %q, want it to change with the resources", hash)
+	}
+}
+
+func TestCiliumRunnable_offlineImages(t *testing.T) {
+	tests := []struct {
+		name      string
+		cri       string
+		wantPrune []string
+		wantErr   bool
+	}{
+		{
+			name: "containerd",
+			cri:  v1.CRIContainerd,
+			wantPrune: []string{"/bin/bash", "-c",
+				`nerdctl --namespace k8s.io images --format '{{.Repository}}:{{.Tag}}' | awk -v prefix='quay.io/cilium/' 'index($0, prefix) == 1' | xargs -r nerdctl --namespace k8s.io rmi`},
+		},
+		{
+			name: "docker",
+			cri:  v1.CRIDocker,
+			wantPrune: []string{"/bin/bash", "-c",
+				`docker images --format '{{.Repository}}:{{.Tag}}' | awk -v prefix='quay.io/cilium/' 'index($0, prefix) == 1' | xargs -r docker rmi`},
+		},
+		{name: "unknown", cri: "podman", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{CRI: tt.cri}, &v1.CNI{Version: "1.14.4", Offline: true}, &v1.Networking{})
+			if _, err := stepper.LoadImage([]v1.StepNode{{ID: "node1"}}); (err != nil) != tt.wantErr {
+				t.Fatalf("LoadImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			steps, err := stepper.UninstallSteps([]v1.StepNode{{ID: "node1"}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UninstallSteps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			prune := steps[len(steps)-1]
+			if prune.Name != "pruneCiliumImages" || !reflect.DeepEqual(prune.Commands[0].ShellCommand, tt.wantPrune) {
+				t.Errorf("last uninstall step %s = %q, want pruneCiliumImages %q", prune.Name, prune.Commands[0].ShellCommand, tt.wantPrune)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_LoadImageByArch(t *testing.T) {
+	stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{CRI: v1.CRIContainerd}, &v1.CNI{Version: "1.14.4", Offline: true}, &v1.Networking{})
+	nodes := []v1.StepNode{{ID: "node1", Arch: "amd64"}, {ID: "node2", Arch: "arm64"}, {ID: "node3", Arch: "amd64"}}
+	steps, err := stepper.LoadImage(nodes)
+	if err != nil {
+		t.Fatalf("LoadImage() error = %v", err)
+	}
+	if got := stepNames(steps); !reflect.DeepEqual(got, []string{"cniImageLoader-amd64", "cniImageLoader-arm64"}) {
+		t.Fatalf("LoadImage() = %v", got)
+	}
+	for i, want := range []struct {
+		arch  string
+		nodes []v1.StepNode
+	}{{"amd64", []v1.StepNode{nodes[0], nodes[2]}}, {"arm64", nodes[1:2]}} {
+		if !reflect.DeepEqual(steps[i].Nodes, want.nodes) {
+			t.Errorf("%s nodes = %v, want %v", steps[i].Name, steps[i].Nodes, want.nodes)
+		}
+		if !strings.Contains(string(steps[i].Commands[0].CustomCommand), `"arch":"`+want.arch+`"`) {
+			t.Errorf("%s does not load the %s package: %s", steps[i].Name, want.arch, steps[i].Commands[0].CustomCommand)
+		}
+	}
+
+	steps, err = stepper.LoadImage([]v1.StepNode{{ID: "node1"}, {ID: "node2"}})
+	if err != nil {
+		t.Fatalf("LoadImage() error = %v", err)
+	}
+	if got := stepNames(steps); !reflect.DeepEqual(got, []string{"cniImageLoader"}) || strings.Contains(string(steps[0].Commands[0].CustomCommand), `"arch"`) {
+		t.Errorf("LoadImage() of nodes without arch = %v, want a single step for the agent architecture", got)
+	}
+
+	if _, err = (&BaseCni{Arch: "not-" + runtime.GOARCH}).Install(context.TODO(), component.Options{DryRun: true}); err == nil {
+		t.Errorf("Install() of the image package of another architecture should fail")
+	}
+}
+
+func TestCiliumRunnable_NodeConcurrency(t *testing.T) {
+	stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{CRI: v1.CRIContainerd}, &v1.CNI{Version: "1.14.4", Offline: true}, &v1.Networking{})
+	nodes := []v1.StepNode{{ID: "node1", Arch: "amd64"}, {ID: "node2", Arch: "arm64"}}
+	loadSteps, err := stepper.LoadImage(nodes)
+	if err != nil {
+		t.Fatalf("LoadImage() error = %v", err)
+	}
+	leaveSteps, err := stepper.(*CiliumRunnable).LeaveNodeSteps(nodes)
+	if err != nil {
+		t.Fatalf("LeaveNodeSteps() error = %v", err)
+	}
+	for _, step := range append(loadSteps, leaveSteps...) {
+		if step.Concurrency != ciliumNodeConcurrency || step.FailFast {
+			t.Errorf("%s concurrency = %d, fail fast = %v, want %d and false", step.Name, step.Concurrency, step.FailFast, ciliumNodeConcurrency)
+		}
+	}
+}
+
+func TestCiliumRunnable_timeouts(t *testing.T) {
+	// the default retry policy gives each of the 4 attempts the step timeout and adds its delays, 10s, 20s and 40s
+	const retryDelays = 70 * time.Second
+	attempt := func(step v1.Step) time.Duration { return (step.Timeout.Duration - retryDelays) / 4 }
+	tests := []struct {
+		name          string
+		timeouts      *v1.CNITimeouts
+		wantInstall   time.Duration
+		wantUninstall time.Duration
+		wantImageLoad time.Duration
+		wantErr       bool
+	}{
+		{name: "default", wantInstall: 2 * time.Minute, wantUninstall: time.Minute, wantImageLoad: 5 * time.Minute},
+		{
+			name: "custom",
+			timeouts: &v1.CNITimeouts{
+				Install:   metav1.Duration{Duration: 10 * time.Minute},
+				Uninstall: metav1.Duration{Duration: 3 * time.Minute},
+				ImageLoad: metav1.Duration{Duration: 20 * time.Minute},
+			},
+			wantInstall: 11 * time.Minute, wantUninstall: 3 * time.Minute, wantImageLoad: 20 * time.Minute,
+		},
+		{
+			name:        "partial",
+			timeouts:    &v1.CNITimeouts{Install: metav1.Duration{Duration: 30 * time.Second}},
+			wantInstall: 90 * time.Second, wantUninstall: time.Minute, wantImageLoad: 5 * time.Minute,
+		},
+		{name: "too short", timeouts: &v1.CNITimeouts{Uninstall: metav1.Duration{Duration: 10 * time.Second}}, wantErr: true},
+		{name: "too long", timeouts: &v1.CNITimeouts{ImageLoad: metav1.Duration{Duration: 2 * time.Hour}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{CRI: v1.CRIContainerd},
+				&v1.CNI{Version: "1.14.4", Offline: true, Timeouts: tt.timeouts}, &v1.Networking{})
+			if err := stepper.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			nodes := []v1.StepNode{{ID: "node1"}}
+			steps, err := stepper.InstallSteps(nodes, "v1.27.4")
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			if got := attempt(stepByName(steps, "installCiliumRelease")); got != tt.wantInstall {
+				t.Errorf("installCiliumRelease timeout = %v, want %v", got, tt.wantInstall)
+			}
+			if steps, err = stepper.UninstallSteps(nodes); err != nil {
+				t.Fatalf("UninstallSteps() error = %v", err)
+			}
+			if got := attempt(stepByName(steps, "uninstallCiliumRelease")); got != tt.wantUninstall {
+				t.Errorf("uninstallCiliumRelease timeout = %v, want %v", got, tt.wantUninstall)
+			}
+			if steps, err = stepper.LoadImage(nodes); err != nil {
+				t.Fatalf("LoadImage() error = %v", err)
+			}
+			if got := attempt(stepByName(steps, "cniImageLoader")); got != tt.wantImageLoad {
+				t.Errorf("cniImageLoader timeout = %v, want %v", got, tt.wantImageLoad)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_retryPolicy(t *testing.T) {
+	retryTimes := func(n int32) *int32 { return &n }
+	tests := []struct {
+		name         string
+		policy       *v1.CNIRetryPolicy
+		wantTimes    int32
+		wantInterval time.Duration
+		wantFactor   int32
+		wantDelays   time.Duration
+		wantErr      bool
+	}{
+		{name: "default", wantTimes: 3, wantInterval: 10 * time.Second, wantFactor: 2, wantDelays: 70 * time.Second},
+		{
+			name:      "custom",
+			policy:    &v1.CNIRetryPolicy{RetryTimes: retryTimes(2), RetryInterval: &metav1.Duration{Duration: 5 * time.Second}, BackoffFactor: 3},
+			wantTimes: 2, wantInterval: 5 * time.Second, wantFactor: 3, wantDelays: 20 * time.Second,
+		},
+		{
+			name:      "no retries",
+			policy:    &v1.CNIRetryPolicy{RetryTimes: retryTimes(0)},
+			wantTimes: 0, wantInterval: 10 * time.Second, wantFactor: 2,
+		},
+		{
+			name:      "immediate retries",
+			policy:    &v1.CNIRetryPolicy{RetryInterval: &metav1.Duration{}},
+			wantTimes: 3, wantFactor: 2,
+		},
+		{name: "too many retries", policy: &v1.CNIRetryPolicy{RetryTimes: retryTimes(11)}, wantErr: true},
+		{name: "negative interval", policy: &v1.CNIRetryPolicy{RetryInterval: &metav1.Duration{Duration: -time.Second}}, wantErr: true},
+		{name: "too long interval", policy: &v1.CNIRetryPolicy{RetryInterval: &metav1.Duration{Duration: time.Hour}}, wantErr: true},
+		{name: "too large factor", policy: &v1.CNIRetryPolicy{BackoffFactor: 11}, wantErr: true},
+		{name: "negative factor", policy: &v1.CNIRetryPolicy{BackoffFactor: -1}, wantErr: true},
+		{name: "default factor", policy: &v1.CNIRetryPolicy{BackoffFactor: 0}, wantTimes: 3, wantInterval: 10 * time.Second, wantFactor: 2, wantDelays: 70 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1"}}}
+			stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", RetryPolicy: tt.policy,
+				Cilium: &v1.Cilium{Encryption: &v1.CiliumEncryption{Type: CiliumEncryptionWireguard}}}, &v1.Networking{})
+			if err := stepper.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			nodes := []v1.StepNode{{ID: "node1"}}
+			install, err := stepper.InstallSteps(nodes, "")
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			// UninstallSteps includes the steps of LeaveNodeSteps, their timeouts must be extended once
+			uninstall, err := stepper.UninstallSteps(nodes)
+			if err != nil {
+				t.Fatalf("UninstallSteps() error = %v", err)
+			}
+			notRetried := sets.NewString("checkCiliumWireguard", "preflightCilium-node1", "reportCiliumPreflight")
+			for _, step := range append(install, uninstall...) {
+				if notRetried.Has(step.Name) {
+					continue
+				}
+				if step.RetryTimes != tt.wantTimes || step.RetryInterval.Duration != tt.wantInterval || step.RetryBackoffFactor != tt.wantFactor {
+					t.Errorf("step %s retry policy = %d, %s, %d, want %d, %s, %d", step.Name, step.RetryTimes, step.RetryInterval.Duration,
+						step.RetryBackoffFactor, tt.wantTimes, tt.wantInterval, tt.wantFactor)
+				}
+			}
+			// every attempt gets the step timeout
+			want := 30*time.Second*time.Duration(tt.wantTimes+1) + tt.wantDelays
+			if got := stepByName(uninstall, "clearCiliumNode").Timeout.Duration; got != want {
+				t.Errorf("clearCiliumNode timeout = %s, want %s", got, want)
+			}
+			// the deterministic checks are not retried
+			for _, name := range notRetried.List() {
+				if step := stepByName(install, name); step.RetryTimes != 0 || step.RetryInterval.Duration != 0 || step.Timeout.Duration != 30*time.Second {
+					t.Errorf("%s retry policy = %d, %s, timeout %s, want no retries", name, step.RetryTimes, step.RetryInterval.Duration, step.Timeout.Duration)
+				}
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_GetImages(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		registry string
+		cilium   *v1.Cilium
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:    "1.14 default",
+			version: "1.14.4",
+			want:    []string{"quay.io/cilium/cilium:v1.14.4", "quay.io/cilium/operator-generic:v1.14.4"},
+		},
+		{
+			name:    "1.14 hubble ui",
+			version: "1.14.4",
+			cilium:  &v1.Cilium{EnableHubble: true, EnableHubbleUI: true},
+			want: []string{"quay.io/cilium/cilium:v1.14.4", "quay.io/cilium/operator-generic:v1.14.4",
+				"quay.io/cilium/certgen:v0.1.9", "quay.io/cilium/hubble-relay:v1.14.4",
+				"quay.io/cilium/hubble-ui:v0.12.1", "quay.io/cilium/hubble-ui-backend:v0.12.1"},
+		},
+		{
+			name:     "1.16 local registry",
+			version:  "v1.16.1",
+			registry: "registry.local:5000/",
+			cilium:   &v1.Cilium{EnableHubble: true, EnableHubbleRelay: true},
+			want: []string{"registry.local:5000/cilium/cilium:v1.16.1", "registry.local:5000/cilium/operator-generic:v1.16.1",
+				"registry.local:5000/cilium/cilium-envoy:v1.29.7-39a2a56bbd5b3a591f69dbca51d3e30ef97e0e51",
+				"registry.local:5000/cilium/certgen:v0.2.0", "registry.local:5000/cilium/hubble-relay:v1.16.1"},
+		},
+		{name: "unsupported version", version: "1.10.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{LocalRegistry: tt.registry, Cilium: tt.cilium}, &v1.Networking{})
+			got, err := stepper.GetImages(tt.version, v1.CRIContainerd)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetImages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetImages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_imageRetags(t *testing.T) {
+	tests := []struct {
+		name     string
+		registry string
+		want     []RegistryImage
+	}{
+		{name: "upstream names"},
+		{
+			name:     "local registry",
+			registry: "registry.local:5000",
+			want: []RegistryImage{
+				{Source: "quay.io/cilium/cilium:v1.14.4", Target: "registry.local:5000/cilium/cilium:v1.14.4"},
+				{Source: "quay.io/cilium/operator-generic:v1.14.4", Target: "registry.local:5000/cilium/operator-generic:v1.14.4"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{CRI: v1.CRIContainerd},
+				&v1.CNI{Version: "1.14.4", Offline: true, LocalRegistry: tt.registry, Cilium: &v1.Cilium{}}, &v1.Networking{})
+			got, err := stepper.(*CiliumRunnable).imageRetags()
+			if err != nil {
+				t.Fatalf("imageRetags() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("imageRetags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_completeDefaults(t *testing.T) {
+	networking := &v1.Networking{Pods: v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}}}
+	tests := []struct {
+		name   string
+		cilium *v1.Cilium
+		want   *v1.Cilium
+	}{
+		{
+			name: "no config",
+			want: &v1.Cilium{IPAMMode: CiliumIPAMClusterPool, ClusterPoolIPv4PodCIDRList: []string{ciliumDefaultIPv4PodCIDR},
+				ClusterPoolIPv4MaskSize: ciliumDefaultIPv4MaskSize},
+		},
+		{
+			name:   "ipam mode",
+			cilium: &v1.Cilium{EnableHubble: true},
+			want:   &v1.Cilium{IPAMMode: CiliumIPAMClusterPool, EnableHubble: true},
+		},
+		{
+			name:   "pools",
+			cilium: &v1.Cilium{Pools: []v1.CiliumPodIPPool{{Name: "default", CIDR: "10.10.0.0/16", MaskSize: 24}}},
+			want:   &v1.Cilium{Pools: []v1.CiliumPodIPPool{{Name: "default", CIDR: "10.10.0.0/16", MaskSize: 24}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before v1.Cilium
+			if tt.cilium != nil {
+				before = *tt.cilium
+			}
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.14.4", Cilium: tt.cilium}, networking).(*CiliumRunnable)
+			if !reflect.DeepEqual(stepper.CiliumConfig, tt.want) {
+				t.Errorf("CiliumConfig = %+v, want %+v", stepper.CiliumConfig, tt.want)
+			}
+			if tt.cilium != nil && !reflect.DeepEqual(*tt.cilium, before) {
+				t.Errorf("InitStep() modified the cilium configuration to %+v", tt.cilium)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_validateClusterPool(t *testing.T) {
+	nodes := []component.Node{
+		{ID: "node1", IPv4: "10.0.0.11", NodeIPv4: "10.0.0.11"},
+		{ID: "node2", IPv4: "10.0.0.12", NodeIPv4: "10.0.0.12"},
+		{ID: "node3", IPv4: "10.0.0.13", NodeIPv4: "10.0.0.13"},
+	}
+	tests := []struct {
+		name    string
+		cilium  *v1.Cilium
+		nodes   []component.Node
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			cilium: &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"172.25.0.0/16"}, ClusterPoolIPv4MaskSize: 24},
+		},
+		{
+			name: "default pool",
+		},
+		{
+			name: "default pool overlaps node",
+			nodes: []component.Node{
+				{ID: "node1", IPv4: "192.168.64.11", NodeIPv4: "192.168.64.11"},
+				{ID: "node2", IPv4: "192.168.64.12", NodeIPv4: "192.168.64.12"},
+			},
+			wantErr: "overlaps the node address 192.168.64.11",
+		},
+		{
+			name:    "invalid cidr",
+			cilium:  &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"172.25.0.0/33"}, ClusterPoolIPv4MaskSize: 24},
+			wantErr: "invalid pod CIDR",
+		},
+		{
+			name:    "overlaps service",
+			cilium:  &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.96.0.0/12"}, ClusterPoolIPv4MaskSize: 24},
+			wantErr: "overlaps the service CIDR",
+		},
+		{
+			name:    "overlaps node",
+			cilium:  &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"}, ClusterPoolIPv4MaskSize: 24},
+			wantErr: "overlaps the node address 10.0.0.11",
+		},
+		{
+			name:    "mask size not larger than prefix",
+			cilium:  &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"172.25.0.0/24"}, ClusterPoolIPv4MaskSize: 24},
+			wantErr: "must be larger than the prefix length",
+		},
+		{
+			name:    "pool too small",
+			cilium:  &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"172.25.0.0/24"}, ClusterPoolIPv4MaskSize: 25},
+			wantErr: "can only allocate 2 nodes, the cluster has 3 nodes",
+		},
+		{
+			name:   "pool fits with multiple cidrs",
+			cilium: &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"172.25.0.0/24", "172.26.0.0/24"}, ClusterPoolIPv4MaskSize: 25},
+		},
+		{
+			name:   "kubernetes ipam skipped",
+			cilium: &v1.Cilium{IPAMMode: "kubernetes", ClusterPoolIPv4PodCIDRList: []string{"10.96.0.0/12"}, ClusterPoolIPv4MaskSize: 24},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networking := &v1.Networking{Services: v1.NetworkRanges{CIDRBlocks: []string{"10.96.0.0/12"}}}
+			clusterNodes := nodes
+			if tt.nodes != nil {
+				clusterNodes = tt.nodes
+			}
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{Masters: clusterNodes[:1], Workers: clusterNodes[1:]},
+				&v1.CNI{Cilium: tt.cilium}, networking)
+			err := stepper.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_RenderString(t *testing.T) {
+	tests := []struct {
+		name   string
+		cni    v1.CNI
+		golden string
+	}{
+		{
+			name:   "default",
+			cni:    v1.CNI{Type: "cilium", Version: "1.14.4"},
+			golden: "cilium-default",
+		},
+		{
+			name: "fully populated",
+			cni: v1.CNI{
+				Type:          "cilium",
+				Version:       "1.14.4",
+				LocalRegistry: "registry.local:5000",
+				Cilium: &v1.Cilium{
+					IPAMMode:                   "cluster-pool",
+					ClusterPoolIPv4PodCIDRList: []string{"172.25.0.0/16"},
+					ClusterPoolIPv4MaskSize:    24,
+					KubeProxyReplacement:       CiliumKubeProxyReplacementStrict,
+					OperatorReplicas:           2,
+					EnableHubble:               true,
+					EnableHubbleRelay:          true,
+					EnableHubbleUI:             true,
+					TunnelMode:                 CiliumTunnelDisabled,
+					Encryption:                 &v1.CiliumEncryption{Type: CiliumEncryptionIPsec},
+				},
+			},
+			golden: "cilium-full",
+		},
+		{
+			name: "snat random load balancer",
+			cni: v1.CNI{
+				Type:    "cilium",
+				Version: "1.15.1",
+				Cilium: &v1.Cilium{
+					OperatorReplicas:      1,
+					LoadBalancerMode:      CiliumLBModeSNAT,
+					LoadBalancerAlgorithm: CiliumLBAlgorithmRandom,
+				},
+			},
+			golden: "cilium-lb-snat-random",
+		},
+		{
+			name: "dsr maglev load balancer",
+			cni: v1.CNI{
+				Type:    "cilium",
+				Version: "1.15.1",
+				Cilium: &v1.Cilium{
+					OperatorReplicas:      1,
+					KubeProxyReplacement:  CiliumKubeProxyReplacementTrue,
+					TunnelMode:            CiliumTunnelDisabled,
+					NativeRoutingCIDR:     "172.25.0.0/16",
+					LoadBalancerMode:      CiliumLBModeDSR,
+					LoadBalancerAlgorithm: CiliumLBAlgorithmMaglev,
+					SocketLB:              true,
+				},
+			},
+			golden: "cilium-lb-dsr-maglev",
+		},
+		{
+			name: "aws-cni chaining",
+			cni: v1.CNI{
+				Type:    "cilium",
+				Version: "1.15.1",
+				Cilium:  &v1.Cilium{OperatorReplicas: 1, CNIChainingMode: CiliumChainingAWSCNI},
+			},
+			golden: "cilium-chaining-aws-cni",
+		},
+		{
+			name: "generic-veth chaining",
+			cni: v1.CNI{
+				Type:    "cilium",
+				Version: "1.15.1",
+				Cilium:  &v1.Cilium{OperatorReplicas: 1, CNIChainingMode: CiliumChainingGenericVeth, CustomCNIConf: "cni-configuration"},
+			},
+			golden: "cilium-chaining-generic-veth",
+		},
+		{
+			name:   "minimal profile",
+			cni:    v1.CNI{Type: "cilium", Version: "1.15.1", Cilium: &v1.Cilium{Profile: CiliumProfileMinimal}},
+			golden: "cilium-profile-minimal",
+		},
+		{
+			name:   "default profile",
+			cni:    v1.CNI{Type: "cilium", Version: "1.15.1", Cilium: &v1.Cilium{Profile: CiliumProfileDefault}},
+			golden: "cilium-profile-default",
+		},
+		{
+			name: "production profile",
+			cni: v1.CNI{
+				Type:    "cilium",
+				Version: "1.15.1",
+				Cilium: &v1.Cilium{
+					Profile:           CiliumProfileProduction,
+					OperatorResources: &v1.CiliumOperatorResources{Requests: map[string]string{"memory": "256Mi"}},
+					HelmValues:        "prometheus:\n  enabled: false\n",
+				},
+			},
+			golden: "cilium-profile-production",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networking := &v1.Networking{
+				DNSDomain: "cluster.local",
+				Services:  v1.NetworkRanges{CIDRBlocks: []string{"10.96.0.0/12"}},
+				Pods:      v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}},
+			}
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &tt.cni, networking)
+			if err := stepper.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			got, err := stepper.RenderString(context.Background())
+			if err != nil {
+				t.Fatalf("RenderString() error = %v", err)
+			}
+			assertGolden(t, tt.golden, []byte(got))
+		})
+	}
+}
+
+func TestCiliumRunnable_MTU(t *testing.T) {
+	render := func(c *v1.Cilium) string {
+		stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Cilium: c}, &v1.Networking{})
+		if err := stepper.Validate(); err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+		values, err := stepper.(*CiliumRunnable).RenderString(context.TODO())
+		if err != nil {
+			t.Fatalf("RenderString() error = %v", err)
+		}
+		return values
+	}
+	if values := render(&v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"}, ClusterPoolIPv4MaskSize: 24, MTU: 8950}); !strings.Contains(values, "\nMTU: 8950\n") {
+		t.Errorf("values do not contain the MTU:\n%s", values)
+	}
+	if values := render(&v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"}, ClusterPoolIPv4MaskSize: 24}); strings.Contains(values, "MTU:") {
+		t.Errorf("values without MTU should not render it:\n%s", values)
+	}
+
+	for _, c := range []*v1.Cilium{{MTU: 1400, AutoDetectMTU: true}, {MTU: 100}} {
+		c.ClusterPoolIPv4PodCIDRList, c.ClusterPoolIPv4MaskSize = []string{"10.0.0.0/16"}, 24
+		stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Cilium: c}, &v1.Networking{})
+		if err := stepper.Validate(); err == nil {
+			t.Errorf("Validate() with mtu %d and autoDetectMTU %v should fail", c.MTU, c.AutoDetectMTU)
+		}
+	}
+
+	metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1", Hostname: "node1"}}, Workers: component.NodeList{{ID: "node2", Hostname: "node2"}}}
+	config := &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"}, ClusterPoolIPv4MaskSize: 24, AutoDetectMTU: true}
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", Cilium: config}, &v1.Networking{}).(*CiliumRunnable)
+	steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1", Hostname: "node1"}}, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	names := strings.Join(stepNames(steps), ",")
+	if !strings.Contains(names, "detectCiliumMTU-node1,detectCiliumMTU-node2,renderCniYaml") {
+		t.Errorf("InstallSteps() = %s, want the MTU detection of every node before the values are rendered", names)
+	}
+	if got := stepByName(steps, "detectCiliumMTU-node2").Nodes; len(got) != 1 || got[0].ID != "node2" {
+		t.Errorf("detectCiliumMTU-node2 nodes = %v, want node2", got)
+	}
+
+	// every detection step replies the minimum of the previous reply and its own MTU
+	ctx := component.WithExtraData(context.TODO(), []byte(`{"mtu":1450}`))
+	reply, err := (&CiliumMTU{}).Install(ctx, component.Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if string(reply) != `{"mtu":1450}` {
+		t.Errorf("Install() = %s, want the MTU of the previous step", reply)
+	}
+	if got := lastMTU(component.WithExtraData(context.TODO(), []byte("join command"))); got != 0 {
+		t.Errorf("lastMTU() of an unrelated reply = %d, want 0", got)
+	}
+
+	if got := stepper.tunnelOverhead(); got != 50 {
+		t.Errorf("tunnelOverhead() = %d, want 50 for vxlan", got)
+	}
+	native := &CiliumRunnable{CiliumConfig: &v1.Cilium{TunnelMode: CiliumTunnelDisabled}}
+	if got := native.tunnelOverhead(); got != 0 {
+		t.Errorf("tunnelOverhead() = %d, want 0 for native routing", got)
+	}
+}
+
+func TestCiliumRunnable_Metrics(t *testing.T) {
+	newStepper := func(metrics *v1.CiliumMetrics, hubble bool) *CiliumRunnable {
+		c := &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"}, ClusterPoolIPv4MaskSize: 24, EnableHubble: hubble, Metrics: metrics}
+		return (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.14.4", Cilium: c}, &v1.Networking{}).(*CiliumRunnable)
+	}
+	stepper := newStepper(&v1.CiliumMetrics{EnablePrometheus: true, EnableServiceMonitor: true, HubbleMetrics: []string{"dns:query;ignoreAAAA", "drop"}}, true)
+	if err := stepper.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	values, err := stepper.RenderString(context.TODO())
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	rendered := make(map[string]interface{})
+	if err = yaml.Unmarshal([]byte(values), &rendered); err != nil {
+		t.Fatalf("invalid values: %v\n%s", err, values)
+	}
+	for _, want := range []string{
+		"operator:\n  replicas: 1\n  prometheus:\n    enabled: true\n    serviceMonitor:\n      enabled: true\n",
+		"\nprometheus:\n  enabled: true\n  serviceMonitor:\n    enabled: true\n",
+		"  metrics:\n    enabled: [\"dns:query;ignoreAAAA\",\"drop\"]\n    serviceMonitor:\n      enabled: true\n",
+	} {
+		if !strings.Contains(values, want) {
+			t.Errorf("values do not contain %q:\n%s", want, values)
+		}
+	}
+	steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	if names := stepNames(withoutPreflight(steps)); names[0] != "checkServiceMonitorCRD" {
+		t.Errorf("InstallSteps() = %v, want the ServiceMonitor CRD check first", names)
+	}
+
+	values, err = newStepper(nil, false).RenderString(context.TODO())
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	if strings.Contains(values, "prometheus") {
+		t.Errorf("values without metrics should not enable prometheus:\n%s", values)
+	}
+	steps, err = newStepper(&v1.CiliumMetrics{EnablePrometheus: true}, false).InstallSteps([]v1.StepNode{{ID: "node1"}}, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	if stepByName(steps, "checkServiceMonitorCRD").Name != "" {
+		t.Errorf("InstallSteps() without ServiceMonitor should not check the CRD")
+	}
+
+	for _, metrics := range []*v1.CiliumMetrics{{HubbleMetrics: []string{"dns"}}, {EnableServiceMonitor: true}, {HubbleMetrics: []string{""}}} {
+		if err := newStepper(metrics, metrics.HubbleMetrics != nil && metrics.HubbleMetrics[0] == "").Validate(); err == nil {
+			t.Errorf("Validate() with metrics %+v should fail", metrics)
+		}
+	}
+}
+
+func TestCiliumRunnable_ClusterMesh(t *testing.T) {
+	newStepper := func(mesh *v1.CiliumClusterMesh) *CiliumRunnable {
+		c := &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"}, ClusterPoolIPv4MaskSize: 24, ClusterMesh: mesh}
+		metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1", Hostname: "node1"}}}
+		return (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", LocalRegistry: "10.0.0.1:5000", Cilium: c}, &v1.Networking{}).(*CiliumRunnable)
+	}
+	stepper := newStepper(&v1.CiliumClusterMesh{ClusterName: "east", ClusterID: 1, EnableClusterMesh: true, APIServerServiceType: CiliumMeshServiceLoadBalancer})
+	if err := stepper.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	values, err := stepper.RenderString(context.TODO())
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	for _, want := range []string{
+		"\ncluster:\n  name: east\n  id: 1\n",
+		"\nclustermesh:\n  useAPIServer: true\n  apiserver:\n    service:\n      type: LoadBalancer\n",
+		"repository: 10.0.0.1:5000/cilium/clustermesh-apiserver\n",
+		"repository: 10.0.0.1:5000/coreos/etcd\n",
+	} {
+		if !strings.Contains(values, want) {
+			t.Errorf("values do not contain %q:\n%s", want, values)
+		}
+	}
+	images, err := stepper.GetImages("1.14.4", v1.CRIContainerd)
+	if err != nil {
+		t.Fatalf("GetImages() error = %v", err)
+	}
+	if !strings.Contains(strings.Join(images, " "), "/cilium/clustermesh-apiserver:") {
+		t.Errorf("GetImages() = %v, want the clustermesh-apiserver image", images)
+	}
+
+	for _, mesh := range []*v1.CiliumClusterMesh{
+		{ClusterName: "east", ClusterID: 0},
+		{ClusterName: "east", ClusterID: 256},
+		{ClusterName: "East_1", ClusterID: 1},
+		{ClusterName: "east", ClusterID: 1, APIServerServiceType: "ClusterIP"},
+	} {
+		if err := newStepper(mesh).Validate(); err == nil {
+			t.Errorf("Validate() with cluster mesh %+v should fail", mesh)
+		}
+	}
+
+	peer := newStepper(&v1.CiliumClusterMesh{ClusterName: "west", ClusterID: 2, EnableClusterMesh: true})
+	steps, err := stepper.ClusterMeshSteps(peer, []byte("kubeconfig"), []v1.StepNode{{ID: "node1"}})
+	if err != nil {
+		t.Fatalf("ClusterMeshSteps() error = %v", err)
+	}
+	want := []string{"installCiliumCLI", "clusterMesh-syncCA", "clusterMesh-enable", "clusterMesh-connect"}
+	if names := stepNames(steps); strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("ClusterMeshSteps() = %v, want %v", names, want)
+	}
+	for _, mesh := range []*v1.CiliumClusterMesh{
+		{ClusterName: "west", ClusterID: 1, EnableClusterMesh: true},
+		{ClusterName: "east", ClusterID: 2, EnableClusterMesh: true},
+		{ClusterName: "west", ClusterID: 2},
+	} {
+		if _, err := stepper.ClusterMeshSteps(newStepper(mesh), nil, []v1.StepNode{{ID: "node1"}}); err == nil {
+			t.Errorf("ClusterMeshSteps() with peer %+v should fail", mesh)
+		}
+	}
+}
+
+func TestCiliumRunnable_CLI(t *testing.T) {
+	newStepper := func(version, mirror string) *CiliumRunnable {
+		metadata := &component.ExtraMetadata{CRI: v1.CRIContainerd, Masters: component.NodeList{{ID: "node1"}}, Workers: component.NodeList{{ID: "node2"}}}
+		return (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: version, Offline: true, Cilium: &v1.Cilium{CLIMirror: mirror}}, &v1.Networking{}).(*CiliumRunnable)
+	}
+	for version, want := range map[string]string{"1.13.4": "v0.14.8", "1.14.4": "v0.15.23", "1.16.1": "v0.16.19", "1.99.0": ciliumCLIVersionDefault} {
+		if got := newStepper(version, "").cliVersion(); got != want {
+			t.Errorf("cliVersion() of cilium %s = %s, want %s", version, got, want)
+		}
+	}
+
+	stepper := newStepper("1.14.4", "http://mirror.example.com/kc/")
+	if err := stepper.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	install := stepByName(steps, "installCiliumCLI")
+	cli := &CiliumCLI{}
+	if err = json.Unmarshal(install.Commands[0].CustomCommand, cli); err != nil {
+		t.Fatal(err)
+	}
+	if want := (CiliumCLI{Version: "v0.15.23", Offline: true, Mirror: "http://mirror.example.com/kc/"}); *cli != want || !install.ErrIgnore {
+		t.Errorf("installCiliumCLI = %+v, ErrIgnore %v, want %+v and ErrIgnore", *cli, install.ErrIgnore, want)
+	}
+	for _, mirror := range []string{"mirror.example.com", "ftp://mirror.example.com", "http://"} {
+		if err := newStepper("1.14.4", mirror).Validate(); err == nil {
+			t.Errorf("Validate() with cli mirror %q should fail", mirror)
+		}
+	}
+
+	steps, err = stepper.UninstallSteps([]v1.StepNode{{ID: "node2"}})
+	if err != nil {
+		t.Fatalf("UninstallSteps() error = %v", err)
+	}
+	if stepByName(steps, "removeCiliumCLI").Name != "" {
+		t.Errorf("UninstallSteps() of a worker should not remove the cilium CLI")
+	}
+}
+
+func TestCiliumRunnable_ConnectivitySteps(t *testing.T) {
+	metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1"}, {ID: "node2"}}, Workers: component.NodeList{{ID: "node3"}}}
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", LocalRegistry: "10.0.0.1:5000", Cilium: &v1.Cilium{}}, &v1.Networking{}).(*CiliumRunnable)
+	steps, err := stepper.ConnectivitySteps(0)
+	if err != nil {
+		t.Fatalf("ConnectivitySteps() error = %v", err)
+	}
+	want := []string{"ciliumConnectivityTest", "cleanCiliumConnectivityTest"}
+	if names := stepNames(steps); strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("ConnectivitySteps() = %v, want %v", names, want)
+	}
+	for _, step := range steps {
+		if len(step.Nodes) != 1 || step.Nodes[0].ID != "node1" || !step.ErrIgnore {
+			t.Errorf("step %s runs on %v, ErrIgnore %v, want the first master and ErrIgnore", step.Name, step.Nodes, step.ErrIgnore)
+		}
+	}
+	test := &CiliumConnectivity{}
+	if err = json.Unmarshal(steps[0].Commands[0].CustomCommand, test); err != nil {
+		t.Fatal(err)
+	}
+	if test.Timeout != CiliumConnectivityTimeoutDefault || !test.MultiNode || test.TestNamespace != CiliumConnectivityNamespace {
+		t.Errorf("ciliumConnectivityTest = %+v", *test)
+	}
+	if got := test.image(ciliumConnectivityCurlImage); got != "10.0.0.1:5000/cilium/alpine-curl:v1.10.0" {
+		t.Errorf("image() = %s, want the image of LocalRegistry", got)
+	}
+	if len(test.probes()) != 4 {
+		t.Errorf("probes() of a multi node cluster = %d, want 4", len(test.probes()))
+	}
+	if _, err = (&CiliumRunnable{}).ConnectivitySteps(time.Minute); err == nil {
+		t.Errorf("ConnectivitySteps() without master should fail")
+	}
+}
+
+func TestNewPlan(t *testing.T) {
+	metadata := &component.ExtraMetadata{Offline: true, CRI: v1.CRIContainerd,
+		Masters: component.NodeList{{ID: "node1", Hostname: "node1"}}, Workers: component.NodeList{{ID: "node2", Hostname: "node2"}}}
+	plan := func() *Plan {
+		stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", Offline: true}, &v1.Networking{})
+		if err := stepper.Validate(); err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+		p, err := NewPlan(context.TODO(), stepper, true, utils.UnwrapNodeList(metadata.Masters), utils.UnwrapNodeList(metadata.GetAllNodes()), "v1.27.4")
+		if err != nil {
+			t.Fatalf("NewPlan() error = %v", err)
+		}
+		return p
+	}
+	first, err := json.Marshal(plan())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := json.Marshal(plan())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) != string(second) {
+		t.Errorf("the plans of the same spec differ:\n%s\n%s", first, second)
+	}
+	p := plan()
+	if len(p.LoadImage) == 0 || len(p.Install) == 0 || len(p.Uninstall) == 0 || !strings.Contains(p.Values, "ipam:") {
+		t.Fatalf("NewPlan() = %+v, want the load image, install and uninstall steps and the values", p)
+	}
+	for _, step := range append(append(p.LoadImage, p.Install...), p.Uninstall...) {
+		if step.ID != "" {
+			t.Errorf("step %s ID = %q, want it cleared", step.Name, step.ID)
+		}
+	}
+	if nodes := stepByName(p.Install, "installCiliumRelease").Nodes; len(nodes) != 1 || nodes[0].ID != "node1" {
+		t.Errorf("installCiliumRelease nodes = %v, want the first master", nodes)
+	}
+}
+
+func TestCiliumRunnable_TemplateOverride(t *testing.T) {
+	tmpl, err := LoadOverridableTemplate(CiliumTemplateKey)
+	if err != nil {
+		t.Fatalf("LoadOverridableTemplate() error = %v", err)
+	}
+	if err = tmpl.ValidateTemplate(tmpl.DefaultTemplate()); err != nil {
+		t.Errorf("ValidateTemplate() of the built-in template error = %v", err)
+	}
+	for _, text := range []string{"operator:\n  replicas: {{ .CiliumConfig.OperatorReplicas", "ipam: {{ .NoSuchField }}", "operator: [\n"} {
+		if err = tmpl.ValidateTemplate(text); err == nil {
+			t.Errorf("ValidateTemplate(%q) should fail", text)
+		}
+	}
+
+	override := "operator:\n  replicas: {{ .CiliumConfig.OperatorReplicas }}\n"
+	metadata := &component.ExtraMetadata{CNITemplates: map[string]string{TemplateDataKey(CiliumTemplateKey): override}}
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", Cilium: &v1.Cilium{OperatorReplicas: 2}}, &v1.Networking{})
+	values, err := stepper.RenderString(context.TODO())
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	if values != "operator:\n  replicas: 2\n" {
+		t.Errorf("RenderString() = %q, want the override rendered", values)
+	}
+	if _, err = LoadOverridableTemplate("kubeadmConfig/v1/template"); err == nil {
+		t.Errorf("LoadOverridableTemplate() of a template which can not be overridden should fail")
+	}
+}
+
+func TestCiliumRunnable_loadBalancer(t *testing.T) {
+	metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1", IPv4: "10.0.0.11"}}, Workers: component.NodeList{{ID: "node2", IPv4: "10.0.0.12"}}}
+	networking := &v1.Networking{
+		Services: v1.NetworkRanges{CIDRBlocks: []string{"10.96.0.0/12"}},
+		Pods:     v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}},
+	}
+	tests := []struct {
+		name    string
+		version string
+		config  *v1.Cilium
+		wantErr string
+	}{
+		{name: "pools only", version: "1.13.9", config: &v1.Cilium{LoadBalancerIPPools: []string{"10.0.0.192/27"}}},
+		{name: "l2 announcements", version: "1.15.1", config: &v1.Cilium{KubeProxyReplacement: CiliumKubeProxyReplacementTrue,
+			EnableL2Announcements: true, LoadBalancerIPPools: []string{"10.0.0.192/27", "10.0.0.224/28"}}},
+		{name: "l2 on old cilium", version: "1.13.9", config: &v1.Cilium{KubeProxyReplacement: CiliumKubeProxyReplacementStrict,
+			EnableL2Announcements: true}, wantErr: "require cilium >= 1.14"},
+		{name: "l2 with kube-proxy", version: "1.15.1", config: &v1.Cilium{EnableL2Announcements: true}, wantErr: "kubeProxyReplacement"},
+		{name: "invalid pool", version: "1.15.1", config: &v1.Cilium{LoadBalancerIPPools: []string{"10.0.0.300/27"}}, wantErr: "invalid cilium load balancer IP pool"},
+		{name: "service overlap", version: "1.15.1", config: &v1.Cilium{LoadBalancerIPPools: []string{"10.100.0.0/24"}}, wantErr: "overlaps the service CIDR"},
+		{name: "pod overlap", version: "1.15.1", config: &v1.Cilium{LoadBalancerIPPools: []string{"172.25.8.0/24"}}, wantErr: "overlaps the pod CIDR"},
+		{name: "cluster pool overlap", version: "1.15.1", config: &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"192.168.64.0/18"},
+			ClusterPoolIPv4MaskSize: 24, LoadBalancerIPPools: []string{"192.168.100.0/24"}}, wantErr: "overlaps the pod CIDR"},
+		{name: "node overlap", version: "1.15.1", config: &v1.Cilium{LoadBalancerIPPools: []string{"10.0.0.0/28"}}, wantErr: "overlaps the node address 10.0.0.11"},
+		{name: "pools overlap", version: "1.15.1", config: &v1.Cilium{LoadBalancerIPPools: []string{"10.0.0.192/27", "10.0.0.200/29"}}, wantErr: "overlap"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.OperatorReplicas = 1
+			stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: tt.version, Cilium: tt.config}, networking).(*CiliumRunnable)
+			err := stepper.Validate()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			w := &bytes.Buffer{}
+			if err = stepper.renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			l2 := tt.config.EnableL2Announcements
+			if want := "\nl2announcements:\n  enabled: true\nexternalIPs:\n  enabled: true\n"; strings.Contains(w.String(), want) != l2 {
+				t.Errorf("renderCiliumTo() output contains %q = %v, want %v:\n%s", want, !l2, l2, w.String())
+			}
+			manifest, err := stepper.LoadBalancerManifest()
+			if err != nil {
+				t.Fatalf("LoadBalancerManifest() error = %v", err)
+			}
+			field := map[bool]string{true: "blocks", false: "cidrs"}[tt.version >= "1.15"]
+			if want := "spec:\n  " + field + ":\n  - cidr: 10.0.0.192/27\n"; !strings.Contains(manifest, want) {
+				t.Errorf("LoadBalancerManifest() does not contain %q:\n%s", want, manifest)
+			}
+			if strings.Contains(manifest, "kind: CiliumL2AnnouncementPolicy") != l2 {
+				t.Errorf("LoadBalancerManifest() contains the L2 announcement policy = %v, want %v:\n%s", !l2, l2, manifest)
+			}
+
+			steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "")
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			names := stepNames(steps)
+			if names[len(names)-1] != "applyCiliumLBResources" {
+				t.Errorf("InstallSteps() = %v, want applyCiliumLBResources last", names)
+			}
+			if check := stepByName(steps, "checkCiliumLBNetwork"); (check.Name != "") != l2 {
+				t.Errorf("InstallSteps() = %v, want checkCiliumLBNetwork %v", names, l2)
+			} else if l2 && (len(check.Nodes) != 2 || !strings.Contains(check.Commands[0].ShellCommand[2], "for ip in 10.0.0.192 10.0.0.224;")) {
+				t.Errorf("checkCiliumLBNetwork = %v", check)
+			}
+
+			steps, err = stepper.UninstallSteps(utils.UnwrapNodeList(metadata.GetAllNodes()))
+			if err != nil {
+				t.Fatalf("UninstallSteps() error = %v", err)
+			}
+			names = stepNames(steps)
+			if joined := strings.Join(names, " "); !strings.HasPrefix(joined, "deleteCiliumLBResources uninstallCiliumRelease") {
+				t.Errorf("UninstallSteps() = %v, want the resources deleted before the release", names)
+			}
+			del := stepByName(steps, "deleteCiliumLBResources").Commands[0].ShellCommand
+			if want := 3 + len(stepper.loadBalancerResources()); len(del) != want || del[3] != ciliumLBIPPoolCRD+"/"+CiliumLBIPPoolName {
+				t.Errorf("deleteCiliumLBResources command = %v", del)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_BGP(t *testing.T) {
+	metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1", IPv4: "10.0.0.11"}}, Workers: component.NodeList{{ID: "node2", IPv4: "10.0.0.12"}}}
+	networking := &v1.Networking{
+		Services: v1.NetworkRanges{CIDRBlocks: []string{"10.96.0.0/12"}},
+		Pods:     v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}},
+	}
+	all := &metav1.LabelSelector{}
+	tests := []struct {
+		name    string
+		bgp     *v1.CiliumBGP
+		wantErr string
+	}{
+		{name: "peerings", bgp: &v1.CiliumBGP{Enabled: true, Peerings: []v1.CiliumBGPPeering{
+			{PeerAddress: "10.0.0.1", PeerASN: 65000, LocalASN: 65010, ExportPodCIDR: true, ServiceSelector: all},
+			{PeerAddress: "10.0.0.2/32", PeerASN: 65000, LocalASN: 65010, ExportPodCIDR: true, ServiceSelector: all},
+			{PeerAddress: "fd00::1", PeerASN: 4200000000, LocalASN: 64512},
+		}}},
+		{name: "disabled peerings", bgp: &v1.CiliumBGP{Peerings: []v1.CiliumBGPPeering{{PeerAddress: "10.0.0.1", PeerASN: 65000, LocalASN: 65010}}},
+			wantErr: "require the bgp control plane to be enabled"},
+		{name: "no peering", bgp: &v1.CiliumBGP{Enabled: true}, wantErr: "at least one peering"},
+		{name: "peer ASN", bgp: &v1.CiliumBGP{Enabled: true, Peerings: []v1.CiliumBGPPeering{{PeerAddress: "10.0.0.1", LocalASN: 65010}}},
+			wantErr: "invalid cilium bgp peer ASN 0"},
+		{name: "local ASN", bgp: &v1.CiliumBGP{Enabled: true, Peerings: []v1.CiliumBGPPeering{{PeerAddress: "10.0.0.1", PeerASN: 65000, LocalASN: 4294967295}}},
+			wantErr: "invalid cilium bgp local ASN 4294967295"},
+		{name: "hostname", bgp: &v1.CiliumBGP{Enabled: true, Peerings: []v1.CiliumBGPPeering{{PeerAddress: "tor1", PeerASN: 65000, LocalASN: 65010}}},
+			wantErr: "must be an IP address"},
+		{name: "network", bgp: &v1.CiliumBGP{Enabled: true, Peerings: []v1.CiliumBGPPeering{{PeerAddress: "10.0.0.0/24", PeerASN: 65000, LocalASN: 65010}}},
+			wantErr: "must be a single address"},
+		{name: "loopback", bgp: &v1.CiliumBGP{Enabled: true, Peerings: []v1.CiliumBGPPeering{{PeerAddress: "127.0.0.1", PeerASN: 65000, LocalASN: 65010}}},
+			wantErr: "must be a unicast address"},
+		{name: "duplicate", bgp: &v1.CiliumBGP{Enabled: true, Peerings: []v1.CiliumBGPPeering{
+			{PeerAddress: "10.0.0.1", PeerASN: 65000, LocalASN: 65010},
+			{PeerAddress: "10.0.0.1/32", PeerASN: 65001, LocalASN: 65010},
+		}}, wantErr: "duplicate cilium bgp peer"},
+		{name: "advertisements", bgp: &v1.CiliumBGP{Enabled: true, Peerings: []v1.CiliumBGPPeering{
+			{PeerAddress: "10.0.0.1", PeerASN: 65000, LocalASN: 65010, ExportPodCIDR: true},
+			{PeerAddress: "10.0.0.2", PeerASN: 65000, LocalASN: 65010},
+		}}, wantErr: "must advertise the same CIDRs"},
+		{name: "selector", bgp: &v1.CiliumBGP{Enabled: true, Peerings: []v1.CiliumBGPPeering{{PeerAddress: "10.0.0.1", PeerASN: 65000, LocalASN: 65010,
+			ServiceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "a", Operator: "Like"}}}}}},
+			wantErr: "invalid cilium bgp service selector"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &v1.CNI{Type: "cilium", Version: "1.15.1", Cilium: &v1.Cilium{OperatorReplicas: 1, BGP: tt.bgp}}
+			stepper := (&CiliumRunnable{}).InitStep(metadata, c, networking).(*CiliumRunnable)
+			err := stepper.Validate()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			w := &bytes.Buffer{}
+			if err = stepper.renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			if want := "\nbgpControlPlane:\n  enabled: true\n"; !strings.Contains(w.String(), want) {
+				t.Errorf("renderCiliumTo() does not contain %q:\n%s", want, w.String())
+			}
+
+			manifest, err := stepper.BGPManifest()
+			if err != nil {
+				t.Fatalf("BGPManifest() error = %v", err)
+			}
+			policy := struct {
+				Spec struct {
+					VirtualRouters []struct {
+						LocalASN        int64                 `json:"localASN"`
+						ExportPodCIDR   bool                  `json:"exportPodCIDR"`
+						ServiceSelector *metav1.LabelSelector `json:"serviceSelector"`
+						Neighbors       []struct {
+							PeerAddress string `json:"peerAddress"`
+							PeerASN     int64  `json:"peerASN"`
+						} `json:"neighbors"`
+					} `json:"virtualRouters"`
+				} `json:"spec"`
+			}{}
+			if err = yaml.Unmarshal([]byte(manifest), &policy); err != nil {
+				t.Fatalf("unmarshal BGPManifest() error = %v:\n%s", err, manifest)
+			}
+			routers := policy.Spec.VirtualRouters
+			if len(routers) != 2 || routers[0].LocalASN != 64512 || routers[1].LocalASN != 65010 {
+				t.Fatalf("BGPManifest() virtual routers = %+v, want 64512 and 65010", routers)
+			}
+			if n := routers[0].Neighbors; len(n) != 1 || n[0].PeerAddress != "fd00::1/128" || n[0].PeerASN != 4200000000 || routers[0].ServiceSelector != nil {
+				t.Errorf("BGPManifest() virtual router 64512 = %+v", routers[0])
+			}
+			if n := routers[1].Neighbors; len(n) != 2 || n[0].PeerAddress != "10.0.0.1/32" || n[1].PeerAddress != "10.0.0.2/32" || !routers[1].ExportPodCIDR {
+				t.Errorf("BGPManifest() virtual router 65010 = %+v", routers[1])
+			}
+			if s := routers[1].ServiceSelector; s == nil || len(s.MatchExpressions) != 1 || s.MatchExpressions[0].Operator != metav1.LabelSelectorOpNotIn {
+				t.Errorf("BGPManifest() service selector of 65010 = %+v, want every service", s)
+			}
+
+			steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "")
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			if names := stepNames(steps); names[len(names)-1] != "applyCiliumBGPPolicy" {
+				t.Errorf("InstallSteps() = %v, want applyCiliumBGPPolicy last", names)
+			}
+			steps, err = stepper.UninstallSteps(utils.UnwrapNodeList(metadata.GetAllNodes()))
+			if err != nil {
+				t.Fatalf("UninstallSteps() error = %v", err)
+			}
+			if joined := strings.Join(stepNames(steps), " "); !strings.HasPrefix(joined, "deleteCiliumBGPPolicy uninstallCiliumRelease") {
+				t.Errorf("UninstallSteps() = %v, want the policy deleted before the release", joined)
+			}
+
+			steps, err = stepper.PeeringSteps([]v1.StepNode{{ID: "node1"}})
+			if err != nil {
+				t.Fatalf("PeeringSteps() error = %v", err)
+			}
+			if len(steps) != 1 || steps[0].Name != "applyCiliumBGPPolicy" || steps[0].Action != v1.ActionUpgrade {
+				t.Errorf("PeeringSteps() = %v", stepNames(steps))
+			}
+
+			hash := BGPPeeringHash(c)
+			if hash == "" {
+				t.Fatalf("BGPPeeringHash() is empty")
+			}
+			c.Cilium.BGP.Peerings[0].PeerASN++
+			if BGPPeeringHash(c) == hash {
+				t.Errorf("BGPPeeringHash() did not change with the peerings")
+			}
+			c.Cilium.BGP.Enabled = false
+			if got := BGPPeeringHash(c); got != "" {
+				t.Errorf("BGPPeeringHash() = %q, want empty when the control plane is disabled", got)
+			}
+			steps, err = stepper.PeeringSteps([]v1.StepNode{{ID: "node1"}})
+			if err != nil || len(steps) != 1 || steps[0].Name != "deleteCiliumBGPPolicy" {
+				t.Errorf("PeeringSteps() = %v, %v, want deleteCiliumBGPPolicy", stepNames(steps), err)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_HostFirewall(t *testing.T) {
+	tests := []struct {
+		name      string
+		metadata  *component.ExtraMetadata
+		wantPorts []string
+	}{
+		{name: "default ports", metadata: &component.ExtraMetadata{}, wantPorts: []string{"22", "10250", "6443", "9889"}},
+		{name: "metadata ports", metadata: &component.ExtraMetadata{APIServerPort: 16443, AgentPort: 19889}, wantPorts: []string{"22", "10250", "16443", "19889"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.metadata.Masters = component.NodeList{{ID: "node1", IPv4: "10.0.0.11"}}
+			c := &v1.CNI{Type: "cilium", Version: "1.15.1", Cilium: &v1.Cilium{OperatorReplicas: 1, EnableHostFirewall: true}}
+			stepper := (&CiliumRunnable{}).InitStep(tt.metadata, c, &v1.Networking{}).(*CiliumRunnable)
+			w := &bytes.Buffer{}
+			if err := stepper.renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			if want := "\nhostFirewall:\n  enabled: true\n"; !strings.Contains(w.String(), want) {
+				t.Errorf("renderCiliumTo() does not contain %q:\n%s", want, w.String())
+			}
+
+			manifest, err := stepper.HostFirewallPolicyManifest()
+			if err != nil {
+				t.Fatalf("HostFirewallPolicyManifest() error = %v", err)
+			}
+			policy := struct {
+				Kind string `json:"kind"`
+				Spec struct {
+					NodeSelector *metav1.LabelSelector `json:"nodeSelector"`
+					Ingress      []struct {
+						FromEntities []string `json:"fromEntities"`
+						ToPorts      []struct {
+							Ports []struct {
+								Port     string `json:"port"`
+								Protocol string `json:"protocol"`
+							} `json:"ports"`
+						} `json:"toPorts"`
+					} `json:"ingress"`
+				} `json:"spec"`
+			}{}
+			if err = yaml.Unmarshal([]byte(manifest), &policy); err != nil {
+				t.Fatalf("unmarshal HostFirewallPolicyManifest() error = %v:\n%s", err, manifest)
+			}
+			if policy.Kind != "CiliumClusterwideNetworkPolicy" || policy.Spec.NodeSelector == nil || len(policy.Spec.Ingress) != 2 {
+				t.Fatalf("HostFirewallPolicyManifest() =\n%s", manifest)
+			}
+			if entities := policy.Spec.Ingress[0].FromEntities; !reflect.DeepEqual(entities, []string{"cluster"}) {
+				t.Errorf("HostFirewallPolicyManifest() first rule entities = %v, want cluster", entities)
+			}
+			var ports []string
+			for _, p := range policy.Spec.Ingress[1].ToPorts[0].Ports {
+				if p.Protocol != "TCP" {
+					t.Errorf("HostFirewallPolicyManifest() port %s protocol = %s, want TCP", p.Port, p.Protocol)
+				}
+				ports = append(ports, p.Port)
+			}
+			if !reflect.DeepEqual(ports, tt.wantPorts) {
+				t.Errorf("HostFirewallPolicyManifest() ports = %v, want %v", ports, tt.wantPorts)
+			}
+
+			steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "")
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			names := stepNames(steps)
+			if joined := strings.Join(names, " "); !strings.Contains(joined, "checkCiliumReady applyCiliumHostFirewallPolicy") {
+				t.Errorf("InstallSteps() = %v, want the policy applied right after the release is ready", names)
+			}
+			steps, err = stepper.UninstallSteps(utils.UnwrapNodeList(tt.metadata.GetAllNodes()))
+			if err != nil {
+				t.Fatalf("UninstallSteps() error = %v", err)
+			}
+			if joined := strings.Join(stepNames(steps), " "); !strings.HasPrefix(joined, "deleteCiliumHostFirewallPolicy uninstallCiliumRelease") {
+				t.Errorf("UninstallSteps() = %v, want the policy deleted before the release", joined)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_EgressGateway(t *testing.T) {
+	metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1", IPv4: "10.0.0.11"}}}
+	policy := v1.CiliumEgressGatewayPolicy{Name: "team-a", Namespaces: []string{"team-a", "team-a-jobs"},
+		EgressNodeLabels: map[string]string{"egress": "team-a"}, EgressIP: "10.0.0.200"}
+	tests := []struct {
+		name    string
+		config  *v1.Cilium
+		wantErr string
+	}{
+		{name: "policies", config: &v1.Cilium{KubeProxyReplacement: CiliumKubeProxyReplacementTrue, EnableEgressGateway: true,
+			EgressGatewayPolicies: []v1.CiliumEgressGatewayPolicy{policy,
+				{Name: "team-b", Namespaces: []string{"team-b"}, EgressNodeLabels: map[string]string{"egress": "team-b"}, EgressIP: "10.0.0.201",
+					DestinationCIDRs: []string{"192.168.0.0/16"}}}}},
+		{name: "no policy", config: &v1.Cilium{KubeProxyReplacement: CiliumKubeProxyReplacementTrue, EnableEgressGateway: true}},
+		{name: "kube-proxy", config: &v1.Cilium{EnableEgressGateway: true}, wantErr: "cilium egress gateway requires kubeProxyReplacement"},
+		{name: "disabled", config: &v1.Cilium{KubeProxyReplacement: CiliumKubeProxyReplacementTrue,
+			EgressGatewayPolicies: []v1.CiliumEgressGatewayPolicy{policy}}, wantErr: "require enableEgressGateway"},
+		{name: "duplicate", config: &v1.Cilium{KubeProxyReplacement: CiliumKubeProxyReplacementTrue, EnableEgressGateway: true,
+			EgressGatewayPolicies: []v1.CiliumEgressGatewayPolicy{policy, policy}}, wantErr: "duplicate cilium egress gateway policy team-a"},
+		{name: "ipv6 egress IP", config: &v1.Cilium{KubeProxyReplacement: CiliumKubeProxyReplacementTrue, EnableEgressGateway: true,
+			EgressGatewayPolicies: []v1.CiliumEgressGatewayPolicy{{Name: "a", Namespaces: []string{"a"}, EgressNodeLabels: map[string]string{"a": "a"}, EgressIP: "fd00::1"}}},
+			wantErr: "must be an IPv4 address"},
+		{name: "no namespace", config: &v1.Cilium{KubeProxyReplacement: CiliumKubeProxyReplacementTrue, EnableEgressGateway: true,
+			EgressGatewayPolicies: []v1.CiliumEgressGatewayPolicy{{Name: "a", EgressNodeLabels: map[string]string{"a": "a"}, EgressIP: "10.0.0.200"}}},
+			wantErr: "selects no namespace"},
+		{name: "no node labels", config: &v1.Cilium{KubeProxyReplacement: CiliumKubeProxyReplacementTrue, EnableEgressGateway: true,
+			EgressGatewayPolicies: []v1.CiliumEgressGatewayPolicy{{Name: "a", Namespaces: []string{"a"}, EgressIP: "10.0.0.200"}}},
+			wantErr: "requires the egress node labels"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.OperatorReplicas = 1
+			stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.15.1", Cilium: tt.config}, &v1.Networking{}).(*CiliumRunnable)
+			err := stepper.Validate()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			w := &bytes.Buffer{}
+			if err = stepper.renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			for _, want := range []string{"\negressGateway:\n  enabled: true\n", "\nbpf:\n  masquerade: true\n"} {
+				if !strings.Contains(w.String(), want) {
+					t.Errorf("renderCiliumTo() does not contain %q:\n%s", want, w.String())
+				}
+			}
+
+			manifest, err := stepper.EgressGatewayManifest()
+			if err != nil {
+				t.Fatalf("EgressGatewayManifest() error = %v", err)
+			}
+			policies := len(tt.config.EgressGatewayPolicies)
+			if got := strings.Count(manifest, "kind: CiliumEgressGatewayPolicy"); got != policies {
+				t.Fatalf("EgressGatewayManifest() has %d policies, want %d:\n%s", got, policies, manifest)
+			}
+			steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "")
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			if apply := stepByName(steps, "applyCiliumEgressGatewayPolicies"); (apply.Name != "") != (policies > 0) {
+				t.Errorf("InstallSteps() = %v, want applyCiliumEgressGatewayPolicies %v", stepNames(steps), policies > 0)
+			}
+			if policies == 0 {
+				return
+			}
+			for _, want := range []string{
+				"name: team-a\n",
+				"  - podSelector:\n      matchExpressions:\n      - key: io.kubernetes.pod.namespace\n        operator: In\n        values:\n        - team-a\n        - team-a-jobs\n",
+				"  destinationCIDRs:\n  - 0.0.0.0/0\n",
+				"  destinationCIDRs:\n  - 192.168.0.0/16\n",
+				"  egressGateway:\n    egressIP: 10.0.0.200\n    nodeSelector:\n      matchLabels:\n        egress: team-a\n",
+			} {
+				if !strings.Contains(manifest, want) {
+					t.Errorf("EgressGatewayManifest() does not contain %q:\n%s", want, manifest)
+				}
+			}
+			steps, err = stepper.UninstallSteps(utils.UnwrapNodeList(metadata.GetAllNodes()))
+			if err != nil {
+				t.Fatalf("UninstallSteps() error = %v", err)
+			}
+			if joined := strings.Join(stepNames(steps), " "); !strings.HasPrefix(joined, "deleteCiliumEgressGatewayPolicies uninstallCiliumRelease") {
+				t.Errorf("UninstallSteps() = %v, want the policies deleted before the release", joined)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_validateLoadBalancerTuning(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *v1.Cilium
+		wantErr string
+	}{
+		{name: "defaults", config: &v1.Cilium{}},
+		{name: "hybrid native routing", config: &v1.Cilium{KubeProxyReplacement: CiliumKubeProxyReplacementTrue, TunnelMode: CiliumTunnelDisabled,
+			NativeRoutingCIDR: "172.25.0.0/16", LoadBalancerMode: CiliumLBModeHybrid}},
+		{name: "invalid mode", config: &v1.Cilium{LoadBalancerMode: "nat"}, wantErr: "invalid cilium load balancer mode"},
+		{name: "invalid algorithm", config: &v1.Cilium{LoadBalancerAlgorithm: "roundrobin"}, wantErr: "invalid cilium load balancer algorithm"},
+		{name: "dsr tunnel", config: &v1.Cilium{KubeProxyReplacement: CiliumKubeProxyReplacementTrue, LoadBalancerMode: CiliumLBModeDSR},
+			wantErr: "requires the native routing, set the tunnel mode to disabled"},
+		{name: "dsr kube-proxy", config: &v1.Cilium{TunnelMode: CiliumTunnelDisabled, NativeRoutingCIDR: "172.25.0.0/16", LoadBalancerMode: CiliumLBModeDSR},
+			wantErr: "cilium load balancer mode dsr requires kubeProxyReplacement"},
+		{name: "maglev kube-proxy", config: &v1.Cilium{LoadBalancerAlgorithm: CiliumLBAlgorithmMaglev},
+			wantErr: "cilium load balancer algorithm maglev requires kubeProxyReplacement"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.OperatorReplicas = 1
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.15.1", Cilium: tt.config}, &v1.Networking{})
+			err := stepper.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_validateChaining(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *v1.Cilium
+		wantErr string
+	}{
+		{name: "aws-cni", config: &v1.Cilium{CNIChainingMode: CiliumChainingAWSCNI}},
+		{name: "invalid mode", config: &v1.Cilium{CNIChainingMode: "calico"}, wantErr: "invalid cilium cni chaining mode"},
+		{name: "cluster pool", config: &v1.Cilium{CNIChainingMode: CiliumChainingAWSCNI, ClusterPoolIPv4PodCIDRList: []string{"192.168.64.0/18"},
+			ClusterPoolIPv4MaskSize: 24}, wantErr: "can not be used with the cluster pool"},
+		{name: "tunnel", config: &v1.Cilium{CNIChainingMode: CiliumChainingAWSCNI, TunnelMode: CiliumTunnelVXLAN}, wantErr: "can not be used with tunnel mode vxlan"},
+		{name: "generic-veth without conf", config: &v1.Cilium{CNIChainingMode: CiliumChainingGenericVeth}, wantErr: "requires customCNIConf"},
+		{name: "conf without chaining", config: &v1.Cilium{CustomCNIConf: "cni-configuration"}, wantErr: "requires a cniChainingMode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.OperatorReplicas = 1
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.15.1", Cilium: tt.config}, &v1.Networking{})
+			err := stepper.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_InstallStepsDependsOn(t *testing.T) {
+	stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.15.1"}, &v1.Networking{})
+	steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	for _, step := range steps {
+		if step.Component != StepComponent {
+			t.Errorf("step %s component = %q, want %q", step.Name, step.Component, StepComponent)
+		}
+	}
+	install := stepByName(steps, "installCiliumRelease")
+	names := stepNames(steps)
+	position := make(map[string]int, len(names))
+	for i, name := range names {
+		position[name] = i
+	}
+	if len(install.DependsOn) == 0 {
+		t.Fatalf("installCiliumRelease depends on nothing")
+	}
+	for _, dep := range install.DependsOn {
+		if i, ok := position[dep]; !ok || i > position[install.Name] {
+			t.Errorf("installCiliumRelease depends on %s, steps = %v", dep, names)
+		}
+	}
+}
+
+func TestCiliumRunnable_RenderFacts(t *testing.T) {
+	stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.15.1"}, &v1.Networking{})
+	runnable := stepper.(*CiliumRunnable)
+	runnable.ValuesTemplate = "kernel: {{ .Facts.KernelVersion }}\ncgroup: {{ .Facts.CgroupVersion }}\ndevices: {{ .Facts.DefaultInterface }}\n"
+	collected := 0
+	ctx := component.WithFacts(context.TODO(), func() sysutil.Facts {
+		collected++
+		return sysutil.Facts{KernelVersion: "5.15.0", CgroupVersion: 2, DefaultInterface: "eth0"}
+	})
+	if err := runnable.Render(ctx, component.Options{DryRun: true}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	got, err := runnable.RenderString(ctx)
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	if want := "kernel: 5.15.0\ncgroup: 2\ndevices: eth0\n"; got != want || collected != 1 {
+		t.Errorf("RenderString() = %q, collected %d times, want %q", got, collected, want)
+	}
+
+	server := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.15.1"}, &v1.Networking{}).(*CiliumRunnable)
+	server.ValuesTemplate = runnable.ValuesTemplate
+	if got, _ = server.RenderString(context.TODO()); got != "kernel: \ncgroup: 0\ndevices: \n" {
+		t.Errorf("RenderString() without facts = %q, want empty facts", got)
+	}
+}
+
+func TestCiliumRunnable_Preflight(t *testing.T) {
+	metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1", Hostname: "node1"}}, Workers: component.NodeList{{ID: "node2", Hostname: "node2"}}}
+	config := &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"}, ClusterPoolIPv4MaskSize: 24}
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.16.1", Cilium: config}, &v1.Networking{}).(*CiliumRunnable)
+	steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1", Hostname: "node1"}}, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	names := strings.Join(stepNames(steps), ",")
+	if !strings.HasPrefix(names, "preflightCilium-node1,preflightCilium-node2,reportCiliumPreflight,") {
+		t.Errorf("InstallSteps() = %s, want the preflight checks of every node first", names)
+	}
+	check := &CiliumPreflight{}
+	if err = json.Unmarshal(stepByName(steps, "preflightCilium-node2").Commands[0].CustomCommand, check); err != nil {
+		t.Fatal(err)
+	}
+	if check.Node != "node2" || check.MinKernel != ciliumMinKernel116 {
+		t.Errorf("preflightCilium-node2 = %+v, want node2 and kernel %s", check, ciliumMinKernel116)
+	}
+
+	writeNode := func(release, mounts, filesystems, config string) string {
+		root := t.TempDir()
+		files := map[string]string{
+			"proc/sys/kernel/osrelease": release + "\n",
+			"proc/mounts":               mounts,
+			"proc/filesystems":          filesystems,
+		}
+		if config != "" {
+			files["boot/config-"+release] = config
+		}
+		for name, content := range files {
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return root
+	}
+	good := writeNode("5.15.0-91-generic", "cgroup2 /sys/fs/cgroup cgroup2 rw 0 0\n", "nodev\tcgroup2\nnodev\tbpf\n",
+		"# kernel config\nCONFIG_BPF=y\nCONFIG_BPF_SYSCALL=y\nCONFIG_NET_CLS_BPF=m\nCONFIG_NET_SCH_INGRESS=m\n")
+	old := writeNode("4.15.0-20-generic", "tmpfs /sys/fs/cgroup tmpfs ro 0 0\n", "nodev\tcgroup\n", "")
+	rhel := writeNode("4.18.0-513.el8.x86_64", "tmpfs /sys/fs/cgroup tmpfs ro 0 0\n", "nodev\tcgroup2\nnodev\tbpf\n",
+		"# kernel config\nCONFIG_BPF=y\n")
+
+	// every check step replies the failures of the previous steps and its own
+	ctx := context.TODO()
+	for _, node := range []*CiliumPreflight{
+		{Node: "node1", MinKernel: ciliumMinKernel, root: good},
+		{Node: "node2", MinKernel: ciliumMinKernel, root: old},
+		{Node: "node3", MinKernel: ciliumMinKernel, root: rhel},
+	} {
+		reply, err := node.Install(ctx, component.Options{})
+		if err != nil {
+			t.Fatalf("Install() of %s error = %v", node.Node, err)
+		}
+		ctx = component.WithExtraData(context.TODO(), reply)
+	}
+	want := []PreflightFailure{
+		{Node: "node2", Check: "kernel", Message: "kernel 4.15.0-20-generic is older than 4.19.57"},
+		{Node: "node2", Check: "cgroup", Message: "/sys/fs/cgroup is not cgroup2 and the kernel does not support cgroup2"},
+		{Node: "node2", Check: "bpf", Message: "the kernel does not support the bpf filesystem"},
+		{Node: "node3", Check: "kernel config", Message: "CONFIG_BPF_SYSCALL is not enabled"},
+		{Node: "node3", Check: "kernel config", Message: "CONFIG_NET_CLS_BPF is not enabled"},
+		{Node: "node3", Check: "kernel config", Message: "CONFIG_NET_SCH_INGRESS is not enabled"},
+	}
+	if got := lastPreflight(ctx).Failures; !reflect.DeepEqual(got, want) {
+		t.Errorf("preflight failures = %+v, want %+v", got, want)
+	}
+
+	_, err = (&CiliumPreflight{Report: true}).Install(ctx, component.Options{})
+	if err == nil || !strings.Contains(err.Error(), "cilium preflight checks failed:\n  node2: kernel: kernel 4.15.0-20-generic is older than 4.19.57\n") {
+		t.Errorf("report error = %v, want the failures of every node", err)
+	}
+	reportStep := stepByName(steps, "reportCiliumPreflight")
+	if matcher := reportStep.ClassifyError(1, err.Error()); matcher == nil || matcher.Category != v1.StepErrorValidationFailed {
+		t.Errorf("ClassifyError() = %v, want %s", matcher, v1.StepErrorValidationFailed)
+	}
+	if _, err = (&CiliumPreflight{Report: true, Force: true}).Install(ctx, component.Options{}); err != nil {
+		t.Errorf("forced report error = %v, want the failures as warnings", err)
+	}
+	if _, err = (&CiliumPreflight{Report: true}).Install(component.WithExtraData(context.TODO(), []byte("join command")), component.Options{}); err != nil {
+		t.Errorf("report of an unrelated reply error = %v", err)
+	}
+
+	config.ForcePreflight = true
+	stepper = (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.16.1", Cilium: config}, &v1.Networking{}).(*CiliumRunnable)
+	steps, err = stepper.InstallSteps([]v1.StepNode{{ID: "node1", Hostname: "node1"}}, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	report := &CiliumPreflight{}
+	if err = json.Unmarshal(stepByName(steps, "reportCiliumPreflight").Commands[0].CustomCommand, report); err != nil || !report.Force {
+		t.Errorf("reportCiliumPreflight = %+v, want forced", report)
+	}
+}
+
+func TestCiliumRunnable_RenderVersions(t *testing.T) {
+	tests := []struct {
+		version string
+		want    []string
+		missing []string
+	}{
+		{
+			version: "1.11.20",
+			want:    []string{"\ntunnel: vxlan\n", "\nkubeProxyReplacement: \"disabled\"\n", "\nhostServices:\n  enabled: true\n", "\nbandwidthManager: true\n"},
+			missing: []string{"routingMode", "socketLB", "bbr"},
+		},
+		{
+			version: "1.13.10",
+			want:    []string{"\ntunnel: vxlan\n", "\nkubeProxyReplacement: \"disabled\"\n", "\nsocketLB:\n  enabled: true\n", "\nbandwidthManager:\n  enabled: true\n  bbr: false\n"},
+			missing: []string{"routingMode", "hostServices"},
+		},
+		{
+			version: "1.15.1",
+			want:    []string{"\nroutingMode: tunnel\ntunnelProtocol: vxlan\n", "\nkubeProxyReplacement: false\n", "\nsocketLB:\n  enabled: true\n", "\nbandwidthManager:\n  enabled: true\n  bbr: false\n"},
+			missing: []string{"\ntunnel:", "hostServices"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			config := &v1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"}, ClusterPoolIPv4MaskSize: 24,
+				TunnelMode: CiliumTunnelVXLAN, SocketLB: true, EnableBandwidthManager: true}
+			stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Type: "cilium", Version: tt.version, Cilium: config}, &v1.Networking{})
+			if err := stepper.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			values, err := stepper.RenderString(context.TODO())
+			if err != nil {
+				t.Fatalf("RenderString() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(values, want) {
+					t.Errorf("values do not contain %q:\n%s", want, values)
+				}
+			}
+			for _, missing := range tt.missing {
+				if strings.Contains(values, missing) {
+					t.Errorf("values contain %q:\n%s", missing, values)
+				}
+			}
+		})
+	}
+
+	for _, c := range []struct {
+		version string
+		config  *v1.Cilium
+	}{
+		{"1.11.20", &v1.Cilium{EnableBandwidthManager: true, EnableBBR: true}},
+		{"1.12.19", &v1.Cilium{BGP: &v1.CiliumBGP{Enabled: true, Peerings: []v1.CiliumBGPPeering{{PeerAddress: "10.0.0.1", PeerASN: 65000, LocalASN: 65001}}}}},
+	} {
+		c.config.ClusterPoolIPv4PodCIDRList, c.config.ClusterPoolIPv4MaskSize = []string{"10.0.0.0/16"}, 24
+		stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Type: "cilium", Version: c.version, Cilium: c.config}, &v1.Networking{})
+		if err := stepper.Validate(); err == nil || !strings.Contains(err.Error(), "requires cilium >=") {
+			t.Errorf("Validate() of cilium %s error = %v, want the feature unsupported by the version", c.version, err)
+		}
+	}
+}
+
+func TestCiliumRunnable_PodIPPools(t *testing.T) {
+	metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1", IPv4: "10.0.0.11"}}}
+	defaultPool := v1.CiliumPodIPPool{Name: "default", CIDR: "172.20.0.0/16", MaskSize: 24}
+	gpuPool := v1.CiliumPodIPPool{Name: "gpu", CIDR: "172.30.0.0/20", MaskSize: 27, NodeSelector: map[string]string{"pool": "gpu"}}
+	pools := func(p ...v1.CiliumPodIPPool) *v1.Cilium {
+		return &v1.Cilium{TunnelMode: CiliumTunnelDisabled, NativeRoutingCIDR: "172.16.0.0/12", Pools: p}
+	}
+	tests := []struct {
+		name    string
+		version string
+		config  *v1.Cilium
+		wantErr string
+	}{
+		{name: "pools", config: pools(defaultPool, gpuPool)},
+		{name: "default only", config: pools(defaultPool)},
+		{name: "old cilium", version: "1.13.4", config: pools(defaultPool), wantErr: "cilium pools require cilium >= 1.14"},
+		{name: "tunnel", config: &v1.Cilium{Pools: []v1.CiliumPodIPPool{defaultPool}}, wantErr: "require the native routing"},
+		{name: "no default", config: pools(gpuPool), wantErr: "require the pool default"},
+		{name: "default node selector", config: pools(v1.CiliumPodIPPool{Name: "default", CIDR: "172.20.0.0/16", MaskSize: 24,
+			NodeSelector: map[string]string{"a": "b"}}), wantErr: "can not have a node selector"},
+		{name: "overlap", config: pools(defaultPool, v1.CiliumPodIPPool{Name: "b", CIDR: "172.20.128.0/20", MaskSize: 26}),
+			wantErr: "cilium pool b overlaps the cilium pool default"},
+		{name: "mask smaller than prefix", config: pools(v1.CiliumPodIPPool{Name: "default", CIDR: "172.20.0.0/16", MaskSize: 12}),
+			wantErr: "must be larger than the prefix length"},
+		{name: "mask equal to prefix", config: pools(v1.CiliumPodIPPool{Name: "default", CIDR: "172.20.0.0/16", MaskSize: 16}),
+			wantErr: "must be larger than the prefix length"},
+		{name: "service overlap", config: pools(v1.CiliumPodIPPool{Name: "default", CIDR: "10.96.0.0/16", MaskSize: 24}),
+			wantErr: "overlaps the service CIDR"},
+		{name: "node overlap", config: pools(v1.CiliumPodIPPool{Name: "default", CIDR: "10.0.0.0/24", MaskSize: 28}),
+			wantErr: "overlaps the node address 10.0.0.11"},
+		{name: "cluster pool", config: &v1.Cilium{TunnelMode: CiliumTunnelDisabled, NativeRoutingCIDR: "172.16.0.0/12",
+			ClusterPoolIPv4PodCIDRList: []string{"172.25.0.0/16"}, ClusterPoolIPv4MaskSize: 24, Pools: []v1.CiliumPodIPPool{defaultPool}},
+			wantErr: "can not be used with the cluster pool"},
+		{name: "multi-pool without pools", config: &v1.Cilium{IPAMMode: CiliumIPAMMultiPool}, wantErr: "requires the pools"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version := tt.version
+			if version == "" {
+				version = "1.15.1"
+			}
+			tt.config.OperatorReplicas = 1
+			stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: version, Cilium: tt.config},
+				&v1.Networking{Services: v1.NetworkRanges{CIDRBlocks: []string{"10.96.0.0/16"}}}).(*CiliumRunnable)
+			err := stepper.Validate()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			w := &bytes.Buffer{}
+			if err = stepper.renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			want := "\nipam:\n  mode: multi-pool\n  operator:\n    autoCreateCiliumPodIPPools:\n      default: {\"ipv4\":{\"cidrs\":[\"172.20.0.0/16\"],\"maskSize\":24}}\nendpointRoutes:\n  enabled: true\n"
+			if !strings.Contains(w.String(), want) || strings.Contains(w.String(), "clusterPoolIPv4PodCIDRList") {
+				t.Errorf("renderCiliumTo() does not render the multi-pool IPAM:\n%s", w.String())
+			}
+
+			manifest, err := stepper.PodIPPoolManifest()
+			if err != nil {
+				t.Fatalf("PodIPPoolManifest() error = %v", err)
+			}
+			steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "")
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			extra := len(tt.config.Pools) > 1
+			if apply := stepByName(steps, "applyCiliumPodIPPools"); (apply.Name != "") != extra {
+				t.Errorf("InstallSteps() = %v, want applyCiliumPodIPPools %v", stepNames(steps), extra)
+			}
+			if !extra {
+				if manifest != "" {
+					t.Errorf("PodIPPoolManifest() = %q, want empty", manifest)
+				}
+				return
+			}
+			for _, want := range []string{
+				"kind: CiliumPodIPPool\nmetadata:\n  labels:\n    kubeclipper.io/cilium-pod-ip-pool: \"true\"\n  name: gpu\nspec:\n  ipv4:\n    cidrs:\n    - 172.30.0.0/20\n    maskSize: 27\n",
+				"ipam-multi-pool-pre-allocation: default=8,gpu=8\n",
+				"  nodeSelector:\n    matchLabels:\n      pool: gpu\n",
+			} {
+				if !strings.Contains(manifest, want) {
+					t.Errorf("PodIPPoolManifest() does not contain %q:\n%s", want, manifest)
+				}
+			}
+			if strings.Contains(manifest, "name: default\n") {
+				t.Errorf("PodIPPoolManifest() contains the default pool the operator creates:\n%s", manifest)
+			}
+			steps, err = stepper.UninstallSteps(utils.UnwrapNodeList(metadata.GetAllNodes()))
+			if err != nil {
+				t.Fatalf("UninstallSteps() error = %v", err)
+			}
+			if joined := strings.Join(stepNames(steps), " "); !strings.HasPrefix(joined, "deleteCiliumPodIPPools uninstallCiliumRelease") {
+				t.Errorf("UninstallSteps() = %v, want the pools deleted before the release", joined)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_debug(t *testing.T) {
+	stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.14.4",
+		Cilium: &v1.Cilium{Debug: true, MonitorAggregation: "low"}}, &v1.Networking{}).(*CiliumRunnable)
+	if err := stepper.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	w := &bytes.Buffer{}
+	if err := stepper.renderCiliumTo(w); err != nil {
+		t.Fatalf("renderCiliumTo() error = %v", err)
+	}
+	for _, want := range []string{"\ndebug:\n  enabled: true\n", "\nbpf:\n  monitorAggregation: low\n"} {
+		if !strings.Contains(w.String(), want) {
+			t.Errorf("renderCiliumTo() output does not contain %q:\n%s", want, w.String())
+		}
+	}
+	invalid := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.14.4",
+		Cilium: &v1.Cilium{MonitorAggregation: "high"}}, &v1.Networking{})
+	if err := invalid.Validate(); err == nil {
+		t.Errorf("Validate() of monitor aggregation high succeeded")
+	}
+	for _, invalid := range []*v1.Cilium{{LogLevel: "verbose"}} {
+		stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.14.4", Cilium: invalid}, &v1.Networking{})
+		if err := stepper.Validate(); err == nil {
+			t.Errorf("Validate() of log level %s succeeded", invalid.LogLevel)
+		}
+	}
+	levels := []struct {
+		cilium  *v1.Cilium
+		level   string
+		verbose string
+	}{
+		{cilium: &v1.Cilium{}, level: CiliumLogLevelInfo},
+		{cilium: &v1.Cilium{Debug: true}, level: CiliumLogLevelDebug},
+		{cilium: &v1.Cilium{Debug: true, LogLevel: CiliumLogLevelInfo}, level: CiliumLogLevelInfo},
+		{cilium: &v1.Cilium{LogLevel: CiliumLogLevelTrace}, level: CiliumLogLevelTrace, verbose: ciliumTraceSubsystems},
+	}
+	for _, tt := range levels {
+		stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.14.4", Cilium: tt.cilium}, &v1.Networking{}).(*CiliumRunnable)
+		if got := stepper.LogLevel(); got != tt.level {
+			t.Errorf("LogLevel() of %+v = %s, want %s", tt.cilium, got, tt.level)
+		}
+		w := &bytes.Buffer{}
+		if err := stepper.renderCiliumTo(w); err != nil {
+			t.Fatalf("renderCiliumTo() error = %v", err)
+		}
+		if got := strings.Contains(w.String(), "\ndebug:\n  enabled: true\n"); got != (tt.level != CiliumLogLevelInfo) {
+			t.Errorf("renderCiliumTo() of log level %s renders debug: %t", tt.level, got)
+		}
+		if got := strings.Contains(w.String(), "  verbose: "+ciliumTraceSubsystems+"\n"); got != (tt.verbose != "") {
+			t.Errorf("renderCiliumTo() of log level %s renders debug.verbose: %t", tt.level, got)
+		}
+	}
+
+	node := v1.StepNode{ID: "node1"}
+	if _, err := OperationStep(stepper, "kube-system", OperationDebugOn, OperationOptions{}, node); err == nil {
+		t.Errorf("OperationStep() of %s without confirmation succeeded", OperationDebugOn)
+	}
+	for name, enabled := range map[string]string{OperationDebugOn: "true", OperationDebugOff: "false"} {
+		step, err := OperationStep(stepper, "kube-system", name, OperationOptions{Confirmed: true}, node)
+		if err != nil {
+			t.Fatalf("OperationStep() of %s error = %v", name, err)
+		}
+		cmd := step.Commands[0].ShellCommand[2]
+		for _, want := range []string{
+			"upgrade cilium /tmp/kc-downloader/.cilium/1.14.4/charts.tgz -n kube-system --reuse-values --set debug.enabled=" + enabled + " && ",
+			ciliumRestartCommand("kube-system"),
+		} {
+			if !strings.Contains(cmd, want) {
+				t.Errorf("%s command does not contain %q:\n%s", name, want, cmd)
+			}
+		}
+		if strings.Contains(cmd, "cilium.yaml") {
+			t.Errorf("%s command renders the values file:\n%s", name, cmd)
+		}
+	}
+}
+
+func TestStaleCiliumNodes(t *testing.T) {
+	list, err := os.ReadFile(filepath.Join("testdata", "ciliumnodes-restored.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		nodes []string
+		want  []string
+	}{
+		{name: "restored onto other nodes", nodes: []string{"master1", "worker1"}, want: []string{"old-master1", "old-worker1"}},
+		{name: "restored onto the same nodes", nodes: []string{"master1", "old-master1", "old-worker1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := staleCiliumNodes(list, tt.nodes)
+			if err != nil {
+				t.Fatalf("staleCiliumNodes() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("staleCiliumNodes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if _, err = staleCiliumNodes([]byte("No resources found"), nil); err == nil {
+		t.Errorf("staleCiliumNodes() of a non JSON list succeeded")
+	}
+}
+
+func TestCiliumRunnable_RestoreSteps(t *testing.T) {
+	metadata := &component.ExtraMetadata{
+		ClusterName: "c1",
+		Masters:     component.NodeList{{ID: "node1", Hostname: "master1"}},
+		Workers:     component.NodeList{{ID: "node2", Hostname: "worker1"}},
+	}
+	networking := v1.Networking{
+		Services: v1.NetworkRanges{CIDRBlocks: []string{"10.96.0.0/12"}},
+		Pods:     v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}},
+	}
+	cluster := &v1.Cluster{
+		CNI:        v1.CNI{Type: "cilium", Version: "1.14.4", Namespace: "kube-system", Cilium: &v1.Cilium{}},
+		Networking: networking,
+	}
+	pre, post, err := RestoreSteps(metadata, cluster)
+	if err != nil {
+		t.Fatalf("RestoreSteps() error = %v", err)
+	}
+	if got := stepNames(pre); !reflect.DeepEqual(got, []string{"captureCiliumCRDs"}) {
+		t.Errorf("pre-restore steps = %v", got)
+	}
+	want := []string{"waitCiliumCRDs", "checkCiliumReady", "deleteStaleCiliumNodes", "restartCiliumOperator"}
+	if got := stepNames(post); !reflect.DeepEqual(got, want) {
+		t.Fatalf("post-restore steps = %v, want %v", got, want)
+	}
+	for _, step := range append(pre, post...) {
+		if len(step.Nodes) != 1 || step.Nodes[0].ID != "node1" {
+			t.Errorf("step %s runs on %v, want the first master", step.Name, step.Nodes)
+		}
+	}
+	capture := strings.Join(pre[0].Commands[0].ShellCommand, " ")
+	wait := strings.Join(post[0].Commands[0].ShellCommand, " ")
+	if file := "/tmp/.cni/c1/cilium/" + ciliumRestoreCRDs; !strings.Contains(capture, file) || !strings.Contains(wait, file) {
+		t.Errorf("the CRDs are not passed through %s:\n%s\n%s", file, capture, wait)
+	}
+	stale := &CiliumStaleNodes{}
+	if err = json.Unmarshal(post[2].Commands[0].CustomCommand, stale); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stale.Nodes, []string{"master1", "worker1"}) {
+		t.Errorf("CiliumStaleNodes nodes = %v", stale.Nodes)
+	}
+
+	// the other cnis have no restore hooks
+	pre, post, err = RestoreSteps(metadata, &v1.Cluster{CNI: v1.CNI{Type: "calico", Version: "v3.26.1", Calico: &v1.Calico{}}, Networking: networking})
+	if err != nil || len(pre) != 0 || len(post) != 0 {
+		t.Errorf("RestoreSteps() of calico = %v, %v, %v", pre, post, err)
+	}
+
+	// an invalid cni is rejected before any step is generated
+	cluster.CNI.Cilium = &v1.Cilium{TunnelMode: "gre"}
+	pre, post, err = RestoreSteps(metadata, cluster)
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Field != "cilium.tunnelMode" || pre != nil || post != nil {
+		t.Errorf("RestoreSteps() of an invalid cilium = %v, %v, %v, want the error of cilium.tunnelMode", pre, post, err)
+	}
+}
@@ -0,0 +1,234 @@
+package cni
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/cmdutil"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ciliumClusterMesh = "cilium-clustermesh"
+
+	CiliumMeshServiceNodePort     = "NodePort"
+	CiliumMeshServiceLoadBalancer = "LoadBalancer"
+
+	ciliumMinClusterID = 1
+	ciliumMaxClusterID = 255
+
+	// the phases of the cluster mesh connection, every phase is a step
+	ciliumMeshPhaseSyncCA  = "syncCA"
+	ciliumMeshPhaseEnable  = "enable"
+	ciliumMeshPhaseConnect = "connect"
+
+	ciliumMeshTimeout = 10 * time.Minute
+)
+
+func init() {
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-"+ciliumClusterMesh, version, component.TypeStep), &CiliumClusterMesh{}); err != nil {
+		panic(err)
+	}
+}
+
+// ClusterMesh the cluster mesh configuration rendered by the values template, nil when the cluster is not meshed.
+func (runnable *CiliumRunnable) ClusterMesh() *v1.CiliumClusterMesh {
+	if runnable.CiliumConfig == nil {
+		return nil
+	}
+	return runnable.CiliumConfig.ClusterMesh
+}
+
+func (runnable *CiliumRunnable) clusterMeshEnabled() bool {
+	mesh := runnable.ClusterMesh()
+	return mesh != nil && mesh.EnableClusterMesh
+}
+
+// MeshServiceType the service type of the clustermesh-apiserver.
+func (runnable *CiliumRunnable) MeshServiceType() string {
+	return strutil.StringDefaultIfEmpty(CiliumMeshServiceNodePort, runnable.ClusterMesh().APIServerServiceType)
+}
+
+func (runnable *CiliumRunnable) validateClusterMesh() error {
+	mesh := runnable.ClusterMesh()
+	if mesh == nil {
+		return nil
+	}
+	if errs := validation.IsDNS1123Label(mesh.ClusterName); len(errs) > 0 {
+		return fmt.Errorf("invalid cilium cluster mesh name %q: must be a DNS-1123 label", mesh.ClusterName)
+	}
+	if mesh.ClusterID < ciliumMinClusterID || mesh.ClusterID > ciliumMaxClusterID {
+		return fmt.Errorf("invalid cilium cluster mesh id %d, must be between %d and %d", mesh.ClusterID, ciliumMinClusterID, ciliumMaxClusterID)
+	}
+	switch mesh.APIServerServiceType {
+	case "", CiliumMeshServiceNodePort, CiliumMeshServiceLoadBalancer:
+	default:
+		return fmt.Errorf("invalid cilium clustermesh-apiserver service type %q, supported values: %s, %s",
+			mesh.APIServerServiceType, CiliumMeshServiceNodePort, CiliumMeshServiceLoadBalancer)
+	}
+	return nil
+}
+
+// ValidateClusterMeshPeers checks the clusters of meshes can share a mesh: both of them run the clustermesh-apiserver
+// and their names and ids differ.
+func ValidateClusterMeshPeers(meshes ...*v1.CiliumClusterMesh) error {
+	names, ids := make(map[string]bool), make(map[int]bool)
+	for _, mesh := range meshes {
+		if mesh == nil || !mesh.EnableClusterMesh {
+			return fmt.Errorf("cilium cluster mesh must be enabled on every cluster of the mesh")
+		}
+		if names[mesh.ClusterName] {
+			return fmt.Errorf("cilium cluster mesh name %s is used by another cluster of the mesh", mesh.ClusterName)
+		}
+		if ids[mesh.ClusterID] {
+			return fmt.Errorf("cilium cluster mesh id %d is used by another cluster of the mesh", mesh.ClusterID)
+		}
+		names[mesh.ClusterName], ids[mesh.ClusterID] = true, true
+	}
+	return nil
+}
+
+// ClusterMeshSteps connects the cluster to peer from nodes, the first master of the cluster. kubeconfig has
+// a context for each cluster named after its mesh name. The steps share the cilium CA of the cluster with peer,
+// generating it when the cluster has none, enable the cluster mesh of both clusters and connect them.
+func (runnable *CiliumRunnable) ClusterMeshSteps(peer *CiliumRunnable, kubeconfig []byte, nodes []v1.StepNode) ([]v1.Step, error) {
+	if err := ValidateClusterMeshPeers(runnable.ClusterMesh(), peer.ClusterMesh()); err != nil {
+		return nil, err
+	}
+	cli, err := runnable.installCLI(nodes)
+	if err != nil {
+		return nil, err
+	}
+	steps := []v1.Step{cli}
+	for _, phase := range []string{ciliumMeshPhaseSyncCA, ciliumMeshPhaseEnable, ciliumMeshPhaseConnect} {
+		custom, err := json.Marshal(&CiliumClusterMesh{
+			Phase:           phase,
+			Kubeconfig:      kubeconfig,
+			Cluster:         runnable.ClusterMesh().ClusterName,
+			Namespace:       runnable.Namespace,
+			ServiceType:     runnable.MeshServiceType(),
+			Peer:            peer.ClusterMesh().ClusterName,
+			PeerNamespace:   peer.Namespace,
+			PeerServiceType: peer.MeshServiceType(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, v1.Step{
+			ID:         newStepID(),
+			Name:       fmt.Sprintf("clusterMesh-%s", phase),
+			Timeout:    metav1.Duration{Duration: ciliumMeshTimeout},
+			ErrIgnore:  false,
+			RetryTimes: 1,
+			Nodes:      nodes,
+			Action:     v1.ActionInstall,
+			Commands: []v1.Command{
+				{
+					Type:          v1.CommandCustom,
+					Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+ciliumClusterMesh, version, component.TypeStep),
+					CustomCommand: custom,
+				},
+			},
+		})
+	}
+	return runnable.stableSteps(runnable.withRetryPolicy(steps), nil)
+}
+
+// CiliumClusterMesh runs a phase of the cluster mesh connection between Cluster and Peer through the cilium CLI.
+// The kubeconfig of both clusters is written to a temporary file for the phase only, it is not in the step log.
+type CiliumClusterMesh struct {
+	Phase           string `json:"phase"`
+	Kubeconfig      []byte `json:"kubeconfig"`
+	Cluster         string `json:"cluster"`
+	Namespace       string `json:"namespace"`
+	ServiceType     string `json:"serviceType"`
+	Peer            string `json:"peer"`
+	PeerNamespace   string `json:"peerNamespace"`
+	PeerServiceType string `json:"peerServiceType"`
+}
+
+func (m *CiliumClusterMesh) NewInstance() component.ObjectMeta {
+	return &CiliumClusterMesh{}
+}
+
+func (m *CiliumClusterMesh) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	f, err := os.CreateTemp("", "clustermesh-*.kubeconfig")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(m.Kubeconfig)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	var script string
+	switch m.Phase {
+	case ciliumMeshPhaseSyncCA:
+		script = m.syncCAScript(f.Name())
+	case ciliumMeshPhaseEnable:
+		script = fmt.Sprintf(`export KUBECONFIG=%s
+set -e
+cilium clustermesh enable --context %s -n %s --service-type %s
+cilium clustermesh enable --context %s -n %s --service-type %s
+cilium clustermesh status --context %s -n %s --wait
+cilium clustermesh status --context %s -n %s --wait`,
+			f.Name(), m.Cluster, m.Namespace, m.ServiceType, m.Peer, m.PeerNamespace, m.PeerServiceType,
+			m.Cluster, m.Namespace, m.Peer, m.PeerNamespace)
+	case ciliumMeshPhaseConnect:
+		script = fmt.Sprintf(`export KUBECONFIG=%s
+set -e
+cilium clustermesh connect --context %s -n %s --destination-context %s
+cilium clustermesh status --context %s -n %s --wait`,
+			f.Name(), m.Cluster, m.Namespace, m.Peer, m.Cluster, m.Namespace)
+	default:
+		return nil, fmt.Errorf("unknown cilium cluster mesh phase %q", m.Phase)
+	}
+	_, err = cmdutil.RunCmdWithContext(ctx, opts.DryRun, "/bin/bash", "-c", script)
+	return nil, err
+}
+
+func (m *CiliumClusterMesh) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	return nil, nil
+}
+
+// syncCAScript copies the cilium CA of the cluster to the peer, both clusters must trust the clustermesh-apiserver
+// certificates of each other. The CA is generated when the cluster has none, e.g. the chart does not manage it.
+// The agents of the peer are restarted to reload the CA when it was replaced.
+func (m *CiliumClusterMesh) syncCAScript(kubeconfig string) string {
+	return fmt.Sprintf(`export KUBECONFIG=%[1]s
+set -e
+dir=$(mktemp -d)
+trap 'rm -rf $dir' EXIT
+ca() { kubectl --context $1 -n $2 get secret cilium-ca -o jsonpath="{.data.ca\\.$3}" 2>/dev/null; }
+if [ -z "$(ca %[2]s %[3]s crt)" ]; then
+  openssl req -x509 -newkey rsa:2048 -nodes -days 3650 -subj "/CN=Cilium CA" -keyout $dir/ca.key -out $dir/ca.crt
+  kubectl --context %[2]s -n %[3]s create secret generic cilium-ca --from-file=ca.crt=$dir/ca.crt --from-file=ca.key=$dir/ca.key
+fi
+[ "$(ca %[2]s %[3]s crt)" = "$(ca %[4]s %[5]s crt)" ] && exit 0
+ca %[2]s %[3]s crt | base64 -d > $dir/ca.crt
+ca %[2]s %[3]s key | base64 -d > $dir/ca.key
+kubectl --context %[4]s -n %[5]s create secret generic cilium-ca --from-file=ca.crt=$dir/ca.crt --from-file=ca.key=$dir/ca.key --dry-run=client -o yaml | \
+  kubectl --context %[4]s apply -f -
+kubectl --context %[4]s -n %[5]s rollout restart ds/cilium`, kubeconfig, m.Cluster, m.Namespace, m.Peer, m.PeerNamespace)
+}
+
+// EtcdImage the etcd repository of the clustermesh-apiserver, rewritten to LocalRegistry when it is set.
+func (runnable *CiliumRunnable) EtcdImage() string {
+	if runnable.LocalRegistry == "" {
+		return ciliumEtcdImage
+	}
+	return strings.TrimSuffix(runnable.LocalRegistry, "/") + "/coreos/etcd"
+}
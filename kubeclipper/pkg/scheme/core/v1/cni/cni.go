@@ -2,15 +2,32 @@ package cni
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/component/common"
 	"github.com/kubeclipper/kubeclipper/pkg/component/utils"
 	"github.com/kubeclipper/kubeclipper/pkg/logger"
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
 	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/cmdutil"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/sysutil"
 	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
 )
 
 var cniFactories = make(map[string]CniFactory)
@@ -37,33 +54,410 @@ const (
 	manifestDir = "/tmp/.cni"
 )
 
+// newStepID generates the IDs of the steps, the tests replace it to render the step plans deterministically.
+var newStepID = strutil.GetUUID
+
 type BaseCni struct {
 	v1.CNI
 	DualStack   bool   `json:"dualStack"`
 	PodIPv4CIDR string `json:"podIPv4CIDR"`
 	PodIPv6CIDR string `json:"podIPv6CIDR"`
+	// Arch the architecture of the nodes of the image load step, the agent architecture is used when it is empty.
+	Arch string `json:"arch,omitempty"`
+	// ManifestDir the directory the manifests and helm values are rendered to on the nodes, see clusterManifestDir.
+	// The shared manifestDir is used when it is empty, e.g. by the steps of the operations created before it was added.
+	ManifestDir string `json:"manifestDir,omitempty"`
+	// Retags the names the loaded images are tagged with after the load, the offline package contains the images
+	// under the names of their upstream repositories while the rendered values may reference others.
+	Retags []RegistryImage `json:"retags,omitempty"`
+	// registryAccess the credentials and TLS settings of LocalRegistry, they are only passed to the steps reaching the registry.
+	registryAccess RegistryAccess
+	// nodeStates the unavailable nodes of the cluster, the per-node steps skip them and the steps requiring them fail.
+	nodeStates nodeStates
+	// Facts the facts of the node the templates are rendered on, e.g. {{ .Facts.KernelVersion }}, see gatherFacts.
+	// They are empty when the templates are rendered on the server.
+	Facts sysutil.Facts `json:"-"`
+}
+
+// gatherFacts exposes the node-local facts of ctx to the templates as .Facts.
+func (runnable *BaseCni) gatherFacts(ctx context.Context) {
+	runnable.Facts = component.GetFacts(ctx)
+}
+
+// clusterManifestDir the manifest directory of the cni of a cluster, manifestDir/<cluster>/<cni>, so that the
+// operations of the clusters sharing a node do not overwrite the rendered files of each other.
+func clusterManifestDir(cluster, cni string) string {
+	if cluster == "" {
+		return manifestDir
+	}
+	return filepath.Join(manifestDir, cluster, cni)
+}
+
+// manifestPath the path of the rendered manifest name on the nodes, the path the render and the apply steps share.
+func (runnable *BaseCni) manifestPath(name string) string {
+	return filepath.Join(strutil.StringDefaultIfEmpty(manifestDir, runnable.ManifestDir), name)
+}
+
+// SemVer parses Version, the v prefix is optional. It is nil when Version is empty or not a version.
+func (runnable *BaseCni) SemVer() *utilversion.Version {
+	v, err := utilversion.ParseGeneric(strings.TrimPrefix(runnable.Version, "v"))
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// VersionAtLeast reports whether Version is min or later, e.g. {{ if .VersionAtLeast "1.14" }} in the values
+// templates. An unknown version is treated as the latest one.
+func (runnable *BaseCni) VersionAtLeast(min string) bool {
+	v := runnable.SemVer()
+	return v == nil || v.AtLeast(utilversion.MustParseGeneric(min))
 }
 
 type Stepper interface {
 	InitStep(metadata *component.ExtraMetadata, cni *v1.CNI, networking *v1.Networking) Stepper
+	// Validate checks the cni configuration initialized by InitStep, it must be called before any steps are generated.
+	Validate() error
 	LoadImage(nodes []v1.StepNode) ([]v1.Step, error)
 	InstallSteps(nodes []v1.StepNode, kubeVersion string) ([]v1.Step, error)
 	UninstallSteps(nodes []v1.StepNode) ([]v1.Step, error)
+	// RollbackSteps undoes InstallSteps run on nodes, and the images LoadImage loaded, when a later step of the
+	// cluster creation failed so that the install can run again. The steps succeed when nothing was installed.
+	RollbackSteps(nodes []v1.StepNode) ([]v1.Step, error)
+	// JoinNodeSteps prepares the nodes joining the cluster for the installed cni without reinstalling it,
+	// the steps run after the nodes joined.
+	JoinNodeSteps(nodes []v1.StepNode) ([]v1.Step, error)
+	// LeaveNodeSteps cleans the cni up from the nodes leaving the cluster, the installed cni is kept.
+	LeaveNodeSteps(nodes []v1.StepNode) ([]v1.Step, error)
+	// UpgradeSteps upgrades the installed cni from fromVersion to toVersion in place.
+	UpgradeSteps(nodes []v1.StepNode, fromVersion, toVersion string) ([]v1.Step, error)
+	// MigrationSteps replaces the installed cni from, initialized with the installed spec, by this one on nodes,
+	// which must be all nodes of the cluster.
+	MigrationSteps(from Stepper, nodes []v1.StepNode) ([]v1.Step, error)
+	// CheckSteps checks the health of the installed cni on nodes, every step responds with a HealthResult.
+	CheckSteps(nodes []v1.StepNode) ([]v1.Step, error)
+	// PreRestoreSteps run before the etcd backup of the cluster is restored, e.g. to capture the cni state the
+	// restore replaces. The steps succeed when the cni has nothing to capture.
+	PreRestoreSteps(nodes []v1.StepNode) ([]v1.Step, error)
+	// PostRestoreSteps reconcile the cni state of the restored etcd backup with the nodes of the cluster, which
+	// may be others than the nodes the backup was taken on.
+	PostRestoreSteps(nodes []v1.StepNode) ([]v1.Step, error)
+	// GetImages returns the images of the cni version, used to build the offline image packages.
+	GetImages(version string, criType string) ([]string, error)
+	// RenderString returns the rendered manifests or helm values without writing them to disk.
+	RenderString(ctx context.Context) (string, error)
+	// Operations returns the commands operating the cni installed in namespace, e.g. its status and logs.
+	Operations(namespace string) []Operation
+	// CmdList returns the commands of Operations keyed by operation name.
 	CmdList(namespace string) map[string]string
 }
 
+// Plan the steps a cni would run on a cluster, generated without running them.
+type Plan struct {
+	LoadImage []v1.Step `json:"loadImage,omitempty"`
+	Install   []v1.Step `json:"install"`
+	Uninstall []v1.Step `json:"uninstall"`
+	// Values the rendered manifests or helm values the install steps write.
+	Values string `json:"values"`
+}
+
+// NewPlan generates the plan of stepper, which must be validated, in the same way a cluster is installed:
+// the images are loaded on all nodes of offline clusters and the cni is installed from the first master.
+// The step IDs are cleared so that the plans of the same spec are the same.
+func NewPlan(ctx context.Context, stepper Stepper, offline bool, masters, nodes []v1.StepNode, kubeVersion string) (*Plan, error) {
+	if len(masters) == 0 {
+		return nil, fmt.Errorf("the cni plan requires at least one master")
+	}
+	plan := &Plan{}
+	var err error
+	if offline {
+		if plan.LoadImage, err = stepper.LoadImage(nodes); err != nil {
+			return nil, err
+		}
+	}
+	if plan.Install, err = stepper.InstallSteps([]v1.StepNode{masters[0]}, kubeVersion); err != nil {
+		return nil, err
+	}
+	if plan.Uninstall, err = stepper.UninstallSteps(nodes); err != nil {
+		return nil, err
+	}
+	if plan.Values, err = stepper.RenderString(ctx); err != nil {
+		return nil, err
+	}
+	for _, steps := range [][]v1.Step{plan.LoadImage, plan.Install, plan.Uninstall} {
+		for i := range steps {
+			steps[i].ID = ""
+		}
+	}
+	return plan, nil
+}
+
+const (
+	minStepTimeout = 30 * time.Second
+	maxStepTimeout = 1 * time.Hour
+
+	imageLoadTimeoutDefault = 5 * time.Minute
+
+	retryTimesDefault    = 3
+	retryIntervalDefault = 10 * time.Second
+	backoffFactorDefault = 2
+	maxRetryTimes        = 10
+	maxRetryInterval     = 5 * time.Minute
+	maxBackoffFactor     = 10
+)
+
+// FieldError an error of the Validate of a stepper caused by the field of the v1.CNI at Field, e.g.
+// cilium.tunnelMode, so that the validation of the clusters reports it on that field.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+// Invalid the error on the field of the v1.CNI at fldPath, the message of Err names the invalid value.
+func (e *FieldError) Invalid(fldPath *field.Path) *field.Error {
+	names := strings.Split(e.Field, ".")
+	return field.Invalid(fldPath.Child(names[0], names[1:]...), field.OmitValueType{}, e.Err.Error())
+}
+
+// Error the error on the field of the cni of the cluster, e.g. the steps of the operations on the cni
+// report it as the validation of the clusters does.
+func (e *FieldError) Error() string {
+	return e.Invalid(field.NewPath("cni")).Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// fieldError err caused by field, nil when err is nil.
+func fieldError(field string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &FieldError{Field: field, Err: err}
+}
+
+// validateTimeouts rejects the step timeouts out of range, zero means unset.
+func (runnable *BaseCni) validateTimeouts() error {
+	if runnable.Timeouts == nil {
+		return nil
+	}
+	for name, d := range map[string]time.Duration{
+		"install":   runnable.Timeouts.Install.Duration,
+		"uninstall": runnable.Timeouts.Uninstall.Duration,
+		"imageLoad": runnable.Timeouts.ImageLoad.Duration,
+	} {
+		if d != 0 && (d < minStepTimeout || d > maxStepTimeout) {
+			return fmt.Errorf("cni %s timeout %s must be between %s and %s", name, d, minStepTimeout, maxStepTimeout)
+		}
+	}
+	return nil
+}
+
+// validateChartSource checks the chart source is an OCI repository, the only source helm pulls from.
+func (runnable *BaseCni) validateChartSource() error {
+	if runnable.ChartSource == "" {
+		return nil
+	}
+	ref := strings.TrimPrefix(runnable.ChartSource, common.OCIScheme)
+	if ref == runnable.ChartSource || ref == "" || strings.ContainsAny(ref, " \t\n;&|$`'\"<>()") {
+		return fmt.Errorf("invalid cni chart source %q: must be an OCI repository like %sharbor.example.com/charts", runnable.ChartSource, common.OCIScheme)
+	}
+	return nil
+}
+
+// validateRegistryPush checks the images are pushed only when the nodes pull them from LocalRegistry.
+func (runnable *BaseCni) validateRegistryPush() error {
+	if runnable.PushToRegistry && (!runnable.Offline || runnable.LocalRegistry == "") {
+		return fmt.Errorf("pushing the cni images to the registry requires an offline cni with a local registry")
+	}
+	if runnable.RemovePushedImages && !runnable.PushToRegistry {
+		return fmt.Errorf("removing the pushed cni images requires pushing them to the registry")
+	}
+	return nil
+}
+
+func (runnable *BaseCni) installTimeout(def time.Duration) time.Duration {
+	if runnable.Timeouts == nil {
+		return def
+	}
+	return durationDefaultIfZero(runnable.Timeouts.Install.Duration, def)
+}
+
+func (runnable *BaseCni) uninstallTimeout(def time.Duration) time.Duration {
+	if runnable.Timeouts == nil {
+		return def
+	}
+	return durationDefaultIfZero(runnable.Timeouts.Uninstall.Duration, def)
+}
+
+// StepComponent the component of the cni steps, see v1.Step Component.
+const StepComponent = "cni"
+
+// stableSteps derives the IDs of steps from their name, nodes and the cni type and version instead of random ones,
+// and sets their InputHash, so that the steps generated again for the same cluster are the same and a retry of
+// the operation skips the steps it completed.
+func (runnable *BaseCni) stableSteps(steps []v1.Step, err error) ([]v1.Step, error) {
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]int, len(steps))
+	for i := range steps {
+		steps[i].Component = StepComponent
+		steps[i].ErrorMatchers = withCNIErrorMatchers(steps[i].ErrorMatchers)
+		nodes := make([]string, 0, len(steps[i].Nodes))
+		for _, node := range steps[i].Nodes {
+			nodes = append(nodes, node.ID)
+		}
+		key := strings.Join([]string{runnable.CNI.Type, runnable.Version, steps[i].Name, strings.Join(nodes, ",")}, "/")
+		// the same step may run twice on the same nodes, e.g. the manifests rendered for the cni and for multus
+		seen[key]++
+		steps[i].ID = uuid.NewSHA1(uuid.NameSpaceURL, []byte(fmt.Sprintf("kubeclipper.io/cni/%s/%d", key, seen[key]))).String()
+		if steps[i].InputHash, err = stepInputHash(steps[i]); err != nil {
+			return nil, err
+		}
+	}
+	return steps, nil
+}
+
+// stepInputHash the hash of the definition of step, its ID, the steps registered on it, its error matchers and
+// whether it reports its progress are left out.
+func stepInputHash(step v1.Step) (string, error) {
+	step.ID, step.InputHash, step.RollbackSteps, step.RollbackAfter = "", "", nil, ""
+	step.ErrorMatchers = nil
+	step.ReportProgress = false
+	data, err := json.Marshal(step)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16], nil
+}
+
+// rollbackImages removes the offline images of name from nodes, the nodes they were not loaded on are ignored.
+// custom is the stepper the images were loaded for.
+func (runnable *BaseCni) rollbackImages(name string, custom Stepper, nodes []v1.StepNode) ([]v1.Step, error) {
+	if !runnable.Offline || runnable.LocalRegistry != "" || len(nodes) == 0 {
+		return nil, nil
+	}
+	bytes, err := json.Marshal(custom)
+	if err != nil {
+		return nil, err
+	}
+	remove := RemoveImage(name, bytes, nodes)
+	remove.ErrIgnore = true
+	return []v1.Step{remove}, nil
+}
+
+func (runnable *BaseCni) imageLoadTimeout() time.Duration {
+	if runnable.Timeouts == nil {
+		return imageLoadTimeoutDefault
+	}
+	return durationDefaultIfZero(runnable.Timeouts.ImageLoad.Duration, imageLoadTimeoutDefault)
+}
+
+// validateRetryPolicy rejects the retry policies out of range.
+func (runnable *BaseCni) validateRetryPolicy() error {
+	policy := runnable.RetryPolicy
+	if policy == nil {
+		return nil
+	}
+	if policy.RetryTimes != nil && (*policy.RetryTimes < 0 || *policy.RetryTimes > maxRetryTimes) {
+		return fmt.Errorf("cni retry times %d must be between 0 and %d", *policy.RetryTimes, maxRetryTimes)
+	}
+	if policy.RetryInterval != nil && (policy.RetryInterval.Duration < 0 || policy.RetryInterval.Duration > maxRetryInterval) {
+		return fmt.Errorf("cni retry interval %s must be between 0s and %s", policy.RetryInterval.Duration, maxRetryInterval)
+	}
+	if policy.BackoffFactor < 0 || policy.BackoffFactor > maxBackoffFactor {
+		return fmt.Errorf("cni retry backoff factor %d must be between 0 and %d (0 means the default)", policy.BackoffFactor, maxBackoffFactor)
+	}
+	return nil
+}
+
+// retryPolicy the retry policy with the unset fields defaulted.
+func (runnable *BaseCni) retryPolicy() (retryTimes int32, interval time.Duration, factor int32) {
+	retryTimes, interval, factor = retryTimesDefault, retryIntervalDefault, backoffFactorDefault
+	if policy := runnable.RetryPolicy; policy != nil {
+		if policy.RetryTimes != nil {
+			retryTimes = *policy.RetryTimes
+		}
+		if policy.RetryInterval != nil {
+			interval = policy.RetryInterval.Duration
+		}
+		if policy.BackoffFactor != 0 {
+			factor = policy.BackoffFactor
+		}
+	}
+	return retryTimes, interval, factor
+}
+
+// withRetryPolicy sets the retry policy on steps. The attempts and the retry delays of a step share its timeout
+// on the agent, so the timeouts are extended to one timeout per attempt plus the delays. The steps which set their
+// retry fields, e.g. v1.Step RetryPolicySet, keep them and the steps of nested step generators are not extended twice.
+func (runnable *BaseCni) withRetryPolicy(steps []v1.Step) []v1.Step {
+	retryTimes, interval, factor := runnable.retryPolicy()
+	for i := range steps {
+		if steps[i].RetryPolicySet || steps[i].RetryInterval.Duration != 0 {
+			continue
+		}
+		steps[i].RetryTimes = retryTimes
+		steps[i].RetryInterval = metav1.Duration{Duration: interval}
+		steps[i].RetryBackoffFactor = factor
+		steps[i].RetryPolicySet = true
+		steps[i].Timeout.Duration *= time.Duration(retryTimes + 1)
+		for retry := 1; retry <= int(retryTimes); retry++ {
+			steps[i].Timeout.Duration += steps[i].RetryDelay(retry)
+		}
+	}
+	return steps
+}
+
+// withProgress reports the output of steps while they run, see v1.Step ReportProgress.
+func withProgress(steps []v1.Step) []v1.Step {
+	for i := range steps {
+		steps[i].ReportProgress = true
+	}
+	return steps
+}
+
+// inParallel marks steps Parallel, each of them depends on the one before it, e.g. the chart download starts along
+// with the image load once helm is installed.
+func inParallel(steps []v1.Step) []v1.Step {
+	for i := range steps {
+		steps[i].Parallel = true
+		if i > 0 {
+			steps[i].DependsOn = append(steps[i].DependsOn, steps[i-1].Name)
+		}
+	}
+	return steps
+}
+
+func durationDefaultIfZero(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
+
 func (runnable *BaseCni) NewInstance() component.ObjectMeta {
 	return &BaseCni{}
 }
 
 func (runnable *BaseCni) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	if runnable.Arch != "" && runnable.Arch != runtime.GOARCH {
+		return nil, fmt.Errorf("the node architecture is %s, the image load step is for %s nodes", runtime.GOARCH, runnable.Arch)
+	}
 	instance, err := downloader.NewInstance(ctx, runnable.Type, runnable.Version, runtime.GOARCH, !runnable.Offline, opts.DryRun)
 	if err != nil {
 		return nil, err
 	}
 
-	if runnable.Offline && runnable.LocalRegistry == "" {
-		dstFile, err := instance.DownloadImages()
+	if runnable.Offline && (runnable.LocalRegistry == "" || len(runnable.Retags) > 0) {
+		dstFile, err := fetchImages(ctx, runnable.Type, instance)
+		if errors.Is(err, downloader.ErrNotFound) {
+			return nil, fmt.Errorf("the %s-%s offline package for %s is missing, push %s-%s-%s.tar.gz to the package server: %v",
+				runnable.Type, runnable.Version, runtime.GOARCH, runnable.Type, runnable.Version, runtime.GOARCH, err)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -71,12 +465,51 @@ func (runnable *BaseCni) Install(ctx context.Context, opts component.Options) ([
 		if err = utils.LoadImage(ctx, opts.DryRun, dstFile, runnable.CriType); err != nil {
 			return nil, err
 		}
-		logger.Info("calico packages offline install successfully")
+		// the load only succeeds once the images have the names the values reference
+		for _, image := range runnable.Retags {
+			if err = utils.TagImage(ctx, opts.DryRun, image.Source, image.Target, runnable.CriType); err != nil {
+				return nil, fmt.Errorf("tag image %s as %s: %w", image.Source, image.Target, err)
+			}
+		}
+		logger.Infof("%s packages offline install successfully", runnable.Type)
 	}
 
 	return nil, nil
 }
 
+// imageDistributor distributes the offline image package to the node, see downloader.Downloader.
+type imageDistributor interface {
+	DownloadImages() (string, error)
+	VerifyImages() error
+	RemoveImages() error
+}
+
+// fetchImages fetches the offline image package of the cni name from dist and verifies it before it is loaded.
+// A package not matching its checksum, e.g. a copy cut short, is fetched once more from the package server
+// before the load fails.
+func fetchImages(ctx context.Context, name string, dist imageDistributor) (string, error) {
+	file, err := dist.DownloadImages()
+	if err != nil {
+		return "", err
+	}
+	if err = dist.VerifyImages(); !errors.Is(err, downloader.ErrChecksumMismatch) {
+		return file, err
+	}
+	logger.Warnf("%v, redistribute the %s images", err, name)
+	if err = dist.RemoveImages(); err != nil {
+		return "", err
+	}
+	if file, err = dist.DownloadImages(); err != nil {
+		return "", err
+	}
+	line := fmt.Sprintf("[%s] checksum mismatch, redistributed %s-%s, retrying\n", time.Now().Format(time.RFC3339), name, downloader.ImageFilename)
+	_, _ = cmdutil.CheckContextAndAppendStepLogFile(ctx, []byte(line))
+	if err = dist.VerifyImages(); err != nil {
+		return "", fmt.Errorf("the redistributed %s images are corrupt too: %w", name, err)
+	}
+	return file, nil
+}
+
 func (runnable *BaseCni) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
 	instance, err := downloader.NewInstance(ctx, runnable.Type, runnable.Version, runtime.GOARCH, !runnable.Offline, opts.DryRun)
 	if err != nil {
@@ -88,8 +521,89 @@ func (runnable *BaseCni) Uninstall(ctx context.Context, opts component.Options)
 	return nil, nil
 }
 
-// RecoveryCNICmd get recovery cni cmd
-func RecoveryCNICmd(metadata *component.ExtraMetadata) (cmdList map[string]string, err error) {
+// clusterScopedNodes returns the node running the cluster scoped uninstall steps, the first master of the cluster,
+// ok is false when nodes do not cover allNodes, i.e. only some nodes leave the cluster.
+func clusterScopedNodes(nodes, allNodes, masters []v1.StepNode) ([]v1.StepNode, bool) {
+	ids := sets.NewString()
+	for _, node := range nodes {
+		ids.Insert(node.ID)
+	}
+	for _, node := range allNodes {
+		if !ids.Has(node.ID) {
+			return nil, false
+		}
+	}
+	if len(masters) > 0 {
+		return masters[:1], true
+	}
+	if len(nodes) > 0 {
+		return nodes[:1], true
+	}
+	return nodes, true
+}
+
+// NodeLabels returns the labels the kubernetes nodes of the cluster carry, keyed by node ID. They are the labels of
+// the cluster spec and the well-known labels set by the kubelet.
+func NodeLabels(nodes component.NodeList) map[string]labels.Set {
+	set := make(map[string]labels.Set, len(nodes))
+	for _, node := range nodes {
+		l := labels.Set{"kubernetes.io/os": "linux"}
+		if node.Hostname != "" {
+			l["kubernetes.io/hostname"] = node.Hostname
+		}
+		if node.Arch != "" {
+			l["kubernetes.io/arch"] = node.Arch
+		}
+		for k, v := range node.Labels {
+			l[k] = v
+		}
+		set[node.ID] = l
+	}
+	return set
+}
+
+// selectNodes returns the nodes matching selector, the nodes without nodeLabels are kept
+// since the DaemonSet may still be scheduled to them.
+func selectNodes(nodes []v1.StepNode, nodeLabels map[string]labels.Set, selector map[string]string) []v1.StepNode {
+	if len(selector) == 0 {
+		return nodes
+	}
+	match := labels.SelectorFromSet(selector)
+	selected := make([]v1.StepNode, 0, len(nodes))
+	for _, node := range nodes {
+		if l, ok := nodeLabels[node.ID]; !ok || match.Matches(l) {
+			selected = append(selected, node)
+		}
+	}
+	return selected
+}
+
+// RestoreSteps returns the pre-restore and post-restore steps of the cni of the cluster restored from a backup,
+// they run on the nodes of metadata around the restore of the etcd backup.
+func RestoreSteps(metadata *component.ExtraMetadata, cluster *v1.Cluster) (pre, post []v1.Step, err error) {
+	if cluster == nil || cluster.CNI.Type == "" {
+		return nil, nil, nil
+	}
+	c, err := Load(cluster.CNI.Type)
+	if err != nil {
+		return nil, nil, err
+	}
+	stepper := c.Create().InitStep(metadata, &cluster.CNI, &cluster.Networking)
+	if err = stepper.Validate(); err != nil {
+		return nil, nil, err
+	}
+	nodes := utils.UnwrapNodeList(metadata.GetAllNodes())
+	if pre, err = stepper.PreRestoreSteps(nodes); err != nil {
+		return nil, nil, err
+	}
+	if post, err = stepper.PostRestoreSteps(nodes); err != nil {
+		return nil, nil, err
+	}
+	return pre, post, nil
+}
+
+// RecoveryCNICmd get recovery cni cmd, the cluster completes the commands which depend on the cni spec, such as the release name
+func RecoveryCNICmd(metadata *component.ExtraMetadata, cluster *v1.Cluster) (cmdList map[string]string, err error) {
 	c, err := Load(metadata.CNI)
 	if err != nil {
 		return
@@ -99,5 +613,9 @@ func RecoveryCNICmd(metadata *component.ExtraMetadata) (cmdList map[string]strin
 		return
 	}
 
-	return c.Create().CmdList(metadata.CNINamespace), nil
+	stepper := c.Create()
+	if cluster != nil {
+		stepper = stepper.InitStep(metadata, &cluster.CNI, &cluster.Networking)
+	}
+	return stepper.CmdList(metadata.CNINamespace), nil
 }
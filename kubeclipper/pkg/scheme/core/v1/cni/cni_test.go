@@ -0,0 +1,333 @@
+package cni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/oplog"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestBaseCni_stableSteps(t *testing.T) {
+	metadata := &component.ExtraMetadata{CRI: "containerd", Masters: component.NodeList{{ID: "node1"}}, Workers: component.NodeList{{ID: "node2"}}}
+	master := []v1.StepNode{{ID: "node1"}}
+	installSteps := func(config *v1.Cilium) []v1.Step {
+		t.Helper()
+		stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Type: "cilium", Version: "1.14.4", Offline: true, Cilium: config},
+			&v1.Networking{DNSDomain: "cluster.local"})
+		loadSteps, err := stepper.LoadImage([]v1.StepNode{{ID: "node1"}, {ID: "node2"}})
+		if err != nil {
+			t.Fatalf("LoadImage() error = %v", err)
+		}
+		steps, err := stepper.InstallSteps(master, "v1.27.4")
+		if err != nil {
+			t.Fatalf("InstallSteps() error = %v", err)
+		}
+		return append(loadSteps, steps...)
+	}
+
+	first := installSteps(&v1.Cilium{})
+	ids := sets.New[string]()
+	for _, step := range first {
+		if step.InputHash == "" {
+			t.Errorf("step %s has no input hash", step.Name)
+		}
+		ids.Insert(step.ID)
+	}
+	if ids.Len() != len(first) {
+		t.Fatalf("the step IDs %v are not unique", stepNames(first))
+	}
+
+	// the first attempt failed at the install of the release
+	failed := stepIndex(first, "installCiliumRelease")
+	status := &v1.OperationStatus{Completed: map[string]string{}}
+	for _, step := range first[:failed] {
+		status.Completed[step.ID] = step.InputHash
+	}
+	second := installSteps(&v1.Cilium{})
+	if len(second) != len(first) {
+		t.Fatalf("the second InstallSteps() = %v, want %v", stepNames(second), stepNames(first))
+	}
+	for i, step := range second {
+		if got, want := status.StepCompleted(&step), i < failed; got != want {
+			t.Errorf("StepCompleted(%s) = %v, want %v", step.Name, got, want)
+		}
+	}
+
+	// the steps whose definition changed run again
+	changed := installSteps(&v1.Cilium{IPAMMode: "kubernetes"})
+	render := stepIndex(changed, "renderCniYaml")
+	if changed[render].ID != first[render].ID {
+		t.Errorf("the changed renderCniYaml step ID = %s, want %s", changed[render].ID, first[render].ID)
+	}
+	if status.StepCompleted(&changed[render]) {
+		t.Errorf("the changed renderCniYaml step is completed")
+	}
+	if step := (v1.Step{ID: first[0].ID}); status.StepCompleted(&step) {
+		t.Errorf("a step without input hash is completed")
+	}
+}
+
+func TestBaseCni_stableStepsRetry(t *testing.T) {
+	metadata := &component.ExtraMetadata{
+		CRI:     "containerd",
+		Masters: component.NodeList{{ID: "master1", Hostname: "master1"}},
+		Workers: component.NodeList{{ID: "worker1", Hostname: "worker1"}},
+	}
+	networking := &v1.Networking{Pods: v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}}}
+	all := []v1.StepNode{{ID: "master1", Hostname: "master1"}, {ID: "worker1", Hostname: "worker1"}}
+	tests := []struct {
+		name   string
+		plan   func() ([]v1.Step, error)
+		failed string
+	}{
+		{
+			name: "upgrade",
+			plan: func() ([]v1.Step, error) {
+				stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Type: "cilium", Version: "1.14.4"}, networking)
+				return stepper.UpgradeSteps(all[:1], "1.14.4", "1.15.1")
+			},
+			failed: "upgradeCiliumRelease",
+		},
+		{
+			name: "migration",
+			plan: func() ([]v1.Step, error) {
+				calico := (&CalicoRunnable{}).InitStep(metadata, &v1.CNI{Type: "calico", Version: "v3.26.1",
+					Calico: &v1.Calico{Mode: CalicoNetworkIPIPAll}}, networking)
+				stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Type: "cilium", Version: "1.14.4"}, networking)
+				return stepper.MigrationSteps(calico, all)
+			},
+			// the master was drained and migrated already
+			failed: "migrateNode-worker1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, err := tt.plan()
+			if err != nil {
+				t.Fatalf("first plan error = %v", err)
+			}
+			ids := sets.New[string]()
+			for _, step := range first {
+				if step.InputHash == "" {
+					t.Errorf("step %s has no input hash", step.Name)
+				}
+				ids.Insert(step.ID)
+			}
+			if ids.Len() != len(first) {
+				t.Fatalf("the step IDs %v are not unique", stepNames(first))
+			}
+
+			failed := stepIndex(first, tt.failed)
+			status := &v1.OperationStatus{Completed: map[string]string{}}
+			for _, step := range first[:failed] {
+				status.Completed[step.ID] = step.InputHash
+			}
+			second, err := tt.plan()
+			if err != nil {
+				t.Fatalf("second plan error = %v", err)
+			}
+			if len(second) != len(first) {
+				t.Fatalf("the second plan = %v, want %v", stepNames(second), stepNames(first))
+			}
+			for i, step := range second {
+				if got, want := status.StepCompleted(&step), i < failed; got != want {
+					t.Errorf("StepCompleted(%s) = %v, want %v", step.Name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func stepIndex(steps []v1.Step, name string) int {
+	for i, step := range steps {
+		if step.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestBaseCni_VersionAtLeast(t *testing.T) {
+	tests := []struct {
+		version string
+		min     string
+		want    bool
+	}{
+		{"v1.14.2", "1.14", true},
+		{"1.13.10", "1.14", false},
+		{"1.15.0-rc.1", "1.15", true},
+		{"", "1.14", true},
+		{"latest", "1.14", true},
+	}
+	for _, tt := range tests {
+		runnable := &BaseCni{CNI: v1.CNI{Version: tt.version}}
+		if got := runnable.VersionAtLeast(tt.min); got != tt.want {
+			t.Errorf("VersionAtLeast(%q) of %q = %v, want %v", tt.min, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestBundleImages(t *testing.T) {
+	images, err := BundleImages("cilium", "1.14.4")
+	if err != nil {
+		t.Fatalf("BundleImages() error = %v", err)
+	}
+	want := sets.New("quay.io/cilium/cilium:v1.14.4", "quay.io/cilium/operator-generic:v1.14.4",
+		"quay.io/cilium/clustermesh-apiserver:v1.14.4", "quay.io/cilium/hubble-relay:v1.14.4",
+		"quay.io/cilium/hubble-ui:v0.12.1", "quay.io/cilium/hubble-ui-backend:v0.12.1")
+	if missing := want.Difference(sets.New(images...)); missing.Len() > 0 {
+		t.Errorf("BundleImages() = %v, missing %v", images, sets.List(missing))
+	}
+	for _, typ := range []string{"calico", "flannel"} {
+		factory, _ := Load(typ)
+		version := "v3.26.1"
+		if typ == "flannel" {
+			version = "v0.22.0"
+		}
+		if _, err = BundleImages(factory.Type(), version); err != nil {
+			t.Errorf("BundleImages(%s) error = %v", typ, err)
+		}
+	}
+	if _, err = BundleImages("unknown", "v1"); err == nil {
+		t.Errorf("BundleImages() of an unknown cni should fail")
+	}
+}
+
+func TestRegistrations(t *testing.T) {
+	registrations := Registrations()
+	got := make(map[string]Registration, len(registrations))
+	for i, r := range registrations {
+		if i > 0 && registrations[i-1].Type >= r.Type {
+			t.Errorf("Registrations() are not sorted by type: %s before %s", registrations[i-1].Type, r.Type)
+		}
+		got[r.Type] = r
+	}
+	want := map[string]string{"calico": "calico-system", "cilium": CiliumNamespaceDefault, "flannel": FlannelNamespace, "kube-ovn": KubeOvnNamespace}
+	for typ, namespace := range want {
+		r, ok := got[typ]
+		if !ok {
+			t.Errorf("Registrations() lacks %s", typ)
+			continue
+		}
+		if r.DefaultNamespace != namespace {
+			t.Errorf("%s DefaultNamespace = %s, want %s", typ, r.DefaultNamespace, namespace)
+		}
+		if r.TemplateKey != cniInfo+"-"+typ+"/v1/template" || r.StepKey != cniInfo+"-"+typ+"/v1/step" {
+			t.Errorf("%s TemplateKey = %s, StepKey = %s", typ, r.TemplateKey, r.StepKey)
+		}
+		if len(r.RegistryVersions) != 1 || r.RegistryVersions[0] != version {
+			t.Errorf("%s RegistryVersions = %v, want [%s]", typ, r.RegistryVersions, version)
+		}
+	}
+	if minors := got["cilium"].SupportedVersions; len(minors) == 0 || minors[0] != "1.13" || minors[len(minors)-1] != "1.16" {
+		t.Errorf("cilium SupportedVersions = %v", minors)
+	}
+	if versions := got["calico"].SupportedVersions; len(versions) != 0 {
+		t.Errorf("calico SupportedVersions = %v, want none", versions)
+	}
+	profiles := []string{CiliumProfileDefault, CiliumProfileMinimal, CiliumProfileProduction}
+	if got := got["cilium"].Profiles; !reflect.DeepEqual(got, profiles) {
+		t.Errorf("cilium Profiles = %v, want %v", got, profiles)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	for _, typ := range []string{"calico", "cilium", "flannel", "kube-ovn"} {
+		c, err := Defaults(typ, "1.14.4")
+		if err != nil {
+			t.Fatalf("Defaults(%s) error = %v", typ, err)
+		}
+		if c.Type != typ || c.Version != "1.14.4" || (c.Calico == nil && c.Cilium == nil && c.Flannel == nil && c.KubeOvn == nil) {
+			t.Errorf("Defaults(%s) = %+v, want the defaults of the type config", typ, c)
+		}
+	}
+	for version, want := range map[string]v1.CiliumKubeProxyReplacement{"1.13.4": CiliumKubeProxyReplacementDisabled, "1.15.1": CiliumKubeProxyReplacementFalse} {
+		if c, _ := Defaults("cilium", version); c.Cilium.KubeProxyReplacement != want {
+			t.Errorf("cilium %s kubeProxyReplacement default = %s, want %s", version, c.Cilium.KubeProxyReplacement, want)
+		}
+	}
+	if _, err := Defaults("unknown", "v1"); err == nil {
+		t.Errorf("Defaults() of an unknown cni should fail")
+	}
+}
+
+// fakeDistributor distributes a package which is corrupt the first corrupt downloads.
+type fakeDistributor struct {
+	corrupt   int
+	downloads int
+	removes   int
+}
+
+func (d *fakeDistributor) DownloadImages() (string, error) {
+	d.downloads++
+	return "/tmp/cilium/images.tar.gz", nil
+}
+
+func (d *fakeDistributor) VerifyImages() error {
+	if d.downloads <= d.corrupt {
+		return fmt.Errorf("images.tar.gz: %w", downloader.ErrChecksumMismatch)
+	}
+	return nil
+}
+
+func (d *fakeDistributor) RemoveImages() error {
+	d.removes++
+	return nil
+}
+
+func TestFetchImages(t *testing.T) {
+	tests := []struct {
+		name          string
+		corrupt       int
+		wantErr       bool
+		wantDownloads int
+		wantRetryLog  bool
+	}{
+		{name: "good", corrupt: 0, wantDownloads: 1},
+		{name: "corrupt then good", corrupt: 1, wantDownloads: 2, wantRetryLog: true},
+		{name: "persistently corrupt", corrupt: 2, wantErr: true, wantDownloads: 2, wantRetryLog: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opLog, err := oplog.NewOperationLog(&oplog.Options{Dir: t.TempDir()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = opLog.CreateOperationDir("op1"); err != nil {
+				t.Fatal(err)
+			}
+			ctx := component.WithOplog(component.WithStepID(component.WithOperationID(context.TODO(), "op1"), "step1"), opLog)
+			dist := &fakeDistributor{corrupt: tt.corrupt}
+			file, err := fetchImages(ctx, "cilium", dist)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchImages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, downloader.ErrChecksumMismatch) {
+				t.Errorf("fetchImages() error = %v, want a checksum mismatch", err)
+			}
+			if err == nil && file != "/tmp/cilium/images.tar.gz" {
+				t.Errorf("fetchImages() = %s", file)
+			}
+			if dist.downloads != tt.wantDownloads || dist.removes != tt.wantDownloads-1 {
+				t.Errorf("fetchImages() downloads = %d, removes = %d, want %d downloads", dist.downloads, dist.removes, tt.wantDownloads)
+			}
+			path, err := opLog.GetStepLogFile("op1", "step1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := os.ReadFile(path)
+			if got := strings.Contains(string(data), "checksum mismatch, redistributed cilium-images.tar.gz, retrying"); got != tt.wantRetryLog {
+				t.Errorf("step log %q, want the retry line %v", data, tt.wantRetryLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package cni
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+
+	"github.com/kubeclipper/kubeclipper/pkg/logger"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"sigs.k8s.io/yaml"
+)
+
+//go:embed compatibility.yaml
+var compatibilityData []byte
+
+// compatibility the kubernetes minor versions the cni minor versions support keyed by cni type, see compatibility.yaml.
+var compatibility = mustParseCompatibility(compatibilityData)
+
+// Compatibility the kubernetes minor versions a cni minor version supports, an empty bound is unlimited.
+type Compatibility struct {
+	Version       string `json:"version"`
+	MinKubernetes string `json:"minKubernetes,omitempty"`
+	MaxKubernetes string `json:"maxKubernetes,omitempty"`
+}
+
+func mustParseCompatibility(data []byte) map[string][]Compatibility {
+	table := make(map[string][]Compatibility)
+	if err := yaml.UnmarshalStrict(data, &table); err != nil {
+		panic(fmt.Sprintf("parse the cni compatibility table: %v", err))
+	}
+	for typ, entries := range table {
+		for _, c := range entries {
+			if _, err := parseMinor(c.Version); err != nil {
+				panic(fmt.Sprintf("the cni compatibility table of %s: %v", typ, err))
+			}
+			for _, v := range []string{c.MinKubernetes, c.MaxKubernetes} {
+				if _, err := parseMinor(v); v != "" && err != nil {
+					panic(fmt.Sprintf("the cni compatibility table of %s: %v", typ, err))
+				}
+			}
+		}
+	}
+	return table
+}
+
+// CheckCompatibility checks the kubernetes version supports the cni version, the versions missing from the
+// compatibility table and an empty kubernetes version are not checked.
+func CheckCompatibility(cniType, cniVersion, kubernetesVersion string) error {
+	if kubernetesVersion == "" {
+		return nil
+	}
+	cniMinor, err := parseMinor(cniVersion)
+	if err != nil {
+		return fmt.Errorf("invalid %s version %s: %w", cniType, cniVersion, err)
+	}
+	kubeMinor, err := parseMinor(kubernetesVersion)
+	if err != nil {
+		return fmt.Errorf("invalid kubernetes version %s: %w", kubernetesVersion, err)
+	}
+	for _, c := range compatibility[cniType] {
+		if v, _ := parseMinor(c.Version); v.String() != cniMinor.String() {
+			continue
+		}
+		if min, _ := parseMinor(c.MinKubernetes); min != nil && kubeMinor.LessThan(min) {
+			return fmt.Errorf("%s %s.x requires kubernetes >= %s, got %s", cniType, c.Version, c.MinKubernetes, kubernetesVersion)
+		}
+		if max, _ := parseMinor(c.MaxKubernetes); max != nil && max.LessThan(kubeMinor) {
+			return fmt.Errorf("%s %s.x requires kubernetes <= %s, got %s", cniType, c.Version, c.MaxKubernetes, kubernetesVersion)
+		}
+		return nil
+	}
+	return nil
+}
+
+// checkCompatibility checks the cni version supports kubernetesVersion unless SkipCompatibilityCheck is set.
+func (runnable *BaseCni) checkCompatibility(kubernetesVersion string) error {
+	err := CheckCompatibility(runnable.Type, runnable.Version, kubernetesVersion)
+	if err != nil && runnable.SkipCompatibilityCheck {
+		logger.Warnf("skip the cni compatibility check: %v", err)
+		return nil
+	}
+	return err
+}
+
+// parseMinor parses the major.minor part of version, the v prefix and the patch version are optional.
+func parseMinor(version string) (*utilversion.Version, error) {
+	if version == "" {
+		return nil, fmt.Errorf("empty version")
+	}
+	v, err := utilversion.ParseGeneric(strings.TrimPrefix(version, "v"))
+	if err != nil {
+		return nil, err
+	}
+	return utilversion.ParseGeneric(fmt.Sprintf("%d.%d", v.Major(), v.Minor()))
+}
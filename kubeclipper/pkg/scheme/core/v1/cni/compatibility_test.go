@@ -0,0 +1,79 @@
+package cni
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	tests := []struct {
+		name              string
+		cniType           string
+		cniVersion        string
+		kubernetesVersion string
+		wantErr           string
+	}{
+		{name: "cilium min bound", cniType: "cilium", cniVersion: "1.15.1", kubernetesVersion: "v1.26.0"},
+		{name: "cilium below min", cniType: "cilium", cniVersion: "1.15.1", kubernetesVersion: "v1.25.9", wantErr: "cilium 1.15.x requires kubernetes >= 1.26"},
+		{name: "cilium max bound patch", cniType: "cilium", cniVersion: "v1.15.1", kubernetesVersion: "v1.29.12"},
+		{name: "cilium above max", cniType: "cilium", cniVersion: "1.15.1", kubernetesVersion: "1.30.0", wantErr: "cilium 1.15.x requires kubernetes <= 1.29"},
+		{name: "calico in range", cniType: "calico", cniVersion: "v3.26.1", kubernetesVersion: "v1.27.4"},
+		{name: "calico below min", cniType: "calico", cniVersion: "v3.26.1", kubernetesVersion: "v1.22.17", wantErr: "calico 3.26.x requires kubernetes >= 1.23"},
+		{name: "unbounded max", cniType: "kube-ovn", cniVersion: "v1.12.4", kubernetesVersion: "v1.40.0"},
+		{name: "unknown cni version", cniType: "cilium", cniVersion: "1.99.0", kubernetesVersion: "v1.10.0"},
+		{name: "unknown cni type", cniType: "weave", cniVersion: "2.8.1", kubernetesVersion: "v1.27.4"},
+		{name: "no kubernetes version", cniType: "cilium", cniVersion: "1.15.1"},
+		{name: "invalid kubernetes version", cniType: "cilium", cniVersion: "1.15.1", kubernetesVersion: "latest", wantErr: "invalid kubernetes version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckCompatibility(tt.cniType, tt.cniVersion, tt.kubernetesVersion)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("CheckCompatibility() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("CheckCompatibility() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompatibilityTable(t *testing.T) {
+	for typ, entries := range compatibility {
+		if _, err := Load(typ); err != nil {
+			t.Errorf("the compatibility table lists the unregistered cni %s", typ)
+		}
+		seen := make(map[string]bool)
+		for _, c := range entries {
+			if seen[c.Version] {
+				t.Errorf("the compatibility table lists %s %s twice", typ, c.Version)
+			}
+			seen[c.Version] = true
+			min, _ := parseMinor(c.MinKubernetes)
+			max, _ := parseMinor(c.MaxKubernetes)
+			if min != nil && max != nil && max.LessThan(min) {
+				t.Errorf("%s %s: maxKubernetes %s < minKubernetes %s", typ, c.Version, c.MaxKubernetes, c.MinKubernetes)
+			}
+		}
+	}
+	if _, ok := compatibility["cilium"]; !ok {
+		t.Error("the compatibility table lacks cilium")
+	}
+}
+
+func TestInstallSteps_compatibility(t *testing.T) {
+	metadata := &component.ExtraMetadata{CRI: "containerd", Masters: component.NodeList{{ID: "node1"}}}
+	cni := &v1.CNI{Type: "cilium", Version: "1.15.1", Cilium: &v1.Cilium{}}
+	stepper := (&CiliumRunnable{}).InitStep(metadata, cni, &v1.Networking{})
+	if _, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "v1.21.14"); err == nil {
+		t.Fatal("InstallSteps() error = nil, want the incompatible kubernetes version rejected")
+	}
+	cni.SkipCompatibilityCheck = true
+	stepper = (&CiliumRunnable{}).InitStep(metadata, cni, &v1.Networking{})
+	if _, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "v1.21.14"); err != nil {
+		t.Fatalf("InstallSteps() error = %v, want the check skipped", err)
+	}
+}
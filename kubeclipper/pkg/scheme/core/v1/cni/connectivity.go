@@ -0,0 +1,354 @@
+package cni
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/cmdutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ciliumConnectivity = "cilium-connectivity"
+	// CiliumConnectivityNamespace the namespace the connectivity test runs in, it is deleted after the test.
+	CiliumConnectivityNamespace = "kc-cilium-connectivity"
+	// CiliumConnectivityTimeoutDefault the time the connectivity test may take when the operation sets none.
+	CiliumConnectivityTimeoutDefault = 10 * time.Minute
+
+	// the modes of the connectivity test
+	ConnectivityModeCLI      = "cli"
+	ConnectivityModeManifest = "manifest"
+
+	// the images of the connectivity test, their repositories are rewritten to LocalRegistry when it is set
+	ciliumConnectivityCurlImage     = "quay.io/cilium/alpine-curl:v1.10.0"
+	ciliumConnectivityJSONMockImage = "quay.io/cilium/json-mock:v1.3.8"
+	ciliumConnectivityDNSImage      = "registry.k8s.io/coredns/coredns:v1.11.1"
+)
+
+func init() {
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-"+ciliumConnectivity, version, component.TypeStep), &CiliumConnectivity{}); err != nil {
+		panic(err)
+	}
+}
+
+// ConnectivityResult the result of a connectivity test, the step response carries it as JSON.
+type ConnectivityResult struct {
+	Mode   string              `json:"mode"`
+	Passed bool                `json:"passed"`
+	Checks []HealthCheckResult `json:"checks"`
+}
+
+func (r *ConnectivityResult) add(name string, passed bool, message string) {
+	r.Checks = append(r.Checks, HealthCheckResult{Name: name, Healthy: passed, Message: strings.TrimSpace(message)})
+	r.Passed = r.Passed && passed
+}
+
+// CiliumConnectivity runs the cilium connectivity test in TestNamespace through the cilium CLI when it is
+// installed on the node, otherwise it deploys the connectivity check probes and waits for them to be available.
+// The test failing does not fail the step, the result of every check is in the response.
+type CiliumConnectivity struct {
+	Namespace     string        `json:"namespace"`
+	TestNamespace string        `json:"testNamespace"`
+	Timeout       time.Duration `json:"timeout"`
+	// MultiNode deploys the probes across nodes, the cluster has more than one node.
+	MultiNode bool `json:"multiNode,omitempty"`
+	// Registry the LocalRegistry the test images are pulled from, the upstream images are used when it is empty.
+	Registry string `json:"registry,omitempty"`
+}
+
+func (c *CiliumConnectivity) NewInstance() component.ObjectMeta {
+	return &CiliumConnectivity{}
+}
+
+func (c *CiliumConnectivity) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	result := &ConnectivityResult{Passed: true}
+	testCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	if _, err := cmdutil.RunCmdWithContext(ctx, opts.DryRun, "/bin/bash", "-c", "command -v cilium"); err == nil {
+		result.Mode = ConnectivityModeCLI
+		c.runCLI(testCtx, opts.DryRun, result)
+	} else {
+		result.Mode = ConnectivityModeManifest
+		c.runManifest(testCtx, opts.DryRun, result)
+	}
+	return json.Marshal(result)
+}
+
+// Uninstall deletes the test namespace, it runs after the test whatever the test result is.
+func (c *CiliumConnectivity) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	_, err := cmdutil.RunCmdWithContext(ctx, opts.DryRun, "kubectl", "delete", "ns", c.TestNamespace, "--ignore-not-found", "--wait=true")
+	return nil, err
+}
+
+// image the image rewritten to Registry.
+func (c *CiliumConnectivity) image(image string) string {
+	if c.Registry == "" {
+		return image
+	}
+	return strings.TrimSuffix(c.Registry, "/") + "/" + image[strings.Index(image, "/")+1:]
+}
+
+// junitReport the part of the cilium CLI junit report the checks are read from.
+type junitReport struct {
+	Suites []struct {
+		Cases []struct {
+			Name    string    `xml:"name,attr"`
+			Failure *struct{} `xml:"failure"`
+			Skipped *struct{} `xml:"skipped"`
+		} `xml:"testcase"`
+	} `xml:"testsuite"`
+}
+
+func (c *CiliumConnectivity) runCLI(ctx context.Context, dryRun bool, result *ConnectivityResult) {
+	f, err := os.CreateTemp("", "cilium-connectivity-*.xml")
+	if err != nil {
+		result.add("connectivityTest", false, err.Error())
+		return
+	}
+	_ = f.Close()
+	defer os.Remove(f.Name())
+
+	args := []string{"connectivity", "test", "-n", c.Namespace, "--test-namespace", c.TestNamespace, "--junit-file", f.Name()}
+	if c.Registry != "" {
+		args = append(args, "--curl-image", c.image(ciliumConnectivityCurlImage),
+			"--json-mock-image", c.image(ciliumConnectivityJSONMockImage),
+			"--dns-test-server-image", c.image(ciliumConnectivityDNSImage))
+	}
+	ec, runErr := cmdutil.RunCmdWithContext(ctx, dryRun, "cilium", args...)
+	if dryRun {
+		return
+	}
+	report := &junitReport{}
+	data, err := os.ReadFile(f.Name())
+	if err == nil {
+		err = xml.Unmarshal(data, report)
+	}
+	for _, suite := range report.Suites {
+		for _, tc := range suite.Cases {
+			if tc.Skipped == nil {
+				result.add(tc.Name, tc.Failure == nil, "")
+			}
+		}
+	}
+	// the CLI writes no report when it fails before running the tests, e.g. on the timeout
+	if runErr != nil && (err != nil || len(result.Checks) == 0) {
+		result.add("connectivityTest", false, cmdOutput(ec, runErr))
+	}
+}
+
+// connectivityProbe a client deployment of the connectivity check, it is available when its probe passes.
+type connectivityProbe struct {
+	Name   string
+	Target string
+	// Affinity keeps the probe on the node of the echo server, AntiAffinity keeps it off.
+	Affinity     bool
+	AntiAffinity bool
+}
+
+func (c *CiliumConnectivity) probes() []connectivityProbe {
+	probes := []connectivityProbe{
+		{Name: "pod-to-a", Target: "echo-a:8080/public"},
+		{Name: "pod-to-b-intra-node-clusterip", Target: "echo-b:8080/public", Affinity: true},
+	}
+	if c.MultiNode {
+		probes = append(probes,
+			connectivityProbe{Name: "pod-to-b-multi-node-clusterip", Target: "echo-b:8080/public", AntiAffinity: true},
+			connectivityProbe{Name: "pod-to-b-multi-node-headless", Target: "echo-b-headless:8080/public", AntiAffinity: true})
+	}
+	return probes
+}
+
+func (c *CiliumConnectivity) runManifest(ctx context.Context, dryRun bool, result *ConnectivityResult) {
+	manifest := &bytes.Buffer{}
+	if err := connectivityCheckTemplate.Execute(manifest, map[string]interface{}{
+		"Namespace": c.TestNamespace,
+		"Echos":     []string{"echo-a", "echo-b"},
+		"EchoImage": c.image(ciliumConnectivityJSONMockImage),
+		"CurlImage": c.image(ciliumConnectivityCurlImage),
+		"Probes":    c.probes(),
+	}); err != nil {
+		result.add("connectivityCheck", false, err.Error())
+		return
+	}
+	script := fmt.Sprintf("kubectl apply -f - <<'EOF'\n%sEOF", manifest.String())
+	if ec, err := cmdutil.RunCmdWithContext(ctx, dryRun, "/bin/bash", "-c", script); err != nil {
+		result.add("connectivityCheck", false, cmdOutput(ec, err))
+		return
+	}
+	// wait for every probe, a probe which is not available by the timeout failed
+	wait := fmt.Sprintf("kubectl wait -n %s --for=condition=Available deploy -l kubeclipper.io/connectivity-probe --timeout %s",
+		c.TestNamespace, c.Timeout)
+	_, _ = cmdutil.RunCmdWithContext(ctx, dryRun, "/bin/bash", "-c", wait)
+	if dryRun {
+		return
+	}
+	for _, probe := range c.probes() {
+		ec, err := cmdutil.RunCmdWithContext(context.TODO(), dryRun, "kubectl", "get", "deploy", probe.Name, "-n", c.TestNamespace,
+			"-o", "jsonpath={.status.availableReplicas}")
+		switch {
+		case err != nil:
+			result.add(probe.Name, false, cmdOutput(ec, err))
+		case strings.TrimSpace(ec.StdOut()) != "1":
+			result.add(probe.Name, false, fmt.Sprintf("%s is not reachable", probe.Target))
+		default:
+			result.add(probe.Name, true, "")
+		}
+	}
+}
+
+// connectivityCheckTemplate the probes of the upstream connectivity check which do not need the internet.
+var connectivityCheckTemplate = template.Must(template.New("connectivity").Parse(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: {{ .Namespace }}
+{{- range $name := .Echos }}
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ $name }}
+  namespace: {{ $.Namespace }}
+spec:
+  selector:
+    matchLabels:
+      name: {{ $name }}
+  replicas: 1
+  template:
+    metadata:
+      labels:
+        name: {{ $name }}
+    spec:
+      containers:
+      - name: echo
+        image: {{ $.EchoImage }}
+        imagePullPolicy: IfNotPresent
+        ports:
+        - containerPort: 8080
+        readinessProbe:
+          exec:
+            command: ["curl", "-sS", "--fail", "--connect-timeout", "5", "-o", "/dev/null", "localhost:8080"]
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{ $name }}
+  namespace: {{ $.Namespace }}
+spec:
+  type: ClusterIP
+  selector:
+    name: {{ $name }}
+  ports:
+  - name: http
+    port: 8080
+{{- end }}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: echo-b-headless
+  namespace: {{ .Namespace }}
+spec:
+  clusterIP: None
+  selector:
+    name: echo-b
+  ports:
+  - name: http
+    port: 8080
+{{- range .Probes }}
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Name }}
+  namespace: {{ $.Namespace }}
+  labels:
+    kubeclipper.io/connectivity-probe: "true"
+spec:
+  selector:
+    matchLabels:
+      name: {{ .Name }}
+  replicas: 1
+  template:
+    metadata:
+      labels:
+        name: {{ .Name }}
+    spec:
+{{- if or .Affinity .AntiAffinity }}
+      affinity:
+        {{ if .Affinity }}podAffinity{{ else }}podAntiAffinity{{ end }}:
+          requiredDuringSchedulingIgnoredDuringExecution:
+          - labelSelector:
+              matchLabels:
+                name: echo-b
+            topologyKey: kubernetes.io/hostname
+{{- end }}
+      containers:
+      - name: probe
+        image: {{ $.CurlImage }}
+        imagePullPolicy: IfNotPresent
+        command: ["/bin/ash", "-c", "sleep 1000000000"]
+        readinessProbe:
+          timeoutSeconds: 7
+          exec:
+            command: ["curl", "-sS", "--fail", "--connect-timeout", "5", "-o", "/dev/null", "{{ .Target }}"]
+        livenessProbe:
+          timeoutSeconds: 7
+          exec:
+            command: ["curl", "-sS", "--fail", "--connect-timeout", "5", "-o", "/dev/null", "{{ .Target }}"]
+{{- end }}
+`))
+
+// ConnectivitySteps runs the connectivity test from the first master and deletes the test namespace afterwards,
+// timeout is the time the test may take. The response of the test step is a ConnectivityResult.
+func (runnable *CiliumRunnable) ConnectivitySteps(timeout time.Duration) ([]v1.Step, error) {
+	if len(runnable.masters) == 0 {
+		return nil, fmt.Errorf("the connectivity test requires a master node")
+	}
+	if timeout <= 0 {
+		timeout = CiliumConnectivityTimeoutDefault
+	}
+	custom, err := json.Marshal(&CiliumConnectivity{
+		Namespace:     runnable.Namespace,
+		TestNamespace: CiliumConnectivityNamespace,
+		Timeout:       timeout,
+		MultiNode:     len(runnable.allNodes) > 1,
+		Registry:      runnable.LocalRegistry,
+	})
+	if err != nil {
+		return nil, err
+	}
+	nodes := runnable.masters[:1]
+	test := runnable.connectivityStep("ciliumConnectivityTest", v1.ActionInstall, timeout+2*time.Minute, custom, nodes)
+	// the test namespace is deleted whatever the test result is, the test step never fails the operation
+	clean := runnable.connectivityStep("cleanCiliumConnectivityTest", v1.ActionUninstall, 3*time.Minute, custom, nodes)
+	return []v1.Step{test, clean}, nil
+}
+
+func (runnable *CiliumRunnable) connectivityStep(name string, action v1.StepAction, timeout time.Duration, custom []byte, nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       name,
+		Timeout:    metav1.Duration{Duration: timeout},
+		ErrIgnore:  true,
+		RetryTimes: 0,
+		Nodes:      nodes,
+		Action:     action,
+		Commands: []v1.Command{
+			{
+				Type:          v1.CommandCustom,
+				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+ciliumConnectivity, version, component.TypeStep),
+				CustomCommand: custom,
+			},
+		},
+	}
+}
@@ -0,0 +1,69 @@
+package cni
+
+import (
+	"reflect"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+// clusterUnreachableMatcher matches the kubectl and helm failures to reach the api server.
+var clusterUnreachableMatcher = v1.StepErrorMatcher{
+	Category: v1.StepErrorClusterUnreachable,
+	Pattern:  `(?i)kubernetes cluster unreachable|unable to connect to the server|connection refused|no route to host|i/o timeout|tls handshake timeout`,
+	Hint:     "the kubernetes api server is not reachable from the node, check the kube-apiserver is running and the kubeconfig of the node",
+}
+
+// helmErrorMatchers classify the failures of the helm releases, the errors of the chart templates and values,
+// the release conflicts and the unreachable clusters need different remediations.
+var helmErrorMatchers = []v1.StepErrorMatcher{
+	{
+		Category: v1.StepErrorHelmReleaseConflict,
+		Pattern:  `cannot re-use a name that is still in use|another operation \(install/upgrade/rollback\) is in progress|has no deployed releases|is not managed by kubeclipper`,
+		Hint:     "the helm release is pending or owned by another installation, roll it back or uninstall it with helm and retry",
+	},
+	clusterUnreachableMatcher,
+	{
+		Category: v1.StepErrorValidationFailed,
+		Pattern:  `values don't meet the specifications of the schema|YAML parse error|error converting YAML to JSON|template: \S+: executing|parse error at|unable to build kubernetes objects from release manifest|error validating data`,
+		Hint:     "the chart rejected the rendered values, check the cni configuration and the custom helm values",
+	},
+}
+
+// cniErrorMatchers classify the failures of every cni step after the matchers of the step itself.
+var cniErrorMatchers = []v1.StepErrorMatcher{
+	{
+		Category: v1.StepErrorChecksumMismatch,
+		Pattern:  `checksum mismatch`,
+		Hint:     "the downloaded package does not match its checksum, check the package source or upload the offline package again",
+	},
+	{
+		Category: v1.StepErrorDownloadFailed,
+		Pattern:  `download .*failed|failed to download|download failed`,
+		Hint:     "the package could not be downloaded, check the node reaches the package source",
+	},
+	{
+		Category: v1.StepErrorImagePullFailed,
+		Pattern:  `ErrImagePull|ImagePullBackOff|failed to pull image|pull access denied|manifest unknown`,
+		Hint:     "the images could not be pulled, check the image registry, the image pull secret and the offline images",
+	},
+	clusterUnreachableMatcher,
+	{
+		Category: v1.StepErrorTimeout,
+		Pattern:  `timed out waiting for the condition|context deadline exceeded`,
+		Hint:     "the step timed out, check the step log and extend the cni timeouts if the cluster is slow",
+	},
+	{
+		Category:  v1.StepErrorTimeout,
+		ExitCodes: []int{124},
+		Hint:      "the step timed out, check the step log and extend the cni timeouts if the cluster is slow",
+	},
+}
+
+// withCNIErrorMatchers appends cniErrorMatchers to the matchers of a step unless they were appended already.
+func withCNIErrorMatchers(matchers []v1.StepErrorMatcher) []v1.StepErrorMatcher {
+	if n := len(matchers) - len(cniErrorMatchers); n >= 0 && reflect.DeepEqual(matchers[n:], cniErrorMatchers) {
+		return matchers
+	}
+	// do not append to the shared matchers of the step
+	return append(matchers[:len(matchers):len(matchers)], cniErrorMatchers...)
+}
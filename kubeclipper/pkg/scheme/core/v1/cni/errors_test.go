@@ -0,0 +1,114 @@
+package cni
+
+import (
+	"testing"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func TestCiliumRunnable_ErrorMatchers(t *testing.T) {
+	stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{Version: "1.15.1"}, &v1.Networking{})
+	steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	tests := []struct {
+		name     string
+		step     string
+		exitCode int
+		output   string
+		want     v1.StepErrorCategory
+	}{
+		{
+			name:   "release name in use",
+			step:   "installCiliumRelease",
+			output: "Error: INSTALLATION FAILED: cannot re-use a name that is still in use",
+			want:   v1.StepErrorHelmReleaseConflict,
+		},
+		{
+			name:   "pending release",
+			step:   "installCiliumRelease",
+			output: "Error: UPGRADE FAILED: another operation (install/upgrade/rollback) is in progress",
+			want:   v1.StepErrorHelmReleaseConflict,
+		},
+		{
+			name:   "values schema",
+			step:   "installCiliumRelease",
+			output: "Error: values don't meet the specifications of the schema(s) in the following chart(s):\ncilium:\n- ipam.mode: Invalid type",
+			want:   v1.StepErrorValidationFailed,
+		},
+		{
+			name:   "chart template",
+			step:   "installCiliumRelease",
+			output: `Error: template: cilium/templates/cilium-configmap.yaml:12:3: executing "cilium/templates/cilium-configmap.yaml" at <fail>: error calling fail`,
+			want:   v1.StepErrorValidationFailed,
+		},
+		{
+			name:   "cluster unreachable",
+			step:   "installCiliumRelease",
+			output: "Error: Kubernetes cluster unreachable: Get \"https://10.0.0.1:6443/version\": dial tcp 10.0.0.1:6443: connect: connection refused",
+			want:   v1.StepErrorClusterUnreachable,
+		},
+		{
+			name:   "release wait timeout",
+			step:   "installCiliumRelease",
+			output: "Error: INSTALLATION FAILED: timed out waiting for the condition",
+			want:   v1.StepErrorTimeout,
+		},
+		{
+			name:   "release not managed",
+			step:   "checkCiliumRelease",
+			output: "helm release cilium already exists in namespace kube-system and is not managed by kubeclipper",
+			want:   v1.StepErrorHelmReleaseConflict,
+		},
+		{
+			name:   "values render",
+			step:   "renderCniYaml",
+			output: "render template error",
+			want:   v1.StepErrorValidationFailed,
+		},
+		{
+			name:   "chart checksum",
+			step:   "cilium-chartLoad",
+			output: "download cilium-1.15.1 chart packages failed: checksum mismatch",
+			want:   v1.StepErrorChecksumMismatch,
+		},
+		{
+			name:   "chart download",
+			step:   "cilium-chartLoad",
+			output: "download cilium-1.15.1 chart packages failed: download failed: dial tcp: lookup example.com: no such host",
+			want:   v1.StepErrorDownloadFailed,
+		},
+		{
+			name:     "timeout command",
+			step:     "installCiliumRelease",
+			exitCode: 124,
+			want:     v1.StepErrorTimeout,
+		},
+		{
+			name:     "unknown",
+			step:     "installCiliumRelease",
+			exitCode: 1,
+			output:   "Error: something else",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step := stepByName(steps, tt.step)
+			if step.Name == "" {
+				t.Fatalf("no step %s in %v", tt.step, stepNames(steps))
+			}
+			var got v1.StepErrorCategory
+			if m := step.ClassifyError(tt.exitCode, tt.output); m != nil {
+				got = m.Category
+				if m.Hint == "" {
+					t.Errorf("%s has no hint", got)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("ClassifyError() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package cni
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/component/utils"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/fileutil"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
+	tmplutil "github.com/kubeclipper/kubeclipper/pkg/utils/template"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	FlannelBackendVXLAN     = "vxlan"
+	FlannelBackendHostGW    = "host-gw"
+	FlannelBackendWireguard = "wireguard"
+
+	// FlannelNamespace the namespace the flannel manifests deploy flannel in.
+	FlannelNamespace = "kube-flannel"
+	// flannelCNIPluginVersion the version of the flannel cni plugin image shipped with the flannel manifests
+	flannelCNIPluginVersion = "v1.2.0"
+)
+
+var flannelBackends = sets.NewString(FlannelBackendVXLAN, FlannelBackendHostGW, FlannelBackendWireguard)
+
+func init() {
+	Register(&FlannelRunnable{})
+	if err := component.RegisterTemplate(fmt.Sprintf(component.RegisterTemplateKeyFormat,
+		cniInfo+"-flannel", version, component.TypeTemplate), &FlannelRunnable{}); err != nil {
+		panic(err)
+	}
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-flannel", version, component.TypeStep), &FlannelRunnable{}); err != nil {
+		panic(err)
+	}
+}
+
+// FlannelRunnable installs flannel from its manifests, flannel allocates the pod addresses from the podCIDR
+// kube-controller-manager assigns to every node.
+type FlannelRunnable struct {
+	BaseCni
+	// Backend the resolved flannel backend, see InitStep.
+	Backend       string `json:"backend"`
+	kubeProxyMode string
+	// masters the master nodes of the cluster, the cluster scoped steps run on the first one
+	masters []v1.StepNode
+	// allNodes all nodes of the cluster, the cluster scoped steps only run when every node is uninstalled
+	allNodes []v1.StepNode
+}
+
+func (runnable *FlannelRunnable) Type() string {
+	return "flannel"
+}
+
+func (runnable *FlannelRunnable) DefaultNamespace() string {
+	return FlannelNamespace
+}
+
+func (runnable *FlannelRunnable) Create() Stepper {
+	return &FlannelRunnable{}
+}
+
+func (runnable *FlannelRunnable) NewInstance() component.ObjectMeta {
+	return &FlannelRunnable{}
+}
+
+func (runnable *FlannelRunnable) InitStep(metadata *component.ExtraMetadata, cni *v1.CNI, networking *v1.Networking) Stepper {
+	stepper := &FlannelRunnable{}
+	stepper.CNI = *cni
+	stepper.BaseCni.Type = "flannel"
+	stepper.CriType = metadata.CRI
+	stepper.Namespace = FlannelNamespace
+	stepper.PodIPv4CIDR, stepper.PodIPv6CIDR = SplitPodCIDRs(networking)
+	stepper.DualStack = stepper.PodIPv4CIDR != "" && stepper.PodIPv6CIDR != ""
+	backend := ""
+	if cni.Flannel != nil {
+		backend = cni.Flannel.Backend
+	}
+	stepper.Backend = strutil.StringDefaultIfEmpty(stepper.Defaults().Backend, backend)
+	stepper.kubeProxyMode = metadata.KubeProxyMode
+	stepper.masters = utils.UnwrapNodeList(metadata.Masters)
+	stepper.allNodes = utils.UnwrapNodeList(metadata.GetAllNodes())
+	stepper.nodeStates = newNodeStates(metadata)
+	return stepper
+}
+
+// Defaults the effective flannel configuration when the cluster leaves a field unset.
+func (runnable *FlannelRunnable) Defaults() *v1.Flannel {
+	return &v1.Flannel{Backend: FlannelBackendVXLAN}
+}
+
+func (runnable *FlannelRunnable) setDefaults(cni *v1.CNI) {
+	cni.Flannel = runnable.Defaults()
+}
+
+func (runnable *FlannelRunnable) Validate() error {
+	if runnable.ChartSource != "" {
+		return fieldError("chartSource", fmt.Errorf("flannel is installed from its manifests, a chart source is not supported"))
+	}
+	if runnable.PushToRegistry {
+		return fieldError("pushToRegistry", fmt.Errorf("flannel does not support pushing the images to the local registry"))
+	}
+	if !flannelBackends.Has(runnable.Backend) {
+		return fmt.Errorf("invalid flannel backend %q, supported values: %v", runnable.Backend, flannelBackends.List())
+	}
+	if runnable.kubeProxyMode == kubeProxyModeEBPF {
+		return fmt.Errorf("flannel does not replace kube-proxy, proxy mode %s is not supported", kubeProxyModeEBPF)
+	}
+	if runnable.PodIPv4CIDR == "" && runnable.PodIPv6CIDR == "" {
+		return fmt.Errorf("flannel requires a pod cidr")
+	}
+	if _, err := runnable.FlannelTemplate(); err != nil {
+		return err
+	}
+	return fieldError("timeouts", runnable.validateTimeouts())
+}
+
+// GetImages returns the images referenced by the flannel manifests.
+func (runnable *FlannelRunnable) GetImages(version string, criType string) ([]string, error) {
+	if criType != "" && !v1.AllowedCRIType.Has(criType) {
+		return nil, fmt.Errorf("unsupported cri type %q", criType)
+	}
+	registry := strutil.StringDefaultIfEmpty("docker.io", strings.TrimSuffix(runnable.LocalRegistry, "/"))
+	return []string{
+		fmt.Sprintf("%s/flannel/flannel:%s", registry, version),
+		fmt.Sprintf("%s/flannel/flannel-cni-plugin:%s", registry, flannelCNIPluginVersion),
+	}, nil
+}
+
+func (runnable *FlannelRunnable) LoadImage(nodes []v1.StepNode) ([]v1.Step, error) {
+	if runnable.Offline && runnable.LocalRegistry == "" {
+		return runnable.stableSteps(loadImageSteps("flannel", runnable.nodeStates.available(nodes), runnable.imageLoadTimeout(), func(arch string) ([]byte, error) {
+			target := *runnable
+			target.Arch = arch
+			return json.Marshal(&target)
+		}))
+	}
+	return nil, nil
+}
+
+// InstallSteps applies the rendered flannel manifests, no chart is involved whatever the kubernetes version is.
+func (runnable *FlannelRunnable) InstallSteps(nodes []v1.StepNode, kubernetesVersion string) ([]v1.Step, error) {
+	if err := runnable.nodeStates.require(nodes); err != nil {
+		return nil, err
+	}
+	if err := runnable.checkCompatibility(kubernetesVersion); err != nil {
+		return nil, err
+	}
+	bytes, err := json.Marshal(runnable)
+	if err != nil {
+		return nil, err
+	}
+	return runnable.stableSteps([]v1.Step{
+		RenderYaml("flannel", bytes, nodes),
+		ApplyYaml(filepath.Join(manifestDir, "flannel.yaml"), nodes),
+	}, nil)
+}
+
+// UninstallSteps removes the flannel resources when the whole cluster is uninstalled, then cleans the nodes up.
+func (runnable *FlannelRunnable) UninstallSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	var steps []v1.Step
+	if clusterNodes, ok := clusterScopedNodes(nodes, runnable.allNodes, runnable.masters); ok {
+		steps = append(steps, runnable.removeResources(clusterNodes))
+	}
+	leaveSteps, err := runnable.LeaveNodeSteps(nodes)
+	if err != nil {
+		return nil, err
+	}
+	return runnable.stableSteps(append(steps, leaveSteps...), nil)
+}
+
+// RollbackSteps deletes the flannel manifest applied on nodes and the flannel images.
+func (runnable *FlannelRunnable) RollbackSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	steps := []v1.Step{
+		DeleteYaml(filepath.Join(manifestDir, "flannel.yaml"), nodes, runnable.uninstallTimeout(2*time.Minute)),
+		RemoveManifests(nodes, "flannel.yaml"),
+	}
+	images, err := runnable.rollbackImages("flannel", runnable, runnable.allNodes)
+	if err != nil {
+		return nil, err
+	}
+	return runnable.stableSteps(append(steps, images...), nil)
+}
+
+// JoinNodeSteps loads the flannel images on the joining nodes of offline clusters, the DaemonSet
+// schedules flannel itself.
+func (runnable *FlannelRunnable) JoinNodeSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	return runnable.LoadImage(nodes)
+}
+
+// LeaveNodeSteps removes the flannel interfaces and state from the nodes leaving the cluster.
+func (runnable *FlannelRunnable) LeaveNodeSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	steps := []v1.Step{runnable.clearNode(nodes)}
+	if runnable.Offline && runnable.LocalRegistry == "" {
+		custom, err := json.Marshal(runnable)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, RemoveImage("flannel", custom, nodes))
+	}
+	return runnable.stableSteps(steps, nil)
+}
+
+func (runnable *FlannelRunnable) removeResources(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "removeFlannel",
+		Timeout:    metav1.Duration{Duration: runnable.uninstallTimeout(2 * time.Minute)},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "delete", "ds", "kube-flannel-ds", "-n", runnable.Namespace, "--ignore-not-found"},
+			},
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "delete", "clusterrolebinding,clusterrole", "flannel", "--ignore-not-found"},
+			},
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "delete", "ns", runnable.Namespace, "--ignore-not-found"},
+			},
+		},
+	}
+}
+
+// clearNode deletes the flannel interfaces, the subnet lease in /run/flannel and the cni config,
+// the nodes keep forwarding through them otherwise.
+func (runnable *FlannelRunnable) clearNode(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "clearFlannelNode",
+		Timeout:    metav1.Duration{Duration: runnable.uninstallTimeout(30 * time.Second)},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					"for link in flannel.1 flannel-v6.1 flannel-wg flannel-wg-v6 cni0; do ip link delete $link 2>/dev/null; done; true"},
+			},
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"rm", "-rf", "/run/flannel", "/etc/cni/net.d/10-flannel.conflist"},
+			},
+		},
+	}
+}
+
+func (runnable *FlannelRunnable) UpgradeSteps(nodes []v1.StepNode, fromVersion, toVersion string) ([]v1.Step, error) {
+	return nil, fmt.Errorf("flannel upgrade from %s to %s is not supported", fromVersion, toVersion)
+}
+
+// MigrationSteps the cni migration only supports migrating from calico to cilium.
+func (runnable *FlannelRunnable) MigrationSteps(from Stepper, nodes []v1.StepNode) ([]v1.Step, error) {
+	return nil, fmt.Errorf("migrating from %T to flannel is not supported", from)
+}
+
+// CheckSteps the health check is not implemented for flannel.
+func (runnable *FlannelRunnable) CheckSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	return nil, fmt.Errorf("the flannel health check is not supported")
+}
+
+// PreRestoreSteps flannel has no state to capture before a restore.
+func (runnable *FlannelRunnable) PreRestoreSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	return nil, nil
+}
+
+// PostRestoreSteps flannel has no state to reconcile after a restore, its daemon-set is restarted by the restore.
+func (runnable *FlannelRunnable) PostRestoreSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	return nil, nil
+}
+
+// CmdList cni kubectl cmd list
+func (runnable *FlannelRunnable) CmdList(namespace string) map[string]string {
+	return operationCommands(runnable.Operations(namespace))
+}
+
+func (runnable *FlannelRunnable) Operations(namespace string) []Operation {
+	return []Operation{
+		{
+			Name:        OperationGet,
+			Description: "List the flannel pods.",
+			Command:     fmt.Sprintf("kubectl get po -n %s -l app=flannel -o wide", namespace),
+			Output:      OperationOutputText,
+		},
+		{
+			Name:        OperationStatus,
+			Description: "Show the rollout status of the flannel DaemonSet.",
+			Command:     fmt.Sprintf("kubectl get ds kube-flannel-ds -n %[1]s -o wide && kubectl rollout status ds kube-flannel-ds -n %[1]s --timeout=10s", namespace),
+			Output:      OperationOutputText,
+		},
+		{
+			Name:        OperationLogs,
+			Description: fmt.Sprintf("Show the last %d log lines of every flannel pod.", operationLogLines),
+			Command: fmt.Sprintf("kubectl -n %s logs -l app=flannel -c kube-flannel --tail=%d --prefix --max-log-requests=%d",
+				namespace, operationLogLines, operationMaxLogRequests),
+			Output: OperationOutputText,
+		},
+		{
+			Name:        OperationVersion,
+			Description: "Show the image of the flannel DaemonSet.",
+			Command:     fmt.Sprintf("kubectl get ds kube-flannel-ds -n %s -o jsonpath='{.spec.template.spec.containers[0].image}'", namespace),
+			Output:      OperationOutputText,
+		},
+		{
+			Name:        OperationRestart,
+			Description: "Restart the flannel pods, the pod networking of the nodes is disrupted until they are ready.",
+			Command:     fmt.Sprintf("kubectl rollout restart ds kube-flannel-ds -n %s", namespace),
+			Destructive: true,
+			Output:      OperationOutputText,
+		},
+		healthOperation("flannel", Workloads{Namespace: namespace, DaemonSets: []string{"kube-flannel-ds"}}),
+	}
+}
+
+func (runnable *FlannelRunnable) Render(ctx context.Context, opts component.Options) error {
+	runnable.gatherFacts(ctx)
+	if opts.DryRun {
+		_, err := runnable.RenderString(ctx)
+		return err
+	}
+	if err := os.MkdirAll(manifestDir, 0755); err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomicWithContext(ctx, filepath.Join(manifestDir, "flannel.yaml"), 0644,
+		runnable.renderFlannelTo, opts.DryRun)
+}
+
+// RenderString returns the manifests Render writes to the manifest file.
+func (runnable *FlannelRunnable) RenderString(ctx context.Context) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := runnable.renderFlannelTo(buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (runnable *FlannelRunnable) renderFlannelTo(w io.Writer) error {
+	flannelTemp, err := runnable.FlannelTemplate()
+	if err != nil {
+		return err
+	}
+	_, err = tmplutil.New().RenderTo(w, flannelTemp, runnable)
+	return err
+}
+
+func (runnable *FlannelRunnable) FlannelTemplate() (string, error) {
+	switch runnable.Version {
+	case "v0.22.3":
+		return flannelV0223, nil
+	}
+	return "", fmt.Errorf("flannel does not support version: %s", runnable.Version)
+}
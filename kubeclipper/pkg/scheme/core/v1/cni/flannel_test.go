@@ -0,0 +1,168 @@
+package cni
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func newFlannelStepper(metadata *component.ExtraMetadata, flannel *v1.Flannel, cidrs ...string) *FlannelRunnable {
+	networking := &v1.Networking{Pods: v1.NetworkRanges{CIDRBlocks: cidrs}}
+	return (&FlannelRunnable{}).InitStep(metadata, &v1.CNI{Type: "flannel", Version: "v0.22.3", Flannel: flannel}, networking).(*FlannelRunnable)
+}
+
+// flannelNetConf returns the net-conf.json of the rendered flannel manifests.
+func flannelNetConf(t *testing.T, manifests string) map[string]interface{} {
+	t.Helper()
+	for _, doc := range strings.Split(manifests, "\n---\n") {
+		cm := struct {
+			Kind string            `json:"kind"`
+			Data map[string]string `json:"data"`
+		}{}
+		if err := yaml.Unmarshal([]byte(doc), &cm); err != nil {
+			t.Fatalf("invalid flannel manifest: %v\n%s", err, doc)
+		}
+		if cm.Kind != "ConfigMap" {
+			continue
+		}
+		conf := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(cm.Data["net-conf.json"]), &conf); err != nil {
+			t.Fatalf("invalid net-conf.json: %v\n%s", err, cm.Data["net-conf.json"])
+		}
+		return conf
+	}
+	t.Fatalf("the flannel manifests have no ConfigMap")
+	return nil
+}
+
+func TestFlannelRunnable_render(t *testing.T) {
+	tests := []struct {
+		name    string
+		flannel *v1.Flannel
+		cidrs   []string
+		want    map[string]interface{}
+	}{
+		{
+			name:  "vxlan",
+			cidrs: []string{"172.25.0.0/16"},
+			want:  map[string]interface{}{"Network": "172.25.0.0/16", "Backend": map[string]interface{}{"Type": "vxlan"}},
+		},
+		{
+			name:    "host-gw",
+			flannel: &v1.Flannel{Backend: FlannelBackendHostGW},
+			cidrs:   []string{"172.25.0.0/16"},
+			want:    map[string]interface{}{"Network": "172.25.0.0/16", "Backend": map[string]interface{}{"Type": "host-gw"}},
+		},
+		{
+			name:    "wireguard",
+			flannel: &v1.Flannel{Backend: FlannelBackendWireguard},
+			cidrs:   []string{"172.25.0.0/16"},
+			want:    map[string]interface{}{"Network": "172.25.0.0/16", "Backend": map[string]interface{}{"Type": "wireguard"}},
+		},
+		{
+			name:  "dual stack",
+			cidrs: []string{"172.25.0.0/16", "fd00:10:244::/56"},
+			want: map[string]interface{}{"Network": "172.25.0.0/16", "EnableIPv6": true, "IPv6Network": "fd00:10:244::/56",
+				"Backend": map[string]interface{}{"Type": "vxlan"}},
+		},
+		{
+			name:  "ipv6",
+			cidrs: []string{"fd00:10:244::/56"},
+			want: map[string]interface{}{"EnableIPv4": false, "EnableIPv6": true, "IPv6Network": "fd00:10:244::/56",
+				"Backend": map[string]interface{}{"Type": "vxlan"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &bytes.Buffer{}
+			if err := newFlannelStepper(&component.ExtraMetadata{}, tt.flannel, tt.cidrs...).renderFlannelTo(w); err != nil {
+				t.Fatalf("renderFlannelTo() error = %v", err)
+			}
+			if got := flannelNetConf(t, w.String()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("net-conf.json = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	stepper := newFlannelStepper(&component.ExtraMetadata{}, nil, "172.25.0.0/16")
+	stepper.LocalRegistry = "172.0.0.1:5000"
+	w := &bytes.Buffer{}
+	if err := stepper.renderFlannelTo(w); err != nil {
+		t.Fatalf("renderFlannelTo() error = %v", err)
+	}
+	assertGolden(t, "flannel-vxlan", w.Bytes())
+}
+
+func TestFlannelRunnable_Validate(t *testing.T) {
+	tests := []struct {
+		name          string
+		version       string
+		flannel       *v1.Flannel
+		kubeProxyMode string
+		cidrs         []string
+		wantErr       bool
+	}{
+		{name: "default", cidrs: []string{"172.25.0.0/16"}},
+		{name: "wireguard", flannel: &v1.Flannel{Backend: "wireguard"}, cidrs: []string{"172.25.0.0/16"}},
+		{name: "typo", flannel: &v1.Flannel{Backend: "udp"}, cidrs: []string{"172.25.0.0/16"}, wantErr: true},
+		{name: "no pod cidr", wantErr: true},
+		{name: "ebpf proxy", kubeProxyMode: "ebpf", cidrs: []string{"172.25.0.0/16"}, wantErr: true},
+		{name: "unknown version", version: "v0.10.0", cidrs: []string{"172.25.0.0/16"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := newFlannelStepper(&component.ExtraMetadata{KubeProxyMode: tt.kubeProxyMode}, tt.flannel, tt.cidrs...)
+			if tt.version != "" {
+				stepper.Version = tt.version
+			}
+			if err := stepper.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFlannelRunnable_Steps(t *testing.T) {
+	metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1"}}, Workers: component.NodeList{{ID: "node2"}}}
+	stepper := newFlannelStepper(metadata, nil, "172.25.0.0/16")
+	stepper.Offline = true
+	master, all := []v1.StepNode{{ID: "node1"}}, []v1.StepNode{{ID: "node1"}, {ID: "node2"}}
+
+	steps, err := stepper.InstallSteps(master, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	if got := stepNames(steps); !reflect.DeepEqual(got, []string{"renderCniYaml", "applyCniYaml"}) {
+		t.Errorf("InstallSteps() = %v", got)
+	}
+	if steps, err = stepper.LoadImage(all); err != nil || len(steps) != 1 {
+		t.Errorf("LoadImage() = %v, %v", stepNames(steps), err)
+	}
+
+	if steps, err = stepper.UninstallSteps(all); err != nil {
+		t.Fatalf("UninstallSteps() error = %v", err)
+	}
+	if got := stepNames(steps); !reflect.DeepEqual(got, []string{"removeFlannel", "clearFlannelNode", "removeCniImage"}) {
+		t.Errorf("UninstallSteps() = %v", got)
+	}
+	if remove := stepByName(steps, "removeFlannel"); !reflect.DeepEqual(remove.Nodes, master) {
+		t.Errorf("removeFlannel nodes = %v, want the first master", remove.Nodes)
+	}
+	if clear := stepByName(steps, "clearFlannelNode"); !strings.Contains(strings.Join(clear.Commands[1].ShellCommand, " "), "/run/flannel") {
+		t.Errorf("clearFlannelNode commands = %v", clear.Commands)
+	}
+	if steps, err = stepper.UninstallSteps(all[1:]); err != nil || stepByName(steps, "removeFlannel").Name != "" {
+		t.Errorf("UninstallSteps() of some nodes should keep the flannel resources: %v, %v", stepNames(steps), err)
+	}
+
+	cmds := stepper.CmdList(FlannelNamespace)
+	if !strings.Contains(cmds[OperationStatus], "ds kube-flannel-ds -n kube-flannel") {
+		t.Errorf("CmdList() = %v", cmds)
+	}
+}
@@ -0,0 +1,157 @@
+package cni
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/cmdutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const ciliumHealth = "cilium-health"
+
+func init() {
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-"+ciliumHealth, version, component.TypeStep), &CiliumHealth{}); err != nil {
+		panic(err)
+	}
+}
+
+// HealthResult the result of a cni health check step on a node, the step response carries it as JSON
+// so that the pass or fail of every node can be rendered without parsing the step log.
+type HealthResult struct {
+	Node    string              `json:"node"`
+	Healthy bool                `json:"healthy"`
+	Checks  []HealthCheckResult `json:"checks"`
+}
+
+type HealthCheckResult struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+}
+
+func (r *HealthResult) add(name string, healthy bool, message string) {
+	r.Checks = append(r.Checks, HealthCheckResult{Name: name, Healthy: healthy, Message: strings.TrimSpace(message)})
+	r.Healthy = r.Healthy && healthy
+}
+
+// CiliumHealth checks the cilium agent of the node, or the cilium resources of the cluster when Cluster is set.
+type CiliumHealth struct {
+	Namespace string `json:"namespace"`
+	CriType   string `json:"criType"`
+	// Cluster runs the cluster scoped checks through kubectl, the step runs on a single master.
+	Cluster bool `json:"cluster,omitempty"`
+	// Nodes the number of nodes of the cluster, every node must have its CiliumNode.
+	Nodes int `json:"nodes,omitempty"`
+}
+
+func (h *CiliumHealth) NewInstance() component.ObjectMeta {
+	return &CiliumHealth{}
+}
+
+func (h *CiliumHealth) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	hostname, _ := os.Hostname()
+	result := &HealthResult{Node: hostname, Healthy: true}
+	if h.Cluster {
+		h.checkCluster(ctx, opts.DryRun, result)
+	} else {
+		h.checkAgent(ctx, opts.DryRun, result)
+	}
+	return json.Marshal(result)
+}
+
+func (h *CiliumHealth) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	return nil, nil
+}
+
+// checkAgent runs cilium status in the agent container of the node, the node has no kubeconfig.
+func (h *CiliumHealth) checkAgent(ctx context.Context, dryRun bool, result *HealthResult) {
+	cli, find := "crictl", "crictl ps --name cilium-agent -q | head -n 1"
+	if h.CriType == v1.CRIDocker {
+		cli, find = "docker", "docker ps -q --filter label=io.kubernetes.container.name=cilium-agent | head -n 1"
+	}
+	// cilium 1.15 renamed the agent cli to cilium-dbg
+	cmd := fmt.Sprintf(`id=$(%s); [ -n "$id" ] || { echo "the cilium-agent container is not running"; exit 1; }; %s exec $id sh -c 'cilium-dbg status --brief 2>/dev/null || cilium status --brief'`, find, cli)
+	ec, err := cmdutil.RunCmdWithContext(ctx, dryRun, "/bin/bash", "-c", cmd)
+	result.add("ciliumStatus", err == nil, cmdOutput(ec, err))
+}
+
+func (h *CiliumHealth) checkCluster(ctx context.Context, dryRun bool, result *HealthResult) {
+	ec, err := cmdutil.RunCmdWithContext(ctx, dryRun, "/bin/bash", "-c", "kubectl get ciliumnodes --no-headers | wc -l")
+	count, _ := strconv.Atoi(strings.TrimSpace(ec.StdOut()))
+	switch {
+	case err != nil:
+		result.add("ciliumNodes", false, cmdOutput(ec, err))
+	case !dryRun && count < h.Nodes:
+		result.add("ciliumNodes", false, fmt.Sprintf("%d of %d nodes have a CiliumNode", count, h.Nodes))
+	default:
+		result.add("ciliumNodes", true, "")
+	}
+
+	ec, err = cmdutil.RunCmdWithContext(ctx, dryRun, "/bin/bash", "-c",
+		fmt.Sprintf(`kubectl get po -n %s -l k8s-app=cilium --no-headers | awk '{split($2, c, "/"); if (c[1] != c[2] || $3 != "Running") print $1}'`, h.Namespace))
+	notReady := strings.Fields(ec.StdOut())
+	switch {
+	case err != nil:
+		result.add("ciliumPods", false, cmdOutput(ec, err))
+	case !dryRun && len(notReady) > 0:
+		result.add("ciliumPods", false, "not ready pods: "+strings.Join(notReady, ", "))
+	default:
+		result.add("ciliumPods", true, "")
+	}
+}
+
+func cmdOutput(ec *cmdutil.ExecCmd, err error) string {
+	if err == nil {
+		return ec.StdOut()
+	}
+	if ec == nil {
+		return err.Error()
+	}
+	return strings.TrimSpace(ec.StdOut() + "\n" + ec.StdErr())
+}
+
+// CheckSteps checks the cilium agent of every node and, when nodes cover the cluster, the CiliumNodes
+// and cilium pods from the first master. The checks never fail the steps, each step response is a HealthResult.
+func (runnable *CiliumRunnable) CheckSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	agent, err := json.Marshal(&CiliumHealth{Namespace: runnable.Namespace, CriType: runnable.CriType})
+	if err != nil {
+		return nil, err
+	}
+	steps := []v1.Step{runnable.healthStep("checkCiliumAgent", agent, nodes)}
+	if master, ok := runnable.clusterScopedNodes(nodes); ok {
+		cluster, err := json.Marshal(&CiliumHealth{Namespace: runnable.Namespace, Cluster: true, Nodes: len(runnable.allNodes)})
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, runnable.healthStep("checkCiliumCluster", cluster, master))
+	}
+	return steps, nil
+}
+
+func (runnable *CiliumRunnable) healthStep(name string, custom []byte, nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       name,
+		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:          v1.CommandCustom,
+				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+ciliumHealth, version, component.TypeStep),
+				CustomCommand: custom,
+			},
+		},
+	}
+}
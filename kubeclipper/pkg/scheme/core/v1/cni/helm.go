@@ -0,0 +1,353 @@
+package cni
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component/common"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// helmStepTimeoutDefault the step timeout when helm does not wait for the release resources.
+	helmStepTimeoutDefault = 2 * time.Minute
+	// helmStepTimeoutBuffer leaves time for chart loading and hooks on top of the helm timeout.
+	helmStepTimeoutBuffer = 1 * time.Minute
+	// helmReleaseManagedLabel marks the helm release secrets of the releases installed by kubeclipper.
+	helmReleaseManagedLabel = "kubeclipper.io/managed"
+	// helmReleaseNameMaxLength the longest release name helm accepts.
+	helmReleaseNameMaxLength = 53
+	// helmSetArgForbiddenChars the shell metacharacters and whitespace rejected in the extra --set arguments.
+	helmSetArgForbiddenChars = ";&|$`\\\"'<>()! \t\r\n"
+	// helmHome the directory of the helm cache and configuration of the cni releases, so that helm does not write to
+	// the home directory of root.
+	helmHome = "/opt/kc/helm"
+	// helmKubeconfig the kubeconfig of the control plane nodes the releases are installed from.
+	helmKubeconfig = "/etc/kubernetes/admin.conf"
+)
+
+// helmEnv the environment of the helm commands of the cni releases, helm connects with kubeconfig or
+// helmKubeconfig when it is empty.
+func helmEnv(kubeconfig string) []v1.EnvVar {
+	if kubeconfig == "" {
+		kubeconfig = helmKubeconfig
+	}
+	return []v1.EnvVar{
+		{Name: "HELM_CACHE_HOME", Value: filepath.Join(helmHome, "cache")},
+		{Name: "HELM_CONFIG_HOME", Value: filepath.Join(helmHome, "config")},
+		{Name: "KUBECONFIG", Value: kubeconfig},
+	}
+}
+
+// writeHelmKubeconfig writes the copy of helmKubeconfig pointing at the kube-apiserver endpoint to kubeconfig,
+// e.g. the load balancer in front of the kube-apiservers instead of the local one.
+func writeHelmKubeconfig(kubeconfig, endpoint string) v1.Command {
+	return v1.Command{
+		Type: v1.CommandShell,
+		ShellCommand: []string{"/bin/bash", "-c",
+			fmt.Sprintf(`install -D -m 600 %[1]s %[2]s && kubectl --kubeconfig %[2]s config set-cluster "$(kubectl --kubeconfig %[2]s config view -o jsonpath='{.clusters[0].name}')" --server https://%[3]s`,
+				helmKubeconfig, kubeconfig, endpoint)},
+	}
+}
+
+// helmReleaseKubeconfig the kubeconfig the helm commands of release connect with, the one writeHelmKubeconfig
+// writes for endpoint or helmKubeconfig when endpoint is empty.
+func helmReleaseKubeconfig(release, endpoint string) string {
+	if endpoint == "" {
+		return helmKubeconfig
+	}
+	return filepath.Join(helmHome, release+".kubeconfig")
+}
+
+// withHelmKubeconfig sets the helm environment of the commands and the cancel commands of step, with endpoint
+// the step writes the kubeconfig of release pointing at it first, see helmReleaseKubeconfig.
+func withHelmKubeconfig(step v1.Step, release, endpoint string) v1.Step {
+	kubeconfig := helmReleaseKubeconfig(release, endpoint)
+	if endpoint != "" {
+		step.BeforeRunCommands = append(step.BeforeRunCommands, writeHelmKubeconfig(kubeconfig, endpoint))
+	}
+	for _, cmds := range [][]v1.Command{step.Commands, step.CancelCommands} {
+		for i := range cmds {
+			cmds[i].Env = helmEnv(kubeconfig)
+		}
+	}
+	return step
+}
+
+// helmSetKeyRegexp matches the value paths of helm --set, e.g. hubble.relay.enabled or ipam.operator.clusterPoolIPv4PodCIDRList[0].
+var helmSetKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+(\[[0-9]+\])?(\.[A-Za-z0-9_-]+(\[[0-9]+\])?)*$`)
+
+// HelmReleaseOptions the extra flags of helm upgrade --install.
+type HelmReleaseOptions struct {
+	Wait    bool
+	Atomic  bool
+	Timeout time.Duration
+	// ReuseValues keeps the values of the last release, the values file is merged on top of them.
+	ReuseValues bool
+	// Set the --set pairs, they override the values file.
+	Set map[string]string
+	// SetArgs the key=value --set arguments given by the user, they are passed in order after Set.
+	SetArgs []string
+	// APIServerEndpoint the host:port helm reaches the kube-apiserver at instead of the local one, the step writes
+	// a kubeconfig pointing at it, see v1.Cluster ExternalAPIServerEndpoint.
+	APIServerEndpoint string
+}
+
+func (o HelmReleaseOptions) args() []string {
+	var args []string
+	if o.Wait {
+		args = append(args, "--wait")
+	}
+	if o.Atomic {
+		args = append(args, "--atomic")
+	}
+	if o.ReuseValues {
+		args = append(args, "--reuse-values")
+	}
+	if o.Timeout > 0 {
+		args = append(args, "--timeout", o.Timeout.String())
+	}
+	for _, arg := range o.setArgs() {
+		args = append(args, "--set", arg)
+	}
+	return args
+}
+
+// setArgs the key=value --set arguments in the order helm applies them, Set sorted by key and then SetArgs.
+func (o HelmReleaseOptions) setArgs() []string {
+	keys := make([]string, 0, len(o.Set))
+	for k := range o.Set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := make([]string, 0, len(o.Set)+len(o.SetArgs))
+	for _, k := range keys {
+		args = append(args, k+"="+o.Set[k])
+	}
+	return append(args, o.SetArgs...)
+}
+
+// ValidateHelmSetArgs checks every argument is a key=value pair without shell metacharacters.
+func ValidateHelmSetArgs(args []string) error {
+	for _, arg := range args {
+		key, _, ok := strings.Cut(arg, "=")
+		if !ok || !helmSetKeyRegexp.MatchString(key) {
+			return fmt.Errorf("invalid helm set argument %q: must be key=value", arg)
+		}
+		if strings.ContainsAny(arg, helmSetArgForbiddenChars) {
+			return fmt.Errorf("invalid helm set argument %q: shell metacharacters and whitespace are not allowed", arg)
+		}
+	}
+	return nil
+}
+
+// stepTimeout derives the step timeout from the helm timeout, helm --wait blocks until the timeout at most.
+func (o HelmReleaseOptions) stepTimeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout + helmStepTimeoutBuffer
+	}
+	return helmStepTimeoutDefault
+}
+
+// InstallHelmRelease apply helm chart with rendered values
+func InstallHelmRelease(stepName, release, namespace, chartPath, values string, nodes []v1.StepNode, opts HelmReleaseOptions) v1.Step {
+	cmd := append([]string{common.HelmBin, "upgrade", "--install", "--create-namespace", release, "-n", namespace, chartPath, "-f", values}, opts.args()...)
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       stepName,
+		Timeout:    metav1.Duration{Duration: opts.stepTimeout()},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Commands: []v1.Command{
+			{
+				Type:          v1.CommandShell,
+				ShellCommand:  cmd,
+				SensitiveArgs: sensitiveHelmArgs(cmd),
+			},
+		},
+		ErrorMatchers: helmErrorMatchers,
+	}
+}
+
+// HelmSDKSupported whether the agents of all nodes run the v1.CommandHelm commands, the helm steps fall back to
+// the helm binary when an agent does not advertise it.
+func HelmSDKSupported(nodes []v1.StepNode) bool {
+	if len(nodes) == 0 {
+		return false
+	}
+	for _, node := range nodes {
+		if !node.HelmSDK {
+			return false
+		}
+	}
+	return true
+}
+
+// helmInstallCommand the v1.CommandHelm equivalent of the helm upgrade --install command of InstallHelmRelease.
+func helmInstallCommand(release, namespace, chartPath, values string, opts HelmReleaseOptions) v1.Command {
+	set := opts.setArgs()
+	return v1.Command{
+		Type: v1.CommandHelm,
+		Helm: &v1.HelmCommand{
+			Action:          v1.HelmActionInstall,
+			Release:         release,
+			Namespace:       namespace,
+			ChartPath:       chartPath,
+			ValuesFile:      values,
+			CreateNamespace: true,
+			Wait:            opts.Wait,
+			Atomic:          opts.Atomic,
+			ReuseValues:     opts.ReuseValues,
+			Timeout:         metav1.Duration{Duration: opts.Timeout},
+			Set:             set,
+			SensitiveSet:    sensitiveHelmSetArgs(set),
+		},
+	}
+}
+
+// DiffHelmRelease diffs the upgrade of the installed release with the rendered values against the deployed
+// release, the step is a v1.Step Diff step so it requires HelmSDKSupported nodes.
+func DiffHelmRelease(stepName, release, namespace, chartPath, values string, nodes []v1.StepNode, opts HelmReleaseOptions) v1.Step {
+	cmd := helmInstallCommand(release, namespace, chartPath, values, opts)
+	cmd.Helm.Action = v1.HelmActionDiff
+	cmd.Helm.CreateNamespace = false
+	return v1.Step{
+		ID:            newStepID(),
+		Name:          stepName,
+		Timeout:       metav1.Duration{Duration: helmStepTimeoutDefault},
+		ErrIgnore:     false,
+		RetryTimes:    1,
+		Nodes:         nodes,
+		Action:        v1.ActionUpgrade,
+		Diff:          true,
+		Commands:      []v1.Command{cmd},
+		ErrorMatchers: helmErrorMatchers,
+	}
+}
+
+// helmUninstallCommand the v1.CommandHelm equivalent of helm uninstall release -n namespace.
+func helmUninstallCommand(release, namespace string) v1.Command {
+	return v1.Command{
+		Type: v1.CommandHelm,
+		Helm: &v1.HelmCommand{
+			Action:    v1.HelmActionUninstall,
+			Release:   release,
+			Namespace: namespace,
+		},
+	}
+}
+
+// RollbackHelmRelease rolls release back to its previous revision, the release is uninstalled when it has none,
+// e.g. the first install of the release was interrupted.
+func RollbackHelmRelease(release, namespace string) v1.Command {
+	return v1.Command{
+		Type: v1.CommandShell,
+		ShellCommand: []string{"/bin/bash", "-c",
+			fmt.Sprintf(`%[3]s status %[1]s -n %[2]s >/dev/null 2>&1 || exit 0; %[3]s rollback %[1]s -n %[2]s || %[3]s uninstall %[1]s -n %[2]s`,
+				release, namespace, common.HelmBin)},
+	}
+}
+
+// UninstallHelmRelease uninstalls release and waits for its resources to be deleted, the step succeeds when
+// release is not installed so that it can be re-run.
+func UninstallHelmRelease(stepName, release, namespace string, nodes []v1.StepNode, timeout time.Duration) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       stepName,
+		Timeout:    metav1.Duration{Duration: timeout + helmStepTimeoutBuffer},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf(`%[4]s status %[1]s -n %[2]s >/dev/null 2>&1 || exit 0; %[4]s uninstall %[1]s -n %[2]s --wait --timeout %[3]s`,
+						release, namespace, timeout, common.HelmBin)},
+			},
+		},
+	}
+}
+
+// CheckHelmReleaseOwner fails when release already exists in namespace and was not installed by kubeclipper,
+// helm upgrade --install would otherwise silently take over the release.
+func CheckHelmReleaseOwner(stepName, release, namespace string, nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       stepName,
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf(`%[4]s status %[1]s -n %[2]s >/dev/null 2>&1 || exit 0; [ -n "$(kubectl get secret -n %[2]s -l owner=helm,name=%[1]s,%[3]s=true -o name)" ] && exit 0; echo "helm release %[1]s already exists in namespace %[2]s and is not managed by kubeclipper, uninstall it or choose another release name" >&2; exit 1`,
+						release, namespace, helmReleaseManagedLabel, common.HelmBin)},
+			},
+		},
+		ErrorMatchers: helmErrorMatchers,
+	}
+}
+
+// MarkHelmRelease labels the release secrets of release so that CheckHelmReleaseOwner accepts the release,
+// it runs after every install and upgrade because helm creates a new secret for each revision.
+func MarkHelmRelease(stepName, release, namespace string, nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       stepName,
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"kubectl", "label", "secret", "-n", namespace, "-l", "owner=helm,name=" + release,
+					helmReleaseManagedLabel + "=true", "--overwrite"},
+			},
+		},
+	}
+}
+
+// MergeHelmValues deep-merges the user values over the generated values, both in YAML.
+// Nested maps are merged, any other user value replaces the generated one and null deletes the key.
+func MergeHelmValues(generated []byte, user string) ([]byte, error) {
+	base := make(map[string]interface{})
+	if err := yaml.Unmarshal(generated, &base); err != nil {
+		return nil, fmt.Errorf("parse generated helm values: %w", err)
+	}
+	override := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(user), &override); err != nil {
+		return nil, fmt.Errorf("parse user helm values: %w", err)
+	}
+	return yaml.Marshal(mergeValues(base, override))
+}
+
+func mergeValues(dst, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		srcMap, srcOK := v.(map[string]interface{})
+		dstMap, dstOK := dst[k].(map[string]interface{})
+		if srcOK && dstOK {
+			dst[k] = mergeValues(dstMap, srcMap)
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
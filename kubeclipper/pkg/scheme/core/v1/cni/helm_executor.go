@@ -0,0 +1,75 @@
+package cni
+
+import (
+	"fmt"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+// validateHelmExecutor checks the helm executor is a master of the cluster, the masters are unknown when the
+// values are only rendered.
+func (runnable *CiliumRunnable) validateHelmExecutor() error {
+	if runnable.HelmExecutor == "" || len(runnable.masters) == 0 {
+		return nil
+	}
+	for _, master := range runnable.masters {
+		if master.ID == runnable.HelmExecutor {
+			return nil
+		}
+	}
+	return fmt.Errorf("the helm executor %s is not a master of the cluster", runnable.HelmExecutor)
+}
+
+// helmExecutor returns the master the steps against the kube-apiserver run on, the helm executor of the cni or the
+// first available master, and the other available masters they fail over to in order. nodes, the masters given by
+// the caller, are used when the masters of the cluster are unknown.
+func (runnable *CiliumRunnable) helmExecutor(nodes []v1.StepNode) (executor, failover []v1.StepNode, err error) {
+	if len(runnable.masters) == 0 {
+		return nodes, nil, runnable.nodeStates.require(nodes)
+	}
+	if err = runnable.validateHelmExecutor(); err != nil {
+		return nil, nil, err
+	}
+	var executors []v1.StepNode
+	for _, master := range runnable.masters {
+		if master.ID == runnable.HelmExecutor {
+			if err = runnable.nodeStates.require([]v1.StepNode{master}); err != nil {
+				return nil, nil, fmt.Errorf("the helm executor is unavailable: %w", err)
+			}
+			executors = append(executors, master)
+		}
+	}
+	for _, master := range runnable.nodeStates.available(runnable.masters) {
+		if master.ID != runnable.HelmExecutor {
+			executors = append(executors, master)
+		}
+	}
+	if len(executors) == 0 {
+		return nil, nil, runnable.nodeStates.require(runnable.masters)
+	}
+	return executors[:1], executors[1:], nil
+}
+
+// withFailover lets the steps run on executor fail over to failover when its agent does not respond, see
+// v1.Step FailoverNodes. The steps of the single node executor are tagged by its node ID, the per-node steps of the
+// same node, e.g. its preflight checks, must stay on it and are not passed.
+func withFailover(steps []v1.Step, executor, failover []v1.StepNode) []v1.Step {
+	if len(executor) != 1 || len(failover) == 0 {
+		return steps
+	}
+	for i := range steps {
+		if len(steps[i].Nodes) == 1 && steps[i].Nodes[0].ID == executor[0].ID {
+			steps[i].FailoverNodes = failover
+		}
+	}
+	return steps
+}
+
+// stagedOnFailover marks steps as staging the files the later steps of the executor read, e.g. the chart and the
+// values of the release, a step failing over runs them again on its new node first, see v1.Step FailoverStaging.
+func stagedOnFailover(steps []v1.Step) []v1.Step {
+	for i := range steps {
+		steps[i].FailoverStaging = true
+	}
+	return steps
+}
@@ -0,0 +1,152 @@
+package cni
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/component/utils"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func haMetadata() *component.ExtraMetadata {
+	return &component.ExtraMetadata{
+		CRI: v1.CRIContainerd,
+		Masters: component.NodeList{
+			{ID: "node1", Hostname: "master1", Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionFalse, Reason: "KubeletNotReady"}}},
+			{ID: "node2", Hostname: "master2"},
+			{ID: "node3", Hostname: "master3"},
+		},
+		Workers: component.NodeList{{ID: "node4", Hostname: "worker1"}},
+	}
+}
+
+func stepNodeIDs(nodes []v1.StepNode) []string {
+	var ids []string
+	for _, node := range nodes {
+		ids = append(ids, node.ID)
+	}
+	return ids
+}
+
+func TestCiliumRunnable_helmExecutor(t *testing.T) {
+	networking := &v1.Networking{Pods: v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}}}
+	tests := []struct {
+		name         string
+		executor     string
+		mtu          bool
+		wantExecutor string
+		wantFailover []string
+		wantErr      string
+	}{
+		{name: "first ready master", wantExecutor: "node2", wantFailover: []string{"node3"}},
+		{name: "override", executor: "node3", wantExecutor: "node3", wantFailover: []string{"node2"}},
+		{name: "auto detect mtu", mtu: true, wantExecutor: "node2", wantFailover: []string{"node3"}},
+		{name: "override not a master", executor: "node4", wantErr: "the helm executor node4 is not a master of the cluster"},
+		{name: "override unavailable", executor: "node1", wantErr: "the helm executor is unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepper := (&CiliumRunnable{}).InitStep(haMetadata(), &v1.CNI{Type: "cilium", Version: "1.14.4", HelmExecutor: tt.executor,
+				Cilium: &v1.Cilium{AutoDetectMTU: tt.mtu}}, networking)
+			steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "v1.27.4")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("InstallSteps() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			for _, name := range []string{"cilium-chartLoad", "renderCniYaml", "installCiliumRelease", "checkCiliumReady"} {
+				step := stepByName(steps, name)
+				if got := stepNodeIDs(step.Nodes); !reflect.DeepEqual(got, []string{tt.wantExecutor}) {
+					t.Errorf("%s nodes = %v, want %s", name, got, tt.wantExecutor)
+				}
+				if got := stepNodeIDs(step.FailoverNodes); !reflect.DeepEqual(got, tt.wantFailover) {
+					t.Errorf("%s failover nodes = %v, want %v", name, got, tt.wantFailover)
+				}
+				// the release fails over to a node without the chart and the values unless they are staged again
+				if staging := name == "cilium-chartLoad" || name == "renderCniYaml"; step.FailoverStaging != staging {
+					t.Errorf("%s failover staging = %v, want %v", name, step.FailoverStaging, staging)
+				}
+			}
+			// the render step is given the MTU detected before the failover, the detection is not staged again
+			for _, step := range steps {
+				if strings.HasPrefix(step.Name, "detectCiliumMTU-") && step.FailoverStaging {
+					t.Errorf("%s failover staging = true, want false", step.Name)
+				}
+			}
+			// the preflight checks of the executor check the executor itself
+			if step := stepByName(steps, "preflightCilium-"+tt.wantExecutor); len(step.FailoverNodes) > 0 {
+				t.Errorf("%s failover nodes = %v, want none", step.Name, stepNodeIDs(step.FailoverNodes))
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_apiServerEndpoint(t *testing.T) {
+	metadata := haMetadata()
+	metadata.APIServerEndpoint = "lb.example.com:8443"
+	networking := &v1.Networking{Pods: v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}}}
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Type: "cilium", Version: "1.14.4", Cilium: &v1.Cilium{}}, networking)
+	kubeconfig := helmHome + "/cilium.kubeconfig"
+	assertKubeconfig := func(step v1.Step) {
+		t.Helper()
+		if len(step.BeforeRunCommands) != 1 {
+			t.Fatalf("%s before run commands = %+v, want the kubeconfig written", step.Name, step.BeforeRunCommands)
+		}
+		write := step.BeforeRunCommands[0].ShellCommand[2]
+		if !strings.Contains(write, "install -D -m 600 "+helmKubeconfig+" "+kubeconfig) || !strings.Contains(write, "--server https://lb.example.com:8443") {
+			t.Errorf("%s kubeconfig command = %s", step.Name, write)
+		}
+		for _, cmd := range append(step.Commands, step.CancelCommands...) {
+			if !reflect.DeepEqual(cmd.Env, helmEnv(kubeconfig)) {
+				t.Errorf("%s command env = %v, want KUBECONFIG %s", step.Name, cmd.Env, kubeconfig)
+			}
+		}
+	}
+
+	steps, err := stepper.InstallSteps(nil, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	assertKubeconfig(stepByName(steps, "installCiliumRelease"))
+
+	sdk := haMetadata()
+	sdk.APIServerEndpoint = metadata.APIServerEndpoint
+	for i := range sdk.Masters {
+		sdk.Masters[i].HelmSDK = true
+	}
+	upgrader := (&CiliumRunnable{}).InitStep(sdk, &v1.CNI{Type: "cilium", Version: "1.14.4", Cilium: &v1.Cilium{}}, networking)
+	for _, toVersion := range []string{"1.14.4", "1.15.1"} {
+		steps, err = upgrader.UpgradeSteps(utils.UnwrapNodeList(sdk.Masters), "1.14.4", toVersion)
+		if err != nil {
+			t.Fatalf("UpgradeSteps() to %s error = %v", toVersion, err)
+		}
+		upgrade := stepByName(steps, "upgradeCiliumRelease")
+		assertKubeconfig(upgrade)
+		if len(upgrade.CancelCommands) != 1 || upgrade.Commands[0].Type != v1.CommandHelm {
+			t.Errorf("upgradeCiliumRelease = %+v, want the helm SDK install rolled back when cancelled", upgrade)
+		}
+		if toVersion == "1.14.4" {
+			assertKubeconfig(stepByName(steps, "diffCiliumRelease"))
+		}
+	}
+
+	calico := (&CalicoRunnable{}).InitStep(metadata, &v1.CNI{Type: "calico", Version: "v3.26.1",
+		Calico: &v1.Calico{Mode: CalicoNetworkIPIPAll}}, networking)
+	steps, err = stepper.MigrationSteps(calico, utils.UnwrapNodeList(metadata.GetAllNodes()))
+	if err != nil {
+		t.Fatalf("MigrationSteps() error = %v", err)
+	}
+	for _, name := range []string{"installCiliumRelease", "promoteCiliumRelease"} {
+		step := stepByName(steps, name)
+		assertKubeconfig(step)
+		if len(step.CancelCommands) != 1 {
+			t.Errorf("%s cancel commands = %+v, want the release rolled back", name, step.CancelCommands)
+		}
+	}
+}
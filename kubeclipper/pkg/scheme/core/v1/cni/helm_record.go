@@ -0,0 +1,183 @@
+package cni
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/component/common"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/cmdutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	helmReleaseRecord = "helm-release-record"
+	// helmHistoryMax the revisions of helm history kept in the record.
+	helmHistoryMax = "10"
+)
+
+// helmSensitiveKeyRegexp matches the value names whose values are redacted from the record,
+// e.g. registry credentials, ipsec keys and tokens.
+var helmSensitiveKeyRegexp = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|privatekey|ipsec.*key)`)
+
+func init() {
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-"+helmReleaseRecord, version, component.TypeStep), &HelmReleaseRecorder{}); err != nil {
+		panic(err)
+	}
+}
+
+// HelmReleaseRecorder responds with the v1.CNIReleaseRecord of Release, it runs on the node the release was installed
+// from so that it reads the values file helm installed the release with.
+type HelmReleaseRecorder struct {
+	Release    string   `json:"release"`
+	Namespace  string   `json:"namespace"`
+	Command    []string `json:"command"`
+	ValuesFile string   `json:"valuesFile"`
+}
+
+func (r *HelmReleaseRecorder) NewInstance() component.ObjectMeta {
+	return &HelmReleaseRecorder{}
+}
+
+func (r *HelmReleaseRecorder) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	if opts.DryRun {
+		return nil, nil
+	}
+	values, err := os.ReadFile(r.ValuesFile)
+	if err != nil {
+		return nil, err
+	}
+	redacted, err := RedactHelmValues(values)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(values)
+	record := &v1.CNIReleaseRecord{
+		Release:      r.Release,
+		Namespace:    r.Namespace,
+		Command:      r.Command,
+		Values:       string(redacted),
+		ValuesSHA256: hex.EncodeToString(sum[:]),
+	}
+	ec, err := cmdutil.RunCmdWithContext(ctx, opts.DryRun, common.HelmBin, "history", r.Release, "-n", r.Namespace, "--max", helmHistoryMax)
+	if err != nil {
+		record.History = cmdOutput(ec, err)
+	} else {
+		record.History = strings.TrimSpace(ec.StdOut())
+	}
+	return json.Marshal(record)
+}
+
+func (r *HelmReleaseRecorder) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	return nil, nil
+}
+
+// RecordHelmRelease records the command of the install step and the values file it installed release with,
+// the step responds with a v1.CNIReleaseRecord. The record is for auditing, it does not fail the install.
+func RecordHelmRelease(install v1.Step, release, namespace, values string) (v1.Step, error) {
+	var command []string
+	if len(install.Commands) > 0 {
+		command = RedactHelmArgs(install.Commands[0].ShellCommand)
+	}
+	custom, err := json.Marshal(&HelmReleaseRecorder{
+		Release:    release,
+		Namespace:  namespace,
+		Command:    command,
+		ValuesFile: values,
+	})
+	if err != nil {
+		return v1.Step{}, err
+	}
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       v1.StepRecordCNIRelease,
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      install.Nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:          v1.CommandCustom,
+				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+helmReleaseRecord, version, component.TypeStep),
+				CustomCommand: custom,
+			},
+		},
+	}, nil
+}
+
+// RedactHelmValues replaces the sensitive values of the YAML values, including the keys of the TLS
+// cert and key pairs, with v1.StepOutputRedacted.
+func RedactHelmValues(values []byte) ([]byte, error) {
+	parsed := make(map[string]interface{})
+	if err := yaml.Unmarshal(values, &parsed); err != nil {
+		return nil, fmt.Errorf("parse helm values: %w", err)
+	}
+	return yaml.Marshal(redactValues(parsed))
+}
+
+func redactValues(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		_, certPair := value["cert"]
+		for k, item := range value {
+			if item != nil && (helmSensitiveKeyRegexp.MatchString(k) || (certPair && k == "key")) {
+				if _, nested := item.(map[string]interface{}); !nested {
+					value[k] = v1.StepOutputRedacted
+					continue
+				}
+			}
+			value[k] = redactValues(item)
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = redactValues(value[i])
+		}
+	}
+	return v
+}
+
+// RedactHelmArgs redacts the sensitive values of the helm --set arguments.
+func RedactHelmArgs(args []string) []string {
+	redacted := append([]string(nil), args...)
+	for _, i := range sensitiveHelmArgs(args) {
+		key, _, _ := strings.Cut(args[i], "=")
+		redacted[i] = key + "=" + v1.StepOutputRedacted
+	}
+	return redacted
+}
+
+// sensitiveHelmArgs the indexes of the helm --set arguments with sensitive values.
+func sensitiveHelmArgs(args []string) []int {
+	var indexes []int
+	for i := 1; i < len(args); i++ {
+		if args[i-1] != "--set" {
+			continue
+		}
+		if key, _, ok := strings.Cut(args[i], "="); ok && helmSensitiveKeyRegexp.MatchString(key) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// sensitiveHelmSetArgs the indexes of the key=value --set arguments with sensitive values.
+func sensitiveHelmSetArgs(set []string) []int {
+	var indexes []int
+	for i, arg := range set {
+		if key, _, ok := strings.Cut(arg, "="); ok && helmSensitiveKeyRegexp.MatchString(key) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
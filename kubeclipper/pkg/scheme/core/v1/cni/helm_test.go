@@ -0,0 +1,392 @@
+package cni
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/component/common"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func TestInstallHelmRelease(t *testing.T) {
+	base := []string{common.HelmBin, "upgrade", "--install", "--create-namespace", "cilium", "-n", "kube-system", "chart.tgz", "-f", "values.yaml"}
+	tests := []struct {
+		name        string
+		opts        HelmReleaseOptions
+		wantArgs    []string
+		wantTimeout time.Duration
+	}{
+		{
+			name:        "default",
+			wantTimeout: 2 * time.Minute,
+		},
+		{
+			name:        "wait atomic",
+			opts:        HelmReleaseOptions{Wait: true, Atomic: true, Timeout: 10 * time.Minute},
+			wantArgs:    []string{"--wait", "--atomic", "--timeout", "10m0s"},
+			wantTimeout: 11 * time.Minute,
+		},
+		{
+			name:        "set pairs",
+			opts:        HelmReleaseOptions{Set: map[string]string{"operator.replicas": "2", "debug.enabled": "true"}},
+			wantArgs:    []string{"--set", "debug.enabled=true", "--set", "operator.replicas=2"},
+			wantTimeout: 2 * time.Minute,
+		},
+		{
+			name:        "user set args after set pairs",
+			opts:        HelmReleaseOptions{Set: map[string]string{"debug.enabled": "false"}, SetArgs: []string{"debug.enabled=true", "hubble.enabled=false"}},
+			wantArgs:    []string{"--set", "debug.enabled=false", "--set", "debug.enabled=true", "--set", "hubble.enabled=false"},
+			wantTimeout: 2 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step := InstallHelmRelease("installCiliumRelease", "cilium", "kube-system", "chart.tgz", "values.yaml", []v1.StepNode{{ID: "node1"}}, tt.opts)
+			if want := append(append([]string{}, base...), tt.wantArgs...); !reflect.DeepEqual(step.Commands[0].ShellCommand, want) {
+				t.Errorf("InstallHelmRelease() command = %v, want %v", step.Commands[0].ShellCommand, want)
+			}
+			if step.Timeout.Duration != tt.wantTimeout {
+				t.Errorf("InstallHelmRelease() timeout = %v, want %v", step.Timeout.Duration, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+// helmCLIArgs the helm cli arguments equivalent to the helm SDK command h.
+func helmCLIArgs(h *v1.HelmCommand) []string {
+	args := []string{common.HelmBin, "upgrade", "--install"}
+	if h.CreateNamespace {
+		args = append(args, "--create-namespace")
+	}
+	args = append(args, h.Release, "-n", h.Namespace, h.ChartPath, "-f", h.ValuesFile)
+	if h.Wait {
+		args = append(args, "--wait")
+	}
+	if h.Atomic {
+		args = append(args, "--atomic")
+	}
+	if h.ReuseValues {
+		args = append(args, "--reuse-values")
+	}
+	if h.Timeout.Duration > 0 {
+		args = append(args, "--timeout", h.Timeout.Duration.String())
+	}
+	for _, arg := range h.Set {
+		args = append(args, "--set", arg)
+	}
+	return args
+}
+
+func TestHelmInstallCommand(t *testing.T) {
+	for _, opts := range []HelmReleaseOptions{
+		{},
+		{Wait: true, Atomic: true, Timeout: 10 * time.Minute},
+		{ReuseValues: true, Timeout: time.Minute},
+		{Set: map[string]string{"operator.replicas": "2", "debug.enabled": "true"}},
+		{Set: map[string]string{"debug.enabled": "false"}, SetArgs: []string{"debug.enabled=true", "hubble.enabled=false"}},
+		{SetArgs: []string{"debug.enabled=true", "clustermesh.apiserver.tls.auth.token=abc"}},
+	} {
+		shell := InstallHelmRelease("installCiliumRelease", "cilium", "kube-system", "chart.tgz", "values.yaml", []v1.StepNode{{ID: "node1"}}, opts).Commands[0]
+		cmd := helmInstallCommand("cilium", "kube-system", "chart.tgz", "values.yaml", opts)
+		if cmd.Type != v1.CommandHelm || cmd.Helm.Action != v1.HelmActionInstall {
+			t.Fatalf("helmInstallCommand(%+v) = %+v, want a helm install command", opts, cmd)
+		}
+		if got := helmCLIArgs(cmd.Helm); !reflect.DeepEqual(got, shell.ShellCommand) {
+			t.Errorf("helmInstallCommand(%+v) = %v, want the shell command %v", opts, got, shell.ShellCommand)
+		}
+		var sensitive []string
+		for _, i := range shell.SensitiveArgs {
+			sensitive = append(sensitive, shell.ShellCommand[i])
+		}
+		var got []string
+		for _, i := range cmd.Helm.SensitiveSet {
+			got = append(got, cmd.Helm.Set[i])
+		}
+		if !reflect.DeepEqual(got, sensitive) {
+			t.Errorf("helmInstallCommand(%+v) sensitive set = %v, want %v", opts, got, sensitive)
+		}
+	}
+}
+
+func TestHelmSDKSupported(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []v1.StepNode
+		want  bool
+	}{
+		{name: "no nodes"},
+		{name: "all nodes", nodes: []v1.StepNode{{ID: "node1", HelmSDK: true}, {ID: "node2", HelmSDK: true}}, want: true},
+		{name: "old agent", nodes: []v1.StepNode{{ID: "node1", HelmSDK: true}, {ID: "node2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HelmSDKSupported(tt.nodes); got != tt.want {
+				t.Errorf("HelmSDKSupported() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateHelmSetArgs(t *testing.T) {
+	valid := []string{"debug.enabled=true", "ipam.operator.clusterPoolIPv4PodCIDRList[0]=10.0.0.0/8", "k8sServiceHost=", "extraArgs={--foo,--bar}"}
+	if err := ValidateHelmSetArgs(valid); err != nil {
+		t.Errorf("ValidateHelmSetArgs() error = %v", err)
+	}
+	for _, arg := range []string{"debug.enabled", "=true", "debug..enabled=true", "debug.enabled=true;reboot", "a=$(id)", "a=`id`",
+		"a=b c", "a=b\nc", "a=b|c", "a=b&&c", "a='b'"} {
+		if err := ValidateHelmSetArgs([]string{arg}); err == nil {
+			t.Errorf("ValidateHelmSetArgs(%q) should fail", arg)
+		}
+	}
+}
+
+func TestInstallCalicoRelease(t *testing.T) {
+	nodes := []v1.StepNode{{ID: "node1"}}
+	if got := InstallCalicoRelease("chart.tgz", "values.yaml", nodes, HelmReleaseOptions{}).Timeout.Duration; got != time.Minute {
+		t.Errorf("InstallCalicoRelease() default timeout = %v, want 1m", got)
+	}
+	step := InstallCalicoRelease("chart.tgz", "values.yaml", nodes, HelmReleaseOptions{Timeout: 5 * time.Minute})
+	if got := step.Timeout.Duration; got != 6*time.Minute {
+		t.Errorf("InstallCalicoRelease() timeout = %v, want 6m", got)
+	}
+	if cmd := strings.Join(step.Commands[0].ShellCommand, " "); !strings.HasSuffix(cmd, "--timeout 5m0s") {
+		t.Errorf("InstallCalicoRelease() command = %s, want --timeout 5m0s", cmd)
+	}
+}
+
+func TestMergeHelmValues(t *testing.T) {
+	generated := `operator:
+  replicas: 1
+  image:
+    repository: quay.io/cilium/operator
+ipam:
+  operator:
+    clusterPoolIPv4PodCIDRList: ["10.0.0.0/16"]
+kubeProxyReplacement: "false"
+`
+	tests := []struct {
+		name    string
+		user    string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "nested maps",
+			user: "operator:\n  replicas: 2\n  prometheus:\n    enabled: true\n",
+			want: `ipam:
+  operator:
+    clusterPoolIPv4PodCIDRList:
+    - 10.0.0.0/16
+kubeProxyReplacement: "false"
+operator:
+  image:
+    repository: quay.io/cilium/operator
+  prometheus:
+    enabled: true
+  replicas: 2
+`,
+		},
+		{
+			name: "lists replaced wholesale",
+			user: "ipam:\n  operator:\n    clusterPoolIPv4PodCIDRList: [\"10.1.0.0/16\", \"10.2.0.0/16\"]\n",
+			want: `ipam:
+  operator:
+    clusterPoolIPv4PodCIDRList:
+    - 10.1.0.0/16
+    - 10.2.0.0/16
+kubeProxyReplacement: "false"
+operator:
+  image:
+    repository: quay.io/cilium/operator
+  replicas: 1
+`,
+		},
+		{
+			name: "null deletes key",
+			user: "operator:\n  image: null\nkubeProxyReplacement: ~\n",
+			want: `ipam:
+  operator:
+    clusterPoolIPv4PodCIDRList:
+    - 10.0.0.0/16
+operator:
+  replicas: 1
+`,
+		},
+		{
+			name: "scalar replaces map",
+			user: "ipam: disabled\n",
+			want: `ipam: disabled
+kubeProxyReplacement: "false"
+operator:
+  image:
+    repository: quay.io/cilium/operator
+  replicas: 1
+`,
+		},
+		{
+			name:    "invalid yaml",
+			user:    "operator:\n  replicas: 2\n\tbad: 1\n",
+			wantErr: "line 3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergeHelmValues([]byte(generated), tt.user)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("MergeHelmValues() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MergeHelmValues() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MergeHelmValues() got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactHelmValues(t *testing.T) {
+	values := `imagePullSecrets:
+- name: registry
+registry:
+  password: s3cret
+  username: admin
+encryption:
+  ipsec:
+    keyFile: keys
+tls:
+  cert: certdata
+  key: keydata
+operator:
+  tolerations:
+  - key: node-role.kubernetes.io/control-plane
+    operator: Exists
+clustermesh:
+  apiserver:
+    tls:
+      auto:
+        method: helm
+`
+	got, err := RedactHelmValues([]byte(values))
+	if err != nil {
+		t.Fatalf("RedactHelmValues() error = %v", err)
+	}
+	for _, secret := range []string{"s3cret", "keydata", "name: registry"} {
+		if strings.Contains(string(got), secret) {
+			t.Errorf("RedactHelmValues() = %s, should not contain %q", got, secret)
+		}
+	}
+	for _, kept := range []string{"username: admin", "cert: certdata", "key: node-role.kubernetes.io/control-plane", "method: helm"} {
+		if !strings.Contains(string(got), kept) {
+			t.Errorf("RedactHelmValues() = %s, should contain %q", got, kept)
+		}
+	}
+	if _, err = RedactHelmValues([]byte("- a\n- b")); err == nil {
+		t.Error("RedactHelmValues() should fail on values which are not a map")
+	}
+}
+
+func TestRecordHelmRelease(t *testing.T) {
+	install := InstallHelmRelease("installCiliumRelease", "cilium", "kube-system", "chart.tgz", "values.yaml", []v1.StepNode{{ID: "node1"}},
+		HelmReleaseOptions{SetArgs: []string{"debug.enabled=true", "encryption.ipsec.secretName=keys", "clustermesh.apiserver.etcd.password=pass"}})
+	step, err := RecordHelmRelease(install, "cilium", "kube-system", "values.yaml")
+	if err != nil {
+		t.Fatalf("RecordHelmRelease() error = %v", err)
+	}
+	if step.Name != v1.StepRecordCNIRelease || !step.ErrIgnore || !reflect.DeepEqual(step.Nodes, install.Nodes) {
+		t.Errorf("RecordHelmRelease() = %+v, want an ignorable %s step on the install nodes", step, v1.StepRecordCNIRelease)
+	}
+	recorder := &HelmReleaseRecorder{}
+	if err = json.Unmarshal(step.Commands[0].CustomCommand, recorder); err != nil {
+		t.Fatalf("unmarshal recorder: %v", err)
+	}
+	cmd := strings.Join(recorder.Command, " ")
+	if !strings.Contains(cmd, "debug.enabled=true") || strings.Contains(cmd, "=keys") || strings.Contains(cmd, "=pass") {
+		t.Errorf("recorded command = %s, want the sensitive values redacted", cmd)
+	}
+}
+
+func TestStepper_RollbackSteps(t *testing.T) {
+	metadata := &component.ExtraMetadata{
+		Masters: component.NodeList{{ID: "node1", Hostname: "node1", IPv4: "10.0.0.1"}},
+		Workers: component.NodeList{{ID: "node2", Hostname: "node2", IPv4: "10.0.0.2"}},
+	}
+	master := []v1.StepNode{{ID: "node1"}}
+	tests := []struct {
+		name    string
+		stepper Stepper
+		want    []string
+		// wantImageNodes the number of nodes the images are removed from
+		wantImageNodes int
+	}{
+		{
+			name:    "calico",
+			stepper: newCalicoStepper(metadata, "v3.26.1", &v1.Calico{Mode: CalicoNetworkIPIPAll}),
+			want:    []string{"removeCalicoRelease", "removeCniManifests"},
+		},
+		{
+			name: "cilium",
+			stepper: (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Version: "1.14.4", Offline: true, Namespace: "cilium",
+				Cilium: &v1.Cilium{}}, &v1.Networking{}),
+			want:           []string{"uninstallCiliumRelease", "removeCiliumNamespace", "removeCniManifests", "removeCniImage"},
+			wantImageNodes: 2,
+		},
+		{
+			name:    "flannel",
+			stepper: newFlannelStepper(metadata, nil, "10.244.0.0/16"),
+			want:    []string{"deleteCniYaml", "removeCniManifests"},
+		},
+		{
+			name:    "kube-ovn",
+			stepper: newKubeOvnStepper(metadata, nil, "10.16.0.0/16"),
+			want:    []string{"uninstallKubeOvnRelease", "removeCniManifests"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := tt.stepper.RollbackSteps(master)
+			if err != nil {
+				t.Fatalf("RollbackSteps() error = %v", err)
+			}
+			if got := stepNames(steps); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RollbackSteps() = %v, want %v", got, tt.want)
+			}
+			if remove := stepByName(steps, "removeCniImage"); len(remove.Nodes) != tt.wantImageNodes {
+				t.Errorf("the images are removed from %v, want %d nodes", remove.Nodes, tt.wantImageNodes)
+			}
+			// the rollback runs when nothing may have been installed, the removal commands must tolerate it
+			for _, step := range steps {
+				cmd := strings.Join(step.Commands[0].ShellCommand, " ")
+				if step.ErrIgnore || strings.Contains(cmd, "|| exit 0") || strings.Contains(cmd, "--ignore-not-found") ||
+					strings.HasPrefix(cmd, "rm -f") || strings.Contains(cmd, "elif [ -f") {
+					continue
+				}
+				t.Errorf("step %s is not idempotent: %s", step.Name, cmd)
+			}
+		})
+	}
+}
+
+func TestWithRollback(t *testing.T) {
+	install := []v1.Step{{ID: "load"}, {ID: "install"}}
+	rollback := []v1.Step{{ID: "uninstall"}}
+	op := &v1.Operation{Steps: append(append([]v1.Step{{ID: "init"}}, WithRollback(install, rollback)...), v1.Step{ID: "addon"})}
+	if op.Steps[1].RollbackAfter != "install" || len(op.Steps[1].RollbackSteps) != 1 {
+		t.Fatalf("WithRollback() registered %q %v", op.Steps[1].RollbackAfter, op.Steps[1].RollbackSteps)
+	}
+	for failed, want := range map[int][]int{0: nil, 2: nil, 3: {1}} {
+		if got := op.CompletedRollbacks(failed); !reflect.DeepEqual(got, want) {
+			t.Errorf("CompletedRollbacks(%d) = %v, want %v", failed, got, want)
+		}
+	}
+	if got := WithRollback(nil, rollback); got != nil {
+		t.Errorf("WithRollback() without install steps = %v", got)
+	}
+}
@@ -0,0 +1,564 @@
+package cni
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/component/common"
+	"github.com/kubeclipper/kubeclipper/pkg/component/utils"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/fileutil"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
+	tmplutil "github.com/kubeclipper/kubeclipper/pkg/utils/template"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	KubeOvnTunnelGeneve = "geneve"
+	KubeOvnTunnelVXLAN  = "vxlan"
+	KubeOvnTunnelSTT    = "stt"
+
+	// KubeOvnNamespace the kube-ovn chart deploys kube-ovn in kube-system whatever the release namespace is.
+	KubeOvnNamespace = "kube-system"
+	// kubeOvnRelease the release name of the kube-ovn chart
+	kubeOvnRelease = "kube-ovn"
+	// kubeOvnMasterLabel the node label the ovn-central pods are scheduled by, the chart spreads them
+	// over the labelled nodes with a required pod anti-affinity on the hostname.
+	kubeOvnMasterLabel = "kube-ovn/role"
+
+	kubeOvnDefaultJoinCIDR     = "100.64.0.0/16"
+	kubeOvnDefaultJoinIPv6CIDR = "fd00:100:64::/112"
+	// kubeOvnDefaultSubnet and kubeOvnJoinSubnet the subnets kube-ovn-controller creates from the values
+	kubeOvnDefaultSubnet = "ovn-default"
+	kubeOvnJoinSubnet    = "join"
+)
+
+var kubeOvnTunnelTypes = sets.NewString(KubeOvnTunnelGeneve, KubeOvnTunnelVXLAN, KubeOvnTunnelSTT)
+
+func init() {
+	Register(&KubeOvnRunnable{})
+	if err := component.RegisterTemplate(fmt.Sprintf(component.RegisterTemplateKeyFormat,
+		cniInfo+"-kube-ovn", version, component.TypeTemplate), &KubeOvnRunnable{}); err != nil {
+		panic(err)
+	}
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-kube-ovn", version, component.TypeStep), &KubeOvnRunnable{}); err != nil {
+		panic(err)
+	}
+}
+
+// KubeOvnRunnable installs kube-ovn from its chart. The ovn databases run as a raft cluster on some masters,
+// see ovnDBNodes.
+type KubeOvnRunnable struct {
+	BaseCni
+	// NetStack, PodCIDR, PodGateway, ServiceCIDR and JoinCIDR are rendered into the chart values,
+	// the CIDRs of both families are joined by a comma on dual-stack clusters.
+	NetStack    string `json:"netStack"`
+	PodCIDR     string `json:"podCIDR"`
+	PodGateway  string `json:"podGateway"`
+	ServiceCIDR string `json:"serviceCIDR"`
+	JoinCIDR    string `json:"joinCIDR"`
+	TunnelType  string `json:"tunnelType"`
+	// MasterNodes the addresses of the ovn database nodes.
+	MasterNodes   string `json:"masterNodes"`
+	kubeProxyMode string
+	// dbNodes the masters running the ovn databases, see ovnDBNodes
+	dbNodes []v1.StepNode
+	// masters the master nodes of the cluster, the cluster scoped steps run on the first one
+	masters []v1.StepNode
+	// allNodes all nodes of the cluster, the cluster scoped steps only run when every node is uninstalled
+	allNodes []v1.StepNode
+}
+
+func (runnable *KubeOvnRunnable) Type() string {
+	return "kube-ovn"
+}
+
+func (runnable *KubeOvnRunnable) DefaultNamespace() string {
+	return KubeOvnNamespace
+}
+
+func (runnable *KubeOvnRunnable) Create() Stepper {
+	return &KubeOvnRunnable{}
+}
+
+func (runnable *KubeOvnRunnable) NewInstance() component.ObjectMeta {
+	return &KubeOvnRunnable{}
+}
+
+func (runnable *KubeOvnRunnable) InitStep(metadata *component.ExtraMetadata, cni *v1.CNI, networking *v1.Networking) Stepper {
+	stepper := &KubeOvnRunnable{}
+	stepper.CNI = *cni
+	stepper.BaseCni.Type = "kube-ovn"
+	stepper.CriType = metadata.CRI
+	stepper.Namespace = KubeOvnNamespace
+	stepper.PodIPv4CIDR, stepper.PodIPv6CIDR = SplitPodCIDRs(networking)
+	stepper.DualStack = stepper.PodIPv4CIDR != "" && stepper.PodIPv6CIDR != ""
+	config := cni.KubeOvn
+	if config == nil {
+		config = &v1.KubeOvn{}
+	}
+	defaults := stepper.Defaults()
+	stepper.TunnelType = strutil.StringDefaultIfEmpty(defaults.TunnelType, config.TunnelType)
+
+	var podCIDRs, joinCIDRs []string
+	if stepper.PodIPv4CIDR != "" {
+		podCIDRs = append(podCIDRs, stepper.PodIPv4CIDR)
+		joinCIDRs = append(joinCIDRs, strutil.StringDefaultIfEmpty(defaults.JoinCIDR, config.JoinCIDR))
+	}
+	if stepper.PodIPv6CIDR != "" {
+		podCIDRs = append(podCIDRs, stepper.PodIPv6CIDR)
+		joinCIDRs = append(joinCIDRs, strutil.StringDefaultIfEmpty(defaults.JoinIPv6CIDR, config.JoinIPv6CIDR))
+	}
+	var gateways []string
+	for _, cidr := range podCIDRs {
+		gateways = append(gateways, subnetGateway(cidr))
+	}
+	switch {
+	case stepper.DualStack:
+		stepper.NetStack = "dual_stack"
+	case stepper.PodIPv6CIDR != "":
+		stepper.NetStack = "ipv6"
+	default:
+		stepper.NetStack = "ipv4"
+	}
+	stepper.PodCIDR = strings.Join(podCIDRs, ",")
+	stepper.PodGateway = strings.Join(gateways, ",")
+	stepper.JoinCIDR = strings.Join(joinCIDRs, ",")
+	if networking != nil {
+		stepper.ServiceCIDR = strings.Join(networking.Services.CIDRBlocks, ",")
+	}
+
+	stepper.kubeProxyMode = metadata.KubeProxyMode
+	stepper.masters = utils.UnwrapNodeList(metadata.Masters)
+	stepper.allNodes = utils.UnwrapNodeList(metadata.GetAllNodes())
+	stepper.nodeStates = newNodeStates(metadata)
+	stepper.dbNodes = ovnDBNodes(stepper.masters)
+	var addresses []string
+	for _, node := range stepper.dbNodes {
+		addresses = append(addresses, node.IPv4)
+	}
+	stepper.MasterNodes = strings.Join(addresses, ",")
+	return stepper
+}
+
+// ovnDBNodes picks the masters running the ovn databases. The raft cluster of the databases needs an odd number
+// of members to keep a quorum, three members survive the failure of one, more members only slow the writes down.
+func ovnDBNodes(masters []v1.StepNode) []v1.StepNode {
+	if len(masters) >= 3 {
+		return masters[:3]
+	}
+	if len(masters) > 0 {
+		return masters[:1]
+	}
+	return nil
+}
+
+// subnetGateway the first address of cidr, kube-ovn uses it as the gateway of the subnet.
+func subnetGateway(cidr string) string {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return ""
+	}
+	ip := new(big.Int).SetBytes(ipNet.IP)
+	ip.Add(ip, big.NewInt(1))
+	gateway := make(net.IP, len(ipNet.IP))
+	return net.IP(ip.FillBytes(gateway)).String()
+}
+
+// Defaults the effective kube-ovn configuration when the cluster leaves a field unset, the IPv6 join subnet
+// applies to the dual-stack and IPv6 clusters.
+func (runnable *KubeOvnRunnable) Defaults() *v1.KubeOvn {
+	return &v1.KubeOvn{
+		JoinCIDR:     kubeOvnDefaultJoinCIDR,
+		JoinIPv6CIDR: kubeOvnDefaultJoinIPv6CIDR,
+		TunnelType:   KubeOvnTunnelGeneve,
+	}
+}
+
+func (runnable *KubeOvnRunnable) setDefaults(cni *v1.CNI) {
+	cni.KubeOvn = runnable.Defaults()
+}
+
+func (runnable *KubeOvnRunnable) Validate() error {
+	if err := runnable.validateTimeouts(); err != nil {
+		return fieldError("timeouts", err)
+	}
+	if err := runnable.validateRetryPolicy(); err != nil {
+		return fieldError("retryPolicy", err)
+	}
+	if err := runnable.validateChartSource(); err != nil {
+		return fieldError("chartSource", err)
+	}
+	if runnable.PushToRegistry {
+		return fieldError("pushToRegistry", fmt.Errorf("kube-ovn does not support pushing the images to the local registry"))
+	}
+	if !kubeOvnTunnelTypes.Has(runnable.TunnelType) {
+		return fmt.Errorf("invalid kube-ovn tunnel type %q, supported values: %v", runnable.TunnelType, kubeOvnTunnelTypes.List())
+	}
+	if runnable.kubeProxyMode == kubeProxyModeEBPF {
+		return fmt.Errorf("kube-ovn does not replace kube-proxy, proxy mode %s is not supported", kubeProxyModeEBPF)
+	}
+	if runnable.PodCIDR == "" {
+		return fmt.Errorf("kube-ovn requires a pod cidr")
+	}
+	if len(runnable.dbNodes) == 0 {
+		return fmt.Errorf("kube-ovn requires a master to run the ovn databases")
+	}
+	var others []*net.IPNet
+	for _, cidr := range strings.Split(runnable.PodCIDR+","+runnable.ServiceCIDR, ",") {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			others = append(others, ipNet)
+		}
+	}
+	for _, cidr := range strings.Split(runnable.JoinCIDR, ",") {
+		_, join, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid kube-ovn join cidr %q: %v", cidr, err)
+		}
+		for _, other := range others {
+			if join.Contains(other.IP) || other.Contains(join.IP) {
+				return fmt.Errorf("kube-ovn join cidr %s overlaps %s", cidr, other)
+			}
+		}
+	}
+	return nil
+}
+
+// GetImages returns the kube-ovn image, the chart runs every component from it.
+func (runnable *KubeOvnRunnable) GetImages(version string, criType string) ([]string, error) {
+	if criType != "" && !v1.AllowedCRIType.Has(criType) {
+		return nil, fmt.Errorf("unsupported cri type %q", criType)
+	}
+	registry := strutil.StringDefaultIfEmpty("docker.io", strings.TrimSuffix(runnable.LocalRegistry, "/"))
+	return []string{fmt.Sprintf("%s/kubeovn/kube-ovn:%s", registry, version)}, nil
+}
+
+func (runnable *KubeOvnRunnable) LoadImage(nodes []v1.StepNode) ([]v1.Step, error) {
+	if runnable.Offline && runnable.LocalRegistry == "" {
+		return runnable.stableSteps(loadImageSteps("kube-ovn", runnable.nodeStates.available(nodes), runnable.imageLoadTimeout(), func(arch string) ([]byte, error) {
+			target := *runnable
+			target.Arch = arch
+			return json.Marshal(&target)
+		}))
+	}
+	return nil, nil
+}
+
+// InstallSteps labels the ovn database nodes, installs the chart and waits for the subnets kube-ovn-controller
+// bootstraps from the values, the pods are not scheduled until the default subnet is ready.
+func (runnable *KubeOvnRunnable) InstallSteps(nodes []v1.StepNode, kubernetesVersion string) ([]v1.Step, error) {
+	if err := runnable.nodeStates.require(nodes); err != nil {
+		return nil, err
+	}
+	if err := runnable.checkCompatibility(kubernetesVersion); err != nil {
+		return nil, err
+	}
+	var steps []v1.Step
+	chart := &common.Chart{
+		PkgName: "kube-ovn",
+		Version: runnable.Version,
+		Offline: runnable.Offline,
+		Source:  runnable.ChartSource,
+	}
+	cLoadSteps, err := chart.InstallStepsV2(nodes)
+	if err != nil {
+		return nil, err
+	}
+	steps = append(steps, cLoadSteps...)
+	bytes, err := json.Marshal(runnable)
+	if err != nil {
+		return nil, err
+	}
+	steps = append(steps, RenderYaml("kube-ovn", bytes, nodes), runnable.labelDBNodes(nodes))
+	steps = append(steps, CheckHelmReleaseOwner("checkKubeOvnRelease", kubeOvnRelease, runnable.Namespace, nodes))
+	chartPath := filepath.Join(downloader.BaseDstDir, "."+chart.PkgName, chart.Version, downloader.ChartFilename)
+	steps = append(steps,
+		InstallHelmRelease("installKubeOvnRelease", kubeOvnRelease, runnable.Namespace, chartPath, filepath.Join(manifestDir, "kube-ovn.yaml"), nodes,
+			HelmReleaseOptions{Timeout: runnable.installTimeout(0)}),
+		MarkHelmRelease("markKubeOvnRelease", kubeOvnRelease, runnable.Namespace, nodes),
+		runnable.checkSubnets(nodes))
+	return runnable.stableSteps(runnable.withRetryPolicy(steps), nil)
+}
+
+// labelDBNodes labels the ovn database nodes, ovn-central is only scheduled to the labelled nodes.
+func (runnable *KubeOvnRunnable) labelDBNodes(nodes []v1.StepNode) v1.Step {
+	cmd := []string{"kubectl", "label", "node"}
+	for _, node := range runnable.dbNodes {
+		cmd = append(cmd, node.Hostname)
+	}
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "labelKubeOvnDBNodes",
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: append(cmd, kubeOvnMasterLabel+"=master", "--overwrite"),
+			},
+		},
+	}
+}
+
+// checkSubnets waits for the default and join subnets, kube-ovn-controller creates them once the chart is installed.
+func (runnable *KubeOvnRunnable) checkSubnets(nodes []v1.StepNode) v1.Step {
+	timeout := runnable.installTimeout(5 * time.Minute)
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "checkKubeOvnSubnets",
+		Timeout:    metav1.Duration{Duration: timeout + time.Minute},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf("for i in $(seq 30); do kubectl get subnet %[1]s %[2]s >/dev/null 2>&1 && break; sleep 2; done; kubectl wait subnet %[1]s %[2]s --for=condition=Ready --timeout=%[3]s",
+						kubeOvnDefaultSubnet, kubeOvnJoinSubnet, timeout)},
+			},
+		},
+	}
+}
+
+// UninstallSteps removes the release and the kube-ovn CRDs when the whole cluster is uninstalled,
+// then cleans the nodes up.
+func (runnable *KubeOvnRunnable) UninstallSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	var steps []v1.Step
+	if clusterNodes, ok := clusterScopedNodes(nodes, runnable.allNodes, runnable.masters); ok {
+		steps = append(steps, runnable.removeRelease(clusterNodes))
+		if runnable.RemoveHelm {
+			helm, err := common.GetRemoveHelmStep(clusterNodes)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, helm)
+		}
+	}
+	leaveSteps, err := runnable.LeaveNodeSteps(nodes)
+	if err != nil {
+		return nil, err
+	}
+	return runnable.stableSteps(runnable.withRetryPolicy(append(steps, leaveSteps...)), nil)
+}
+
+func (runnable *KubeOvnRunnable) removeRelease(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "uninstallKubeOvnRelease",
+		Timeout:    metav1.Duration{Duration: runnable.uninstallTimeout(2 * time.Minute)},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{common.HelmBin, "uninstall", kubeOvnRelease, "-n", runnable.Namespace},
+			},
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					"kubectl get crd -o name | grep '\\.kubeovn\\.io$' | xargs -r kubectl delete --ignore-not-found"},
+			},
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "label", "node", "--all", kubeOvnMasterLabel + "-"},
+			},
+		},
+	}
+}
+
+// RollbackSteps uninstalls the kube-ovn release installed on nodes and removes the kube-ovn images, the CRDs and
+// the database node labels are kept for the install to reuse.
+func (runnable *KubeOvnRunnable) RollbackSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	steps := []v1.Step{
+		UninstallHelmRelease("uninstallKubeOvnRelease", kubeOvnRelease, runnable.Namespace, nodes, runnable.uninstallTimeout(2*time.Minute)),
+		RemoveManifests(nodes, "kube-ovn.yaml"),
+	}
+	images, err := runnable.rollbackImages("kube-ovn", runnable, runnable.allNodes)
+	if err != nil {
+		return nil, err
+	}
+	return runnable.stableSteps(runnable.withRetryPolicy(append(steps, images...)), nil)
+}
+
+// JoinNodeSteps loads the kube-ovn images on the joining nodes of offline clusters, the DaemonSets
+// schedule kube-ovn themselves.
+func (runnable *KubeOvnRunnable) JoinNodeSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	return runnable.LoadImage(nodes)
+}
+
+// LeaveNodeSteps removes the ovs and ovn state from the nodes leaving the cluster.
+func (runnable *KubeOvnRunnable) LeaveNodeSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	steps := []v1.Step{runnable.clearNode(nodes)}
+	if runnable.Offline && runnable.LocalRegistry == "" {
+		custom, err := json.Marshal(runnable)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, RemoveImage("kube-ovn", custom, nodes))
+	}
+	return runnable.stableSteps(runnable.withRetryPolicy(steps), nil)
+}
+
+// clearNode deletes the interfaces and the ovs/ovn directories kube-ovn left on the nodes,
+// a later ovs would load the stale databases otherwise.
+func (runnable *KubeOvnRunnable) clearNode(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "clearKubeOvnNode",
+		Timeout:    metav1.Duration{Duration: runnable.uninstallTimeout(30 * time.Second)},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					"for link in ovn0 genev_sys_6081 vxlan_sys_4789 stt_sys_7471 ovs-system br-int; do ip link delete $link 2>/dev/null; done; true"},
+			},
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"rm", "-rf", "/var/run/openvswitch", "/var/run/ovn", "/etc/origin/openvswitch", "/etc/origin/ovn",
+					"/var/log/openvswitch", "/var/log/ovn", "/var/log/kube-ovn", "/etc/openvswitch", "/etc/cni/net.d/01-kube-ovn.conflist"},
+			},
+		},
+	}
+}
+
+func (runnable *KubeOvnRunnable) UpgradeSteps(nodes []v1.StepNode, fromVersion, toVersion string) ([]v1.Step, error) {
+	return nil, fmt.Errorf("kube-ovn upgrade from %s to %s is not supported", fromVersion, toVersion)
+}
+
+// MigrationSteps the cni migration only supports migrating from calico to cilium.
+func (runnable *KubeOvnRunnable) MigrationSteps(from Stepper, nodes []v1.StepNode) ([]v1.Step, error) {
+	return nil, fmt.Errorf("migrating from %T to kube-ovn is not supported", from)
+}
+
+// CheckSteps the health check is not implemented for kube-ovn.
+func (runnable *KubeOvnRunnable) CheckSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	return nil, fmt.Errorf("the kube-ovn health check is not supported")
+}
+
+// PreRestoreSteps kube-ovn has no state to capture before a restore.
+func (runnable *KubeOvnRunnable) PreRestoreSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	return nil, nil
+}
+
+// PostRestoreSteps kube-ovn has no state to reconcile after a restore, its daemon-set is restarted by the restore.
+func (runnable *KubeOvnRunnable) PostRestoreSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	return nil, nil
+}
+
+// CmdList cni kubectl cmd list
+func (runnable *KubeOvnRunnable) CmdList(namespace string) map[string]string {
+	return operationCommands(runnable.Operations(namespace))
+}
+
+func (runnable *KubeOvnRunnable) Operations(namespace string) []Operation {
+	return []Operation{
+		{
+			Name:        OperationGet,
+			Description: "List the kube-ovn pods and subnets.",
+			Command:     fmt.Sprintf("kubectl get po -n %s -l component=network -o wide && kubectl get subnets", namespace),
+			Output:      OperationOutputText,
+		},
+		{
+			Name:        OperationStatus,
+			Description: "Show the rollout status of the kube-ovn cni and ovs DaemonSets.",
+			Command: fmt.Sprintf("kubectl get ds kube-ovn-cni ovs-ovn -n %[1]s -o wide && kubectl rollout status ds kube-ovn-cni -n %[1]s --timeout=10s && kubectl rollout status ds ovs-ovn -n %[1]s --timeout=10s",
+				namespace),
+			Output: OperationOutputText,
+		},
+		{
+			Name:        OperationLogs,
+			Description: fmt.Sprintf("Show the last %d log lines of every kube-ovn cni server.", operationLogLines),
+			Command: fmt.Sprintf("kubectl -n %s logs -l app=kube-ovn-cni -c cni-server --tail=%d --prefix --max-log-requests=%d",
+				namespace, operationLogLines, operationMaxLogRequests),
+			Output: OperationOutputText,
+		},
+		{
+			Name:        OperationVersion,
+			Description: "Show the image of the kube-ovn controller.",
+			Command:     fmt.Sprintf("kubectl get deploy kube-ovn-controller -n %s -o jsonpath='{.spec.template.spec.containers[0].image}'", namespace),
+			Output:      OperationOutputText,
+		},
+		{
+			Name:        OperationRestart,
+			Description: "Restart the kube-ovn cni servers, the pod networking of the nodes is disrupted until they are ready.",
+			Command:     fmt.Sprintf("kubectl rollout restart ds kube-ovn-cni -n %s", namespace),
+			Destructive: true,
+			Output:      OperationOutputText,
+		},
+		healthOperation("kube-ovn", Workloads{Namespace: namespace, DaemonSets: []string{"kube-ovn-cni", "ovs-ovn"},
+			Deployments: []string{"kube-ovn-controller"}, Release: kubeOvnRelease}),
+	}
+}
+
+func (runnable *KubeOvnRunnable) Render(ctx context.Context, opts component.Options) error {
+	runnable.gatherFacts(ctx)
+	if opts.DryRun {
+		_, err := runnable.RenderString(ctx)
+		return err
+	}
+	if err := os.MkdirAll(manifestDir, 0755); err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomicWithContext(ctx, filepath.Join(manifestDir, "kube-ovn.yaml"), 0644,
+		runnable.renderKubeOvnTo, opts.DryRun)
+}
+
+// RenderString returns the helm values Render writes to the manifest file.
+func (runnable *KubeOvnRunnable) RenderString(ctx context.Context) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := runnable.renderKubeOvnTo(buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (runnable *KubeOvnRunnable) renderKubeOvnTo(w io.Writer) error {
+	_, err := tmplutil.New().RenderTo(w, kubeOvnValuesTemplate, runnable)
+	return err
+}
+
+const kubeOvnValuesTemplate = `global:
+  registry:
+    address: {{with .CNI.LocalRegistry}}{{.}}{{else}}docker.io{{end}}/kubeovn
+  images:
+    kubeovn:
+      repository: kube-ovn
+      tag: {{.CNI.Version}}
+networking:
+  NET_STACK: {{.NetStack}}
+  NETWORK_TYPE: geneve
+  TUNNEL_TYPE: {{.TunnelType}}
+  DEFAULT_SUBNET: ovn-default
+  NODE_SUBNET: join
+{{.NetStack}}:
+  POD_CIDR: "{{.PodCIDR}}"
+  POD_GATEWAY: "{{.PodGateway}}"
+  SVC_CIDR: "{{.ServiceCIDR}}"
+  JOIN_CIDR: "{{.JoinCIDR}}"
+MASTER_NODES: "{{.MasterNodes}}"
+MASTER_NODES_LABEL: "kube-ovn/role=master"
+`
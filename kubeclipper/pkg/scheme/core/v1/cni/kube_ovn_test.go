@@ -0,0 +1,135 @@
+package cni
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func kubeOvnMetadata(masters int) *component.ExtraMetadata {
+	metadata := &component.ExtraMetadata{Workers: component.NodeList{{ID: "worker1", Hostname: "worker1", IPv4: "10.0.0.100"}}}
+	for i := 1; i <= masters; i++ {
+		name := fmt.Sprintf("master%d", i)
+		metadata.Masters = append(metadata.Masters, component.Node{ID: name, Hostname: name, IPv4: fmt.Sprintf("10.0.0.%d", i)})
+	}
+	return metadata
+}
+
+func newKubeOvnStepper(metadata *component.ExtraMetadata, config *v1.KubeOvn, pods ...string) *KubeOvnRunnable {
+	networking := &v1.Networking{
+		Services: v1.NetworkRanges{CIDRBlocks: []string{"10.96.0.0/12"}},
+		Pods:     v1.NetworkRanges{CIDRBlocks: pods},
+	}
+	return (&KubeOvnRunnable{}).InitStep(metadata, &v1.CNI{Type: "kube-ovn", Version: "v1.12.4", KubeOvn: config}, networking).(*KubeOvnRunnable)
+}
+
+func TestKubeOvnRunnable_render(t *testing.T) {
+	stepper := newKubeOvnStepper(kubeOvnMetadata(3), nil, "172.25.0.0/16")
+	stepper.LocalRegistry = "172.0.0.1:5000"
+	w := &bytes.Buffer{}
+	if err := stepper.renderKubeOvnTo(w); err != nil {
+		t.Fatalf("renderKubeOvnTo() error = %v", err)
+	}
+	assertGolden(t, "kube-ovn-base", w.Bytes())
+
+	stepper = newKubeOvnStepper(kubeOvnMetadata(1), &v1.KubeOvn{TunnelType: KubeOvnTunnelVXLAN}, "172.25.0.0/16", "fd00:10:244::/56")
+	values, err := stepper.RenderString(context.TODO())
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	for _, want := range []string{
+		"NET_STACK: dual_stack", "TUNNEL_TYPE: vxlan", "dual_stack:\n",
+		`POD_CIDR: "172.25.0.0/16,fd00:10:244::/56"`, `POD_GATEWAY: "172.25.0.1,fd00:10:244::1"`,
+		`JOIN_CIDR: "100.64.0.0/16,fd00:100:64::/112"`, `MASTER_NODES: "10.0.0.1"`,
+	} {
+		if !strings.Contains(values, want) {
+			t.Errorf("RenderString() does not contain %q:\n%s", want, values)
+		}
+	}
+}
+
+func TestOvnDBNodes(t *testing.T) {
+	tests := []struct {
+		masters int
+		want    int
+	}{
+		{masters: 0, want: 0},
+		{masters: 1, want: 1},
+		{masters: 2, want: 1},
+		{masters: 3, want: 3},
+		{masters: 5, want: 3},
+	}
+	for _, tt := range tests {
+		stepper := newKubeOvnStepper(kubeOvnMetadata(tt.masters), nil, "172.25.0.0/16")
+		if got := len(strings.FieldsFunc(stepper.MasterNodes, func(r rune) bool { return r == ',' })); len(stepper.dbNodes) != tt.want || got != tt.want {
+			t.Errorf("%d masters: db nodes = %v, MasterNodes = %q, want %d", tt.masters, stepper.dbNodes, stepper.MasterNodes, tt.want)
+		}
+	}
+}
+
+func TestKubeOvnRunnable_Validate(t *testing.T) {
+	tests := []struct {
+		name          string
+		masters       int
+		config        *v1.KubeOvn
+		kubeProxyMode string
+		pods          []string
+		wantErr       bool
+	}{
+		{name: "default", masters: 1, pods: []string{"172.25.0.0/16"}},
+		{name: "stt", masters: 3, config: &v1.KubeOvn{TunnelType: "stt"}, pods: []string{"172.25.0.0/16"}},
+		{name: "typo", masters: 1, config: &v1.KubeOvn{TunnelType: "gre"}, pods: []string{"172.25.0.0/16"}, wantErr: true},
+		{name: "no master", pods: []string{"172.25.0.0/16"}, wantErr: true},
+		{name: "no pod cidr", masters: 1, wantErr: true},
+		{name: "ebpf proxy", masters: 1, kubeProxyMode: "ebpf", pods: []string{"172.25.0.0/16"}, wantErr: true},
+		{name: "invalid join", masters: 1, config: &v1.KubeOvn{JoinCIDR: "100.64.0.0"}, pods: []string{"172.25.0.0/16"}, wantErr: true},
+		{name: "join overlaps pods", masters: 1, config: &v1.KubeOvn{JoinCIDR: "172.25.128.0/24"}, pods: []string{"172.25.0.0/16"}, wantErr: true},
+		{name: "join overlaps services", masters: 1, config: &v1.KubeOvn{JoinCIDR: "10.96.0.0/16"}, pods: []string{"172.25.0.0/16"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := kubeOvnMetadata(tt.masters)
+			metadata.KubeProxyMode = tt.kubeProxyMode
+			if err := newKubeOvnStepper(metadata, tt.config, tt.pods...).Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKubeOvnRunnable_Steps(t *testing.T) {
+	stepper := newKubeOvnStepper(kubeOvnMetadata(3), nil, "172.25.0.0/16")
+	stepper.Offline = true
+	master := []v1.StepNode{{ID: "master1"}}
+	steps, err := stepper.InstallSteps(master, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	want := []string{"renderCniYaml", "labelKubeOvnDBNodes", "checkKubeOvnRelease", "installKubeOvnRelease", "markKubeOvnRelease", "checkKubeOvnSubnets"}
+	if got := stepNames(steps[len(steps)-len(want):]); !reflect.DeepEqual(got, want) {
+		t.Errorf("InstallSteps() = %v, want %v", got, want)
+	}
+	if label := stepByName(steps, "labelKubeOvnDBNodes"); !reflect.DeepEqual(label.Commands[0].ShellCommand[3:6], []string{"master1", "master2", "master3"}) {
+		t.Errorf("labelKubeOvnDBNodes command = %v", label.Commands[0].ShellCommand)
+	}
+
+	all := stepper.allNodes
+	if steps, err = stepper.UninstallSteps(all); err != nil {
+		t.Fatalf("UninstallSteps() error = %v", err)
+	}
+	if got := stepNames(steps); !reflect.DeepEqual(got, []string{"uninstallKubeOvnRelease", "clearKubeOvnNode", "removeCniImage"}) {
+		t.Errorf("UninstallSteps() = %v", got)
+	}
+	if clear := stepByName(steps, "clearKubeOvnNode"); !strings.Contains(strings.Join(clear.Commands[1].ShellCommand, " "), "/etc/origin/ovn") {
+		t.Errorf("clearKubeOvnNode commands = %v", clear.Commands)
+	}
+	if steps, err = stepper.UninstallSteps(all[1:]); err != nil || stepByName(steps, "uninstallKubeOvnRelease").Name != "" {
+		t.Errorf("UninstallSteps() of some nodes should keep the release: %v, %v", stepNames(steps), err)
+	}
+}
@@ -0,0 +1,95 @@
+package cni
+
+import (
+	"fmt"
+	"time"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// kube-proxy is not deployed when the cluster proxy mode is ebpf
+	kubeProxyModeEBPF = "ebpf"
+	// apiServerDomainPrefix keep the same with k8s.APIServerDomainPrefix, the domain is written to /etc/hosts of every node
+	apiServerDomainPrefix = "apiserver."
+	apiServerPort         = 6443
+
+	// kubernetesServicesEndpoint the ConfigMap calico reads the apiserver address from when it replaces kube-proxy.
+	kubernetesServicesEndpoint = "kubernetes-services-endpoint"
+)
+
+// kubernetesServiceEndpoint the apiserver address of the cni agents which replace kube-proxy,
+// they can not reach the apiserver through the kubernetes service without kube-proxy.
+func kubernetesServiceEndpoint(networking *v1.Networking) (string, int) {
+	dnsDomain := ""
+	if networking != nil {
+		dnsDomain = networking.DNSDomain
+	}
+	return apiServerDomainPrefix + strutil.StringDefaultIfEmpty("cluster.local", dnsDomain), apiServerPort
+}
+
+// removeKubeProxy deletes the kube-proxy deployed by kubeadm once the cni DaemonSet ds is healthy,
+// keeping both of them will break the service forwarding rules.
+func removeKubeProxy(ds, namespace string, nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "removeKubeProxy",
+		Timeout:    metav1.Duration{Duration: 6 * time.Minute},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "rollout", "status", "ds/" + ds, "-n", namespace, "--timeout", "5m"},
+			},
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "delete", "ds", "kube-proxy", "-n", "kube-system", "--ignore-not-found"},
+			},
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "delete", "cm", "kube-proxy", "-n", "kube-system", "--ignore-not-found"},
+			},
+		},
+	}
+}
+
+// cleanKubeProxyRules flushes the ipvs and iptables rules kube-proxy left on every node,
+// deleting the DaemonSet does not remove them.
+func cleanKubeProxyRules(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "cleanKubeProxyRules",
+		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c", "ip link delete kube-ipvs0 2>/dev/null; if command -v ipvsadm >/dev/null; then ipvsadm --clear; fi; true"},
+			},
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					"iptables-save | grep -v KUBE | iptables-restore; if command -v ip6tables-save >/dev/null; then ip6tables-save | grep -v KUBE | ip6tables-restore; fi"},
+			},
+		},
+	}
+}
+
+// applyServicesEndpoint applies the kubernetes-services-endpoint ConfigMap in namespace, the calico components
+// load the apiserver address host:port from it.
+func applyServicesEndpoint(namespace, host string, port int) v1.Command {
+	return v1.Command{
+		Type: v1.CommandShell,
+		ShellCommand: []string{"/bin/bash", "-c",
+			fmt.Sprintf("kubectl create configmap %s -n %s --from-literal=KUBERNETES_SERVICE_HOST=%s --from-literal=KUBERNETES_SERVICE_PORT=%d --dry-run=client -o yaml | kubectl apply -f -",
+				kubernetesServicesEndpoint, namespace, host, port)},
+	}
+}
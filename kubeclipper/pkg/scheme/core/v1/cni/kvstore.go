@@ -0,0 +1,112 @@
+package cni
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	CiliumIdentityAllocationCRD     = "crd"
+	CiliumIdentityAllocationKVStore = "kvstore"
+
+	// CiliumEtcdSecretName the secret the cilium chart mounts the etcd client certificates from.
+	CiliumEtcdSecretName = "cilium-etcd-secrets"
+
+	// ciliumKVStoreMinControlPlanes the control-plane nodes a kvstore cluster needs to survive the loss of one of them.
+	ciliumKVStoreMinControlPlanes = 3
+)
+
+// KVStore the etcd rendered by the values template, nil when the identities are not allocated in a kvstore.
+func (runnable *CiliumRunnable) KVStore() *v1.CiliumKVStore {
+	if runnable.CiliumConfig == nil || runnable.CiliumConfig.IdentityAllocationMode != CiliumIdentityAllocationKVStore {
+		return nil
+	}
+	return runnable.CiliumConfig.KVStore
+}
+
+func (runnable *CiliumRunnable) validateKVStore() error {
+	mode, kvstore := runnable.CiliumConfig.IdentityAllocationMode, runnable.CiliumConfig.KVStore
+	switch mode {
+	case "", CiliumIdentityAllocationCRD:
+		if kvstore != nil {
+			return fmt.Errorf("cilium kvstore requires identity allocation mode %s", CiliumIdentityAllocationKVStore)
+		}
+		return nil
+	case CiliumIdentityAllocationKVStore:
+	default:
+		return fmt.Errorf("invalid cilium identity allocation mode %q, supported values: %s, %s",
+			mode, CiliumIdentityAllocationCRD, CiliumIdentityAllocationKVStore)
+	}
+	if kvstore == nil || len(kvstore.Endpoints) == 0 || kvstore.CAFile == "" || kvstore.CertFile == "" || kvstore.KeyFile == "" {
+		return fmt.Errorf("cilium identity allocation mode %s requires the kvstore endpoints, caFile, certFile and keyFile",
+			CiliumIdentityAllocationKVStore)
+	}
+	for _, endpoint := range kvstore.Endpoints {
+		if u, err := url.Parse(endpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid cilium kvstore endpoint %q: must be an https URL", endpoint)
+		}
+	}
+	for _, file := range []string{kvstore.CAFile, kvstore.CertFile, kvstore.KeyFile} {
+		if !filepath.IsAbs(file) || strings.ContainsAny(file, helmSetArgForbiddenChars) {
+			return fmt.Errorf("invalid cilium kvstore certificate path %q: must be an absolute path without shell metacharacters", file)
+		}
+	}
+	if len(runnable.masters) < ciliumKVStoreMinControlPlanes && !kvstore.Force {
+		return fmt.Errorf("cilium identity allocation mode %s requires at least %d control-plane nodes, the cluster has %d, set force to override",
+			CiliumIdentityAllocationKVStore, ciliumKVStoreMinControlPlanes, len(runnable.masters))
+	}
+	return nil
+}
+
+// createKVStoreSecret creates the CiliumEtcdSecretName secret from the etcd certificates on nodes,
+// it is replaced on reinstall so that renewed certificates are picked up.
+func (runnable *CiliumRunnable) createKVStoreSecret(nodes []v1.StepNode) v1.Step {
+	kvstore := runnable.KVStore()
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "createCiliumEtcdSecret",
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf("kubectl create ns %s --dry-run=client -o yaml | kubectl apply -f -", runnable.Namespace)},
+			},
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf("kubectl create secret generic %s -n %s --from-file=etcd-client-ca.crt=%s --from-file=etcd-client.crt=%s "+
+						"--from-file=etcd-client.key=%s --dry-run=client -o yaml | kubectl apply -f -",
+						CiliumEtcdSecretName, runnable.Namespace, kvstore.CAFile, kvstore.CertFile, kvstore.KeyFile)},
+			},
+		},
+	}
+}
+
+func (runnable *CiliumRunnable) removeKVStoreSecret(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "removeCiliumEtcdSecret",
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "delete", "secret", CiliumEtcdSecretName, "-n", runnable.Namespace, "--ignore-not-found"},
+			},
+		},
+	}
+}
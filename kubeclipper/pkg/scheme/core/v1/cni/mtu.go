@@ -0,0 +1,163 @@
+package cni
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/cmdutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ciliumMTU = "cilium-mtu"
+
+	// ciliumTunnelOverhead the bytes the vxlan and geneve encapsulation take from the MTU of the node.
+	ciliumTunnelOverhead = 50
+	ciliumMinMTU         = 576
+	ciliumMaxMTU         = 65535
+)
+
+// detectMTUScript prints the MTU of the interface of the default route, IPv6 only nodes use the IPv6 default route.
+const detectMTUScript = `dev=$({ ip route show default; ip -6 route show default; } 2>/dev/null | awk '{for (i = 1; i < NF; i++) if ($i == "dev") {print $(i+1); exit}}')
+[ -n "$dev" ] || { echo "the node has no default route"; exit 1; }
+ip -o link show dev "$dev" | awk '{for (i = 1; i < NF; i++) if ($i == "mtu") print $(i+1)}'`
+
+func init() {
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-"+ciliumMTU, version, component.TypeStep), &CiliumMTU{}); err != nil {
+		panic(err)
+	}
+}
+
+// MTUResult the smallest MTU detected so far, every detection step passes it to the next one.
+type MTUResult struct {
+	MTU int `json:"mtu"`
+}
+
+// CiliumMTU detects the MTU of the node, or renders the cilium values with the detected MTU when Values is set.
+// The detection steps run one node after another, each of them replies the minimum of its MTU and the
+// reply of the previous step, so that the render step receives the minimum across the nodes.
+type CiliumMTU struct {
+	Values *CiliumRunnable `json:"values,omitempty"`
+}
+
+func (m *CiliumMTU) NewInstance() component.ObjectMeta {
+	return &CiliumMTU{}
+}
+
+func (m *CiliumMTU) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	detected := lastMTU(ctx)
+	if m.Values != nil {
+		return nil, m.render(ctx, detected, opts)
+	}
+	ec, err := cmdutil.RunCmdWithContext(ctx, opts.DryRun, "/bin/bash", "-c", detectMTUScript)
+	if err != nil {
+		return nil, fmt.Errorf("detect the MTU of the default route interface failed: %s", cmdOutput(ec, err))
+	}
+	if !opts.DryRun {
+		mtu, err := strconv.Atoi(strings.TrimSpace(ec.StdOut()))
+		if err != nil {
+			return nil, fmt.Errorf("invalid MTU %q of the default route interface", strings.TrimSpace(ec.StdOut()))
+		}
+		if detected == 0 || mtu < detected {
+			detected = mtu
+		}
+	}
+	return json.Marshal(&MTUResult{MTU: detected})
+}
+
+func (m *CiliumMTU) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	return nil, nil
+}
+
+func (m *CiliumMTU) render(ctx context.Context, detected int, opts component.Options) error {
+	values := *m.Values
+	config := *values.CiliumConfig
+	switch {
+	case detected > 0:
+		config.MTU = detected - values.tunnelOverhead()
+	case !opts.DryRun:
+		return fmt.Errorf("no MTU was detected on the nodes")
+	}
+	values.CiliumConfig = &config
+	return values.Render(ctx, opts)
+}
+
+// lastMTU returns the MTU replied by the previous detection step, 0 when the previous step is not one.
+func lastMTU(ctx context.Context) int {
+	result := &MTUResult{}
+	if data := component.GetExtraData(ctx); data != nil {
+		_ = json.Unmarshal(data, result)
+	}
+	return result.MTU
+}
+
+// tunnelOverhead the encapsulation overhead of TunnelMode, native routing has none.
+func (runnable *CiliumRunnable) tunnelOverhead() int {
+	if runnable.CiliumConfig != nil && runnable.CiliumConfig.TunnelMode == CiliumTunnelDisabled {
+		return 0
+	}
+	return ciliumTunnelOverhead
+}
+
+func (runnable *CiliumRunnable) validateMTU() error {
+	config := runnable.CiliumConfig
+	if config.MTU != 0 && config.AutoDetectMTU {
+		return fmt.Errorf("cilium mtu and autoDetectMTU can not be set together")
+	}
+	if config.MTU != 0 && (config.MTU < ciliumMinMTU || config.MTU > ciliumMaxMTU) {
+		return fmt.Errorf("invalid cilium mtu %d, must be between %d and %d", config.MTU, ciliumMinMTU, ciliumMaxMTU)
+	}
+	return nil
+}
+
+// renderValues renders the values of cilium on nodes. With AutoDetectMTU the MTU of every available node of the
+// cluster is detected first and the values are rendered with the minimum less the tunnel overhead. Only the render
+// step stages the values on the failover nodes, it is given the MTU the detection steps replied again.
+func (runnable *CiliumRunnable) renderValues(values *CiliumRunnable, nodes []v1.StepNode) ([]v1.Step, error) {
+	bytes, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	if values.CiliumConfig == nil || !values.CiliumConfig.AutoDetectMTU {
+		return stagedOnFailover([]v1.Step{RenderYaml("cilium", bytes, nodes)}), nil
+	}
+	detectNodes := runnable.nodeStates.available(runnable.allNodes)
+	if len(detectNodes) == 0 {
+		detectNodes = nodes
+	}
+	var steps []v1.Step
+	for _, node := range detectNodes {
+		steps = append(steps, mtuStep(fmt.Sprintf("detectCiliumMTU-%s", node.Hostname), []byte("{}"), []v1.StepNode{node}))
+	}
+	render, err := json.Marshal(&CiliumMTU{Values: values})
+	if err != nil {
+		return nil, err
+	}
+	return append(steps, stagedOnFailover([]v1.Step{mtuStep("renderCniYaml", render, nodes)})...), nil
+}
+
+func mtuStep(name string, custom []byte, nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       name,
+		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:          v1.CommandCustom,
+				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+ciliumMTU, version, component.TypeStep),
+				CustomCommand: custom,
+			},
+		},
+	}
+}
@@ -0,0 +1,435 @@
+package cni
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/component/utils"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/fileutil"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
+	tmplutil "github.com/kubeclipper/kubeclipper/pkg/utils/template"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// MultusVersion the version of the multus thin plugin installed in front of the cni.
+	MultusVersion = "v4.0.2"
+	// multusConfFile the config multus writes in front of the config of the cni, the container runtime
+	// picks the first config of /etc/cni/net.d.
+	multusConfFile = "/etc/cni/net.d/00-multus.conf"
+	// multusNetworksFile the NetworkAttachmentDefinitions rendered next to the multus manifests
+	multusNetworksFile = "multus-networks.yaml"
+)
+
+func init() {
+	if err := component.RegisterTemplate(fmt.Sprintf(component.RegisterTemplateKeyFormat,
+		cniInfo+"-multus", version, component.TypeTemplate), &MultusRunnable{}); err != nil {
+		panic(err)
+	}
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-multus", version, component.TypeStep), &MultusRunnable{}); err != nil {
+		panic(err)
+	}
+}
+
+// MultusRunnable installs multus as a meta plugin in front of the cni of the cluster. It is not a cni of its own,
+// the steps are layered over the steps of the cni, see NewMultus.
+type MultusRunnable struct {
+	BaseCni
+	// masters the master nodes of the cluster, the cluster scoped steps run on the first one
+	masters []v1.StepNode
+	// allNodes all nodes of the cluster, the cluster scoped steps only run when every node is uninstalled
+	allNodes []v1.StepNode
+}
+
+// NewMultus returns the multus layered over the cni c, it is nil when c does not enable multus.
+func NewMultus(metadata *component.ExtraMetadata, c *v1.CNI) *MultusRunnable {
+	if !c.EnableMultus {
+		return nil
+	}
+	runnable := &MultusRunnable{}
+	runnable.CNI = *c
+	runnable.BaseCni.Type = "multus"
+	runnable.Version = MultusVersion
+	runnable.CriType = metadata.CRI
+	runnable.masters = utils.UnwrapNodeList(metadata.Masters)
+	runnable.allNodes = utils.UnwrapNodeList(metadata.GetAllNodes())
+	return runnable
+}
+
+func (runnable *MultusRunnable) NewInstance() component.ObjectMeta {
+	return &MultusRunnable{}
+}
+
+// ValidateNetworkAttachments checks the NetworkAttachmentDefinitions of c, they require multus.
+func ValidateNetworkAttachments(c *v1.CNI) error {
+	if len(c.NetworkAttachments) > 0 && !c.EnableMultus {
+		return fmt.Errorf("network attachments require multus to be enabled")
+	}
+	names := sets.NewString()
+	for _, attachment := range c.NetworkAttachments {
+		if errs := validation.IsDNS1123Subdomain(attachment.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid network attachment name %q: %s", attachment.Name, strings.Join(errs, ", "))
+		}
+		namespace := strutil.StringDefaultIfEmpty(metav1.NamespaceDefault, attachment.Namespace)
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace %q of network attachment %s: %s", namespace, attachment.Name, strings.Join(errs, ", "))
+		}
+		key := namespace + "/" + attachment.Name
+		if names.Has(key) {
+			return fmt.Errorf("duplicate network attachment %s", key)
+		}
+		names.Insert(key)
+		config := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(attachment.Config), &config); err != nil {
+			return fmt.Errorf("the config of network attachment %s is not a JSON object: %v", attachment.Name, err)
+		}
+		if _, ok := config["type"]; !ok {
+			if _, ok = config["plugins"]; !ok {
+				return fmt.Errorf("the config of network attachment %s has neither a type nor plugins", attachment.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (runnable *MultusRunnable) Validate() error {
+	return ValidateNetworkAttachments(&runnable.CNI)
+}
+
+func (runnable *MultusRunnable) LoadImage(nodes []v1.StepNode) ([]v1.Step, error) {
+	if runnable.Offline && runnable.LocalRegistry == "" {
+		return runnable.stableSteps(loadImageSteps("multus", nodes, runnable.imageLoadTimeout(), func(arch string) ([]byte, error) {
+			target := *runnable
+			target.Arch = arch
+			return json.Marshal(&target)
+		}))
+	}
+	return nil, nil
+}
+
+// InstallSteps installs multus once the cni is installed, multus generates its config from the config of the cni,
+// then applies the NetworkAttachmentDefinitions.
+func (runnable *MultusRunnable) InstallSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	bytes, err := json.Marshal(runnable)
+	if err != nil {
+		return nil, err
+	}
+	apply := ApplyYaml(filepath.Join(manifestDir, "multus.yaml"), nodes)
+	apply.Name = "applyMultus"
+	steps := []v1.Step{RenderYaml("multus", bytes, nodes), apply, {
+		ID:         newStepID(),
+		Name:       "checkMultusReady",
+		Timeout:    metav1.Duration{Duration: runnable.installTimeout(5*time.Minute) + time.Minute},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"kubectl", "rollout", "status", "ds/kube-multus-ds", "-n", metav1.NamespaceSystem,
+					"--timeout", runnable.installTimeout(5 * time.Minute).String()},
+			},
+		},
+	}}
+	if len(runnable.NetworkAttachments) > 0 {
+		networks := ApplyYaml(filepath.Join(manifestDir, multusNetworksFile), nodes)
+		networks.Name = "applyMultusNetworks"
+		steps = append(steps, networks)
+	}
+	return runnable.stableSteps(steps, nil)
+}
+
+// UninstallSteps removes multus when the whole cluster is uninstalled, then its config from the nodes. They must
+// run before the cni is cleaned up, the nodes are left with a multus config delegating to a removed cni otherwise.
+func (runnable *MultusRunnable) UninstallSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	var steps []v1.Step
+	if clusterNodes, ok := clusterScopedNodes(nodes, runnable.allNodes, runnable.masters); ok {
+		steps = append(steps, v1.Step{
+			ID:         newStepID(),
+			Name:       "removeMultus",
+			Timeout:    metav1.Duration{Duration: runnable.uninstallTimeout(2 * time.Minute)},
+			ErrIgnore:  true,
+			RetryTimes: 1,
+			Nodes:      clusterNodes,
+			Action:     v1.ActionUninstall,
+			Commands: []v1.Command{
+				{
+					Type:         v1.CommandShell,
+					ShellCommand: []string{"kubectl", "delete", "ds", "kube-multus-ds", "-n", metav1.NamespaceSystem, "--ignore-not-found", "--wait"},
+				},
+				{
+					Type:         v1.CommandShell,
+					ShellCommand: []string{"kubectl", "delete", "crd", "network-attachment-definitions.k8s.cni.cncf.io", "--ignore-not-found"},
+				},
+				{
+					Type:         v1.CommandShell,
+					ShellCommand: []string{"kubectl", "delete", "clusterrolebinding,clusterrole", "multus", "--ignore-not-found"},
+				},
+			},
+		})
+	}
+	leaveSteps, err := runnable.LeaveNodeSteps(nodes)
+	if err != nil {
+		return nil, err
+	}
+	return runnable.stableSteps(append(steps, leaveSteps...), nil)
+}
+
+// LeaveNodeSteps removes the multus config and binary from the nodes.
+func (runnable *MultusRunnable) LeaveNodeSteps(nodes []v1.StepNode) ([]v1.Step, error) {
+	steps := []v1.Step{{
+		ID:         newStepID(),
+		Name:       "clearMultusNode",
+		Timeout:    metav1.Duration{Duration: runnable.uninstallTimeout(30 * time.Second)},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"rm", "-rf", multusConfFile, "/etc/cni/net.d/multus.d", "/opt/cni/bin/multus-shim", "/opt/cni/bin/multus"},
+			},
+		},
+	}}
+	if runnable.Offline && runnable.LocalRegistry == "" {
+		custom, err := json.Marshal(runnable)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, RemoveImage("multus", custom, nodes))
+	}
+	return runnable.stableSteps(steps, nil)
+}
+
+func (runnable *MultusRunnable) Render(ctx context.Context, opts component.Options) error {
+	runnable.gatherFacts(ctx)
+	if opts.DryRun {
+		_, err := runnable.RenderString(ctx)
+		return err
+	}
+	if err := os.MkdirAll(manifestDir, 0755); err != nil {
+		return err
+	}
+	if err := fileutil.WriteFileAtomicWithContext(ctx, filepath.Join(manifestDir, "multus.yaml"), 0644,
+		runnable.renderMultusTo, opts.DryRun); err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomicWithContext(ctx, filepath.Join(manifestDir, multusNetworksFile), 0644,
+		runnable.renderNetworksTo, opts.DryRun)
+}
+
+// RenderString returns the manifests and the NetworkAttachmentDefinitions Render writes.
+func (runnable *MultusRunnable) RenderString(ctx context.Context) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := runnable.renderMultusTo(buf); err != nil {
+		return "", err
+	}
+	if err := runnable.renderNetworksTo(buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (runnable *MultusRunnable) renderMultusTo(w io.Writer) error {
+	_, err := tmplutil.New().RenderTo(w, multusTemplate, runnable)
+	return err
+}
+
+// renderNetworksTo marshals the NetworkAttachmentDefinitions instead of templating them,
+// the configs are arbitrary JSON which must be quoted.
+func (runnable *MultusRunnable) renderNetworksTo(w io.Writer) error {
+	for _, attachment := range runnable.NetworkAttachments {
+		data, err := yaml.Marshal(map[string]interface{}{
+			"apiVersion": "k8s.cni.cncf.io/v1",
+			"kind":       "NetworkAttachmentDefinition",
+			"metadata": map[string]string{
+				"name":      attachment.Name,
+				"namespace": strutil.StringDefaultIfEmpty(metav1.NamespaceDefault, attachment.Namespace),
+			},
+			"spec": map[string]string{"config": attachment.Config},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "---\n%s", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const multusTemplate = `---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: network-attachment-definitions.k8s.cni.cncf.io
+spec:
+  group: k8s.cni.cncf.io
+  scope: Namespaced
+  names:
+    plural: network-attachment-definitions
+    singular: network-attachment-definition
+    kind: NetworkAttachmentDefinition
+    shortNames:
+    - net-attach-def
+  versions:
+    - name: v1
+      served: true
+      storage: true
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            apiVersion:
+              type: string
+            kind:
+              type: string
+            metadata:
+              type: object
+            spec:
+              type: object
+              properties:
+                config:
+                  type: string
+---
+kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: multus
+rules:
+  - apiGroups: ["k8s.cni.cncf.io"]
+    resources:
+      - '*'
+    verbs:
+      - '*'
+  - apiGroups:
+      - ""
+    resources:
+      - pods
+      - pods/status
+    verbs:
+      - get
+      - update
+  - apiGroups:
+      - ""
+      - events.k8s.io
+    resources:
+      - events
+    verbs:
+      - create
+      - patch
+      - update
+---
+kind: ClusterRoleBinding
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: multus
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: multus
+subjects:
+- kind: ServiceAccount
+  name: multus
+  namespace: kube-system
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: multus
+  namespace: kube-system
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: kube-multus-ds
+  namespace: kube-system
+  labels:
+    tier: node
+    app: multus
+    name: multus
+spec:
+  selector:
+    matchLabels:
+      name: multus
+  updateStrategy:
+    type: RollingUpdate
+  template:
+    metadata:
+      labels:
+        tier: node
+        app: multus
+        name: multus
+    spec:
+      hostNetwork: true
+      tolerations:
+      - operator: Exists
+        effect: NoSchedule
+      - operator: Exists
+        effect: NoExecute
+      serviceAccountName: multus
+      containers:
+      - name: kube-multus
+        image: {{with .CNI.LocalRegistry}}{{.}}{{else}}ghcr.io{{end}}/k8snetworkplumbingwg/multus-cni:{{.CNI.Version}}
+        command: ["/thin_entrypoint"]
+        args:
+        - "--multus-conf-file=auto"
+        - "--multus-autoconfig-dir=/host/etc/cni/net.d"
+        - "--cni-conf-dir=/host/etc/cni/net.d"
+        resources:
+          requests:
+            cpu: "100m"
+            memory: "50Mi"
+          limits:
+            cpu: "100m"
+            memory: "50Mi"
+        securityContext:
+          privileged: true
+        terminationMessagePolicy: FallbackToLogsOnError
+        volumeMounts:
+        - name: cni
+          mountPath: /host/etc/cni/net.d
+        - name: cnibin
+          mountPath: /host/opt/cni/bin
+      initContainers:
+      - name: install-multus-binary
+        image: {{with .CNI.LocalRegistry}}{{.}}{{else}}ghcr.io{{end}}/k8snetworkplumbingwg/multus-cni:{{.CNI.Version}}
+        command: ["/install_multus"]
+        args:
+        - "--type"
+        - "thin"
+        resources:
+          requests:
+            cpu: "10m"
+            memory: "15Mi"
+        securityContext:
+          privileged: true
+        terminationMessagePolicy: FallbackToLogsOnError
+        volumeMounts:
+        - name: cnibin
+          mountPath: /host/opt/cni/bin
+          mountPropagation: Bidirectional
+      terminationGracePeriodSeconds: 10
+      volumes:
+      - name: cni
+        hostPath:
+          path: /etc/cni/net.d
+      - name: cnibin
+        hostPath:
+          path: /opt/cni/bin
+`
@@ -0,0 +1,127 @@
+package cni
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const macvlanConfig = `{"cniVersion":"0.3.1","type":"macvlan","master":"eth1","ipam":{"type":"host-local","subnet":"192.168.1.0/24"},"name":"it's"}`
+
+func TestValidateNetworkAttachments(t *testing.T) {
+	tests := []struct {
+		name        string
+		multus      bool
+		attachments []v1.NetworkAttachment
+		wantErr     bool
+	}{
+		{name: "disabled"},
+		{name: "enabled", multus: true},
+		{name: "macvlan", multus: true, attachments: []v1.NetworkAttachment{{Name: "macvlan", Config: macvlanConfig}}},
+		{name: "plugins", multus: true, attachments: []v1.NetworkAttachment{{Name: "chain", Namespace: "telco", Config: `{"plugins":[{"type":"bridge"}]}`}}},
+		{name: "without multus", attachments: []v1.NetworkAttachment{{Name: "macvlan", Config: macvlanConfig}}, wantErr: true},
+		{name: "invalid name", multus: true, attachments: []v1.NetworkAttachment{{Name: "Macvlan", Config: macvlanConfig}}, wantErr: true},
+		{name: "invalid namespace", multus: true, attachments: []v1.NetworkAttachment{{Name: "macvlan", Namespace: "a.b", Config: macvlanConfig}}, wantErr: true},
+		{name: "not json", multus: true, attachments: []v1.NetworkAttachment{{Name: "macvlan", Config: "type: macvlan"}}, wantErr: true},
+		{name: "no type", multus: true, attachments: []v1.NetworkAttachment{{Name: "macvlan", Config: `{"cniVersion":"0.3.1"}`}}, wantErr: true},
+		{
+			name:   "duplicate",
+			multus: true,
+			attachments: []v1.NetworkAttachment{
+				{Name: "macvlan", Config: macvlanConfig},
+				{Name: "macvlan", Namespace: "default", Config: macvlanConfig},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateNetworkAttachments(&v1.CNI{EnableMultus: tt.multus, NetworkAttachments: tt.attachments}); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateNetworkAttachments() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMultusRunnable_Steps(t *testing.T) {
+	metadata := &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1"}}, Workers: component.NodeList{{ID: "node2"}}}
+	if NewMultus(metadata, &v1.CNI{Type: "calico"}) != nil {
+		t.Fatalf("NewMultus() should be nil when multus is not enabled")
+	}
+	multus := NewMultus(metadata, &v1.CNI{Type: "calico", Version: "v3.26.1", Offline: true, EnableMultus: true})
+	if multus.Type != "multus" || multus.Version != MultusVersion {
+		t.Fatalf("NewMultus() = %s %s", multus.Type, multus.Version)
+	}
+	master, all := []v1.StepNode{{ID: "node1"}}, []v1.StepNode{{ID: "node1"}, {ID: "node2"}}
+
+	steps, err := multus.InstallSteps(master)
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	if got := stepNames(steps); !reflect.DeepEqual(got, []string{"renderCniYaml", "applyMultus", "checkMultusReady"}) {
+		t.Errorf("InstallSteps() = %v", got)
+	}
+	multus.NetworkAttachments = []v1.NetworkAttachment{{Name: "macvlan", Config: macvlanConfig}}
+	if steps, err = multus.InstallSteps(master); err != nil || stepNames(steps)[len(steps)-1] != "applyMultusNetworks" {
+		t.Errorf("InstallSteps() with network attachments = %v, %v", stepNames(steps), err)
+	}
+	if steps, err = multus.LoadImage(all); err != nil || len(steps) != 1 {
+		t.Errorf("LoadImage() = %v, %v", stepNames(steps), err)
+	}
+
+	if steps, err = multus.UninstallSteps(all); err != nil {
+		t.Fatalf("UninstallSteps() error = %v", err)
+	}
+	if got := stepNames(steps); !reflect.DeepEqual(got, []string{"removeMultus", "clearMultusNode", "removeCniImage"}) {
+		t.Errorf("UninstallSteps() = %v", got)
+	}
+	if clear := stepByName(steps, "clearMultusNode"); !strings.Contains(strings.Join(clear.Commands[0].ShellCommand, " "), multusConfFile) {
+		t.Errorf("clearMultusNode commands = %v", clear.Commands)
+	}
+	if steps, err = multus.LeaveNodeSteps(all[1:]); err != nil || stepByName(steps, "removeMultus").Name != "" {
+		t.Errorf("LeaveNodeSteps() = %v, %v", stepNames(steps), err)
+	}
+}
+
+func TestMultusRunnable_renderNetworksTo(t *testing.T) {
+	multus := NewMultus(&component.ExtraMetadata{}, &v1.CNI{EnableMultus: true, NetworkAttachments: []v1.NetworkAttachment{
+		{Name: "macvlan", Config: macvlanConfig},
+		{Name: "chain", Namespace: "telco", Config: `{"plugins":[{"type":"bridge"}]}`},
+	}})
+	w := &bytes.Buffer{}
+	if err := multus.renderNetworksTo(w); err != nil {
+		t.Fatalf("renderNetworksTo() error = %v", err)
+	}
+	docs := strings.Split(strings.TrimPrefix(w.String(), "---\n"), "---\n")
+	if len(docs) != 2 {
+		t.Fatalf("renderNetworksTo() rendered %d documents:\n%s", len(docs), w.String())
+	}
+	nad := struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Spec struct {
+			Config string `json:"config"`
+		} `json:"spec"`
+	}{}
+	if err := yaml.Unmarshal([]byte(docs[0]), &nad); err != nil {
+		t.Fatal(err)
+	}
+	if nad.Metadata.Name != "macvlan" || nad.Metadata.Namespace != "default" || nad.Spec.Config != macvlanConfig {
+		t.Errorf("renderNetworksTo() = %+v", nad)
+	}
+
+	w.Reset()
+	if err := multus.renderMultusTo(w); err != nil {
+		t.Fatalf("renderMultusTo() error = %v", err)
+	}
+	if !strings.Contains(w.String(), "image: ghcr.io/k8snetworkplumbingwg/multus-cni:"+MultusVersion) {
+		t.Errorf("renderMultusTo() does not render the multus image:\n%s", w.String())
+	}
+}
@@ -0,0 +1,82 @@
+package cni
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// NamespaceCreatedLabel marks the cni namespaces kubeclipper created, only they are deleted on uninstall.
+	NamespaceCreatedLabel      = "kubeclipper.io/cni-namespace"
+	namespaceCreatedLabelValue = "created"
+
+	// podSecurityEnforceLabel the pod security admission label, the baseline and restricted
+	// standards reject the privileged and host network pods of the cni.
+	podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+)
+
+// PrepareNamespace fails before the release is installed when namespace can not host the privileged cni pods:
+// an existing namespace must not enforce the baseline or restricted pod security standard, a missing one
+// must be creatable. The missing namespace is created and labeled with NamespaceCreatedLabel.
+func PrepareNamespace(stepName, namespace string, nodes []v1.StepNode) v1.Step {
+	script := fmt.Sprintf(`ns=%[1]s
+if kubectl get namespace "$ns" >/dev/null 2>&1; then
+  enforce=$(kubectl get namespace "$ns" -o jsonpath='{.metadata.labels.%[2]s}')
+  if [ "$enforce" = baseline ] || [ "$enforce" = restricted ]; then
+    echo "namespace $ns enforces the $enforce pod security standard which rejects the privileged cni pods, label it %[3]s=privileged or install the cni into another namespace" >&2
+    exit 1
+  fi
+  exit 0
+fi
+if ! kubectl auth can-i create namespaces >/dev/null 2>&1; then
+  echo "namespace $ns does not exist and the admin kubeconfig is not allowed to create namespaces, create it or grant the create verb on namespaces" >&2
+  exit 1
+fi
+kubectl create namespace "$ns" && kubectl label namespace "$ns" %[4]s=%[5]s`,
+		namespace, escapeJSONPathKey(podSecurityEnforceLabel), podSecurityEnforceLabel, NamespaceCreatedLabel, namespaceCreatedLabelValue)
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       stepName,
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c", script},
+			},
+		},
+	}
+}
+
+// RemoveCreatedNamespace deletes namespace if PrepareNamespace created it, the namespaces created by users are kept.
+func RemoveCreatedNamespace(stepName, namespace string, nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       stepName,
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf(`if [ "$(kubectl get namespace %[1]s -o jsonpath='{.metadata.labels.%[2]s}' 2>/dev/null)" = %[3]s ]; then kubectl delete namespace %[1]s --wait=false; fi`,
+						namespace, escapeJSONPathKey(NamespaceCreatedLabel), namespaceCreatedLabelValue)},
+			},
+		},
+	}
+}
+
+// escapeJSONPathKey escapes the dots of a label key for the kubectl jsonpath.
+func escapeJSONPathKey(key string) string {
+	return strings.ReplaceAll(key, ".", `\.`)
+}
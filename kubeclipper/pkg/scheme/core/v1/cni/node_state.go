@@ -0,0 +1,93 @@
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/logger"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/common"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+// ExcludedNode a node the steps of the cni skip because it is unavailable, see component.Node.Unavailable.
+type ExcludedNode struct {
+	ID       string `json:"id"`
+	Hostname string `json:"hostname,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// nodeStates the reasons the nodes of the cluster are unavailable, keyed by node ID.
+type nodeStates map[string]string
+
+func newNodeStates(metadata *component.ExtraMetadata) nodeStates {
+	states := make(nodeStates)
+	for _, node := range metadata.GetAllNodes() {
+		if reason := node.Unavailable(); reason != "" {
+			states[node.ID] = reason
+		}
+	}
+	return states
+}
+
+// available returns the available nodes of nodes, the per-node steps skip the others rather than hang on them
+// until they time out.
+func (s nodeStates) available(nodes []v1.StepNode) []v1.StepNode {
+	if len(s) == 0 {
+		return nodes
+	}
+	available := make([]v1.StepNode, 0, len(nodes))
+	for _, node := range nodes {
+		if reason, ok := s[node.ID]; ok {
+			logger.Warnf("the cni steps skip node %s (%s): %s", node.ID, node.Hostname, reason)
+			continue
+		}
+		available = append(available, node)
+	}
+	return available
+}
+
+// require fails when one of nodes is unavailable, the steps can not do without them, e.g. the master running helm
+// against the kube-apiserver.
+func (s nodeStates) require(nodes []v1.StepNode) error {
+	var unavailable []string
+	for _, node := range nodes {
+		if reason, ok := s[node.ID]; ok {
+			unavailable = append(unavailable, fmt.Sprintf("%s (%s): %s", node.ID, node.Hostname, reason))
+		}
+	}
+	if len(unavailable) > 0 {
+		return fmt.Errorf("the cni steps require the unavailable nodes %s", strings.Join(unavailable, ", "))
+	}
+	return nil
+}
+
+// ExcludedNodes the nodes of metadata the steps of the cni skip.
+func ExcludedNodes(metadata *component.ExtraMetadata) []ExcludedNode {
+	var excluded []ExcludedNode
+	for _, node := range metadata.GetAllNodes() {
+		if reason := node.Unavailable(); reason != "" {
+			excluded = append(excluded, ExcludedNode{ID: node.ID, Hostname: node.Hostname, Reason: reason})
+		}
+	}
+	return excluded
+}
+
+// AnnotateExcludedNodes records the nodes the cni steps of op skip on op, so that the users see why the steps
+// did not run on them.
+func AnnotateExcludedNodes(op *v1.Operation, metadata *component.ExtraMetadata) error {
+	excluded := ExcludedNodes(metadata)
+	if len(excluded) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(excluded)
+	if err != nil {
+		return err
+	}
+	if op.Annotations == nil {
+		op.Annotations = make(map[string]string)
+	}
+	op.Annotations[common.AnnotationExcludedNodes] = string(data)
+	return nil
+}
@@ -0,0 +1,147 @@
+package cni
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/component/utils"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/common"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func notReadyMetadata() *component.ExtraMetadata {
+	notReady := []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionUnknown, Reason: "NodeStatusUnknown"}}
+	return &component.ExtraMetadata{
+		CRI:     v1.CRIContainerd,
+		Masters: component.NodeList{{ID: "node1", Hostname: "master1"}},
+		Workers: component.NodeList{
+			{ID: "node2", Hostname: "worker1", Conditions: notReady},
+			{ID: "node3", Hostname: "worker2", Disable: true},
+			{ID: "node4", Hostname: "worker3"},
+		},
+	}
+}
+
+func TestCiliumRunnable_unavailableNodes(t *testing.T) {
+	metadata := notReadyMetadata()
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Type: "cilium", Version: "1.14.4", Offline: true, Cilium: &v1.Cilium{}},
+		&v1.Networking{Pods: v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}}})
+	steps, err := stepper.LoadImage(utils.UnwrapNodeList(metadata.GetAllNodes()))
+	if err != nil {
+		t.Fatalf("LoadImage() error = %v", err)
+	}
+	load := stepByName(steps, "cniImageLoader")
+	var ids []string
+	for _, node := range load.Nodes {
+		ids = append(ids, node.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"node1", "node4"}) {
+		t.Errorf("LoadImage() nodes = %v, want the available nodes", ids)
+	}
+
+	if _, err = stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "v1.27.4"); err != nil {
+		t.Errorf("InstallSteps() on an available master error = %v", err)
+	}
+	metadata.Masters[0].Conditions = metadata.Workers[0].Conditions
+	stepper = (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Type: "cilium", Version: "1.14.4", Cilium: &v1.Cilium{}},
+		&v1.Networking{Pods: v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}}})
+	_, err = stepper.InstallSteps([]v1.StepNode{{ID: "node1"}}, "v1.27.4")
+	if err == nil || !strings.Contains(err.Error(), "node1 (master1): the node is not ready") {
+		t.Errorf("InstallSteps() without an available master error = %v", err)
+	}
+}
+
+func TestCiliumRunnable_unavailableNodeChecks(t *testing.T) {
+	metadata := notReadyMetadata()
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Type: "cilium", Version: "1.14.4", Cilium: &v1.Cilium{
+		AutoDetectMTU:          true,
+		EnableBandwidthManager: true,
+		EnableBBR:              true,
+		Encryption:             &v1.CiliumEncryption{Type: CiliumEncryptionWireguard},
+	}}, &v1.Networking{Pods: v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}}})
+	if err := stepper.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1", Hostname: "master1"}}, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	var checked []string
+	for _, step := range steps {
+		if strings.HasPrefix(step.Name, "detectCiliumMTU-") || strings.HasPrefix(step.Name, "preflightCilium-") {
+			checked = append(checked, step.Name)
+		}
+	}
+	want := []string{"preflightCilium-master1", "preflightCilium-worker3", "detectCiliumMTU-master1", "detectCiliumMTU-worker3"}
+	if !reflect.DeepEqual(checked, want) {
+		t.Errorf("InstallSteps() checks %v, want the available nodes %v", checked, want)
+	}
+	for _, name := range []string{"checkCiliumWireguard", "checkCiliumBBRKernel"} {
+		var ids []string
+		for _, node := range stepByName(steps, name).Nodes {
+			ids = append(ids, node.ID)
+		}
+		if !reflect.DeepEqual(ids, []string{"node1", "node4"}) {
+			t.Errorf("%s nodes = %v, want the available nodes", name, ids)
+		}
+	}
+}
+
+func TestCiliumRunnable_migrationUnavailableNodes(t *testing.T) {
+	metadata := notReadyMetadata()
+	networking := &v1.Networking{Pods: v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}}}
+	calico := (&CalicoRunnable{}).InitStep(metadata, &v1.CNI{Type: "calico", Version: "v3.26.1",
+		Calico: &v1.Calico{Mode: CalicoNetworkIPIPAll}}, networking)
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Type: "cilium", Version: "1.14.4"}, networking)
+	steps, err := stepper.MigrationSteps(calico, utils.UnwrapNodeList(metadata.GetAllNodes()))
+	if err != nil {
+		t.Fatalf("MigrationSteps() error = %v", err)
+	}
+	var migrated []string
+	for _, step := range steps {
+		if strings.HasPrefix(step.Name, "migrateNode-") {
+			migrated = append(migrated, step.Nodes[0].ID+"/"+strings.TrimPrefix(step.Name, "migrateNode-"))
+		}
+	}
+	if want := []string{"node1/master1", "node1/worker3"}; !reflect.DeepEqual(migrated, want) {
+		t.Errorf("MigrationSteps() migrates %v, want the available nodes %v", migrated, want)
+	}
+	for _, name := range []string{"removeTunl", "removeCali"} {
+		var ids []string
+		for _, node := range stepByName(steps, name).Nodes {
+			ids = append(ids, node.ID)
+		}
+		if !reflect.DeepEqual(ids, []string{"node1", "node4"}) {
+			t.Errorf("%s nodes = %v, want the available nodes", name, ids)
+		}
+	}
+}
+
+func TestAnnotateExcludedNodes(t *testing.T) {
+	op := &v1.Operation{}
+	if err := AnnotateExcludedNodes(op, notReadyMetadata()); err != nil {
+		t.Fatal(err)
+	}
+	var excluded []ExcludedNode
+	if err := json.Unmarshal([]byte(op.Annotations[common.AnnotationExcludedNodes]), &excluded); err != nil {
+		t.Fatalf("unmarshal the %s annotation: %v", common.AnnotationExcludedNodes, err)
+	}
+	want := []ExcludedNode{
+		{ID: "node2", Hostname: "worker1", Reason: "the node is not ready, the Ready condition is Unknown: NodeStatusUnknown"},
+		{ID: "node3", Hostname: "worker2", Reason: "the node is disabled"},
+	}
+	if !reflect.DeepEqual(excluded, want) {
+		t.Errorf("excluded nodes = %+v, want %+v", excluded, want)
+	}
+
+	op = &v1.Operation{}
+	if err := AnnotateExcludedNodes(op, &component.ExtraMetadata{Masters: component.NodeList{{ID: "node1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := op.Annotations[common.AnnotationExcludedNodes]; ok {
+		t.Errorf("AnnotateExcludedNodes() annotated an operation without unavailable nodes")
+	}
+}
@@ -0,0 +1,142 @@
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	OperationGet       = "get"
+	OperationRestart   = "restart"
+	OperationStatus    = "status"
+	OperationLogs      = "logs"
+	OperationVersion   = "version"
+	OperationEndpoints = "endpoints"
+	OperationSysdump   = "sysdump"
+	// OperationCollectLogs collects the recent logs of the cni pods into a bundle.
+	OperationCollectLogs = "collect-logs"
+
+	OperationOutputText = "text"
+	OperationOutputJSON = "json"
+	// OperationOutputBundle the operation collects a support bundle the server downloads, see SupportBundleCollector.
+	OperationOutputBundle = "bundle"
+
+	// operationLogLines the lines of the agent logs the logs operation shows.
+	operationLogLines = 200
+	// operationMaxLogRequests the agents the logs operation reads concurrently, kubectl reads 5 by default.
+	operationMaxLogRequests = 100
+	operationTimeout        = time.Minute
+)
+
+// Operation a command operating the installed cni from a master node.
+type Operation struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Command     string `json:"command"`
+	// Destructive operations disrupt the cni, e.g. restart the agents, they only run when confirmed.
+	Destructive bool `json:"destructive"`
+	// Output the format of the command output, text or json, or bundle when the operation collects a support bundle.
+	Output string `json:"output"`
+	// Fallback the collector of the bundle operations when Command fails.
+	Fallback string `json:"fallback,omitempty"`
+	// Files the files of the node the bundle operations add to the bundle.
+	Files []string `json:"files,omitempty"`
+	// sinceCommand the command of the log operations collecting the logs of the last since, see OperationOptions Since.
+	sinceCommand func(since time.Duration) string
+}
+
+// OperationOptions the options of OperationStep.
+type OperationOptions struct {
+	// Confirmed the destructive operations only run when confirmed.
+	Confirmed bool
+	// MaxBundleSize caps the bundle of the bundle operations, DefaultSupportBundleMaxSize when it is 0.
+	MaxBundleSize int64
+	// Since the log operations collect the logs of the last Since, the default of the operation when it is 0.
+	Since time.Duration
+}
+
+// operationCommands keys the commands of ops by name, the format of CmdList.
+func operationCommands(ops []Operation) map[string]string {
+	cmdList := make(map[string]string, len(ops))
+	for _, op := range ops {
+		cmdList[op.Name] = op.Command
+	}
+	return cmdList
+}
+
+// OperationStep runs the operation name of stepper in namespace on node, the command output is the step output.
+// The bundle operations write SupportBundleFile into the operation dir of node instead.
+func OperationStep(stepper Stepper, namespace, name string, opts OperationOptions, node v1.StepNode) (v1.Step, error) {
+	for _, op := range stepper.Operations(namespace) {
+		if op.Name != name {
+			continue
+		}
+		if opts.Since != 0 {
+			if op.sinceCommand == nil {
+				return v1.Step{}, fmt.Errorf("the cni operation %s does not collect logs, it does not take a log window", op.Name)
+			}
+			if opts.Since < 0 {
+				return v1.Step{}, fmt.Errorf("invalid log window %s of the cni operation %s", opts.Since, op.Name)
+			}
+			op.Command = op.sinceCommand(opts.Since)
+		}
+		if op.Output == OperationOutputBundle {
+			return bundleStep(op, opts.MaxBundleSize, node)
+		}
+		if op.Destructive && !opts.Confirmed {
+			return v1.Step{}, fmt.Errorf("the cni operation %s is destructive: %s, it must be confirmed", op.Name, op.Description)
+		}
+		return v1.Step{
+			ID:         newStepID(),
+			Name:       "cniOperation-" + op.Name,
+			Timeout:    metav1.Duration{Duration: operationTimeout},
+			ErrIgnore:  false,
+			RetryTimes: 0,
+			Nodes:      []v1.StepNode{node},
+			Action:     v1.ActionInstall,
+			Commands: []v1.Command{
+				{
+					Type:         v1.CommandShell,
+					ShellCommand: []string{"/bin/bash", "-c", op.Command},
+				},
+			},
+		}, nil
+	}
+	return v1.Step{}, fmt.Errorf("unknown cni operation %q", name)
+}
+
+func bundleStep(op Operation, maxSize int64, node v1.StepNode) (v1.Step, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultSupportBundleMaxSize
+	}
+	custom, err := json.Marshal(&SupportBundleCollector{
+		Command:  op.Command,
+		Fallback: op.Fallback,
+		Files:    op.Files,
+		MaxSize:  maxSize,
+	})
+	if err != nil {
+		return v1.Step{}, err
+	}
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "cniOperation-" + op.Name,
+		Timeout:    metav1.Duration{Duration: supportBundleTimeout},
+		ErrIgnore:  false,
+		RetryTimes: 0,
+		Nodes:      []v1.StepNode{node},
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:          v1.CommandCustom,
+				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+supportBundle, version, component.TypeStep),
+				CustomCommand: custom,
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,145 @@
+package cni
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func TestStepper_Operations(t *testing.T) {
+	steppers := map[string]Stepper{
+		"cilium": &CiliumRunnable{},
+		"calico": &CalicoRunnable{},
+	}
+	for name, stepper := range steppers {
+		t.Run(name, func(t *testing.T) {
+			ops := stepper.Operations("cni-system")
+			byName := make(map[string]Operation, len(ops))
+			for _, op := range ops {
+				if _, ok := byName[op.Name]; ok {
+					t.Errorf("Operations() duplicates %s", op.Name)
+				}
+				if op.Description == "" || op.Output == "" || !strings.Contains(op.Command+op.Fallback, "kubectl") {
+					t.Errorf("Operations() %s = %+v", op.Name, op)
+				}
+				byName[op.Name] = op
+			}
+			// the operations shared by every cni, the UI offers them whatever the cni is
+			for _, shared := range []string{OperationGet, OperationStatus, OperationLogs, OperationVersion, OperationRestart, OperationHealth} {
+				if _, ok := byName[shared]; !ok {
+					t.Errorf("Operations() is missing %s", shared)
+				}
+			}
+			if !byName[OperationRestart].Destructive || byName[OperationStatus].Destructive {
+				t.Errorf("Operations() only restart should be destructive")
+			}
+			if !strings.Contains(byName[OperationLogs].Command, "--tail=200") {
+				t.Errorf("Operations() logs = %s", byName[OperationLogs].Command)
+			}
+			if cmdList := stepper.CmdList("cni-system"); cmdList[OperationRestart] != byName[OperationRestart].Command || len(cmdList) != len(ops) {
+				t.Errorf("CmdList() = %v, want the commands of Operations()", cmdList)
+			}
+		})
+	}
+}
+
+func TestParseWorkloadHealth(t *testing.T) {
+	ops := (&CiliumRunnable{}).CmdList("kube-system")
+	for _, want := range []string{"kubectl get ds cilium -n kube-system", "kubectl get deploy cilium-operator -n kube-system", "helm status cilium -n kube-system"} {
+		if !strings.Contains(ops[OperationHealth], want) {
+			t.Errorf("health command does not contain %q: %s", want, ops[OperationHealth])
+		}
+	}
+
+	tests := []struct {
+		name    string
+		output  string
+		healthy bool
+		failed  map[string]string
+	}{
+		{
+			name:    "ready",
+			output:  "daemonset/cilium 3 3\ndeployment/cilium-operator 2 2\nrelease/cilium deployed\n",
+			healthy: true,
+		},
+		{
+			name:   "not ready",
+			output: "daemonset/cilium 3 2\ndeployment/cilium-operator 2\nrelease/cilium failed\n",
+			failed: map[string]string{
+				"daemonset/cilium":           "2 of 3 pods ready",
+				"deployment/cilium-operator": "0 of 2 pods ready",
+				"release/cilium":             "release cilium failed",
+			},
+		},
+		{
+			name:   "missing",
+			output: "daemonset/cilium - -\nrelease/cilium \n",
+			failed: map[string]string{
+				"daemonset/cilium": "daemonset cilium not found",
+				"release/cilium":   "release cilium not found",
+			},
+		},
+		{
+			name:   "unexpected",
+			output: "",
+			failed: map[string]string{"output": `unexpected health output ""`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ParseWorkloadHealth(tt.output)
+			if result.Healthy != tt.healthy {
+				t.Errorf("ParseWorkloadHealth() healthy = %v, want %v: %+v", result.Healthy, tt.healthy, result)
+			}
+			failed := make(map[string]string)
+			for _, check := range result.Checks {
+				if !check.Healthy {
+					failed[check.Name] = check.Message
+				}
+			}
+			if len(failed) != len(tt.failed) {
+				t.Errorf("ParseWorkloadHealth() failed checks = %v, want %v", failed, tt.failed)
+			}
+			for name, msg := range tt.failed {
+				if failed[name] != msg {
+					t.Errorf("ParseWorkloadHealth() check %s = %q, want %q", name, failed[name], msg)
+				}
+			}
+		})
+	}
+}
+
+func TestOperationStep(t *testing.T) {
+	stepper := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{}, &v1.CNI{}, &v1.Networking{})
+	node := v1.StepNode{ID: "master1"}
+	tests := []struct {
+		name      string
+		operation string
+		confirmed bool
+		wantErr   string
+	}{
+		{name: "status", operation: OperationStatus},
+		{name: "unknown", operation: "bugtool", wantErr: "unknown cni operation"},
+		{name: "unconfirmed restart", operation: OperationRestart, wantErr: "must be confirmed"},
+		{name: "confirmed restart", operation: OperationRestart, confirmed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, err := OperationStep(stepper, "kube-system", tt.operation, OperationOptions{Confirmed: tt.confirmed}, node)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("OperationStep() error = %v, want %q", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if step.Name != "cniOperation-"+tt.operation || len(step.Nodes) != 1 || step.Nodes[0].ID != node.ID {
+				t.Errorf("OperationStep() = %+v", step)
+			}
+			if want := stepper.CmdList("kube-system")[tt.operation]; step.Commands[0].ShellCommand[2] != want {
+				t.Errorf("OperationStep() command = %s, want %s", step.Commands[0].ShellCommand[2], want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package cni
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni/steptest"
+)
+
+// renderPlan renders the steps of the cni from the image load to the uninstall with sequential step IDs.
+func renderPlan(t *testing.T, cni *v1.CNI, networking *v1.Networking) steptest.Plan {
+	t.Helper()
+	var n int
+	prev := newStepID
+	newStepID = func() string {
+		n++
+		return fmt.Sprintf("id-%d", n)
+	}
+	t.Cleanup(func() { newStepID = prev })
+	factory, err := Load(cni.Type)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := &component.ExtraMetadata{
+		ClusterName: "c1",
+		CRI:         "containerd",
+		Masters:     component.NodeList{{ID: "node1", Hostname: "master1"}},
+		Workers:     component.NodeList{{ID: "node2", Hostname: "worker1"}},
+	}
+	stepper := factory.Create().InitStep(metadata, cni, networking)
+	if err = stepper.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	all := []v1.StepNode{{ID: "node1", Hostname: "master1"}, {ID: "node2", Hostname: "worker1"}}
+	master := all[:1]
+	var plan steptest.Plan
+	if plan.LoadImage, err = stepper.LoadImage(all); err != nil {
+		t.Fatalf("LoadImage() error = %v", err)
+	}
+	if plan.Install, err = stepper.InstallSteps(master, "v1.27.4"); err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	if plan.Uninstall, err = stepper.UninstallSteps(all); err != nil {
+		t.Fatalf("UninstallSteps() error = %v", err)
+	}
+	return plan
+}
+
+func TestCiliumRunnable_plan(t *testing.T) {
+	networking := &v1.Networking{
+		DNSDomain: "cluster.local",
+		Services:  v1.NetworkRanges{CIDRBlocks: []string{"10.96.0.0/12"}},
+		Pods:      v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}},
+	}
+	tests := []struct {
+		name    string
+		cni     v1.CNI
+		install []string
+	}{
+		{
+			name:    "plan-cilium-offline",
+			cni:     v1.CNI{Type: "cilium", Version: "1.14.4", Offline: true, Cilium: &v1.Cilium{}},
+			install: []string{"/tmp/.cni/c1/cilium/cilium.yaml"},
+		},
+		{
+			name:    "plan-cilium-offline-registry",
+			cni:     v1.CNI{Type: "cilium", Version: "1.14.4", Offline: true, LocalRegistry: "registry.local:5000", Cilium: &v1.Cilium{}},
+			install: []string{"/tmp/.cni/c1/cilium/cilium.yaml"},
+		},
+		{
+			name:    "plan-cilium-online",
+			cni:     v1.CNI{Type: "cilium", Version: "1.14.4", Cilium: &v1.Cilium{}},
+			install: []string{"/tmp/.cni/c1/cilium/cilium.yaml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := renderPlan(t, &tt.cni, networking)
+			steptest.CommandContains(t, steptest.StepNamed(t, plan.Install, "installCiliumRelease"), tt.install...)
+			steptest.StepNamed(t, plan.Uninstall, "uninstallCiliumRelease")
+			steptest.AssertGolden(t, filepath.Join("testdata", tt.name+".golden"), plan, *update)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package cni
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Registration describes a cni registered by its factory, see Register.
+type Registration struct {
+	// Type the Type() of the cni factory.
+	Type string `json:"type"`
+	// TemplateKey the key the cni template is registered with, empty when no template is registered.
+	TemplateKey string `json:"templateKey,omitempty"`
+	// StepKey the key the agent step of the cni is registered with, empty when no step is registered.
+	StepKey string `json:"stepKey,omitempty"`
+	// RegistryVersions the versions of the component registry the template and the step are registered with.
+	RegistryVersions []string `json:"registryVersions,omitempty"`
+	// DefaultNamespace the namespace the cni is deployed in when the cluster does not set one.
+	DefaultNamespace string `json:"defaultNamespace,omitempty"`
+	// SupportedVersions the versions, or minor versions, the cni supports, empty when it does not restrict them.
+	SupportedVersions []string `json:"supportedVersions,omitempty"`
+	// Profiles the values profiles the cni renders, empty when it has none.
+	Profiles []string `json:"profiles,omitempty"`
+}
+
+// namespaceDefaulter is implemented by the cnis deployed in a default namespace.
+type namespaceDefaulter interface {
+	DefaultNamespace() string
+}
+
+// versionLister is implemented by the cnis restricting the versions they install.
+type versionLister interface {
+	SupportedVersions() []string
+}
+
+// profileLister is implemented by the cnis with values profiles.
+type profileLister interface {
+	Profiles() []string
+}
+
+// configDefaulter is implemented by the cnis with configuration defaults, it sets the defaults of the version of
+// cni on its type config.
+type configDefaulter interface {
+	setDefaults(cni *v1.CNI)
+}
+
+// Defaults the cni of cniType and version with the configuration defaults the servers apply when the cluster
+// leaves a field unset, the clients pre-fill their forms with them.
+func Defaults(cniType, version string) (*v1.CNI, error) {
+	factory, err := Load(cniType)
+	if err != nil {
+		return nil, err
+	}
+	c := &v1.CNI{Type: cniType, Version: version}
+	if d, ok := factory.Create().(configDefaulter); ok {
+		d.setDefaults(c)
+	}
+	return c, nil
+}
+
+// Registrations walks the cni factories and the component registry, the registrations are sorted by type.
+func Registrations() []Registration {
+	templates := component.TemplateKeys()
+	steps := component.AgentStepKeys()
+	registrations := make([]Registration, 0, len(cniFactories))
+	for typ, factory := range cniFactories {
+		r := Registration{Type: factory.Type()}
+		name := cniInfo + "-" + typ
+		versions := sets.New[string]()
+		for _, key := range registeredKeys(templates, name, component.TypeTemplate) {
+			r.TemplateKey = key
+			versions.Insert(strings.Split(key, "/")[1])
+		}
+		for _, key := range registeredKeys(steps, name, component.TypeStep) {
+			r.StepKey = key
+			versions.Insert(strings.Split(key, "/")[1])
+		}
+		r.RegistryVersions = sets.List(versions)
+		stepper := factory.Create()
+		if d, ok := stepper.(namespaceDefaulter); ok {
+			r.DefaultNamespace = d.DefaultNamespace()
+		}
+		if l, ok := stepper.(versionLister); ok {
+			r.SupportedVersions = l.SupportedVersions()
+		}
+		if l, ok := stepper.(profileLister); ok {
+			r.Profiles = l.Profiles()
+		}
+		registrations = append(registrations, r)
+	}
+	sort.Slice(registrations, func(i, j int) bool {
+		return registrations[i].Type < registrations[j].Type
+	})
+	return registrations
+}
+
+// registeredKeys the name/version/kind keys of keys, the keys are sorted so the latest registry version is the last.
+func registeredKeys(keys []string, name, kind string) []string {
+	var matched []string
+	for _, key := range keys {
+		parts := strings.Split(key, "/")
+		if len(parts) == 3 && parts[0] == name && parts[2] == kind {
+			matched = append(matched, key)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,285 @@
+package cni
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/component/utils"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cri"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	registryConfigurer = "registry-configure"
+	registryPullSecret = "registry-pull-secret"
+
+	// CiliumRegistrySecretName the image pull secret of the cilium pods when LocalRegistry requires authentication.
+	CiliumRegistrySecretName = "cilium-registry-credentials"
+
+	dockerRegistryCertsDir = "/etc/docker/certs.d"
+)
+
+func init() {
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-"+registryConfigurer, version, component.TypeStep), &RegistryConfigurer{}); err != nil {
+		panic(err)
+	}
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-"+registryPullSecret, version, component.TypeStep), &RegistryPullSecret{}); err != nil {
+		panic(err)
+	}
+}
+
+// registryPasswordEnv the sensitive environment variable passing the password of LocalRegistry to the steps,
+// the password is not part of the custom commands so that it is redacted from the persisted operations.
+const registryPasswordEnv = "KC_REGISTRY_PASSWORD"
+
+// RegistryAccess the credentials and the TLS settings the steps reach LocalRegistry with.
+type RegistryAccess struct {
+	Username              string `json:"username,omitempty"`
+	Password              string `json:"-"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify,omitempty"`
+	CA                    string `json:"ca,omitempty"`
+}
+
+// env the environment of the commands passing the password to the steps.
+func (a *RegistryAccess) env() []v1.EnvVar {
+	if a.Password == "" {
+		return nil
+	}
+	return []v1.EnvVar{{Name: registryPasswordEnv, Value: a.Password, Sensitive: true}}
+}
+
+// loadPassword reads the password the command was run with.
+func (a *RegistryAccess) loadPassword(ctx context.Context) {
+	if password := component.GetCommandEnv(ctx, registryPasswordEnv); password != "" {
+		a.Password = password
+	}
+}
+
+func (a *RegistryAccess) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: a.InsecureSkipTLSVerify}
+	if a.CA == "" {
+		return config, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM([]byte(a.CA)) {
+		return nil, fmt.Errorf("the registry CA bundle has no PEM certificates")
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
+// RegistryConfigurer configures the container runtime of the node to trust Registry: containerd gets a hosts.toml
+// of the registry, docker the registry CA under its certs.d and the registry in its insecure registries.
+type RegistryConfigurer struct {
+	Registry              string `json:"registry"`
+	CriType               string `json:"criType"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify,omitempty"`
+	CA                    string `json:"ca,omitempty"`
+}
+
+func (c *RegistryConfigurer) NewInstance() component.ObjectMeta {
+	return &RegistryConfigurer{}
+}
+
+func (c *RegistryConfigurer) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	if opts.DryRun {
+		return nil, nil
+	}
+	host, _, _ := strings.Cut(c.Registry, "/")
+	switch c.CriType {
+	case v1.CRIContainerd:
+		registry := &cri.ContainerdRegistry{
+			Server: host,
+			Hosts: []cri.ContainerdHost{{
+				Scheme:       "https",
+				Host:         host,
+				Capabilities: []string{cri.CapabilityPull, cri.CapabilityResolve, cri.CapabilityPush},
+				SkipVerify:   c.InsecureSkipTLSVerify,
+				CA:           []byte(c.CA),
+			}},
+		}
+		return nil, registry.RenderConfigs(cri.ContainerdDefaultRegistryConfigDir)
+	case v1.CRIDocker:
+		if c.CA != "" {
+			dir := filepath.Join(dockerRegistryCertsDir, host)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(filepath.Join(dir, "ca.crt"), []byte(c.CA), 0644); err != nil {
+				return nil, err
+			}
+		}
+		if c.InsecureSkipTLSVerify {
+			return nil, utils.AddOrRemoveInsecureRegistryToCRI(ctx, c.CriType, host, true, opts.DryRun)
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported cri type %q", c.CriType)
+	}
+}
+
+// Uninstall keeps the registry configuration, the other workloads of the cluster may pull from the registry.
+func (c *RegistryConfigurer) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	return nil, nil
+}
+
+// RegistryPullSecret applies the docker config secret Name of Registry in Namespace, the secret is piped
+// to kubectl so that the password is neither logged nor on the command line.
+type RegistryPullSecret struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Registry  string `json:"registry"`
+	Username  string `json:"username"`
+	// Password the password passed by the registryPasswordEnv environment variable of the command.
+	Password string `json:"-"`
+}
+
+func (s *RegistryPullSecret) NewInstance() component.ObjectMeta {
+	return &RegistryPullSecret{}
+}
+
+func (s *RegistryPullSecret) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	if opts.DryRun {
+		return nil, nil
+	}
+	if password := component.GetCommandEnv(ctx, registryPasswordEnv); password != "" {
+		s.Password = password
+	}
+	secret, err := s.manifest()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, "kubectl", "apply", "-f", "-")
+	cmd.Stdin = strings.NewReader(string(secret))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("apply the image pull secret %s/%s: %w: %s", s.Namespace, s.Name, err, strings.TrimSpace(string(out)))
+	}
+	return nil, nil
+}
+
+func (s *RegistryPullSecret) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	return nil, nil
+}
+
+// manifest the kubernetes.io/dockerconfigjson secret of the credentials.
+func (s *RegistryPullSecret) manifest() ([]byte, error) {
+	host, _, _ := strings.Cut(s.Registry, "/")
+	auth := map[string]interface{}{
+		"auths": map[string]interface{}{
+			host: map[string]string{
+				"username": s.Username,
+				"password": s.Password,
+				"auth":     base64.StdEncoding.EncodeToString([]byte(s.Username + ":" + s.Password)),
+			},
+		},
+	}
+	config, err := json.Marshal(auth)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "kubernetes.io/dockerconfigjson",
+		"metadata":   map[string]string{"name": s.Name, "namespace": s.Namespace},
+		"data":       map[string][]byte{".dockerconfigjson": config},
+	})
+}
+
+// configureRegistry configures the container runtimes of nodes to trust LocalRegistry, nil when the registry
+// is trusted already.
+func (runnable *CiliumRunnable) configureRegistry(nodes []v1.StepNode) (*v1.Step, error) {
+	access := runnable.registryAccess
+	if runnable.LocalRegistry == "" || (!access.InsecureSkipTLSVerify && access.CA == "") {
+		return nil, nil
+	}
+	custom, err := json.Marshal(&RegistryConfigurer{
+		Registry:              runnable.LocalRegistry,
+		CriType:               runnable.CriType,
+		InsecureSkipTLSVerify: access.InsecureSkipTLSVerify,
+		CA:                    access.CA,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &v1.Step{
+		ID:         newStepID(),
+		Name:       "configureCiliumRegistry",
+		Timeout:    metav1.Duration{Duration: time.Minute},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:          v1.CommandCustom,
+				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+registryConfigurer, version, component.TypeStep),
+				CustomCommand: custom,
+			},
+		},
+	}, nil
+}
+
+// createPullSecret creates the image pull secret of the cilium pods, the values template references it.
+func (runnable *CiliumRunnable) createPullSecret(nodes []v1.StepNode) (v1.Step, error) {
+	custom, err := json.Marshal(&RegistryPullSecret{
+		Name:      CiliumRegistrySecretName,
+		Namespace: runnable.Namespace,
+		Registry:  runnable.LocalRegistry,
+		Username:  runnable.registryAccess.Username,
+	})
+	if err != nil {
+		return v1.Step{}, err
+	}
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "createCiliumPullSecret",
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:          v1.CommandCustom,
+				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+registryPullSecret, version, component.TypeStep),
+				CustomCommand: custom,
+				Env:           runnable.registryAccess.env(),
+			},
+		},
+	}, nil
+}
+
+func (runnable *CiliumRunnable) removePullSecret(nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "removeCiliumPullSecret",
+		Timeout:    metav1.Duration{Duration: 30 * time.Second},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: []string{"kubectl", "delete", "secret", CiliumRegistrySecretName, "-n", runnable.Namespace, "--ignore-not-found"},
+			},
+		},
+	}
+}
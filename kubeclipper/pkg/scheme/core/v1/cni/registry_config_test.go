@@ -0,0 +1,138 @@
+package cni
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func TestRegistryAccess_tls(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "https://")
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	tests := []struct {
+		name    string
+		access  RegistryAccess
+		wantErr bool
+	}{
+		{name: "untrusted", wantErr: true},
+		{name: "ca", access: RegistryAccess{CA: ca}},
+		{name: "insecure", access: RegistryAccess{InsecureSkipTLSVerify: true}},
+		{name: "invalid ca", access: RegistryAccess{CA: "not a certificate"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := &RegistryImageVerifier{Registry: registry, Images: []string{registry + "/cilium/cilium:v1.14.4"}, RegistryAccess: tt.access}
+			if _, err := verifier.Install(context.TODO(), component.Options{}); (err != nil) != tt.wantErr {
+				t.Errorf("Install() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegistryPullSecret_manifest(t *testing.T) {
+	data, err := (&RegistryPullSecret{
+		Name:      CiliumRegistrySecretName,
+		Namespace: "kube-system",
+		Registry:  "registry.local:5000/mirror",
+		Username:  "admin",
+		Password:  "secret",
+	}).manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := struct {
+		Type string            `json:"type"`
+		Data map[string][]byte `json:"data"`
+	}{}
+	if err = json.Unmarshal(data, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.Type != "kubernetes.io/dockerconfigjson" {
+		t.Errorf("manifest() type = %s", secret.Type)
+	}
+	if config := string(secret.Data[".dockerconfigjson"]); !strings.Contains(config, `"registry.local:5000":{"auth":"YWRtaW46c2VjcmV0"`) {
+		t.Errorf("manifest() .dockerconfigjson = %s", config)
+	}
+}
+
+func TestCiliumRunnable_registryConfig(t *testing.T) {
+	nodes := []v1.StepNode{{ID: "node1"}, {ID: "node2"}}
+	tests := []struct {
+		name          string
+		metadata      component.ExtraMetadata
+		config        *v1.CNIRegistryConfig
+		wantConfigure bool
+		wantSecret    bool
+	}{
+		{name: "trusted anonymous"},
+		{name: "insecure", config: &v1.CNIRegistryConfig{InsecureSkipTLSVerify: true}, wantConfigure: true},
+		{name: "ca", metadata: component.ExtraMetadata{LocalRegistryCA: "ca"}, wantConfigure: true},
+		{
+			name:       "authenticated",
+			metadata:   component.ExtraMetadata{LocalRegistryUsername: "admin", LocalRegistryPassword: "secret"},
+			wantSecret: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.metadata.CRI = v1.CRIContainerd
+			tt.metadata.Masters = component.NodeList{{ID: "node1"}}
+			tt.metadata.Workers = component.NodeList{{ID: "node2"}}
+			cni := &v1.CNI{Version: "1.14.4", LocalRegistry: "registry.local:5000", SkipImageVerification: true, LocalRegistryConfig: tt.config}
+			stepper := (&CiliumRunnable{}).InitStep(&tt.metadata, cni, &v1.Networking{}).(*CiliumRunnable)
+
+			steps, err := stepper.LoadImage(nodes)
+			if err != nil {
+				t.Fatalf("LoadImage() error = %v", err)
+			}
+			configure := stepByName(steps, "configureCiliumRegistry")
+			if (configure.Name != "") != tt.wantConfigure {
+				t.Fatalf("LoadImage() = %v, want configureCiliumRegistry %v", stepNames(steps), tt.wantConfigure)
+			}
+			if tt.wantConfigure && len(configure.Nodes) != len(nodes) {
+				t.Errorf("configureCiliumRegistry nodes = %v, want every node", configure.Nodes)
+			}
+
+			if steps, err = stepper.InstallSteps(nodes, ""); err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			names := strings.Join(stepNames(steps), " ")
+			if strings.Contains(names, "createCiliumPullSecret") != tt.wantSecret {
+				t.Fatalf("InstallSteps() = %v, want createCiliumPullSecret %v", names, tt.wantSecret)
+			}
+			w := &bytes.Buffer{}
+			if err = stepper.renderCiliumTo(w); err != nil {
+				t.Fatalf("renderCiliumTo() error = %v", err)
+			}
+			if strings.Contains(w.String(), "imagePullSecrets:\n- name: "+CiliumRegistrySecretName) != tt.wantSecret {
+				t.Errorf("renderCiliumTo() imagePullSecrets want %v:\n%s", tt.wantSecret, w.String())
+			}
+			if !tt.wantSecret {
+				return
+			}
+			if strings.Index(names, "prepareCiliumNamespace") > strings.Index(names, "createCiliumPullSecret") ||
+				strings.Index(names, "createCiliumPullSecret") > strings.Index(names, "installCiliumRelease") {
+				t.Errorf("createCiliumPullSecret should run between the namespace and the release, got %v", names)
+			}
+			if steps, err = stepper.UninstallSteps(nodes); err != nil {
+				t.Fatalf("UninstallSteps() error = %v", err)
+			}
+			if stepByName(steps, "removeCiliumPullSecret").Name == "" {
+				t.Errorf("UninstallSteps() = %v, want removeCiliumPullSecret", stepNames(steps))
+			}
+		})
+	}
+}
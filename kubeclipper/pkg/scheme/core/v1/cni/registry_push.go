@@ -0,0 +1,271 @@
+package cni
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/component/utils"
+	"github.com/kubeclipper/kubeclipper/pkg/logger"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/cmdutil"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const registryImagePusher = "registry-image-push"
+
+func init() {
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-"+registryImagePusher, version, component.TypeStep), &RegistryImagePusher{}); err != nil {
+		panic(err)
+	}
+}
+
+// RegistryImage an image of the offline package and the tag it is pushed to.
+type RegistryImage struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// RegistryImagePusher pushes the images of the offline package of the cni to Registry on install and deletes the pushed
+// tags from Registry on uninstall. The images are pushed by the container runtime CLI, which trusts the registry once
+// RegistryConfigurer configured it, containerd nodes with skopeo installed copy them from the package without loading them.
+type RegistryImagePusher struct {
+	Type     string          `json:"type"`
+	Version  string          `json:"version"`
+	Arch     string          `json:"arch,omitempty"`
+	CriType  string          `json:"criType"`
+	Registry string          `json:"registry"`
+	Images   []RegistryImage `json:"images"`
+	RegistryAccess
+}
+
+func (p *RegistryImagePusher) NewInstance() component.ObjectMeta {
+	return &RegistryImagePusher{}
+}
+
+func (p *RegistryImagePusher) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	p.loadPassword(ctx)
+	if p.Arch != "" && p.Arch != runtime.GOARCH {
+		return nil, fmt.Errorf("the node architecture is %s, the image push step is for %s nodes", runtime.GOARCH, p.Arch)
+	}
+	instance, err := downloader.NewInstance(ctx, p.Type, p.Version, runtime.GOARCH, false, opts.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	file, err := instance.DownloadImages()
+	if errors.Is(err, downloader.ErrNotFound) {
+		return nil, fmt.Errorf("the %s-%s offline package for %s is missing, push %s-%s-%s.tar.gz to the package server: %v",
+			p.Type, p.Version, runtime.GOARCH, p.Type, p.Version, runtime.GOARCH, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.CriType == v1.CRIContainerd {
+		if _, err = exec.LookPath("skopeo"); err == nil {
+			return nil, p.copyImages(ctx, opts.DryRun, file)
+		}
+	}
+	if err = utils.LoadImage(ctx, opts.DryRun, file, p.CriType); err != nil {
+		return nil, err
+	}
+	return nil, p.pushImages(ctx, opts.DryRun)
+}
+
+// Uninstall deletes the pushed tags from Registry, the tags of other clusters sharing the images are deleted as well.
+func (p *RegistryImagePusher) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	if opts.DryRun {
+		return nil, nil
+	}
+	p.loadPassword(ctx)
+	api, err := newRegistryAPI(p.Registry, p.RegistryAccess)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, image := range p.Images {
+		repository, reference, ok := splitRegistryImage(p.Registry, image.Target)
+		if !ok {
+			continue
+		}
+		if err := api.delete(ctx, repository, reference); err != nil {
+			errs = append(errs, fmt.Errorf("delete image %s from registry %s: %w", image.Target, p.Registry, err))
+		}
+	}
+	return nil, utilerrors.NewAggregate(errs)
+}
+
+// pushImages tags the loaded images with the registry tags and pushes them with the container runtime CLI.
+func (p *RegistryImagePusher) pushImages(ctx context.Context, dryRun bool) error {
+	var cli []string
+	switch p.CriType {
+	case v1.CRIContainerd:
+		cli = []string{"nerdctl", "--namespace", "k8s.io"}
+	case v1.CRIDocker:
+		cli = []string{"docker"}
+	default:
+		return fmt.Errorf("unsupported cri type %q", p.CriType)
+	}
+	if err := p.login(ctx, dryRun, cli); err != nil {
+		return err
+	}
+	for _, image := range p.Images {
+		if _, err := cmdutil.RunCmdWithContext(ctx, dryRun, cli[0], append(cli[1:], "tag", image.Source, image.Target)...); err != nil {
+			return err
+		}
+		if _, err := cmdutil.RunCmdWithContext(ctx, dryRun, cli[0], append(cli[1:], "push", image.Target)...); err != nil {
+			return err
+		}
+	}
+	logger.Info("cni images pushed to the registry successfully", zap.String("registry", p.Registry))
+	return nil
+}
+
+// copyImages copies the images from the package to the registry with skopeo, the package is removed afterwards.
+func (p *RegistryImagePusher) copyImages(ctx context.Context, dryRun bool, file string) error {
+	args := []string{"copy", "--all"}
+	if p.InsecureSkipTLSVerify {
+		args = append(args, "--dest-tls-verify=false")
+	}
+	if p.CA != "" {
+		// skopeo trusts the *.crt files of the cert dir
+		dir, err := os.MkdirTemp("", "registry-ca")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		if err = os.WriteFile(filepath.Join(dir, "ca.crt"), []byte(p.CA), 0644); err != nil {
+			return err
+		}
+		args = append(args, "--dest-cert-dir", dir)
+	}
+	if err := p.login(ctx, dryRun, []string{"skopeo"}); err != nil {
+		return err
+	}
+	for _, image := range p.Images {
+		if _, err := cmdutil.RunCmdWithContext(ctx, dryRun, "skopeo", append(args,
+			fmt.Sprintf("docker-archive:%s:%s", file, image.Source), "docker://"+image.Target)...); err != nil {
+			return err
+		}
+	}
+	_, err := cmdutil.RunCmdWithContext(ctx, dryRun, "rm", "-rf", file)
+	return err
+}
+
+// login logs cli in to the registry host, the password is passed on stdin so that it is not logged.
+func (p *RegistryImagePusher) login(ctx context.Context, dryRun bool, cli []string) error {
+	if p.Username == "" || dryRun {
+		return nil
+	}
+	host, _, _ := strings.Cut(p.Registry, "/")
+	args := append(cli[1:], "login", "--username", p.Username, "--password-stdin")
+	if cli[0] == "skopeo" && p.InsecureSkipTLSVerify {
+		args = append(args, "--tls-verify=false")
+	}
+	cmd := exec.CommandContext(ctx, cli[0], append(args, host)...)
+	cmd.Stdin = strings.NewReader(p.Password)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s login %s: %w: %s", cli[0], host, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// pushNode returns the node pushing the images, the first control-plane node of the cluster. ok is false when
+// the images are not pushed or the node is not one of nodes, e.g. when nodes join the cluster.
+func (runnable *CiliumRunnable) pushNode(nodes []v1.StepNode) (v1.StepNode, bool) {
+	if !runnable.PushToRegistry || !runnable.Offline || runnable.LocalRegistry == "" || len(nodes) == 0 {
+		return v1.StepNode{}, false
+	}
+	if len(runnable.masters) == 0 {
+		return nodes[0], true
+	}
+	for _, node := range nodes {
+		if node.ID == runnable.masters[0].ID {
+			return node, true
+		}
+	}
+	return v1.StepNode{}, false
+}
+
+// registryImages pairs the images of the offline package with the LocalRegistry tags the nodes pull.
+func (runnable *CiliumRunnable) registryImages() ([]RegistryImage, error) {
+	source := *runnable
+	source.LocalRegistry = ""
+	sources, err := source.GetImages(runnable.Version, runnable.CriType)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := runnable.GetImages(runnable.Version, runnable.CriType)
+	if err != nil {
+		return nil, err
+	}
+	images := make([]RegistryImage, len(sources))
+	for i := range sources {
+		images[i] = RegistryImage{Source: sources[i], Target: targets[i]}
+	}
+	return images, nil
+}
+
+func (runnable *CiliumRunnable) imagePusher(arch string) ([]byte, error) {
+	images, err := runnable.registryImages()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&RegistryImagePusher{
+		Type:           runnable.BaseCni.Type,
+		Version:        runnable.Version,
+		Arch:           arch,
+		CriType:        runnable.CriType,
+		Registry:       runnable.LocalRegistry,
+		Images:         images,
+		RegistryAccess: runnable.registryAccess,
+	})
+}
+
+// pushImages pushes the images to LocalRegistry from node before the other nodes pull them.
+func (runnable *CiliumRunnable) pushImages(node v1.StepNode) (v1.Step, error) {
+	custom, err := runnable.imagePusher(node.Arch)
+	if err != nil {
+		return v1.Step{}, err
+	}
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "pushCiliumImages",
+		Timeout:    metav1.Duration{Duration: runnable.imageLoadTimeout()},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      []v1.StepNode{node},
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:          v1.CommandCustom,
+				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+registryImagePusher, version, component.TypeStep),
+				CustomCommand: custom,
+				Env:           runnable.registryAccess.env(),
+			},
+		},
+	}, nil
+}
+
+// removePushedImages deletes the pushed images from LocalRegistry, the uninstall goes on when the registry refuses.
+func (runnable *CiliumRunnable) removePushedImages(nodes []v1.StepNode) (v1.Step, error) {
+	custom, err := runnable.imagePusher("")
+	if err != nil {
+		return v1.Step{}, err
+	}
+	step := RemoveImage(registryImagePusher, custom, nodes[:1])
+	step.Name = "removeCiliumRegistryImages"
+	step.Commands[0].Env = runnable.registryAccess.env()
+	step.ErrIgnore = true
+	return step, nil
+}
@@ -0,0 +1,138 @@
+package cni
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func TestRegistryImagePusher_Uninstall(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "admin" || password != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodHead && r.URL.Path == "/v2/library/cilium/cilium/manifests/v1.14.4":
+			w.Header().Set("Docker-Content-Digest", "sha256:0123")
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "http://") + "/library"
+
+	pusher := &RegistryImagePusher{
+		Registry:       registry,
+		RegistryAccess: RegistryAccess{Username: "admin", Password: "secret"},
+		Images: []RegistryImage{
+			{Source: "quay.io/cilium/cilium:v1.14.4", Target: registry + "/cilium/cilium:v1.14.4"},
+			{Source: "quay.io/cilium/operator-generic:v1.14.4", Target: registry + "/cilium/operator-generic:v1.14.4"},
+		},
+	}
+	if _, err := pusher.Uninstall(context.TODO(), component.Options{}); err != nil {
+		t.Fatalf("Uninstall() error = %v", err)
+	}
+	if want := []string{"/v2/library/cilium/cilium/manifests/sha256:0123"}; strings.Join(deleted, ",") != strings.Join(want, ",") {
+		t.Errorf("Uninstall() deleted %v, want %v", deleted, want)
+	}
+
+	pusher.Password = "wrong"
+	if _, err := pusher.Uninstall(context.TODO(), component.Options{}); err == nil {
+		t.Errorf("Uninstall() with wrong credentials should fail")
+	}
+}
+
+func TestCiliumRunnable_pushImages(t *testing.T) {
+	nodes := []v1.StepNode{{ID: "node1", Arch: "amd64"}, {ID: "node2", Arch: "arm64"}}
+	metadata := &component.ExtraMetadata{
+		CRI:                   v1.CRIContainerd,
+		Masters:               component.NodeList{{ID: "node1"}},
+		Workers:               component.NodeList{{ID: "node2"}},
+		LocalRegistryUsername: "admin",
+		LocalRegistryPassword: "secret",
+	}
+	cni := &v1.CNI{Version: "1.14.4", Offline: true, LocalRegistry: "registry.local:5000", PushToRegistry: true, RemovePushedImages: true}
+	stepper := (&CiliumRunnable{}).InitStep(metadata, cni, &v1.Networking{})
+	if err := stepper.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	steps, err := stepper.LoadImage(nodes)
+	if err != nil {
+		t.Fatalf("LoadImage() error = %v", err)
+	}
+	if names := stepNames(steps); len(names) != 1 || names[0] != "pushCiliumImages" {
+		t.Fatalf("LoadImage() = %v, want only pushCiliumImages", names)
+	}
+	if len(steps[0].Nodes) != 1 || steps[0].Nodes[0].ID != "node1" {
+		t.Errorf("pushCiliumImages nodes = %v, want the first control-plane node", steps[0].Nodes)
+	}
+	pusher := &RegistryImagePusher{}
+	if err = json.Unmarshal(steps[0].Commands[0].CustomCommand, pusher); err != nil {
+		t.Fatal(err)
+	}
+	if pusher.Arch != "amd64" || pusher.Username != "admin" || strings.Contains(string(steps[0].Commands[0].CustomCommand), "secret") {
+		t.Errorf("pushCiliumImages pusher = %+v", pusher)
+	}
+	if env := steps[0].Commands[0].Env; len(env) != 1 || env[0].Value != "secret" || !env[0].Sensitive {
+		t.Errorf("pushCiliumImages env = %+v, want the password in a sensitive variable", env)
+	}
+	if want := (RegistryImage{Source: "quay.io/cilium/cilium:v1.14.4", Target: "registry.local:5000/cilium/cilium:v1.14.4"}); pusher.Images[0] != want {
+		t.Errorf("pushCiliumImages images[0] = %+v, want %+v", pusher.Images[0], want)
+	}
+	custom, err := json.Marshal(stepper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(custom), "secret") {
+		t.Errorf("the registry password must only be passed to the push steps")
+	}
+
+	if steps, err = stepper.JoinNodeSteps(nodes[1:]); err != nil {
+		t.Fatalf("JoinNodeSteps() error = %v", err)
+	}
+	if stepByName(steps, "pushCiliumImages").Name != "" {
+		t.Errorf("JoinNodeSteps() = %v, the joining nodes pull the pushed images", stepNames(steps))
+	}
+
+	if steps, err = stepper.UninstallSteps(nodes); err != nil {
+		t.Fatalf("UninstallSteps() error = %v", err)
+	}
+	remove := stepByName(steps, "removeCiliumRegistryImages")
+	if remove.Name == "" || remove.Action != v1.ActionUninstall || !remove.ErrIgnore || len(remove.Nodes) != 1 {
+		t.Errorf("UninstallSteps() removeCiliumRegistryImages = %+v in %v", remove, stepNames(steps))
+	}
+}
+
+func TestBaseCni_validateRegistryPush(t *testing.T) {
+	tests := []struct {
+		name    string
+		cni     v1.CNI
+		wantErr bool
+	}{
+		{name: "push", cni: v1.CNI{Offline: true, LocalRegistry: "registry.local", PushToRegistry: true, RemovePushedImages: true}},
+		{name: "online", cni: v1.CNI{LocalRegistry: "registry.local", PushToRegistry: true}, wantErr: true},
+		{name: "no registry", cni: v1.CNI{Offline: true, PushToRegistry: true}, wantErr: true},
+		{name: "remove without push", cni: v1.CNI{Offline: true, LocalRegistry: "registry.local", RemovePushedImages: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (&BaseCni{CNI: tt.cni}).validateRegistryPush(); (err != nil) != tt.wantErr {
+				t.Errorf("validateRegistryPush() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package cni
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	registryImageVerifier = "registry-image-verify"
+
+	registryRequestTimeout = 10 * time.Second
+)
+
+// registryManifestMediaTypes the manifests a registry may store the images as, the multi-arch cilium images are lists.
+var registryManifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+var bearerParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+func init() {
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-"+registryImageVerifier, version, component.TypeStep), &RegistryImageVerifier{}); err != nil {
+		panic(err)
+	}
+}
+
+// RegistryImageVerifier fails when any of Images is missing from Registry, it asks the registry API for the
+// manifest of every image.
+type RegistryImageVerifier struct {
+	Registry string   `json:"registry"`
+	Images   []string `json:"images"`
+	RegistryAccess
+}
+
+func (v *RegistryImageVerifier) NewInstance() component.ObjectMeta {
+	return &RegistryImageVerifier{}
+}
+
+func (v *RegistryImageVerifier) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	if opts.DryRun {
+		return nil, nil
+	}
+	v.loadPassword(ctx)
+	api, err := newRegistryAPI(v.Registry, v.RegistryAccess)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, image := range v.Images {
+		repository, reference, ok := splitRegistryImage(v.Registry, image)
+		if !ok {
+			continue
+		}
+		found, err := api.exists(ctx, repository, reference)
+		if err != nil {
+			return nil, fmt.Errorf("verify image %s in registry %s: %w", image, v.Registry, err)
+		}
+		if !found {
+			missing = append(missing, image)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("the images are missing from registry %s: %s", v.Registry, strings.Join(missing, ", "))
+	}
+	return nil, nil
+}
+
+func (v *RegistryImageVerifier) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	return nil, nil
+}
+
+// splitRegistryImage splits image of registry into the repository path on the registry host and the tag,
+// ok is false when image is not in registry.
+func splitRegistryImage(registry, image string) (repository, reference string, ok bool) {
+	registry = strings.TrimSuffix(registry, "/")
+	if !strings.HasPrefix(image, registry+"/") {
+		return "", "", false
+	}
+	name := image[len(registry)+1:]
+	if _, path, found := strings.Cut(registry, "/"); found {
+		name = path + "/" + name
+	}
+	if i := strings.LastIndex(name, "@"); i > 0 {
+		return name[:i], name[i+1:], true
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[:i], name[i+1:], true
+	}
+	return name, "latest", true
+}
+
+// registryAPI reaches the registry API of a registry host over https, and over http when https can not be reached,
+// the way the container runtimes reach insecure registries. Bearer tokens are requested when the registry asks
+// for them, anonymously unless there are credentials.
+type registryAPI struct {
+	client   *http.Client
+	host     string
+	username string
+	password string
+}
+
+func newRegistryAPI(registry string, access RegistryAccess) (*registryAPI, error) {
+	tlsConfig, err := access.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	// the registry API is served at the root of the host, the path of the registry is part of the repositories
+	host, _, _ := strings.Cut(registry, "/")
+	// the local registry is internal, it is never reached through the download proxy
+	if proxy := downloader.ProxyFunc(host); proxy != nil {
+		transport.Proxy = proxy
+	}
+	return &registryAPI{
+		client:   &http.Client{Timeout: registryRequestTimeout, Transport: transport},
+		host:     host,
+		username: access.Username,
+		password: access.Password,
+	}, nil
+}
+
+// exists sends HEAD /v2/<repository>/manifests/<reference>.
+func (r *registryAPI) exists(ctx context.Context, repository, reference string) (bool, error) {
+	resp, err := r.manifest(ctx, http.MethodHead, repository, reference, "pull")
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected registry response %s", resp.Status)
+	}
+}
+
+// delete deletes the manifest reference points to, the registry only deletes manifests by digest.
+// A missing manifest is not an error.
+func (r *registryAPI) delete(ctx context.Context, repository, reference string) error {
+	resp, err := r.manifest(ctx, http.MethodHead, repository, reference, "pull,delete")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("unexpected registry response %s", resp.Status)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return fmt.Errorf("the registry did not respond with the digest of %s:%s", repository, reference)
+	}
+	if resp, err = r.manifest(ctx, http.MethodDelete, repository, digest, "pull,delete"); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK, http.StatusNotFound:
+		return nil
+	case http.StatusMethodNotAllowed:
+		return fmt.Errorf("the registry does not allow deleting images")
+	default:
+		return fmt.Errorf("unexpected registry response %s", resp.Status)
+	}
+}
+
+// manifest sends method to /v2/<repository>/manifests/<reference>, actions are the actions of the token scope.
+// The caller closes the response body.
+func (r *registryAPI) manifest(ctx context.Context, method, repository, reference, actions string) (*http.Response, error) {
+	var err error
+	for _, scheme := range []string{"https", "http"} {
+		var resp *http.Response
+		u := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, r.host, repository, reference)
+		if resp, err = r.do(ctx, method, u, ""); err != nil {
+			continue
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			return resp, nil
+		}
+		resp.Body.Close()
+		authorization, err := r.authorize(ctx, resp.Header.Get("WWW-Authenticate"), repository, actions)
+		if err != nil {
+			return nil, err
+		}
+		return r.do(ctx, method, u, authorization)
+	}
+	return nil, err
+}
+
+func (r *registryAPI) do(ctx context.Context, method, u, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(registryManifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return r.client.Do(req)
+}
+
+// authorize returns the Authorization header answering the challenge of the registry.
+func (r *registryAPI) authorize(ctx context.Context, challenge, repository, actions string) (string, error) {
+	scheme := strings.ToLower(challenge)
+	if strings.HasPrefix(scheme, "basic ") && r.username != "" {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(r.username+":"+r.password)), nil
+	}
+	if !strings.HasPrefix(scheme, "bearer ") {
+		return "", fmt.Errorf("the registry requires authentication %q", challenge)
+	}
+	token, err := r.token(ctx, challenge, repository, actions)
+	if err != nil {
+		return "", err
+	}
+	return "Bearer " + token, nil
+}
+
+// token requests a token of repository from the token server of the bearer challenge.
+func (r *registryAPI) token(ctx context.Context, challenge, repository, actions string) (string, error) {
+	params := make(map[string]string)
+	for _, match := range bearerParamRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid registry authentication realm %q", params["realm"])
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", "repository:"+repository+":"+actions)
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("the registry denied the token: %s", resp.Status)
+	}
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("decode the registry token: %w", err)
+	}
+	return strutil.StringDefaultIfEmpty(token.AccessToken, token.Token), nil
+}
+
+// verifyRegistryImages fails before the release is installed when the images of the cilium version are missing
+// from LocalRegistry, the agents would be stuck pulling them otherwise.
+func (runnable *CiliumRunnable) verifyRegistryImages(nodes []v1.StepNode) (v1.Step, error) {
+	images, err := runnable.GetImages(runnable.Version, runnable.CriType)
+	if err != nil {
+		return v1.Step{}, err
+	}
+	custom, err := json.Marshal(&RegistryImageVerifier{Registry: runnable.LocalRegistry, Images: images, RegistryAccess: runnable.registryAccess})
+	if err != nil {
+		return v1.Step{}, err
+	}
+	if len(nodes) > 1 {
+		nodes = nodes[:1]
+	}
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "verifyCiliumImages",
+		Timeout:    metav1.Duration{Duration: time.Minute},
+		ErrIgnore:  false,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionInstall,
+		Commands: []v1.Command{
+			{
+				Type:          v1.CommandCustom,
+				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+registryImageVerifier, version, component.TypeStep),
+				CustomCommand: custom,
+				Env:           runnable.registryAccess.env(),
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,97 @@
+package cni
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func TestRegistryImageVerifier(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if r.URL.Query().Get("scope") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"token":"anonymous"}`))
+		case r.Header.Get("Authorization") != "Bearer anonymous":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method == http.MethodHead && r.URL.Path == "/v2/library/cilium/cilium/manifests/v1.14.4":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "http://") + "/library"
+
+	tests := []struct {
+		name    string
+		images  []string
+		wantErr string
+	}{
+		{name: "present", images: []string{registry + "/cilium/cilium:v1.14.4", "quay.io/coreos/etcd:v3.5.4"}},
+		{
+			name:    "missing",
+			images:  []string{registry + "/cilium/cilium:v1.14.4", registry + "/cilium/operator-generic:v1.14.4"},
+			wantErr: "missing from registry " + registry + ": " + registry + "/cilium/operator-generic:v1.14.4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := (&RegistryImageVerifier{Registry: registry, Images: tt.images}).Install(context.TODO(), component.Options{})
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Install() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCiliumRunnable_verifyRegistryImages(t *testing.T) {
+	tests := []struct {
+		name       string
+		cni        v1.CNI
+		wantVerify bool
+	}{
+		{name: "offline with registry", cni: v1.CNI{Offline: true, LocalRegistry: "registry.local:5000"}, wantVerify: true},
+		{name: "skipped", cni: v1.CNI{Offline: true, LocalRegistry: "registry.local:5000", SkipImageVerification: true}},
+		{name: "offline without registry", cni: v1.CNI{Offline: true}},
+		{name: "online", cni: v1.CNI{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cni.Version = "1.14.4"
+			metadata := &component.ExtraMetadata{CRI: v1.CRIContainerd, Masters: component.NodeList{{ID: "node1"}, {ID: "node2"}}}
+			stepper := (&CiliumRunnable{}).InitStep(metadata, &tt.cni, &v1.Networking{})
+			steps, err := stepper.InstallSteps([]v1.StepNode{{ID: "node1"}, {ID: "node2"}}, "")
+			if err != nil {
+				t.Fatalf("InstallSteps() error = %v", err)
+			}
+			verify := stepByName(steps, "verifyCiliumImages")
+			if (verify.Name != "") != tt.wantVerify {
+				t.Fatalf("InstallSteps() = %v, want verifyCiliumImages %v", stepNames(steps), tt.wantVerify)
+			}
+			if !tt.wantVerify {
+				return
+			}
+			if len(verify.Nodes) != 1 {
+				t.Errorf("verifyCiliumImages nodes = %v, want a single node", verify.Nodes)
+			}
+			if names := strings.Join(stepNames(steps), " "); strings.Index(names, "verifyCiliumImages") > strings.Index(names, "installCiliumRelease") {
+				t.Errorf("verifyCiliumImages should run before the release is installed, got %v", names)
+			}
+			if custom := string(verify.Commands[0].CustomCommand); !strings.Contains(custom, `"registry.local:5000/cilium/cilium:v1.14.4"`) {
+				t.Errorf("verifyCiliumImages images = %s", custom)
+			}
+		})
+	}
+}
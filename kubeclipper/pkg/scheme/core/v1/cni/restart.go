@@ -0,0 +1,104 @@
+package cni
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// restartWorkloadsTimeoutDefault the time to drain a node when v1.CNIRestartWorkloads Timeout is unset.
+	restartWorkloadsTimeoutDefault = 10 * time.Minute
+	// maxRestartConcurrency the most nodes drained at once.
+	maxRestartConcurrency = 10
+)
+
+// validateRestartWorkloads rejects the workload restarts out of range.
+func (runnable *BaseCni) validateRestartWorkloads() error {
+	restart := runnable.RestartWorkloads
+	if restart == nil {
+		return nil
+	}
+	if restart.Concurrency < 0 || restart.Concurrency > maxRestartConcurrency {
+		return fmt.Errorf("cni restart concurrency %d must be between 1 and %d", restart.Concurrency, maxRestartConcurrency)
+	}
+	if restart.GracePeriod != nil && restart.GracePeriod.Duration < 0 {
+		return fmt.Errorf("cni restart grace period %s must not be negative", restart.GracePeriod.Duration)
+	}
+	if d := restart.Timeout.Duration; d != 0 && (d < minStepTimeout || d > maxStepTimeout) {
+		return fmt.Errorf("cni restart timeout %s must be between %s and %s", d, minStepTimeout, maxStepTimeout)
+	}
+	return nil
+}
+
+// RestartWorkloads drains the nodes from master one batch of Concurrency nodes after another, the evicted pods
+// of the workloads are recreated on the network of the installed cni. The DaemonSet and static pods are kept.
+// Every node is uncordoned after its drain, also when the drain fails. The output lists the restarted pods of
+// each node:
+//
+//	node <name>: restarted <namespace>/<pod>
+func RestartWorkloads(restart *v1.CNIRestartWorkloads, nodes, master []v1.StepNode) []v1.Step {
+	if restart == nil || len(nodes) == 0 {
+		return nil
+	}
+	concurrency := restart.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	timeout := durationDefaultIfZero(restart.Timeout.Duration, restartWorkloadsTimeoutDefault)
+	drainArgs := fmt.Sprintf("--ignore-daemonsets --delete-emptydir-data --timeout %s", timeout)
+	if restart.GracePeriod != nil {
+		drainArgs += fmt.Sprintf(" --grace-period %d", int(restart.GracePeriod.Duration.Seconds()))
+	}
+	var steps []v1.Step
+	for start := 0; start < len(nodes); start += concurrency {
+		end := start + concurrency
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+		var hostnames []string
+		for _, node := range nodes[start:end] {
+			hostnames = append(hostnames, node.Hostname)
+		}
+		steps = append(steps, v1.Step{
+			ID:         newStepID(),
+			Name:       "restartWorkloads-" + strings.Join(hostnames, ","),
+			Timeout:    metav1.Duration{Duration: timeout + time.Minute},
+			ErrIgnore:  false,
+			RetryTimes: 0,
+			Nodes:      master,
+			Action:     v1.ActionInstall,
+			Commands: []v1.Command{
+				{
+					Type:         v1.CommandShell,
+					ShellCommand: []string{"/bin/bash", "-c", restartWorkloadsScript(hostnames, drainArgs)},
+				},
+			},
+		})
+	}
+	return steps
+}
+
+// restartWorkloadsScript drains the nodes in parallel, it fails when the drain of a node fails.
+func restartWorkloadsScript(hostnames []string, drainArgs string) string {
+	return fmt.Sprintf(`restart() {
+  node=$1
+  pods=$(kubectl get po -A --field-selector spec.nodeName=$node -o custom-columns=NS:.metadata.namespace,NAME:.metadata.name,OWNER:.metadata.ownerReferences[0].kind --no-headers | awk '$3!="DaemonSet" && $3!="Node" {print $1"/"$2}')
+  kubectl cordon $node >/dev/null || return 1
+  if ! kubectl drain $node %[2]s >/dev/null; then
+    echo "node $node: drain failed, the pods which are not evicted keep their network"
+    kubectl uncordon $node >/dev/null
+    return 1
+  fi
+  kubectl uncordon $node >/dev/null || return 1
+  for pod in $pods; do echo "node $node: restarted $pod"; done
+}
+pids=""
+for node in %[1]s; do restart $node & pids="$pids $!"; done
+failed=0
+for pid in $pids; do wait $pid || failed=1; done
+exit $failed`, strings.Join(hostnames, " "), drainArgs)
+}
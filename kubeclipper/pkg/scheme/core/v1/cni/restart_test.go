@@ -0,0 +1,72 @@
+package cni
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRestartWorkloads(t *testing.T) {
+	nodes := []v1.StepNode{{ID: "1", Hostname: "node1"}, {ID: "2", Hostname: "node2"}, {ID: "3", Hostname: "node3"}}
+	master := nodes[:1]
+	tests := []struct {
+		name      string
+		restart   *v1.CNIRestartWorkloads
+		want      []string
+		wantDrain string
+	}{
+		{name: "disabled"},
+		{
+			name:      "one node at a time",
+			restart:   &v1.CNIRestartWorkloads{},
+			want:      []string{"restartWorkloads-node1", "restartWorkloads-node2", "restartWorkloads-node3"},
+			wantDrain: "--ignore-daemonsets --delete-emptydir-data --timeout 10m0s >",
+		},
+		{
+			name:      "batches with grace period",
+			restart:   &v1.CNIRestartWorkloads{Concurrency: 2, GracePeriod: &metav1.Duration{Duration: 30 * time.Second}, Timeout: metav1.Duration{Duration: 5 * time.Minute}},
+			want:      []string{"restartWorkloads-node1,node2", "restartWorkloads-node3"},
+			wantDrain: "--ignore-daemonsets --delete-emptydir-data --timeout 5m0s --grace-period 30 >",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps := RestartWorkloads(tt.restart, nodes, master)
+			if got := stepNames(steps); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("RestartWorkloads() = %v, want %v", got, tt.want)
+			}
+			for _, step := range steps {
+				script := step.Commands[0].ShellCommand[2]
+				if !reflect.DeepEqual(step.Nodes, master) || !strings.Contains(script, tt.wantDrain) {
+					t.Errorf("%s runs on %v:\n%s", step.Name, step.Nodes, script)
+				}
+			}
+		})
+	}
+}
+
+func TestBaseCni_validateRestartWorkloads(t *testing.T) {
+	tests := []struct {
+		name    string
+		restart *v1.CNIRestartWorkloads
+		wantErr bool
+	}{
+		{name: "unset"},
+		{name: "defaults", restart: &v1.CNIRestartWorkloads{}},
+		{name: "concurrency out of range", restart: &v1.CNIRestartWorkloads{Concurrency: maxRestartConcurrency + 1}, wantErr: true},
+		{name: "negative grace period", restart: &v1.CNIRestartWorkloads{GracePeriod: &metav1.Duration{Duration: -time.Second}}, wantErr: true},
+		{name: "timeout too short", restart: &v1.CNIRestartWorkloads{Timeout: metav1.Duration{Duration: time.Second}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runnable := &BaseCni{CNI: v1.CNI{RestartWorkloads: tt.restart}}
+			if err := runnable.validateRestartWorkloads(); (err != nil) != tt.wantErr {
+				t.Errorf("validateRestartWorkloads() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package cni
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component/common"
+)
+
+// OperationHealth reports the desired and ready pods of the cni workloads and the status of the cni release,
+// the server tracks the CNIReady condition of the clusters with it, see ParseWorkloadHealth.
+const OperationHealth = "health"
+
+// helmDeployed the status of a deployed helm release.
+const helmDeployed = "deployed"
+
+// Workloads the workloads of a cni the health operation checks.
+type Workloads struct {
+	Namespace   string
+	DaemonSets  []string
+	Deployments []string
+	// Release the helm release of the cni, empty when the cni is not installed by helm.
+	Release string
+}
+
+// healthOperation the health operation of the cni workloads.
+func healthOperation(cniName string, w Workloads) Operation {
+	return Operation{
+		Name:        OperationHealth,
+		Description: fmt.Sprintf("Show the desired and ready pods of the %s workloads and the status of its release.", cniName),
+		Command:     workloadHealthCommand(w),
+		Output:      OperationOutputText,
+	}
+}
+
+// workloadHealthCommand prints a line of every workload and of the release, the command succeeds when they are
+// missing so that the output tells them apart from the unreachable nodes:
+//
+//	daemonset/<name> <desired> <ready>
+//	deployment/<name> <desired> <available>
+//	release/<name> <status>
+func workloadHealthCommand(w Workloads) string {
+	var cmds []string
+	for _, ds := range w.DaemonSets {
+		cmds = append(cmds, fmt.Sprintf(`kubectl get ds %[1]s -n %[2]s -o jsonpath='daemonset/{.metadata.name} {.status.desiredNumberScheduled} {.status.numberReady}{"\n"}' 2>/dev/null || echo "daemonset/%[1]s - -"`,
+			ds, w.Namespace))
+	}
+	for _, deploy := range w.Deployments {
+		cmds = append(cmds, fmt.Sprintf(`kubectl get deploy %[1]s -n %[2]s -o jsonpath='deployment/{.metadata.name} {.spec.replicas} {.status.availableReplicas}{"\n"}' 2>/dev/null || echo "deployment/%[1]s - -"`,
+			deploy, w.Namespace))
+	}
+	if w.Release != "" {
+		cmds = append(cmds, fmt.Sprintf(`echo "release/%[1]s $(%[3]s status %[1]s -n %[2]s 2>/dev/null | awk '/^STATUS:/ {print $2}')"`, w.Release, w.Namespace, common.HelmBin))
+	}
+	return strings.Join(cmds, "; ")
+}
+
+// ParseWorkloadHealth parses the output of the health operation, every workload and the release is a check of
+// the result. The workloads are healthy when their pods are ready, the release when it is deployed.
+func ParseWorkloadHealth(output string) *HealthResult {
+	result := &HealthResult{Healthy: true}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		kind, name, _ := strings.Cut(fields[0], "/")
+		switch kind {
+		case "daemonset", "deployment":
+			desired, ready := workloadCount(fields, 1), workloadCount(fields, 2)
+			switch {
+			case desired < 0:
+				result.add(fields[0], false, fmt.Sprintf("%s %s not found", kind, name))
+			case ready < desired:
+				result.add(fields[0], false, fmt.Sprintf("%d of %d pods ready", ready, desired))
+			default:
+				result.add(fields[0], true, fmt.Sprintf("%d of %d pods ready", ready, desired))
+			}
+		case "release":
+			status := "not found"
+			if len(fields) > 1 {
+				status = fields[1]
+			}
+			result.add(fields[0], status == helmDeployed, fmt.Sprintf("release %s %s", name, status))
+		}
+	}
+	if len(result.Checks) == 0 {
+		result.add("output", false, fmt.Sprintf("unexpected health output %q", strings.TrimSpace(output)))
+	}
+	return result
+}
+
+// workloadCount the count of the line field i, 0 when kubectl omits it and -1 when the workload is missing.
+func workloadCount(fields []string, i int) int {
+	if i >= len(fields) {
+		return 0
+	}
+	n, err := strconv.Atoi(fields[i])
+	if err != nil {
+		return -1
+	}
+	return n
+}
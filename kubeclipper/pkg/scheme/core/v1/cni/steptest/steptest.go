@@ -0,0 +1,140 @@
+// Package steptest asserts the steps the cni runnables generate without running them on nodes: the plans of the
+// steps are compared with golden files and single steps are checked by their names and commands.
+package steptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+// Plan the steps of a cni from the image load to the uninstall.
+type Plan struct {
+	LoadImage []v1.Step `json:"loadImage"`
+	Install   []v1.Step `json:"install"`
+	Uninstall []v1.Step `json:"uninstall"`
+}
+
+// Normalize replaces the step IDs of plan in their order with step-<n>, also in the rollback steps and their
+// RollbackAfter references, so that the golden files do not change with the IDs.
+func Normalize(plan Plan) Plan {
+	ids := make(map[string]string)
+	var rename func(steps []v1.Step) []v1.Step
+	rename = func(steps []v1.Step) []v1.Step {
+		if steps == nil {
+			return nil
+		}
+		renamed := make([]v1.Step, len(steps))
+		for i, step := range steps {
+			if step.ID != "" {
+				if _, ok := ids[step.ID]; !ok {
+					ids[step.ID] = fmt.Sprintf("step-%d", len(ids)+1)
+				}
+				step.ID = ids[step.ID]
+			}
+			step.RollbackSteps = rename(step.RollbackSteps)
+			renamed[i] = step
+		}
+		return renamed
+	}
+	plan.LoadImage, plan.Install, plan.Uninstall = rename(plan.LoadImage), rename(plan.Install), rename(plan.Uninstall)
+	var relink func(steps []v1.Step)
+	relink = func(steps []v1.Step) {
+		for i := range steps {
+			if id, ok := ids[steps[i].RollbackAfter]; ok {
+				steps[i].RollbackAfter = id
+			}
+			relink(steps[i].RollbackSteps)
+		}
+	}
+	relink(plan.LoadImage)
+	relink(plan.Install)
+	relink(plan.Uninstall)
+	return plan
+}
+
+// AssertGolden compares the normalized plan with the golden file, the file is rewritten when update is set.
+func AssertGolden(t testing.TB, golden string, plan Plan, update bool) {
+	t.Helper()
+	got, err := json.MarshalIndent(Normalize(plan), "", "  ")
+	if err != nil {
+		t.Fatalf("marshal the plan: %v", err)
+	}
+	got = append(got, '\n')
+	if update {
+		if err = os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Fatalf("update golden file %s failed: %v", golden, err)
+		}
+		if err = os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatalf("update golden file %s failed: %v", golden, err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("read golden file %s failed: %v", golden, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch, got:\n%s\nwant:\n%s", golden, got, want)
+	}
+}
+
+// StepNamed returns the first step named name, the test fails when there is none.
+func StepNamed(t testing.TB, steps []v1.Step, name string) v1.Step {
+	t.Helper()
+	for _, step := range steps {
+		if step.Name == name {
+			return step
+		}
+	}
+	names := make([]string, 0, len(steps))
+	for _, step := range steps {
+		names = append(names, step.Name)
+	}
+	t.Fatalf("no step %s in %v", name, names)
+	return v1.Step{}
+}
+
+// CommandContains checks that a command of step contains each of want, the shell commands are joined with
+// spaces, the helm commands are compared in their JSON form and the custom and template commands with their data.
+func CommandContains(t testing.TB, step v1.Step, want ...string) {
+	t.Helper()
+	var commands []string
+	for _, cmd := range step.Commands {
+		commands = append(commands, commandString(cmd))
+	}
+	for _, w := range want {
+		found := false
+		for _, cmd := range commands {
+			if strings.Contains(cmd, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("the commands of step %s do not contain %q:\n%s", step.Name, w, strings.Join(commands, "\n"))
+		}
+	}
+}
+
+func commandString(cmd v1.Command) string {
+	if cmd.Type == v1.CommandShell {
+		return strings.Join(cmd.ShellCommand, " ")
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err.Error()
+	}
+	switch {
+	case cmd.CustomCommand != nil:
+		return string(data) + " " + string(cmd.CustomCommand)
+	case cmd.Template != nil:
+		return string(data) + " " + string(cmd.Template.Data)
+	}
+	return string(data)
+}
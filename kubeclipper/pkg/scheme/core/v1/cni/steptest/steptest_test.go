@@ -0,0 +1,54 @@
+package steptest
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func TestNormalize(t *testing.T) {
+	plan := Normalize(Plan{
+		LoadImage: []v1.Step{{ID: "a", Name: "load"}},
+		Install: []v1.Step{
+			{ID: "b", Name: "install", RollbackAfter: "c", RollbackSteps: []v1.Step{{ID: "d", Name: "rollback"}}},
+			{ID: "c", Name: "check"},
+		},
+		Uninstall: []v1.Step{{ID: "a", Name: "load"}},
+	})
+	want := Plan{
+		LoadImage: []v1.Step{{ID: "step-1", Name: "load"}},
+		Install: []v1.Step{
+			{ID: "step-2", Name: "install", RollbackAfter: "step-4", RollbackSteps: []v1.Step{{ID: "step-3", Name: "rollback"}}},
+			{ID: "step-4", Name: "check"},
+		},
+		Uninstall: []v1.Step{{ID: "step-1", Name: "load"}},
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("Normalize() = %+v, want %+v", plan, want)
+	}
+}
+
+func TestCommandContains(t *testing.T) {
+	step := v1.Step{Name: "install", Commands: []v1.Command{
+		{Type: v1.CommandShell, ShellCommand: []string{"helm", "install", "cilium"}},
+		{Type: v1.CommandCustom, CustomCommand: []byte(`{"version":"1.14.4"}`)},
+	}}
+	CommandContains(t, step, "helm install cilium", `"version":"1.14.4"`)
+
+	ft := &fakeTB{}
+	CommandContains(ft, step, "helm uninstall")
+	if !ft.failed {
+		t.Errorf("CommandContains() of a missing command passed")
+	}
+}
+
+// fakeTB records the failures of the assertions under test.
+type fakeTB struct {
+	testing.TB
+	failed bool
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(string, ...interface{}) { f.failed = true }
@@ -0,0 +1,454 @@
+package cni
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/logger"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/cmdutil"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	supportBundle = "support-bundle"
+
+	// SupportBundleFile the bundle the bundle operations write into the operation dir of the node,
+	// the server downloads it from there.
+	SupportBundleFile = "support-bundle.tar.gz"
+	// DefaultSupportBundleMaxSize caps the bundle when the operation does not.
+	DefaultSupportBundleMaxSize = 200 << 20
+
+	supportBundleTimeout = 10 * time.Minute
+	// collectLogsSince the logs the collect-logs operation collects by default.
+	collectLogsSince = 30 * time.Minute
+	// collectLogsLimitBytes caps the log of every container, the bundle cap leaves out the logs over it.
+	collectLogsLimitBytes = 20 << 20
+	// collectLogsPodTimeout and collectLogsTimeout bound the log reads of a pod and of a component, a wedged node
+	// only times the logs of its pods out. The components together stay within supportBundleTimeout.
+	collectLogsPodTimeout = time.Minute
+	collectLogsTimeout    = 3 * time.Minute
+	// collectLogsConcurrency the pods whose logs are read at once.
+	collectLogsConcurrency = 10
+	// supportBundleSkipped lists the collected files left out of the bundle to stay below its size cap.
+	supportBundleSkipped = "SKIPPED"
+	redactedValue        = "<redacted>"
+)
+
+func init() {
+	if err := component.RegisterAgentStep(fmt.Sprintf(component.RegisterStepKeyFormat,
+		cniInfo+"-"+supportBundle, version, component.TypeStep), &SupportBundleCollector{}); err != nil {
+		panic(err)
+	}
+}
+
+// SupportBundleCollector collects a diagnostic bundle: Command, or Fallback when Command fails, writes the
+// diagnostics into the directory $BUNDLE_DIR, Files are copied next to them. The zip archives are extracted
+// and the secrets stripped from the manifests before everything is archived into SupportBundleFile.
+type SupportBundleCollector struct {
+	Command  string   `json:"command"`
+	Fallback string   `json:"fallback,omitempty"`
+	Files    []string `json:"files,omitempty"`
+	MaxSize  int64    `json:"maxSize"`
+}
+
+func (c *SupportBundleCollector) NewInstance() component.ObjectMeta {
+	return &SupportBundleCollector{}
+}
+
+func (c *SupportBundleCollector) Install(ctx context.Context, opts component.Options) ([]byte, error) {
+	if opts.DryRun {
+		return nil, nil
+	}
+	oplog := component.GetOplog(ctx)
+	if oplog == nil {
+		return nil, fmt.Errorf("the support bundle requires the operation log")
+	}
+	opDir, err := oplog.GetOperationDir(component.GetOperationID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "kc-support-bundle-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err = c.collect(ctx, dir); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(opDir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(filepath.Join(opDir, SupportBundleFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err = writeSupportBundle(dir, f, c.MaxSize); err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	_, _ = cmdutil.CheckContextAndAppendStepLogFile(ctx, []byte(fmt.Sprintf("[%s] + collected the support bundle %s, %d bytes\n\n",
+		time.Now().Format(time.RFC3339), SupportBundleFile, stat.Size())))
+	return nil, nil
+}
+
+func (c *SupportBundleCollector) Uninstall(ctx context.Context, opts component.Options) ([]byte, error) {
+	return nil, nil
+}
+
+// collect runs the collector commands and copies Files into dir, then prepares dir to be archived.
+func (c *SupportBundleCollector) collect(ctx context.Context, dir string) error {
+	if err := c.run(ctx, dir, c.Command); err != nil {
+		if c.Fallback == "" {
+			return err
+		}
+		logger.Warnf("collect the support bundle failed, run the fallback collector: %s", err.Error())
+		if err = c.run(ctx, dir, c.Fallback); err != nil {
+			return err
+		}
+	}
+	for _, file := range c.Files {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(filepath.Join(dir, "files", filepath.Base(file)), data, 0600); err != nil {
+			return err
+		}
+	}
+	if err := extractZips(dir); err != nil {
+		return err
+	}
+	return redactDir(dir)
+}
+
+func (c *SupportBundleCollector) run(ctx context.Context, dir, command string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0755); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "/bin/bash", "-c", command)
+	cmd.Env = append(os.Environ(), "BUNDLE_DIR="+dir)
+	out, err := cmd.CombinedOutput()
+	_, _ = cmdutil.CheckContextAndAppendStepLogFile(ctx, []byte(fmt.Sprintf("[%s] + %s\n%s\n", time.Now().Format(time.RFC3339), command, out)))
+	if err != nil {
+		return fmt.Errorf("run the support bundle collector: %w", err)
+	}
+	return nil
+}
+
+// extractZips extracts the zip archives of dir, e.g. cilium sysdump, so that their manifests are redacted.
+func extractZips(dir string) error {
+	archives, err := filepath.Glob(filepath.Join(dir, "*.zip"))
+	if err != nil {
+		return err
+	}
+	for _, archive := range archives {
+		if err = extractZip(archive, strings.TrimSuffix(archive, ".zip")); err != nil {
+			return err
+		}
+		if err = os.Remove(archive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZip(archive, dst string) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		path := filepath.Join(dst, f.Name)
+		if !strings.HasPrefix(path, filepath.Clean(dst)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid file %s in %s", f.Name, archive)
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err = extractZipFile(f, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipFile(f *zip.File, path string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// redactDir strips the secrets from the yaml and json manifests of dir.
+func redactDir(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		var redact func([]byte) []byte
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			redact = redactYAML
+		case ".json":
+			redact = redactJSON
+		default:
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, redact(data), info.Mode())
+	})
+}
+
+// redactYAML strips the secrets from the documents of data, the documents which are not yaml are kept as they are.
+func redactYAML(data []byte) []byte {
+	docs := strings.Split(string(data), "\n---")
+	for i, doc := range docs {
+		var v interface{}
+		if err := yaml.Unmarshal([]byte(doc), &v); err != nil || v == nil {
+			continue
+		}
+		out, err := yaml.Marshal(redactValue(v))
+		if err != nil {
+			continue
+		}
+		if i > 0 {
+			out = append([]byte("\n"), out...)
+		}
+		docs[i] = string(out)
+	}
+	return []byte(strings.Join(docs, "\n---"))
+}
+
+func redactJSON(data []byte) []byte {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return data
+	}
+	out, err := json.MarshalIndent(redactValue(v), "", "  ")
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+// redactValue replaces the data of the secrets, the values of the password, token and private key fields and
+// the PEM private keys.
+func redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		secret := value["kind"] == "Secret"
+		for k, field := range value {
+			switch {
+			case secret && (k == "data" || k == "stringData"):
+				if data, ok := field.(map[string]interface{}); ok {
+					for key := range data {
+						data[key] = redactedValue
+					}
+				}
+			case sensitiveField(k):
+				if s, ok := field.(string); ok && s != "" {
+					value[k] = redactedValue
+				}
+			default:
+				value[k] = redactValue(field)
+			}
+		}
+		// the last applied configuration annotation repeats the whole manifest
+		if metadata, ok := value["metadata"].(map[string]interface{}); ok && secret {
+			if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+				delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+			}
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = redactValue(value[i])
+		}
+	case string:
+		if strings.Contains(value, "PRIVATE KEY-----") {
+			return redactedValue
+		}
+	}
+	return v
+}
+
+func sensitiveField(name string) bool {
+	name = strings.ToLower(name)
+	return strings.Contains(name, "password") || strings.Contains(name, "token") ||
+		strings.HasSuffix(name, ".key") || strings.HasSuffix(name, "privatekey")
+}
+
+// writeSupportBundle archives dir into w, the files which would take the archive over maxSize are left out and
+// listed in supportBundleSkipped instead.
+func writeSupportBundle(dir string, w io.Writer, maxSize int64) error {
+	if maxSize <= 0 {
+		maxSize = DefaultSupportBundleMaxSize
+	}
+	counter := &countingWriter{w: w}
+	gw := gzip.NewWriter(counter)
+	tw := tar.NewWriter(gw)
+	var skipped []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if err = gw.Flush(); err != nil {
+			return err
+		}
+		// the gzip and tar headers and trailers are far below the reserved 64KiB
+		if counter.n+info.Size()+64<<10 > maxSize {
+			skipped = append(skipped, name)
+			return nil
+		}
+		return addTarFile(tw, path, name, info)
+	})
+	if err != nil {
+		return err
+	}
+	if len(skipped) > 0 {
+		list := []byte(strings.Join(skipped, "\n") + "\n")
+		if err = tw.WriteHeader(&tar.Header{Name: supportBundleSkipped, Mode: 0644, Size: int64(len(list)), ModTime: time.Now()}); err != nil {
+			return err
+		}
+		if _, err = tw.Write(list); err != nil {
+			return err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func addTarFile(tw *tar.Writer, path, name string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(name)
+	if err = tw.WriteHeader(header); err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(tw, f, info.Size())
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ciliumSysdump the bundle operation of cilium: cilium sysdump when the cilium CLI is installed, otherwise the
+// logs, the cilium resources and the bpf maps of one agent. The rendered values are added to both.
+func (runnable *CiliumRunnable) ciliumSysdump(namespace string) Operation {
+	agent := fmt.Sprintf("kubectl -n %s exec ds/cilium -c cilium-agent --", namespace)
+	collect := []string{
+		fmt.Sprintf("kubectl -n %s logs -l k8s-app=cilium -c cilium-agent --prefix --max-log-requests=%d > \"$BUNDLE_DIR/cilium-agent.log\" 2>&1",
+			namespace, operationMaxLogRequests),
+		fmt.Sprintf("kubectl -n %s logs -l io.cilium/app=operator --prefix > \"$BUNDLE_DIR/cilium-operator.log\" 2>&1", namespace),
+		fmt.Sprintf("kubectl -n %s get all,cm -o yaml > \"$BUNDLE_DIR/cilium-resources.yaml\" 2>&1", namespace),
+		"kubectl get ciliumnodes.cilium.io -o yaml > \"$BUNDLE_DIR/ciliumnodes.yaml\" 2>&1",
+		"kubectl get ciliumendpoints.cilium.io -A -o yaml > \"$BUNDLE_DIR/ciliumendpoints.yaml\" 2>&1",
+		fmt.Sprintf("%s cilium status --verbose > \"$BUNDLE_DIR/cilium-status.txt\" 2>&1", agent),
+	}
+	for _, m := range []string{"lb list", "ct list global", "nat list", "ipcache list", "endpoint list", "policy get --all", "tunnel list"} {
+		collect = append(collect, fmt.Sprintf("%s cilium bpf %s > \"$BUNDLE_DIR/bpf-%s.txt\" 2>&1",
+			agent, m, strings.Fields(m)[0]))
+	}
+	// the fallback fails when the cluster is unreachable, the single collectors may fail
+	collect = append(collect, fmt.Sprintf("kubectl -n %s get ds cilium >/dev/null", namespace))
+	// the values rendered before ManifestDir was added are overwritten by the current ones when there are both
+	files := []string{filepath.Join(manifestDir, "cilium.yaml")}
+	if values := runnable.manifestPath("cilium.yaml"); values != files[0] {
+		files = append(files, values)
+	}
+	return Operation{
+		Name:        OperationSysdump,
+		Description: "Collect a support bundle of cilium: cilium sysdump, or the logs, the cilium resources and the bpf maps of one agent.",
+		Command:     fmt.Sprintf("command -v cilium >/dev/null 2>&1 && cilium sysdump -n %s --output-filename \"$BUNDLE_DIR/cilium-sysdump\"", namespace),
+		Fallback:    strings.Join(collect, "; "),
+		Files:       files,
+		Output:      OperationOutputBundle,
+	}
+}
+
+// ciliumCollectLogs the bundle operation collecting the last collectLogsSince of the logs of the cilium agents,
+// the operator and hubble relay, one file per pod, OperationOptions Since changes the window. The logs of the pods
+// not read in time are listed in the ERRORS file of the bundle instead of failing the operation.
+func ciliumCollectLogs(namespace string) Operation {
+	return Operation{
+		Name: OperationCollectLogs,
+		Description: fmt.Sprintf("Collect the logs of the last %s of the cilium agents, the operator and hubble relay into a bundle.",
+			collectLogsSince),
+		Command: collectLogsCommand(namespace, collectLogsSince),
+		Output:  OperationOutputBundle,
+		sinceCommand: func(since time.Duration) string {
+			return collectLogsCommand(namespace, since)
+		},
+	}
+}
+
+// collectLogsCommand reads the logs of the last since of the cilium components into the bundle directory.
+func collectLogsCommand(namespace string, since time.Duration) string {
+	components := []struct{ selector, container string }{
+		{"k8s-app=cilium", "cilium-agent"},
+		{"io.cilium/app=operator", "cilium-operator"},
+		{"k8s-app=hubble-relay", "hubble-relay"},
+	}
+	collect := make([]string, 0, len(components))
+	for _, c := range components {
+		read := fmt.Sprintf(`timeout -k 5 %[1]d kubectl -n %[2]s logs "$0" -c %[3]s --since=%[4]s --limit-bytes=%[5]d --request-timeout=%[1]ds > "$BUNDLE_DIR/%[3]s-${0#pod/}.log" 2>&1 || echo "$0: the logs were not read" >> "$BUNDLE_DIR/ERRORS"`,
+			int(collectLogsPodTimeout.Seconds()), namespace, c.container, since, collectLogsLimitBytes)
+		collect = append(collect, fmt.Sprintf(`kubectl -n %s get po -l %s -o name --request-timeout=30s | timeout -k 5 %d xargs -r -P %d -n 1 bash -c '%s' || echo "%s: the logs were not all collected within %s" >> "$BUNDLE_DIR/ERRORS"`,
+			namespace, c.selector, int(collectLogsTimeout.Seconds()), collectLogsConcurrency, read, c.container, collectLogsTimeout))
+	}
+	return strings.Join(collect, "\n")
+}
@@ -0,0 +1,79 @@
+package cni
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// TemplateOverridesConfigMap the ConfigMap the overrides of the registered cni templates are stored in,
+// the data key of an override is TemplateDataKey of the template key.
+const TemplateOverridesConfigMap = "cni-template-overrides"
+
+// CiliumTemplateKey the key the cilium values template is registered under.
+var CiliumTemplateKey = fmt.Sprintf(component.RegisterTemplateKeyFormat, cniInfo+"-cilium", version, component.TypeTemplate)
+
+// OverridableTemplate a registered cni template which users may replace with their own template text.
+type OverridableTemplate interface {
+	// DefaultTemplate returns the built-in template text.
+	DefaultTemplate() string
+	// ValidateTemplate parses text and renders it against a sample configuration.
+	ValidateTemplate(text string) error
+}
+
+// LoadOverridableTemplate returns the template registered under key if it can be overridden.
+func LoadOverridableTemplate(key string) (OverridableTemplate, error) {
+	t, ok := component.LoadTemplate(key)
+	if !ok {
+		return nil, fmt.Errorf("template %s is not registered", key)
+	}
+	o, ok := t.(OverridableTemplate)
+	if !ok {
+		return nil, fmt.Errorf("template %s can not be overridden", key)
+	}
+	return o, nil
+}
+
+// TemplateDataKey the ConfigMap data key of the template key, the data keys can not contain slashes.
+func TemplateDataKey(key string) string {
+	return strings.ReplaceAll(key, "/", ".")
+}
+
+func (runnable *CiliumRunnable) DefaultTemplate() string {
+	return ciliumValuesTemplate
+}
+
+// ValidateTemplate renders text against a cilium with hubble and encryption enabled,
+// the rendered values must be valid YAML.
+func (runnable *CiliumRunnable) ValidateTemplate(text string) error {
+	sample := (&CiliumRunnable{}).InitStep(&component.ExtraMetadata{
+		CRI:     v1.CRIContainerd,
+		Masters: component.NodeList{{ID: "sample", Hostname: "sample", IPv4: "10.0.0.1"}},
+	}, &v1.CNI{
+		Type:          "cilium",
+		Version:       "1.14.4",
+		LocalRegistry: "registry.example.com:5000",
+		Cilium: &v1.Cilium{
+			OperatorReplicas:           1,
+			ClusterPoolIPv4PodCIDRList: []string{ciliumDefaultIPv4PodCIDR},
+			ClusterPoolIPv4MaskSize:    ciliumDefaultIPv4MaskSize,
+			EnableHubble:               true,
+			EnableHubbleRelay:          true,
+			EnableHubbleUI:             true,
+			Encryption:                 &v1.CiliumEncryption{Type: CiliumEncryptionWireguard},
+		},
+	}, &v1.Networking{}).(*CiliumRunnable)
+	sample.ValuesTemplate = text
+	values, err := sample.RenderString(context.TODO())
+	if err != nil {
+		return fmt.Errorf("render cilium template failed: %v", err)
+	}
+	if err = yaml.Unmarshal([]byte(values), &map[string]interface{}{}); err != nil {
+		return fmt.Errorf("the rendered cilium values are not valid YAML: %v", err)
+	}
+	return nil
+}
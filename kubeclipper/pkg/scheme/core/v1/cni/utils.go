@@ -2,6 +2,8 @@ package cni
 
 import (
 	"fmt"
+	"net"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -10,14 +12,15 @@ import (
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
 	"github.com/kubeclipper/kubeclipper/pkg/utils/strutil"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
 )
 
-func LoadImage(name string, custom []byte, nodes []v1.StepNode) v1.Step {
+func LoadImage(name string, custom []byte, nodes []v1.StepNode, timeout time.Duration) v1.Step {
 	return v1.Step{
 
-		ID:         strutil.GetUUID(),
+		ID:         newStepID(),
 		Name:       "cniImageLoader",
-		Timeout:    metav1.Duration{Duration: 5 * time.Minute},
+		Timeout:    metav1.Duration{Duration: timeout},
 		ErrIgnore:  false,
 		RetryTimes: 1,
 		Nodes:      nodes,
@@ -32,9 +35,51 @@ func LoadImage(name string, custom []byte, nodes []v1.StepNode) v1.Step {
 	}
 }
 
+// ArchNodes the nodes of an architecture.
+type ArchNodes struct {
+	Arch  string
+	Nodes []v1.StepNode
+}
+
+// GroupNodesByArch groups the nodes by architecture in the order the architectures first appear,
+// the nodes without a known architecture are grouped under an empty one.
+func GroupNodesByArch(nodes []v1.StepNode) []ArchNodes {
+	var groups []ArchNodes
+	index := make(map[string]int)
+	for _, node := range nodes {
+		i, ok := index[node.Arch]
+		if !ok {
+			i = len(groups)
+			index[node.Arch] = i
+			groups = append(groups, ArchNodes{Arch: node.Arch})
+		}
+		groups[i].Nodes = append(groups[i].Nodes, node)
+	}
+	return groups
+}
+
+// loadImageSteps generates a LoadImage step per architecture of nodes, render marshals the cni for an architecture.
+// A single architecture keeps the step name of LoadImage.
+func loadImageSteps(name string, nodes []v1.StepNode, timeout time.Duration, render func(arch string) ([]byte, error)) ([]v1.Step, error) {
+	groups := GroupNodesByArch(nodes)
+	steps := make([]v1.Step, 0, len(groups))
+	for _, group := range groups {
+		custom, err := render(group.Arch)
+		if err != nil {
+			return nil, err
+		}
+		step := LoadImage(name, custom, group.Nodes, timeout)
+		if len(groups) > 1 {
+			step.Name = fmt.Sprintf("%s-%s", step.Name, strutil.StringDefaultIfEmpty("unknown", group.Arch))
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
 func RenderYaml(name string, custom []byte, nodes []v1.StepNode) v1.Step {
 	return v1.Step{
-		ID:         strutil.GetUUID(),
+		ID:         newStepID(),
 		Name:       "renderCniYaml",
 		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
 		ErrIgnore:  false,
@@ -49,12 +94,17 @@ func RenderYaml(name string, custom []byte, nodes []v1.StepNode) v1.Step {
 				},
 			},
 		},
+		ErrorMatchers: []v1.StepErrorMatcher{{
+			Category: v1.StepErrorValidationFailed,
+			Pattern:  "render template error",
+			Hint:     "the cni values failed to render, check the cni configuration and the custom values template",
+		}},
 	}
 }
 
 func ApplyYaml(yamlName string, nodes []v1.StepNode) v1.Step {
 	return v1.Step{
-		ID:         strutil.GetUUID(),
+		ID:         newStepID(),
 		Name:       "applyCniYaml",
 		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
 		ErrIgnore:  false,
@@ -69,42 +119,133 @@ func ApplyYaml(yamlName string, nodes []v1.StepNode) v1.Step {
 	}
 }
 
-func RemoveImage(name string, custom []byte, nodes []v1.StepNode) v1.Step {
+// DeleteYaml deletes the resources of the applied manifest yamlName and waits for them to be gone,
+// nothing is done when the manifest was not rendered.
+func DeleteYaml(yamlName string, nodes []v1.StepNode, timeout time.Duration) v1.Step {
 	return v1.Step{
-		ID:         strutil.GetUUID(),
-		Name:       "removeCniImage",
-		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
+		ID:         newStepID(),
+		Name:       "deleteCniYaml",
+		Timeout:    metav1.Duration{Duration: timeout},
 		ErrIgnore:  false,
 		RetryTimes: 1,
 		Nodes:      nodes,
 		Action:     v1.ActionUninstall,
 		Commands: []v1.Command{
 			{
-				Type:          v1.CommandCustom,
-				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+name, version, component.TypeStep),
-				CustomCommand: custom,
+				Type: v1.CommandShell,
+				ShellCommand: []string{"/bin/bash", "-c",
+					fmt.Sprintf("[ -f %[1]s ] || exit 0; kubectl delete -f %[1]s --ignore-not-found --wait --timeout=%[2]s", yamlName, timeout)},
 			},
 		},
 	}
 }
 
-func InstallCalicoRelease(chartPath string, yamlName string, nodes []v1.StepNode) v1.Step {
+// RemoveManifests removes the manifests or helm values RenderYaml rendered on the nodes, the names are files or
+// directories relative to manifestDir.
+func RemoveManifests(nodes []v1.StepNode, names ...string) v1.Step {
+	cmd := []string{"rm", "-rf"}
+	for _, name := range names {
+		cmd = append(cmd, filepath.Join(manifestDir, name))
+	}
 	return v1.Step{
-		ID:         strutil.GetUUID(),
-		Name:       "installCalicoRelease",
+		ID:         newStepID(),
+		Name:       "removeCniManifests",
+		Timeout:    metav1.Duration{Duration: 10 * time.Second},
+		ErrIgnore:  true,
+		RetryTimes: 1,
+		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
+		Commands: []v1.Command{
+			{
+				Type:         v1.CommandShell,
+				ShellCommand: cmd,
+			},
+		},
+	}
+}
+
+// WithRollback registers rollback on the first of steps, the install of a component. The operation engine runs
+// rollback once the last of steps completed and a later step of the operation failed, a retry of the operation
+// then restarts from the first of steps.
+func WithRollback(steps, rollback []v1.Step) []v1.Step {
+	if len(steps) == 0 || len(rollback) == 0 {
+		return steps
+	}
+	steps[0].RollbackSteps = rollback
+	steps[0].RollbackAfter = steps[len(steps)-1].ID
+	return steps
+}
+
+func RemoveImage(name string, custom []byte, nodes []v1.StepNode) v1.Step {
+	return v1.Step{
+		ID:         newStepID(),
+		Name:       "removeCniImage",
 		Timeout:    metav1.Duration{Duration: 1 * time.Minute},
 		ErrIgnore:  false,
 		RetryTimes: 1,
 		Nodes:      nodes,
+		Action:     v1.ActionUninstall,
 		Commands: []v1.Command{
 			{
-				Type:         v1.CommandShell,
-				ShellCommand: []string{"helm", "upgrade", "--install", "--create-namespace", "calico", "-n", "calico-system", chartPath, "-f", yamlName},
+				Type:          v1.CommandCustom,
+				Identity:      fmt.Sprintf(component.RegisterStepKeyFormat, cniInfo+"-"+name, version, component.TypeStep),
+				CustomCommand: custom,
 			},
 		},
 	}
 }
 
+func InstallCalicoRelease(chartPath string, yamlName string, nodes []v1.StepNode, opts HelmReleaseOptions) v1.Step {
+	step := InstallHelmRelease("installCalicoRelease", "calico", "calico-system", chartPath, yamlName, nodes, opts)
+	if opts.Timeout == 0 {
+		// calico never waited on the release, keep its shorter step timeout
+		step.Timeout = metav1.Duration{Duration: calicoReleaseStepTimeout}
+	}
+	return step
+}
+
+// ImageRemoveCommand returns the command removing the images whose repository starts with repoPrefix from the container runtime.
+func ImageRemoveCommand(criType, repoPrefix string) ([]string, error) {
+	var cli string
+	switch criType {
+	case v1.CRIContainerd:
+		cli = "nerdctl --namespace k8s.io"
+	case v1.CRIDocker:
+		cli = "docker"
+	default:
+		return nil, fmt.Errorf("unsupported cri type %q", criType)
+	}
+	return []string{"/bin/bash", "-c",
+		fmt.Sprintf(`%[1]s images --format '{{.Repository}}:{{.Tag}}' | awk -v prefix='%[2]s' 'index($0, prefix) == 1' | xargs -r %[1]s rmi`, cli, repoPrefix)}, nil
+}
+
+// CheckUpgradeVersion rejects the cni version changes which are not upgrades.
+func CheckUpgradeVersion(fromVersion, toVersion string) error {
+	from, err := utilversion.ParseGeneric(fromVersion)
+	if err != nil {
+		return fmt.Errorf("invalid cni version %q: %w", fromVersion, err)
+	}
+	to, err := utilversion.ParseGeneric(toVersion)
+	if err != nil {
+		return fmt.Errorf("invalid cni version %q: %w", toVersion, err)
+	}
+	if to.LessThan(from) {
+		return fmt.Errorf("downgrade cni from %s to %s is not supported", fromVersion, toVersion)
+	}
+	if !from.LessThan(to) {
+		return fmt.Errorf("cni version %s is already installed", toVersion)
+	}
+	return nil
+}
+
+// CheckMigration rejects the cni type changes which have no migration, only calico can be migrated to cilium.
+func CheckMigration(fromType, toType string) error {
+	if fromType != "calico" || toType != "cilium" {
+		return fmt.Errorf("migrating cni from %s to %s is not supported", fromType, toType)
+	}
+	return nil
+}
+
 func IsHighKubeVersion(kubeVersion string) bool {
 	if kubeVersion == "" {
 		return false
@@ -154,3 +295,70 @@ func ParseNodeAddressDetection(nodeAddressDetection string) NodeAddressDetection
 		}
 	}
 }
+
+// SplitPodCIDRs returns the first IPv4 and the first IPv6 pod CIDR of networking.
+func SplitPodCIDRs(networking *v1.Networking) (ipv4, ipv6 string) {
+	if networking == nil {
+		return
+	}
+	for _, cidr := range networking.Pods.CIDRBlocks {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if ip.To4() != nil {
+			if ipv4 == "" {
+				ipv4 = cidr
+			}
+			continue
+		}
+		if ipv6 == "" {
+			ipv6 = cidr
+		}
+	}
+	return
+}
+
+// ValidatePodCIDRPool checks the pod CIDR pool from which every node gets a podCIDR of maskSize:
+// the pool must be valid CIDRs, must not overlap the service CIDRs and node addresses,
+// and must be large enough for nodeCount nodes.
+func ValidatePodCIDRPool(pool []string, maskSize int, serviceCIDRs, nodeIPs []string, nodeCount int) error {
+	var services []*net.IPNet
+	for _, cidr := range serviceCIDRs {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			services = append(services, ipNet)
+		}
+	}
+	capacity := 0
+	for _, cidr := range pool {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid pod CIDR %q: %w", cidr, err)
+		}
+		prefix, bits := ipNet.Mask.Size()
+		if maskSize <= prefix || maskSize > bits {
+			return fmt.Errorf("pod CIDR mask size %d must be larger than the prefix length of %s and at most %d", maskSize, cidr, bits)
+		}
+		for _, svc := range services {
+			if ipNet.Contains(svc.IP) || svc.Contains(ipNet.IP) {
+				return fmt.Errorf("pod CIDR %s overlaps the service CIDR %s", cidr, svc)
+			}
+		}
+		for _, ip := range nodeIPs {
+			if nodeIP := net.ParseIP(ip); nodeIP != nil && ipNet.Contains(nodeIP) {
+				return fmt.Errorf("pod CIDR %s overlaps the node address %s", cidr, ip)
+			}
+		}
+		if capacity < nodeCount {
+			if n := maskSize - prefix; n >= 31 {
+				capacity = nodeCount
+			} else {
+				capacity += 1 << n
+			}
+		}
+	}
+	if len(pool) > 0 && capacity < nodeCount {
+		return fmt.Errorf("pod CIDRs %v with mask size %d can only allocate %d nodes, the cluster has %d nodes", pool, maskSize, capacity, nodeCount)
+	}
+	return nil
+}
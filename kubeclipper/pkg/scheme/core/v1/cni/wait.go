@@ -0,0 +1,94 @@
+package cni
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// waitForInterval the delay between the polls of the waits.
+	waitForInterval = 2 * time.Second
+	// crdWaitTimeout the time the cilium operator takes to register its CRDs at most.
+	crdWaitTimeout = 2 * time.Minute
+)
+
+// WaitForSupported whether the agents of all nodes run the v1.CommandWaitFor commands, the waits fall back to
+// kubectl when an agent does not advertise it.
+func WaitForSupported(nodes []v1.StepNode) bool {
+	if len(nodes) == 0 {
+		return false
+	}
+	for _, node := range nodes {
+		if !node.WaitFor {
+			return false
+		}
+	}
+	return true
+}
+
+// waitForCommand the v1.CommandWaitFor command of wait, it connects with kubeconfig, see waitKubeconfig.
+func waitForCommand(wait v1.WaitForCommand, kubeconfig string) v1.Command {
+	if wait.Interval.Duration == 0 {
+		wait.Interval = metav1.Duration{Duration: waitForInterval}
+	}
+	return v1.Command{
+		Type:    v1.CommandWaitFor,
+		WaitFor: &wait,
+		Env:     []v1.EnvVar{{Name: "KUBECONFIG", Value: kubeconfig}},
+	}
+}
+
+// waitKubeconfig the kubeconfig the waits of cilium connect with, the one of the helm commands of the release so
+// that they reach the kube-apiserver at the same endpoint, see InstallCiliumRelease.
+func (runnable *CiliumRunnable) waitKubeconfig() string {
+	return helmReleaseKubeconfig(runnable.ReleaseName(), runnable.apiServerEndpoint)
+}
+
+// writeWaitKubeconfig the commands writing waitKubeconfig before the waits run on nodes, the step may run on a node
+// the release was not installed from, e.g. after a failover.
+func (runnable *CiliumRunnable) writeWaitKubeconfig(nodes []v1.StepNode) []v1.Command {
+	if runnable.apiServerEndpoint == "" || !WaitForSupported(nodes) {
+		return nil
+	}
+	return []v1.Command{writeHelmKubeconfig(runnable.waitKubeconfig(), runnable.apiServerEndpoint)}
+}
+
+// rolloutCommand waits for the rollout of the workload name of kind in namespace.
+func rolloutCommand(kind, name, namespace string, timeout time.Duration, kubeconfig string) v1.Command {
+	return waitForCommand(v1.WaitForCommand{
+		Kind:      kind,
+		Name:      name,
+		Namespace: namespace,
+		Condition: v1.WaitConditionRolloutComplete,
+		Timeout:   metav1.Duration{Duration: timeout},
+	}, kubeconfig)
+}
+
+// applyAfterCRDs the commands applying manifest once crds are established, the cilium operator registers them
+// after the release is installed. The waits connect with kubeconfig, the agents without v1.CommandWaitFor poll for
+// the CRDs with kubectl.
+func applyAfterCRDs(nodes []v1.StepNode, kubeconfig, manifest string, crds ...string) []v1.Command {
+	apply := v1.Command{
+		Type:         v1.CommandShell,
+		ShellCommand: []string{"/bin/bash", "-c", fmt.Sprintf("kubectl apply -f - <<'EOF'\n%sEOF", manifest)},
+	}
+	if !WaitForSupported(nodes) {
+		apply.ShellCommand[2] = fmt.Sprintf("for i in $(seq 60); do kubectl get crd %s >/dev/null 2>&1 && break; sleep 2; done; %s",
+			strings.Join(crds, " "), apply.ShellCommand[2])
+		return []v1.Command{apply}
+	}
+	cmds := make([]v1.Command, 0, len(crds)+1)
+	for _, crd := range crds {
+		cmds = append(cmds, waitForCommand(v1.WaitForCommand{
+			Kind:      "CustomResourceDefinition",
+			Name:      crd,
+			Condition: v1.WaitConditionEstablished,
+			Timeout:   metav1.Duration{Duration: crdWaitTimeout},
+		}, kubeconfig))
+	}
+	return append(cmds, apply)
+}
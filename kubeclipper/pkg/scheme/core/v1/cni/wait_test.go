@@ -0,0 +1,104 @@
+package cni
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func TestCiliumRunnable_checkReadyWaitFor(t *testing.T) {
+	metadata := &component.ExtraMetadata{
+		CRI:     v1.CRIContainerd,
+		Masters: component.NodeList{{ID: "node1", Hostname: "master1", WaitFor: true}},
+	}
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Type: "cilium", Version: "1.14.4", Cilium: &v1.Cilium{EnableDNSProxy: true}},
+		&v1.Networking{Pods: v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}}})
+	steps, err := stepper.InstallSteps(nil, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	check := stepByName(steps, "checkCiliumReady")
+	if len(check.Commands) != 3 {
+		t.Fatalf("checkCiliumReady commands = %+v, want the two rollout waits and the DNS probe", check.Commands)
+	}
+	for i, want := range []struct{ kind, name string }{{"DaemonSet", "cilium"}, {"Deployment", "cilium-operator"}} {
+		cmd := check.Commands[i]
+		if cmd.Type != v1.CommandWaitFor || cmd.WaitFor == nil {
+			t.Fatalf("checkCiliumReady command %d = %+v, want a wait", i, cmd)
+		}
+		if cmd.WaitFor.Kind != want.kind || cmd.WaitFor.Name != want.name || cmd.WaitFor.Namespace != "kube-system" ||
+			cmd.WaitFor.Condition != v1.WaitConditionRolloutComplete || cmd.WaitFor.Timeout.Duration != ciliumDefaultReadinessTimeout {
+			t.Errorf("checkCiliumReady wait %d = %+v, want the rollout of %s %s", i, cmd.WaitFor, want.kind, want.name)
+		}
+	}
+	if probe := check.Commands[2]; probe.Type != v1.CommandShell || probe.ShellCommand[2] != stepper.(*CiliumRunnable).dnsProbeScript() {
+		t.Errorf("checkCiliumReady command 2 = %+v, want the DNS probe", probe)
+	}
+	if len(check.BeforeRunCommands) != 0 || check.Commands[0].Env[0].Value != helmKubeconfig {
+		t.Errorf("checkCiliumReady = %+v, want the waits connecting with %s", check, helmKubeconfig)
+	}
+}
+
+func TestCiliumRunnable_waitKubeconfig(t *testing.T) {
+	metadata := &component.ExtraMetadata{
+		CRI:               v1.CRIContainerd,
+		Masters:           component.NodeList{{ID: "node1", Hostname: "master1", WaitFor: true}},
+		APIServerEndpoint: "lb.example.com:8443",
+	}
+	stepper := (&CiliumRunnable{}).InitStep(metadata, &v1.CNI{Type: "cilium", Version: "1.14.4", Cilium: &v1.Cilium{
+		BGP: &v1.CiliumBGP{Enabled: true, Peerings: []v1.CiliumBGPPeering{{PeerAddress: "10.0.0.1", PeerASN: 65000, LocalASN: 65010}}}}},
+		&v1.Networking{Pods: v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}}})
+	steps, err := stepper.InstallSteps(nil, "v1.27.4")
+	if err != nil {
+		t.Fatalf("InstallSteps() error = %v", err)
+	}
+	// the waits reach the kube-apiserver at the endpoint of the release, they write its kubeconfig on their node
+	kubeconfig := helmHome + "/cilium.kubeconfig"
+	for _, name := range []string{"checkCiliumReady", "applyCiliumBGPPolicy"} {
+		step := stepByName(steps, name)
+		if len(step.BeforeRunCommands) != 1 || !strings.Contains(step.BeforeRunCommands[0].ShellCommand[2], "--server https://lb.example.com:8443") {
+			t.Errorf("%s before run commands = %+v, want the kubeconfig of the endpoint written", name, step.BeforeRunCommands)
+		}
+		var waits int
+		for _, cmd := range step.Commands {
+			if cmd.Type != v1.CommandWaitFor {
+				continue
+			}
+			waits++
+			if !reflect.DeepEqual(cmd.Env, []v1.EnvVar{{Name: "KUBECONFIG", Value: kubeconfig}}) {
+				t.Errorf("%s wait env = %v, want KUBECONFIG %s", name, cmd.Env, kubeconfig)
+			}
+		}
+		if waits == 0 {
+			t.Errorf("%s has no waits", name)
+		}
+	}
+}
+
+func TestApplyAfterCRDs(t *testing.T) {
+	crds := []string{"a.cilium.io", "b.cilium.io"}
+	cmds := applyAfterCRDs([]v1.StepNode{{ID: "node1", WaitFor: true}}, helmKubeconfig, "kind: A\n", crds...)
+	if len(cmds) != 3 {
+		t.Fatalf("applyAfterCRDs() = %+v, want a wait for each CRD and the apply", cmds)
+	}
+	for i, crd := range crds {
+		wait := cmds[i].WaitFor
+		if cmds[i].Type != v1.CommandWaitFor || wait == nil || wait.Name != crd || wait.Condition != v1.WaitConditionEstablished ||
+			wait.Interval.Duration != 2*time.Second || !reflect.DeepEqual(cmds[i].Env, []v1.EnvVar{{Name: "KUBECONFIG", Value: helmKubeconfig}}) {
+			t.Errorf("applyAfterCRDs() command %d = %+v, want %s established", i, cmds[i], crd)
+		}
+	}
+	if apply := cmds[2].ShellCommand[2]; apply != "kubectl apply -f - <<'EOF'\nkind: A\nEOF" {
+		t.Errorf("applyAfterCRDs() apply = %q", apply)
+	}
+
+	// one agent without the waits falls the step back to kubectl
+	cmds = applyAfterCRDs([]v1.StepNode{{ID: "node1", WaitFor: true}, {ID: "node2"}}, helmKubeconfig, "kind: A\n", crds...)
+	if len(cmds) != 1 || !strings.HasPrefix(cmds[0].ShellCommand[2], "for i in $(seq 60); do kubectl get crd a.cilium.io b.cilium.io ") {
+		t.Errorf("applyAfterCRDs() without the waits = %+v", cmds)
+	}
+}
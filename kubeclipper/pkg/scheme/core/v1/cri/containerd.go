@@ -285,7 +285,7 @@ func (runnable *ContainerdRunnable) renderRegistryConfig(dryRun bool) error {
 	}
 	regCfgs := ToContainerdRegistryConfig(runnable.Registies)
 	for _, cfg := range regCfgs {
-		if err := cfg.renderConfigs(runnable.RegistryConfigDir); err != nil {
+		if err := cfg.RenderConfigs(runnable.RegistryConfigDir); err != nil {
 			return err
 		}
 	}
@@ -312,7 +312,7 @@ func (c *ContainerdRegistryConfigure) Install(ctx context.Context, opts componen
 		}
 	}
 	for _, r := range c.Registries {
-		err := r.renderConfigs(c.ConfigDir)
+		err := r.RenderConfigs(c.ConfigDir)
 		if err != nil {
 			return nil, fmt.Errorf("renderConfigs to %s failed:%w", c.ConfigDir, err)
 		}
@@ -354,8 +354,8 @@ type ContainerdRegistry struct {
 	Hosts  []ContainerdHost
 }
 
-// generate hosts.toml and ca file
-func (h *ContainerdRegistry) renderConfigs(dir string) error {
+// RenderConfigs generates the hosts.toml and the ca files of the registry under dir.
+func (h *ContainerdRegistry) RenderConfigs(dir string) error {
 	hostDir := filepath.Join(dir, h.Server)
 	err := os.MkdirAll(hostDir, 0755)
 	if err != nil {
@@ -119,7 +119,7 @@ func TestContainerdRegistryRender(t *testing.T) {
 			},
 		},
 	}
-	err = r.renderConfigs(dir)
+	err = r.RenderConfigs(dir)
 	require.NoError(t, err)
 
 	cafile := filepath.Join(dir, "docker.io", "local2.registry.com.pem")
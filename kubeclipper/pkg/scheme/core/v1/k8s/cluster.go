@@ -127,6 +127,8 @@ type Recovery struct {
 	BackupFileSize     int64
 	BackupFileMD5      string
 	FileDir
+	// Cluster is only used to make the steps, it is not passed to the agent
+	Cluster *v1.Cluster `json:"-"`
 
 	installSteps []v1.Step
 }
@@ -680,6 +682,11 @@ func (stepper *Recovery) MakeInstallSteps(ctx context.Context, metadata *compone
 	if err != nil {
 		return err
 	}
+	preRestore, postRestore, err := cni.RestoreSteps(metadata, stepper.Cluster)
+	if err != nil {
+		return err
+	}
+	stepper.installSteps = append(stepper.installSteps, preRestore...)
 
 	stepper.installSteps = append(stepper.installSteps, v1.Step{
 		ID:         strutil.GetUUID(),
@@ -736,7 +743,7 @@ func (stepper *Recovery) MakeInstallSteps(ctx context.Context, metadata *compone
 		},
 	}
 
-	cmdList, err := cni.RecoveryCNICmd(metadata)
+	cmdList, err := cni.RecoveryCNICmd(metadata, stepper.Cluster)
 	if err == nil {
 		restart.Commands = append(restart.Commands, v1.Command{
 			Type:         v1.CommandShell,
@@ -745,8 +752,9 @@ func (stepper *Recovery) MakeInstallSteps(ctx context.Context, metadata *compone
 	}
 
 	restart.Commands = append(restart.Commands, v1.Command{
-		Type:         v1.CommandShell,
-		ShellCommand: []string{"/bin/bash", "-c", "kubectl rollout restart ds kube-proxy -n kube-system"},
+		Type: v1.CommandShell,
+		// kube-proxy is removed when the cni replaces it
+		ShellCommand: []string{"/bin/bash", "-c", "if kubectl get ds kube-proxy -n kube-system >/dev/null 2>&1; then kubectl rollout restart ds kube-proxy -n kube-system; fi"},
 	})
 
 	if err == nil {
@@ -774,6 +782,7 @@ func (stepper *Recovery) MakeInstallSteps(ctx context.Context, metadata *compone
 	}
 
 	stepper.installSteps = append(stepper.installSteps, restart)
+	stepper.installSteps = append(stepper.installSteps, postRestore...)
 	return nil
 }
 
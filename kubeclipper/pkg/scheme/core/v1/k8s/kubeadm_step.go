@@ -30,10 +30,12 @@ import (
 	"k8s.io/apimachinery/pkg/util/sets"
 	"sigs.k8s.io/yaml"
 
+	componentcommon "github.com/kubeclipper/kubeclipper/pkg/component/common"
 	"github.com/kubeclipper/kubeclipper/pkg/logger"
 	"github.com/kubeclipper/kubeclipper/pkg/scheme/common"
 	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 
 	"github.com/kubeclipper/kubeclipper/pkg/component"
 	"github.com/kubeclipper/kubeclipper/pkg/component/utils"
@@ -228,18 +230,45 @@ func (runnable *Runnable) makeInstallSteps(metadata *component.ExtraMetadata) ([
 		return nil, err
 	}
 	cniStepper := cf.Create().InitStep(metadata, &c.CNI, &c.Networking)
+	if err = cniStepper.Validate(); err != nil {
+		return nil, err
+	}
+	if err = cni.ValidateNetworkAttachments(&c.CNI); err != nil {
+		return nil, err
+	}
+	multus := cni.NewMultus(metadata, &c.CNI)
+	var cniSteps []v1.Step
 	if metadata.Offline {
 		steps, err = cniStepper.LoadImage(nodes)
 		if err != nil {
 			return nil, err
 		}
-		installSteps = append(installSteps, steps...)
+		cniSteps = append(cniSteps, steps...)
+		if multus != nil {
+			if steps, err = multus.LoadImage(nodes); err != nil {
+				return nil, err
+			}
+			cniSteps = append(cniSteps, steps...)
+		}
 	}
 	steps, err = cniStepper.InstallSteps([]v1.StepNode{masters[0]}, runnable.KubernetesVersion)
 	if err != nil {
 		return nil, err
 	}
-	installSteps = append(installSteps, steps...)
+	cniSteps = append(cniSteps, steps...)
+	// the cni is rolled back when a later step fails, helm refuses to install the release again otherwise
+	rollback, err := cniStepper.RollbackSteps([]v1.StepNode{masters[0]})
+	if err != nil {
+		return nil, err
+	}
+	installSteps = append(installSteps, cni.WithRollback(cniSteps, rollback)...)
+	if multus != nil {
+		// multus generates its config from the config of the cni, it is installed after the cni
+		if steps, err = multus.InstallSteps([]v1.StepNode{masters[0]}); err != nil {
+			return nil, err
+		}
+		installSteps = append(installSteps, steps...)
+	}
 
 	steps, err = PatchTaintAndLabelStep(runnable.Masters, runnable.Workers, metadata)
 	if err != nil {
@@ -346,6 +375,13 @@ func (runnable *Runnable) makeUninstallSteps(metadata *component.ExtraMetadata)
 	}
 	uninstallSteps = append(uninstallSteps, steps...)
 
+	// the packages of the cluster are no longer in use
+	gc, err := componentcommon.PackageCacheGCStep(nodes, nil)
+	if err != nil {
+		return nil, err
+	}
+	uninstallSteps = append(uninstallSteps, gc)
+
 	return uninstallSteps, nil
 }
 
@@ -1057,7 +1093,218 @@ func CleanCNI(metadata *component.ExtraMetadata, c *v1.CNI, networking *v1.Netwo
 		return nil, nil
 	}
 
-	return cf.Create().InitStep(metadata, c, networking).UninstallSteps(nodes)
+	steps, err := multusUninstallSteps(metadata, c, nodes, false)
+	if err != nil {
+		return nil, err
+	}
+	cniSteps, err := cf.Create().InitStep(metadata, c, networking).UninstallSteps(nodes)
+	if err != nil {
+		return nil, err
+	}
+	return append(steps, cniSteps...), nil
+}
+
+// multusUninstallSteps removes multus from nodes ahead of the cni, leaving only removes the config from the nodes.
+func multusUninstallSteps(metadata *component.ExtraMetadata, c *v1.CNI, nodes []v1.StepNode, leaving bool) ([]v1.Step, error) {
+	multus := cni.NewMultus(metadata, c)
+	if multus == nil {
+		return nil, nil
+	}
+	if leaving {
+		return multus.LeaveNodeSteps(nodes)
+	}
+	return multus.UninstallSteps(nodes)
+}
+
+// LeaveNodeCNI clean cni config of the nodes removed from the cluster, the cni of the cluster is kept
+func LeaveNodeCNI(metadata *component.ExtraMetadata, c *v1.CNI, networking *v1.Networking, nodes []v1.StepNode) ([]v1.Step, error) {
+	cf, err := cni.Load(c.Type)
+	if err != nil {
+		logger.Debugf("clean cni error: %v", err)
+		return nil, nil
+	}
+
+	cniStepper := cf.Create().InitStep(metadata, c, networking)
+	if err = cniStepper.Validate(); err != nil {
+		return nil, err
+	}
+	steps, err := multusUninstallSteps(metadata, c, nodes, true)
+	if err != nil {
+		return nil, err
+	}
+	cniSteps, err := cniStepper.LeaveNodeSteps(nodes)
+	if err != nil {
+		return nil, err
+	}
+	return append(steps, cniSteps...), nil
+}
+
+// UpgradeCNI upgrade the installed cni from fromVersion to the version of the cni spec
+func UpgradeCNI(metadata *component.ExtraMetadata, c *v1.CNI, networking *v1.Networking, nodes []v1.StepNode, fromVersion string) ([]v1.Step, error) {
+	cf, err := cni.Load(c.Type)
+	if err != nil {
+		return nil, err
+	}
+	cniStepper := cf.Create().InitStep(metadata, c, networking)
+	if err = cniStepper.Validate(); err != nil {
+		return nil, err
+	}
+	return cniStepper.UpgradeSteps(nodes, fromVersion, c.Version)
+}
+
+// UpdateCNIPeerings re-applies the BGP peerings of the installed cni on nodes without upgrading it
+func UpdateCNIPeerings(metadata *component.ExtraMetadata, c *v1.CNI, networking *v1.Networking, nodes []v1.StepNode) ([]v1.Step, error) {
+	cf, err := cni.Load(c.Type)
+	if err != nil {
+		return nil, err
+	}
+	cniStepper := cf.Create().InitStep(metadata, c, networking)
+	if err = cniStepper.Validate(); err != nil {
+		return nil, err
+	}
+	updater, ok := cniStepper.(cni.PeeringUpdater)
+	if !ok {
+		return nil, fmt.Errorf("cni %s does not support updating the BGP peerings", c.Type)
+	}
+	return updater.PeeringSteps(nodes)
+}
+
+// MigrateCNI migrate the installed cni of the from spec to the cni spec on all nodes of the cluster
+func MigrateCNI(metadata *component.ExtraMetadata, c, from *v1.CNI, networking *v1.Networking, nodes []v1.StepNode) ([]v1.Step, error) {
+	fromFactory, err := cni.Load(from.Type)
+	if err != nil {
+		return nil, err
+	}
+	cf, err := cni.Load(c.Type)
+	if err != nil {
+		return nil, err
+	}
+	cniStepper := cf.Create().InitStep(metadata, c, networking)
+	if err = cniStepper.Validate(); err != nil {
+		return nil, err
+	}
+	return cniStepper.MigrationSteps(fromFactory.Create().InitStep(metadata, from, networking), nodes)
+}
+
+// CheckCNI check the health of the installed cni on nodes
+func CheckCNI(metadata *component.ExtraMetadata, c *v1.CNI, networking *v1.Networking, nodes []v1.StepNode) ([]v1.Step, error) {
+	cf, err := cni.Load(c.Type)
+	if err != nil {
+		return nil, err
+	}
+	cniStepper := cf.Create().InitStep(metadata, c, networking)
+	if err = cniStepper.Validate(); err != nil {
+		return nil, err
+	}
+	return cniStepper.CheckSteps(nodes)
+}
+
+// CheckCNIConnectivity runs the connectivity test of the cni from the first master of the cluster
+func CheckCNIConnectivity(metadata *component.ExtraMetadata, c *v1.CNI, networking *v1.Networking, timeout time.Duration) ([]v1.Step, error) {
+	if c.Type != "cilium" {
+		return nil, fmt.Errorf("the connectivity test is not supported by cni %s", c.Type)
+	}
+	cf, err := cni.Load(c.Type)
+	if err != nil {
+		return nil, err
+	}
+	cniStepper := cf.Create().InitStep(metadata, c, networking)
+	if err = cniStepper.Validate(); err != nil {
+		return nil, err
+	}
+	return cniStepper.(*cni.CiliumRunnable).ConnectivitySteps(timeout)
+}
+
+// RunCNIOperation runs the operation name of the cni from the first master of the cluster,
+// the destructive operations only run when confirmed
+func RunCNIOperation(metadata *component.ExtraMetadata, c *v1.CNI, networking *v1.Networking, name string, opts cni.OperationOptions) ([]v1.Step, error) {
+	cf, err := cni.Load(c.Type)
+	if err != nil {
+		return nil, err
+	}
+	cniStepper := cf.Create().InitStep(metadata, c, networking)
+	if err = cniStepper.Validate(); err != nil {
+		return nil, err
+	}
+	masters := utils.UnwrapNodeList(metadata.Masters)
+	if len(masters) == 0 {
+		return nil, fmt.Errorf("the cni operation requires a master node")
+	}
+	step, err := cni.OperationStep(cniStepper, metadata.CNINamespace, name, opts, masters[0])
+	if err != nil {
+		return nil, err
+	}
+	return []v1.Step{step}, nil
+}
+
+// ConnectClusterMesh connect the cilium of the cluster to the one of peer from the first master of the cluster,
+// kubeconfig has a context for each cluster named after its cilium cluster mesh name
+func ConnectClusterMesh(metadata *component.ExtraMetadata, c *v1.Cluster, peerMetadata *component.ExtraMetadata, peer *v1.Cluster, kubeconfig []byte) ([]v1.Step, error) {
+	if c.CNI.Type != "cilium" || peer.CNI.Type != "cilium" {
+		return nil, fmt.Errorf("cluster mesh requires cilium on both clusters")
+	}
+	cf, err := cni.Load(c.CNI.Type)
+	if err != nil {
+		return nil, err
+	}
+	local := cf.Create().InitStep(metadata, &c.CNI, &c.Networking)
+	if err = local.Validate(); err != nil {
+		return nil, err
+	}
+	remote := cf.Create().InitStep(peerMetadata, &peer.CNI, &peer.Networking)
+	if err = remote.Validate(); err != nil {
+		return nil, err
+	}
+	masters := utils.UnwrapNodeList(metadata.Masters)
+	if len(masters) == 0 {
+		return nil, fmt.Errorf("cluster %s has no master", c.Name)
+	}
+	return local.(*cni.CiliumRunnable).ClusterMeshSteps(remote.(*cni.CiliumRunnable), kubeconfig, masters[:1])
+}
+
+// MergeKubeConfig merge the kubeconfigs keyed by context name, the current context of every kubeconfig
+// and its cluster and user are renamed to the key so that the names of the kubeconfigs do not collide
+func MergeKubeConfig(configs map[string]string) ([]byte, error) {
+	merged := clientcmdapi.NewConfig()
+	for name, content := range configs {
+		cfg, err := clientcmd.Load([]byte(content))
+		if err != nil {
+			return nil, err
+		}
+		kubeContext, ok := cfg.Contexts[cfg.CurrentContext]
+		if !ok {
+			return nil, fmt.Errorf("the kubeconfig of %s has no current context", name)
+		}
+		cluster, ok := cfg.Clusters[kubeContext.Cluster]
+		if !ok {
+			return nil, fmt.Errorf("the kubeconfig of %s has no cluster %s", name, kubeContext.Cluster)
+		}
+		user, ok := cfg.AuthInfos[kubeContext.AuthInfo]
+		if !ok {
+			return nil, fmt.Errorf("the kubeconfig of %s has no user %s", name, kubeContext.AuthInfo)
+		}
+		merged.Clusters[name] = cluster
+		merged.AuthInfos[name] = user
+		merged.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name}
+		if merged.CurrentContext == "" || name < merged.CurrentContext {
+			merged.CurrentContext = name
+		}
+	}
+	return clientcmd.Write(*merged)
+}
+
+// PlanCNI generate the cni steps of the cluster without running them
+func PlanCNI(ctx context.Context, metadata *component.ExtraMetadata, c *v1.Cluster) (*cni.Plan, error) {
+	cf, err := cni.Load(c.CNI.Type)
+	if err != nil {
+		return nil, err
+	}
+	cniStepper := cf.Create().InitStep(metadata, &c.CNI, &c.Networking)
+	if err = cniStepper.Validate(); err != nil {
+		return nil, err
+	}
+	return cni.NewPlan(ctx, cniStepper, metadata.Offline, utils.UnwrapNodeList(metadata.Masters),
+		utils.UnwrapNodeList(metadata.GetAllNodes()), c.KubernetesVersion)
 }
 
 func RemoveHostname(c *v1.Cluster, nodes []v1.StepNode) ([]v1.Step, error) {
@@ -0,0 +1,100 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package k8s
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
+)
+
+func TestCNISteps_validate(t *testing.T) {
+	metadata := &component.ExtraMetadata{
+		ClusterName:  "c1",
+		CNINamespace: "kube-system",
+		Masters:      component.NodeList{{ID: "node1", Hostname: "master1"}},
+		Workers:      component.NodeList{{ID: "node2", Hostname: "worker1"}},
+	}
+	networking := &v1.Networking{
+		Services: v1.NetworkRanges{CIDRBlocks: []string{"10.96.0.0/12"}},
+		Pods:     v1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}},
+	}
+	nodes := []v1.StepNode{{ID: "node2", Hostname: "worker1"}}
+	tests := []struct {
+		name  string
+		steps func(c *v1.CNI) ([]v1.Step, error)
+	}{
+		{
+			name: "leave node",
+			steps: func(c *v1.CNI) ([]v1.Step, error) {
+				return LeaveNodeCNI(metadata, c, networking, nodes)
+			},
+		},
+		{
+			name: "check",
+			steps: func(c *v1.CNI) ([]v1.Step, error) {
+				return CheckCNI(metadata, c, networking, nodes)
+			},
+		},
+		{
+			name: "connectivity",
+			steps: func(c *v1.CNI) ([]v1.Step, error) {
+				return CheckCNIConnectivity(metadata, c, networking, 5*time.Minute)
+			},
+		},
+		{
+			name: "operation",
+			steps: func(c *v1.CNI) ([]v1.Step, error) {
+				return RunCNIOperation(metadata, c, networking, cni.OperationStatus, cni.OperationOptions{})
+			},
+		},
+		{
+			name: "restore",
+			steps: func(c *v1.CNI) ([]v1.Step, error) {
+				pre, post, err := cni.RestoreSteps(metadata, &v1.Cluster{CNI: *c, Networking: *networking})
+				return append(pre, post...), err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid := &v1.CNI{Type: "cilium", Version: "1.14.4", Namespace: "kube-system", Cilium: &v1.Cilium{}}
+			if steps, err := tt.steps(valid); err != nil || len(steps) == 0 {
+				t.Fatalf("steps of a valid cilium = %v, %v", steps, err)
+			}
+			invalid := &v1.CNI{Type: "cilium", Version: "1.14.4", Namespace: "kube-system", Cilium: &v1.Cilium{TunnelMode: "gre"}}
+			steps, err := tt.steps(invalid)
+			var fieldErr *cni.FieldError
+			if !errors.As(err, &fieldErr) || fieldErr.Field != "cilium.tunnelMode" {
+				t.Errorf("error = %v, want the error of cilium.tunnelMode", err)
+			}
+			if want := `cni.cilium.tunnelMode: Invalid value: invalid cilium tunnel mode "gre"`; err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("error = %v, want %s", err, want)
+			}
+			if len(steps) != 0 {
+				t.Errorf("steps of an invalid cilium = %v, want none", steps)
+			}
+		})
+	}
+}
@@ -121,18 +121,16 @@ func (stepper *GenNode) MakeInstallSteps(metadata *component.ExtraMetadata, patc
 		}
 		stepper.installSteps = append(stepper.installSteps, steps...)
 
+		cf, err := cni.Load(stepper.Cluster.CNI.Type)
+		if err != nil {
+			return err
+		}
+		// the joining nodes take pod CIDRs from the cni pool as well
+		cniStepper := cf.Create().InitStep(metadata, &stepper.Cluster.CNI, &stepper.Cluster.Networking)
+		if err = cniStepper.Validate(); err != nil {
+			return err
+		}
 		if metadata.Offline {
-			cf, err := cni.Load(stepper.Cluster.CNI.Type)
-			if err != nil {
-				return err
-			}
-			cniStepper := cf.Create().InitStep(metadata, &stepper.Cluster.CNI, &stepper.Cluster.Networking)
-			steps, err = cniStepper.LoadImage(patchNodes)
-			if err != nil {
-				return err
-			}
-			stepper.installSteps = append(stepper.installSteps, steps...)
-
 			for _, addon := range metadata.Addons {
 				ad, ok := component.Load(fmt.Sprintf(component.RegisterFormat, addon.Name, addon.Version))
 				if !ok {
@@ -179,6 +177,19 @@ func (stepper *GenNode) MakeInstallSteps(metadata *component.ExtraMetadata, patc
 			return err
 		}
 		stepper.installSteps = append(stepper.installSteps, steps...)
+
+		// the cni images are loaded and the cni agents checked once the nodes joined
+		steps, err = cniStepper.JoinNodeSteps(patchNodes)
+		if err != nil {
+			return err
+		}
+		stepper.installSteps = append(stepper.installSteps, steps...)
+		if multus := cni.NewMultus(metadata, &stepper.Cluster.CNI); multus != nil {
+			if steps, err = multus.LoadImage(patchNodes); err != nil {
+				return err
+			}
+			stepper.installSteps = append(stepper.installSteps, steps...)
+		}
 	}
 
 	return nil
@@ -224,7 +235,7 @@ func (stepper *GenNode) MakeUninstallSteps(metadata *component.ExtraMetadata, pa
 		stepper.uninstallSteps = append(stepper.uninstallSteps, steps...)
 
 		// clean CNI config
-		steps, err = CleanCNI(metadata, &stepper.Cluster.CNI, &stepper.Cluster.Networking, patchNodes)
+		steps, err = LeaveNodeCNI(metadata, &stepper.Cluster.CNI, &stepper.Cluster.Networking, patchNodes)
 		if err != nil {
 			return err
 		}
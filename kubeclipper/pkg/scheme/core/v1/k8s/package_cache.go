@@ -0,0 +1,47 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package k8s
+
+import (
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
+)
+
+// ClusterPackages the downloader.PackageKey of the package versions the components installed on the cluster use,
+// the package cache garbage collection of the cluster nodes keeps them.
+func ClusterPackages(c *v1.Cluster) []string {
+	packages := []string{
+		downloader.PackageKey(K8s, c.KubernetesVersion),
+		downloader.PackageKey(k8sExtension, c.KubernetesVersion),
+	}
+	if c.ContainerRuntime.Type != "" {
+		packages = append(packages, downloader.PackageKey(c.ContainerRuntime.Type, c.ContainerRuntime.Version))
+	}
+	if c.CNI.Type != "" {
+		packages = append(packages, downloader.PackageKey(c.CNI.Type, c.CNI.Version))
+	}
+	if c.CNI.EnableMultus {
+		packages = append(packages, downloader.PackageKey("multus", cni.MultusVersion))
+	}
+	for _, addon := range c.Addons {
+		packages = append(packages, downloader.PackageKey(addon.Name, addon.Version))
+	}
+	return packages
+}
@@ -20,6 +20,9 @@ package v1
 
 import (
 	"errors"
+	"regexp"
+	"strings"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -57,6 +60,36 @@ func (op *Operation) GetStep(stepID string) (step Step, ok bool) {
 	return
 }
 
+// CompletedRollbacks the indexes of the steps registering RollbackSteps for a component installed completely before
+// the step at index failed, in the reverse order of the installs which is the order to run the rollbacks in.
+func (op *Operation) CompletedRollbacks(failed int) []int {
+	var indexes []int
+	for i := failed - 1; i >= 0; i-- {
+		if len(op.Steps[i].RollbackSteps) == 0 {
+			continue
+		}
+		for j := i; j < failed; j++ {
+			if op.Steps[j].ID == op.Steps[i].RollbackAfter {
+				indexes = append(indexes, i)
+				break
+			}
+		}
+	}
+	return indexes
+}
+
+// RedactSecrets redacts the secrets of the steps of the operation, see Step.RedactSecrets. It reports whether
+// the operation carries secrets.
+func (op *Operation) RedactSecrets() bool {
+	sensitive := false
+	for i := range op.Steps {
+		if op.Steps[i].RedactSecrets() {
+			sensitive = true
+		}
+	}
+	return sensitive
+}
+
 // default operation timeout is 90 min
 
 const DefaultOperationTimeoutSecs = "5400"
@@ -70,11 +103,24 @@ const (
 	OperationStatusTermination OperationStatusType = "termination"
 	OperationStatusUnknown     OperationStatusType = "unknown"
 	OperationStatusSuccessful  OperationStatusType = "successful"
+	// OperationStatusPendingApproval the operation stopped after its diff step until the user approves the diff,
+	// see Step.Diff.
+	OperationStatusPendingApproval OperationStatusType = "pendingApproval"
 )
 
 type OperationStatus struct {
 	Status     OperationStatusType  `json:"status,omitempty"`
 	Conditions []OperationCondition `json:"conditions,omitempty"`
+	// Completed the InputHash of the steps completed by the attempts of the operation, keyed by step ID.
+	Completed map[string]string `json:"completed,omitempty"`
+	// CompletedReplies the responses of the steps completed by the attempts of the operation, keyed by step ID.
+	// The steps skipped as completed reply them again, the next step may read them, see StepCompleted.
+	CompletedReplies map[string][]byte `json:"completedReplies,omitempty"`
+}
+
+// StepCompleted reports whether step completed with the same definition in a prior attempt of the operation.
+func (s *OperationStatus) StepCompleted(step *Step) bool {
+	return step.InputHash != "" && s.Completed[step.ID] == step.InputHash
 }
 
 type StepAction string
@@ -100,6 +146,12 @@ const (
 	OperationUninstallComponents          = "UninstallComponents"
 	OperationUpdateCertification          = "UpdateCertifications"
 	OperationUpdateAPIServerCertification = "UpdateAPIServerCertifications"
+	OperationUpgradeCNI                   = "UpgradeCNI"
+	OperationMigrateCNI                   = "MigrateCNI"
+	OperationCheckCNI                     = "CheckCNI"
+	OperationConnectClusterMesh           = "ConnectClusterMesh"
+	OperationCheckCNIConnectivity         = "CheckCNIConnectivity"
+	OperationRunCNIOperation              = "RunCNIOperation"
 )
 
 // Step TODO: add commands struct instead of string
@@ -115,6 +167,168 @@ type Step struct {
 	AfterRunCommands  []Command       `json:"afterRunCommands,omitempty"`
 	RetryTimes        int32           `json:"retryTimes,omitempty"`
 	AutomaticRetry    bool            `json:"automaticRetry"`
+	// RetryInterval the delay before the first retry, every following retry waits RetryBackoffFactor times longer.
+	// The retries run immediately when it is zero. The attempts and the delays share Timeout.
+	RetryInterval metav1.Duration `json:"retryInterval,omitempty"`
+	// RetryBackoffFactor the growth of the retry delay, the delay does not grow when it is less than 2.
+	RetryBackoffFactor int32 `json:"retryBackoffFactor,omitempty"`
+	// RetryPolicySet the retry fields of the step are final, the retry policy of the step generator does not
+	// override them, e.g. the deterministic checks which are never retried.
+	RetryPolicySet bool `json:"retryPolicySet,omitempty"`
+	// SensitiveOutput redacts the output of the shell commands on the step status, e.g. the commands print secrets.
+	SensitiveOutput bool `json:"sensitiveOutput,omitempty"`
+	// CancelCommands the shell commands run best-effort on the nodes after the step is cancelled by the operation
+	// termination, e.g. to roll back a half-applied change.
+	CancelCommands []Command `json:"cancelCommands,omitempty"`
+	// RollbackSteps undo the component whose install starts at this step and completes at the step RollbackAfter,
+	// they are run when a later step of the operation fails so that a retry can install the component again.
+	RollbackSteps []Step `json:"rollbackSteps,omitempty"`
+	// RollbackAfter the ID of the last install step of the component RollbackSteps undo.
+	RollbackAfter string `json:"rollbackAfter,omitempty"`
+	// InputHash the hash of the definition of the step, a retry of the operation skips the step when it completed
+	// with the same ID and InputHash in a prior attempt. The step always runs when it is empty.
+	InputHash string `json:"inputHash,omitempty"`
+	// Component the component the step belongs to, e.g. cri, kubernetes, cni or the addon name.
+	Component string `json:"component,omitempty"`
+	// DependsOn the names of the steps of the same component which must run before the step, component/name names
+	// a step of another component. The operation is ordered by them when it is assembled, see Parallel.
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Parallel the step starts as soon as the steps of its component it depends on and the steps of the other
+	// components before it completed, along with the steps between them, e.g. the chart download along with the
+	// image load, but not past a Diff step. It is given no last step reply when it starts ahead of its turn. The
+	// steps run one after another otherwise, the steps after a parallel step wait on it.
+	Parallel bool `json:"parallel,omitempty"`
+	// Concurrency the number of nodes the step runs on at once, every node at once when it is zero.
+	Concurrency int32 `json:"concurrency,omitempty"`
+	// FailFast stops the step on the first failed node: the nodes not started yet are not run and the running ones
+	// are cancelled. Otherwise every node runs to completion and reports its own status.
+	FailFast bool `json:"failFast,omitempty"`
+	// ErrorMatchers classify the failures of the step, the first matching one sets the error category and the
+	// remediation hint of the step status.
+	ErrorMatchers []StepErrorMatcher `json:"errorMatchers,omitempty"`
+	// Diff the response of the step is the diff of the changes the following steps of the operation apply. The
+	// operation succeeds without running them when the diff is empty, and stops in OperationStatusPendingApproval
+	// after the step when it is labeled with the confirm label set to false.
+	Diff bool `json:"diff,omitempty"`
+	// ReportProgress the agents report the output the step writes to its step log while it runs, the server
+	// streams it with the state transitions of the steps to the watchers of the operation progress.
+	ReportProgress bool `json:"reportProgress,omitempty"`
+	// FailoverNodes the nodes a step of a single node moves to in order when the agent of its node does not respond,
+	// e.g. the other masters for the steps run against the kube-apiserver. The status records the node it ran on.
+	FailoverNodes []StepNode `json:"failoverNodes,omitempty"`
+	// FailoverStaging the step stages the files the later steps of its node read, e.g. the downloaded chart and the
+	// rendered values of a helm release. A later step failing over runs it again on the node it moves to first.
+	FailoverStaging bool `json:"failoverStaging,omitempty"`
+}
+
+// StepErrorCategory the category of a step failure, the console explains the failure by it.
+type StepErrorCategory string
+
+const (
+	StepErrorDownloadFailed      StepErrorCategory = "DownloadFailed"
+	StepErrorChecksumMismatch    StepErrorCategory = "ChecksumMismatch"
+	StepErrorHelmReleaseConflict StepErrorCategory = "HelmReleaseConflict"
+	StepErrorImagePullFailed     StepErrorCategory = "ImagePullFailed"
+	StepErrorTimeout             StepErrorCategory = "Timeout"
+	StepErrorValidationFailed    StepErrorCategory = "ValidationFailed"
+	// StepErrorClusterUnreachable the step could not reach the kubernetes api server.
+	StepErrorClusterUnreachable StepErrorCategory = "ClusterUnreachable"
+	// StepErrorDNSResolutionFailed the cni is ready but the pods can not resolve the cluster names.
+	StepErrorDNSResolutionFailed StepErrorCategory = "DNSResolutionFailed"
+)
+
+// StepErrorMatcher matches the failures of a step whose exit code is one of ExitCodes and whose output matches the
+// regular expression Pattern, an empty condition matches every failure.
+type StepErrorMatcher struct {
+	Category  StepErrorCategory `json:"category"`
+	ExitCodes []int             `json:"exitCodes,omitempty"`
+	Pattern   string            `json:"pattern,omitempty"`
+	// Hint the remediation of the failure shown with it.
+	Hint string `json:"hint,omitempty"`
+}
+
+// Match reports whether the failure exiting with exitCode and printing output matches, exitCode is 0 when the
+// failure did not come from a shell command. A matcher without conditions or with an invalid pattern matches nothing.
+func (m *StepErrorMatcher) Match(exitCode int, output string) bool {
+	if len(m.ExitCodes) == 0 && m.Pattern == "" {
+		return false
+	}
+	if len(m.ExitCodes) > 0 {
+		found := false
+		for _, code := range m.ExitCodes {
+			found = found || code == exitCode
+		}
+		if !found {
+			return false
+		}
+	}
+	if m.Pattern == "" {
+		return true
+	}
+	re, err := regexp.Compile(m.Pattern)
+	return err == nil && re.MatchString(output)
+}
+
+// ClassifyError returns the first ErrorMatchers matching the failure, see StepErrorMatcher.Match, nil when none does.
+func (s *Step) ClassifyError(exitCode int, output string) *StepErrorMatcher {
+	for i := range s.ErrorMatchers {
+		if s.ErrorMatchers[i].Match(exitCode, output) {
+			return &s.ErrorMatchers[i]
+		}
+	}
+	return nil
+}
+
+// commandLists the command lists of the step, the rollback steps excluded.
+func (s *Step) commandLists() [][]Command {
+	return [][]Command{s.BeforeRunCommands, s.Commands, s.AfterRunCommands, s.CancelCommands}
+}
+
+// RedactSecrets replaces the sensitive arguments and environment values of the commands of the step and of its
+// rollback steps by RedactedValue, it reports whether the step carries secrets. Redact a copy of the step that
+// is still to be run.
+func (s *Step) RedactSecrets() bool {
+	sensitive := false
+	for _, cmds := range s.commandLists() {
+		for i := range cmds {
+			if cmds[i].redact() {
+				sensitive = true
+			}
+		}
+	}
+	for i := range s.RollbackSteps {
+		if s.RollbackSteps[i].RedactSecrets() {
+			sensitive = true
+		}
+	}
+	return sensitive
+}
+
+// SecretsRedacted reports whether the secrets of the step or of its rollback steps were redacted, the step cannot
+// be run until they are restored.
+func (s *Step) SecretsRedacted() bool {
+	for _, cmds := range s.commandLists() {
+		for i := range cmds {
+			if cmds[i].redacted() {
+				return true
+			}
+		}
+	}
+	for i := range s.RollbackSteps {
+		if s.RollbackSteps[i].SecretsRedacted() {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryDelay the delay before the retry-th retry of the step, retry starts at 1.
+func (s *Step) RetryDelay(retry int) time.Duration {
+	delay := s.RetryInterval.Duration
+	for i := 1; i < retry && s.RetryBackoffFactor > 1; i++ {
+		delay *= time.Duration(s.RetryBackoffFactor)
+	}
+	return delay
 }
 
 type StepNode struct {
@@ -122,6 +336,12 @@ type StepNode struct {
 	IPv4     string `json:"ipv4,omitempty"`
 	NodeIPv4 string `json:"nodeIPv4,omitempty"`
 	Hostname string `json:"hostname,omitempty"`
+	// Arch the architecture of the node, e.g. amd64, empty when it is unknown.
+	Arch string `json:"arch,omitempty"`
+	// HelmSDK whether the agent of the node runs CommandHelm commands.
+	HelmSDK bool `json:"helmSDK,omitempty"`
+	// WaitFor whether the agent of the node runs CommandWaitFor commands.
+	WaitFor bool `json:"waitFor,omitempty"`
 }
 
 type CommandType string
@@ -130,8 +350,80 @@ const (
 	CommandShell          CommandType = "shell"
 	CommandTemplateRender CommandType = "templateRender"
 	CommandCustom         CommandType = "custom"
+	// CommandHelm runs Helm with the helm SDK built into the agent instead of the helm binary, only the agents
+	// advertising StepNode.HelmSDK support it.
+	CommandHelm CommandType = "helm"
+	// CommandWaitFor polls the kubernetes API from the agent until a resource meets a condition, only the agents
+	// advertising StepNode.WaitFor support it.
+	CommandWaitFor CommandType = "waitFor"
+)
+
+// WaitCondition the condition a CommandWaitFor command waits for.
+type WaitCondition string
+
+const (
+	// WaitConditionRolloutComplete the Deployment, DaemonSet or StatefulSet rolled out the pods of its current
+	// revision and they are available, like kubectl rollout status.
+	WaitConditionRolloutComplete WaitCondition = "rolloutComplete"
+	// WaitConditionPodsReady the pods matching the selectors are ready.
+	WaitConditionPodsReady WaitCondition = "podsReady"
+	// WaitConditionEstablished the CustomResourceDefinition is established.
+	WaitConditionEstablished WaitCondition = "established"
+)
+
+// WaitForCommand the wait of a CommandWaitFor command, it fails with the last observed state of the resource when
+// the condition is not met within Timeout.
+type WaitForCommand struct {
+	// Kind Deployment, DaemonSet or StatefulSet for WaitConditionRolloutComplete, Pod for WaitConditionPodsReady
+	// and CustomResourceDefinition for WaitConditionEstablished.
+	Kind string `json:"kind"`
+	// Name the name of the resource, ignored by WaitConditionPodsReady.
+	Name      string        `json:"name,omitempty"`
+	Namespace string        `json:"namespace,omitempty"`
+	Condition WaitCondition `json:"condition"`
+	// Selector and FieldSelector the label and field selectors of the pods of WaitConditionPodsReady,
+	// e.g. k8s-app=cilium and spec.nodeName=node1.
+	Selector      string `json:"selector,omitempty"`
+	FieldSelector string `json:"fieldSelector,omitempty"`
+	// ReadyCount the ready pods WaitConditionPodsReady waits for, every matching pod and at least one when it is zero.
+	ReadyCount int `json:"readyCount,omitempty"`
+	// Interval the delay between the polls, 2s when it is zero.
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// Timeout the time to wait, 5m when it is zero.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+type HelmAction string
+
+const (
+	// HelmActionInstall installs the release or upgrades it when it exists, like helm upgrade --install.
+	HelmActionInstall   HelmAction = "install"
+	HelmActionUninstall HelmAction = "uninstall"
+	// HelmActionDiff renders the upgrade of the installed release without applying it, the reply of the command
+	// is the diff of the rendered manifests, empty when the upgrade changes nothing.
+	HelmActionDiff HelmAction = "diff"
 )
 
+// HelmCommand the helm release operation of a CommandHelm command, the fields mirror the flags of the helm cli.
+type HelmCommand struct {
+	Action    HelmAction `json:"action"`
+	Release   string     `json:"release"`
+	Namespace string     `json:"namespace"`
+	// ChartPath the local path of the chart on the node, ignored by HelmActionUninstall.
+	ChartPath string `json:"chartPath,omitempty"`
+	// ValuesFile the local path of the values file on the node.
+	ValuesFile      string          `json:"valuesFile,omitempty"`
+	CreateNamespace bool            `json:"createNamespace,omitempty"`
+	Wait            bool            `json:"wait,omitempty"`
+	Atomic          bool            `json:"atomic,omitempty"`
+	ReuseValues     bool            `json:"reuseValues,omitempty"`
+	Timeout         metav1.Duration `json:"timeout,omitempty"`
+	// Set the key=value --set arguments, in the order they are applied.
+	Set []string `json:"set,omitempty"`
+	// SensitiveSet the indexes of the Set arguments carrying secrets, they are redacted like Command.SensitiveArgs.
+	SensitiveSet []int `json:"sensitiveSet,omitempty"`
+}
+
 type TemplateCommand struct {
 	Identity string `json:"identity,omitempty"`
 	Data     []byte `json:"data,omitempty"`
@@ -143,6 +435,88 @@ type Command struct {
 	Identity      string           `json:"identity,omitempty"`
 	CustomCommand []byte           `json:"customCommand,omitempty"`
 	Template      *TemplateCommand `json:"template,omitempty"`
+	// SensitiveArgs the indexes of the ShellCommand arguments carrying secrets, they are replaced by RedactedValue
+	// when the operation is persisted and masked in the logs of the agent.
+	SensitiveArgs []int `json:"sensitiveArgs,omitempty"`
+	// Env the environment variables the agent runs the command with, the custom and helm commands read them from
+	// the context.
+	Env []EnvVar `json:"env,omitempty"`
+	// Helm the release operation of a CommandHelm command.
+	Helm *HelmCommand `json:"helm,omitempty"`
+	// WaitFor the wait of a CommandWaitFor command.
+	WaitFor *WaitForCommand `json:"waitFor,omitempty"`
+}
+
+// EnvVar an environment variable of a command, the Value of a Sensitive variable is replaced by RedactedValue
+// when the operation is persisted.
+type EnvVar struct {
+	Name      string `json:"name"`
+	Value     string `json:"value,omitempty"`
+	Sensitive bool   `json:"sensitive,omitempty"`
+}
+
+// RedactedValue replaces the secrets of the commands in the persisted operations.
+const RedactedValue = "***"
+
+// MaskedShellCommand the ShellCommand with its sensitive arguments masked, to log the command.
+func (c *Command) MaskedShellCommand() []string {
+	if len(c.SensitiveArgs) == 0 {
+		return c.ShellCommand
+	}
+	masked := append([]string(nil), c.ShellCommand...)
+	for _, i := range c.SensitiveArgs {
+		if i >= 0 && i < len(masked) {
+			masked[i] = RedactedValue
+		}
+	}
+	return masked
+}
+
+// redact replaces the sensitive arguments and environment values of the command by RedactedValue, it reports
+// whether the command carries secrets.
+func (c *Command) redact() bool {
+	sensitive := false
+	for _, i := range c.SensitiveArgs {
+		if i >= 0 && i < len(c.ShellCommand) {
+			c.ShellCommand[i], sensitive = RedactedValue, true
+		}
+	}
+	for i := range c.Env {
+		if c.Env[i].Sensitive {
+			c.Env[i].Value, sensitive = RedactedValue, true
+		}
+	}
+	if c.Helm != nil {
+		for _, i := range c.Helm.SensitiveSet {
+			if i >= 0 && i < len(c.Helm.Set) {
+				key, _, _ := strings.Cut(c.Helm.Set[i], "=")
+				c.Helm.Set[i], sensitive = key+"="+RedactedValue, true
+			}
+		}
+	}
+	return sensitive
+}
+
+// redacted reports whether the secrets of the command were replaced by RedactedValue.
+func (c *Command) redacted() bool {
+	for _, i := range c.SensitiveArgs {
+		if i >= 0 && i < len(c.ShellCommand) && c.ShellCommand[i] == RedactedValue {
+			return true
+		}
+	}
+	for _, env := range c.Env {
+		if env.Sensitive && env.Value == RedactedValue {
+			return true
+		}
+	}
+	if c.Helm != nil {
+		for _, i := range c.Helm.SensitiveSet {
+			if i >= 0 && i < len(c.Helm.Set) && strings.HasSuffix(c.Helm.Set[i], "="+RedactedValue) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // OperationCondition contains condition information for a node.
@@ -156,6 +530,18 @@ type StepStatusType string
 const (
 	StepStatusSuccessful StepStatusType = "successful"
 	StepStatusFailed     StepStatusType = "failed"
+	// StepStatusCancelled the step was stopped by the operation termination before it finished.
+	StepStatusCancelled StepStatusType = "cancelled"
+	// StepStatusRunning the step is running on the node, the operation progress reports it, it is not recorded
+	// on the operation.
+	StepStatusRunning StepStatusType = "running"
+)
+
+const (
+	// StepOutputMaxSize the size of the output tail kept on the step status of a node.
+	StepOutputMaxSize = 2 * 1024
+	// StepOutputRedacted replaces the output of the steps whose output is sensitive.
+	StepOutputRedacted = "<redacted>"
 )
 
 type StepStatus struct {
@@ -170,6 +556,18 @@ type StepStatus struct {
 	// +optional
 	Message  string `json:"message,omitempty"`
 	Response []byte `json:"response,omitempty"`
+	// Output the last StepOutputMaxSize bytes of the stdout and stderr of the shell commands of the step.
+	// +optional
+	Output string `json:"output,omitempty"`
+	// Attempts the number of times the step ran on the node, 1 plus the retries.
+	// +optional
+	Attempts int32 `json:"attempts,omitempty"`
+	// ErrorCategory the category of the failure, empty when the step did not fail or no matcher matched it.
+	// +optional
+	ErrorCategory StepErrorCategory `json:"errorCategory,omitempty"`
+	// Hint the remediation of the failure.
+	// +optional
+	Hint string `json:"hint,omitempty"`
 }
 
 type PendingOperation struct {
@@ -23,6 +23,7 @@
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -219,6 +220,46 @@ func (in *CNI) DeepCopyInto(out *CNI) {
 		*out = new(Calico)
 		**out = **in
 	}
+	if in.Cilium != nil {
+		in, out := &in.Cilium, &out.Cilium
+		*out = new(Cilium)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Flannel != nil {
+		in, out := &in.Flannel, &out.Flannel
+		*out = new(Flannel)
+		**out = **in
+	}
+	if in.KubeOvn != nil {
+		in, out := &in.KubeOvn, &out.KubeOvn
+		*out = new(KubeOvn)
+		**out = **in
+	}
+	if in.Timeouts != nil {
+		in, out := &in.Timeouts, &out.Timeouts
+		*out = new(CNITimeouts)
+		**out = **in
+	}
+	if in.RetryPolicy != nil {
+		in, out := &in.RetryPolicy, &out.RetryPolicy
+		*out = new(CNIRetryPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LocalRegistryConfig != nil {
+		in, out := &in.LocalRegistryConfig, &out.LocalRegistryConfig
+		*out = new(CNIRegistryConfig)
+		**out = **in
+	}
+	if in.NetworkAttachments != nil {
+		in, out := &in.NetworkAttachments, &out.NetworkAttachments
+		*out = make([]NetworkAttachment, len(*in))
+		copy(*out, *in)
+	}
+	if in.RestartWorkloads != nil {
+		in, out := &in.RestartWorkloads, &out.RestartWorkloads
+		*out = new(CNIRestartWorkloads)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -232,6 +273,110 @@ func (in *CNI) DeepCopy() *CNI {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CNIRegistryConfig) DeepCopyInto(out *CNIRegistryConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CNIRegistryConfig.
+func (in *CNIRegistryConfig) DeepCopy() *CNIRegistryConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(CNIRegistryConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CNIReleaseRecord) DeepCopyInto(out *CNIReleaseRecord) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CNIReleaseRecord.
+func (in *CNIReleaseRecord) DeepCopy() *CNIReleaseRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(CNIReleaseRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CNIRestartWorkloads) DeepCopyInto(out *CNIRestartWorkloads) {
+	*out = *in
+	if in.GracePeriod != nil {
+		in, out := &in.GracePeriod, &out.GracePeriod
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	out.Timeout = in.Timeout
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CNIRestartWorkloads.
+func (in *CNIRestartWorkloads) DeepCopy() *CNIRestartWorkloads {
+	if in == nil {
+		return nil
+	}
+	out := new(CNIRestartWorkloads)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CNIRetryPolicy) DeepCopyInto(out *CNIRetryPolicy) {
+	*out = *in
+	if in.RetryTimes != nil {
+		in, out := &in.RetryTimes, &out.RetryTimes
+		*out = new(int32)
+		**out = **in
+	}
+	if in.RetryInterval != nil {
+		in, out := &in.RetryInterval, &out.RetryInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CNIRetryPolicy.
+func (in *CNIRetryPolicy) DeepCopy() *CNIRetryPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(CNIRetryPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CNITimeouts) DeepCopyInto(out *CNITimeouts) {
+	*out = *in
+	out.Install = in.Install
+	out.Uninstall = in.Uninstall
+	out.ImageLoad = in.ImageLoad
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CNITimeouts.
+func (in *CNITimeouts) DeepCopy() *CNITimeouts {
+	if in == nil {
+		return nil
+	}
+	out := new(CNITimeouts)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CRIRegistry) DeepCopyInto(out *CRIRegistry) {
 	*out = *in
@@ -286,6 +431,370 @@ func (in *Certification) DeepCopy() *Certification {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Cilium) DeepCopyInto(out *Cilium) {
+	*out = *in
+	if in.ClusterPoolIPv4PodCIDRList != nil {
+		in, out := &in.ClusterPoolIPv4PodCIDRList, &out.ClusterPoolIPv4PodCIDRList
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClusterPoolIPv6PodCIDRList != nil {
+		in, out := &in.ClusterPoolIPv6PodCIDRList, &out.ClusterPoolIPv6PodCIDRList
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Encryption != nil {
+		in, out := &in.Encryption, &out.Encryption
+		*out = new(CiliumEncryption)
+		**out = **in
+	}
+	if in.ReadinessCheckTimeout != nil {
+		in, out := &in.ReadinessCheckTimeout, &out.ReadinessCheckTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.OperatorResources != nil {
+		in, out := &in.OperatorResources, &out.OperatorResources
+		*out = new(CiliumOperatorResources)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.OperatorNodeSelector != nil {
+		in, out := &in.OperatorNodeSelector, &out.OperatorNodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.OperatorTolerations != nil {
+		in, out := &in.OperatorTolerations, &out.OperatorTolerations
+		*out = make([]CiliumToleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]CiliumToleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ExtraSetArgs != nil {
+		in, out := &in.ExtraSetArgs, &out.ExtraSetArgs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Metrics != nil {
+		in, out := &in.Metrics, &out.Metrics
+		*out = new(CiliumMetrics)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ClusterMesh != nil {
+		in, out := &in.ClusterMesh, &out.ClusterMesh
+		*out = new(CiliumClusterMesh)
+		**out = **in
+	}
+	if in.KVStore != nil {
+		in, out := &in.KVStore, &out.KVStore
+		*out = new(CiliumKVStore)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LoadBalancerIPPools != nil {
+		in, out := &in.LoadBalancerIPPools, &out.LoadBalancerIPPools
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.BGP != nil {
+		in, out := &in.BGP, &out.BGP
+		*out = new(CiliumBGP)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.EgressGatewayPolicies != nil {
+		in, out := &in.EgressGatewayPolicies, &out.EgressGatewayPolicies
+		*out = make([]CiliumEgressGatewayPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Pools != nil {
+		in, out := &in.Pools, &out.Pools
+		*out = make([]CiliumPodIPPool, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DNSProxyConfig != nil {
+		in, out := &in.DNSProxyConfig, &out.DNSProxyConfig
+		*out = new(CiliumDNSProxy)
+		**out = **in
+	}
+	if in.AgentResources != nil {
+		in, out := &in.AgentResources, &out.AgentResources
+		*out = new(CiliumOperatorResources)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Cilium.
+func (in *Cilium) DeepCopy() *Cilium {
+	if in == nil {
+		return nil
+	}
+	out := new(Cilium)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CiliumBGP) DeepCopyInto(out *CiliumBGP) {
+	*out = *in
+	if in.Peerings != nil {
+		in, out := &in.Peerings, &out.Peerings
+		*out = make([]CiliumBGPPeering, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CiliumBGP.
+func (in *CiliumBGP) DeepCopy() *CiliumBGP {
+	if in == nil {
+		return nil
+	}
+	out := new(CiliumBGP)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CiliumBGPPeering) DeepCopyInto(out *CiliumBGPPeering) {
+	*out = *in
+	if in.ServiceSelector != nil {
+		in, out := &in.ServiceSelector, &out.ServiceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CiliumBGPPeering.
+func (in *CiliumBGPPeering) DeepCopy() *CiliumBGPPeering {
+	if in == nil {
+		return nil
+	}
+	out := new(CiliumBGPPeering)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CiliumClusterMesh) DeepCopyInto(out *CiliumClusterMesh) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CiliumClusterMesh.
+func (in *CiliumClusterMesh) DeepCopy() *CiliumClusterMesh {
+	if in == nil {
+		return nil
+	}
+	out := new(CiliumClusterMesh)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CiliumDNSProxy) DeepCopyInto(out *CiliumDNSProxy) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CiliumDNSProxy.
+func (in *CiliumDNSProxy) DeepCopy() *CiliumDNSProxy {
+	if in == nil {
+		return nil
+	}
+	out := new(CiliumDNSProxy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CiliumEgressGatewayPolicy) DeepCopyInto(out *CiliumEgressGatewayPolicy) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.EgressNodeLabels != nil {
+		in, out := &in.EgressNodeLabels, &out.EgressNodeLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.DestinationCIDRs != nil {
+		in, out := &in.DestinationCIDRs, &out.DestinationCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CiliumEgressGatewayPolicy.
+func (in *CiliumEgressGatewayPolicy) DeepCopy() *CiliumEgressGatewayPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(CiliumEgressGatewayPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CiliumEncryption) DeepCopyInto(out *CiliumEncryption) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CiliumEncryption.
+func (in *CiliumEncryption) DeepCopy() *CiliumEncryption {
+	if in == nil {
+		return nil
+	}
+	out := new(CiliumEncryption)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CiliumKVStore) DeepCopyInto(out *CiliumKVStore) {
+	*out = *in
+	if in.Endpoints != nil {
+		in, out := &in.Endpoints, &out.Endpoints
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CiliumKVStore.
+func (in *CiliumKVStore) DeepCopy() *CiliumKVStore {
+	if in == nil {
+		return nil
+	}
+	out := new(CiliumKVStore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CiliumMetrics) DeepCopyInto(out *CiliumMetrics) {
+	*out = *in
+	if in.HubbleMetrics != nil {
+		in, out := &in.HubbleMetrics, &out.HubbleMetrics
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CiliumMetrics.
+func (in *CiliumMetrics) DeepCopy() *CiliumMetrics {
+	if in == nil {
+		return nil
+	}
+	out := new(CiliumMetrics)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CiliumOperatorResources) DeepCopyInto(out *CiliumOperatorResources) {
+	*out = *in
+	if in.Requests != nil {
+		in, out := &in.Requests, &out.Requests
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Limits != nil {
+		in, out := &in.Limits, &out.Limits
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CiliumOperatorResources.
+func (in *CiliumOperatorResources) DeepCopy() *CiliumOperatorResources {
+	if in == nil {
+		return nil
+	}
+	out := new(CiliumOperatorResources)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CiliumPodIPPool) DeepCopyInto(out *CiliumPodIPPool) {
+	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CiliumPodIPPool.
+func (in *CiliumPodIPPool) DeepCopy() *CiliumPodIPPool {
+	if in == nil {
+		return nil
+	}
+	out := new(CiliumPodIPPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CiliumToleration) DeepCopyInto(out *CiliumToleration) {
+	*out = *in
+	if in.TolerationSeconds != nil {
+		in, out := &in.TolerationSeconds, &out.TolerationSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CiliumToleration.
+func (in *CiliumToleration) DeepCopy() *CiliumToleration {
+	if in == nil {
+		return nil
+	}
+	out := new(CiliumToleration)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CloudProvider) DeepCopyInto(out *CloudProvider) {
 	*out = *in
@@ -467,6 +976,23 @@ func (in *Cluster) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterCondition) DeepCopyInto(out *ClusterCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterCondition.
+func (in *ClusterCondition) DeepCopy() *ClusterCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClusterList) DeepCopyInto(out *ClusterList) {
 	*out = *in
@@ -526,6 +1052,18 @@ func (in *ClusterStatus) DeepCopyInto(out *ClusterStatus) {
 		*out = make([]ControlPlaneHealth, len(*in))
 		copy(*out, *in)
 	}
+	if in.CNIRelease != nil {
+		in, out := &in.CNIRelease, &out.CNIRelease
+		*out = new(CNIReleaseRecord)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]ClusterCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -573,6 +1111,26 @@ func (in *Command) DeepCopyInto(out *Command) {
 		*out = new(TemplateCommand)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.SensitiveArgs != nil {
+		in, out := &in.SensitiveArgs, &out.SensitiveArgs
+		*out = make([]int, len(*in))
+		copy(*out, *in)
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]EnvVar, len(*in))
+		copy(*out, *in)
+	}
+	if in.Helm != nil {
+		in, out := &in.Helm, &out.Helm
+		*out = new(HelmCommand)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WaitFor != nil {
+		in, out := &in.WaitFor, &out.WaitFor
+		*out = new(WaitForCommand)
+		**out = **in
+	}
 	return
 }
 
@@ -969,6 +1527,22 @@ func (in *DomainStatus) DeepCopy() *DomainStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnvVar) DeepCopyInto(out *EnvVar) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvVar.
+func (in *EnvVar) DeepCopy() *EnvVar {
+	if in == nil {
+		return nil
+	}
+	out := new(EnvVar)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Etcd) DeepCopyInto(out *Etcd) {
 	*out = *in
@@ -1046,6 +1620,22 @@ func (in *EventList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Flannel) DeepCopyInto(out *Flannel) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Flannel.
+func (in *Flannel) DeepCopy() *Flannel {
+	if in == nil {
+		return nil
+	}
+	out := new(Flannel)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FsConfig) DeepCopyInto(out *FsConfig) {
 	*out = *in
@@ -1062,6 +1652,33 @@ func (in *FsConfig) DeepCopy() *FsConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HelmCommand) DeepCopyInto(out *HelmCommand) {
+	*out = *in
+	out.Timeout = in.Timeout
+	if in.Set != nil {
+		in, out := &in.Set, &out.Set
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SensitiveSet != nil {
+		in, out := &in.SensitiveSet, &out.SensitiveSet
+		*out = make([]int, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HelmCommand.
+func (in *HelmCommand) DeepCopy() *HelmCommand {
+	if in == nil {
+		return nil
+	}
+	out := new(HelmCommand)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *InsecureRegistry) DeepCopyInto(out *InsecureRegistry) {
 	*out = *in
@@ -1079,6 +1696,22 @@ func (in *InsecureRegistry) DeepCopy() *InsecureRegistry {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeOvn) DeepCopyInto(out *KubeOvn) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeOvn.
+func (in *KubeOvn) DeepCopy() *KubeOvn {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeOvn)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KubeProxy) DeepCopyInto(out *KubeProxy) {
 	*out = *in
@@ -1111,6 +1744,22 @@ func (in *Kubelet) DeepCopy() *Kubelet {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkAttachment) DeepCopyInto(out *NetworkAttachment) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkAttachment.
+func (in *NetworkAttachment) DeepCopy() *NetworkAttachment {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkAttachment)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NetworkRanges) DeepCopyInto(out *NetworkRanges) {
 	*out = *in
@@ -1409,6 +2058,28 @@ func (in *OperationStatus) DeepCopyInto(out *OperationStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Completed != nil {
+		in, out := &in.Completed, &out.Completed
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.CompletedReplies != nil {
+		in, out := &in.CompletedReplies, &out.CompletedReplies
+		*out = make(map[string][]byte, len(*in))
+		for key, val := range *in {
+			var outVal []byte
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make([]byte, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
 	return
 }
 
@@ -1820,6 +2491,37 @@ func (in *Step) DeepCopyInto(out *Step) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.CancelCommands != nil {
+		in, out := &in.CancelCommands, &out.CancelCommands
+		*out = make([]Command, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RollbackSteps != nil {
+		in, out := &in.RollbackSteps, &out.RollbackSteps
+		*out = make([]Step, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DependsOn != nil {
+		in, out := &in.DependsOn, &out.DependsOn
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ErrorMatchers != nil {
+		in, out := &in.ErrorMatchers, &out.ErrorMatchers
+		*out = make([]StepErrorMatcher, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.FailoverNodes != nil {
+		in, out := &in.FailoverNodes, &out.FailoverNodes
+		*out = make([]StepNode, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -1849,6 +2551,27 @@ func (in *StepNode) DeepCopy() *StepNode {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StepErrorMatcher) DeepCopyInto(out *StepErrorMatcher) {
+	*out = *in
+	if in.ExitCodes != nil {
+		in, out := &in.ExitCodes, &out.ExitCodes
+		*out = make([]int, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StepErrorMatcher.
+func (in *StepErrorMatcher) DeepCopy() *StepErrorMatcher {
+	if in == nil {
+		return nil
+	}
+	out := new(StepErrorMatcher)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StepStatus) DeepCopyInto(out *StepStatus) {
 	*out = *in
@@ -1969,6 +2692,24 @@ func (in *TemplateList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WaitForCommand) DeepCopyInto(out *WaitForCommand) {
+	*out = *in
+	out.Interval = in.Interval
+	out.Timeout = in.Timeout
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WaitForCommand.
+func (in *WaitForCommand) DeepCopy() *WaitForCommand {
+	if in == nil {
+		return nil
+	}
+	out := new(WaitForCommand)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WebTerminal) DeepCopyInto(out *WebTerminal) {
 	*out = *in
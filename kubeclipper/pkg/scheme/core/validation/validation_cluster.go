@@ -0,0 +1,181 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/common"
+	corev1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ValidateCluster validates the cni of a created cluster.
+func ValidateCluster(c *corev1.Cluster) field.ErrorList {
+	if !cniManaged(c) {
+		return field.ErrorList{}
+	}
+	return ValidateClusterCNI(c, field.NewPath("cni"))
+}
+
+// ValidateClusterUpdate validates the cni of an updated cluster when it changes, the status updates of the
+// clusters do not fail on the cni checks added after the cluster was created. The cni type only changes by a cni
+// migration and the ipam mode of a running cluster only with it.
+func ValidateClusterUpdate(c, old *corev1.Cluster) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if !cniManaged(c) || apiequality.Semantic.DeepEqual(c.CNI, old.CNI) {
+		return allErrs
+	}
+	fldPath := field.NewPath("cni")
+	if c.CNI.Type != old.CNI.Type {
+		if err := cni.CheckMigration(old.CNI.Type, c.CNI.Type); err != nil {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("type"), "the cni type only changes by a cni migration: "+err.Error()))
+		}
+	} else if c.CNI.Type == "cilium" && old.Status.Phase == corev1.ClusterRunning && ciliumIPAMMode(c.CNI.Cilium) != ciliumIPAMMode(old.CNI.Cilium) {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("cilium", "ipamMode"),
+			"the ipam mode of a running cluster can not change, the pods keep the addresses of the old ipam"))
+	}
+	if len(allErrs) > 0 {
+		return allErrs
+	}
+	return ValidateClusterCNI(c, fldPath)
+}
+
+// ValidateClusterCNI validates the cni of c with the Validate of its registered stepper, the install runs the
+// same checks. The fields the steppers can not be built without are checked first.
+func ValidateClusterCNI(c *corev1.Cluster, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	factory, err := cni.Load(c.CNI.Type)
+	if err != nil {
+		var types []string
+		for _, r := range cni.Registrations() {
+			types = append(types, r.Type)
+		}
+		return append(allErrs, field.NotSupported(fldPath.Child("type"), c.CNI.Type, types))
+	}
+	if len(c.Networking.Pods.CIDRBlocks) == 0 {
+		allErrs = append(allErrs, field.Required(field.NewPath("networking", "pods", "cidrBlocks"), "the cni allocates the pod IPs from them"))
+	}
+	switch c.CNI.Type {
+	case "calico":
+		if c.CNI.Calico == nil {
+			allErrs = append(allErrs, field.Required(fldPath.Child("calico"), ""))
+		}
+	case "cilium":
+		allErrs = append(allErrs, validateCilium(c.CNI.Cilium, fldPath.Child("cilium"))...)
+	}
+	if len(allErrs) > 0 {
+		return allErrs
+	}
+
+	metadata := &component.ExtraMetadata{
+		ClusterName:   c.Name,
+		CRI:           c.ContainerRuntime.Type,
+		KubeProxyMode: c.Networking.ProxyMode,
+	}
+	for _, node := range c.Masters {
+		metadata.Masters = append(metadata.Masters, component.Node{ID: node.ID})
+	}
+	for _, node := range c.Workers {
+		metadata.Workers = append(metadata.Workers, component.Node{ID: node.ID})
+	}
+	if err = factory.Create().InitStep(metadata, &c.CNI, &c.Networking).Validate(); err != nil {
+		allErrs = append(allErrs, stepperFieldError(fldPath, c.CNI.Type, err))
+	}
+	if err = cni.ValidateNetworkAttachments(&c.CNI); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("networkAttachments"), len(c.CNI.NetworkAttachments), err.Error()))
+	}
+	return allErrs
+}
+
+// stepperFieldError reports err of the Validate of the stepper on the field of the cni it names, see
+// cni.FieldError, and on the cni of cniType otherwise.
+func stepperFieldError(fldPath *field.Path, cniType string, err error) *field.Error {
+	var fieldErr *cni.FieldError
+	if !errors.As(err, &fieldErr) {
+		return field.Invalid(fldPath, cniType, err.Error())
+	}
+	return fieldErr.Invalid(fldPath)
+}
+
+// validateCilium validates the ranges of the cilium fields.
+func validateCilium(config *corev1.Cilium, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if config == nil {
+		return allErrs
+	}
+	allErrs = append(allErrs, validateClusterPool(config.ClusterPoolIPv4PodCIDRList, config.ClusterPoolIPv4MaskSize, false,
+		fldPath.Child("clusterPoolIPv4PodCIDRList"), fldPath.Child("clusterPoolIPv4MaskSize"))...)
+	allErrs = append(allErrs, validateClusterPool(config.ClusterPoolIPv6PodCIDRList, config.ClusterPoolIPv6MaskSize, true,
+		fldPath.Child("clusterPoolIPv6PodCIDRList"), fldPath.Child("clusterPoolIPv6MaskSize"))...)
+	if config.OperatorReplicas < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("operatorReplicas"), config.OperatorReplicas, "must be greater than or equal to 0"))
+	}
+	if config.MTU < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("mtu"), config.MTU, "must be greater than or equal to 0"))
+	}
+	return allErrs
+}
+
+// validateClusterPool validates the CIDRs of a cluster pool are of the family and its mask size fits them.
+func validateClusterPool(cidrs []string, maskSize int, ipv6 bool, cidrsPath, maskSizePath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	family, bits := "IPv4", 32
+	if ipv6 {
+		family, bits = "IPv6", 128
+	}
+	for i, cidr := range cidrs {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil || (ip.To4() == nil) != ipv6 {
+			allErrs = append(allErrs, field.Invalid(cidrsPath.Index(i), cidr, "must be an "+family+" CIDR"))
+		}
+	}
+	if (len(cidrs) > 0 || maskSize != 0) && (maskSize < 1 || maskSize > bits) {
+		allErrs = append(allErrs, field.Invalid(maskSizePath, maskSize, fmt.Sprintf("must be between 1 and %d", bits)))
+	}
+	return allErrs
+}
+
+// ciliumIPAMMode the ipam mode config renders, the pools render the multi-pool ipam.
+func ciliumIPAMMode(config *corev1.Cilium) string {
+	switch {
+	case config == nil:
+		return cni.CiliumIPAMClusterPool
+	case config.IPAMMode != "":
+		return config.IPAMMode
+	case len(config.Pools) > 0:
+		return cni.CiliumIPAMMultiPool
+	}
+	return cni.CiliumIPAMClusterPool
+}
+
+// cniManaged whether kubeclipper installs the cni of c, the clusters of the cloud providers and the clusters
+// without a cni are left out.
+func cniManaged(c *corev1.Cluster) bool {
+	if c.Labels[common.LabelClusterProviderName] != "" {
+		return false
+	}
+	_, ok := c.Annotations[common.AnnotationOnlyInstallKubernetesComp]
+	return !ok
+}
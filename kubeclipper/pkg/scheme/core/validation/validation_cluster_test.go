@@ -0,0 +1,216 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/common"
+	corev1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func ciliumCluster(cilium *corev1.Cilium) *corev1.Cluster {
+	return &corev1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo"},
+		Masters:    corev1.WorkerNodeList{{ID: "node1"}},
+		Networking: corev1.Networking{
+			IPFamily: corev1.IPFamilyIPv4,
+			Services: corev1.NetworkRanges{CIDRBlocks: []string{"10.96.0.0/12"}},
+			Pods:     corev1.NetworkRanges{CIDRBlocks: []string{"172.25.0.0/16"}},
+		},
+		CNI: corev1.CNI{Type: "cilium", Version: "1.14.4", Cilium: cilium},
+	}
+}
+
+func TestValidateCluster(t *testing.T) {
+	tests := []struct {
+		name      string
+		cluster   *corev1.Cluster
+		wantErr   string
+		wantField string
+	}{
+		{
+			name:    "valid",
+			cluster: ciliumCluster(&corev1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"}, ClusterPoolIPv4MaskSize: 24}),
+		},
+		{
+			name:    "no cilium config",
+			cluster: ciliumCluster(nil),
+		},
+		{
+			name:    "mask size",
+			cluster: ciliumCluster(&corev1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"}, ClusterPoolIPv4MaskSize: 33}),
+			wantErr: "cni.cilium.clusterPoolIPv4MaskSize: Invalid value: 33: must be between 1 and 32",
+		},
+		{
+			name:    "cidr",
+			cluster: ciliumCluster(&corev1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/33"}, ClusterPoolIPv4MaskSize: 24}),
+			wantErr: `cni.cilium.clusterPoolIPv4PodCIDRList[0]: Invalid value: "10.0.0.0/33": must be an IPv4 CIDR`,
+		},
+		{
+			name: "cidr family",
+			cluster: ciliumCluster(&corev1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"}, ClusterPoolIPv4MaskSize: 24,
+				ClusterPoolIPv6PodCIDRList: []string{"10.1.0.0/16"}, ClusterPoolIPv6MaskSize: 120}),
+			wantErr: `cni.cilium.clusterPoolIPv6PodCIDRList[0]: Invalid value: "10.1.0.0/16": must be an IPv6 CIDR`,
+		},
+		{
+			name:    "operator replicas",
+			cluster: ciliumCluster(&corev1.Cilium{OperatorReplicas: -1}),
+			wantErr: "cni.cilium.operatorReplicas: Invalid value: -1: must be greater than or equal to 0",
+		},
+		{
+			name:      "stepper validation",
+			cluster:   ciliumCluster(&corev1.Cilium{TunnelMode: "gre"}),
+			wantErr:   `cni.cilium.tunnelMode: Invalid value: invalid cilium tunnel mode "gre"`,
+			wantField: "cni.cilium.tunnelMode",
+		},
+		{
+			name:      "stepper validation of a nested field",
+			cluster:   ciliumCluster(&corev1.Cilium{Encryption: &corev1.CiliumEncryption{Type: "tls"}}),
+			wantErr:   `invalid cilium encryption type "tls"`,
+			wantField: "cni.cilium.encryption.type",
+		},
+		{
+			name: "stepper validation of the cni",
+			cluster: func() *corev1.Cluster {
+				c := ciliumCluster(nil)
+				c.CNI.HelmExecutor = "node9"
+				return c
+			}(),
+			wantErr:   "the helm executor node9 is not a master of the cluster",
+			wantField: "cni.helmExecutor",
+		},
+		{
+			name: "unsupported type",
+			cluster: func() *corev1.Cluster {
+				c := ciliumCluster(nil)
+				c.CNI.Type = "weave"
+				return c
+			}(),
+			wantErr: `cni.type: Unsupported value: "weave"`,
+		},
+		{
+			name: "calico without config",
+			cluster: func() *corev1.Cluster {
+				c := ciliumCluster(nil)
+				c.CNI = corev1.CNI{Type: "calico", Version: "v3.26.1"}
+				return c
+			}(),
+			wantErr: "cni.calico: Required value",
+		},
+		{
+			name: "cluster of a provider",
+			cluster: func() *corev1.Cluster {
+				c := ciliumCluster(nil)
+				c.Labels = map[string]string{common.LabelClusterProviderName: "kubeadm"}
+				c.CNI = corev1.CNI{Type: "unknown"}
+				return c
+			}(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidateCluster(tt.cluster)
+			if tt.wantErr == "" {
+				if len(errs) > 0 {
+					t.Errorf("ValidateCluster() = %v", errs.ToAggregate())
+				}
+				return
+			}
+			if len(errs) == 0 || !strings.Contains(errs.ToAggregate().Error(), tt.wantErr) {
+				t.Errorf("ValidateCluster() = %v, want %s", errs.ToAggregate(), tt.wantErr)
+			}
+			if tt.wantField != "" && (len(errs) != 1 || errs[0].Field != tt.wantField) {
+				t.Errorf("ValidateCluster() = %v, want the error of %s", errs.ToAggregate(), tt.wantField)
+			}
+		})
+	}
+}
+
+func TestValidateClusterUpdate(t *testing.T) {
+	running := func(c *corev1.Cluster) *corev1.Cluster {
+		c.Status.Phase = corev1.ClusterRunning
+		return c
+	}
+	calico := func() *corev1.Cluster {
+		c := ciliumCluster(nil)
+		c.CNI = corev1.CNI{Type: "calico", Version: "v3.26.1", Calico: &corev1.Calico{Mode: "Overlay-Vxlan-All", MTU: 1440}}
+		return running(c)
+	}
+	tests := []struct {
+		name    string
+		cluster *corev1.Cluster
+		old     *corev1.Cluster
+		wantErr string
+	}{
+		{
+			name:    "cni unchanged",
+			cluster: ciliumCluster(&corev1.Cilium{OperatorReplicas: -1}),
+			old:     running(ciliumCluster(&corev1.Cilium{OperatorReplicas: -1})),
+		},
+		{
+			name:    "migration",
+			cluster: ciliumCluster(nil),
+			old:     calico(),
+		},
+		{
+			name: "type",
+			cluster: func() *corev1.Cluster {
+				c := ciliumCluster(nil)
+				c.CNI = corev1.CNI{Type: "flannel", Version: "v0.22.0"}
+				return c
+			}(),
+			old:     calico(),
+			wantErr: "cni.type: Forbidden: the cni type only changes by a cni migration",
+		},
+		{
+			name:    "ipam mode",
+			cluster: ciliumCluster(&corev1.Cilium{Pools: []corev1.CiliumPodIPPool{{Name: "default", CIDR: "10.10.0.0/16", MaskSize: 24}}}),
+			old:     running(ciliumCluster(nil)),
+			wantErr: "cni.cilium.ipamMode: Forbidden: the ipam mode of a running cluster can not change",
+		},
+		{
+			name:    "ipam mode before the cluster runs",
+			cluster: ciliumCluster(&corev1.Cilium{IPAMMode: "cluster-pool", EnableHubble: true}),
+			old:     ciliumCluster(nil),
+		},
+		{
+			name:    "changed field",
+			cluster: ciliumCluster(&corev1.Cilium{ClusterPoolIPv4PodCIDRList: []string{"10.0.0.0/16"}, ClusterPoolIPv4MaskSize: 33}),
+			old:     running(ciliumCluster(nil)),
+			wantErr: "cni.cilium.clusterPoolIPv4MaskSize: Invalid value: 33",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidateClusterUpdate(tt.cluster, tt.old)
+			if tt.wantErr == "" {
+				if len(errs) > 0 {
+					t.Errorf("ValidateClusterUpdate() = %v", errs.ToAggregate())
+				}
+				return
+			}
+			if len(errs) == 0 || !strings.Contains(errs.ToAggregate().Error(), tt.wantErr) {
+				t.Errorf("ValidateClusterUpdate() = %v, want %s", errs.ToAggregate(), tt.wantErr)
+			}
+		})
+	}
+}
@@ -472,7 +472,7 @@ var Roles = GlobalRoleList{
 		Rules: []rbacv1.PolicyRule{
 			{
 				APIGroups: []string{"core.kubeclipper.io"},
-				Resources: []string{"clusters", "nodes", "regions", "operations", "logs", "clusters/upgrade", "nodes/terminal"},
+				Resources: []string{"clusters", "nodes", "regions", "operations", "operations/progress", "logs", "clusters/upgrade", "nodes/terminal"},
 				Verbs:     []string{"get", "list", "watch"},
 			},
 			{
@@ -508,7 +508,7 @@ var Roles = GlobalRoleList{
 		Rules: []rbacv1.PolicyRule{
 			{
 				APIGroups: []string{"core.kubeclipper.io"},
-				Resources: []string{"clusters", "nodes", "regions", "operations/retry", "clusters/upgrade"},
+				Resources: []string{"clusters", "nodes", "regions", "operations/retry", "operations/approve", "clusters/upgrade", "cni"},
 				Verbs:     []string{"create"},
 			},
 			{
@@ -32,6 +32,7 @@ import (
 	"k8s.io/apiserver/pkg/storage/names"
 
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/validation"
 )
 
 var (
@@ -88,7 +89,7 @@ func (ClusterStrategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Ob
 }
 
 func (ClusterStrategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
-	return field.ErrorList{}
+	return validation.ValidateCluster(obj.(*v1.Cluster))
 }
 
 func (ClusterStrategy) AllowCreateOnUpdate() bool {
@@ -103,5 +104,5 @@ func (ClusterStrategy) Canonicalize(obj runtime.Object) {
 }
 
 func (ClusterStrategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
-	return field.ErrorList{}
+	return validation.ValidateClusterUpdate(obj.(*v1.Cluster), old.(*v1.Cluster))
 }
@@ -511,6 +511,11 @@ func (s *APIServer) SetupController(mgr manager.Manager, informerFactory informe
 		CmdDelivery:         mgr.GetCmdDelivery(),
 		CloudProviderLister: informerFactory.Core().V1().CloudProviders().Lister(),
 	}).SetupWithManager(mgr)
+	(&controller.CNIStatusMon{
+		ClusterWriter: clusterOperator,
+		ClusterLister: informerFactory.Core().V1().Clusters().Lister(),
+		CmdDelivery:   mgr.GetCmdDelivery(),
+	}).SetupWithManager(mgr)
 	(&controller.NodeStatusMon{
 		NodeLister:  informerFactory.Core().V1().Nodes().Lister(),
 		LeaseLister: informerFactory.Core().V1().Leases().Lister(),
@@ -27,6 +27,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/kubeclipper/kubeclipper/pkg/clusteroperation"
 	"github.com/kubeclipper/kubeclipper/pkg/controller"
 
 	"github.com/kubeclipper/kubeclipper/pkg/oplog"
@@ -44,8 +45,10 @@ import (
 	"github.com/kubeclipper/kubeclipper/pkg/models/lease"
 	"github.com/kubeclipper/kubeclipper/pkg/models/operation"
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
 	"github.com/kubeclipper/kubeclipper/pkg/service"
 	"github.com/kubeclipper/kubeclipper/pkg/simple/client/natsio"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
 )
 
 var _ service.Interface = (*Service)(nil)
@@ -53,12 +56,19 @@ var _ service.IDelivery = (*Service)(nil)
 
 const (
 	updateOperationStatusRetry = 10
+	// cancelStepTimeout the time a node has to acknowledge the cancellation of a step
+	cancelStepTimeout = 10 * time.Second
 )
 
 type stepStatus struct {
 	OperationIdentity  string
 	OperationCondition v1.OperationCondition
 	DryRun             bool
+	// InputHash the input hash of the step completed successfully, it is recorded on the operation.
+	InputHash string
+	// RolledBack the IDs of the completed steps whose component was rolled back, their records are removed
+	// so that a retry runs them again.
+	RolledBack []string
 }
 
 type Service struct {
@@ -72,6 +82,7 @@ type Service struct {
 	opOperator        operation.Operator
 	stepStatusChan    chan stepStatus
 	terminationChan   *chan struct{}
+	progress          *progressHub
 }
 
 func NewService(opts *natsio.NatsOptions, clusterOperator cluster.Operator, leaseOperator lease.Operator, opOperator operation.Operator, terminationChan *chan struct{}) *Service {
@@ -86,6 +97,7 @@ func NewService(opts *natsio.NatsOptions, clusterOperator cluster.Operator, leas
 		opOperator:        opOperator,
 		stepStatusChan:    make(chan stepStatus, 256),
 		terminationChan:   terminationChan,
+		progress:          newProgressHub(),
 	}
 	s.client.SetReconnectHandler(s.defaultMQReconnectHandler)
 	s.client.SetDisconnectErrHandler(s.defaultMQDisconnectHandler)
@@ -126,6 +138,10 @@ func (s *Service) Run(stopCh <-chan struct{}) error {
 	if err := s.client.QueueSubscribe(s.nodeReportSubject, s.queueGroup, s.nodeStateReportInHandler); err != nil {
 		return err
 	}
+	// every server keeps the progress for its watchers
+	if err := s.client.Subscribe(service.StepProgressSubject(s.nodeReportSubject), s.stepProgressHandler); err != nil {
+		return err
+	}
 	go s.stepStatusChannelController()
 	return nil
 }
@@ -171,11 +187,30 @@ func (s *Service) stepStatusChannelController() {
 				continue
 			}
 
-			stepLen := len(o.Status.Conditions)
-			if stepLen > 0 && status.OperationCondition.StepID == o.Status.Conditions[stepLen-1].StepID {
-				o.Status.Conditions[stepLen-1].Status = append(o.Status.Conditions[stepLen-1].Status, status.OperationCondition.Status...)
+			if len(status.RolledBack) > 0 {
+				for _, id := range status.RolledBack {
+					delete(o.Status.Completed, id)
+					delete(o.Status.CompletedReplies, id)
+				}
 			} else {
-				o.Status.Conditions = append(o.Status.Conditions, status.OperationCondition)
+				stepLen := len(o.Status.Conditions)
+				if stepLen > 0 && status.OperationCondition.StepID == o.Status.Conditions[stepLen-1].StepID {
+					o.Status.Conditions[stepLen-1].Status = append(o.Status.Conditions[stepLen-1].Status, status.OperationCondition.Status...)
+				} else {
+					o.Status.Conditions = append(o.Status.Conditions, status.OperationCondition)
+				}
+				if status.InputHash != "" {
+					if o.Status.Completed == nil {
+						o.Status.Completed = make(map[string]string)
+					}
+					o.Status.Completed[status.OperationCondition.StepID] = status.InputHash
+					if reply := stepResponse(&status.OperationCondition); reply != nil {
+						if o.Status.CompletedReplies == nil {
+							o.Status.CompletedReplies = make(map[string][]byte)
+						}
+						o.Status.CompletedReplies[status.OperationCondition.StepID] = reply
+					}
+				}
 			}
 
 			if _, err := s.opOperator.UpdateOperation(context.TODO(), o); err != nil {
@@ -189,6 +224,8 @@ func (s *Service) stepStatusChannelController() {
 }
 
 func (s *Service) updateOperationStatus(op string, status v1.OperationStatusType, dryRun bool) {
+	// the watchers of the operation progress stop with the operation
+	defer s.publishProgress(service.StepProgress{Operation: op, OperationStatus: status})
 	if dryRun {
 		logger.Debug("dry run update operation status", zap.String("status", string(status)))
 		return
@@ -231,6 +268,11 @@ func (s *Service) syncClusterCondition(op *v1.Operation, clu *v1.Cluster) error
 	case v1.OperationCreateCluster:
 		if op.Status.Status == v1.OperationStatusSuccessful {
 			clu.Status.Phase = v1.ClusterRunning
+			clu.Status.Versions.CNI = clu.CNI.Version
+			clu.Status.Versions.CNIPlacement = cni.AgentPlacementHash(&clu.CNI)
+			clu.Status.Versions.CNIBGP = cni.BGPPeeringHash(&clu.CNI)
+			clu.Status.Versions.CNIType = clu.CNI.Type
+			setCNIRelease(op, clu)
 		} else {
 			clu.Status.Phase = v1.ClusterInstallFailed
 		}
@@ -359,6 +401,51 @@ func (s *Service) syncClusterCondition(op *v1.Operation, clu *v1.Cluster) error
 			return err
 		}
 		return nil
+	case v1.OperationUpgradeCNI:
+		if op.Status.Status == v1.OperationStatusPendingApproval {
+			// the cluster stays updating until the diff is approved
+			return nil
+		}
+		if op.Status.Status == v1.OperationStatusSuccessful {
+			clu.Status.Phase = v1.ClusterRunning
+			clu.Status.Versions.CNI = clu.CNI.Version
+			clu.Status.Versions.CNIPlacement = cni.AgentPlacementHash(&clu.CNI)
+			clu.Status.Versions.CNIBGP = cni.BGPPeeringHash(&clu.CNI)
+			setCNIRelease(op, clu)
+		} else {
+			clu.Status.Phase = v1.ClusterUpdateFailed
+		}
+		if _, err := s.clusterOperator.UpdateCluster(context.TODO(), clu); err != nil {
+			return err
+		}
+		return nil
+	case v1.OperationCheckCNI:
+		// the health check does not change the cluster, the results are the step responses
+		return nil
+	case v1.OperationConnectClusterMesh:
+		// the clusters carry the cluster mesh configuration already, the connection does not change them
+		return nil
+	case v1.OperationCheckCNIConnectivity:
+		// the connectivity test does not change the cluster, the results are the step responses
+		return nil
+	case v1.OperationRunCNIOperation:
+		// the output of the cni operation is the step output, the cluster is not changed
+		return nil
+	case v1.OperationMigrateCNI:
+		if op.Status.Status == v1.OperationStatusSuccessful {
+			clu.Status.Phase = v1.ClusterRunning
+			clu.Status.Versions.CNI = clu.CNI.Version
+			clu.Status.Versions.CNIPlacement = cni.AgentPlacementHash(&clu.CNI)
+			clu.Status.Versions.CNIBGP = cni.BGPPeeringHash(&clu.CNI)
+			clu.Status.Versions.CNIType = clu.CNI.Type
+			setCNIRelease(op, clu)
+		} else {
+			clu.Status.Phase = v1.ClusterUpdateFailed
+		}
+		if _, err := s.clusterOperator.UpdateCluster(context.TODO(), clu); err != nil {
+			return err
+		}
+		return nil
 	default:
 		logger.Error("unsupported operation action", zap.String("operation", op.Name),
 			zap.String("cluster", clu.Name), zap.String("action", v))
@@ -366,6 +453,32 @@ func (s *Service) syncClusterCondition(op *v1.Operation, clu *v1.Cluster) error
 	}
 }
 
+// setCNIRelease records the cni release the operation installed in the cluster status, the record is
+// the response of the v1.StepRecordCNIRelease step. The previous record is kept when there is none.
+func setCNIRelease(op *v1.Operation, clu *v1.Cluster) {
+	for _, step := range op.Steps {
+		if step.Name != v1.StepRecordCNIRelease {
+			continue
+		}
+		for _, cond := range op.Status.Conditions {
+			if cond.StepID != step.ID {
+				continue
+			}
+			for _, status := range cond.Status {
+				if status.Status != v1.StepStatusSuccessful || len(status.Response) == 0 {
+					continue
+				}
+				record := &v1.CNIReleaseRecord{}
+				if err := json.Unmarshal(status.Response, record); err != nil {
+					logger.Warn("unmarshal cni release record failed", zap.String("operation", op.Name), zap.Error(err))
+					continue
+				}
+				clu.Status.CNIRelease = record
+			}
+		}
+	}
+}
+
 func (s *Service) updateNodeRoleLabel(clusterName, nodeName string, role common.NodeRole, del bool) error {
 	node, err := s.clusterOperator.GetNodeEx(context.TODO(), nodeName, "0")
 	if err != nil {
@@ -415,8 +528,15 @@ func (s *Service) DeliverTaskOperation(ctx context.Context, operation *v1.Operat
 	defer close(doneChan)
 	errChan := make(chan error, 1)
 	defer close(errChan)
+	approvalChan := make(chan struct{}, 1)
+	defer close(approvalChan)
 	operation.Status.Conditions = make([]v1.OperationCondition, len(operation.Steps))
+	// the operation was read back from the storage with its secrets redacted
+	restoreSecrets(operation)
 	var termination bool
+	// running the steps being delivered keyed by their index, they are cancelled on their nodes when the operation
+	// is terminated
+	var running sync.Map
 	go func() {
 		for {
 			select {
@@ -439,6 +559,10 @@ func (s *Service) DeliverTaskOperation(ctx context.Context, operation *v1.Operat
 						stepCtxCancel()
 						// termination step flag
 						termination = true
+						running.Range(func(_, step any) bool {
+							go s.cancelStep(operation.Name, step.(*v1.Step))
+							return true
+						})
 
 						go s.updateOperationStatus(operation.Name, v1.OperationStatusTermination, opts.DryRun)
 						return
@@ -446,44 +570,96 @@ func (s *Service) DeliverTaskOperation(ctx context.Context, operation *v1.Operat
 				}
 			case <-doneChan:
 				// all step done, set operation status successful
+				forgetSecrets(operation.Name)
 				go s.updateOperationStatus(operation.Name, v1.OperationStatusSuccessful, opts.DryRun)
 				return
 			case <-errChan:
 				// step run error and step ignoreError flag is false
 				go s.updateOperationStatus(operation.Name, v1.OperationStatusFailed, opts.DryRun)
 				return
+			case <-approvalChan:
+				// the diff waits for the approval, the secrets are kept for the approved steps
+				go s.updateOperationStatus(operation.Name, v1.OperationStatusPendingApproval, opts.DryRun)
+				return
 			}
 		}
 	}()
 	var err error
+	failed := -1
+	pending := false
+	// moved the nodes the steps failed over to, keyed by the node they moved from
+	moved := make(map[string]string)
+	// replies the last step reply each step is delivered with, the staging steps are given it again on a failover
+	replies := make([][]byte, len(operation.Steps))
+	// launched the parallel steps started ahead of their turn keyed by their index, see v1.Step Parallel
+	launched := make(map[int]*parallelRun)
+	deps := clusteroperation.Dependencies(operation.Steps)
 	for i, step := range operation.Steps {
 		if termination {
 			logger.Debug("termination delivery task step", zap.String("operation", operation.Name), zap.String("step", step.Name))
 			break
 		}
+		for _, p := range parallelReady(operation, i, deps, launched, opts.Force) {
+			pinFailover(&operation.Steps[p], moved)
+			running.Store(p, &operation.Steps[p])
+			prior, priorReplies := parallelPrior(operation.Steps, replies, i, p, launched)
+			stage := s.failoverStaging(operation.Name, prior, priorReplies, &operation.Steps[p], moved, opts.DryRun, component.GetRetry(stepCtx))
+			logger.Info("start the parallel step", zap.String("operation", operation.Name), zap.String("step", operation.Steps[p].Name))
+			launched[p] = s.deliveryParallelStep(stepCtx, operation.Name, &operation.Steps[p], &operation.Status.Conditions[p], stage, opts.DryRun)
+		}
+		if run, ok := launched[i]; ok {
+			status, runErr := run.wait()
+			running.Delete(i)
+			if !failedOverFrom(&operation.Steps[i], moved) {
+				s.sendStepStatusToChannel(status)
+				recordFailover(&operation.Steps[i], &operation.Status.Conditions[i], moved)
+				if err = runErr; err != nil {
+					logger.Error("delivery parallel step error", zap.Error(err), zap.String("step", step.Name))
+					if step.ErrIgnore || opts.ForceSkipError {
+						err = nil
+						continue
+					}
+					failed = i
+					break
+				}
+				continue
+			}
+			// the steps before it failed over from its node meanwhile, it runs again next to them
+			logger.Info("deliver the parallel step again on the failover node", zap.String("operation", operation.Name), zap.String("step", step.Name))
+		}
 		// TODO: add retry steps
 		// TODO: refactor
 		// Notice: 目前只针对 CUSTOM 命令有用，下一步骤依赖上一步骤的输出，比如 K8S 安装时初始化一个 K8S 控制节点后得到 kubeadm join 命令，需要传给其他节点进行执行
 		// len(steps) > 0
-		if i-1 > 0 {
-			// Steps will not be run when nodes field is empty,
-			// so there is no running status.
-			// May be out of list range here.
-			if len(operation.Status.Conditions[i-1].Status) < 1 {
-				if !opts.ForceSkipError {
-					return errors.New("unexpected error, steps node field must be valid")
-				}
-				err = s.deliveryTaskStep(stepCtx, operation.Name, &operation.Steps[i],
-					nil, &operation.Status.Conditions[i], opts.DryRun)
-			} else {
-				logger.Info("last response", zap.ByteString("response", operation.Status.Conditions[i-1].Status[0].Response))
-				err = s.deliveryTaskStep(stepCtx, operation.Name, &operation.Steps[i],
-					operation.Status.Conditions[i-1].Status[0].Response, &operation.Status.Conditions[i], opts.DryRun)
-			}
-		} else {
-			err = s.deliveryTaskStep(stepCtx, operation.Name, &operation.Steps[i],
-				component.GetExtraData(ctx), &operation.Status.Conditions[i], opts.DryRun)
+		pinFailover(&operation.Steps[i], moved)
+		running.Store(i, &operation.Steps[i])
+		lastReply, ok := lastStepReply(ctx, operation, i)
+		replies[i] = lastReply
+		if !opts.Force && operation.Status.StepCompleted(&operation.Steps[i]) {
+			logger.Info("skip the step completed in a prior attempt", zap.String("operation", operation.Name), zap.String("step", step.Name))
+			s.skipTaskStep(operation.Name, &operation.Steps[i], &operation.Status.Conditions[i], "the step completed in a prior attempt of the operation",
+				operation.Status.CompletedReplies[step.ID], opts.DryRun)
+			continue
+		}
+		if operation.Steps[i].SecretsRedacted() {
+			err = fmt.Errorf("the secrets of step %s are no longer available, the server restarted or the operation failed too long ago", step.Name)
+			logger.Error("delivery task step error", zap.Error(err), zap.String("operation", operation.Name))
+			s.failTaskStep(operation.Name, &operation.Steps[i], &operation.Status.Conditions[i], err, opts.DryRun)
+			failed = i
+			break
+		}
+		// Steps will not be run when nodes field is empty,
+		// so there is no running status.
+		if !ok && !opts.ForceSkipError {
+			waitParallel(launched)
+			return errors.New("unexpected error, steps node field must be valid")
 		}
+		logger.Info("last response", zap.ByteString("response", lastReply))
+		stage := s.failoverStaging(operation.Name, operation.Steps[:i], replies[:i], &operation.Steps[i], moved, opts.DryRun, component.GetRetry(stepCtx))
+		err = s.deliveryTaskStep(stepCtx, operation.Name, &operation.Steps[i], lastReply, &operation.Status.Conditions[i], stage,
+			s.sendStepStatusToChannel, opts.DryRun)
+		running.Delete(i)
+		recordFailover(&operation.Steps[i], &operation.Status.Conditions[i], moved)
 		logger.Debug("after delivery task step", zap.Error(err))
 		if err != nil {
 			logger.Error("delivery task step error", zap.Error(err), zap.String("step", step.Name))
@@ -493,17 +669,151 @@ func (s *Service) DeliverTaskOperation(ctx context.Context, operation *v1.Operat
 				err = nil
 				continue
 			}
+			failed = i
 			break
 		}
+		if operation.Steps[i].Diff && !opts.DryRun {
+			if len(stepResponse(&operation.Status.Conditions[i])) == 0 {
+				logger.Info("the diff is empty, skip the remaining steps", zap.String("operation", operation.Name), zap.String("step", step.Name))
+				for j := i + 1; j < len(operation.Steps); j++ {
+					s.skipTaskStep(operation.Name, &operation.Steps[j], &operation.Status.Conditions[j], "the diff of the operation is empty", nil, opts.DryRun)
+				}
+				break
+			}
+			if operation.Labels[common.LabelOperationConfirm] == "false" {
+				logger.Info("the operation waits for the approval of the diff", zap.String("operation", operation.Name), zap.String("step", step.Name))
+				pending = true
+				break
+			}
+		}
 	}
-	if err != nil {
+	// the parallel steps still running when the operation stopped are not recorded, a retry runs them again
+	waitParallel(launched)
+	switch {
+	case err != nil:
+		if !termination && failed >= 0 {
+			s.rollbackOperation(operation, failed, opts.DryRun)
+		}
 		errChan <- err
-	} else {
+	case pending:
+		approvalChan <- struct{}{}
+	default:
 		doneChan <- struct{}{}
 	}
 	return nil
 }
 
+// parallelRun a parallel step delivered ahead of its turn, see v1.Step Parallel.
+type parallelRun struct {
+	done   chan struct{}
+	err    error
+	status chan stepStatus
+}
+
+// wait waits on the step, its status is recorded by the caller so that the statuses are recorded in the order of
+// the operation.
+func (r *parallelRun) wait() (stepStatus, error) {
+	<-r.done
+	return <-r.status, r.err
+}
+
+// finished reports whether the step completed successfully.
+func (r *parallelRun) finished() bool {
+	select {
+	case <-r.done:
+		return r.err == nil
+	default:
+		return false
+	}
+}
+
+// deliveryParallelStep starts delivering step, its status is recorded when the operation reaches its turn.
+func (s *Service) deliveryParallelStep(ctx context.Context, opName string, step *v1.Step, cond *v1.OperationCondition, stage failoverStage, dryRun bool) *parallelRun {
+	run := &parallelRun{done: make(chan struct{}), status: make(chan stepStatus, 1)}
+	go func() {
+		defer close(run.done)
+		run.err = s.deliveryTaskStep(ctx, opName, step, nil, cond, stage, func(status stepStatus) {
+			run.status <- status
+		}, dryRun)
+	}()
+	return run
+}
+
+// waitParallel waits on the parallel steps still running.
+func waitParallel(launched map[int]*parallelRun) {
+	for _, run := range launched {
+		<-run.done
+	}
+}
+
+// parallelPrior the steps before the parallel step p started at step i of the operation and their replies: the
+// steps before step i and the parallel steps between them which completed, see failoverStaging.
+func parallelPrior(steps []v1.Step, replies [][]byte, i, p int, launched map[int]*parallelRun) ([]v1.Step, [][]byte) {
+	prior, priorReplies := steps[:i:i], replies[:i:i]
+	for j := i; j < p; j++ {
+		if run, ok := launched[j]; ok && run.finished() {
+			prior, priorReplies = append(prior, steps[j]), append(priorReplies, nil)
+		}
+	}
+	return prior, priorReplies
+}
+
+// parallelReady the parallel steps after step i of operation which may start along with it: the steps of their
+// component they depend on and the steps of the other components before them completed. The steps after a diff
+// wait on its approval, the steps completed in a prior attempt or without their secrets wait on their turn.
+func parallelReady(operation *v1.Operation, i int, deps [][]int, launched map[int]*parallelRun, force bool) []int {
+	var ready []int
+	for p := i + 1; p < len(operation.Steps); p++ {
+		step := &operation.Steps[p]
+		if operation.Steps[p-1].Diff || step.Diff {
+			break
+		}
+		if _, ok := launched[p]; ok || !step.Parallel || len(step.Nodes) == 0 || step.SecretsRedacted() ||
+			!force && operation.Status.StepCompleted(step) {
+			continue
+		}
+		completed := func(j int) bool {
+			if run, ok := launched[j]; ok && j >= i {
+				return run.finished()
+			}
+			return j < i
+		}
+		waits := false
+		for j := 0; j < p && !waits; j++ {
+			waits = operation.Steps[j].Component != step.Component && !completed(j)
+		}
+		for _, j := range deps[p] {
+			waits = waits || !completed(j)
+		}
+		if !waits {
+			ready = append(ready, p)
+		}
+	}
+	return ready
+}
+
+// lastStepReply the reply step i of operation is delivered with, the response of the previous step or the extra
+// data of ctx for the first steps. ok is false when the previous step ran on no node.
+func lastStepReply(ctx context.Context, operation *v1.Operation, i int) (reply []byte, ok bool) {
+	if i-1 <= 0 {
+		return component.GetExtraData(ctx), true
+	}
+	if len(operation.Status.Conditions[i-1].Status) < 1 {
+		return nil, false
+	}
+	return operation.Status.Conditions[i-1].Status[0].Response, true
+}
+
+// stepResponse the first non-empty response of the nodes of the step of cond.
+func stepResponse(cond *v1.OperationCondition) []byte {
+	for _, status := range cond.Status {
+		if len(status.Response) > 0 {
+			return status.Response
+		}
+	}
+	return nil
+}
+
 func (s *Service) DeliverLogRequest(ctx context.Context, operation *service.LogOperation) (opResp oplog.LogContentResponse, err error) {
 	pb, err := initPayload(operation.OperationIdentity, operation.Op, nil, nil, nil, false, component.GetRetry(ctx))
 	if err != nil {
@@ -530,22 +840,77 @@ func (s *Service) DeliverLogRequest(ctx context.Context, operation *service.LogO
 	return
 }
 
+func (s *Service) DeliverFileRequest(ctx context.Context, operation *service.LogOperation) (opResp oplog.FileContentResponse, err error) {
+	pb, err := initPayload(operation.OperationIdentity, service.OperationFile, nil, nil, nil, false, component.GetRetry(ctx))
+	if err != nil {
+		return
+	}
+	msg := &natsio.Msg{
+		Subject: fmt.Sprintf(service.MsgSubjectFormat, operation.To, s.subjectSuffix),
+		Data:    pb,
+	}
+	data, err := s.client.RequestWithContext(ctx, msg)
+	if err != nil {
+		return
+	}
+	resp := &service.CommonReply{}
+	if err = json.Unmarshal(data, resp); err != nil {
+		logger.Error("unmarshal agent reply error", zap.Error(err))
+		return
+	}
+	if resp.Error != nil {
+		return opResp, resp.Error
+	}
+	if err = json.Unmarshal(resp.Data, &opResp); err != nil {
+		logger.Error("unmarshal operation file response error", zap.Error(err))
+		return
+	}
+	return
+}
+
+func (s *Service) DeliverCacheGCRequest(ctx context.Context, node string, req *downloader.CacheGCRequest) (*downloader.CacheGCResult, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	pb, err := initPayload(string(data), service.OperationCacheGC, nil, nil, nil, false, component.GetRetry(ctx))
+	if err != nil {
+		return nil, err
+	}
+	msg := &natsio.Msg{
+		Subject: fmt.Sprintf(service.MsgSubjectFormat, node, s.subjectSuffix),
+		Data:    pb,
+	}
+	data, err = s.client.RequestWithContext(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	resp := &service.CommonReply{}
+	if err = json.Unmarshal(data, resp); err != nil {
+		logger.Error("unmarshal agent reply error", zap.Error(err))
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	result := &downloader.CacheGCResult{}
+	if err = json.Unmarshal(resp.Data, result); err != nil {
+		logger.Error("unmarshal cache gc response error", zap.Error(err))
+		return nil, err
+	}
+	return result, nil
+}
+
 func (s *Service) DeliverStep(ctx context.Context, step *v1.Step, opts *service.Options) error {
 	if opts == nil {
 		opts = &service.Options{DryRun: false}
 	}
 	errChan := make(chan error, len(step.Nodes))
 	defer close(errChan)
-	wg := &sync.WaitGroup{}
-	status := new(v1.StepStatus)
+	status := make([]v1.StepStatus, len(step.Nodes))
 	payloadBytes, err := initPayload("", service.OperationRunStep, step, nil, nil, opts.DryRun, component.GetRetry(ctx))
 
-	for _, node := range step.Nodes {
-		wg.Add(1)
-		go s.deliveryStepToNode(wg, node.ID, payloadBytes, step.Timeout.Duration+2*time.Second, status, errChan)
-	}
-
-	wg.Wait()
+	s.deliveryStepToNodes("", step, payloadBytes, status, nil, errChan)
 
 	logger.Debug("after delivery task step", zap.Error(err))
 	if err != nil {
@@ -578,12 +943,17 @@ func (s *Service) DeliverCmd(ctx context.Context, toNode string, cmds []string,
 	return resp.Data, nil
 }
 
-func (s *Service) deliveryTaskStep(ctx context.Context, opName string, step *v1.Step, lastStepReply []byte, cond *v1.OperationCondition, dryRun bool) error {
+// deliveryTaskStep delivers step and records its status with record once it completed or ctx is done.
+func (s *Service) deliveryTaskStep(ctx context.Context, opName string, step *v1.Step, lastStepReply []byte, cond *v1.OperationCondition, stage failoverStage,
+	record func(stepStatus), dryRun bool) error {
 	payloadBytes, err := initPayload(opName, service.OperationRunTask, step, lastStepReply, nil, dryRun, component.GetRetry(ctx))
 	if err != nil {
 		return err
 	}
 
+	// recorded the status is recorded before the step returns, the statuses are recorded in the order of the steps
+	recorded := make(chan struct{})
+	defer func() { <-recorded }()
 	doneChan := make(chan struct{}, 1)
 	defer close(doneChan)
 	status := make([]v1.StepStatus, len(step.Nodes))
@@ -594,11 +964,12 @@ func (s *Service) deliveryTaskStep(ctx context.Context, opName string, step *v1.
 	//	Status: status,
 	// }
 	go func(op string, cond *v1.OperationCondition) {
+		defer close(recorded)
 		for {
 			select {
 			case <-ctx.Done():
 				// operation timeout
-				s.sendStepStatusToChannel(stepStatus{
+				record(stepStatus{
 					OperationIdentity:  op,
 					OperationCondition: *cond,
 					DryRun:             dryRun,
@@ -607,29 +978,23 @@ func (s *Service) deliveryTaskStep(ctx context.Context, opName string, step *v1.
 			case <-doneChan:
 				// step done
 				logger.Debug("in step done cond", zap.Any("condition", *cond))
-				s.sendStepStatusToChannel(stepStatus{
+				record(stepStatus{
 					OperationIdentity:  op,
 					OperationCondition: *cond,
 					DryRun:             dryRun,
+					InputHash:          step.InputHash,
 				})
 				return
 			}
 		}
 	}(opName, cond)
 
-	wg := sync.WaitGroup{}
 	// NOTE: per node can send one error only.
 	errChan := make(chan error, len(step.Nodes))
 	defer close(errChan)
 
-	for i, node := range step.Nodes {
-		wg.Add(1)
-		// notice: make sure step timeout less than operation timeout
-		// TODO: add step retry
-		go s.deliveryStepToNode(&wg, node.ID, payloadBytes, step.Timeout.Duration+2*time.Second, &status[i], errChan)
-	}
-
-	wg.Wait()
+	// notice: make sure step timeout less than operation timeout
+	s.deliveryStepToNodes(opName, step, payloadBytes, status, stage, errChan)
 
 	if len(errChan) > 0 {
 		logger.Debug("err chan has value...")
@@ -640,9 +1005,188 @@ func (s *Service) deliveryTaskStep(ctx context.Context, opName string, step *v1.
 	return nil
 }
 
-func (s *Service) deliveryStepToNode(wg *sync.WaitGroup, node string, payload []byte, timeout time.Duration, stepStatus *v1.StepStatus, errChan chan error) {
-	defer wg.Done()
+// deliveryStepToNodes runs payload of step on the nodes of the step, on at most Concurrency nodes at once when it is
+// positive. Every node records its own status and sends its error to errChan. A failed node does not stop the
+// others unless the step fails fast, the nodes not started yet are then recorded cancelled without running and
+// the running ones are cancelled.
+func (s *Service) deliveryStepToNodes(opName string, step *v1.Step, payload []byte, status []v1.StepStatus, stage failoverStage, errChan chan error) {
+	limit := len(step.Nodes)
+	if step.Concurrency > 0 && int(step.Concurrency) < limit {
+		limit = int(step.Concurrency)
+	}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		failed  string
+		running = make(map[string]bool)
+		slots   = make(chan struct{}, limit)
+	)
+	for i, node := range step.Nodes {
+		slots <- struct{}{}
+		mu.Lock()
+		stopped := failed
+		if stopped == "" {
+			running[node.ID] = true
+		}
+		mu.Unlock()
+		if stopped != "" {
+			<-slots
+			status[i].Node = node.ID
+			status[i].StartAt = metav1.Now()
+			setStepStatus(&status[i], v1.StepStatusCancelled, "step not run", fmt.Sprintf("the step failed fast on node %s", stopped), nil)
+			s.publishStepStatus(opName, step, &status[i])
+			continue
+		}
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			s.publishStepStatus(opName, step, &v1.StepStatus{Node: node, Status: v1.StepStatusRunning})
+			s.deliveryStepToNode(node, failoverNodes(step), payload, step.Timeout.Duration+2*time.Second, &status[i], stage, errChan)
+			s.publishStepStatus(opName, step, &status[i])
+			mu.Lock()
+			delete(running, node)
+			var cancel []string
+			if step.FailFast && failed == "" && status[i].Status != v1.StepStatusSuccessful {
+				failed = node
+				for n := range running {
+					cancel = append(cancel, n)
+				}
+			}
+			mu.Unlock()
+			if len(cancel) > 0 {
+				s.cancelStepOnNodes(opName, step, cancel)
+			}
+		}(i, node.ID)
+	}
+	wg.Wait()
+}
+
+// failoverNodes the nodes step fails over to, a step of several nodes runs on each of them and does not fail over.
+func failoverNodes(step *v1.Step) []v1.StepNode {
+	if len(step.Nodes) != 1 {
+		return nil
+	}
+	return step.FailoverNodes
+}
+
+// failoverStage stages the files a step failing over reads on the node it moves to, it records its failure on the
+// status of the step, see v1.Step FailoverStaging.
+type failoverStage func(node string, stepStatus *v1.StepStatus) error
+
+// failoverStaging the failoverStage of step running the staging steps of prior on the node of step again, the
+// staging steps of the nodes step failed over from included. Each of them is given the last step reply it was
+// delivered with, replies of prior, e.g. the MTU the detection steps replied to the render of the values.
+// It is nil when step does not fail over.
+func (s *Service) failoverStaging(opName string, prior []v1.Step, replies [][]byte, step *v1.Step, moved map[string]string, dryRun, retry bool) failoverStage {
+	if len(failoverNodes(step)) == 0 {
+		return nil
+	}
+	var (
+		staging        []v1.Step
+		stagingReplies [][]byte
+	)
+	for i, p := range prior {
+		if p.FailoverStaging && len(p.Nodes) == 1 && movedTo(p.Nodes[0].ID, moved) == step.Nodes[0].ID {
+			staging = append(staging, p)
+			stagingReplies = append(stagingReplies, replies[i])
+		}
+	}
+	if len(staging) == 0 {
+		return nil
+	}
+	return func(node string, stepStatus *v1.StepStatus) error {
+		for i := range staging {
+			payload, err := initPayload(opName, service.OperationRunTask, &staging[i], stagingReplies[i], nil, dryRun, retry)
+			if err != nil {
+				return err
+			}
+			logger.Info("stage the failover node", zap.String("node", node), zap.String("step", staging[i].Name))
+			if err = s.requestStepOnNode(node, payload, staging[i].Timeout.Duration+2*time.Second, stepStatus); err != nil {
+				stepStatus.Message = fmt.Sprintf("stage step %s on the failover node failed: %s", staging[i].Name, stepStatus.Message)
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// movedTo the node the steps of node run on after their failovers.
+func movedTo(node string, moved map[string]string) string {
+	for range moved {
+		next, ok := moved[node]
+		if !ok {
+			break
+		}
+		node = next
+	}
+	return node
+}
+
+// pinFailover moves step to the node the steps of its node failed over to, so that the rest of the operation runs
+// next to the files staged there and does not wait on the agent that stopped responding again.
+func pinFailover(step *v1.Step, moved map[string]string) {
+	if len(failoverNodes(step)) == 0 {
+		return
+	}
+	node := movedTo(step.Nodes[0].ID, moved)
+	if node == step.Nodes[0].ID {
+		return
+	}
+	var failover []v1.StepNode
+	for _, n := range append(step.Nodes, step.FailoverNodes...) {
+		if n.ID == node {
+			step.Nodes = []v1.StepNode{n}
+		} else if _, lost := moved[n.ID]; !lost {
+			failover = append(failover, n)
+		}
+	}
+	if step.Nodes[0].ID == node {
+		step.FailoverNodes = failover
+	}
+}
+
+// failedOverFrom reports whether the steps of the node of step failed over to another node, see pinFailover.
+func failedOverFrom(step *v1.Step, moved map[string]string) bool {
+	return len(failoverNodes(step)) > 0 && movedTo(step.Nodes[0].ID, moved) != step.Nodes[0].ID
+}
+
+// recordFailover records the node step failed over to on moved.
+func recordFailover(step *v1.Step, cond *v1.OperationCondition, moved map[string]string) {
+	if len(failoverNodes(step)) == 0 || len(cond.Status) != 1 {
+		return
+	}
+	if node := cond.Status[0].Node; node != "" && node != step.Nodes[0].ID {
+		moved[step.Nodes[0].ID] = node
+	}
+}
+
+// deliveryStepToNode runs payload on node, it moves to the failover nodes in order while the agent of the node does
+// not respond, i.e. no agent subscribes to the node subject. A step the agent replied to or timed out on may have run
+// and does not fail over. stage, when it is not nil, prepares the node the step moves to first.
+func (s *Service) deliveryStepToNode(node string, failover []v1.StepNode, payload []byte, timeout time.Duration, stepStatus *v1.StepStatus, stage failoverStage, errChan chan error) {
+	err := s.requestStepOnNode(node, payload, timeout, stepStatus)
+	for _, next := range failover {
+		if !errors.Is(err, nats.ErrNoResponders) {
+			break
+		}
+		logger.Warn("the agent of the node does not respond, the step fails over to the next node",
+			zap.String("node", stepStatus.Node), zap.String("next", next.ID))
+		*stepStatus = v1.StepStatus{}
+		if stage != nil {
+			if err = stage(next.ID, stepStatus); err != nil {
+				continue
+			}
+		}
+		err = s.requestStepOnNode(next.ID, payload, timeout, stepStatus)
+	}
+	if err != nil {
+		errChan <- err
+	}
+}
 
+// requestStepOnNode runs payload on node and records the reply of its agent on stepStatus.
+func (s *Service) requestStepOnNode(node string, payload []byte, timeout time.Duration, stepStatus *v1.StepStatus) error {
 	now := time.Now()
 	stepStatus.StartAt = metav1.NewTime(now)
 	stepStatus.Node = node
@@ -657,26 +1201,155 @@ func (s *Service) deliveryStepToNode(wg *sync.WaitGroup, node string, payload []
 	}
 	data, err := s.client.Request(msg, func(msg *natsio.Msg) error {
 		setStepStatus(stepStatus, v1.StepStatusFailed, "run step timeout", "server wait for agent reply timeout", nil)
+		stepStatus.ErrorCategory = v1.StepErrorTimeout
+		stepStatus.Hint = "the agent did not reply within the step timeout, check the node is online and the step log"
 		return nil
 	})
 	if err != nil {
 		setStepStatus(stepStatus, v1.StepStatusFailed, err.Error(), "internal server error for send request to agent", nil)
-		errChan <- err
-		return
+		return err
 	}
 	resp := &service.CommonReply{}
 	if err = json.Unmarshal(data, resp); err != nil {
 		logger.Error("unmarshal agent reply error", zap.Error(err))
 		setStepStatus(stepStatus, v1.StepStatusFailed, "unmarshal agent reply error", err.Error(), nil)
-		errChan <- err
-		return
+		return err
+	}
+	stepStatus.Output = resp.Output
+	stepStatus.Attempts = resp.Attempts
+	if resp.Cancelled {
+		setStepStatus(stepStatus, v1.StepStatusCancelled, "step cancelled", resp.Error.Error(), nil)
+		return resp.Error
 	}
 	if resp.Error != nil {
 		setStepStatus(stepStatus, v1.StepStatusFailed, resp.Error.Message, resp.Error.Error(), nil)
-		errChan <- resp.Error
-		return
+		stepStatus.ErrorCategory = resp.ErrorCategory
+		stepStatus.Hint = resp.Hint
+		return resp.Error
 	}
 	setStepStatus(stepStatus, v1.StepStatusSuccessful, "run step successfully", "run step successfully", resp.Data)
+	return nil
+}
+
+// rollbackOperation runs the rollback steps of the components installed completely before the step failed of
+// operation, in the reverse order of the installs. The rollbacks are best-effort, their errors are only logged.
+// The install steps of the components rolled back are no longer completed.
+func (s *Service) rollbackOperation(operation *v1.Operation, failed int, dryRun bool) {
+	var rolledBack []string
+	for _, i := range operation.CompletedRollbacks(failed) {
+		for j := range operation.Steps[i].RollbackSteps {
+			step := &operation.Steps[i].RollbackSteps[j]
+			logger.Info("rollback step", zap.String("operation", operation.Name), zap.String("step", step.Name))
+			if err := s.deliveryRollbackStep(operation.Name, step, dryRun); err != nil {
+				logger.Warn("rollback step error", zap.String("operation", operation.Name), zap.String("step", step.Name), zap.Error(err))
+			}
+		}
+		for j := i; j < failed; j++ {
+			rolledBack = append(rolledBack, operation.Steps[j].ID)
+			if operation.Steps[j].ID == operation.Steps[i].RollbackAfter {
+				break
+			}
+		}
+	}
+	if len(rolledBack) > 0 {
+		s.sendStepStatusToChannel(stepStatus{OperationIdentity: operation.Name, DryRun: dryRun, RolledBack: rolledBack})
+	}
+}
+
+// skipTaskStep records step successful on its nodes without running it for reason, e.g. it completed in a prior
+// attempt of the operation. The nodes reply response, the reply of the step in that attempt, to the next step.
+func (s *Service) skipTaskStep(opName string, step *v1.Step, cond *v1.OperationCondition, reason string, response []byte, dryRun bool) {
+	cond.StepID = step.ID
+	cond.Status = make([]v1.StepStatus, len(step.Nodes))
+	for i, node := range step.Nodes {
+		cond.Status[i].Node = node.ID
+		cond.Status[i].StartAt = metav1.Now()
+		setStepStatus(&cond.Status[i], v1.StepStatusSuccessful, "step skipped", reason, response)
+		s.publishStepStatus(opName, step, &cond.Status[i])
+	}
+	s.sendStepStatusToChannel(stepStatus{
+		OperationIdentity:  opName,
+		OperationCondition: *cond,
+		DryRun:             dryRun,
+	})
+}
+
+// failTaskStep records step failed with err on its nodes without running it.
+func (s *Service) failTaskStep(opName string, step *v1.Step, cond *v1.OperationCondition, err error, dryRun bool) {
+	cond.StepID = step.ID
+	cond.Status = make([]v1.StepStatus, len(step.Nodes))
+	for i, node := range step.Nodes {
+		cond.Status[i].Node = node.ID
+		cond.Status[i].StartAt = metav1.Now()
+		setStepStatus(&cond.Status[i], v1.StepStatusFailed, "step not run", err.Error(), nil)
+		s.publishStepStatus(opName, step, &cond.Status[i])
+	}
+	s.sendStepStatusToChannel(stepStatus{
+		OperationIdentity:  opName,
+		OperationCondition: *cond,
+		DryRun:             dryRun,
+	})
+}
+
+// restoreSecrets restores the secrets of the steps of op redacted when it was persisted.
+func restoreSecrets(op *v1.Operation) {
+	operation.RestoreSecrets(op)
+}
+
+// forgetSecrets drops the secrets of the operation name once it no longer runs steps.
+func forgetSecrets(name string) {
+	operation.ForgetSecrets(name)
+}
+
+// deliveryRollbackStep runs step on its nodes like deliveryTaskStep, the status of the step is not recorded
+// on the operation whose steps stay the ones to retry.
+func (s *Service) deliveryRollbackStep(opName string, step *v1.Step, dryRun bool) error {
+	payloadBytes, err := initPayload(opName, service.OperationRunTask, step, nil, nil, dryRun, false)
+	if err != nil {
+		return err
+	}
+	errChan := make(chan error, len(step.Nodes))
+	defer close(errChan)
+	status := make([]v1.StepStatus, len(step.Nodes))
+	s.deliveryStepToNodes(opName, step, payloadBytes, status, nil, errChan)
+	if len(errChan) > 0 && !step.ErrIgnore {
+		return <-errChan
+	}
+	return nil
+}
+
+// cancelStep cancels step on its nodes and the nodes it may have failed over to, the nodes terminate the commands
+// of the step and reply the step cancelled.
+func (s *Service) cancelStep(opName string, step *v1.Step) {
+	failover := failoverNodes(step)
+	nodes := make([]string, 0, len(step.Nodes)+len(failover))
+	for _, node := range step.Nodes {
+		nodes = append(nodes, node.ID)
+	}
+	for _, node := range failover {
+		nodes = append(nodes, node.ID)
+	}
+	s.cancelStepOnNodes(opName, step, nodes)
+}
+
+// cancelStepOnNodes cancels step on nodes, see cancelStep.
+func (s *Service) cancelStepOnNodes(opName string, step *v1.Step, nodes []string) {
+	payload, err := initPayload(opName, service.OperationCancelStep, &v1.Step{ID: step.ID, Name: step.Name}, nil, nil, false, false)
+	if err != nil {
+		logger.Error("init cancel step payload error", zap.String("operation", opName), zap.String("step", step.Name), zap.Error(err))
+		return
+	}
+	for _, node := range nodes {
+		msg := &natsio.Msg{
+			Subject: fmt.Sprintf(service.MsgSubjectFormat, node, s.subjectSuffix),
+			Timeout: cancelStepTimeout,
+			Data:    payload,
+		}
+		if _, err = s.client.Request(msg, nil); err != nil {
+			logger.Warn("cancel step error", zap.String("operation", opName), zap.String("step", step.Name),
+				zap.String("node", node), zap.Error(err))
+		}
+	}
 }
 
 func setStepStatus(status *v1.StepStatus, statusType v1.StepStatusType, message, reason string, response []byte) {
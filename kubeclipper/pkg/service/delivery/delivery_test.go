@@ -0,0 +1,395 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/nats-io/nats.go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kubeclipper/kubeclipper/pkg/errors"
+	mock_operation "github.com/kubeclipper/kubeclipper/pkg/models/operation/mock"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/common"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/service"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/client/natsio"
+)
+
+// fakeAgents replies to the steps of the nodes with an agent, the other nodes do not respond.
+type fakeAgents struct {
+	natsio.Interface
+	mu     sync.Mutex
+	online map[string]bool
+	// lostAfter the steps after which the agents of the nodes stop responding, keyed by node
+	lostAfter map[string]string
+	requested []string
+	// ran the steps the agents replied to, as step@node
+	ran []string
+	// replies the data the agents reply to the steps, keyed by step
+	replies map[string]string
+	// received the last step reply of the steps the agents ran, keyed by step@node
+	received map[string]string
+	// waitFor the steps the agents reply to a step only once they replied to, keyed by step
+	waitFor map[string]string
+	// replied closed when the agents replied to the steps, keyed by step
+	replied map[string]chan struct{}
+}
+
+func (f *fakeAgents) Request(msg *natsio.Msg, _ natsio.TimeoutHandler) ([]byte, error) {
+	node := strings.TrimSuffix(msg.Subject, ".agent")
+	payload := service.MsgPayload{}
+	if msg.Data != nil {
+		if err := json.Unmarshal(msg.Data, &payload); err != nil {
+			return nil, err
+		}
+	}
+	if other, ok := f.waitFor[payload.Step.Name]; ok {
+		select {
+		case <-f.replied[other]:
+		case <-time.After(5 * time.Second):
+			return json.Marshal(&service.CommonReply{Error: &errors.StatusError{Message: other + " did not run"}})
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if replied, ok := f.replied[payload.Step.Name]; ok {
+		defer func() {
+			select {
+			case <-replied:
+			default:
+				close(replied)
+			}
+		}()
+	}
+	f.requested = append(f.requested, node)
+	if !f.online[node] {
+		return nil, nats.ErrNoResponders
+	}
+	if msg.Data != nil {
+		f.ran = append(f.ran, payload.Step.Name+"@"+node)
+		if f.received != nil {
+			f.received[payload.Step.Name+"@"+node] = string(payload.LastTaskReply)
+		}
+		if f.lostAfter[node] == payload.Step.Name {
+			f.online[node] = false
+		}
+	}
+	reply := &service.CommonReply{Output: "ran on " + node}
+	if data, ok := f.replies[payload.Step.Name]; ok {
+		reply.Data = []byte(data)
+	}
+	return json.Marshal(reply)
+}
+
+func (f *fakeAgents) Publish(*natsio.Msg) error {
+	return nil
+}
+
+func TestDeliveryStepToNode_failover(t *testing.T) {
+	agents := &fakeAgents{online: map[string]bool{"master3": true}}
+	s := &Service{client: agents, subjectSuffix: "agent"}
+	step := &v1.Step{
+		Nodes:         []v1.StepNode{{ID: "master1"}},
+		FailoverNodes: []v1.StepNode{{ID: "master2"}, {ID: "master3"}},
+	}
+	errChan := make(chan error, 1)
+	status := v1.StepStatus{}
+	s.deliveryStepToNode("master1", failoverNodes(step), nil, time.Second, &status, nil, errChan)
+	if len(errChan) != 0 {
+		t.Fatalf("deliveryStepToNode() error = %v", <-errChan)
+	}
+	if status.Node != "master3" || status.Status != v1.StepStatusSuccessful || status.Output != "ran on master3" {
+		t.Errorf("status = %+v, want the step successful on master3", status)
+	}
+	if want := []string{"master1", "master2", "master3"}; strings.Join(agents.requested, ",") != strings.Join(want, ",") {
+		t.Errorf("requested nodes = %v, want %v", agents.requested, want)
+	}
+
+	// the steps of several nodes run on each of them
+	step.Nodes = append(step.Nodes, v1.StepNode{ID: "master2"})
+	if failover := failoverNodes(step); failover != nil {
+		t.Errorf("failoverNodes() = %v for a step of several nodes", failover)
+	}
+
+	agents.requested = nil
+	agents.online = nil
+	s.deliveryStepToNode("master1", []v1.StepNode{{ID: "master2"}}, nil, time.Second, &status, nil, errChan)
+	if len(errChan) != 1 || status.Node != "master2" || status.Status != v1.StepStatusFailed {
+		t.Errorf("status = %+v, want the step failed on the last node", status)
+	}
+}
+
+func TestDeliverTaskOperation_failoverStaging(t *testing.T) {
+	// the agent of the executor is lost after the values are rendered and before the release is installed
+	agents := &fakeAgents{online: map[string]bool{"master1": true, "master2": true}, lostAfter: map[string]string{"master1": "renderCniYaml"}}
+	termination := make(chan struct{})
+	s := &Service{client: agents, subjectSuffix: "agent", stepStatusChan: make(chan stepStatus, 16), terminationChan: &termination}
+	executor, failover := []v1.StepNode{{ID: "master1"}}, []v1.StepNode{{ID: "master2"}, {ID: "master3"}}
+	op := &v1.Operation{
+		ObjectMeta: metav1.ObjectMeta{Name: "op", Labels: map[string]string{common.LabelTimeoutSeconds: "60"}},
+		Steps: []v1.Step{
+			{ID: "1", Name: "cilium-chartLoad", Nodes: executor, FailoverNodes: failover, FailoverStaging: true},
+			{ID: "2", Name: "renderCniYaml", Nodes: executor, FailoverNodes: failover, FailoverStaging: true},
+			{ID: "3", Name: "installCiliumRelease", Nodes: executor, FailoverNodes: failover},
+			{ID: "4", Name: "markCiliumRelease", Nodes: executor, FailoverNodes: failover},
+		},
+	}
+	if err := s.DeliverTaskOperation(context.TODO(), op, &service.Options{DryRun: true}); err != nil {
+		t.Fatalf("DeliverTaskOperation() error = %v", err)
+	}
+	// the chart and the values are staged on the new executor again and the rest of the operation stays on it
+	want := []string{"cilium-chartLoad@master1", "renderCniYaml@master1", "cilium-chartLoad@master2", "renderCniYaml@master2",
+		"installCiliumRelease@master2", "markCiliumRelease@master2"}
+	if !reflect.DeepEqual(agents.ran, want) {
+		t.Errorf("ran steps = %v, want %v", agents.ran, want)
+	}
+	if want := []string{"master1", "master1", "master1", "master2", "master2", "master2", "master2"}; !reflect.DeepEqual(agents.requested, want) {
+		t.Errorf("requested nodes = %v, want %v", agents.requested, want)
+	}
+	for i, cond := range op.Status.Conditions {
+		node := "master2"
+		if i < 2 {
+			node = "master1"
+		}
+		if len(cond.Status) != 1 || cond.Status[0].Node != node || cond.Status[0].Status != v1.StepStatusSuccessful {
+			t.Errorf("step %s status = %+v, want successful on %s", op.Steps[i].Name, cond.Status, node)
+		}
+	}
+	if got := op.Steps[3].FailoverNodes; !reflect.DeepEqual(got, []v1.StepNode{{ID: "master3"}}) {
+		t.Errorf("markCiliumRelease failover nodes = %v, want the remaining master", got)
+	}
+}
+
+func TestDeliverTaskOperation_failoverStagingMTU(t *testing.T) {
+	// the values rendered with the detected MTU are lost with the executor
+	agents := &fakeAgents{
+		online:    map[string]bool{"master1": true, "master2": true, "worker1": true},
+		lostAfter: map[string]string{"master1": "renderCniYaml"},
+		replies:   map[string]string{"detectCiliumMTU-master1": `{"mtu":1500}`, "detectCiliumMTU-worker1": `{"mtu":1450}`},
+		received:  make(map[string]string),
+	}
+	termination := make(chan struct{})
+	s := &Service{client: agents, subjectSuffix: "agent", stepStatusChan: make(chan stepStatus, 16), terminationChan: &termination}
+	executor, failover := []v1.StepNode{{ID: "master1"}}, []v1.StepNode{{ID: "master2"}}
+	op := &v1.Operation{
+		ObjectMeta: metav1.ObjectMeta{Name: "op", Labels: map[string]string{common.LabelTimeoutSeconds: "60"}},
+		Steps: []v1.Step{
+			{ID: "1", Name: "cilium-chartLoad", Nodes: executor, FailoverNodes: failover, FailoverStaging: true},
+			{ID: "2", Name: "detectCiliumMTU-master1", Nodes: executor, FailoverNodes: failover},
+			{ID: "3", Name: "detectCiliumMTU-worker1", Nodes: []v1.StepNode{{ID: "worker1"}}},
+			{ID: "4", Name: "renderCniYaml", Nodes: executor, FailoverNodes: failover, FailoverStaging: true},
+			{ID: "5", Name: "installCiliumRelease", Nodes: executor, FailoverNodes: failover},
+		},
+	}
+	if err := s.DeliverTaskOperation(context.TODO(), op, &service.Options{DryRun: true}); err != nil {
+		t.Fatalf("DeliverTaskOperation() error = %v", err)
+	}
+	// the MTU is not detected again, the values are rendered on the new executor with the MTU detected before
+	want := []string{"cilium-chartLoad@master1", "detectCiliumMTU-master1@master1", "detectCiliumMTU-worker1@worker1",
+		"renderCniYaml@master1", "cilium-chartLoad@master2", "renderCniYaml@master2", "installCiliumRelease@master2"}
+	if !reflect.DeepEqual(agents.ran, want) {
+		t.Errorf("ran steps = %v, want %v", agents.ran, want)
+	}
+	for _, step := range []string{"renderCniYaml@master1", "renderCniYaml@master2"} {
+		if got := agents.received[step]; got != `{"mtu":1450}` {
+			t.Errorf("%s last step reply = %s, want the detected MTU", step, got)
+		}
+	}
+	if cond := op.Status.Conditions[4]; len(cond.Status) != 1 || cond.Status[0].Node != "master2" || cond.Status[0].Status != v1.StepStatusSuccessful {
+		t.Errorf("installCiliumRelease status = %+v, want successful on master2", cond.Status)
+	}
+}
+
+func TestDeliverTaskOperation_retrySkippedReplies(t *testing.T) {
+	agents := &fakeAgents{online: map[string]bool{"master1": true, "worker1": true}, received: make(map[string]string)}
+	termination := make(chan struct{})
+	s := &Service{client: agents, subjectSuffix: "agent", stepStatusChan: make(chan stepStatus, 16), terminationChan: &termination}
+	// the MTU was detected by the prior attempt, the values changed since
+	op := &v1.Operation{
+		ObjectMeta: metav1.ObjectMeta{Name: "op", Labels: map[string]string{common.LabelTimeoutSeconds: "60"}},
+		Steps: []v1.Step{
+			{ID: "1", Name: "cilium-chartLoad", Nodes: []v1.StepNode{{ID: "master1"}}, InputHash: "a"},
+			{ID: "2", Name: "detectCiliumMTU-master1", Nodes: []v1.StepNode{{ID: "master1"}}, InputHash: "b"},
+			{ID: "3", Name: "detectCiliumMTU-worker1", Nodes: []v1.StepNode{{ID: "worker1"}}, InputHash: "c"},
+			{ID: "4", Name: "renderCniYaml", Nodes: []v1.StepNode{{ID: "master1"}}, InputHash: "e"},
+		},
+		Status: v1.OperationStatus{
+			Completed:        map[string]string{"1": "a", "2": "b", "3": "c", "4": "d"},
+			CompletedReplies: map[string][]byte{"2": []byte(`{"mtu":1500}`), "3": []byte(`{"mtu":1450}`)},
+		},
+	}
+	if err := s.DeliverTaskOperation(context.TODO(), op, &service.Options{DryRun: true}); err != nil {
+		t.Fatalf("DeliverTaskOperation() error = %v", err)
+	}
+	if want := []string{"renderCniYaml@master1"}; !reflect.DeepEqual(agents.ran, want) {
+		t.Errorf("ran steps = %v, want %v", agents.ran, want)
+	}
+	// the skipped detection replies the MTU it detected in the prior attempt
+	if got := agents.received["renderCniYaml@master1"]; got != `{"mtu":1450}` {
+		t.Errorf("renderCniYaml last step reply = %s, want the MTU detected in the prior attempt", got)
+	}
+}
+
+func TestStepStatusChannelController_completedReplies(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	op := &v1.Operation{ObjectMeta: metav1.ObjectMeta{Name: "op"}}
+	opOperator := mock_operation.NewMockOperator(ctrl)
+	opOperator.EXPECT().GetOperation(gomock.Any(), "op").Return(op, nil).AnyTimes()
+	opOperator.EXPECT().UpdateOperation(gomock.Any(), gomock.Any()).Return(op, nil).AnyTimes()
+	s := &Service{opOperator: opOperator, stepStatusChan: make(chan stepStatus, 2)}
+	s.stepStatusChan <- stepStatus{
+		OperationIdentity:  "op",
+		OperationCondition: v1.OperationCondition{StepID: "2", Status: []v1.StepStatus{{Node: "master1", Response: []byte(`{"mtu":1500}`)}}},
+		InputHash:          "b",
+	}
+	s.stepStatusChan <- stepStatus{OperationIdentity: "op", RolledBack: []string{"1"}}
+	close(s.stepStatusChan)
+	op.Status.Completed = map[string]string{"1": "a"}
+	op.Status.CompletedReplies = map[string][]byte{"1": []byte("reply")}
+	s.stepStatusChannelController()
+
+	if want := map[string]string{"2": "b"}; !reflect.DeepEqual(op.Status.Completed, want) {
+		t.Errorf("completed = %v, want %v", op.Status.Completed, want)
+	}
+	if want := map[string][]byte{"2": []byte(`{"mtu":1500}`)}; !reflect.DeepEqual(op.Status.CompletedReplies, want) {
+		t.Errorf("completed replies = %s, want %s", op.Status.CompletedReplies, want)
+	}
+}
+
+func TestDeliverTaskOperation_parallel(t *testing.T) {
+	// the image load completes only once the chart download ran along with it
+	agents := &fakeAgents{
+		online:  map[string]bool{"master1": true},
+		waitFor: map[string]string{"cniImageLoader": "cilium-chartLoad"},
+		replied: map[string]chan struct{}{"cilium-chartLoad": make(chan struct{})},
+	}
+	termination := make(chan struct{})
+	s := &Service{client: agents, subjectSuffix: "agent", stepStatusChan: make(chan stepStatus, 16), terminationChan: &termination}
+	nodes := []v1.StepNode{{ID: "master1"}}
+	op := &v1.Operation{
+		ObjectMeta: metav1.ObjectMeta{Name: "op", Labels: map[string]string{common.LabelTimeoutSeconds: "60"}},
+		Steps: []v1.Step{
+			{ID: "1", Name: "kubeadmInit", Component: "kubernetes", Nodes: nodes},
+			{ID: "2", Name: "cniImageLoader", Component: "cni", Nodes: nodes},
+			{ID: "3", Name: "cilium-chartLoad", Component: "cni", Nodes: nodes, Parallel: true},
+			{ID: "4", Name: "renderCniYaml", Component: "cni", Nodes: nodes},
+			{ID: "5", Name: "installCiliumRelease", Component: "cni", Nodes: nodes, DependsOn: []string{"cilium-chartLoad", "renderCniYaml"}},
+		},
+	}
+	if err := s.DeliverTaskOperation(context.TODO(), op, &service.Options{DryRun: true}); err != nil {
+		t.Fatalf("DeliverTaskOperation() error = %v", err)
+	}
+	for i, cond := range op.Status.Conditions {
+		if len(cond.Status) != 1 || cond.Status[0].Status != v1.StepStatusSuccessful {
+			t.Errorf("step %s status = %+v, want successful", op.Steps[i].Name, cond.Status)
+		}
+	}
+	// the kubernetes steps and the dependencies still run before the step, the statuses are recorded in order
+	if agents.ran[0] != "kubeadmInit@master1" || agents.ran[len(agents.ran)-1] != "installCiliumRelease@master1" {
+		t.Errorf("ran steps = %v", agents.ran)
+	}
+	var recorded []string
+	for len(s.stepStatusChan) > 0 {
+		recorded = append(recorded, (<-s.stepStatusChan).OperationCondition.StepID)
+	}
+	if want := []string{"1", "2", "3", "4", "5"}; !reflect.DeepEqual(recorded, want) {
+		t.Errorf("recorded step statuses = %v, want %v", recorded, want)
+	}
+}
+
+func TestDeliverTaskOperation_parallelFailover(t *testing.T) {
+	// the agent of master1 stops responding after the chart download, the image load moves to master2 meanwhile
+	agents := &fakeAgents{
+		online:    map[string]bool{"master1": true, "master2": true},
+		lostAfter: map[string]string{"master1": "cilium-chartLoad"},
+		waitFor:   map[string]string{"cniImageLoader": "cilium-chartLoad"},
+		replied:   map[string]chan struct{}{"cilium-chartLoad": make(chan struct{})},
+	}
+	termination := make(chan struct{})
+	s := &Service{client: agents, subjectSuffix: "agent", stepStatusChan: make(chan stepStatus, 16), terminationChan: &termination}
+	nodes := []v1.StepNode{{ID: "master1"}}
+	failover := []v1.StepNode{{ID: "master2"}}
+	op := &v1.Operation{
+		ObjectMeta: metav1.ObjectMeta{Name: "op", Labels: map[string]string{common.LabelTimeoutSeconds: "60"}},
+		Steps: []v1.Step{
+			{ID: "1", Name: "cniImageLoader", Component: "cni", Nodes: nodes, FailoverNodes: failover},
+			{ID: "2", Name: "cilium-chartLoad", Component: "cni", Nodes: nodes, FailoverNodes: failover, Parallel: true, FailoverStaging: true},
+			{ID: "3", Name: "installCiliumRelease", Component: "cni", Nodes: nodes, FailoverNodes: failover, DependsOn: []string{"cilium-chartLoad"}},
+		},
+	}
+	if err := s.DeliverTaskOperation(context.TODO(), op, &service.Options{DryRun: true}); err != nil {
+		t.Fatalf("DeliverTaskOperation() error = %v", err)
+	}
+	// the chart is downloaded again next to the release
+	want := []string{"cilium-chartLoad@master1", "cniImageLoader@master2", "cilium-chartLoad@master2", "installCiliumRelease@master2"}
+	if !reflect.DeepEqual(agents.ran, want) {
+		t.Errorf("ran steps = %v, want %v", agents.ran, want)
+	}
+	if got := len(s.stepStatusChan); got != len(op.Steps) {
+		t.Errorf("recorded %d step statuses, want one per step", got)
+	}
+}
+
+func TestParallelReady(t *testing.T) {
+	nodes := []v1.StepNode{{ID: "master1"}}
+	op := &v1.Operation{Steps: []v1.Step{
+		{Name: "kubeadmInit", Component: "kubernetes", Nodes: nodes},
+		{Name: "cniImageLoader", Component: "cni", Nodes: nodes},
+		{Name: "installHelm", Component: "cni", Nodes: nodes, Parallel: true},
+		{Name: "cilium-chartLoad", Component: "cni", Nodes: nodes, Parallel: true, DependsOn: []string{"installHelm"}},
+		{Name: "diffCiliumRelease", Component: "cni", Nodes: nodes, Diff: true},
+		{Name: "pullCiliumImages", Component: "cni", Nodes: nodes, Parallel: true},
+	}}
+	deps := [][]int{nil, nil, nil, {2}, nil, nil}
+	// the steps of the other components before them complete first
+	if got := parallelReady(op, 0, deps, nil, false); len(got) != 0 {
+		t.Errorf("parallelReady() at the kubernetes step = %v, want none", got)
+	}
+	if got := parallelReady(op, 1, deps, nil, false); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("parallelReady() at the image load = %v, want the helm install", got)
+	}
+	// a dependency started ahead of its turn completed
+	done := &parallelRun{done: make(chan struct{})}
+	close(done.done)
+	if got := parallelReady(op, 1, deps, map[int]*parallelRun{2: done}, false); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("parallelReady() after the helm install = %v, want the chart load", got)
+	}
+	// the steps after a diff wait on its approval
+	if got := parallelReady(op, 4, deps, nil, false); len(got) != 0 {
+		t.Errorf("parallelReady() at the diff = %v, want none", got)
+	}
+	// the steps completed in a prior attempt are skipped at their turn
+	op.Steps[2].ID, op.Steps[2].InputHash = "3", "a"
+	op.Status.Completed = map[string]string{"3": "a"}
+	if got := parallelReady(op, 1, deps, nil, false); len(got) != 0 {
+		t.Errorf("parallelReady() of a completed step = %v, want none", got)
+	}
+	if got := parallelReady(op, 1, deps, nil, true); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("parallelReady() of a completed step forced = %v, want it", got)
+	}
+}
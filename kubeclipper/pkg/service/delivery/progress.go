@@ -0,0 +1,192 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+
+	"github.com/kubeclipper/kubeclipper/pkg/logger"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/service"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/client/natsio"
+)
+
+const (
+	// progressEventsMax the most progress events kept of an operation, the oldest are dropped.
+	progressEventsMax = 2000
+	// progressRetention how long the progress of an operation is kept once it stopped running.
+	progressRetention = 10 * time.Minute
+	// progressIdleRetention how long the progress of an operation is kept without any event, e.g. when the
+	// server missed the end of the operation.
+	progressIdleRetention = 24 * time.Hour
+)
+
+// operationProgress the progress events of an operation.
+type operationProgress struct {
+	events []service.ProgressEvent
+	cursor int64
+	// changed is closed and replaced when an event is added.
+	changed chan struct{}
+	stopped bool
+	updated time.Time
+}
+
+// progressHub keeps the recent progress of the operations for the watchers.
+type progressHub struct {
+	mu  sync.Mutex
+	ops map[string]*operationProgress
+}
+
+func newProgressHub() *progressHub {
+	return &progressHub{ops: make(map[string]*operationProgress)}
+}
+
+// get the progress of operation, it is created when missing. The caller holds the lock.
+func (h *progressHub) get(operation string) *operationProgress {
+	op, ok := h.ops[operation]
+	if !ok {
+		op = &operationProgress{changed: make(chan struct{}), updated: time.Now()}
+		h.ops[operation] = op
+	}
+	return op
+}
+
+// add numbers p and adds it to the progress of its operation.
+func (h *progressHub) add(p service.StepProgress) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.gc(time.Now())
+	op := h.get(p.Operation)
+	op.cursor++
+	op.events = append(op.events, service.ProgressEvent{Cursor: op.cursor, StepProgress: p})
+	if n := len(op.events); n > progressEventsMax {
+		op.events = append(op.events[:0:0], op.events[n-progressEventsMax:]...)
+	}
+	// a retried operation runs again after it stopped
+	op.stopped = p.OperationStatus != "" && p.OperationStatus != v1.OperationStatusRunning
+	op.updated = time.Now()
+	close(op.changed)
+	op.changed = make(chan struct{})
+}
+
+// since the events of operation after cursor, the channel closed on the next event and whether the operation
+// stopped running.
+func (h *progressHub) since(operation string, cursor int64) ([]service.ProgressEvent, <-chan struct{}, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	op := h.get(operation)
+	var events []service.ProgressEvent
+	for i, e := range op.events {
+		if e.Cursor > cursor {
+			events = append(events, op.events[i:]...)
+			break
+		}
+	}
+	return events, op.changed, op.stopped
+}
+
+// gc drops the progress of the operations stopped or without events for progressRetention and the one idle for
+// progressIdleRetention, their watchers are woken to pick up the new progress. The caller holds the lock.
+func (h *progressHub) gc(now time.Time) {
+	for name, op := range h.ops {
+		idle := now.Sub(op.updated)
+		if ((op.stopped || len(op.events) == 0) && idle > progressRetention) || idle > progressIdleRetention {
+			close(op.changed)
+			delete(h.ops, name)
+		}
+	}
+}
+
+// WatchOperationProgress sends the progress events of the operation after cursor until ctx is done or the
+// operation stops running. The operations not running when the watch starts only send the events kept of them.
+func (s *Service) WatchOperationProgress(ctx context.Context, operation string, cursor int64) <-chan service.ProgressEvent {
+	ch := make(chan service.ProgressEvent)
+	go func() {
+		defer close(ch)
+		finished := false
+		if op, err := s.opOperator.GetOperation(ctx, operation); err == nil {
+			finished = op.Status.Status != v1.OperationStatusRunning && op.Status.Status != v1.OperationStatusPending
+		}
+		for {
+			events, changed, stopped := s.progress.since(operation, cursor)
+			for _, e := range events {
+				select {
+				case ch <- e:
+					cursor = e.Cursor
+				case <-ctx.Done():
+					return
+				}
+			}
+			if stopped || finished {
+				return
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (s *Service) stepProgressHandler(msg *nats.Msg) {
+	p := service.StepProgress{}
+	if err := json.Unmarshal(msg.Data, &p); err != nil {
+		logger.Error("unmarshal step progress error", zap.Error(err))
+		return
+	}
+	if p.Operation == "" {
+		return
+	}
+	s.progress.add(p)
+}
+
+// publishProgress publishes the progress of an operation to the servers, the progress of the operations not
+// stored, e.g. the steps delivered alone, is not published.
+func (s *Service) publishProgress(p service.StepProgress) {
+	if p.Operation == "" {
+		return
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		logger.Error("marshal step progress error", zap.Error(err))
+		return
+	}
+	if err = s.client.Publish(&natsio.Msg{Subject: service.StepProgressSubject(s.nodeReportSubject), Data: data}); err != nil {
+		logger.Warn("publish step progress failed", zap.String("operation", p.Operation), zap.Error(err))
+	}
+}
+
+// publishStepStatus publishes the state of step on the node of status.
+func (s *Service) publishStepStatus(opName string, step *v1.Step, status *v1.StepStatus) {
+	s.publishProgress(service.StepProgress{
+		Operation: opName,
+		StepID:    step.ID,
+		StepName:  step.Name,
+		Node:      status.Node,
+		Status:    status.Status,
+	})
+}
@@ -0,0 +1,96 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package delivery
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/service"
+)
+
+func TestProgressHub(t *testing.T) {
+	h := newProgressHub()
+	_, changed, stopped := h.since("op1", 0)
+	if stopped {
+		t.Fatal("since() stopped before the operation ran")
+	}
+	h.add(service.StepProgress{Operation: "op1", StepName: "installCilium", Node: "node1", Status: v1.StepStatusRunning})
+	select {
+	case <-changed:
+	default:
+		t.Fatal("changed is not closed by the event")
+	}
+	h.add(service.StepProgress{Operation: "op1", StepName: "installCilium", Node: "node1", Output: "installing\n"})
+	h.add(service.StepProgress{Operation: "op2", StepName: "other"})
+
+	events, _, _ := h.since("op1", 0)
+	if len(events) != 2 || events[0].Cursor != 1 || events[1].Cursor != 2 || events[1].Output != "installing\n" {
+		t.Fatalf("since(0) = %+v, want the 2 events of op1", events)
+	}
+	// a reconnect resumes after the last event received
+	if events, _, _ = h.since("op1", 1); len(events) != 1 || events[0].Cursor != 2 {
+		t.Fatalf("since(1) = %+v, want the event 2", events)
+	}
+	if events, _, _ = h.since("op1", 2); len(events) != 0 {
+		t.Fatalf("since(2) = %+v, want none", events)
+	}
+
+	h.add(service.StepProgress{Operation: "op1", OperationStatus: v1.OperationStatusSuccessful})
+	if events, _, stopped = h.since("op1", 2); len(events) != 1 || !stopped {
+		t.Fatalf("since(2) = %+v, %v, want the operation event and stopped", events, stopped)
+	}
+	// a retry runs the operation again
+	h.add(service.StepProgress{Operation: "op1", StepName: "installCilium", Node: "node1", Status: v1.StepStatusRunning})
+	if _, _, stopped = h.since("op1", 0); stopped {
+		t.Fatal("since() stopped after the operation ran again")
+	}
+}
+
+func TestProgressHub_max(t *testing.T) {
+	h := newProgressHub()
+	for i := 0; i < progressEventsMax+10; i++ {
+		h.add(service.StepProgress{Operation: "op1", Output: "line\n"})
+	}
+	events, _, _ := h.since("op1", 0)
+	if len(events) != progressEventsMax || events[0].Cursor != 11 {
+		t.Fatalf("since(0) = %d events from %d, want %d from 11", len(events), events[0].Cursor, progressEventsMax)
+	}
+}
+
+func TestProgressHub_gc(t *testing.T) {
+	h := newProgressHub()
+	h.add(service.StepProgress{Operation: "stopped", OperationStatus: v1.OperationStatusFailed})
+	h.add(service.StepProgress{Operation: "running", Status: v1.StepStatusRunning})
+	_, changed, _ := h.since("stopped", 0)
+	h.mu.Lock()
+	h.gc(time.Now().Add(progressRetention + time.Minute))
+	_, stoppedKept := h.ops["stopped"]
+	_, runningKept := h.ops["running"]
+	h.mu.Unlock()
+	if stoppedKept || !runningKept {
+		t.Fatalf("gc() kept stopped %v and running %v, want only the running one", stoppedKept, runningKept)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Fatal("the watchers of the dropped progress are not woken")
+	}
+}
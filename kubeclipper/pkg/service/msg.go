@@ -41,6 +41,11 @@ const (
 	OperationRecovery
 	OperationRunCmd
 	OperationRunStep
+	OperationFile
+	// OperationCancelStep cancels the running task step of the operation on the node
+	OperationCancelStep
+	// OperationCacheGC collects the package cache of the node, see downloader.CacheGCRequest
+	OperationCacheGC
 )
 
 const (
@@ -62,6 +67,15 @@ type NodeStatusPayload struct {
 type CommonReply struct {
 	Error *errors.StatusError `json:"error,omitempty"`
 	Data  []byte              `json:"data,omitempty"`
+	// Output the output tail of the shell commands of a step, see v1.StepStatus.Output.
+	Output string `json:"output,omitempty"`
+	// Attempts the number of times the step ran, see v1.StepStatus.Attempts.
+	Attempts int32 `json:"attempts,omitempty"`
+	// Cancelled the step was cancelled by the operation termination, Error tells where it stopped.
+	Cancelled bool `json:"cancelled,omitempty"`
+	// ErrorCategory and Hint classify Error, see v1.StepStatus.ErrorCategory.
+	ErrorCategory v1.StepErrorCategory `json:"errorCategory,omitempty"`
+	Hint          string               `json:"hint,omitempty"`
 }
 
 type MsgPayload struct {
@@ -84,4 +98,6 @@ type LogOperation struct {
 type Options struct {
 	DryRun         bool
 	ForceSkipError bool
+	// Force runs the steps completed in a prior attempt of the operation again instead of skipping them.
+	Force bool
 }
@@ -0,0 +1,53 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package service
+
+import (
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+// StepProgress a change of a task step of an operation on a node. The agents report the output the running steps
+// append to their step log, the server reports the state transitions of the steps and the status of the operation.
+type StepProgress struct {
+	Operation string `json:"operation"`
+	StepID    string `json:"stepID,omitempty"`
+	StepName  string `json:"stepName,omitempty"`
+	Node      string `json:"node,omitempty"`
+	// Status the state of the step on Node, empty for the output reports.
+	Status v1.StepStatusType `json:"status,omitempty"`
+	// Offset the offset of Output in the step log of Node.
+	Offset int64  `json:"offset,omitempty"`
+	Output string `json:"output,omitempty"`
+	// OperationStatus the status of the operation once it stopped running, the operation reports nothing more
+	// until it runs again.
+	OperationStatus v1.OperationStatusType `json:"operationStatus,omitempty"`
+}
+
+// ProgressEvent a StepProgress of an operation numbered by the server, a watcher resumes after the Cursor of the
+// last event it received.
+type ProgressEvent struct {
+	Cursor int64 `json:"cursor"`
+	StepProgress
+}
+
+// StepProgressSubject the subject the step progress is published on, every server subscribes it so that the
+// progress can be watched on any of them.
+func StepProgressSubject(nodeReportSubject string) string {
+	return nodeReportSubject + ".progress"
+}
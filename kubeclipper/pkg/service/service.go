@@ -28,6 +28,7 @@ import (
 
 	"github.com/kubeclipper/kubeclipper/pkg/logger"
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
 )
 
 type Runnable interface {
@@ -42,6 +43,13 @@ type Interface interface {
 
 type IDelivery interface {
 	DeliverLogRequest(ctx context.Context, operation *LogOperation) (oplog.LogContentResponse, error) // request & response synchronously.
+	// DeliverFileRequest requests a chunk of a file the steps of an operation wrote on the node, see oplog.FileContentRequest.
+	DeliverFileRequest(ctx context.Context, operation *LogOperation) (oplog.FileContentResponse, error)
+	// DeliverCacheGCRequest collects the package cache of the node, request & response synchronously.
+	DeliverCacheGCRequest(ctx context.Context, node string, req *downloader.CacheGCRequest) (*downloader.CacheGCResult, error)
+	// WatchOperationProgress sends the progress events of the operation after cursor until ctx is done or the
+	// operation stops running, the channel is closed then.
+	WatchOperationProgress(ctx context.Context, operation string, cursor int64) <-chan ProgressEvent
 	CmdDelivery
 }
 
@@ -21,8 +21,13 @@ package task
 import (
 	"context"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/nats-io/nats.go"
 	"go.uber.org/zap"
@@ -34,16 +39,131 @@ import (
 	"github.com/kubeclipper/kubeclipper/pkg/logger"
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
 	"github.com/kubeclipper/kubeclipper/pkg/service"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
 	"github.com/kubeclipper/kubeclipper/pkg/utils/cmdutil"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/helmutil"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/sysutil"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/waitutil"
 )
 
-func (s *Service) runTaskStep(ctx context.Context, payload *service.MsgPayload, subject string) ([]byte, *errors.StatusError) {
-	// stepKey to distinguish which step the log file belongs to
-	stepKey := fmt.Sprintf("%s-%s", payload.Step.ID, payload.Step.Name)
+// errStepCancelled the cause of the context of the task steps cancelled by the operation termination.
+var errStepCancelled = stderrors.New("step cancelled by the operation termination")
+
+// cancelCommandsTimeout the time the cancel commands of a cancelled step have to run.
+const cancelCommandsTimeout = time.Minute
+
+// runTask runs the task step of payload, cancelStep cancels it while it is running. The cancel commands
+// of the step run after it was cancelled.
+func (s *Service) runTask(ctx context.Context, payload *service.MsgPayload, subject string) service.CommonReply {
+	key := runningStepKey(payload.OperationIdentity, payload.Step.ID)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	s.runningSteps.Store(key, cancel)
+	defer s.runningSteps.Delete(key)
+
+	stop := make(chan struct{})
+	progress := make(chan struct{})
+	go func() {
+		defer close(progress)
+		s.reportProgress(payload, stop)
+	}()
+	output := newStepOutput(v1.StepOutputMaxSize)
+	replyData, statusError, attempts := runWithRetry(ctx, payload, output, func() ([]byte, *errors.StatusError) {
+		return s.runTaskStep(ctx, payload, subject, output)
+	})
+	// the last output is reported before the reply so that it precedes the state transition of the step
+	close(stop)
+	<-progress
+	reply := service.CommonReply{Error: statusError, Data: replyData, Attempts: attempts}
+	if statusError != nil && stderrors.Is(context.Cause(ctx), errStepCancelled) {
+		reply.Cancelled = true
+		s.runCancelCommands(payload, output)
+	} else if statusError != nil {
+		reply.ErrorCategory, reply.Hint = classifyFailure(ctx, payload, output, statusError)
+	}
+	reply.Output = output.String(payload.Step.SensitiveOutput)
+	return reply
+}
+
+// classifyFailure classifies the failure of the step of payload by its error matchers with the exit code of the
+// failed shell command, the output and the error. A step stopped by its timeout which no matcher matches is a Timeout.
+func classifyFailure(ctx context.Context, payload *service.MsgPayload, output *stepOutput, statusError *errors.StatusError) (v1.StepErrorCategory, string) {
+	text := []string{output.String(false), statusError.Message, string(statusError.Reason)}
+	if statusError.Details != nil {
+		for _, cause := range statusError.Details.Causes {
+			text = append(text, cause.Message)
+		}
+	}
+	if m := payload.Step.ClassifyError(output.exitCode, strings.Join(text, "\n")); m != nil {
+		return m.Category, m.Hint
+	}
+	if stderrors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return v1.StepErrorTimeout, fmt.Sprintf("the step did not finish within its timeout of %s", payload.Step.Timeout.Duration)
+	}
+	return "", ""
+}
+
+// cancelStep cancels the task step stepID of the operation opID, it reports whether the step is running on the node.
+func (s *Service) cancelStep(opID, stepID string) bool {
+	cancel, ok := s.runningSteps.Load(runningStepKey(opID, stepID))
+	if ok {
+		cancel.(context.CancelCauseFunc)(errStepCancelled)
+	}
+	return ok
+}
+
+// runCancelCommands runs the cancel commands of the cancelled step, the context of the step is done already
+// so they run with a context of their own. They are best-effort, their failures are only logged.
+func (s *Service) runCancelCommands(payload *service.MsgPayload, output *stepOutput) {
+	if len(payload.Step.CancelCommands) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cancelCommandsTimeout)
+	defer cancel()
+	ctx = s.withStepLog(ctx, payload)
+	for _, c := range payload.Step.CancelCommands {
+		if c.Type != v1.CommandShell {
+			continue
+		}
+		logger.Debug("run cancel command", zap.Strings("cmd", c.MaskedShellCommand()))
+		if err := runShellCommand(ctx, &c, payload.DryRun, output); err != nil {
+			logger.Warn("run cancel command failed", zap.String("step", payload.Step.Name), zap.Strings("cmd", c.MaskedShellCommand()), zap.Error(err))
+		}
+	}
+}
+
+func runningStepKey(opID, stepID string) string {
+	return opID + "/" + stepID
+}
+
+// stepLogKey distinguishes which step the log file belongs to
+func stepLogKey(payload *service.MsgPayload) string {
+	return fmt.Sprintf("%s-%s", payload.Step.ID, payload.Step.Name)
+}
+
+// withStepLog puts the operation, the step and the operation log into ctx, the commands write the step log with them.
+func (s *Service) withStepLog(ctx context.Context, payload *service.MsgPayload) context.Context {
 	ctx = component.WithOperationID(ctx, payload.OperationIdentity) // put operation ID into context
-	ctx = component.WithStepID(ctx, stepKey)                        // put step ID into context
+	ctx = component.WithStepID(ctx, stepLogKey(payload))            // put step ID into context
 	ctx = component.WithOplog(ctx, s.oplog)                         // put operation log object into context
-	ctx = component.WithRepoMirror(ctx, s.repoMirror)
+	ctx = s.withFacts(ctx, payload.OperationIdentity)
+	return component.WithRepoMirror(ctx, s.repoMirror)
+}
+
+// withFacts puts the node-local facts of the operation opID into ctx, they are collected once per operation
+// when a command first uses them.
+func (s *Service) withFacts(ctx context.Context, opID string) context.Context {
+	if s.facts == nil {
+		return ctx
+	}
+	return component.WithFacts(ctx, func() sysutil.Facts {
+		return s.facts.Get(ctx, opID)
+	})
+}
+
+func (s *Service) runTaskStep(ctx context.Context, payload *service.MsgPayload, subject string, output *stepOutput) ([]byte, *errors.StatusError) {
+	stepKey := stepLogKey(payload)
+	ctx = s.withStepLog(ctx, payload)
 
 	var entry string
 	// truncate step log file
@@ -79,18 +199,32 @@ func (s *Service) runTaskStep(ctx context.Context, payload *service.MsgPayload,
 	for _, c := range cmds {
 		switch c.Type {
 		case v1.CommandShell:
-			logger.Debug("run shell command", zap.Strings("cmd", c.ShellCommand))
-			if err := runShellCommand(ctx, c.ShellCommand, payload.DryRun); err != nil {
+			logger.Debug("run shell command", zap.Strings("cmd", c.MaskedShellCommand()))
+			if err := runShellCommand(ctx, &c, payload.DryRun, output); err != nil {
 				errMsg := "run shell command error"
 				return nil, doStatusError(errMsg, errMsg, errors.ShellCommand, 500, err)
 			}
+		case v1.CommandHelm:
+			reply, err := runHelmCommand(component.WithCommandEnv(ctx, c.Env), c.Helm, payload.DryRun, output)
+			if err != nil {
+				errMsg := "run helm command error"
+				return nil, doStatusError(errMsg, errMsg, errors.HelmCommand, 500, err)
+			}
+			if reply != nil {
+				replyData = reply
+			}
+		case v1.CommandWaitFor:
+			if err := runWaitForCommand(component.WithCommandEnv(ctx, c.Env), c.WaitFor, payload.DryRun, output); err != nil {
+				errMsg := "run wait command error"
+				return nil, doStatusError(errMsg, errMsg, errors.WaitForCommand, 500, err)
+			}
 		case v1.CommandCustom:
 			var statusError *errors.StatusError
 			if payload.LastTaskReply != nil {
 				// Put join command into context
 				ctx = component.WithExtraData(ctx, payload.LastTaskReply)
 			}
-			if replyData, statusError = runCustomCommand(ctx, &payload.Step, c.Identity, c.CustomCommand, payload.DryRun); statusError != nil {
+			if replyData, statusError = runCustomCommand(component.WithCommandEnv(ctx, c.Env), &payload.Step, c.Identity, c.CustomCommand, payload.DryRun); statusError != nil {
 				return nil, statusError
 			}
 		case v1.CommandTemplateRender:
@@ -102,12 +236,13 @@ func (s *Service) runTaskStep(ctx context.Context, payload *service.MsgPayload,
 	return replyData, nil
 }
 
-func (s *Service) runStep(ctx context.Context, payload *service.MsgPayload, subject string) ([]byte, *errors.StatusError) {
+func (s *Service) runStep(ctx context.Context, payload *service.MsgPayload, subject string, output *stepOutput) ([]byte, *errors.StatusError) {
 	// stepKey to distinguish which step the log file belongs to
 	stepKey := fmt.Sprintf("%s-%s", payload.Step.ID, payload.Step.Name)
 	ctx = component.WithStepID(ctx, stepKey) // put step ID into context
 	ctx = component.WithOplog(ctx, s.oplog)  // put operation log object into context
 	ctx = component.WithRepoMirror(ctx, s.repoMirror)
+	ctx = s.withFacts(ctx, payload.OperationIdentity)
 
 	cmds := make([]v1.Command, len(payload.Step.BeforeRunCommands)+len(payload.Step.Commands)+len(payload.Step.AfterRunCommands))
 	cmds = append(cmds, payload.Step.BeforeRunCommands...)
@@ -117,18 +252,32 @@ func (s *Service) runStep(ctx context.Context, payload *service.MsgPayload, subj
 	for _, c := range cmds {
 		switch c.Type {
 		case v1.CommandShell:
-			logger.Debug("run shell command", zap.Strings("cmd", c.ShellCommand))
-			if err := runShellCommand(ctx, c.ShellCommand, payload.DryRun); err != nil {
+			logger.Debug("run shell command", zap.Strings("cmd", c.MaskedShellCommand()))
+			if err := runShellCommand(ctx, &c, payload.DryRun, output); err != nil {
 				errMsg := "run shell command error"
 				return nil, doStatusError(errMsg, errMsg, errors.ShellCommand, 500, err)
 			}
+		case v1.CommandHelm:
+			reply, err := runHelmCommand(component.WithCommandEnv(ctx, c.Env), c.Helm, payload.DryRun, output)
+			if err != nil {
+				errMsg := "run helm command error"
+				return nil, doStatusError(errMsg, errMsg, errors.HelmCommand, 500, err)
+			}
+			if reply != nil {
+				replyData = reply
+			}
+		case v1.CommandWaitFor:
+			if err := runWaitForCommand(component.WithCommandEnv(ctx, c.Env), c.WaitFor, payload.DryRun, output); err != nil {
+				errMsg := "run wait command error"
+				return nil, doStatusError(errMsg, errMsg, errors.WaitForCommand, 500, err)
+			}
 		case v1.CommandCustom:
 			var statusError *errors.StatusError
 			if payload.LastTaskReply != nil {
 				// Put join command into context
 				ctx = component.WithExtraData(ctx, payload.LastTaskReply)
 			}
-			if replyData, statusError = runCustomCommand(ctx, &payload.Step, c.Identity, c.CustomCommand, payload.DryRun); statusError != nil {
+			if replyData, statusError = runCustomCommand(component.WithCommandEnv(ctx, c.Env), &payload.Step, c.Identity, c.CustomCommand, payload.DryRun); statusError != nil {
 				return nil, statusError
 			}
 		case v1.CommandTemplateRender:
@@ -147,12 +296,12 @@ func (s *Service) msgHandler(msg *nats.Msg) {
 func (s *Service) taskHandler(msg *nats.Msg) {
 	// TODO: recovery from panic
 	logger.Debugf("Got incoming msg subject %s", msg.Subject)
-	logger.Debugf("Got incoming msg content %s", string(msg.Data))
 	payload := &service.MsgPayload{}
 	if err := json.Unmarshal(msg.Data, payload); err != nil {
 		logger.Error("unmarshal task payload error", zap.Error(err))
 		return
 	}
+	logger.Debugf("Got incoming msg content %s", string(redactedPayload(msg.Data, payload)))
 	logger.Debug("in coming task payload", zap.Int("operation", int(payload.Op)),
 		zap.String("step", payload.Step.Name), zap.ByteString("lastResponse", payload.LastTaskReply), zap.Duration("timeout", payload.Step.Timeout.Duration))
 	ctx, cancel := context.WithTimeout(context.TODO(), payload.Step.Timeout.Duration)
@@ -199,34 +348,71 @@ func (s *Service) taskHandler(msg *nats.Msg) {
 			statusError = doStatusError(errMsg, "marshal log content response error", errors.StepLog, 500, err)
 			return
 		}
-	case service.OperationRunTask:
+	case service.OperationFile:
 		var replyData []byte
-		for i := 0; i <= int(payload.Step.RetryTimes); i++ {
-			// reset retry field
-			if i > 0 {
-				payload.Retry = true
-			}
-			replyData, statusError = s.runTaskStep(ctx, payload, msg.Subject)
-			if statusError == nil {
-				break
-			}
-			logger.Debug("run task step failed", zap.String("step", payload.Step.Name), zap.Int("retry", i), zap.Int32("maxRetry", payload.Step.RetryTimes))
+		defer func() {
+			responseMessage(msg, replyData, statusError)
+		}()
+		errMsg := "handle operation file error"
+		req := oplog.FileContentRequest{}
+		if err := json.Unmarshal([]byte(payload.OperationIdentity), &req); err != nil {
+			logger.Error("parse operation file message error", zap.Error(err))
+			statusError = doStatusError(errMsg, "parse operation file message error", errors.StepLog, 500, err)
+			return
 		}
-		responseMessage(msg, replyData, statusError)
-	case service.OperationRunStep:
+		content, fileSize, err := s.oplog.GetOperationFileContent(req.OpID, req.Name, req.Offset, req.Length)
+		if err != nil {
+			logger.Error("read operation file error", zap.Error(err))
+			statusError = doStatusError(errMsg, "read operation file error", errors.StepLog, 500, err)
+			return
+		}
+		replyData, err = json.Marshal(oplog.FileContentResponse{
+			Content:  content,
+			FileSize: fileSize,
+		})
+		if err != nil {
+			logger.Error("marshal operation file response error", zap.Error(err))
+			statusError = doStatusError(errMsg, "marshal operation file response error", errors.StepLog, 500, err)
+			return
+		}
+	case service.OperationCacheGC:
 		var replyData []byte
-		for i := 0; i <= int(payload.Step.RetryTimes); i++ {
-			// reset retry field
-			if i > 0 {
-				payload.Retry = true
-			}
-			replyData, statusError = s.runStep(ctx, payload, msg.Subject)
-			if statusError == nil {
-				break
-			}
-			logger.Debug("run step failed", zap.String("step", payload.Step.Name), zap.Int("retry", i), zap.Int32("maxRetry", payload.Step.RetryTimes))
+		defer func() {
+			responseMessage(msg, replyData, statusError)
+		}()
+		errMsg := "handle package cache gc error"
+		req := &downloader.CacheGCRequest{}
+		if err := json.Unmarshal([]byte(payload.OperationIdentity), req); err != nil {
+			logger.Error("parse package cache gc message error", zap.Error(err))
+			statusError = doStatusError(errMsg, "parse package cache gc message error", errors.CacheGC, 500, err)
+			return
 		}
-		responseMessage(msg, replyData, statusError)
+		result, err := downloader.CollectCache(downloader.BaseDstDir, req)
+		if err != nil {
+			logger.Error("collect package cache error", zap.Error(err))
+			statusError = doStatusError(errMsg, "collect package cache error", errors.CacheGC, 500, err)
+			return
+		}
+		if replyData, err = json.Marshal(result); err != nil {
+			logger.Error("marshal package cache gc response error", zap.Error(err))
+			statusError = doStatusError(errMsg, "marshal package cache gc response error", errors.CacheGC, 500, err)
+			return
+		}
+	case service.OperationRunTask:
+		responseReply(msg, s.runTask(ctx, payload, msg.Subject))
+	case service.OperationCancelStep:
+		// the cancelled step replies itself once its commands stopped
+		responseMessage(msg, []byte(strconv.FormatBool(s.cancelStep(payload.OperationIdentity, payload.Step.ID))), nil)
+	case service.OperationRunStep:
+		output := newStepOutput(v1.StepOutputMaxSize)
+		replyData, statusError, attempts := runWithRetry(ctx, payload, output, func() ([]byte, *errors.StatusError) {
+			return s.runStep(ctx, payload, msg.Subject, output)
+		})
+		reply := service.CommonReply{Error: statusError, Data: replyData, Output: output.String(payload.Step.SensitiveOutput), Attempts: attempts}
+		if statusError != nil {
+			reply.ErrorCategory, reply.Hint = classifyFailure(ctx, payload, output, statusError)
+		}
+		responseReply(msg, reply)
 	default:
 		responseMessage(msg, nil, &errors.StatusError{
 			Message: "unknown operation",
@@ -236,11 +422,103 @@ func (s *Service) taskHandler(msg *nats.Msg) {
 	}
 }
 
-func runShellCommand(ctx context.Context, cmds []string, dryRun bool) error {
-	_, err := cmdutil.RunCmdWithContext(ctx, dryRun, cmds[0], cmds[1:]...)
+// runWithRetry runs the step of payload until it succeeds, it is retried payload.Step.RetryTimes times at most
+// waiting v1.Step.RetryDelay before every retry. The retries stop when ctx, the step timeout, is done.
+// Only the output of the last attempt is kept.
+func runWithRetry(ctx context.Context, payload *service.MsgPayload, output *stepOutput,
+	run func() ([]byte, *errors.StatusError)) (replyData []byte, statusError *errors.StatusError, attempts int32) {
+	for i := 0; i <= int(payload.Step.RetryTimes); i++ {
+		if i > 0 {
+			// reset retry field
+			payload.Retry = true
+			if delay := payload.Step.RetryDelay(i); delay > 0 {
+				select {
+				case <-ctx.Done():
+					return replyData, statusError, attempts
+				case <-time.After(delay):
+				}
+			}
+		}
+		output.Reset()
+		attempts++
+		replyData, statusError = run()
+		if statusError == nil || ctx.Err() != nil {
+			break
+		}
+		logger.Debug("run step failed", zap.String("step", payload.Step.Name), zap.Int("retry", i), zap.Int32("maxRetry", payload.Step.RetryTimes))
+	}
+	return replyData, statusError, attempts
+}
+
+// runShellCommand runs the shell command cmd and writes its stdout and stderr to output. The last line of stderr is added
+// to the returned error, it usually carries the reason of the failure, e.g. the error of helm.
+func runShellCommand(ctx context.Context, cmd *v1.Command, dryRun bool, output *stepOutput) error {
+	ec, err := cmdutil.RunCommandWithContext(ctx, dryRun, cmd)
+	if ec == nil {
+		return err
+	}
+	output.WriteString(ec.StdOut())
+	output.WriteString(ec.StdErr())
+	var exitErr *exec.ExitError
+	if stderrors.As(err, &exitErr) {
+		output.exitCode = exitErr.ExitCode()
+	}
+	if err != nil {
+		if lines := strings.Split(strings.TrimSpace(ec.StdErr()), "\n"); lines[len(lines)-1] != "" {
+			return fmt.Errorf("%w: %s", err, lines[len(lines)-1])
+		}
+	}
+	return err
+}
+
+// runHelmCommand runs the helm release operation cmd with the helm SDK and writes the resource changes and the
+// release status to output. The reply of the diff action is the diff, the server keeps it on the operation.
+func runHelmCommand(ctx context.Context, cmd *v1.HelmCommand, dryRun bool, output *stepOutput) ([]byte, error) {
+	if cmd != nil {
+		logger.Debug("run helm command", zap.String("action", string(cmd.Action)), zap.String("release", cmd.Release),
+			zap.String("namespace", cmd.Namespace))
+	}
+	out, err := helmutil.Run(ctx, cmd, dryRun)
+	output.WriteString(out)
+	if err != nil {
+		output.WriteString(err.Error() + "\n")
+		return nil, err
+	}
+	if cmd.Action == v1.HelmActionDiff && !dryRun {
+		return []byte(out), nil
+	}
+	return nil, nil
+}
+
+// runWaitForCommand polls the kubernetes API until the wait of cmd is met, the observed states are written to
+// output.
+func runWaitForCommand(ctx context.Context, cmd *v1.WaitForCommand, dryRun bool, output *stepOutput) error {
+	if cmd != nil {
+		logger.Debug("run wait command", zap.String("kind", cmd.Kind), zap.String("name", cmd.Name),
+			zap.String("namespace", cmd.Namespace), zap.String("condition", string(cmd.Condition)))
+	}
+	out, err := waitutil.Run(ctx, cmd, dryRun)
+	output.WriteString(out)
+	if err != nil {
+		output.WriteString(err.Error() + "\n")
+	}
 	return err
 }
 
+// redactedPayload the content data of payload to log, with the secrets of the step redacted.
+func redactedPayload(data []byte, payload *service.MsgPayload) []byte {
+	redacted := *payload
+	redacted.Step = *payload.Step.DeepCopy()
+	if !redacted.Step.RedactSecrets() {
+		return data
+	}
+	data, err := json.Marshal(&redacted)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
 func runTemplateRenderCommand(ctx context.Context, cmd *v1.TemplateCommand, dryRun bool) *errors.StatusError {
 	errMsg := "render template error"
 	tmplRender, ok := component.LoadTemplate(cmd.Identity)
@@ -310,10 +588,10 @@ func runCustomCommand(ctx context.Context, step *v1.Step, identity string, custo
 }
 
 func responseMessage(msg *nats.Msg, data []byte, error *errors.StatusError) {
-	reply := service.CommonReply{
-		Error: error,
-		Data:  data,
-	}
+	responseReply(msg, service.CommonReply{Error: error, Data: data})
+}
+
+func responseReply(msg *nats.Msg, reply service.CommonReply) {
 	replyBytes, err := json.Marshal(reply)
 	if err != nil {
 		logger.Error("marshal response message failed", zap.Error(err))
@@ -339,3 +617,38 @@ func doStatusError(errMsg, errReason string, errType errors.CauseType, errCode i
 		Code: errCode,
 	}
 }
+
+// stepOutput keeps the last max bytes written to it and the exit code of the failed shell command.
+type stepOutput struct {
+	max      int
+	buf      []byte
+	exitCode int
+}
+
+func newStepOutput(max int) *stepOutput {
+	return &stepOutput{max: max}
+}
+
+func (o *stepOutput) WriteString(s string) {
+	o.buf = append(o.buf, s...)
+	if len(o.buf) > o.max {
+		o.buf = o.buf[len(o.buf)-o.max:]
+		// do not start in the middle of a multi-byte character
+		for len(o.buf) > 0 && !utf8.RuneStart(o.buf[0]) {
+			o.buf = o.buf[1:]
+		}
+	}
+}
+
+func (o *stepOutput) Reset() {
+	o.buf = o.buf[:0]
+	o.exitCode = 0
+}
+
+// String returns the kept output, or v1.StepOutputRedacted when the output is sensitive.
+func (o *stepOutput) String(sensitive bool) string {
+	if sensitive && len(o.buf) > 0 {
+		return v1.StepOutputRedacted
+	}
+	return string(o.buf)
+}
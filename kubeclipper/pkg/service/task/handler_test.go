@@ -0,0 +1,236 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package task
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component/common"
+	"github.com/kubeclipper/kubeclipper/pkg/oplog"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
+	"github.com/kubeclipper/kubeclipper/pkg/service"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
+)
+
+func newTestService(t *testing.T) *Service {
+	ol, err := oplog.NewOperationLog(&oplog.Options{Dir: t.TempDir(), SingleThreshold: oplog.DefaultThreshold})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Service{oplog: ol}
+}
+
+// runCancelled runs the task step of payload, cancels it once started reports true and returns the reply of the step.
+func runCancelled(t *testing.T, s *Service, payload *service.MsgPayload, started func() bool) service.CommonReply {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+	replies := make(chan service.CommonReply, 1)
+	go func() {
+		replies <- s.runTask(ctx, payload, "")
+	}()
+	for deadline := time.Now().Add(10 * time.Second); !started(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("step %s did not start", payload.Step.Name)
+		}
+	}
+	if !s.cancelStep(payload.OperationIdentity, payload.Step.ID) {
+		t.Fatalf("cancelStep() = false, want the step %s running", payload.Step.Name)
+	}
+	select {
+	case reply := <-replies:
+		return reply
+	case <-time.After(5 * time.Second):
+		t.Fatalf("step %s is still running after it was cancelled", payload.Step.Name)
+	}
+	return service.CommonReply{}
+}
+
+func TestService_cancelChartDownload(t *testing.T) {
+	const pkg = "kc-cancel-test"
+	if err := os.MkdirAll(downloader.BaseDstDir, 0755); err != nil {
+		t.Skipf("the downloader directories are not writable: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(filepath.Join(downloader.BaseDstDir, "."+pkg))
+		_ = os.RemoveAll(filepath.Join("/opt/kc/manifest", pkg))
+	})
+	var once sync.Once
+	requested := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(requested) })
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	downloader.SetOptions(&downloader.Options{Address: srv.URL, Retries: 3, RetryBackoff: time.Second})
+
+	steps, err := (&common.Chart{PkgName: pkg, Version: "v0.0.1", Offline: true}).InstallStepsV2([]v1.StepNode{{ID: "node1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestService(t)
+	payload := &service.MsgPayload{Op: service.OperationRunTask, OperationIdentity: "op1", Step: steps[0]}
+	reply := runCancelled(t, s, payload, func() bool {
+		select {
+		case <-requested:
+			return true
+		default:
+			return false
+		}
+	})
+	if !reply.Cancelled || reply.Error == nil || reply.Attempts != 1 {
+		t.Errorf("reply = %+v, want a cancelled step without retries", reply)
+	}
+	if s.cancelStep(payload.OperationIdentity, payload.Step.ID) {
+		t.Errorf("cancelStep() = true after the step stopped")
+	}
+}
+
+func TestService_cancelHelmInstall(t *testing.T) {
+	// the fake helm hangs on install and fails the rollback of a release without a previous revision
+	bin := t.TempDir()
+	calls := filepath.Join(bin, "calls")
+	script := "#!/bin/sh\necho \"$1\" >> " + calls + "\ncase \"$1\" in\nupgrade) exec sleep 30 ;;\nrollback) exit 1 ;;\nesac\n"
+	helm := filepath.Join(bin, "helm")
+	if err := os.WriteFile(helm, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	readCalls := func() []string {
+		data, _ := os.ReadFile(calls)
+		return strings.Fields(string(data))
+	}
+
+	step := cni.InstallCiliumRelease("cilium", "/tmp/charts.tgz", "/tmp/cilium.yaml", "kube-system", []v1.StepNode{{ID: "node1"}},
+		cni.HelmReleaseOptions{Timeout: time.Minute})
+	for _, cmds := range [][]v1.Command{step.Commands, step.CancelCommands} {
+		for i := range cmds {
+			for j, arg := range cmds[i].ShellCommand {
+				cmds[i].ShellCommand[j] = strings.ReplaceAll(arg, common.HelmBin, helm)
+			}
+		}
+	}
+	payload := &service.MsgPayload{Op: service.OperationRunTask, OperationIdentity: "op1", Step: step}
+	start := time.Now()
+	reply := runCancelled(t, newTestService(t), payload, func() bool { return len(readCalls()) > 0 })
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("the cancelled helm install stopped after %v", elapsed)
+	}
+	if !reply.Cancelled || reply.Error == nil {
+		t.Errorf("reply = %+v, want a cancelled step", reply)
+	}
+	if got, want := strings.Join(readCalls(), " "), "upgrade status rollback uninstall"; got != want {
+		t.Errorf("helm calls = %q, want %q", got, want)
+	}
+}
+
+func TestService_runTaskFailed(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "cancelled")
+	payload := &service.MsgPayload{Op: service.OperationRunTask, OperationIdentity: "op1", Step: v1.Step{
+		ID:             "step1",
+		Name:           "fail",
+		Commands:       []v1.Command{{Type: v1.CommandShell, ShellCommand: []string{"false"}}},
+		CancelCommands: []v1.Command{{Type: v1.CommandShell, ShellCommand: []string{"touch", marker}}},
+	}}
+	reply := newTestService(t).runTask(context.TODO(), payload, "")
+	if reply.Cancelled || reply.Error == nil {
+		t.Errorf("reply = %+v, want a failed step", reply)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("the cancel commands ran for a failed step")
+	}
+}
+
+func TestService_runTaskClassifyFailure(t *testing.T) {
+	matchers := []v1.StepErrorMatcher{
+		{Category: v1.StepErrorHelmReleaseConflict, Pattern: "cannot re-use a name", Hint: "uninstall the release"},
+		{Category: v1.StepErrorClusterUnreachable, Pattern: "Kubernetes cluster unreachable", Hint: "check the api server"},
+		{Category: v1.StepErrorDownloadFailed, ExitCodes: []int{7}, Hint: "check the source"},
+	}
+	tests := []struct {
+		name     string
+		script   string
+		timeout  time.Duration
+		want     v1.StepErrorCategory
+		wantHint string
+	}{
+		{
+			name:     "stderr",
+			script:   `echo "Error: INSTALLATION FAILED: cannot re-use a name that is still in use" >&2; exit 1`,
+			want:     v1.StepErrorHelmReleaseConflict,
+			wantHint: "uninstall the release",
+		},
+		{
+			name:     "stdout",
+			script:   `echo "Error: Kubernetes cluster unreachable: connection refused"; exit 1`,
+			want:     v1.StepErrorClusterUnreachable,
+			wantHint: "check the api server",
+		},
+		{
+			name:     "exit code",
+			script:   `exit 7`,
+			want:     v1.StepErrorDownloadFailed,
+			wantHint: "check the source",
+		},
+		{
+			name:    "timeout",
+			script:  `exec sleep 10`,
+			timeout: 200 * time.Millisecond,
+			want:    v1.StepErrorTimeout,
+		},
+		{
+			name:   "unmatched",
+			script: `echo "Error: unknown" >&2; exit 1`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := &service.MsgPayload{Op: service.OperationRunTask, OperationIdentity: "op1", Step: v1.Step{
+				ID:            "step1",
+				Name:          "classify",
+				Commands:      []v1.Command{{Type: v1.CommandShell, ShellCommand: []string{"/bin/sh", "-c", tt.script}}},
+				ErrorMatchers: matchers,
+			}}
+			ctx := context.TODO()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			reply := newTestService(t).runTask(ctx, payload, "")
+			if reply.Error == nil {
+				t.Fatalf("reply = %+v, want a failed step", reply)
+			}
+			if reply.ErrorCategory != tt.want {
+				t.Errorf("error category = %q, want %q", reply.ErrorCategory, tt.want)
+			}
+			if tt.wantHint != "" && reply.Hint != tt.wantHint {
+				t.Errorf("hint = %q, want %q", reply.Hint, tt.wantHint)
+			}
+		})
+	}
+}
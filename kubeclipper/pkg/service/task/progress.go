@@ -0,0 +1,96 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package task
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/kubeclipper/kubeclipper/pkg/logger"
+	"github.com/kubeclipper/kubeclipper/pkg/service"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/client/natsio"
+)
+
+// progressInterval how often the output of the running steps is reported.
+const progressInterval = 2 * time.Second
+
+// reportProgress reports the output the task step of payload appends to its step log every progressInterval
+// until stop is closed, the output written since the last report is reported once more then. The steps without
+// v1.Step ReportProgress and the ones with a sensitive output are not reported.
+func (s *Service) reportProgress(payload *service.MsgPayload, stop <-chan struct{}) {
+	if !payload.Step.ReportProgress || payload.Step.SensitiveOutput || payload.DryRun || s.oplog == nil {
+		return
+	}
+	var offset int64
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			s.publishProgress(payload, &offset)
+			return
+		case <-ticker.C:
+			s.publishProgress(payload, &offset)
+		}
+	}
+}
+
+// publishProgress publishes the step log of payload after offset and moves offset past it.
+func (s *Service) publishProgress(payload *service.MsgPayload, offset *int64) {
+	for {
+		// a read is capped at the log threshold, the rest is read by the next one
+		content, _, size, err := s.oplog.GetStepLogContent(payload.OperationIdentity, stepLogKey(payload), *offset, 0)
+		if err != nil {
+			// the step did not write its log yet
+			return
+		}
+		if size < *offset {
+			// a retry of the step truncated the log
+			*offset = 0
+			continue
+		}
+		if len(content) == 0 {
+			return
+		}
+		data, err := json.Marshal(service.StepProgress{
+			Operation: payload.OperationIdentity,
+			StepID:    payload.Step.ID,
+			StepName:  payload.Step.Name,
+			Node:      s.AgentID,
+			Offset:    *offset,
+			Output:    string(content),
+		})
+		if err != nil {
+			logger.Error("marshal step progress error", zap.Error(err))
+			return
+		}
+		*offset += int64(len(content))
+		if err = s.mqClient.Publish(&natsio.Msg{
+			Subject: service.StepProgressSubject(s.NodeReportSubject),
+			From:    s.AgentID,
+			Data:    data,
+		}); err != nil {
+			logger.Warn("publish step progress failed", zap.String("operation", payload.OperationIdentity),
+				zap.String("step", payload.Step.Name), zap.Error(err))
+			return
+		}
+	}
+}
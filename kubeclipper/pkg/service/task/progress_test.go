@@ -0,0 +1,111 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package task
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/service"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/client/natsio"
+	mock_natsio "github.com/kubeclipper/kubeclipper/pkg/simple/client/natsio/mock"
+)
+
+func TestService_publishProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mq := mock_natsio.NewMockInterface(ctrl)
+	s := newTestService(t)
+	s.mqClient = mq
+	s.NodeReportSubject = "report"
+	s.AgentID = "node1"
+	var published []service.StepProgress
+	mq.EXPECT().Publish(gomock.Any()).DoAndReturn(func(msg *natsio.Msg) error {
+		if msg.Subject != "report.progress" {
+			t.Errorf("subject = %s, want report.progress", msg.Subject)
+		}
+		p := service.StepProgress{}
+		if err := json.Unmarshal(msg.Data, &p); err != nil {
+			t.Fatal(err)
+		}
+		published = append(published, p)
+		return nil
+	}).AnyTimes()
+
+	payload := &service.MsgPayload{
+		OperationIdentity: "op1",
+		Step:              v1.Step{ID: "s1", Name: "installCilium", ReportProgress: true},
+	}
+	var offset int64
+	// no log yet
+	s.publishProgress(payload, &offset)
+	if len(published) != 0 {
+		t.Fatalf("published %d reports before the step log was written", len(published))
+	}
+	appendLog := func(data string) {
+		if err := s.oplog.CreateStepLogFileAndAppend(payload.OperationIdentity, stepLogKey(payload), []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendLog("first\n")
+	s.publishProgress(payload, &offset)
+	appendLog("second\n")
+	s.publishProgress(payload, &offset)
+	// nothing new
+	s.publishProgress(payload, &offset)
+
+	want := []service.StepProgress{
+		{Operation: "op1", StepID: "s1", StepName: "installCilium", Node: "node1", Offset: 0, Output: "first\n"},
+		{Operation: "op1", StepID: "s1", StepName: "installCilium", Node: "node1", Offset: 6, Output: "second\n"},
+	}
+	if len(published) != len(want) {
+		t.Fatalf("published %v, want %v", published, want)
+	}
+	for i := range want {
+		if published[i] != want[i] {
+			t.Errorf("report %d = %+v, want %+v", i, published[i], want[i])
+		}
+	}
+	if offset != 13 {
+		t.Errorf("offset = %d, want 13", offset)
+	}
+}
+
+func TestService_reportProgressSkipped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	// any report fails the test
+	s := newTestService(t)
+	s.mqClient = mock_natsio.NewMockInterface(ctrl)
+	stop := make(chan struct{})
+	close(stop)
+	for _, payload := range []*service.MsgPayload{
+		{OperationIdentity: "op1", Step: v1.Step{ID: "s1", Name: "plain"}},
+		{OperationIdentity: "op1", Step: v1.Step{ID: "s2", Name: "secret", ReportProgress: true, SensitiveOutput: true}},
+		{OperationIdentity: "op1", Step: v1.Step{ID: "s3", Name: "dry", ReportProgress: true}, DryRun: true},
+	} {
+		if err := s.oplog.CreateStepLogFileAndAppend(payload.OperationIdentity, stepLogKey(payload), []byte("output\n")); err != nil {
+			t.Fatal(err)
+		}
+		s.reportProgress(payload, stop)
+	}
+}
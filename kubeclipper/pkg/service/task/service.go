@@ -44,6 +44,7 @@ import (
 	"github.com/kubeclipper/kubeclipper/pkg/service"
 	bs "github.com/kubeclipper/kubeclipper/pkg/simple/backupstore"
 	"github.com/kubeclipper/kubeclipper/pkg/simple/client/natsio"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/sysutil"
 )
 
 var _ service.Interface = (*Service)(nil)
@@ -100,6 +101,10 @@ type Service struct {
 	oplog       component.OperationLogFile
 	backupStore bs.BackupStore
 	repoMirror  string
+	// runningSteps the context.CancelCauseFunc of the task steps running on the node, keyed by runningStepKey
+	runningSteps sync.Map
+	// facts the node-local facts of the operations, the templates rendered on the node read them
+	facts *sysutil.FactsCache
 }
 
 type ServiceOption func(*Service)
@@ -172,6 +177,7 @@ func NewService(agentID, region, ipDetectMethod, nodeIPDetectMethod string, regi
 		RegisterNode:               registerNode,
 		clock:                      clock.RealClock{},
 		onRepeatedHeartbeatFailure: defaultRepeatedHeartbeatFailure,
+		facts:                      sysutil.NewFactsCache(sysutil.NodeFactsCollector{}),
 	}
 	for _, opt := range opts {
 		opt(s)
@@ -23,14 +23,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"strconv"
 
 	apimachineryversion "k8s.io/apimachinery/pkg/version"
 
 	corev1 "github.com/kubeclipper/kubeclipper/pkg/apis/core/v1"
 	"github.com/kubeclipper/kubeclipper/pkg/clusteroperation"
+	"github.com/kubeclipper/kubeclipper/pkg/query"
 
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
 	iamv1 "github.com/kubeclipper/kubeclipper/pkg/scheme/iam/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/simple/downloader"
 )
 
 const (
@@ -46,6 +49,7 @@ const (
 	publicKeyPath     = "/api/config.kubeclipper.io/v1/terminal.key"
 	versionPath       = "/version"
 	componentMetaPath = "/api/config.kubeclipper.io/v1/componentmeta"
+	cniRegistryPath   = "/api/config.kubeclipper.io/v1/cnis"
 	configmapPath     = "/api/core.kubeclipper.io/v1/configmaps"
 	templatePath      = "/api/core.kubeclipper.io/v1/templates"
 	registryPath      = "/api/core.kubeclipper.io/v1/registries"
@@ -80,6 +84,29 @@ func (cli *Client) DescribeNode(ctx context.Context, name string) (*NodesList, e
 	return &nodes, err
 }
 
+// CollectNodePackageCache collects the package cache of the node name with policy, dryRun only reports the
+// package versions to remove.
+func (cli *Client) CollectNodePackageCache(ctx context.Context, name string, policy downloader.CacheGCPolicy, dryRun bool) (*downloader.CacheGCResult, error) {
+	q := url.Values{}
+	if policy.KeepVersions != 0 {
+		q.Set(query.ParameterKeepVersions, strconv.Itoa(policy.KeepVersions))
+	}
+	if policy.MaxSize != 0 {
+		q.Set(query.ParameterMaxSize, strconv.FormatInt(policy.MaxSize>>20, 10))
+	}
+	if dryRun {
+		q.Set(query.ParamDryRun, "true")
+	}
+	serverResp, err := cli.post(ctx, fmt.Sprintf("%s/%s/cache/gc", ListNodesPath, name), q, nil, nil)
+	defer ensureReaderClosed(serverResp)
+	if err != nil {
+		return nil, err
+	}
+	result := &downloader.CacheGCResult{}
+	err = json.NewDecoder(serverResp.body).Decode(result)
+	return result, err
+}
+
 func (cli *Client) DeleteNode(ctx context.Context, name string) error {
 	serverResp, err := cli.delete(ctx, fmt.Sprintf("%s/%s", ListNodesPath, name), nil, nil)
 	defer ensureReaderClosed(serverResp)
@@ -160,6 +187,25 @@ func (cli *Client) ListOperation(ctx context.Context, query Queries) (*Operation
 	return &opList, err
 }
 
+// RetryOperation retries the failed operation name, force runs the steps completed in a prior attempt again
+// instead of skipping them.
+func (cli *Client) RetryOperation(ctx context.Context, name string, force bool) error {
+	q := url.Values{}
+	if force {
+		q.Set(query.ParameterForce, "true")
+	}
+	resp, err := cli.post(ctx, fmt.Sprintf("%s/%s/retry", operationPath, name), q, nil, nil)
+	defer ensureReaderClosed(resp)
+	return err
+}
+
+// ApproveOperation approves the diff of the operation name pending approval.
+func (cli *Client) ApproveOperation(ctx context.Context, name string) error {
+	resp, err := cli.post(ctx, fmt.Sprintf("%s/%s/approve", operationPath, name), nil, nil, nil)
+	defer ensureReaderClosed(resp)
+	return err
+}
+
 func (cli *Client) PrintLogs(ctx context.Context, operation v1.Operation) error {
 	fmt.Println("operation: ", operation.Name)
 	for _, step := range operation.Steps {
@@ -358,6 +404,18 @@ func (cli *Client) GetComponentMeta(ctx context.Context, query url.Values) (*Com
 	return &v, err
 }
 
+// ListCNIRegistrations lists the cnis the server can install, the online query also lists the online versions.
+func (cli *Client) ListCNIRegistrations(ctx context.Context, query url.Values) (*CNIRegistrationList, error) {
+	serverResp, err := cli.get(ctx, cniRegistryPath, query, nil)
+	defer ensureReaderClosed(serverResp)
+	if err != nil {
+		return nil, err
+	}
+	v := CNIRegistrationList{}
+	err = json.NewDecoder(serverResp.body).Decode(&v)
+	return &v, err
+}
+
 func (cli *Client) InstallOrUninstallComponent(ctx context.Context, cluName string, component *corev1.PatchComponents) (*ClustersList, error) {
 	url := fmt.Sprintf(componentPath, cluName)
 	resp, err := cli.patch(ctx, url, nil, component, nil)
@@ -388,6 +446,47 @@ func (cli *Client) UpdateCert(ctx context.Context, cluName string) (*ClustersLis
 	return clusters, err
 }
 
+// CheckCNI starts the cni health check of the cluster, the health of every node is in the step responses of the operation.
+func (cli *Client) CheckCNI(ctx context.Context, cluName string) (*v1.Operation, error) {
+	resp, err := cli.post(ctx, fmt.Sprintf("%s/%s/cni/check", clustersPath, cluName), nil, nil, nil)
+	defer ensureReaderClosed(resp)
+	if err != nil {
+		return nil, err
+	}
+	op := v1.Operation{}
+	err = json.NewDecoder(resp.body).Decode(&op)
+	return &op, err
+}
+
+// CheckCNIConnectivity starts the cni connectivity test of the cluster, timeoutSeconds 0 uses the server default.
+// The result of every check is in the step responses of the operation.
+func (cli *Client) CheckCNIConnectivity(ctx context.Context, cluName string, timeoutSeconds int) (*v1.Operation, error) {
+	q := url.Values{}
+	if timeoutSeconds > 0 {
+		q.Set(query.ParameterTimeoutSeconds, strconv.Itoa(timeoutSeconds))
+	}
+	resp, err := cli.post(ctx, fmt.Sprintf("%s/%s/cni/connectivity", clustersPath, cluName), q, nil, nil)
+	defer ensureReaderClosed(resp)
+	if err != nil {
+		return nil, err
+	}
+	op := v1.Operation{}
+	err = json.NewDecoder(resp.body).Decode(&op)
+	return &op, err
+}
+
+// ConnectClusterMesh connects the cilium cluster mesh of the cluster to the peer cluster.
+func (cli *Client) ConnectClusterMesh(ctx context.Context, cluName, peer string) (*v1.Operation, error) {
+	resp, err := cli.post(ctx, fmt.Sprintf("%s/%s/cni/clustermesh", clustersPath, cluName), nil, map[string]string{"peer": peer}, nil)
+	defer ensureReaderClosed(resp)
+	if err != nil {
+		return nil, err
+	}
+	op := v1.Operation{}
+	err = json.NewDecoder(resp.body).Decode(&op)
+	return &op, err
+}
+
 func (cli *Client) ListBackupsWithCluster(ctx context.Context, clusterName string) (*BackupList, error) {
 	serverResp, err := cli.get(ctx, fmt.Sprintf("%s/%s/backups", clustersPath, clusterName), nil, nil)
 	defer ensureReaderClosed(serverResp)
@@ -30,6 +30,7 @@ import (
 
 	"github.com/kubeclipper/kubeclipper/pkg/cli/printer"
 	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
 )
 
 type LoginRequest struct {
@@ -133,10 +134,13 @@ func (n *ClustersList) JSONPrint() ([]byte, error) {
 }
 
 func (n *ClustersList) TablePrint() ([]string, [][]string) {
-	headers := []string{"name", "region", "master_count", "worker_count", "status", "apiserver_certs_expiration", "create_timestamp"}
+	headers := []string{"name", "region", "master_count", "worker_count", "status", "cni_ready", "apiserver_certs_expiration", "create_timestamp"}
 	var data [][]string
 	for _, cluster := range n.Items {
-		var ace string
+		var ace, cniReady string
+		if cond := cluster.Status.GetCondition(v1.ClusterCNIReady); cond != nil {
+			cniReady = string(cond.Status)
+		}
 		for _, cert := range cluster.Status.Certifications {
 			if cert.Name == "apiserver" {
 				ace = cert.ExpirationTime.String()
@@ -147,6 +151,7 @@ func (n *ClustersList) TablePrint() ([]string, [][]string) {
 			strconv.FormatInt(int64(len(cluster.Masters)), 10),
 			strconv.FormatInt(int64(len(cluster.Workers)), 10),
 			string(cluster.Status.Phase),
+			cniReady,
 			ace,
 			cluster.CreationTimestamp.String()})
 	}
@@ -236,6 +241,27 @@ type ComponentMeta struct {
 	Addons []scheme.MetaResource    `json:"addons"`
 }
 
+// CNIRegistration a cni the server can install, with the versions of its packages.
+type CNIRegistration struct {
+	cni.Registration
+	Versions []CNIVersion `json:"versions"`
+}
+
+type CNIVersion struct {
+	Version string `json:"version"`
+	// Arches the architectures of the packages present in the static server.
+	Arches []string `json:"arches,omitempty"`
+	// Offline the packages of the version are present in the static server, the version installs offline.
+	Offline bool `json:"offline"`
+	// Defaults the cni of the version with the configuration the server applies to the fields the cluster leaves unset.
+	Defaults *v1.CNI `json:"defaults,omitempty"`
+}
+
+type CNIRegistrationList struct {
+	Items      []CNIRegistration `json:"items" description:"paging data"`
+	TotalCount int               `json:"totalCount,omitempty" description:"total count"`
+}
+
 type BackupList struct {
 	Items      []v1.Backup `json:"items" description:"paging data"`
 	TotalCount int         `json:"totalCount,omitempty" description:"total count"`
@@ -0,0 +1,303 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package downloader
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrBundleMismatch the bundle is not the package of the requested install.
+var ErrBundleMismatch = errors.New("bundle mismatch")
+
+// BundleManifest the manifest.json at the root of an air-gap bundle. The bundle carries the package of a version
+// in the layout of the package server, type/version/arch, with the manifest.json the downloader verifies it by.
+type BundleManifest struct {
+	// Type the name of the package, e.g. cilium.
+	Type    string `json:"type"`
+	Version string `json:"version"`
+	Arch    string `json:"arch"`
+	// Images the images of images.tar.gz.
+	Images []string `json:"images,omitempty"`
+	// Files the files of the package with their digests.
+	Files []ManifestElement `json:"files"`
+}
+
+// Dir the directory of the package in the bundle and on the package server.
+func (m *BundleManifest) Dir() string {
+	return path.Join(m.Type, m.Version, m.Arch)
+}
+
+func (m *BundleManifest) validate() error {
+	for _, s := range []string{m.Type, m.Version, m.Arch} {
+		if s == "" || s == "." || s == ".." || strings.ContainsAny(s, `/\`) {
+			return fmt.Errorf("invalid bundle %s", m.Dir())
+		}
+	}
+	return nil
+}
+
+// BundleName the file name of the bundle of the package.
+func BundleName(typ, version, arch string) string {
+	return fmt.Sprintf("%s-%s-%s-bundle.tar.gz", typ, version, arch)
+}
+
+// WriteBundle writes the bundle of the files, keyed by their names in the package, to w.
+// The digests of the files are computed into the Files of m.
+func WriteBundle(w io.Writer, m *BundleManifest, files map[string]string) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if name == ManifestFilename || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid bundle file name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	m.Files = nil
+	for _, name := range names {
+		sha, md5sum, err := digestFile(files[name])
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, ManifestElement{Name: name, Digest: md5sum, SHA256: sha})
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	bundle, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = writeTarFile(tw, ManifestFilename, bundle); err != nil {
+		return err
+	}
+	manifest, err := json.MarshalIndent(m.Files, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = writeTarFile(tw, path.Join(m.Dir(), ManifestFilename), manifest); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err = copyTarFile(tw, path.Join(m.Dir(), name), files[name]); err != nil {
+			return err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// ReadBundle validates the bundle read from r and unpacks the package into dstDir in the layout of the package
+// server, nothing is unpacked when dstDir is empty. The bundle must be the package of version of typ, and every
+// file must match its sha256 digest. The package replaces the unpacked one only once it is valid.
+func ReadBundle(r io.Reader, dstDir, typ, version string) (*BundleManifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("read the bundle: %w", err)
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != ManifestFilename {
+		return nil, fmt.Errorf("the bundle does not start with %s", ManifestFilename)
+	}
+	m := &BundleManifest{}
+	if err = json.NewDecoder(tr).Decode(m); err != nil {
+		return nil, fmt.Errorf("parse the %s of the bundle: %w", ManifestFilename, err)
+	}
+	if err = m.validate(); err != nil {
+		return nil, err
+	}
+	if m.Type != typ || strings.TrimPrefix(m.Version, "v") != strings.TrimPrefix(version, "v") {
+		return nil, fmt.Errorf("%w: the bundle is %s-%s, the install is %s-%s", ErrBundleMismatch, m.Type, m.Version, typ, version)
+	}
+
+	var staging string
+	if dstDir != "" {
+		parent := filepath.Join(dstDir, m.Type, m.Version)
+		if err = os.MkdirAll(parent, 0755); err != nil {
+			return nil, err
+		}
+		if staging, err = os.MkdirTemp(parent, "."+m.Arch+"-import-"); err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(staging)
+	}
+	digests := make(map[string]string, len(m.Files))
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read the bundle: %w", err)
+		}
+		dir, name := path.Split(hdr.Name)
+		if path.Clean(dir) != m.Dir() || name == "" || hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected entry %s in the bundle of %s", hdr.Name, m.Dir())
+		}
+		if digests[name], err = unpackFile(tr, staging, name); err != nil {
+			return nil, err
+		}
+	}
+	if err = m.verify(digests); err != nil {
+		return nil, err
+	}
+	if staging == "" {
+		return m, nil
+	}
+	if err = m.verifyManifest(filepath.Join(staging, ManifestFilename)); err != nil {
+		return nil, err
+	}
+	dst := filepath.Join(dstDir, m.Type, m.Version, m.Arch)
+	if err = os.RemoveAll(dst); err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(staging, 0755); err != nil {
+		return nil, err
+	}
+	return m, os.Rename(staging, dst)
+}
+
+// verify checks the sha256 digests of the unpacked files, every file of the manifest must be unpacked.
+func (m *BundleManifest) verify(digests map[string]string) error {
+	if _, ok := digests[ManifestFilename]; !ok {
+		return fmt.Errorf("the bundle of %s has no %s", m.Dir(), ManifestFilename)
+	}
+	known := map[string]bool{ManifestFilename: true}
+	for _, f := range m.Files {
+		known[f.Name] = true
+		sum, ok := digests[f.Name]
+		if !ok {
+			return fmt.Errorf("the bundle of %s is missing %s", m.Dir(), f.Name)
+		}
+		if !strings.EqualFold(sum, f.SHA256) {
+			return fmt.Errorf("%w for %s: expected sha256 %s, got %s", ErrChecksumMismatch, f.Name, f.SHA256, sum)
+		}
+	}
+	for name := range digests {
+		if !known[name] {
+			return fmt.Errorf("the bundle of %s has the file %s missing from its manifest", m.Dir(), name)
+		}
+	}
+	return nil
+}
+
+// verifyManifest checks the manifest.json the downloader verifies the package by carries the digests of the bundle.
+func (m *BundleManifest) verifyManifest(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var manifest []ManifestElement
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("parse the %s of %s: %w", ManifestFilename, m.Dir(), err)
+	}
+	digests := make(map[string]string, len(manifest))
+	for _, v := range manifest {
+		digests[v.Name] = v.SHA256
+	}
+	for _, f := range m.Files {
+		if !strings.EqualFold(digests[f.Name], f.SHA256) {
+			return fmt.Errorf("the %s of %s does not match the bundle for %s", ManifestFilename, m.Dir(), f.Name)
+		}
+	}
+	return nil
+}
+
+// unpackFile writes the file read from r into dir, it returns the sha256 digest of the file.
+// The file is only hashed when dir is empty.
+func unpackFile(r io.Reader, dir, name string) (string, error) {
+	h := sha256.New()
+	w := io.Writer(h)
+	if dir != "" {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		w = io.MultiWriter(f, h)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return "", fmt.Errorf("unpack %s: %w", name, err)
+	}
+	return hexSum(h), nil
+}
+
+// digestFile returns the sha256 and md5 digests of file.
+func digestFile(file string) (string, string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	sha, md := sha256.New(), md5.New()
+	if _, err = io.Copy(io.MultiWriter(sha, md), f); err != nil {
+		return "", "", err
+	}
+	return hexSum(sha), hexSum(md), nil
+}
+
+func hexSum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now(), Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func copyTarFile(tw *tar.Writer, name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime(), Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
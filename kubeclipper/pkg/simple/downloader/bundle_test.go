@@ -0,0 +1,170 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package downloader
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestBundle(t *testing.T) ([]byte, map[string][]byte) {
+	t.Helper()
+	content := map[string][]byte{ChartFilename: []byte("cilium chart"), ImageFilename: []byte("cilium images")}
+	dir := t.TempDir()
+	files := make(map[string]string)
+	for name, data := range content {
+		files[name] = filepath.Join(dir, name)
+		if err := os.WriteFile(files[name], data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	m := &BundleManifest{Type: "cilium", Version: "1.14.4", Arch: "amd64", Images: []string{"quay.io/cilium/cilium:v1.14.4"}}
+	if err := WriteBundle(&buf, m, files); err != nil {
+		t.Fatalf("WriteBundle() error = %v", err)
+	}
+	return buf.Bytes(), content
+}
+
+// rewriteBundle rewrites the entries of bundle by edit, an entry edited to nil is dropped.
+func rewriteBundle(t *testing.T, bundle []byte, edit func(name string, data []byte) (string, []byte)) []byte {
+	t.Helper()
+	gr, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		name, data := edit(hdr.Name, data)
+		if data == nil {
+			continue
+		}
+		if err = writeTarFile(tw, name, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = tw.Close()
+	_ = gw.Close()
+	return buf.Bytes()
+}
+
+func TestReadBundle(t *testing.T) {
+	bundle, content := writeTestBundle(t)
+	dir := filepath.Join("cilium", "1.14.4", "amd64")
+	tests := []struct {
+		name    string
+		bundle  []byte
+		version string
+		wantErr error
+	}{
+		{name: "valid", bundle: bundle, version: "v1.14.4"},
+		{name: "version mismatch", bundle: bundle, version: "1.14.5", wantErr: ErrBundleMismatch},
+		{name: "tampered", version: "1.14.4", wantErr: ErrChecksumMismatch,
+			bundle: rewriteBundle(t, bundle, func(name string, data []byte) (string, []byte) {
+				if strings.HasSuffix(name, ImageFilename) {
+					return name, []byte("tampered")
+				}
+				return name, data
+			})},
+		{name: "missing file", version: "1.14.4",
+			bundle: rewriteBundle(t, bundle, func(name string, data []byte) (string, []byte) {
+				if strings.HasSuffix(name, ChartFilename) {
+					return name, nil
+				}
+				return name, data
+			})},
+		{name: "entry outside the package", version: "1.14.4",
+			bundle: rewriteBundle(t, bundle, func(name string, data []byte) (string, []byte) {
+				if strings.HasSuffix(name, ChartFilename) {
+					return "cilium/1.14.4/amd64/../../../" + ChartFilename, data
+				}
+				return name, data
+			})},
+		{name: "manifest.json of the downloader not matching", version: "1.14.4",
+			bundle: rewriteBundle(t, bundle, func(name string, data []byte) (string, []byte) {
+				if name == "cilium/1.14.4/amd64/"+ManifestFilename {
+					data, _ = json.Marshal([]ManifestElement{{Name: ChartFilename}, {Name: ImageFilename}})
+				}
+				return name, data
+			})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			m, err := ReadBundle(bytes.NewReader(tt.bundle), dst, "cilium", tt.version)
+			if tt.name != "valid" {
+				if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+					t.Fatalf("ReadBundle() error = %v, want %v", err, tt.wantErr)
+				}
+				if _, err = os.Stat(filepath.Join(dst, dir)); !os.IsNotExist(err) {
+					t.Errorf("ReadBundle() unpacked the invalid bundle")
+				}
+				entries, _ := os.ReadDir(filepath.Join(dst, "cilium", "1.14.4"))
+				if len(entries) != 0 {
+					t.Errorf("ReadBundle() left %v behind", entries)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadBundle() error = %v", err)
+			}
+			if m.Dir() != "cilium/1.14.4/amd64" || len(m.Images) != 1 || len(m.Files) != 2 {
+				t.Errorf("ReadBundle() manifest = %+v", m)
+			}
+			for name, data := range content {
+				got, _ := os.ReadFile(filepath.Join(dst, dir, name))
+				if !bytes.Equal(got, data) {
+					t.Errorf("the unpacked %s = %q, want %q", name, got, data)
+				}
+			}
+			data, _ := os.ReadFile(filepath.Join(dst, dir, ManifestFilename))
+			var manifest []ManifestElement
+			if err = json.Unmarshal(data, &manifest); err != nil {
+				t.Fatal(err)
+			}
+			if err = VerifySHA256(manifest, ImageFilename, filepath.Join(dst, dir, ImageFilename)); err != nil || manifest[0].SHA256 == "" {
+				t.Errorf("the unpacked %s does not verify the package: %v", ManifestFilename, err)
+			}
+		})
+	}
+
+	// validating only unpacks nothing
+	if _, err := ReadBundle(bytes.NewReader(bundle), "", "cilium", "1.14.4"); err != nil {
+		t.Errorf("ReadBundle() without a destination error = %v", err)
+	}
+}
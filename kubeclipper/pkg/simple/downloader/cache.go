@@ -0,0 +1,206 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package downloader
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubeclipper/kubeclipper/pkg/logger"
+)
+
+// cacheMinAge the versions used more recently are never collected, a download may be using them.
+const cacheMinAge = time.Hour
+
+// CachedPackage a version of a package cached under BaseDstDir/.<name>/<version>.
+type CachedPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Size the size of the cached files in bytes.
+	Size int64 `json:"size"`
+	// LastUsed the last time a downloader used the version.
+	LastUsed time.Time `json:"lastUsed"`
+	// InUse the version is referenced by an installed component, it is never collected.
+	InUse bool `json:"inUse,omitempty"`
+}
+
+// Key the name/version key of the package the in-use packages of a CacheGCRequest are given by.
+func (p *CachedPackage) Key() string {
+	return PackageKey(p.Name, p.Version)
+}
+
+// PackageKey the name/version key of the version of a package.
+func PackageKey(name, version string) string {
+	return name + "/" + version
+}
+
+// CacheGCPolicy the versions kept by the cache garbage collection, the zero values use the agent options.
+type CacheGCPolicy struct {
+	// KeepVersions the most recently used versions kept per package, negative keeps every version.
+	KeepVersions int `json:"keepVersions,omitempty"`
+	// MaxSize the total size in bytes the least recently used versions are removed down to, negative is unlimited.
+	MaxSize int64 `json:"maxSize,omitempty"`
+}
+
+// CacheGCRequest collects the cached packages of a node.
+type CacheGCRequest struct {
+	CacheGCPolicy
+	// InUse the PackageKey of the versions referenced by the installed components, they are never removed.
+	InUse []string `json:"inUse,omitempty"`
+	// DryRun reports the versions which would be removed without removing them.
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// CacheGCResult the outcome of a cache garbage collection.
+type CacheGCResult struct {
+	Removed []CachedPackage `json:"removed,omitempty"`
+	Kept    []CachedPackage `json:"kept,omitempty"`
+	// ReclaimedBytes the size of the removed versions.
+	ReclaimedBytes int64 `json:"reclaimedBytes"`
+}
+
+// withDefaults fills the zero fields of the policy from the downloader options.
+func (p CacheGCPolicy) withDefaults() CacheGCPolicy {
+	defaults := NewOptions()
+	if options != nil {
+		defaults = options
+	}
+	if p.KeepVersions == 0 {
+		p.KeepVersions = defaults.CacheKeepVersions
+	}
+	if p.MaxSize == 0 {
+		p.MaxSize = defaults.CacheMaxSize
+	}
+	return p
+}
+
+// ListCache lists the cached package versions under dir, the most recently used first.
+func ListCache(dir string) ([]CachedPackage, error) {
+	names, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var packages []CachedPackage
+	for _, name := range names {
+		if !name.IsDir() || !strings.HasPrefix(name.Name(), ".") {
+			continue
+		}
+		versions, err := os.ReadDir(filepath.Join(dir, name.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range versions {
+			if !version.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, name.Name(), version.Name())
+			info, err := version.Info()
+			if err != nil {
+				return nil, err
+			}
+			size, err := dirSize(path)
+			if err != nil {
+				return nil, err
+			}
+			packages = append(packages, CachedPackage{
+				Name:     strings.TrimPrefix(name.Name(), "."),
+				Version:  version.Name(),
+				Size:     size,
+				LastUsed: info.ModTime(),
+			})
+		}
+	}
+	sort.SliceStable(packages, func(i, j int) bool {
+		return packages[i].LastUsed.After(packages[j].LastUsed)
+	})
+	return packages, nil
+}
+
+// CollectCache removes the cached package versions under dir which the policy of req does not keep, the versions
+// in use and the ones used within the last hour are always kept.
+func CollectCache(dir string, req *CacheGCRequest) (*CacheGCResult, error) {
+	packages, err := ListCache(dir)
+	if err != nil {
+		return nil, err
+	}
+	policy := req.CacheGCPolicy.withDefaults()
+	inUse := make(map[string]bool, len(req.InUse))
+	for _, key := range req.InUse {
+		inUse[key] = true
+	}
+	var (
+		result   = &CacheGCResult{}
+		kept     = make(map[string]int)
+		keptSize int64
+	)
+	// packages are the most recently used first, the oldest ones are removed once the limits are reached
+	for _, p := range packages {
+		p.InUse = inUse[p.Key()]
+		keep := p.InUse || time.Since(p.LastUsed) < cacheMinAge ||
+			((policy.KeepVersions <= 0 || kept[p.Name] < policy.KeepVersions) && (policy.MaxSize <= 0 || keptSize+p.Size <= policy.MaxSize))
+		if keep {
+			kept[p.Name]++
+			keptSize += p.Size
+			result.Kept = append(result.Kept, p)
+			continue
+		}
+		if !req.DryRun {
+			if err = os.RemoveAll(filepath.Join(dir, "."+p.Name, p.Version)); err != nil {
+				return result, err
+			}
+			logger.Infof("remove the cached package %s of %d bytes", p.Key(), p.Size)
+		}
+		result.Removed = append(result.Removed, p)
+		result.ReclaimedBytes += p.Size
+	}
+	return result, nil
+}
+
+// touch marks the version directory dir used, the cache garbage collection removes the least recently used versions.
+func touch(dir string) {
+	now := time.Now()
+	if err := os.Chtimes(dir, now, now); err != nil {
+		logger.Warnf("update the last use of %s failed: %v", dir, err)
+	}
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,125 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// cachePackage caches a version of size bytes last used age ago.
+func cachePackage(t *testing.T, dir, name, version string, size int, age time.Duration) {
+	t.Helper()
+	path := filepath.Join(dir, "."+name, version)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path, ChartFilename), make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	used := time.Now().Add(-age)
+	if err := os.Chtimes(path, used, used); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func keys(packages []CachedPackage) []string {
+	var s []string
+	for _, p := range packages {
+		s = append(s, p.Key())
+	}
+	sort.Strings(s)
+	return s
+}
+
+func TestCollectCache(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         CacheGCRequest
+		wantRemoved []string
+		reclaimed   int64
+	}{
+		{
+			name:        "keep the most recently used versions",
+			req:         CacheGCRequest{CacheGCPolicy: CacheGCPolicy{KeepVersions: 2, MaxSize: -1}},
+			wantRemoved: []string{"cilium/1.13.0"},
+			reclaimed:   100,
+		},
+		{
+			name:        "keep the versions in use",
+			req:         CacheGCRequest{CacheGCPolicy: CacheGCPolicy{KeepVersions: 1, MaxSize: -1}, InUse: []string{"cilium/1.13.0"}},
+			wantRemoved: []string{"cilium/1.14.0", "k8s/v1.27.4"},
+			reclaimed:   300,
+		},
+		{
+			name:        "remove the least recently used versions down to the max size",
+			req:         CacheGCRequest{CacheGCPolicy: CacheGCPolicy{KeepVersions: -1, MaxSize: 450}},
+			wantRemoved: []string{"cilium/1.13.0", "k8s/v1.27.4"},
+			reclaimed:   300,
+		},
+		{
+			name:        "keep every version",
+			req:         CacheGCRequest{CacheGCPolicy: CacheGCPolicy{KeepVersions: -1, MaxSize: -1}},
+			wantRemoved: nil,
+		},
+		{
+			name:        "dry run",
+			req:         CacheGCRequest{CacheGCPolicy: CacheGCPolicy{KeepVersions: 1, MaxSize: -1}, DryRun: true},
+			wantRemoved: []string{"cilium/1.13.0", "cilium/1.14.0", "k8s/v1.27.4"},
+			reclaimed:   400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// the version just downloaded is never collected
+			cachePackage(t, dir, "cilium", "1.15.0", 100, time.Minute)
+			cachePackage(t, dir, "cilium", "1.14.0", 100, 2*time.Hour)
+			cachePackage(t, dir, "cilium", "1.13.0", 100, 3*time.Hour)
+			cachePackage(t, dir, "k8s", "v1.28.2", 200, 2*time.Hour)
+			cachePackage(t, dir, "k8s", "v1.27.4", 200, 4*time.Hour)
+
+			result, err := CollectCache(dir, &tt.req)
+			if err != nil {
+				t.Fatalf("CollectCache() error = %v", err)
+			}
+			if got := keys(result.Removed); !reflect.DeepEqual(got, tt.wantRemoved) {
+				t.Errorf("CollectCache() removed %v, want %v", got, tt.wantRemoved)
+			}
+			if result.ReclaimedBytes != tt.reclaimed {
+				t.Errorf("CollectCache() reclaimed %d bytes, want %d", result.ReclaimedBytes, tt.reclaimed)
+			}
+			cached, err := ListCache(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := len(result.Kept)
+			if tt.req.DryRun {
+				want += len(result.Removed)
+			}
+			if len(cached) != want {
+				t.Errorf("ListCache() = %v, want %d cached versions", keys(cached), want)
+			}
+		})
+	}
+}
@@ -21,6 +21,7 @@ package downloader
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -47,23 +48,52 @@ const (
 	ChartFilename    = "charts.tgz"
 )
 
+const (
+	// partialFileSuffix the suffix of a file being downloaded, an interrupted download resumes from it.
+	partialFileSuffix = ".part"
+	// progressInterval how often the download progress is written into the step log.
+	progressInterval = 5 * time.Second
+	// maxRetryBackoff caps the exponential backoff between the download attempts.
+	maxRetryBackoff = 30 * time.Second
+)
+
 var options *Options
 
+// ErrChecksumMismatch the downloaded file does not match the sha256 digest of the manifest.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrNotFound the package server does not have the file, e.g. the package of the architecture was not pushed.
+var ErrNotFound = errors.New("not found on the package server")
+
 // SetOptions set downloader options
 func SetOptions(op *Options) {
 	options = op
 }
 
 type Downloader struct {
-	// k8s component repo
+	// the sources of the package tried in order, a source falls back to the next one once it failed
+	sources []Mirror
+	// the path of the package on the sources, e.g. k8s/v1.23.3/amd64
+	pkgPath string
+	// the package of the source being downloaded from, e.g. https://oss.kubeclipper.io/packages/k8s/v1.23.3/amd64
 	baseURI string
+	// the source which served the last download
+	served string
+	// mirrorTimeout bounds the download from a source without timeout when there are sources to fall back to
+	mirrorTimeout time.Duration
 	// the default directory for storing resources, e.g. /tmp/kc-downloader/.k8s/v1.23.3/amd64
 	dstDir string
+	// the architecture independent directory for storing charts, e.g. /tmp/kc-downloader/.cilium/1.14.4
+	chartDir string
 	// the default directory to the top-level manifest file, e.g. /opt/kc/manifest/k8s/v1.23.3/amd64
 	manifestDir string
 	// the default directory to the config manifest file, e.g. /opt/kc/manifest/k8s/v1.23.3/amd64/config
 	cManifestDir string
 	dryRun       bool
+	// retries the attempts after the first failed one of a file download
+	retries int
+	// retryBackoff the delay before the first retry, it doubles on every retry
+	retryBackoff time.Duration
 	// enable remote download
 	// online bool
 	// inherits the component context
@@ -74,31 +104,61 @@ func NewInstance(ctx context.Context, name, version, arch string, online, dryRun
 	if options == nil {
 		return nil, fmt.Errorf("the required downloader configuration is missing, you need to call SetOptions before calling NewInstance")
 	}
-	var baseURI, dstDir, manifestDir, cManifestDir string
+	// the offline packages are only served by the package server of kubeclipper
+	sources := []Mirror{{URL: options.Address}}
 	if online {
-		baseURI = CloudStaticServer
-	} else {
-		baseURI = options.Address
+		sources = options.onlineSources(name)
+	}
+	return newInstance(ctx, sources, name, version, arch, dryRun)
+}
+
+// NewMirrorInstance downloads from mirror instead of the package server, the mirror has the layout of the package server.
+func NewMirrorInstance(ctx context.Context, mirror, name, version, arch string, dryRun bool) (*Downloader, error) {
+	if options == nil {
+		return nil, fmt.Errorf("the required downloader configuration is missing, you need to call SetOptions before calling NewMirrorInstance")
 	}
+	return newInstance(ctx, []Mirror{{URL: mirror}}, name, version, arch, dryRun)
+}
+
+func newInstance(ctx context.Context, sources []Mirror, name, version, arch string, dryRun bool) (*Downloader, error) {
+	for i := range sources {
+		sources[i].URL = strings.TrimSuffix(sources[i].URL, "/")
+	}
+	pkgPath := fmt.Sprintf("%s/%s/%s", name, version, arch)
+	var dstDir, chartDir, manifestDir, cManifestDir string
 	if !dryRun {
-		dstDir = filepath.Join(BaseDstDir, "."+name, version)
+		chartDir = filepath.Join(BaseDstDir, "."+name, version)
+		dstDir = filepath.Join(chartDir, arch)
 		manifestDir = filepath.Join(baseManifestDir, name, version, arch)
 		cManifestDir = filepath.Join(baseManifestDir, name, version, arch, "config")
 		// create required directories
 		if err := createDirs(dstDir, manifestDir, cManifestDir); err != nil {
 			return nil, err
 		}
+		removeStalePartialFiles(options.PartialFileMaxAge, chartDir, dstDir, manifestDir)
+		touch(chartDir)
 	}
 	return &Downloader{
-		ctx:          ctx,
-		baseURI:      fmt.Sprintf("%s/%s/%s/%s", baseURI, name, version, arch),
-		dryRun:       dryRun,
-		dstDir:       dstDir,
-		manifestDir:  manifestDir,
-		cManifestDir: cManifestDir,
+		ctx:           ctx,
+		sources:       sources,
+		pkgPath:       pkgPath,
+		baseURI:       fmt.Sprintf("%s/%s", sources[0].URL, pkgPath),
+		mirrorTimeout: options.MirrorTimeout,
+		dryRun:        dryRun,
+		dstDir:        dstDir,
+		chartDir:      chartDir,
+		manifestDir:   manifestDir,
+		cManifestDir:  cManifestDir,
+		retries:       options.Retries,
+		retryBackoff:  options.RetryBackoff,
 	}, nil
 }
 
+// Source the source which served the last download, it is empty before a download succeeded.
+func (dl *Downloader) Source() string {
+	return dl.served
+}
+
 // DownloadConfigs download config file
 func (dl *Downloader) DownloadConfigs() (string, error) {
 	return filepath.Join(dl.dstDir, ConfigFilename), dl.Download(ConfigFilename)
@@ -130,6 +190,23 @@ func (dl *Downloader) DownloadImages() (string, error) {
 	return filepath.Join(dl.dstDir, ImageFilename), dl.Download(ImageFilename)
 }
 
+// VerifyImages checks the downloaded image file against the sha256 digest of the manifest it was downloaded with,
+// e.g. before the images are loaded from a copy which may have been cut short.
+func (dl *Downloader) VerifyImages() error {
+	if dl.dryRun {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(dl.manifestDir, ManifestFilename))
+	if err != nil {
+		return fmt.Errorf("read the manifest of %s: %w", ImageFilename, err)
+	}
+	var manifest []ManifestElement
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("parse the manifest of %s: %w", ImageFilename, err)
+	}
+	return VerifySHA256(manifest, ImageFilename, filepath.Join(dl.dstDir, ImageFilename))
+}
+
 // RemoveImages remove image file
 func (dl *Downloader) RemoveImages() error {
 	return os.RemoveAll(filepath.Join(dl.dstDir, ImageFilename))
@@ -137,16 +214,16 @@ func (dl *Downloader) RemoveImages() error {
 
 // DownloadCharts download chart file
 func (dl *Downloader) DownloadCharts() (string, error) {
-	return filepath.Join(dl.dstDir, ChartFilename), dl.Download(ChartFilename)
+	return filepath.Join(dl.chartDir, ChartFilename), dl.download(dl.chartDir, ChartFilename)
 }
 
 // RemoveCharts remove chart file
 func (dl *Downloader) RemoveCharts() error {
-	return os.RemoveAll(filepath.Join(dl.dstDir, ChartFilename))
+	return os.RemoveAll(filepath.Join(dl.chartDir, ChartFilename))
 }
 
 func (dl *Downloader) GetChartDownloadPath() string {
-	return filepath.Join(dl.dstDir, ChartFilename)
+	return filepath.Join(dl.chartDir, ChartFilename)
 }
 
 // DownloadCustomImages download custom image file
@@ -224,10 +301,72 @@ func (dl *Downloader) DownloadAndUnpackConfigs() (string, error) {
 
 // Download file list
 func (dl *Downloader) Download(fileList ...string) (err error) {
+	return dl.download(dl.dstDir, fileList...)
+}
+
+// download downloads the files from the sources in order, a source failing falls back to the next one.
+// The manifest is downloaded from the same source as the files, their checksums are verified alike.
+func (dl *Downloader) download(dstDir string, fileList ...string) error {
 	if dl.dryRun {
-		logger.Debug("dry run download", zap.String("srcDir", dl.baseURI), zap.String("dstDir", dl.dstDir), zap.Strings("fileList", fileList))
-		return
+		logger.Debug("dry run download", zap.String("srcDir", dl.baseURI), zap.Strings("sources", dl.sourceURLs()),
+			zap.String("dstDir", dstDir), zap.Strings("fileList", fileList))
+		return nil
 	}
+	if len(dl.sources) < 2 {
+		// nothing to fall back to, the download is only bounded by the step
+		if err := dl.downloadFiles(dstDir, fileList...); err != nil {
+			return err
+		}
+		if len(dl.sources) == 1 {
+			dl.served = dl.sources[0].URL
+		}
+		return nil
+	}
+	var errs []error
+	for i, source := range dl.sources {
+		err := dl.downloadFrom(source, dstDir, fileList...)
+		if err == nil {
+			dl.served = source.URL
+			appendStepLog(dl.ctx, "download", fmt.Sprintf("[%s] + %v served by %s\n\n", time.Now().Format(time.RFC3339), fileList, source.URL))
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", source.URL, err))
+		if dl.ctx.Err() != nil {
+			break
+		}
+		if i+1 < len(dl.sources) {
+			logger.Warnf("download %v from %s failed, fall back to %s: %v", fileList, source.URL, dl.sources[i+1].URL, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// downloadFrom downloads the files from source within the timeout of the source.
+func (dl *Downloader) downloadFrom(source Mirror, dstDir string, fileList ...string) error {
+	timeout := source.Timeout
+	if timeout == 0 {
+		timeout = dl.mirrorTimeout
+	}
+	parent := dl.ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		dl.ctx, cancel = context.WithTimeout(parent, timeout)
+		defer cancel()
+	}
+	defer func() { dl.ctx = parent }()
+	dl.baseURI = fmt.Sprintf("%s/%s", source.URL, dl.pkgPath)
+	return dl.downloadFiles(dstDir, fileList...)
+}
+
+func (dl *Downloader) sourceURLs() []string {
+	urls := make([]string, 0, len(dl.sources))
+	for _, s := range dl.sources {
+		urls = append(urls, s.URL)
+	}
+	return urls
+}
+
+func (dl *Downloader) downloadFiles(dstDir string, fileList ...string) (err error) {
 	// top-level manifest file
 	mElements, err := dl.getManifestElements(dl.manifestDir)
 	if err != nil {
@@ -236,11 +375,11 @@ func (dl *Downloader) Download(fileList ...string) (err error) {
 	}
 	var files []string
 	for _, filename := range fileList {
-		absolutePath := filepath.Join(dl.dstDir, filename)
+		absolutePath := filepath.Join(dstDir, filename)
 		files = append(files, absolutePath)
 		// download resource file
-		if err = dl.DownloadFile(dl.dstDir, filename); err != nil {
-			logger.Errorf("download %s resource file failed: %s", absolutePath)
+		if err = dl.downloadAndVerify(mElements, dstDir, filename); err != nil {
+			logger.Errorf("download %s resource file failed: %v", absolutePath, err)
 			return
 		}
 	}
@@ -252,6 +391,48 @@ func (dl *Downloader) Download(fileList ...string) (err error) {
 	return
 }
 
+// downloadAndVerify downloads the file and checks its sha256 digest,
+// a file not matching the manifest is downloaded once more before giving up.
+func (dl *Downloader) downloadAndVerify(manifest []ManifestElement, dstDir, filename string) error {
+	for retried := false; ; retried = true {
+		if err := dl.DownloadFile(dstDir, filename); err != nil {
+			return err
+		}
+		err := VerifySHA256(manifest, filename, filepath.Join(dstDir, filename))
+		if err == nil || retried || !errors.Is(err, ErrChecksumMismatch) {
+			return err
+		}
+		logger.Warnf("%v, download %s again", err, filename)
+	}
+}
+
+// VerifySHA256 checks the file against the sha256 digest of the manifest element named name,
+// the check is skipped when the manifest carries no sha256 digest for it.
+func VerifySHA256(manifest []ManifestElement, name, file string) error {
+	for _, v := range manifest {
+		if v.Name != name || v.SHA256 == "" {
+			continue
+		}
+		if sum := fileutil.Sha256Sum(file); !strings.EqualFold(sum, v.SHA256) {
+			return fmt.Errorf("%w for %s: expected sha256 %s, got %s", ErrChecksumMismatch, name, v.SHA256, sum)
+		}
+		return nil
+	}
+	return nil
+}
+
+// SHA256Checklist formats the sha256 digests of the manifest in the sha256sum --check format,
+// the paths are relative to the directory of the manifest.
+func SHA256Checklist(manifest []ManifestElement) string {
+	var b strings.Builder
+	for _, v := range manifest {
+		if v.SHA256 != "" {
+			fmt.Fprintf(&b, "%s  %s\n", v.SHA256, v.Name)
+		}
+	}
+	return b.String()
+}
+
 // validateMd5Digest validates md5 digest of file list
 // files param: the value must be an absolute path
 func (dl *Downloader) validateMd5Digest(manifest []ManifestElement, files []string) (err error) {
@@ -302,22 +483,13 @@ func (dl *Downloader) getManifestElements(prefix string) (manifest []ManifestEle
 	return
 }
 
-// DownloadFile download the file to the specified directory
+// DownloadFile download the file to the specified directory. The file is written to a partial file first,
+// a failed attempt is retried with an exponential backoff and resumes the partial file by a Range request.
+// All attempts are bounded by the context, which expires with the step.
 func (dl *Downloader) DownloadFile(dstDir, filename string) (err error) {
-	prefix := fmt.Sprintf("backsource.%d-%.3f.", os.Getpid(), float64(time.Now().UnixNano())/float64(time.Second))
 	dstFile := path.Join(dstDir, filename)
-	file, err := os.CreateTemp(filepath.Dir(dstFile), prefix)
-	if err != nil {
-		return fmt.Errorf("create temp file failed: %v", err)
-	}
-	defer file.Close()
 	fullURL := fmt.Sprintf("%s/%s", dl.baseURI, filename)
 	logger.Debug("start to download file", zap.String("download from", fullURL))
-	resp, err := httpGet(fullURL, 0)
-	if err != nil {
-		return fmt.Errorf("download failed: %v", err)
-	}
-	defer resp.Body.Close()
 	defer func() {
 		// assembly command
 		cmd := fmt.Sprintf("download from %s", fullURL)
@@ -328,73 +500,130 @@ func (dl *Downloader) DownloadFile(dstDir, filename string) (err error) {
 			}
 			return
 		}
-		ln := fmt.Sprintf("[%s] + %s %s\n\n", time.Now().Format(time.RFC3339), cmd, dealErr(err))
-		if check, sErr := cmdutil.CheckContextAndAppendStepLogFile(dl.ctx, []byte(ln)); sErr != nil {
-			// detect context content and distinguish errors
-			if check {
-				logger.Error("get operation step log file failed: "+sErr.Error(),
-					zap.String("operation", component.GetOperationID(dl.ctx)),
-					zap.String("step", component.GetStepID(dl.ctx)),
-					zap.String("cmd", cmd),
-				)
-			} else {
-				// commands do not need to be logged
-				logger.Debug("this command does not need to be logged", zap.String("cmd", cmd))
-			}
-		}
+		appendStepLog(dl.ctx, cmd, fmt.Sprintf("[%s] + %s %s\n\n", time.Now().Format(time.RFC3339), cmd, dealErr(err)))
 	}()
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("failed to download from source, response code: %d", resp.StatusCode)
-	}
-	buf := make([]byte, 512*1024)
-	reader := fileutil.NewFileReader(resp.Body, false)
-	if _, err = io.CopyBuffer(file, reader, buf); err != nil {
-		return fmt.Errorf("copy buffer error: %d", err)
+	backoff := dl.retryBackoff
+	for attempt := 0; ; attempt++ {
+		var retryable bool
+		if retryable, err = dl.downloadPartial(fullURL, dstFile+partialFileSuffix, filename); err == nil {
+			return fileutil.MoveFile(dstFile+partialFileSuffix, dstFile)
+		}
+		if !retryable || attempt >= dl.retries {
+			return err
+		}
+		logger.Warnf("download %s failed, retry in %s: %v", fullURL, backoff, err)
+		select {
+		case <-dl.ctx.Done():
+			return fmt.Errorf("download %s canceled: %v", fullURL, dl.ctx.Err())
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
 	}
-	return fileutil.MoveFile(file.Name(), dstFile)
 }
 
-func httpGet(url string, timeout time.Duration) (resp *http.Response, err error) {
-	var (
-		cancel func()
-		client = &http.Client{}
-	)
-	req, err := http.NewRequest("GET", url, nil)
+// downloadPartial downloads the remaining bytes of the partial file, it reports whether a failure can be retried.
+func (dl *Downloader) downloadPartial(fullURL, partialFile, filename string) (bool, error) {
+	file, err := os.OpenFile(partialFile, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		return
+		return false, fmt.Errorf("open partial file failed: %v", err)
 	}
-	if timeout > 0 {
-		timeoutCtx, cancelFunc := context.WithTimeout(context.Background(), timeout)
-		req = req.WithContext(timeoutCtx)
-		cancel = cancelFunc
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, fmt.Errorf("seek partial file failed: %v", err)
 	}
-	res, err := client.Do(req)
+	resp, err := httpGet(dl.ctx, fullURL, offset)
 	if err != nil {
-		return nil, err
+		return dl.ctx.Err() == nil, fmt.Errorf("download failed: %v", err)
 	}
-	if cancel == nil {
-		return res, nil
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		logger.Debugf("resume downloading %s from %d bytes", fullURL, offset)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// the partial file does not belong to the remote file, start over
+		if err = file.Truncate(0); err != nil {
+			return false, fmt.Errorf("truncate partial file failed: %v", err)
+		}
+		return true, fmt.Errorf("the partial file of %s does not match the source", filename)
+	case resp.StatusCode == http.StatusNotFound:
+		return false, fmt.Errorf("%s %w", fullURL, ErrNotFound)
+	case resp.StatusCode >= 400:
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+		return retryable, fmt.Errorf("failed to download from source, response code: %d", resp.StatusCode)
+	default:
+		// the source ignored the range, the whole file is sent again
+		if err = file.Truncate(0); err != nil {
+			return false, fmt.Errorf("truncate partial file failed: %v", err)
+		}
+		if offset, err = file.Seek(0, io.SeekStart); err != nil {
+			return false, fmt.Errorf("seek partial file failed: %v", err)
+		}
+	}
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	progress := newProgressWriter(dl.ctx, filename, offset, total)
+	buf := make([]byte, 512*1024)
+	reader := fileutil.NewFileReader(resp.Body, false)
+	_, err = io.CopyBuffer(io.MultiWriter(file, progress), reader, buf)
+	progress.report()
+	if err != nil {
+		return dl.ctx.Err() == nil, fmt.Errorf("copy buffer error: %v", err)
 	}
-	res.Body = newWithFuncReadCloser(res.Body, cancel)
-	return res, nil
+	return false, nil
 }
 
-type withFuncReadCloser struct {
-	f func()
-	io.ReadCloser
+// appendStepLog writes ln into the log file of the step running the download.
+func appendStepLog(ctx context.Context, cmd, ln string) {
+	if check, sErr := cmdutil.CheckContextAndAppendStepLogFile(ctx, []byte(ln)); sErr != nil {
+		// detect context content and distinguish errors
+		if check {
+			logger.Error("get operation step log file failed: "+sErr.Error(),
+				zap.String("operation", component.GetOperationID(ctx)),
+				zap.String("step", component.GetStepID(ctx)),
+				zap.String("cmd", cmd),
+			)
+		} else {
+			// commands do not need to be logged
+			logger.Debug("this command does not need to be logged", zap.String("cmd", cmd))
+		}
+	}
 }
 
-func (wrc *withFuncReadCloser) Close() error {
-	if wrc.f != nil {
-		wrc.f()
+func httpGet(ctx context.Context, url string, offset int64) (*http.Response, error) {
+	client := newHTTPClient()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
 	}
-	return wrc.ReadCloser.Close()
+	return client.Do(req)
 }
 
-func newWithFuncReadCloser(rc io.ReadCloser, f func()) io.ReadCloser {
-	return &withFuncReadCloser{
-		f:          f,
-		ReadCloser: rc,
+// removeStalePartialFiles removes the partial files which were not resumed within maxAge, 0 keeps them.
+func removeStalePartialFiles(maxAge time.Duration, dirs ...string) {
+	if maxAge <= 0 {
+		return
+	}
+	for _, dir := range dirs {
+		files, _ := filepath.Glob(filepath.Join(dir, "*"+partialFileSuffix))
+		for _, f := range files {
+			info, err := os.Stat(f)
+			if err != nil || time.Since(info.ModTime()) <= maxAge {
+				continue
+			}
+			if err = os.Remove(f); err != nil {
+				logger.Warnf("remove stale partial file %s failed: %v", f, err)
+				continue
+			}
+			logger.Debugf("remove stale partial file %s", f)
+		}
 	}
 }
 
@@ -0,0 +1,313 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDownloader_downloadAndVerify(t *testing.T) {
+	chart := []byte("cilium chart")
+	sum := sha256.Sum256(chart)
+	manifest := []ManifestElement{{Name: ChartFilename, SHA256: hex.EncodeToString(sum[:])}}
+
+	tests := []struct {
+		name      string
+		responses [][]byte
+		wantErr   bool
+		wantGets  int
+	}{
+		{name: "match", responses: [][]byte{chart}, wantGets: 1},
+		{name: "truncated once", responses: [][]byte{chart[:4], chart}, wantGets: 2},
+		{name: "tampered", responses: [][]byte{[]byte("tampered"), []byte("tampered")}, wantErr: true, wantGets: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gets := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body := tt.responses[len(tt.responses)-1]
+				if gets < len(tt.responses) {
+					body = tt.responses[gets]
+				}
+				gets++
+				_, _ = w.Write(body)
+			}))
+			defer srv.Close()
+			dl := &Downloader{ctx: context.TODO(), baseURI: srv.URL, dstDir: t.TempDir()}
+
+			err := dl.downloadAndVerify(manifest, dl.dstDir, ChartFilename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("downloadAndVerify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("downloadAndVerify() error = %v, want ErrChecksumMismatch", err)
+			}
+			if gets != tt.wantGets {
+				t.Errorf("downloadAndVerify() downloaded %d times, want %d", gets, tt.wantGets)
+			}
+		})
+	}
+}
+
+func TestDownloader_downloadMirrors(t *testing.T) {
+	chart := []byte("cilium chart")
+	sum := sha256.Sum256(chart)
+	manifest, _ := json.Marshal([]ManifestElement{{Name: ChartFilename, SHA256: hex.EncodeToString(sum[:])}})
+	serve := func(body []byte, delay time.Duration) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(delay):
+			}
+			switch r.URL.Path {
+			case "/cilium/1.14.4/amd64/" + ManifestFilename:
+				_, _ = w.Write(manifest)
+			case "/cilium/1.14.4/amd64/" + ChartFilename:
+				_, _ = w.Write(body)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	}
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	empty := httptest.NewServer(http.NotFoundHandler())
+	defer empty.Close()
+	tampered := serve([]byte("tampered"), 0)
+	defer tampered.Close()
+	slow := serve(chart, time.Second)
+	defer slow.Close()
+	mirror := serve(chart, 0)
+	defer mirror.Close()
+
+	tests := []struct {
+		name       string
+		sources    []Mirror
+		wantErr    error
+		wantSource string
+	}{
+		{name: "primary", sources: []Mirror{{URL: mirror.URL}, {URL: down.URL}}, wantSource: mirror.URL},
+		{name: "primary down", sources: []Mirror{{URL: down.URL}, {URL: mirror.URL}}, wantSource: mirror.URL},
+		{name: "not found on the primary", sources: []Mirror{{URL: empty.URL}, {URL: mirror.URL}}, wantSource: mirror.URL},
+		{name: "tampered mirror", sources: []Mirror{{URL: tampered.URL}, {URL: mirror.URL}}, wantSource: mirror.URL},
+		{name: "mirror timed out", sources: []Mirror{{URL: slow.URL, Timeout: 50 * time.Millisecond}, {URL: mirror.URL}}, wantSource: mirror.URL},
+		{name: "every source tampered", sources: []Mirror{{URL: down.URL}, {URL: tampered.URL}}, wantErr: ErrChecksumMismatch},
+		{name: "not found on every source", sources: []Mirror{{URL: empty.URL}, {URL: empty.URL}}, wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl := &Downloader{
+				ctx:           context.TODO(),
+				sources:       tt.sources,
+				pkgPath:       "cilium/1.14.4/amd64",
+				mirrorTimeout: time.Minute,
+				chartDir:      t.TempDir(),
+				manifestDir:   t.TempDir(),
+				retryBackoff:  time.Millisecond,
+			}
+			file, err := dl.DownloadCharts()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("DownloadCharts() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DownloadCharts() error = %v", err)
+			}
+			if got, _ := os.ReadFile(file); !bytes.Equal(got, chart) {
+				t.Errorf("DownloadCharts() content = %q, want %q", got, chart)
+			}
+			if dl.Source() != tt.wantSource {
+				t.Errorf("Source() = %s, want %s", dl.Source(), tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestVerifySHA256(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ImageFilename)
+	if err := os.WriteFile(file, []byte("images"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("images"))
+	if err := VerifySHA256([]ManifestElement{{Name: ImageFilename, SHA256: fmt.Sprintf("%X", sum)}}, ImageFilename, file); err != nil {
+		t.Errorf("VerifySHA256() error = %v", err)
+	}
+	if err := VerifySHA256([]ManifestElement{{Name: ImageFilename, Digest: "md5"}}, ImageFilename, file); err != nil {
+		t.Errorf("VerifySHA256() of a manifest without sha256 error = %v", err)
+	}
+	if got := SHA256Checklist([]ManifestElement{{Name: ImageFilename, SHA256: "abc"}, {Name: ConfigFilename}}); got != "abc  images.tar.gz\n" {
+		t.Errorf("SHA256Checklist() = %q", got)
+	}
+}
+
+func TestDownloader_VerifyImages(t *testing.T) {
+	dir := t.TempDir()
+	sum := sha256.Sum256([]byte("images"))
+	manifest, _ := json.Marshal([]ManifestElement{{Name: ImageFilename, SHA256: hex.EncodeToString(sum[:])}})
+	if err := os.WriteFile(filepath.Join(dir, ManifestFilename), manifest, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dl := &Downloader{dstDir: dir, manifestDir: dir}
+	if err := os.WriteFile(filepath.Join(dir, ImageFilename), []byte("images"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := dl.VerifyImages(); err != nil {
+		t.Errorf("VerifyImages() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ImageFilename), []byte("imag"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := dl.VerifyImages(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("VerifyImages() of a corrupt file error = %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestDownloader_DownloadFile(t *testing.T) {
+	content := []byte("offline package content")
+	tests := []struct {
+		name     string
+		partial  []byte
+		failures int
+		status   int
+		retries  int
+		wantErr  bool
+		wantGets int
+	}{
+		{name: "resume partial file", partial: content[:8], wantGets: 1},
+		{name: "retry server errors", failures: 2, status: http.StatusServiceUnavailable, retries: 3, wantGets: 3},
+		{name: "retries exhausted", failures: 3, status: http.StatusServiceUnavailable, retries: 2, wantErr: true, wantGets: 3},
+		{name: "not found is not retried", failures: 1, status: http.StatusNotFound, retries: 3, wantErr: true, wantGets: 1},
+		{name: "partial file not matching", partial: append(append([]byte{}, content...), "extra"...), retries: 1, wantGets: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gets int
+			var ranges []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gets++
+				ranges = append(ranges, r.Header.Get("Range"))
+				if gets <= tt.failures {
+					w.WriteHeader(tt.status)
+					return
+				}
+				http.ServeContent(w, r, "pkg", time.Time{}, bytes.NewReader(content))
+			}))
+			defer srv.Close()
+			dir := t.TempDir()
+			if tt.partial != nil {
+				if err := os.WriteFile(filepath.Join(dir, ImageFilename+partialFileSuffix), tt.partial, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			dl := &Downloader{ctx: context.TODO(), baseURI: srv.URL, dstDir: dir, retries: tt.retries, retryBackoff: time.Millisecond}
+
+			err := dl.DownloadFile(dir, ImageFilename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DownloadFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gets != tt.wantGets {
+				t.Errorf("DownloadFile() requests = %d, want %d", gets, tt.wantGets)
+			}
+			if tt.wantErr {
+				if tt.status == http.StatusNotFound && !errors.Is(err, ErrNotFound) {
+					t.Errorf("DownloadFile() error = %v, want ErrNotFound", err)
+				}
+				return
+			}
+			if got, _ := os.ReadFile(filepath.Join(dir, ImageFilename)); !bytes.Equal(got, content) {
+				t.Errorf("DownloadFile() content = %q, want %q", got, content)
+			}
+			if tt.partial != nil && ranges[0] != fmt.Sprintf("bytes=%d-", len(tt.partial)) {
+				t.Errorf("DownloadFile() first range = %q, want the partial file resumed", ranges[0])
+			}
+			if _, err = os.Stat(filepath.Join(dir, ImageFilename+partialFileSuffix)); !os.IsNotExist(err) {
+				t.Errorf("the partial file is left after the download")
+			}
+		})
+	}
+}
+
+func TestDownloader_DownloadFileCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	dl := &Downloader{ctx: ctx, baseURI: srv.URL, dstDir: t.TempDir(), retries: 100, retryBackoff: time.Second}
+
+	start := time.Now()
+	if err := dl.DownloadFile(dl.dstDir, ImageFilename); err == nil {
+		t.Fatalf("DownloadFile() should fail when the step times out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("DownloadFile() returned after %v, want it bounded by the context", elapsed)
+	}
+}
+
+func TestRemoveStalePartialFiles(t *testing.T) {
+	dir := t.TempDir()
+	stale, fresh := filepath.Join(dir, "stale.tgz"+partialFileSuffix), filepath.Join(dir, "fresh.tgz"+partialFileSuffix)
+	for _, f := range []string{stale, fresh} {
+		if err := os.WriteFile(f, []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+	removeStalePartialFiles(24*time.Hour, dir)
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale partial file is not removed")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("fresh partial file is removed: %v", err)
+	}
+}
+
+func TestProgressWriter_String(t *testing.T) {
+	p := newProgressWriter(context.TODO(), ChartFilename, 1024, 4096)
+	p.last = p.start.Add(2 * time.Second)
+	_, _ = p.Write(make([]byte, 2048))
+	if got, want := p.String(), "3072/4096 bytes (75%) 1.0KiB/s"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	p.total = -1
+	if got, want := p.String(), "3072 bytes 1.0KiB/s"; got != want {
+		t.Errorf("String() without the size = %q, want %q", got, want)
+	}
+}
@@ -18,6 +18,8 @@
 
 package downloader
 
+import "time"
+
 var (
 	CloudStaticServer = "https://oss.kubeclipper.io/packages"
 )
@@ -26,14 +28,82 @@ type Options struct {
 	Address       string `json:"address" yaml:"address"`
 	TLSCertFile   string `json:"tlsCertFile" yaml:"tlsCertFile"`
 	TLSPrivateKey string `json:"tlsPrivateKey" yaml:"tlsPrivateKey"`
+	// Retries the download attempts of a file after the first one failed.
+	Retries int `json:"retries" yaml:"retries"`
+	// RetryBackoff the delay before the first retry, it doubles on every retry.
+	RetryBackoff time.Duration `json:"retryBackoff" yaml:"retryBackoff"`
+	// PartialFileMaxAge removes the partial downloads not resumed within the age, 0 keeps them.
+	PartialFileMaxAge time.Duration `json:"partialFileMaxAge" yaml:"partialFileMaxAge"`
+	// CacheKeepVersions the most recently used versions of a package the cache garbage collection keeps, 0 keeps every version.
+	CacheKeepVersions int `json:"cacheKeepVersions" yaml:"cacheKeepVersions"`
+	// CacheMaxSize the total size in bytes the cache garbage collection shrinks the cache to, 0 is unlimited.
+	CacheMaxSize int64 `json:"cacheMaxSize" yaml:"cacheMaxSize"`
+	// HTTPProxy the proxy of the downloads over http, e.g. http://proxy.example.com:3128.
+	HTTPProxy string `json:"httpProxy,omitempty" yaml:"httpProxy,omitempty"`
+	// HTTPSProxy the proxy the downloads over https are tunneled through.
+	HTTPSProxy string `json:"httpsProxy,omitempty" yaml:"httpsProxy,omitempty"`
+	// NoProxy the comma-separated hosts, domains and CIDRs reached without the proxy, the package server of
+	// kubeclipper is always reached directly.
+	NoProxy string `json:"noProxy,omitempty" yaml:"noProxy,omitempty"`
+	// Mirrors the fallbacks of the online package server, they are tried in order once it failed.
+	Mirrors []Mirror `json:"mirrors,omitempty" yaml:"mirrors,omitempty"`
+	// MirrorTimeout bounds the download from a source when there are mirrors to fall back to, 0 is only bounded by the step.
+	MirrorTimeout time.Duration `json:"mirrorTimeout" yaml:"mirrorTimeout"`
+}
+
+// Mirror a source of packages with the layout of the package server.
+type Mirror struct {
+	URL string `json:"url" yaml:"url"`
+	// Timeout overrides the MirrorTimeout of the options for the mirror.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// Packages the names of the packages the mirror serves, it serves every package when it is empty.
+	Packages []string `json:"packages,omitempty" yaml:"packages,omitempty"`
+}
+
+// serves whether the mirror serves the package name.
+func (m *Mirror) serves(name string) bool {
+	if len(m.Packages) == 0 {
+		return true
+	}
+	for _, p := range m.Packages {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// onlineSources the online package server followed by the mirrors of the package name.
+func (o *Options) onlineSources(name string) []Mirror {
+	sources := []Mirror{{URL: CloudStaticServer}}
+	for _, m := range o.Mirrors {
+		if m.serves(name) {
+			sources = append(sources, m)
+		}
+	}
+	return sources
 }
 
 func NewOptions() *Options {
-	return &Options{}
+	return &Options{
+		Retries:           3,
+		RetryBackoff:      2 * time.Second,
+		PartialFileMaxAge: 24 * time.Hour,
+		CacheKeepVersions: 2,
+		MirrorTimeout:     10 * time.Minute,
+	}
+}
+
+// Artifact the output of the steps downloading a package, it records the source which served the files.
+type Artifact struct {
+	Files  []string `json:"files"`
+	Source string   `json:"source,omitempty"`
 }
 
 type ManifestElement struct {
 	Name   string `json:"name"`
 	Digest string `json:"digest"`
+	// SHA256 the sha256 digest of the file, packages built before it was added only carry the md5 Digest.
+	SHA256 string `json:"sha256,omitempty"`
 	Path   string `json:"path"`
 }
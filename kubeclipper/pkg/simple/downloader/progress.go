@@ -0,0 +1,79 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// progressWriter counts the downloaded bytes and periodically appends the progress to the step log,
+// the lines keep the raw byte counts so that the progress of the step can be parsed from the log.
+type progressWriter struct {
+	ctx      context.Context
+	filename string
+	start    time.Time
+	last     time.Time
+	// resumed the bytes of the partial file before the download
+	resumed int64
+	done    int64
+	// total the size of the file, -1 when the source does not send it
+	total int64
+}
+
+func newProgressWriter(ctx context.Context, filename string, resumed, total int64) *progressWriter {
+	now := time.Now()
+	return &progressWriter{ctx: ctx, filename: filename, start: now, last: now, resumed: resumed, done: resumed, total: total}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if time.Since(p.last) >= progressInterval {
+		p.report()
+	}
+	return len(b), nil
+}
+
+func (p *progressWriter) report() {
+	p.last = time.Now()
+	cmd := fmt.Sprintf("download progress %s", p.filename)
+	appendStepLog(p.ctx, cmd, fmt.Sprintf("[%s] + %s %s\n\n", p.last.Format(time.RFC3339), cmd, p.String()))
+}
+
+// String formats the progress, e.g. 52428800/104857600 bytes (50%) 2.5MiB/s.
+func (p *progressWriter) String() string {
+	rate := 0.0
+	if elapsed := p.last.Sub(p.start).Seconds(); elapsed > 0 {
+		rate = float64(p.done-p.resumed) / elapsed
+	}
+	if p.total <= 0 {
+		return fmt.Sprintf("%d bytes %s/s", p.done, formatBytes(rate))
+	}
+	return fmt.Sprintf("%d/%d bytes (%d%%) %s/s", p.done, p.total, p.done*100/p.total, formatBytes(rate))
+}
+
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	i := 0
+	for ; n >= 1024 && i < len(units)-1; i++ {
+		n /= 1024
+	}
+	return fmt.Sprintf("%.1f%s", n, units[i])
+}
@@ -0,0 +1,65 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package downloader
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// hasProxy whether the options configure a proxy.
+func (o *Options) hasProxy() bool {
+	return o != nil && (o.HTTPProxy != "" || o.HTTPSProxy != "")
+}
+
+// ProxyFunc the proxy of the HTTP clients of the agent downloading packages, the https requests are tunneled
+// through the HTTPSProxy by CONNECT. The package server of kubeclipper, the NoProxy of the options and the hosts
+// of noProxy, e.g. the local registry, are reached directly. It is nil when the options configure no proxy,
+// the clients then keep the proxy of the agent environment.
+func ProxyFunc(noProxy ...string) func(*http.Request) (*url.URL, error) {
+	if !options.hasProxy() {
+		return nil
+	}
+	bypass := append([]string{options.NoProxy}, noProxy...)
+	if u, err := url.Parse(options.Address); err == nil && u.Host != "" {
+		bypass = append(bypass, u.Host)
+	}
+	proxy := (&httpproxy.Config{
+		HTTPProxy:  options.HTTPProxy,
+		HTTPSProxy: options.HTTPSProxy,
+		NoProxy:    strings.Join(bypass, ","),
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
+
+// newHTTPClient the client of the downloads, it honors the proxy of the options.
+func newHTTPClient() *http.Client {
+	proxy := ProxyFunc()
+	if proxy == nil {
+		return &http.Client{}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	return &http.Client{Transport: transport}
+}
@@ -0,0 +1,94 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setOptions(t *testing.T, op *Options) {
+	t.Helper()
+	previous := options
+	SetOptions(op)
+	t.Cleanup(func() { SetOptions(previous) })
+}
+
+func TestProxyFunc(t *testing.T) {
+	setOptions(t, &Options{Address: "http://10.0.0.2:8081"})
+	if ProxyFunc() != nil {
+		t.Fatalf("ProxyFunc() should be nil without a proxy")
+	}
+
+	setOptions(t, &Options{
+		Address:    "http://10.0.0.2:8081",
+		HTTPProxy:  "http://proxy.corp:3128",
+		HTTPSProxy: "http://proxy.corp:3129",
+		NoProxy:    ".corp.internal,192.168.0.0/16",
+	})
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://oss.kubeclipper.io/packages/cilium/1.14.4/amd64/charts.tgz", want: "http://proxy.corp:3129"},
+		{url: "http://mirror.example.com/packages/charts.tgz", want: "http://proxy.corp:3128"},
+		{url: "http://10.0.0.2:8081/cilium/1.14.4/amd64/charts.tgz"},
+		{url: "https://packages.corp.internal/charts.tgz"},
+		{url: "https://192.168.10.5/v2/"},
+		{url: "https://registry.local:5000/v2/"},
+	}
+	proxy := ProxyFunc("registry.local:5000")
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		got, err := proxy(req)
+		if err != nil {
+			t.Fatalf("proxy(%s) error = %v", tt.url, err)
+		}
+		if (got == nil && tt.want != "") || (got != nil && got.String() != tt.want) {
+			t.Errorf("proxy(%s) = %v, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestDownloader_DownloadFileProxy(t *testing.T) {
+	content := []byte("cilium chart")
+	var requested string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+		_, _ = w.Write(content)
+	}))
+	defer proxy.Close()
+	setOptions(t, &Options{HTTPProxy: proxy.URL})
+
+	dir := t.TempDir()
+	dl := &Downloader{ctx: context.TODO(), baseURI: "http://packages.example.com/cilium/1.14.4/amd64", dstDir: dir}
+	if err := dl.DownloadFile(dir, ChartFilename); err != nil {
+		t.Fatalf("DownloadFile() error = %v", err)
+	}
+	if want := "http://packages.example.com/cilium/1.14.4/amd64/" + ChartFilename; requested != want {
+		t.Errorf("the proxy was requested %q, want %q", requested, want)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, ChartFilename)); string(got) != string(content) {
+		t.Errorf("DownloadFile() content = %q, want %q", got, content)
+	}
+}
@@ -31,10 +31,26 @@ import (
 
 	"github.com/kubeclipper/kubeclipper/pkg/component"
 	"github.com/kubeclipper/kubeclipper/pkg/logger"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
 )
 
 func RunCmdWithContext(ctx context.Context, dryRun bool, command string, args ...string) (*ExecCmd, error) {
-	ec := NewExecCmd(ctx, command, args...)
+	return runExecCmd(ctx, dryRun, NewExecCmd(ctx, command, args...))
+}
+
+// RunCommandWithContext runs the shell command of cmd like RunCmdWithContext, with the environment variables of cmd.
+// The sensitive arguments of cmd are masked in the logs.
+func RunCommandWithContext(ctx context.Context, dryRun bool, cmd *v1.Command) (*ExecCmd, error) {
+	ec := NewExecCmd(ctx, cmd.ShellCommand[0], cmd.ShellCommand[1:]...)
+	ec.masked = cmd.MaskedShellCommand()
+	for _, env := range cmd.Env {
+		ec.env = append(ec.env, env.Name+"="+env.Value)
+	}
+	return runExecCmd(ctx, dryRun, ec)
+}
+
+func runExecCmd(ctx context.Context, dryRun bool, ec *ExecCmd) (*ExecCmd, error) {
+	command := ec.Args[0]
 	logger.Debug("running command", zap.String("cmd", ec.String()))
 	if dryRun {
 		_, err := ec.stdOutBuf.WriteString("dry run command")
@@ -26,13 +26,22 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strings"
+	"syscall"
 	"time"
 )
 
+// terminationGracePeriod the time a command has to exit after it is terminated by its context.
+const terminationGracePeriod = 10 * time.Second
+
 type ExecCmd struct {
 	stdOutBuf *bytes.Buffer
 	stdErrBuf *bytes.Buffer
 	startTime time.Time
+	// env the environment variables added to the environment of the process.
+	env []string
+	// masked the command line with its sensitive arguments masked, it is logged instead of the command line.
+	masked []string
 	*exec.Cmd
 }
 
@@ -44,6 +53,12 @@ func NewExecCmd(ctx context.Context, command string, args ...string) *ExecCmd {
 		startTime: time.Now(),
 	}
 	ec.Cmd.Stdout, ec.Cmd.Stderr = ec.stdOutBuf, ec.stdErrBuf
+	// terminate the command when ctx is done so that it can clean up, e.g. helm releases its lock,
+	// it is killed when it does not exit within the grace period
+	ec.Cmd.Cancel = func() error {
+		return ec.Cmd.Process.Signal(syscall.SIGTERM)
+	}
+	ec.Cmd.WaitDelay = terminationGracePeriod
 	return ec
 }
 
@@ -63,13 +78,21 @@ func (ec *ExecCmd) StdOut() string { return ec.stdOutBuf.String() }
 func (ec *ExecCmd) StdErr() string { return ec.stdErrBuf.String() }
 
 // String returns a human-readable description of command
-func (ec *ExecCmd) CommandString() string { return ec.Cmd.String() }
+func (ec *ExecCmd) CommandString() string { return ec.String() }
+
+// String returns a human-readable description of command, the sensitive arguments of the command are masked.
+func (ec *ExecCmd) String() string {
+	if ec.masked != nil {
+		return strings.Join(ec.masked, " ")
+	}
+	return ec.Cmd.String()
+}
 
 func (ec *ExecCmd) Run() error {
 	if ec.Cmd.Process != nil {
 		return errors.New("exec: already started")
 	}
-	ec.Cmd.Env = os.Environ()
+	ec.Cmd.Env = append(os.Environ(), ec.env...)
 	if err := ec.Cmd.Run(); err != nil {
 		// errs = append(errs, err)
 		// command runs and exits with a non-zero exit status
@@ -86,7 +109,7 @@ func (ec *ExecCmd) Marshal() ([]byte, error) {
 
 	// format: [2006-01-02T15:04:05Z07:00]: ${command line}
 	// e.g., [2006-01-02T15:04:05Z07:00]: systemctl start docker
-	buf.WriteString(fmt.Sprintf("[%s]: %s\n", ec.startTime.Format(time.RFC3339), ec.Cmd.String()))
+	buf.WriteString(fmt.Sprintf("[%s]: %s\n", ec.startTime.Format(time.RFC3339), ec.String()))
 
 	// copy stdout contents to buffer
 	if _, err := buf.Write(ec.stdOutBuf.Bytes()); err != nil {
@@ -21,6 +21,7 @@ package fileutil
 import (
 	"bufio"
 	"crypto/md5"
+	"crypto/sha256"
 	"fmt"
 	"hash"
 	"io"
@@ -45,6 +46,15 @@ func IsRegularFile(name string) bool {
 
 // Md5Sum generates md5 for a given file.
 func Md5Sum(name string) string {
+	return fileSum(name, md5.New())
+}
+
+// Sha256Sum generates sha256 for a given file.
+func Sha256Sum(name string) string {
+	return fileSum(name, sha256.New())
+}
+
+func fileSum(name string, h hash.Hash) string {
 	if !IsRegularFile(name) {
 		return ""
 	}
@@ -54,7 +64,6 @@ func Md5Sum(name string) string {
 	}
 	defer f.Close()
 	r := bufio.NewReaderSize(f, bufferSize)
-	h := md5.New()
 
 	_, err = io.Copy(h, r)
 	if err != nil {
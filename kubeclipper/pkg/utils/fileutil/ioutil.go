@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"time"
 
 	"go.uber.org/zap"
@@ -58,26 +59,65 @@ func WriteFileWithDataFunc(path string, flag int, perm os.FileMode, dataFunc fun
 	return dataFunc(f)
 }
 
-func WriteFileWithContext(ctx context.Context, path string, flag int, perm os.FileMode, fn func(w io.Writer) error, dryRun bool) error {
+// WriteFileAtomicWithDataFunc writes the data to a temp file next to path and renames it to path once it is synced,
+// so that path holds either its previous content or the whole new content, never a partial write.
+func WriteFileAtomicWithDataFunc(path string, perm os.FileMode, dataFunc func(w io.Writer) error, dryRun bool) (err error) {
+	if dryRun {
+		return nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
 	defer func() {
-		ln := fmt.Sprintf("[%s] + rendering %s\n\n", time.Now().Format(time.RFC3339), path)
-		if check, err := cmdutil.CheckContextAndAppendStepLogFile(ctx, []byte(ln)); err != nil {
-			// detect context content and distinguish errors
-			if check {
-				logger.Error("get operation step log file failed: "+err.Error(),
-					zap.String("operation", component.GetOperationID(ctx)),
-					zap.String("step", component.GetStepID(ctx)),
-					zap.String("cmd", fmt.Sprintf("render %s", path)),
-				)
-			} else {
-				// commands do not need to be logged
-				logger.Debug("this command does not need to be logged", zap.String("cmd", fmt.Sprintf("render %s", path)))
-			}
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
 		}
 	}()
+	if err = dataFunc(f); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func WriteFileWithContext(ctx context.Context, path string, flag int, perm os.FileMode, fn func(w io.Writer) error, dryRun bool) error {
+	defer appendRenderLog(ctx, path)
 	return WriteFileWithDataFunc(path, flag, perm, fn, dryRun)
 }
 
+// WriteFileAtomicWithContext is WriteFileWithContext replacing path atomically, see WriteFileAtomicWithDataFunc.
+func WriteFileAtomicWithContext(ctx context.Context, path string, perm os.FileMode, fn func(w io.Writer) error, dryRun bool) error {
+	defer appendRenderLog(ctx, path)
+	return WriteFileAtomicWithDataFunc(path, perm, fn, dryRun)
+}
+
+func appendRenderLog(ctx context.Context, path string) {
+	ln := fmt.Sprintf("[%s] + rendering %s\n\n", time.Now().Format(time.RFC3339), path)
+	if check, err := cmdutil.CheckContextAndAppendStepLogFile(ctx, []byte(ln)); err != nil {
+		// detect context content and distinguish errors
+		if check {
+			logger.Error("get operation step log file failed: "+err.Error(),
+				zap.String("operation", component.GetOperationID(ctx)),
+				zap.String("step", component.GetStepID(ctx)),
+				zap.String("cmd", fmt.Sprintf("render %s", path)),
+			)
+		} else {
+			// commands do not need to be logged
+			logger.Debug("this command does not need to be logged", zap.String("cmd", fmt.Sprintf("render %s", path)))
+		}
+	}
+}
+
 func Peek(filepath string, offset int64, length int) (data []byte, err error) {
 	f, err := os.Open(filepath)
 	if err != nil {
@@ -19,8 +19,10 @@
 package fileutil
 
 import (
+	"errors"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -53,3 +55,45 @@ func TestWriteFileWithDataFunc(t *testing.T) {
 		os.Remove(tt.filename)
 	}
 }
+
+func TestWriteFileAtomicWithDataFunc(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "cilium.yaml")
+	original := "operator:\n  replicas: 1\n"
+	assert.NoError(t, os.WriteFile(filename, []byte(original), 0600))
+
+	// the write fails half way, the original content must be kept and the temp file removed
+	err := WriteFileAtomicWithDataFunc(filename, 0644, func(w io.Writer) error {
+		if _, err := w.Write([]byte("operator:\n  repl")); err != nil {
+			return err
+		}
+		return errors.New("agent killed")
+	}, false)
+	assert.Error(t, err)
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, original, string(data))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// dry run does not touch the file
+	assert.NoError(t, WriteFileAtomicWithDataFunc(filename, 0644, func(w io.Writer) error {
+		return errors.New("dry run must not render")
+	}, true))
+
+	content := "operator:\n  replicas: 2\n"
+	assert.NoError(t, WriteFileAtomicWithDataFunc(filename, 0644, func(w io.Writer) error {
+		_, err := w.Write([]byte(content))
+		return err
+	}, false))
+	data, err = os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(data))
+	info, err := os.Stat(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+	entries, err = os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,283 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package helmutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	"github.com/kubeclipper/kubeclipper/pkg/logger"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/utils/cmdutil"
+)
+
+const (
+	// defaultTimeout the timeout of the helm cli when --timeout is not given.
+	defaultTimeout = 5 * time.Minute
+	// DiffMaxSize the size the diff of HelmActionDiff is truncated to, the diff is kept on the operation.
+	DiffMaxSize = 64 * 1024
+)
+
+// Run runs the release operation of cmd with the helm SDK. Like the helm cli it reads the kubeconfig from
+// $KUBECONFIG or ~/.kube/config and stores the releases as secrets, the environment of the command in ctx, see
+// component.WithCommandEnv, overrides the one of the agent. The output lists the resources the operation
+// added, changed or removed with the diff of the changed ones and ends with the status of the release.
+func Run(ctx context.Context, cmd *v1.HelmCommand, dryRun bool) (string, error) {
+	if cmd == nil {
+		return "", fmt.Errorf("helm command without the helm payload")
+	}
+	if dryRun {
+		return fmt.Sprintf("dry run: helm %s %s -n %s\n", cmd.Action, cmd.Release, cmd.Namespace), nil
+	}
+	settings := cli.New()
+	settings.SetNamespace(cmd.Namespace)
+	withCommandEnv(ctx, settings)
+	cfg := new(action.Configuration)
+	if err := cfg.Init(settings.RESTClientGetter(), cmd.Namespace, os.Getenv("HELM_DRIVER"), actionLog(ctx)); err != nil {
+		return "", fmt.Errorf("init helm configuration: %w", err)
+	}
+	switch cmd.Action {
+	case v1.HelmActionInstall:
+		return install(ctx, cfg, settings, cmd)
+	case v1.HelmActionUninstall:
+		return uninstall(cfg, cmd)
+	case v1.HelmActionDiff:
+		return diff(ctx, cfg, settings, cmd)
+	}
+	return "", fmt.Errorf("unsupported helm action %q", cmd.Action)
+}
+
+// withCommandEnv applies the KUBECONFIG, HELM_CACHE_HOME and HELM_CONFIG_HOME of the command being run to settings,
+// they are not set on the agent process which runs the commands of several steps at once.
+func withCommandEnv(ctx context.Context, settings *cli.EnvSettings) {
+	if kubeconfig := component.GetCommandEnv(ctx, "KUBECONFIG"); kubeconfig != "" {
+		settings.KubeConfig = kubeconfig
+	}
+	if cache := component.GetCommandEnv(ctx, "HELM_CACHE_HOME"); cache != "" {
+		settings.RepositoryCache = filepath.Join(cache, "repository")
+	}
+	if config := component.GetCommandEnv(ctx, "HELM_CONFIG_HOME"); config != "" {
+		settings.RepositoryConfig = filepath.Join(config, "repositories.yaml")
+		settings.RegistryConfig = filepath.Join(config, "registry", "config.json")
+	}
+}
+
+func timeout(cmd *v1.HelmCommand) time.Duration {
+	if cmd.Timeout.Duration > 0 {
+		return cmd.Timeout.Duration
+	}
+	return defaultTimeout
+}
+
+// actionLog logs the progress of the helm actions, e.g. the resources helm waits for, and appends it to the log of
+// the step running the command.
+func actionLog(ctx context.Context) action.DebugLog {
+	return func(format string, v ...interface{}) {
+		logger.Debugf(format, v...)
+		line := fmt.Sprintf("[%s] helm: %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, v...))
+		_, _ = cmdutil.CheckContextAndAppendStepLogFile(ctx, []byte(line))
+	}
+}
+
+// loadChart loads the chart of cmd and merges its values file and --set arguments.
+func loadChart(settings *cli.EnvSettings, cmd *v1.HelmCommand) (*chart.Chart, map[string]interface{}, error) {
+	c, err := loader.Load(cmd.ChartPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load chart %s: %w", cmd.ChartPath, err)
+	}
+	opts := &values.Options{Values: cmd.Set}
+	if cmd.ValuesFile != "" {
+		opts.ValueFiles = []string{cmd.ValuesFile}
+	}
+	vals, err := opts.MergeValues(getter.All(settings))
+	if err != nil {
+		return nil, nil, fmt.Errorf("merge values of release %s: %w", cmd.Release, err)
+	}
+	return c, vals, nil
+}
+
+// install installs the release or upgrades it when it exists, the same way as helm upgrade --install.
+func install(ctx context.Context, cfg *action.Configuration, settings *cli.EnvSettings, cmd *v1.HelmCommand) (string, error) {
+	chart, vals, err := loadChart(settings, cmd)
+	if err != nil {
+		return "", err
+	}
+
+	history := action.NewHistory(cfg)
+	history.Max = 1
+	var (
+		rel      *release.Release
+		previous string
+	)
+	if _, err = history.Run(cmd.Release); errors.Is(err, driver.ErrReleaseNotFound) {
+		client := action.NewInstall(cfg)
+		client.ReleaseName = cmd.Release
+		client.Namespace = cmd.Namespace
+		client.CreateNamespace = cmd.CreateNamespace
+		client.Wait = cmd.Wait
+		client.Atomic = cmd.Atomic
+		client.Timeout = timeout(cmd)
+		rel, err = client.RunWithContext(ctx, chart, vals)
+	} else if err != nil {
+		return "", fmt.Errorf("get history of release %s: %w", cmd.Release, err)
+	} else {
+		var last *release.Release
+		if last, err = action.NewGet(cfg).Run(cmd.Release); err != nil {
+			return "", fmt.Errorf("get release %s: %w", cmd.Release, err)
+		}
+		previous = last.Manifest
+		client := action.NewUpgrade(cfg)
+		client.Namespace = cmd.Namespace
+		client.Wait = cmd.Wait
+		client.Atomic = cmd.Atomic
+		client.ReuseValues = cmd.ReuseValues
+		client.Timeout = timeout(cmd)
+		rel, err = client.RunWithContext(ctx, cmd.Release, chart, vals)
+	}
+	if rel == nil {
+		return "", err
+	}
+	return ManifestDiff(previous, rel.Manifest) + releaseStatus(rel), err
+}
+
+// diff renders the upgrade of the installed release with the server, like helm upgrade --dry-run, and returns the
+// diff of its manifest against the deployed one.
+func diff(ctx context.Context, cfg *action.Configuration, settings *cli.EnvSettings, cmd *v1.HelmCommand) (string, error) {
+	chart, vals, err := loadChart(settings, cmd)
+	if err != nil {
+		return "", err
+	}
+	last, err := action.NewGet(cfg).Run(cmd.Release)
+	if err != nil {
+		return "", fmt.Errorf("get release %s: %w", cmd.Release, err)
+	}
+	client := action.NewUpgrade(cfg)
+	client.Namespace = cmd.Namespace
+	client.ReuseValues = cmd.ReuseValues
+	client.DryRun = true
+	rel, err := client.RunWithContext(ctx, cmd.Release, chart, vals)
+	if err != nil {
+		return "", fmt.Errorf("render the upgrade of release %s: %w", cmd.Release, err)
+	}
+	out := ManifestDiff(last.Manifest, rel.Manifest)
+	if len(out) > DiffMaxSize {
+		out = out[:DiffMaxSize] + "\n... the diff is truncated\n"
+	}
+	return out, nil
+}
+
+func uninstall(cfg *action.Configuration, cmd *v1.HelmCommand) (string, error) {
+	client := action.NewUninstall(cfg)
+	client.Wait = cmd.Wait
+	client.Timeout = timeout(cmd)
+	resp, err := client.Run(cmd.Release)
+	if err != nil {
+		return "", err
+	}
+	var out string
+	if resp != nil && resp.Release != nil {
+		out = ManifestDiff(resp.Release.Manifest, "")
+	}
+	return out + fmt.Sprintf("release %s uninstalled\n", cmd.Release), nil
+}
+
+func releaseStatus(rel *release.Release) string {
+	status := release.StatusUnknown
+	if rel.Info != nil {
+		status = rel.Info.Status
+	}
+	return fmt.Sprintf("release %s %s revision %d\n", rel.Name, status, rel.Version)
+}
+
+// ManifestDiff compares the rendered manifests of two revisions of a release, a line per added, changed or
+// removed resource, the changed resources are followed by their unified diff:
+//
+//	added <kind>/<name>
+//	changed <kind>/<name>
+//	removed <kind>/<name>
+func ManifestDiff(from, to string) string {
+	fromResources, toResources := manifestResources(from), manifestResources(to)
+	keys := make([]string, 0, len(fromResources)+len(toResources))
+	for key := range fromResources {
+		keys = append(keys, key)
+	}
+	for key := range toResources {
+		if _, ok := fromResources[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		before, inFrom := fromResources[key]
+		after, inTo := toResources[key]
+		switch {
+		case !inFrom:
+			fmt.Fprintf(&b, "added %s\n", key)
+		case !inTo:
+			fmt.Fprintf(&b, "removed %s\n", key)
+		case before != after:
+			fmt.Fprintf(&b, "changed %s\n", key)
+			diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+				A:       difflib.SplitLines(before),
+				B:       difflib.SplitLines(after),
+				Context: 2,
+			})
+			b.WriteString(diff)
+		}
+	}
+	return b.String()
+}
+
+// manifestResources splits manifest into its resources keyed by <kind>/<name>.
+func manifestResources(manifest string) map[string]string {
+	resources := make(map[string]string)
+	for _, doc := range releaseutil.SplitManifests(manifest) {
+		var head struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		}
+		if err := yaml.Unmarshal([]byte(doc), &head); err != nil || head.Kind == "" {
+			continue
+		}
+		resources[head.Kind+"/"+head.Metadata.Name] = strings.TrimSpace(doc) + "\n"
+	}
+	return resources
+}
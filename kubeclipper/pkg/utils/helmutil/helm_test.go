@@ -0,0 +1,125 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package helmutil
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/cli"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+const revision1 = `---
+# Source: cilium/templates/cilium-configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cilium-config
+data:
+  debug: "false"
+---
+# Source: cilium/templates/cilium-agent/serviceaccount.yaml
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: cilium
+---
+# Source: cilium/templates/hubble/serviceaccount.yaml
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: hubble-relay
+`
+
+const revision2 = `---
+# Source: cilium/templates/cilium-configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cilium-config
+data:
+  debug: "true"
+---
+# Source: cilium/templates/cilium-agent/serviceaccount.yaml
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: cilium
+---
+# Source: cilium/templates/cilium-operator/serviceaccount.yaml
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: cilium-operator
+`
+
+func TestManifestDiff(t *testing.T) {
+	got := ManifestDiff(revision1, revision2)
+	for _, want := range []string{
+		"changed ConfigMap/cilium-config\n",
+		"-  debug: \"false\"\n+  debug: \"true\"\n",
+		"added ServiceAccount/cilium-operator\n",
+		"removed ServiceAccount/hubble-relay\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("ManifestDiff() = %s, want %q", got, want)
+		}
+	}
+	if strings.Contains(got, "ServiceAccount/cilium\n") {
+		t.Errorf("ManifestDiff() = %s, the unchanged resources are omitted", got)
+	}
+	if got := ManifestDiff(revision1, revision1); got != "" {
+		t.Errorf("ManifestDiff() of the same manifests = %s, want empty", got)
+	}
+	if got := ManifestDiff(revision1, ""); strings.Count(got, "removed ") != 3 {
+		t.Errorf("ManifestDiff() of the uninstalled release = %s, want all resources removed", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	if _, err := Run(context.TODO(), nil, false); err == nil {
+		t.Errorf("Run() without the helm payload should fail")
+	}
+	out, err := Run(context.TODO(), &v1.HelmCommand{Action: v1.HelmActionInstall, Release: "cilium", Namespace: "kube-system"}, true)
+	if err != nil || out != "dry run: helm install cilium -n kube-system\n" {
+		t.Errorf("Run() dry run = %q, %v", out, err)
+	}
+}
+
+func TestWithCommandEnv(t *testing.T) {
+	settings := cli.New()
+	withCommandEnv(context.TODO(), settings)
+	if settings.KubeConfig != "" {
+		t.Errorf("withCommandEnv() without the command env set kubeconfig %s", settings.KubeConfig)
+	}
+	ctx := component.WithCommandEnv(context.TODO(), []v1.EnvVar{
+		{Name: "KUBECONFIG", Value: "/etc/kubernetes/admin.conf"},
+		{Name: "HELM_CACHE_HOME", Value: "/opt/kc/helm/cache"},
+		{Name: "HELM_CONFIG_HOME", Value: "/opt/kc/helm/config"},
+	})
+	withCommandEnv(ctx, settings)
+	if settings.KubeConfig != "/etc/kubernetes/admin.conf" || settings.RepositoryCache != "/opt/kc/helm/cache/repository" ||
+		settings.RepositoryConfig != "/opt/kc/helm/config/repositories.yaml" || settings.RegistryConfig != "/opt/kc/helm/config/registry/config.json" {
+		t.Errorf("withCommandEnv() settings = %+v", settings)
+	}
+}
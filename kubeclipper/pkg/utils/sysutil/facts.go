@@ -0,0 +1,151 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package sysutil
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+const (
+	// FactsTimeout the time the facts collection may take, the facts not collected by then are left empty.
+	FactsTimeout = 2 * time.Second
+	// factsCacheSize the operations whose facts are kept.
+	factsCacheSize = 8
+)
+
+// Facts the node-local facts the agent exposes to the templates it renders, a fact which could not be
+// collected is empty.
+type Facts struct {
+	KernelVersion string `json:"kernelVersion"`
+	// CgroupVersion 1 or 2, 0 when it is unknown.
+	CgroupVersion int `json:"cgroupVersion"`
+	CPUs          int `json:"cpus"`
+	// DefaultInterface the interface of the IPv4 default route.
+	DefaultInterface string `json:"defaultInterface"`
+	// DefaultInterfaceMTU the MTU of DefaultInterface.
+	DefaultInterfaceMTU int `json:"defaultInterfaceMTU"`
+	// Interfaces the names of the interfaces which are up, the loopback excluded.
+	Interfaces []string `json:"interfaces"`
+}
+
+// FactsCollector collects the facts of the node.
+type FactsCollector interface {
+	Collect(ctx context.Context) Facts
+}
+
+// NodeFactsCollector collects the facts from the kernel interfaces of the node, it reads files only.
+type NodeFactsCollector struct{}
+
+func (NodeFactsCollector) Collect(ctx context.Context) Facts {
+	facts := Facts{CPUs: runtime.NumCPU(), CgroupVersion: cgroupVersion("/sys/fs/cgroup")}
+	facts.KernelVersion, _ = host.KernelVersionWithContext(ctx)
+	facts.DefaultInterface = defaultRouteInterface("/proc/net/route")
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		facts.Interfaces = append(facts.Interfaces, iface.Name)
+		if iface.Name == facts.DefaultInterface {
+			facts.DefaultInterfaceMTU = iface.MTU
+		}
+	}
+	sort.Strings(facts.Interfaces)
+	return facts
+}
+
+// cgroupVersion 2 when the unified hierarchy is mounted at root, 1 when the v1 hierarchies are.
+func cgroupVersion(root string) int {
+	if _, err := os.Stat(root + "/cgroup.controllers"); err == nil {
+		return 2
+	}
+	if _, err := os.Stat(root); err == nil {
+		return 1
+	}
+	return 0
+}
+
+// defaultRouteInterface the interface of the first IPv4 default route of the route table file.
+func defaultRouteInterface(routeFile string) string {
+	f, err := os.Open(routeFile)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 8 && fields[1] == "00000000" && fields[7] == "00000000" {
+			return fields[0]
+		}
+	}
+	return ""
+}
+
+// FactsCache collects the facts once per operation, the facts of the latest operations are kept.
+type FactsCache struct {
+	collector FactsCollector
+	mu        sync.Mutex
+	// operations the keys of facts, the oldest first
+	operations []string
+	facts      map[string]Facts
+}
+
+func NewFactsCache(collector FactsCollector) *FactsCache {
+	return &FactsCache{collector: collector, facts: make(map[string]Facts)}
+}
+
+// Get returns the facts of the operation opID, they are collected when the operation first asks for them.
+// The collection is abandoned after FactsTimeout, the operation gets the empty facts then.
+func (c *FactsCache) Get(ctx context.Context, opID string) Facts {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if facts, ok := c.facts[opID]; ok {
+		return facts
+	}
+	ctx, cancel := context.WithTimeout(ctx, FactsTimeout)
+	defer cancel()
+	collected := make(chan Facts, 1)
+	go func() {
+		collected <- c.collector.Collect(ctx)
+	}()
+	var facts Facts
+	select {
+	case facts = <-collected:
+	case <-ctx.Done():
+	}
+	if len(c.operations) == factsCacheSize {
+		delete(c.facts, c.operations[0])
+		c.operations = c.operations[1:]
+	}
+	c.operations = append(c.operations, opID)
+	c.facts[opID] = facts
+	return facts
+}
@@ -0,0 +1,123 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package sysutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeCollector struct {
+	calls int32
+	delay time.Duration
+}
+
+func (c *fakeCollector) Collect(ctx context.Context) Facts {
+	n := atomic.AddInt32(&c.calls, 1)
+	select {
+	case <-time.After(c.delay):
+	case <-ctx.Done():
+		return Facts{}
+	}
+	return Facts{KernelVersion: "5.15.0", CgroupVersion: 2, CPUs: int(n)}
+}
+
+func TestFactsCache_Get(t *testing.T) {
+	collector := &fakeCollector{}
+	cache := NewFactsCache(collector)
+	first := cache.Get(context.TODO(), "op1")
+	if first.KernelVersion != "5.15.0" || first.CgroupVersion != 2 {
+		t.Fatalf("Get() = %+v", first)
+	}
+	if again := cache.Get(context.TODO(), "op1"); again.CPUs != first.CPUs || collector.calls != 1 {
+		t.Errorf("Get() of the same operation collected again, calls = %d", collector.calls)
+	}
+	if other := cache.Get(context.TODO(), "op2"); other.CPUs == first.CPUs || collector.calls != 2 {
+		t.Errorf("Get() of another operation = %+v, calls = %d, want collected again", other, collector.calls)
+	}
+	for i := 0; i < factsCacheSize; i++ {
+		cache.Get(context.TODO(), string(rune('a'+i)))
+	}
+	if len(cache.facts) != factsCacheSize {
+		t.Errorf("the cache keeps %d operations, want %d", len(cache.facts), factsCacheSize)
+	}
+	if _, ok := cache.facts["op1"]; ok {
+		t.Errorf("the facts of the oldest operation are kept")
+	}
+}
+
+func TestFactsCache_GetTimeout(t *testing.T) {
+	cache := NewFactsCache(&fakeCollector{delay: time.Minute})
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if facts := cache.Get(ctx, "op1"); facts.KernelVersion != "" {
+		t.Errorf("Get() = %+v, want the empty facts", facts)
+	}
+	if elapsed := time.Since(start); elapsed > FactsTimeout {
+		t.Errorf("Get() took %s", elapsed)
+	}
+}
+
+func TestNodeFactsCollector_Collect(t *testing.T) {
+	start := time.Now()
+	facts := NodeFactsCollector{}.Collect(context.TODO())
+	if elapsed := time.Since(start); elapsed > FactsTimeout {
+		t.Errorf("Collect() took %s, want less than %s", elapsed, FactsTimeout)
+	}
+	if facts.CPUs == 0 {
+		t.Errorf("Collect() = %+v, want the CPUs", facts)
+	}
+}
+
+func Test_defaultRouteInterface(t *testing.T) {
+	routes := filepath.Join(t.TempDir(), "route")
+	data := "Iface\tDestination\tGateway\tFlags\tRefCnt\tUse\tMetric\tMask\tMTU\tWindow\tIRTT\n" +
+		"eth1\t0000A8C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0\n" +
+		"eth0\t00000000\t0101A8C0\t0003\t0\t0\t0\t00000000\t0\t0\t0\n"
+	if err := os.WriteFile(routes, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := defaultRouteInterface(routes); got != "eth0" {
+		t.Errorf("defaultRouteInterface() = %q, want eth0", got)
+	}
+	if got := defaultRouteInterface(filepath.Join(t.TempDir(), "missing")); got != "" {
+		t.Errorf("defaultRouteInterface() of a missing file = %q", got)
+	}
+}
+
+func Test_cgroupVersion(t *testing.T) {
+	root := t.TempDir()
+	if got := cgroupVersion(root); got != 1 {
+		t.Errorf("cgroupVersion() = %d, want 1", got)
+	}
+	if err := os.WriteFile(filepath.Join(root, "cgroup.controllers"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := cgroupVersion(root); got != 2 {
+		t.Errorf("cgroupVersion() = %d, want 2", got)
+	}
+	if got := cgroupVersion(filepath.Join(root, "missing")); got != 0 {
+		t.Errorf("cgroupVersion() of a missing root = %d, want 0", got)
+	}
+}
@@ -20,11 +20,16 @@ package template
 
 import (
 	"bytes"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"reflect"
+	"strings"
 	"text/template"
 
 	"github.com/Masterminds/sprig/v3"
+	"sigs.k8s.io/yaml"
 )
 
 type AdvancedTemplate struct {
@@ -40,8 +45,14 @@ const (
 func funcMap() template.FuncMap {
 	f := sprig.TxtFuncMap()
 
-	// add extra functionality
-	extra := template.FuncMap{}
+	// add extra functionality, the helm style helpers below accept nil which renders as empty
+	extra := template.FuncMap{
+		"toYaml":  toYaml,
+		"indent":  indent,
+		"nindent": nindent,
+		"b64enc":  b64enc,
+		"b64dec":  b64dec,
+	}
 
 	for k, v := range extra {
 		f[k] = v
@@ -94,3 +105,59 @@ func (at *AdvancedTemplate) RenderTo(w io.Writer, tmpl string, vars interface{})
 	}
 	return w.Write([]byte(out))
 }
+
+// toYaml marshals v to YAML without the trailing newline like helm, nil renders as empty.
+func toYaml(v interface{}) (string, error) {
+	if isNil(v) {
+		return "", nil
+	}
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// indent prefixes every line of v with spaces.
+func indent(spaces int, v interface{}) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(toString(v), "\n", "\n"+pad)
+}
+
+// nindent indents v on a new line.
+func nindent(spaces int, v interface{}) string {
+	return "\n" + indent(spaces, v)
+}
+
+func b64enc(v interface{}) string {
+	return base64.StdEncoding.EncodeToString([]byte(toString(v)))
+}
+
+func b64dec(v interface{}) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(toString(v))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	if isNil(v) {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
@@ -77,3 +77,75 @@ func TestRegisterFunc(t *testing.T) {
 		assert.Equal(t, tt.expected, result, tt.tmpl)
 	}
 }
+
+func TestHelmFuncs(t *testing.T) {
+	type resources struct {
+		Requests map[string]string `json:"requests,omitempty"`
+	}
+	var nilResources *resources
+	tests := []struct {
+		tmpl, expected string
+		vars           interface{}
+	}{
+		{
+			tmpl:     `{{ toYaml . }}`,
+			expected: "- 10.0.0.0/16\n- 10.1.0.0/16",
+			vars:     []string{"10.0.0.0/16", "10.1.0.0/16"},
+		},
+		{
+			tmpl:     `resources:{{ toYaml . | nindent 2 }}`,
+			expected: "resources:\n  requests:\n    cpu: 100m",
+			vars:     &resources{Requests: map[string]string{"cpu": "100m"}},
+		},
+		{
+			tmpl:     `{{ toYaml . | indent 4 }}`,
+			expected: "    a: 1\n    b: 2",
+			vars:     map[string]int{"b": 2, "a": 1},
+		},
+		{
+			tmpl:     `[{{ toYaml . }}]`,
+			expected: `[]`,
+			vars:     nilResources,
+		},
+		{
+			tmpl:     `[{{ toYaml nil }}{{ b64enc nil }}{{ b64dec nil }}{{ indent 2 nil }}]`,
+			expected: `[  ]`,
+			vars:     nil,
+		},
+		{
+			tmpl:     `{{ nindent 2 nil }}`,
+			expected: "\n  ",
+			vars:     nil,
+		},
+		{
+			tmpl:     `{{ default "cluster-pool" . }}`,
+			expected: `cluster-pool`,
+			vars:     nil,
+		},
+		{
+			tmpl:     `{{ default "cluster-pool" . }}`,
+			expected: `kubernetes`,
+			vars:     "kubernetes",
+		},
+		{
+			tmpl:     `{{ quote . }}|{{ quote nil }}`,
+			expected: `"1.14"|`,
+			vars:     "1.14",
+		},
+		{
+			tmpl:     `{{ b64enc . | b64dec }}`,
+			expected: `cilium`,
+			vars:     []byte("cilium"),
+		},
+	}
+
+	at := New()
+	for _, tt := range tests {
+		result, err := at.Render(tt.tmpl, tt.vars)
+		assert.NoError(t, err, tt.tmpl)
+		assert.Equal(t, tt.expected, result, tt.tmpl)
+	}
+
+	_, err := New().Render(`{{ b64dec . }}`, "not base64")
+	assert.Error(t, err)
+}
@@ -0,0 +1,378 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package waitutil
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+const (
+	// defaultInterval the delay between the polls when the command does not set it.
+	defaultInterval = 2 * time.Second
+	// defaultTimeout the time to wait when the command does not set it.
+	defaultTimeout = 5 * time.Minute
+	// maxReportedPods the not ready pods listed in the observed state at most.
+	maxReportedPods = 5
+)
+
+var crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// clients the clients of the kubernetes API the waits poll with.
+type clients struct {
+	kube    kubernetes.Interface
+	dynamic dynamic.Interface
+}
+
+// Run polls the kubernetes API until the resource of cmd meets its condition. Like kubectl it reads the kubeconfig
+// from $KUBECONFIG or ~/.kube/config, the KUBECONFIG of the environment of the command in ctx, see
+// component.WithCommandEnv, overrides them. The output has a line for every change of the observed state, the
+// error of a wait timing out ends with the last one.
+func Run(ctx context.Context, cmd *v1.WaitForCommand, dryRun bool) (string, error) {
+	if cmd == nil {
+		return "", fmt.Errorf("wait command without the wait payload")
+	}
+	if err := validate(cmd); err != nil {
+		return "", err
+	}
+	if dryRun {
+		return fmt.Sprintf("dry run: wait for %s to be %s\n", target(cmd), cmd.Condition), nil
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeconfig := component.GetCommandEnv(ctx, "KUBECONFIG"); kubeconfig != "" {
+		rules.ExplicitPath = kubeconfig
+	}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return "", fmt.Errorf("load the kubeconfig: %w", err)
+	}
+	c := clients{}
+	if c.kube, err = kubernetes.NewForConfig(config); err != nil {
+		return "", err
+	}
+	if c.dynamic, err = dynamic.NewForConfig(config); err != nil {
+		return "", err
+	}
+	out := &strings.Builder{}
+	err = waitFor(ctx, c, cmd, out)
+	return out.String(), err
+}
+
+func validate(cmd *v1.WaitForCommand) error {
+	kinds := map[v1.WaitCondition][]string{
+		v1.WaitConditionRolloutComplete: {"Deployment", "DaemonSet", "StatefulSet"},
+		v1.WaitConditionPodsReady:       {"Pod"},
+		v1.WaitConditionEstablished:     {"CustomResourceDefinition"},
+	}
+	supported, ok := kinds[cmd.Condition]
+	if !ok {
+		return fmt.Errorf("unsupported wait condition %q", cmd.Condition)
+	}
+	for _, kind := range supported {
+		if kind == cmd.Kind {
+			if cmd.Name == "" && cmd.Condition != v1.WaitConditionPodsReady {
+				return fmt.Errorf("the %s to wait for has no name", cmd.Kind)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("can not wait for %s to be %s, the condition applies to %s", cmd.Kind, cmd.Condition, strings.Join(supported, ", "))
+}
+
+// target the resource of cmd in the output, e.g. DaemonSet kube-system/cilium.
+func target(cmd *v1.WaitForCommand) string {
+	name := cmd.Name
+	if cmd.Condition == v1.WaitConditionPodsReady {
+		var selectors []string
+		for _, s := range []string{cmd.Selector, cmd.FieldSelector} {
+			if s != "" {
+				selectors = append(selectors, s)
+			}
+		}
+		name = "pods " + strings.Join(selectors, ",")
+		if cmd.Namespace != "" {
+			return fmt.Sprintf("%s in %s", name, cmd.Namespace)
+		}
+		return name
+	}
+	if cmd.Namespace != "" {
+		name = cmd.Namespace + "/" + name
+	}
+	return cmd.Kind + " " + name
+}
+
+func durationDefaultIfZero(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
+
+// waitFor polls until the condition of cmd is met, the timeout of cmd or ctx is done.
+func waitFor(ctx context.Context, c clients, cmd *v1.WaitForCommand, out *strings.Builder) error {
+	timeout := durationDefaultIfZero(cmd.Timeout.Duration, defaultTimeout)
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var last string
+	err := wait.PollImmediateUntilWithContext(waitCtx, durationDefaultIfZero(cmd.Interval.Duration, defaultInterval),
+		func(ctx context.Context) (bool, error) {
+			done, state := observe(ctx, c, cmd)
+			if state != last {
+				fmt.Fprintf(out, "[%s] %s: %s\n", time.Now().Format(time.RFC3339), target(cmd), state)
+				last = state
+			}
+			return done, nil
+		})
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("wait for %s to be %s: %w, last observed: %s", target(cmd), cmd.Condition, ctx.Err(), last)
+	}
+	return fmt.Errorf("timed out waiting for %s to be %s after %s, last observed: %s", target(cmd), cmd.Condition, timeout, last)
+}
+
+// observe returns whether the resource of cmd meets the condition and its state, the errors of the API are
+// states as well, the next poll may succeed.
+func observe(ctx context.Context, c clients, cmd *v1.WaitForCommand) (bool, string) {
+	var (
+		done  bool
+		state string
+		err   error
+	)
+	switch cmd.Condition {
+	case v1.WaitConditionRolloutComplete:
+		done, state, err = rolloutComplete(ctx, c, cmd)
+	case v1.WaitConditionPodsReady:
+		done, state, err = podsReady(ctx, c, cmd)
+	case v1.WaitConditionEstablished:
+		done, state, err = established(ctx, c, cmd)
+	}
+	if apierrors.IsNotFound(err) {
+		return false, "not found"
+	}
+	if err != nil {
+		return false, err.Error()
+	}
+	return done, state
+}
+
+// rolloutComplete follows the checks of kubectl rollout status, the not ready pods of a rollout in progress are
+// added to its state.
+func rolloutComplete(ctx context.Context, c clients, cmd *v1.WaitForCommand) (bool, string, error) {
+	var (
+		done     bool
+		state    string
+		selector *metav1.LabelSelector
+	)
+	switch cmd.Kind {
+	case "Deployment":
+		d, err := c.kube.AppsV1().Deployments(cmd.Namespace).Get(ctx, cmd.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		done, state = deploymentStatus(d)
+		selector = d.Spec.Selector
+	case "DaemonSet":
+		ds, err := c.kube.AppsV1().DaemonSets(cmd.Namespace).Get(ctx, cmd.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		done, state = daemonSetStatus(ds)
+		selector = ds.Spec.Selector
+	case "StatefulSet":
+		sts, err := c.kube.AppsV1().StatefulSets(cmd.Namespace).Get(ctx, cmd.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		done, state = statefulSetStatus(sts)
+		selector = sts.Spec.Selector
+	}
+	if done || selector == nil {
+		return done, state, nil
+	}
+	labels, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false, state, nil
+	}
+	pods, err := c.kube.CoreV1().Pods(cmd.Namespace).List(ctx, metav1.ListOptions{LabelSelector: labels.String()})
+	if err != nil {
+		return false, state, nil
+	}
+	if _, notReady := readyPods(pods.Items); notReady != "" {
+		state += ", not ready: " + notReady
+	}
+	return false, state, nil
+}
+
+func replicasOrDefault(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+func deploymentStatus(d *appsv1.Deployment) (bool, string) {
+	replicas := replicasOrDefault(d.Spec.Replicas)
+	switch {
+	case d.Generation > d.Status.ObservedGeneration:
+		return false, "the update of the spec is not observed yet"
+	case d.Status.UpdatedReplicas < replicas:
+		return false, fmt.Sprintf("%d out of %d new replicas have been updated", d.Status.UpdatedReplicas, replicas)
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		return false, fmt.Sprintf("%d old replicas are pending termination", d.Status.Replicas-d.Status.UpdatedReplicas)
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas:
+		return false, fmt.Sprintf("%d of %d updated replicas are available", d.Status.AvailableReplicas, d.Status.UpdatedReplicas)
+	}
+	return true, "successfully rolled out"
+}
+
+func daemonSetStatus(ds *appsv1.DaemonSet) (bool, string) {
+	switch {
+	case ds.Generation > ds.Status.ObservedGeneration:
+		return false, "the update of the spec is not observed yet"
+	case ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled:
+		return false, fmt.Sprintf("%d out of %d new pods have been updated", ds.Status.UpdatedNumberScheduled, ds.Status.DesiredNumberScheduled)
+	case ds.Status.NumberAvailable < ds.Status.DesiredNumberScheduled:
+		return false, fmt.Sprintf("%d of %d updated pods are available", ds.Status.NumberAvailable, ds.Status.DesiredNumberScheduled)
+	}
+	return true, "successfully rolled out"
+}
+
+func statefulSetStatus(sts *appsv1.StatefulSet) (bool, string) {
+	replicas := replicasOrDefault(sts.Spec.Replicas)
+	switch {
+	case sts.Generation > sts.Status.ObservedGeneration:
+		return false, "the update of the spec is not observed yet"
+	case sts.Status.ReadyReplicas < replicas:
+		return false, fmt.Sprintf("%d of %d pods are ready", sts.Status.ReadyReplicas, replicas)
+	case sts.Spec.UpdateStrategy.Type == appsv1.RollingUpdateStatefulSetStrategyType && sts.Status.UpdateRevision != sts.Status.CurrentRevision:
+		return false, fmt.Sprintf("%d out of %d new pods have been updated", sts.Status.UpdatedReplicas, replicas)
+	}
+	return true, "successfully rolled out"
+}
+
+// podsReady waits for ReadyCount ready pods, or for every pod when it is zero.
+func podsReady(ctx context.Context, c clients, cmd *v1.WaitForCommand) (bool, string, error) {
+	pods, err := c.kube.CoreV1().Pods(cmd.Namespace).List(ctx, metav1.ListOptions{LabelSelector: cmd.Selector, FieldSelector: cmd.FieldSelector})
+	if err != nil {
+		return false, "", err
+	}
+	if len(pods.Items) == 0 {
+		return false, "no pods", nil
+	}
+	ready, notReady := readyPods(pods.Items)
+	want := cmd.ReadyCount
+	if want <= 0 {
+		want = len(pods.Items)
+	}
+	state := fmt.Sprintf("%d of %d pods are ready", ready, len(pods.Items))
+	if cmd.ReadyCount > 0 {
+		state = fmt.Sprintf("%d of %d pods are ready, waiting for %d", ready, len(pods.Items), want)
+	}
+	if ready >= want {
+		return true, state, nil
+	}
+	if notReady != "" {
+		state += ", not ready: " + notReady
+	}
+	return false, state, nil
+}
+
+// readyPods returns the number of ready pods and the not ready ones with the reason, e.g. cilium-x2v7k on node1:
+// ImagePullBackOff. maxReportedPods of them are listed at most.
+func readyPods(pods []corev1.Pod) (int, string) {
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	var (
+		ready    int
+		notReady []string
+	)
+	for i := range pods {
+		reason, ok := podReadiness(&pods[i])
+		if ok {
+			ready++
+			continue
+		}
+		notReady = append(notReady, fmt.Sprintf("%s on %s: %s", pods[i].Name, pods[i].Spec.NodeName, reason))
+	}
+	if len(notReady) > maxReportedPods {
+		notReady = append(notReady[:maxReportedPods], fmt.Sprintf("%d more", len(notReady)-maxReportedPods))
+	}
+	return ready, strings.Join(notReady, "; ")
+}
+
+// podReadiness returns whether the pod is ready and the reason it is not, the reason of the first waiting or failed
+// container or else the phase of the pod.
+func podReadiness(pod *corev1.Pod) (string, bool) {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+			return "", true
+		}
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason != "" {
+			return status.State.Waiting.Reason, false
+		}
+		if status.State.Terminated != nil && status.State.Terminated.Reason != "" && status.State.Terminated.ExitCode != 0 {
+			return status.State.Terminated.Reason, false
+		}
+	}
+	if pod.Status.Phase == corev1.PodRunning {
+		return "containers not ready", false
+	}
+	return string(pod.Status.Phase), false
+}
+
+// established waits for the Established condition of the CustomResourceDefinition.
+func established(ctx context.Context, c clients, cmd *v1.WaitForCommand) (bool, string, error) {
+	crd, err := c.dynamic.Resource(crdResource).Get(ctx, cmd.Name, metav1.GetOptions{})
+	if err != nil {
+		return false, "", err
+	}
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	for _, item := range conditions {
+		cond, ok := item.(map[string]interface{})
+		if !ok || cond["type"] != "Established" {
+			continue
+		}
+		if cond["status"] == "True" {
+			return true, "established", nil
+		}
+		return false, fmt.Sprintf("the Established condition is %v: %v", cond["status"], cond["reason"]), nil
+	}
+	return false, "not established yet", nil
+}
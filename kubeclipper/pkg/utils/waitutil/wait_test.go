@@ -0,0 +1,151 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package waitutil
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+)
+
+func pod(name, node string, ready bool, waiting string) *corev1.Pod {
+	p := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-system", Labels: map[string]string{"k8s-app": "cilium"}},
+		Spec:       corev1.PodSpec{NodeName: node},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	if ready {
+		p.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	}
+	if waiting != "" {
+		p.Status.ContainerStatuses = []corev1.ContainerStatus{{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: waiting}}}}
+	}
+	return p
+}
+
+func crd(name, status string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": name},
+		"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Established", "status": status, "reason": "InitialNamesAccepted"},
+		}},
+	}}
+}
+
+func TestWaitFor(t *testing.T) {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"k8s-app": "cilium"}}
+	kube := fake.NewSimpleClientset(
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "cilium", Namespace: "kube-system", Generation: 2},
+			Spec:       appsv1.DaemonSetSpec{Selector: selector},
+			Status:     appsv1.DaemonSetStatus{ObservedGeneration: 2, DesiredNumberScheduled: 2, UpdatedNumberScheduled: 2, NumberAvailable: 1},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "cilium-operator", Namespace: "kube-system", Generation: 1},
+			Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+		},
+		pod("cilium-a", "node1", true, ""),
+		pod("cilium-b", "node2", false, "ImagePullBackOff"),
+	)
+	scheme := runtime.NewScheme()
+	dynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{crdResource: "CustomResourceDefinitionList"},
+		crd("ciliumbgppeeringpolicies.cilium.io", "True"), crd("ciliumpodippools.cilium.io", "False"))
+	c := clients{kube: kube, dynamic: dynamic}
+
+	tests := []struct {
+		name    string
+		cmd     v1.WaitForCommand
+		wantErr string
+	}{
+		{name: "rolled out deployment", cmd: v1.WaitForCommand{Kind: "Deployment", Name: "cilium-operator", Namespace: "kube-system", Condition: v1.WaitConditionRolloutComplete}},
+		{
+			name:    "daemonset with a pod pulling its image",
+			cmd:     v1.WaitForCommand{Kind: "DaemonSet", Name: "cilium", Namespace: "kube-system", Condition: v1.WaitConditionRolloutComplete},
+			wantErr: "last observed: 1 of 2 updated pods are available, not ready: cilium-b on node2: ImagePullBackOff",
+		},
+		{
+			name:    "missing deployment",
+			cmd:     v1.WaitForCommand{Kind: "Deployment", Name: "hubble-relay", Namespace: "kube-system", Condition: v1.WaitConditionRolloutComplete},
+			wantErr: "last observed: not found",
+		},
+		{name: "ready count", cmd: v1.WaitForCommand{Kind: "Pod", Namespace: "kube-system", Selector: "k8s-app=cilium", Condition: v1.WaitConditionPodsReady, ReadyCount: 1}},
+		{
+			name:    "every pod",
+			cmd:     v1.WaitForCommand{Kind: "Pod", Namespace: "kube-system", Selector: "k8s-app=cilium", Condition: v1.WaitConditionPodsReady},
+			wantErr: "timed out waiting for pods k8s-app=cilium in kube-system to be podsReady after 50ms, last observed: 1 of 2 pods are ready",
+		},
+		{name: "established crd", cmd: v1.WaitForCommand{Kind: "CustomResourceDefinition", Name: "ciliumbgppeeringpolicies.cilium.io", Condition: v1.WaitConditionEstablished}},
+		{
+			name:    "crd not established",
+			cmd:     v1.WaitForCommand{Kind: "CustomResourceDefinition", Name: "ciliumpodippools.cilium.io", Condition: v1.WaitConditionEstablished},
+			wantErr: "last observed: the Established condition is False: InitialNamesAccepted",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cmd.Interval = metav1.Duration{Duration: 10 * time.Millisecond}
+			tt.cmd.Timeout = metav1.Duration{Duration: 50 * time.Millisecond}
+			if err := validate(&tt.cmd); err != nil {
+				t.Fatalf("validate() error = %v", err)
+			}
+			out := &strings.Builder{}
+			err := waitFor(context.TODO(), c, &tt.cmd, out)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("waitFor() error = %v, output %s", err, out)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("waitFor() error = %v, want %s", err, tt.wantErr)
+			}
+			if out.Len() == 0 {
+				t.Errorf("waitFor() did not report the observed state")
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	if _, err := Run(context.TODO(), nil, false); err == nil {
+		t.Errorf("Run() without the wait payload should fail")
+	}
+	if _, err := Run(context.TODO(), &v1.WaitForCommand{Kind: "Pod", Name: "cilium", Condition: v1.WaitConditionEstablished}, true); err == nil {
+		t.Errorf("Run() waiting for a pod to be established should fail")
+	}
+	out, err := Run(context.TODO(), &v1.WaitForCommand{Kind: "DaemonSet", Name: "cilium", Namespace: "kube-system", Condition: v1.WaitConditionRolloutComplete}, true)
+	if err != nil || out != "dry run: wait for DaemonSet kube-system/cilium to be rolloutComplete\n" {
+		t.Errorf("Run() dry run = %q, %v", out, err)
+	}
+}
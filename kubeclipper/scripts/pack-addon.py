@@ -134,6 +134,22 @@ def render_images(chart_path: str, values_path: Optional[str], helm_args: List[s
     return images
 
 
+def read_images_file(path: str) -> List[str]:
+    """
+    读取镜像列表文件，每行一个镜像，忽略空行和 # 注释。
+    CNI 的镜像列表可以用 go run ./tools/cni-images 生成。
+    """
+    images: List[str] = []
+    seen: Set[str] = set()
+    with open(path, "r", encoding="utf-8") as f:
+        for line in f:
+            img = line.strip()
+            if img and not img.startswith("#") and img not in seen:
+                images.append(img)
+                seen.add(img)
+    return images
+
+
 def image_exists(tool: str, image: str, ctr_namespace: str) -> bool:
     try:
         if tool == "nerdctl":
@@ -151,19 +167,19 @@ def image_exists(tool: str, image: str, ctr_namespace: str) -> bool:
     return False
 
 
-def pull_one(tool: str, image: str, ctr_namespace: str) -> None:
+def pull_one(tool: str, image: str, ctr_namespace: str, platform: str) -> None:
     if tool == "nerdctl":
-        run(["nerdctl", "pull", image])
+        run(["nerdctl", "pull", "--platform", platform, image])
     elif tool == "docker":
-        run(["docker", "pull", image])
+        run(["docker", "pull", "--platform", platform, image])
     elif tool == "ctr":
-        run(["ctr", "-n", ctr_namespace, "images", "pull", image])
+        run(["ctr", "-n", ctr_namespace, "images", "pull", "--platform", platform, image])
     else:
         raise RuntimeError(f"unsupported image tool: {tool}")
 
 
 def pull_images(images: List[str], tool: str, parallel: int, ctr_namespace: str,
-                ignore_errors: bool) -> None:
+                ignore_errors: bool, platform: str) -> None:
     if not images:
         return
 
@@ -176,7 +192,7 @@ def pull_images(images: List[str], tool: str, parallel: int, ctr_namespace: str,
     failures: List[Tuple[str, str]] = []
 
     with ThreadPoolExecutor(max_workers=max(1, parallel)) as ex:
-        futs = {ex.submit(pull_one, tool, img, ctr_namespace): img for img in to_pull}
+        futs = {ex.submit(pull_one, tool, img, ctr_namespace, platform): img for img in to_pull}
         for fut in as_completed(futs):
             img = futs[fut]
             try:
@@ -195,15 +211,15 @@ def pull_images(images: List[str], tool: str, parallel: int, ctr_namespace: str,
             print(f"  - {img}: {msg}")
 
 
-def save_images_to_tar(images: List[str], tool: str, outfile_tar: str, ctr_namespace: str) -> None:
+def save_images_to_tar(images: List[str], tool: str, outfile_tar: str, ctr_namespace: str, platform: str) -> None:
     if not images:
         return
     if tool == "nerdctl":
-        cmd = ["nerdctl", "save", "-o", outfile_tar] + images
+        cmd = ["nerdctl", "save", "--platform", platform, "-o", outfile_tar] + images
     elif tool == "docker":
         cmd = ["docker", "save", "-o", outfile_tar] + images
     elif tool == "ctr":
-        cmd = ["ctr", "-n", ctr_namespace, "images", "export", outfile_tar] + images
+        cmd = ["ctr", "-n", ctr_namespace, "images", "export", "--platform", platform, outfile_tar] + images
     else:
         raise RuntimeError(f"unsupported image tool: {tool}")
     run(cmd)
@@ -277,6 +293,9 @@ def main():
                    help="continue even if some images fail to pull")
     p.add_argument("--helm-arg", action="append", default=[],
                    help="extra args passed to 'helm template' (repeatable)")
+    p.add_argument("--images-file",
+                   help="image list file (one per line), used instead of rendering the chart, "
+                        "e.g. generated by 'go run ./tools/cni-images --cni cilium --version 1.14.4'")
 
     args = p.parse_args()
 
@@ -284,6 +303,8 @@ def main():
         args.output = f"{safe_filename(args.name)}-{safe_filename(args.version)}-{safe_filename(args.arch)}.tar.gz"
 
     values_path = resolve_values_path(args.chart_path, args.values)
+    # 按 --arch 拉取和保存镜像，否则多架构镜像只会拿到构建机的架构
+    platform = f"linux/{args.arch}"
 
     workdir = tempfile.mkdtemp(prefix="kc-addon-")
     try:
@@ -297,7 +318,10 @@ def main():
         tar_dir_as_tgz(args.chart_path, charts_tgz)
 
         # 2) 渲染镜像列表
-        images = render_images(args.chart_path, values_path, args.helm_arg)
+        if args.images_file:
+            images = read_images_file(args.images_file)
+        else:
+            images = render_images(args.chart_path, values_path, args.helm_arg)
 
         # 3) 写 images.list（可选，但很有用）
         images_list_path = os.path.join(arch_dir, "images.list")
@@ -306,13 +330,13 @@ def main():
 
         # 4) 先 pull 再 save
         if images and args.pull:
-            pull_images(images, args.image_tool, args.parallel, args.ctr_namespace, args.ignore_pull_errors)
+            pull_images(images, args.image_tool, args.parallel, args.ctr_namespace, args.ignore_pull_errors, platform)
 
         # 5) 保存 images.tar.gz（注意：manifest 示例是 tar.gz）
         images_targz = os.path.join(arch_dir, "images.tar.gz")
         if images:
             tmp_tar = os.path.join(arch_dir, "images.tar")
-            save_images_to_tar(images, args.image_tool, tmp_tar, args.ctr_namespace)
+            save_images_to_tar(images, args.image_tool, tmp_tar, args.ctr_namespace, platform)
             gzip_file(tmp_tar, images_targz)
             os.remove(tmp_tar)
         else:
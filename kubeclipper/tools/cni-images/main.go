@@ -0,0 +1,73 @@
+/*
+ *
+ *  * Copyright 2021 KubeClipper Authors.
+ *  *
+ *  * Licensed under the Apache License, Version 2.0 (the "License");
+ *  * you may not use this file except in compliance with the License.
+ *  * You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+// cni-images prints the image list of a cni version, one image per line.
+// The output can be passed to scripts/pack-addon.py --images-file when building offline packages.
+// Offline installs load the single images.tar.gz of the package, pass --hubble or --hubble-ui when
+// the clusters installed from the package enable the hubble components.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/kubeclipper/kubeclipper/pkg/component"
+	v1 "github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1"
+	"github.com/kubeclipper/kubeclipper/pkg/scheme/core/v1/cni"
+)
+
+var (
+	cniType       string
+	version       string
+	criType       string
+	localRegistry string
+	hubble        bool
+	hubbleUI      bool
+)
+
+func init() {
+	flag.StringVar(&cniType, "cni", "cilium", "cni type, calico or cilium")
+	flag.StringVar(&version, "version", "", "cni version")
+	flag.StringVar(&criType, "cri", v1.CRIContainerd, "container runtime, containerd or docker")
+	flag.StringVar(&localRegistry, "local-registry", "", "rewrite the images to this registry")
+	flag.BoolVar(&hubble, "hubble", false, "include the cilium hubble relay images")
+	flag.BoolVar(&hubbleUI, "hubble-ui", false, "include the cilium hubble relay and ui images")
+}
+
+func main() {
+	flag.Parse()
+	if version == "" {
+		log.Fatal("--version is required")
+	}
+	cf, err := cni.Load(cniType)
+	if err != nil {
+		log.Fatal(err)
+	}
+	spec := &v1.CNI{Type: cniType, Version: version, CriType: criType, LocalRegistry: localRegistry}
+	if cniType == "cilium" && (hubble || hubbleUI) {
+		spec.Cilium = &v1.Cilium{EnableHubble: true, EnableHubbleRelay: true, EnableHubbleUI: hubbleUI}
+	}
+	images, err := cf.Create().InitStep(&component.ExtraMetadata{CRI: criType}, spec, &v1.Networking{}).GetImages(version, criType)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, image := range images {
+		fmt.Println(image)
+	}
+}